@@ -0,0 +1,550 @@
+package goproxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/mod/module"
+)
+
+// deleterCacher is a [Cacher] that can delete caches.
+type deleterCacher interface {
+	Cacher
+	Delete(ctx context.Context, name string) error
+}
+
+// listerCacher is a [Cacher] that can list caches by name prefix.
+type listerCacher interface {
+	Cacher
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// walkerCacher is a [Cacher] that can walk its caches.
+type walkerCacher interface {
+	Cacher
+	Walk(ctx context.Context, fn func(name string, size int64) error) error
+}
+
+const (
+	// cacheStatsTTL is how long the cache statistics collected by walking
+	// the [Goproxy.Cacher] are reused by [Goproxy.AdminHandler].
+	cacheStatsTTL = time.Minute
+
+	// cacheStatsMaxNames is the maximum number of caches walked when
+	// collecting cache statistics, so that huge caches cannot stall the
+	// collection.
+	cacheStatsMaxNames = 100000
+)
+
+// errCacheStatsTruncated is used to stop walking the caches once
+// [cacheStatsMaxNames] is reached.
+var errCacheStatsTruncated = errors.New("cache stats truncated")
+
+// moduleVersionCacheNameExts is the extensions of the cache names of the files
+// of a module version.
+var moduleVersionCacheNameExts = []string{".info", ".mod", ".zip", zipHashCacheNameExt}
+
+// AdminHandler returns an [http.Handler] that serves the administrative API of
+// the g. Every request must carry the g.AdminToken as a bearer token in the
+// Authorization header. The following endpoints are served:
+//
+//   - DELETE /cache/<module>/@v/<version>: Purges the cached info, mod, zip,
+//     and ziphash files of the module version, with the module path and the
+//     version escaped as in the GOPROXY protocol. It responds with 404 if
+//     none of them were cached.
+//
+//   - DELETE /cache?prefix=<module-path-prefix>: Purges all cached files of
+//     the modules whose paths are the module path prefix or start with it
+//     followed by a slash. It requires the g.Cacher to implement
+//     interface{ List(ctx context.Context, prefix string) ([]string, error) }.
+//
+//   - POST /prefetch: Fetches and caches the info, mod, and zip files of the
+//     module versions listed in the request body, one per line as
+//     "<module-path> <version>", as in the output of "go list -m all". Lines
+//     of go.sum files are accepted as well, so a go.sum file can be posted
+//     as is. Only the info and mod files are prefetched for the module
+//     versions that appear only in "/go.mod" lines of go.sum files, since
+//     the go command never downloads their zip files either. Lines without
+//     a version are ignored. The module versions are prefetched as by
+//     [Goproxy.Prefetch], with at most the smaller of the
+//     g.MaxDirectDownloads and g.MaxDirectFetches (or 8 if both are zero) at
+//     the same time. It responds with a JSON object whose "Results" field
+//     lists the module versions, each with "Cached" set if it was already
+//     cached, or "Error" set if it failed.
+//
+//   - GET /debug/cache-stats: Responds with a JSON object of the cache hits
+//     and misses since the g was initialized. If the g.Cacher implements
+//     interface{ Walk(ctx context.Context, fn func(name string, size int64) error) error },
+//     the object also includes the numbers of cached modules and files,
+//     the total size of the files, and the number of files per first
+//     module path element. These are collected from at most 100000 files
+//     (with "Truncated" set if there are more) and reused for a minute.
+//
+//   - GET /modules?offset=<offset>&limit=<limit>: Responds with a JSON object
+//     whose "Modules" field lists the cached module versions, in the order
+//     in which the g.Cacher walks them, each with its "Path", "Version",
+//     total "Size" of the cached files, and "CachedAt", which is when the
+//     earliest of them was cached, if known. At most limit (1000 by default,
+//     10000 at most) module versions are listed after skipping the first
+//     offset. If there are more, the "NextOffset" field is the offset of the
+//     next page. It requires the g.Cacher to implement
+//     interface{ Walk(ctx context.Context, fn func(name string, size int64) error) error },
+//     and only the listed module versions are held in memory.
+//
+//   - GET /maintenance, POST /maintenance, and DELETE /maintenance: Report,
+//     enter, and leave maintenance mode, as by [Goproxy.SetMaintenance]. They
+//     respond with a JSON object whose "Maintenance" field reports whether
+//     the g is in maintenance mode afterwards.
+//
+// Purging requires the g.Cacher to implement
+// interface{ Delete(ctx context.Context, name string) error }, which returns
+// [fs.ErrNotExist] if the cache for the name is not found. Successful purges
+// respond with a JSON object whose "Purged" field lists the purged names.
+//
+// The paths above are relative to the handler. Use [http.StripPrefix] to mount
+// it under a path prefix.
+func (g *Goproxy) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		g.initOnce.Do(g.init)
+		if !g.checkAdminToken(req) {
+			rw.Header().Set("WWW-Authenticate", `Bearer realm="goproxy"`)
+			responseUnauthorized(rw, req)
+			return
+		}
+		if req.URL.Path == "/cache" || strings.HasPrefix(req.URL.Path, "/cache/") {
+			g.serveAdminCache(rw, req)
+			return
+		}
+		if req.URL.Path == "/prefetch" {
+			g.serveAdminPrefetch(rw, req)
+			return
+		}
+		if req.URL.Path == "/debug/cache-stats" {
+			g.serveAdminCacheStats(rw, req)
+			return
+		}
+		if req.URL.Path == "/maintenance" {
+			g.serveAdminMaintenance(rw, req)
+			return
+		}
+		if req.URL.Path == "/modules" {
+			g.serveAdminModules(rw, req)
+			return
+		}
+		responseNotFound(rw, req, -1)
+	})
+}
+
+// checkAdminToken reports whether the req carries the g.AdminToken as a bearer
+// token. It always reports false if the g.AdminToken is empty.
+func (g *Goproxy) checkAdminToken(req *http.Request) bool {
+	if g.AdminToken == "" {
+		return false
+	}
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(g.AdminToken)) == 1
+}
+
+// serveAdminCache serves the cache endpoints of [Goproxy.AdminHandler].
+func (g *Goproxy) serveAdminCache(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodDelete {
+		rw.Header().Set("Allow", http.MethodDelete)
+		responseMethodNotAllowed(rw, req, -1)
+		return
+	}
+	dc, ok := g.Cacher.(deleterCacher)
+	if !ok {
+		responseString(rw, req, http.StatusNotImplemented, -1, "not implemented: cacher does not support deletion")
+		return
+	}
+
+	var names []string
+	if req.URL.Path == "/cache" {
+		prefix := req.URL.Query().Get("prefix")
+		escapedPrefix, err := module.EscapePath(strings.TrimSuffix(prefix, "/"))
+		if err != nil {
+			responseString(rw, req, http.StatusBadRequest, -1, fmt.Sprintf("bad request: invalid module path prefix %q", prefix))
+			return
+		}
+		lc, ok := g.Cacher.(listerCacher)
+		if !ok {
+			responseString(rw, req, http.StatusNotImplemented, -1, "not implemented: cacher does not support listing")
+			return
+		}
+		if names, err = lc.List(req.Context(), escapedPrefix+"/"); err != nil {
+			g.logErrorf("failed to list caches: %s: %v", escapedPrefix, err)
+			responseInternalServerError(rw, req)
+			return
+		}
+	} else {
+		escapedModulePath, escapedModuleVersion, ok := strings.Cut(strings.TrimPrefix(req.URL.Path, "/cache/"), "/@v/")
+		if !ok {
+			responseNotFound(rw, req, -1, "missing /@v/")
+			return
+		}
+		if _, err := module.UnescapePath(escapedModulePath); err != nil {
+			responseNotFound(rw, req, -1, err)
+			return
+		}
+		if _, err := module.UnescapeVersion(escapedModuleVersion); err != nil {
+			responseNotFound(rw, req, -1, err)
+			return
+		}
+		for _, ext := range moduleVersionCacheNameExts {
+			names = append(names, escapedModulePath+"/@v/"+escapedModuleVersion+ext)
+		}
+	}
+
+	purged := []string{}
+	for _, name := range names {
+		if err := dc.Delete(req.Context(), name); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			g.logErrorf("failed to purge cache: %s: %v", name, err)
+			responseInternalServerError(rw, req)
+			return
+		}
+		purged = append(purged, name)
+	}
+	if len(purged) == 0 {
+		responseNotFound(rw, req, -1, "not cached")
+		return
+	}
+	if g.Logger != nil {
+		g.Logger.Info("purged caches", slog.Any("names", purged))
+	}
+
+	b, err := json.Marshal(struct{ Purged []string }{purged})
+	if err != nil {
+		g.logErrorf("failed to marshal purged caches: %v", err)
+		responseInternalServerError(rw, req)
+		return
+	}
+	responseSuccess(rw, req, bytes.NewReader(b), "application/json; charset=utf-8", -1)
+}
+
+// adminPrefetchMaxBodySize is the maximum size in bytes of the request body of
+// the prefetch endpoint of [Goproxy.AdminHandler].
+const adminPrefetchMaxBodySize = 10 << 20
+
+// serveAdminPrefetch serves the prefetch endpoint of [Goproxy.AdminHandler].
+func (g *Goproxy) serveAdminPrefetch(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		rw.Header().Set("Allow", http.MethodPost)
+		responseMethodNotAllowed(rw, req, -1)
+		return
+	}
+	b, err := io.ReadAll(http.MaxBytesReader(rw, req.Body, adminPrefetchMaxBodySize))
+	if err != nil {
+		responseString(rw, req, http.StatusBadRequest, -1, fmt.Sprintf("bad request: %v", err))
+		return
+	}
+
+	var mvs []module.Version
+	goModOnly := map[module.Version]bool{}
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		version, isGoMod := strings.CutSuffix(fields[1], "/go.mod")
+		mv := module.Version{Path: fields[0], Version: version}
+		if only, ok := goModOnly[mv]; ok {
+			goModOnly[mv] = only && isGoMod
+			continue
+		}
+		goModOnly[mv] = isGoMod
+		mvs = append(mvs, mv)
+	}
+
+	results := g.prefetchAll(req.Context(), mvs, goModOnly)
+	if g.Logger != nil {
+		g.Logger.Info("prefetched module versions", slog.Int("count", len(results)))
+	}
+
+	b, err = json.Marshal(struct{ Results []prefetchResult }{results})
+	if err != nil {
+		g.logErrorf("failed to marshal prefetch results: %v", err)
+		responseInternalServerError(rw, req)
+		return
+	}
+	responseSuccess(rw, req, bytes.NewReader(b), "application/json; charset=utf-8", -1)
+}
+
+// serveAdminMaintenance serves the maintenance endpoints of
+// [Goproxy.AdminHandler].
+func (g *Goproxy) serveAdminMaintenance(rw http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPost, http.MethodDelete:
+		if on := req.Method == http.MethodPost; on != g.Maintenance() {
+			g.SetMaintenance(on)
+			if g.Logger != nil {
+				g.Logger.Info("set maintenance mode", slog.Bool("maintenance", on))
+			}
+		}
+	default:
+		rw.Header().Set("Allow", "GET, HEAD, POST, DELETE")
+		responseMethodNotAllowed(rw, req, -1)
+		return
+	}
+
+	b, err := json.Marshal(struct{ Maintenance bool }{g.Maintenance()})
+	if err != nil {
+		g.logErrorf("failed to marshal maintenance mode: %v", err)
+		responseInternalServerError(rw, req)
+		return
+	}
+	responseSuccess(rw, req, bytes.NewReader(b), "application/json; charset=utf-8", -1)
+}
+
+const (
+	// adminModulesDefaultLimit is the default number of module versions
+	// listed by the modules endpoint of [Goproxy.AdminHandler].
+	adminModulesDefaultLimit = 1000
+
+	// adminModulesMaxLimit is the maximum number of module versions listed
+	// by the modules endpoint of [Goproxy.AdminHandler].
+	adminModulesMaxLimit = 10000
+)
+
+// errAdminModulesPageFull is used to stop walking the caches once a page of the
+// modules endpoint of [Goproxy.AdminHandler] is full.
+var errAdminModulesPageFull = errors.New("admin modules page full")
+
+// adminModule is a cached module version listed by the modules endpoint of
+// [Goproxy.AdminHandler].
+type adminModule struct {
+	Path     string
+	Version  string
+	Size     int64
+	CachedAt *time.Time `json:",omitempty"`
+
+	names []string
+}
+
+// serveAdminModules serves the modules endpoint of [Goproxy.AdminHandler].
+func (g *Goproxy) serveAdminModules(rw http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+	default:
+		rw.Header().Set("Allow", "GET, HEAD")
+		responseMethodNotAllowed(rw, req, -1)
+		return
+	}
+	wc, ok := g.Cacher.(walkerCacher)
+	if !ok {
+		responseString(rw, req, http.StatusNotImplemented, -1, "not implemented: cacher does not support walking")
+		return
+	}
+	query := req.URL.Query()
+	offset, limit := 0, adminModulesDefaultLimit
+	if s := query.Get("offset"); s != "" {
+		var err error
+		if offset, err = strconv.Atoi(s); err != nil || offset < 0 {
+			responseString(rw, req, http.StatusBadRequest, -1, fmt.Sprintf("bad request: invalid offset %q", s))
+			return
+		}
+	}
+	if s := query.Get("limit"); s != "" {
+		var err error
+		if limit, err = strconv.Atoi(s); err != nil || limit <= 0 {
+			responseString(rw, req, http.StatusBadRequest, -1, fmt.Sprintf("bad request: invalid limit %q", s))
+			return
+		}
+		limit = min(limit, adminModulesMaxLimit)
+	}
+
+	var (
+		modules    = []*adminModule{}
+		current    *adminModule
+		currentKey string
+		index      int
+		nextOffset int
+	)
+	if err := wc.Walk(req.Context(), func(name string, size int64) error {
+		if strings.HasPrefix(name, "sumdb/") {
+			return nil
+		}
+		escapedModulePath, base, ok := strings.Cut(name, "/@v/")
+		if !ok {
+			return nil
+		}
+		ext := path.Ext(base)
+		if !slices.Contains(moduleVersionCacheNameExts, ext) {
+			return nil
+		}
+		// The files of a module version share the name prefix up to
+		// their extensions, so they are walked one after another.
+		key := strings.TrimSuffix(name, ext)
+		if key != currentKey {
+			if currentKey != "" {
+				index++
+			}
+			current, currentKey = nil, key
+			if index < offset {
+				return nil
+			}
+			if len(modules) == limit {
+				nextOffset = index
+				return errAdminModulesPageFull
+			}
+			modulePath, err := module.UnescapePath(escapedModulePath)
+			if err != nil {
+				return nil
+			}
+			moduleVersion, err := module.UnescapeVersion(strings.TrimSuffix(base, ext))
+			if err != nil {
+				return nil
+			}
+			current = &adminModule{Path: modulePath, Version: moduleVersion}
+			modules = append(modules, current)
+		}
+		if current != nil {
+			current.Size += size
+			current.names = append(current.names, name)
+		}
+		return nil
+	}); err != nil && !errors.Is(err, errAdminModulesPageFull) {
+		g.logErrorf("failed to walk caches: %v", err)
+		responseInternalServerError(rw, req)
+		return
+	}
+
+	for _, m := range modules {
+		for _, name := range m.names {
+			rc, err := g.cache(req.Context(), name)
+			if err != nil {
+				continue
+			}
+			if cachedAt := cachedAt(rc); !cachedAt.IsZero() && (m.CachedAt == nil || cachedAt.Before(*m.CachedAt)) {
+				cachedAt = cachedAt.UTC()
+				m.CachedAt = &cachedAt
+			}
+			rc.Close()
+		}
+	}
+
+	b, err := json.Marshal(struct {
+		Modules    []*adminModule
+		NextOffset int `json:",omitempty"`
+	}{modules, nextOffset})
+	if err != nil {
+		g.logErrorf("failed to marshal cached modules: %v", err)
+		responseInternalServerError(rw, req)
+		return
+	}
+	responseSuccess(rw, req, bytes.NewReader(b), "application/json; charset=utf-8", -1)
+}
+
+// serveAdminCacheStats serves the cache stats endpoint of
+// [Goproxy.AdminHandler].
+func (g *Goproxy) serveAdminCacheStats(rw http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+	default:
+		rw.Header().Set("Allow", "GET, HEAD")
+		responseMethodNotAllowed(rw, req, -1)
+		return
+	}
+
+	var stats struct {
+		Hits   uint64
+		Misses uint64
+		*cacheStats
+	}
+	stats.Hits, stats.Misses = g.metrics.cacheHitsAndMisses()
+	if wc, ok := g.Cacher.(walkerCacher); ok {
+		var err error
+		if stats.cacheStats, err = g.cacheStats.collect(req.Context(), wc); err != nil {
+			g.logErrorf("failed to collect cache stats: %v", err)
+			responseInternalServerError(rw, req)
+			return
+		}
+	}
+
+	b, err := json.Marshal(stats)
+	if err != nil {
+		g.logErrorf("failed to marshal cache stats: %v", err)
+		responseInternalServerError(rw, req)
+		return
+	}
+	responseSuccess(rw, req, bytes.NewReader(b), "application/json; charset=utf-8", -1)
+}
+
+// cacheStats is the statistics of the caches of a [walkerCacher].
+type cacheStats struct {
+	Modules            int
+	Files              int
+	Bytes              int64
+	ModulePathPrefixes map[string]int
+	Truncated          bool
+	CollectedAt        time.Time
+}
+
+// cacheStatsCollector collects and caches [cacheStats]. The zero value is
+// ready to use.
+type cacheStatsCollector struct {
+	mutex sync.Mutex
+	stats *cacheStats
+}
+
+// collect returns the cached stats of the wc if they are recent enough, and
+// otherwise walks the wc to collect them again.
+func (csc *cacheStatsCollector) collect(ctx context.Context, wc walkerCacher) (*cacheStats, error) {
+	csc.mutex.Lock()
+	defer csc.mutex.Unlock()
+	if csc.stats != nil && time.Since(csc.stats.CollectedAt) < cacheStatsTTL {
+		return csc.stats, nil
+	}
+
+	stats := &cacheStats{ModulePathPrefixes: map[string]int{}}
+	modulePaths := map[string]struct{}{}
+	if err := wc.Walk(ctx, func(name string, size int64) error {
+		if stats.Files >= cacheStatsMaxNames {
+			return errCacheStatsTruncated
+		}
+		stats.Files++
+		stats.Bytes += size
+		if strings.HasPrefix(name, "sumdb/") {
+			return nil
+		}
+		escapedModulePath, _, ok := strings.Cut(name, "/@v/")
+		if !ok {
+			escapedModulePath, ok = strings.CutSuffix(name, "/@latest")
+		}
+		if !ok {
+			return nil
+		}
+		if _, ok := modulePaths[escapedModulePath]; !ok {
+			modulePaths[escapedModulePath] = struct{}{}
+			stats.Modules++
+		}
+		prefix, _, _ := strings.Cut(escapedModulePath, "/")
+		stats.ModulePathPrefixes[prefix]++
+		return nil
+	}); err != nil {
+		if !errors.Is(err, errCacheStatsTruncated) {
+			return nil, err
+		}
+		stats.Truncated = true
+	}
+	stats.CollectedAt = time.Now()
+	csc.stats = stats
+	return stats, nil
+}
@@ -0,0 +1,643 @@
+package goproxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGoproxyAdminHandler(t *testing.T) {
+	for _, tt := range []struct {
+		n              int
+		adminToken     string
+		cacher         Cacher
+		cachedNames    []string
+		method         string
+		target         string
+		authorization  string
+		wantStatusCode int
+		wantContent    string
+		wantNames      string
+	}{
+		{
+			n:              1,
+			cacher:         &MemoryCacher{},
+			cachedNames:    []string{"example.com/@v/v1.0.0.info"},
+			method:         http.MethodDelete,
+			target:         "/cache/example.com/@v/v1.0.0",
+			wantStatusCode: http.StatusUnauthorized,
+			wantContent:    "unauthorized",
+			wantNames:      "example.com/@v/v1.0.0.info",
+		},
+		{
+			n:              2,
+			adminToken:     "foobar",
+			cacher:         &MemoryCacher{},
+			cachedNames:    []string{"example.com/@v/v1.0.0.info"},
+			method:         http.MethodDelete,
+			target:         "/cache/example.com/@v/v1.0.0",
+			authorization:  "Bearer foobaz",
+			wantStatusCode: http.StatusUnauthorized,
+			wantContent:    "unauthorized",
+			wantNames:      "example.com/@v/v1.0.0.info",
+		},
+		{
+			n:              3,
+			adminToken:     "foobar",
+			cacher:         &MemoryCacher{},
+			cachedNames:    []string{"example.com/@v/v1.0.0.info"},
+			method:         http.MethodDelete,
+			target:         "/cache/example.com/@v/v1.0.0",
+			authorization:  "Basic foobar",
+			wantStatusCode: http.StatusUnauthorized,
+			wantContent:    "unauthorized",
+			wantNames:      "example.com/@v/v1.0.0.info",
+		},
+		{
+			n:          4,
+			adminToken: "foobar",
+			cacher:     &MemoryCacher{},
+			cachedNames: []string{
+				"example.com/@v/list",
+				"example.com/@v/v1.0.0.info",
+				"example.com/@v/v1.0.0.mod",
+				"example.com/@v/v1.0.0.zip",
+				"example.com/@v/v1.0.0.ziphash",
+				"example.com/@v/v1.1.0.info",
+			},
+			method:         http.MethodDelete,
+			target:         "/cache/example.com/@v/v1.0.0",
+			authorization:  "Bearer foobar",
+			wantStatusCode: http.StatusOK,
+			wantContent:    `{"Purged":["example.com/@v/v1.0.0.info","example.com/@v/v1.0.0.mod","example.com/@v/v1.0.0.zip","example.com/@v/v1.0.0.ziphash"]}`,
+			wantNames:      "example.com/@v/list,example.com/@v/v1.1.0.info",
+		},
+		{
+			n:              5,
+			adminToken:     "foobar",
+			cacher:         &MemoryCacher{},
+			cachedNames:    []string{"example.com/@v/v1.1.0.info"},
+			method:         http.MethodDelete,
+			target:         "/cache/example.com/@v/v1.0.0",
+			authorization:  "Bearer foobar",
+			wantStatusCode: http.StatusNotFound,
+			wantContent:    "not found: not cached",
+			wantNames:      "example.com/@v/v1.1.0.info",
+		},
+		{
+			n:              6,
+			adminToken:     "foobar",
+			cacher:         &MemoryCacher{},
+			method:         http.MethodDelete,
+			target:         "/cache/example.com/@v/",
+			authorization:  "Bearer foobar",
+			wantStatusCode: http.StatusNotFound,
+			wantContent:    `not found: invalid escaped version "": empty path element`,
+		},
+		{
+			n:              7,
+			adminToken:     "foobar",
+			cacher:         &MemoryCacher{},
+			method:         http.MethodDelete,
+			target:         "/cache/example.com",
+			authorization:  "Bearer foobar",
+			wantStatusCode: http.StatusNotFound,
+			wantContent:    "not found: missing /@v/",
+		},
+		{
+			n:          8,
+			adminToken: "foobar",
+			cacher:     DirCacher(t.TempDir()),
+			cachedNames: []string{
+				"example.com/!foo/@v/list",
+				"example.com/!foo/@v/v1.0.0.info",
+				"example.com/!foo/bar/@latest",
+				"example.com/!foobar/@v/v1.0.0.info",
+			},
+			method:         http.MethodDelete,
+			target:         "/cache?prefix=example.com/Foo/",
+			authorization:  "Bearer foobar",
+			wantStatusCode: http.StatusOK,
+			wantContent:    `{"Purged":["example.com/!foo/@v/list","example.com/!foo/@v/v1.0.0.info","example.com/!foo/bar/@latest"]}`,
+			wantNames:      "example.com/!foobar/@v/v1.0.0.info",
+		},
+		{
+			n:              9,
+			adminToken:     "foobar",
+			cacher:         &MemoryCacher{},
+			cachedNames:    []string{"example.com/@v/v1.0.0.info"},
+			method:         http.MethodDelete,
+			target:         "/cache",
+			authorization:  "Bearer foobar",
+			wantStatusCode: http.StatusBadRequest,
+			wantContent:    `bad request: invalid module path prefix ""`,
+			wantNames:      "example.com/@v/v1.0.0.info",
+		},
+		{
+			n:              10,
+			adminToken:     "foobar",
+			cacher:         &MemoryCacher{},
+			method:         http.MethodDelete,
+			target:         "/cache?prefix=example.com",
+			authorization:  "Bearer foobar",
+			wantStatusCode: http.StatusNotFound,
+			wantContent:    "not found: not cached",
+		},
+		{
+			n:              11,
+			adminToken:     "foobar",
+			cacher:         &MemoryCacher{},
+			method:         http.MethodGet,
+			target:         "/cache/example.com/@v/v1.0.0",
+			authorization:  "Bearer foobar",
+			wantStatusCode: http.StatusMethodNotAllowed,
+			wantContent:    "method not allowed",
+		},
+		{
+			n:              12,
+			adminToken:     "foobar",
+			cacher:         &errorCacher{},
+			method:         http.MethodDelete,
+			target:         "/cache/example.com/@v/v1.0.0",
+			authorization:  "Bearer foobar",
+			wantStatusCode: http.StatusNotImplemented,
+			wantContent:    "not implemented: cacher does not support deletion",
+		},
+		{
+			n:              13,
+			adminToken:     "foobar",
+			cacher:         &deleteOnlyCacher{Cacher: &MemoryCacher{}},
+			method:         http.MethodDelete,
+			target:         "/cache?prefix=example.com",
+			authorization:  "Bearer foobar",
+			wantStatusCode: http.StatusNotImplemented,
+			wantContent:    "not implemented: cacher does not support listing",
+		},
+		{
+			n:              14,
+			adminToken:     "foobar",
+			cacher:         &deleteOnlyCacher{Cacher: &MemoryCacher{}, deleteErr: errors.New("cannot delete")},
+			method:         http.MethodDelete,
+			target:         "/cache/example.com/@v/v1.0.0",
+			authorization:  "Bearer foobar",
+			wantStatusCode: http.StatusInternalServerError,
+			wantContent:    "internal server error",
+		},
+		{
+			n:              15,
+			adminToken:     "foobar",
+			cacher:         &MemoryCacher{},
+			method:         http.MethodGet,
+			target:         "/foobar",
+			authorization:  "Bearer foobar",
+			wantStatusCode: http.StatusNotFound,
+			wantContent:    "not found",
+		},
+	} {
+		for _, name := range tt.cachedNames {
+			if err := tt.cacher.Put(context.Background(), name, strings.NewReader(name)); err != nil {
+				t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+			}
+		}
+		g := &Goproxy{
+			AdminToken:  tt.adminToken,
+			Cacher:      tt.cacher,
+			ErrorLogger: log.New(io.Discard, "", 0),
+		}
+		req := httptest.NewRequest(tt.method, tt.target, nil)
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		rec := httptest.NewRecorder()
+		g.AdminHandler().ServeHTTP(rec, req)
+		recr := rec.Result()
+		if got, want := recr.StatusCode, tt.wantStatusCode; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if got, want := recr.Header.Get("Cache-Control"), "must-revalidate, no-cache, no-store"; tt.wantStatusCode != http.StatusInternalServerError && got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		if tt.wantStatusCode == http.StatusUnauthorized {
+			if got, want := recr.Header.Get("WWW-Authenticate"), `Bearer realm="goproxy"`; got != want {
+				t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+			}
+		}
+		if b, err := io.ReadAll(recr.Body); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		} else if got, want := string(b), tt.wantContent; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		if lc, ok := tt.cacher.(listerCacher); ok {
+			if names, err := lc.List(context.Background(), ""); err != nil {
+				t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+			} else if got, want := strings.Join(names, ","), tt.wantNames; got != want {
+				t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+			}
+		}
+	}
+}
+
+type deleteOnlyCacher struct {
+	Cacher
+	deleteErr error
+}
+
+func (doc *deleteOnlyCacher) Delete(ctx context.Context, name string) error {
+	if doc.deleteErr != nil {
+		return doc.deleteErr
+	}
+	return fs.ErrNotExist
+}
+
+func TestGoproxyAdminHandlerCacheStats(t *testing.T) {
+	mc := &MemoryCacher{}
+	for _, name := range []string{
+		"example.com/!foo/@v/list",
+		"example.com/!foo/@v/v1.0.0.info",
+		"example.com/!foo/@latest",
+		"example.org/bar/@v/v1.0.0.mod",
+		"sumdb/sum.golang.org/supported",
+	} {
+		if err := mc.Put(context.Background(), name, strings.NewReader("foobar")); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+	g := &Goproxy{
+		AdminToken:  "foobar",
+		Cacher:      mc,
+		ErrorLogger: log.New(io.Discard, "", 0),
+	}
+	g.initOnce.Do(g.init)
+	g.metrics.incCacheHits("info")
+	g.metrics.incCacheHits("zip")
+	g.metrics.incCacheMisses("mod")
+
+	serve := func(method string) *http.Response {
+		req := httptest.NewRequest(method, "/debug/cache-stats", nil)
+		req.Header.Set("Authorization", "Bearer foobar")
+		rec := httptest.NewRecorder()
+		g.AdminHandler().ServeHTTP(rec, req)
+		return rec.Result()
+	}
+	decode := func(recr *http.Response) map[string]any {
+		var stats map[string]any
+		if err := json.NewDecoder(recr.Body).Decode(&stats); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		return stats
+	}
+
+	recr := serve(http.MethodGet)
+	if got, want := recr.StatusCode, http.StatusOK; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+	if got, want := recr.Header.Get("Content-Type"), "application/json; charset=utf-8"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	stats := decode(recr)
+	collectedAt := stats["CollectedAt"]
+	delete(stats, "CollectedAt")
+	if got, want := stats, map[string]any{
+		"Hits":      float64(2),
+		"Misses":    float64(1),
+		"Modules":   float64(2),
+		"Files":     float64(5),
+		"Bytes":     float64(30),
+		"Truncated": false,
+		"ModulePathPrefixes": map[string]any{
+			"example.com": float64(3),
+			"example.org": float64(1),
+		},
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if err := mc.Put(context.Background(), "example.net/@v/list", strings.NewReader("foobar")); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	stats = decode(serve(http.MethodGet))
+	if got, want := stats["Files"], float64(5); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := stats["CollectedAt"], collectedAt; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if got, want := serve(http.MethodPost).StatusCode, http.StatusMethodNotAllowed; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	g = &Goproxy{
+		AdminToken:  "foobar",
+		Cacher:      &errorCacher{},
+		ErrorLogger: log.New(io.Discard, "", 0),
+	}
+	stats = decode(serve(http.MethodGet))
+	if got, want := stats, map[string]any{"Hits": float64(0), "Misses": float64(0)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestGoproxyAdminHandlerModules(t *testing.T) {
+	mc := &MemoryCacher{}
+	for _, name := range []string{
+		"example.com/!foo/@v/list",
+		"example.com/!foo/@v/v1.0.0.info",
+		"example.com/!foo/@v/v1.0.0.mod",
+		"example.com/!foo/@v/v1.0.0.zip",
+		"example.com/!foo/@v/v1.1.0.info",
+		"example.com/!foo/@latest",
+		"example.org/bar/@v/v1.0.0.mod",
+		"example.org/bar/@v/v2.0.0.notfound",
+		"sumdb/sum.golang.org/supported",
+	} {
+		if err := mc.Put(context.Background(), name, strings.NewReader("foobar")); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+	g := &Goproxy{
+		AdminToken:  "foobar",
+		Cacher:      mc,
+		ErrorLogger: log.New(io.Discard, "", 0),
+	}
+
+	type module struct {
+		Path     string
+		Version  string
+		Size     int64
+		CachedAt string
+	}
+	for _, tt := range []struct {
+		n              int
+		method         string
+		query          string
+		wantStatusCode int
+		wantModules    []module
+		wantNextOffset int
+	}{
+		{
+			n:              1,
+			method:         http.MethodGet,
+			wantStatusCode: http.StatusOK,
+			wantModules: []module{
+				{"example.com/Foo", "v1.0.0", 18, ""},
+				{"example.com/Foo", "v1.1.0", 6, ""},
+				{"example.org/bar", "v1.0.0", 6, ""},
+			},
+		},
+		{
+			n:              2,
+			method:         http.MethodGet,
+			query:          "?limit=2",
+			wantStatusCode: http.StatusOK,
+			wantModules: []module{
+				{"example.com/Foo", "v1.0.0", 18, ""},
+				{"example.com/Foo", "v1.1.0", 6, ""},
+			},
+			wantNextOffset: 2,
+		},
+		{
+			n:              3,
+			method:         http.MethodGet,
+			query:          "?offset=2&limit=2",
+			wantStatusCode: http.StatusOK,
+			wantModules:    []module{{"example.org/bar", "v1.0.0", 6, ""}},
+		},
+		{
+			n:              4,
+			method:         http.MethodGet,
+			query:          "?offset=3",
+			wantStatusCode: http.StatusOK,
+			wantModules:    []module{},
+		},
+		{n: 5, method: http.MethodGet, query: "?offset=-1", wantStatusCode: http.StatusBadRequest},
+		{n: 6, method: http.MethodGet, query: "?limit=foo", wantStatusCode: http.StatusBadRequest},
+		{n: 7, method: http.MethodPost, wantStatusCode: http.StatusMethodNotAllowed},
+	} {
+		req := httptest.NewRequest(tt.method, "/modules"+tt.query, nil)
+		req.Header.Set("Authorization", "Bearer foobar")
+		rec := httptest.NewRecorder()
+		g.AdminHandler().ServeHTTP(rec, req)
+		recr := rec.Result()
+		if got, want := recr.StatusCode, tt.wantStatusCode; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if tt.wantStatusCode != http.StatusOK {
+			continue
+		}
+		var result struct {
+			Modules    []module
+			NextOffset int
+		}
+		if err := json.NewDecoder(recr.Body).Decode(&result); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		}
+		for i := range result.Modules {
+			if result.Modules[i].CachedAt == "" {
+				t.Errorf("test(%d): expected non-empty CachedAt", tt.n)
+			}
+			result.Modules[i].CachedAt = ""
+		}
+		if got, want := result.Modules, tt.wantModules; !reflect.DeepEqual(got, want) {
+			t.Errorf("test(%d): got %v, want %v", tt.n, got, want)
+		}
+		if got, want := result.NextOffset, tt.wantNextOffset; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+	}
+
+	g = &Goproxy{
+		AdminToken:  "foobar",
+		Cacher:      &errorCacher{},
+		ErrorLogger: log.New(io.Discard, "", 0),
+	}
+	req := httptest.NewRequest(http.MethodGet, "/modules", nil)
+	req.Header.Set("Authorization", "Bearer foobar")
+	rec := httptest.NewRecorder()
+	g.AdminHandler().ServeHTTP(rec, req)
+	if got, want := rec.Code, http.StatusNotImplemented; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
+
+func TestGoproxyAdminHandlerMaintenance(t *testing.T) {
+	g := &Goproxy{
+		AdminToken:  "foobar",
+		Cacher:      &MemoryCacher{},
+		ErrorLogger: log.New(io.Discard, "", 0),
+	}
+	serve := func(method string) *http.Response {
+		req := httptest.NewRequest(method, "/maintenance", nil)
+		req.Header.Set("Authorization", "Bearer foobar")
+		rec := httptest.NewRecorder()
+		g.AdminHandler().ServeHTTP(rec, req)
+		return rec.Result()
+	}
+	for _, tt := range []struct {
+		n               int
+		method          string
+		wantStatusCode  int
+		wantMaintenance bool
+	}{
+		{1, http.MethodGet, http.StatusOK, false},
+		{2, http.MethodPost, http.StatusOK, true},
+		{3, http.MethodPost, http.StatusOK, true},
+		{4, http.MethodGet, http.StatusOK, true},
+		{5, http.MethodPut, http.StatusMethodNotAllowed, true},
+		{6, http.MethodDelete, http.StatusOK, false},
+		{7, http.MethodGet, http.StatusOK, false},
+	} {
+		recr := serve(tt.method)
+		if got, want := recr.StatusCode, tt.wantStatusCode; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if got, want := g.Maintenance(), tt.wantMaintenance; got != want {
+			t.Errorf("test(%d): got %t, want %t", tt.n, got, want)
+		}
+		if tt.wantStatusCode != http.StatusOK {
+			if got, want := recr.Header.Get("Allow"), "GET, HEAD, POST, DELETE"; got != want {
+				t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+			}
+			continue
+		}
+		var state struct{ Maintenance bool }
+		if err := json.NewDecoder(recr.Body).Decode(&state); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		}
+		if got, want := state.Maintenance, tt.wantMaintenance; got != want {
+			t.Errorf("test(%d): got %t, want %t", tt.n, got, want)
+		}
+	}
+}
+
+func TestGoproxyAdminHandlerPrefetch(t *testing.T) {
+	proxyServer, proxyURL, proxyRequests := newPrefetchTestProxyServer(t)
+	defer proxyServer.Close()
+
+	g := &Goproxy{
+		Env:         []string{"GOPROXY=" + proxyURL, "GOSUMDB=off"},
+		Cacher:      &MemoryCacher{},
+		TempDir:     t.TempDir(),
+		AdminToken:  "foobar",
+		ErrorLogger: log.New(io.Discard, "", 0),
+	}
+	serve := func(method, body string) *http.Response {
+		req := httptest.NewRequest(method, "/prefetch", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer foobar")
+		rec := httptest.NewRecorder()
+		g.AdminHandler().ServeHTTP(rec, req)
+		return rec.Result()
+	}
+	decode := func(recr *http.Response) []prefetchResult {
+		var v struct{ Results []prefetchResult }
+		if err := json.NewDecoder(recr.Body).Decode(&v); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		return v.Results
+	}
+
+	body := "example.com v1.0.0 h1:foobar\nexample.com v1.0.0/go.mod h1:foobar\nexample.com v1.1.0 h1:foobar\n\nexample.net\n"
+	recr := serve(http.MethodPost, body)
+	if got, want := recr.StatusCode, http.StatusOK; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+	if got, want := recr.Header.Get("Content-Type"), "application/json; charset=utf-8"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	results := decode(recr)
+	if got, want := len(results), 2; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+	if got, want := results[0], (prefetchResult{Path: "example.com", Version: "v1.0.0"}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got, want := results[1].Version, "v1.1.0"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if results[1].Error == "" {
+		t.Error("expected error")
+	}
+	if _, err := g.Cacher.Get(context.Background(), "example.com/@v/v1.0.0.zip"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	n := len(proxyRequests())
+	results = decode(serve(http.MethodPost, "example.com v1.0.0\n"))
+	if got, want := results, []prefetchResult{{Path: "example.com", Version: "v1.0.0", Cached: true}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got, want := len(proxyRequests()), n; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	recr = serve(http.MethodGet, "")
+	if got, want := recr.StatusCode, http.StatusMethodNotAllowed; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+	if got, want := recr.Header.Get("Allow"), http.MethodPost; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	g.Cacher = &MemoryCacher{}
+	n = len(proxyRequests())
+	results = decode(serve(http.MethodPost, "example.com v1.0.0/go.mod h1:foobar\n"))
+	if got, want := results, []prefetchResult{{Path: "example.com", Version: "v1.0.0"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got, want := strings.Join(proxyRequests()[n:], ","), "/example.com/@v/v1.0.0.info,/example.com/@v/v1.0.0.mod"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if _, err := g.Cacher.Get(context.Background(), "example.com/@v/v1.0.0.zip"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got error %v, want %v", err, fs.ErrNotExist)
+	}
+}
+
+func TestCacheStatsCollectorTruncated(t *testing.T) {
+	wc := walkerCacherFunc(func(ctx context.Context, fn func(name string, size int64) error) error {
+		for i := 0; ; i++ {
+			if err := fn(fmt.Sprintf("example.com/m%d/@v/list", i), 1); err != nil {
+				return err
+			}
+		}
+	})
+	stats, err := (&cacheStatsCollector{}).collect(context.Background(), wc)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if got, want := stats.Files, cacheStatsMaxNames; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+	if !stats.Truncated {
+		t.Error("expected truncated stats")
+	}
+
+	wantErr := errors.New("cannot walk")
+	if _, err := (&cacheStatsCollector{}).collect(context.Background(), walkerCacherFunc(func(context.Context, func(string, int64) error) error {
+		return wantErr
+	})); err == nil {
+		t.Fatal("expected error")
+	} else if got, want := err, wantErr; !errors.Is(got, want) && got.Error() != want.Error() {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+type walkerCacherFunc func(ctx context.Context, fn func(name string, size int64) error) error
+
+func (walkerCacherFunc) Get(context.Context, string) (io.ReadCloser, error) {
+	return nil, fs.ErrNotExist
+}
+
+func (walkerCacherFunc) Put(context.Context, string, io.ReadSeeker) error { return nil }
+
+func (f walkerCacherFunc) Walk(ctx context.Context, fn func(name string, size int64) error) error {
+	return f(ctx, fn)
+}
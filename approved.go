@@ -0,0 +1,240 @@
+package goproxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"golang.org/x/mod/semver"
+)
+
+// ApprovedVersions is a set of rules that restrict the versions of some modules
+// that a [Goproxy] serves. See [ParseApprovedVersions] and
+// [Goproxy.ApprovedVersions].
+type ApprovedVersions struct {
+	rules []approvedVersionsRule
+}
+
+// approvedVersionsRule is a rule of an [ApprovedVersions].
+type approvedVersionsRule struct {
+	pattern     string
+	constraints [][]versionComparison
+}
+
+// versionComparison is a comparison of a version against the version using the
+// op, which is one of "=", ">", ">=", "<", and "<=".
+type versionComparison struct {
+	op      string
+	version string
+}
+
+// ParseApprovedVersions parses the data as an [ApprovedVersions].
+//
+// Each non-empty line of the data, except for those starting with "#", is a
+// rule in the form "<pattern> <constraint>...". The pattern is a glob pattern
+// (as defined by [path.Match]) of module path prefixes, in the same form as a
+// GOPRIVATE entry. Each constraint is either an exact version, such as
+// "v1.2.3", or a comma-separated list of comparisons that must all hold, such as
+// ">=v1.2.0,<v1.3.0", with the operators "=", ">", ">=", "<", and "<=". A
+// version is approved if it satisfies any constraint of the first rule whose
+// pattern matches the module path. Modules matching no rule are not restricted.
+//
+// For example:
+//
+//	# Only vetted versions of critical dependencies.
+//	example.com/critical v1.2.3 v1.2.4
+//	golang.org/x/* >=v0.10.0,<v0.11.0
+func ParseApprovedVersions(data []byte) (*ApprovedVersions, error) {
+	av := &ApprovedVersions{}
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for lineNum := 1; s.Scan(); lineNum++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: missing version constraints", lineNum)
+		}
+		pattern := strings.ToLower(fields[0])
+		if strings.Contains(pattern, ",") {
+			return nil, fmt.Errorf("line %d: invalid pattern %q", lineNum, fields[0])
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("line %d: invalid pattern %q: %w", lineNum, fields[0], err)
+		}
+		rule := approvedVersionsRule{pattern: pattern}
+		for _, field := range fields[1:] {
+			var constraint []versionComparison
+			for _, comparison := range strings.Split(field, ",") {
+				vc := parseVersionComparison(comparison)
+				if !semver.IsValid(vc.version) {
+					return nil, fmt.Errorf("line %d: invalid version constraint %q", lineNum, field)
+				}
+				constraint = append(constraint, vc)
+			}
+			rule.constraints = append(rule.constraints, constraint)
+		}
+		av.rules = append(av.rules, rule)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return av, nil
+}
+
+// parseVersionComparison parses the s as a [versionComparison]. A missing
+// operator means "=".
+func parseVersionComparison(s string) versionComparison {
+	for _, op := range []string{">=", "<=", ">", "<", "="} {
+		if version, ok := strings.CutPrefix(s, op); ok {
+			return versionComparison{op: op, version: version}
+		}
+	}
+	return versionComparison{op: "=", version: s}
+}
+
+// rule returns the first rule of the av whose pattern matches the modulePath,
+// or nil if there is none.
+func (av *ApprovedVersions) rule(modulePath string) *approvedVersionsRule {
+	if av == nil {
+		return nil
+	}
+	lowerModulePath := strings.ToLower(modulePath)
+	for i := range av.rules {
+		if globsMatchPath(av.rules[i].pattern, lowerModulePath) {
+			return &av.rules[i]
+		}
+	}
+	return nil
+}
+
+// allows reports whether the version satisfies any constraint of the r.
+func (r *approvedVersionsRule) allows(version string) bool {
+	for _, constraint := range r.constraints {
+		satisfied := true
+		for _, vc := range constraint {
+			if !vc.holds(version) {
+				satisfied = false
+				break
+			}
+		}
+		if satisfied {
+			return true
+		}
+	}
+	return false
+}
+
+// holds reports whether the version satisfies the vc.
+func (vc versionComparison) holds(version string) bool {
+	if vc.op == "=" {
+		return version == vc.version
+	}
+	cmp := semver.Compare(version, vc.version)
+	switch vc.op {
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	default: // "<="
+		return cmp <= 0
+	}
+}
+
+// notApprovedError returns the error of the moduleVersion of the modulePath not
+// being approved.
+func notApprovedError(modulePath, moduleVersion string) error {
+	return &PolicyError{ModulePath: modulePath, ModuleVersion: moduleVersion, Reason: "not approved"}
+}
+
+// approvedVersionsResponseWriter is an [http.ResponseWriter] that holds back a
+// successful list or resolve response until finish is called, so that the
+// versions it contains can be checked against a rule. Responses of other
+// status codes are written through as is.
+type approvedVersionsResponseWriter struct {
+	http.ResponseWriter
+	req        *http.Request
+	f          *fetch
+	rule       *approvedVersionsRule
+	statusCode int
+	buf        bytes.Buffer
+}
+
+// newApprovedVersionsResponseWriter returns a new
+// [approvedVersionsResponseWriter] for the list or resolve request req of the f
+// governed by the rule, along with the request to serve in its place. The
+// returned request always asks for the full content, so that it can be
+// checked.
+func newApprovedVersionsResponseWriter(rw http.ResponseWriter, req *http.Request, f *fetch, rule *approvedVersionsRule) (*approvedVersionsResponseWriter, *http.Request) {
+	innerReq := req.Clone(req.Context())
+	innerReq.Method = http.MethodGet
+	for _, header := range []string{"Range", "If-Range", "If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since"} {
+		innerReq.Header.Del(header)
+	}
+	return &approvedVersionsResponseWriter{ResponseWriter: rw, req: req, f: f, rule: rule}, innerReq
+}
+
+// WriteHeader implements [http.ResponseWriter].
+func (avrw *approvedVersionsResponseWriter) WriteHeader(statusCode int) {
+	if avrw.statusCode != 0 {
+		return
+	}
+	avrw.statusCode = statusCode
+	if statusCode != http.StatusOK {
+		avrw.ResponseWriter.WriteHeader(statusCode)
+	}
+}
+
+// Write implements [http.ResponseWriter].
+func (avrw *approvedVersionsResponseWriter) Write(b []byte) (int, error) {
+	if avrw.statusCode == 0 {
+		avrw.WriteHeader(http.StatusOK)
+	}
+	if avrw.statusCode != http.StatusOK {
+		return avrw.ResponseWriter.Write(b)
+	}
+	return avrw.buf.Write(b)
+}
+
+// finish writes the held back response, if any. A list response is written with
+// only the approved versions, and a resolve response that resolves to a version
+// that is not approved is replaced with a 403 one.
+func (avrw *approvedVersionsResponseWriter) finish() {
+	if avrw.statusCode != http.StatusOK {
+		return
+	}
+	header := avrw.Header()
+	content := avrw.buf.Bytes()
+	if avrw.f.ops == fetchOpsList {
+		var approved []string
+		for _, version := range strings.Split(string(content), "\n") {
+			if version = strings.TrimSpace(version); version != "" && avrw.rule.allows(version) {
+				approved = append(approved, version)
+			}
+		}
+		content = []byte(strings.Join(approved, "\n"))
+		header.Del("ETag")
+	} else {
+		var info struct{ Version string }
+		if err := json.Unmarshal(content, &info); err == nil && !avrw.rule.allows(info.Version) {
+			for _, h := range []string{"ETag", "Last-Modified", "Content-Length", "Accept-Ranges"} {
+				header.Del(h)
+			}
+			responseForbidden(avrw.ResponseWriter, avrw.req, -1, notApprovedError(avrw.f.modulePath, info.Version))
+			return
+		}
+	}
+	header.Set("Content-Length", strconv.Itoa(len(content)))
+	avrw.ResponseWriter.WriteHeader(http.StatusOK)
+	if avrw.req.Method != http.MethodHead {
+		avrw.ResponseWriter.Write(content)
+	}
+}
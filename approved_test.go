@@ -0,0 +1,151 @@
+package goproxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseApprovedVersions(t *testing.T) {
+	for _, tt := range []struct {
+		n         int
+		data      string
+		wantRules int
+		wantError string
+	}{
+		{1, "", 0, ""},
+		{2, "# comment\n\nexample.com v1.0.0 v1.1.0\nExample.org/* >=v1.0.0,<v2.0.0\n", 2, ""},
+		{3, "example.com\n", 0, "line 1: missing version constraints"},
+		{4, "\nexample.com 1.0.0\n", 0, `line 2: invalid version constraint "1.0.0"`},
+		{5, "example.com >=v1.0.0,<\n", 0, `line 1: invalid version constraint ">=v1.0.0,<"`},
+		{6, "example.com,example.org v1.0.0\n", 0, `line 1: invalid pattern "example.com,example.org"`},
+		{7, "[example.com v1.0.0\n", 0, `line 1: invalid pattern "[example.com": syntax error in pattern`},
+	} {
+		av, err := ParseApprovedVersions([]byte(tt.data))
+		if tt.wantError != "" {
+			if err == nil {
+				t.Fatalf("test(%d): expected error", tt.n)
+			}
+			if got, want := err.Error(), tt.wantError; got != want {
+				t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+			}
+		} else if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		} else if got, want := len(av.rules), tt.wantRules; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+	}
+}
+
+func TestApprovedVersionsAllows(t *testing.T) {
+	av, err := ParseApprovedVersions([]byte(`
+example.com/foo v1.0.0 >=v1.2.0,<v1.3.0
+example.com/* =v2.0.0 >v3.0.0
+`))
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	for _, tt := range []struct {
+		n           int
+		modulePath  string
+		version     string
+		wantRule    bool
+		wantAllowed bool
+	}{
+		{1, "example.com/foo", "v1.0.0", true, true},
+		{2, "example.com/foo", "v1.1.0", true, false},
+		{3, "example.com/foo", "v1.2.5", true, true},
+		{4, "example.com/foo", "v1.3.0", true, false},
+		{5, "example.com/foo", "v2.0.0", true, false},
+		{6, "example.com/bar", "v2.0.0", true, true},
+		{7, "example.com/bar/v3", "v3.0.1", true, true},
+		{8, "Example.com/Bar", "v3.0.0", true, false},
+		{9, "example.org/foo", "v1.1.0", false, false},
+	} {
+		rule := av.rule(tt.modulePath)
+		if got, want := rule != nil, tt.wantRule; got != want {
+			t.Fatalf("test(%d): got %t, want %t", tt.n, got, want)
+		}
+		if rule == nil {
+			continue
+		}
+		if got, want := rule.allows(tt.version), tt.wantAllowed; got != want {
+			t.Errorf("test(%d): got %t, want %t", tt.n, got, want)
+		}
+	}
+
+	if got := (*ApprovedVersions)(nil).rule("example.com/foo"); got != nil {
+		t.Errorf("got %v, want nil", got)
+	}
+}
+
+func TestGoproxyServeFetchApprovedVersions(t *testing.T) {
+	cacher := &MemoryCacher{}
+	for name, content := range map[string]string{
+		"example.com/@v/list":        "v1.0.0\nv1.1.0\nv1.2.0\nv1.2.1",
+		"example.com/@latest":        `{"Version":"v1.2.1"}`,
+		"example.com/@v/master.info": `{"Version":"v1.0.0"}`,
+		"example.com/@v/v1.1.0.info": `{"Version":"v1.1.0"}`,
+		"example.com/@v/v1.2.0.info": `{"Version":"v1.2.0"}`,
+		"example.org/@v/list":        "v1.0.0\nv1.1.0",
+	} {
+		if err := cacher.Put(context.Background(), name, strings.NewReader(content)); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+	av, err := ParseApprovedVersions([]byte("example.com v1.0.0 >=v1.2.0,<v1.2.1\n"))
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	g := &Goproxy{Cacher: cacher, Offline: true, ApprovedVersions: av}
+	for _, tt := range []struct {
+		n              int
+		method         string
+		path           string
+		header         http.Header
+		wantStatusCode int
+		wantContent    string
+	}{
+		{1, http.MethodGet, "/example.com/@v/list", nil, http.StatusOK, "v1.0.0\nv1.2.0"},
+		{2, http.MethodHead, "/example.com/@v/list", nil, http.StatusOK, ""},
+		{3, http.MethodGet, "/example.com/@v/list", http.Header{"Range": {"bytes=0-1"}}, http.StatusOK, "v1.0.0\nv1.2.0"},
+		{4, http.MethodGet, "/example.org/@v/list", nil, http.StatusOK, "v1.0.0\nv1.1.0"},
+		{5, http.MethodGet, "/example.com/@v/v1.2.0.info", nil, http.StatusOK, `{"Version":"v1.2.0"}`},
+		{6, http.MethodGet, "/example.com/@v/v1.1.0.info", nil, http.StatusForbidden, "forbidden: version v1.1.0 of module example.com is not approved by this proxy"},
+		{7, http.MethodGet, "/example.com/@v/v1.1.0.zip", nil, http.StatusForbidden, "forbidden: version v1.1.0 of module example.com is not approved by this proxy"},
+		{8, http.MethodGet, "/example.com/@latest", nil, http.StatusForbidden, "forbidden: version v1.2.1 of module example.com is not approved by this proxy"},
+		{9, http.MethodGet, "/example.com/@v/master.info", nil, http.StatusOK, `{"Version":"v1.0.0"}`},
+		{10, http.MethodGet, "/example.com/@v/v1.0.0.info", nil, http.StatusNotFound, "not found: not cached by this proxy in offline mode"},
+	} {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		for k, v := range tt.header {
+			req.Header[k] = v
+		}
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, req)
+		recr := rec.Result()
+		if got, want := recr.StatusCode, tt.wantStatusCode; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if b, err := io.ReadAll(recr.Body); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		} else if got, want := string(b), tt.wantContent; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		if tt.n == 2 {
+			if got, want := recr.Header.Get("Content-Length"), "13"; got != want {
+				t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+			}
+		}
+	}
+
+	g.SetPolicy(Policy{})
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/example.com/@v/v1.1.0.info", nil))
+	if got, want := rec.Result().StatusCode, http.StatusOK; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
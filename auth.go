@@ -0,0 +1,108 @@
+package goproxy
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// ErrUnauthenticated is the error returned by an [Authenticator] when a request
+// carries no valid credentials.
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// Authenticator authenticates the requests served by a [Goproxy]. See
+// [Goproxy.Authenticator].
+//
+// Note that the Authenticator can optionally implement
+// interface{ Challenge() string }, whose result is sent as the
+// WWW-Authenticate header of the responses to the requests failing with
+// [ErrUnauthenticated].
+type Authenticator interface {
+	// Authenticate returns the identity of the client of the req, which is
+	// empty if the client is anonymous. It returns an error wrapping
+	// [ErrUnauthenticated] if the req carries no valid credentials, which
+	// is responded with a 401 status code. Any other error denies the req
+	// with a 403 status code.
+	Authenticate(req *http.Request) (identity string, err error)
+}
+
+// BearerTokenAuthenticator is an [Authenticator] that accepts the requests
+// carrying any of its keys as a bearer token in the Authorization header, with
+// the value of the key as the identity of the client.
+type BearerTokenAuthenticator map[string]string
+
+// Authenticate implements [Authenticator].
+func (bta BearerTokenAuthenticator) Authenticate(req *http.Request) (string, error) {
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return "", ErrUnauthenticated
+	}
+	// Every key is compared, so that the time taken does not tell which
+	// one is closest to the token.
+	var identity string
+	found := 0
+	for key, value := range bta {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(key)) == 1 {
+			identity = value
+			found = 1
+		}
+	}
+	if found == 0 {
+		return "", ErrUnauthenticated
+	}
+	return identity, nil
+}
+
+// Challenge returns the WWW-Authenticate header of the responses to the
+// requests failing with [ErrUnauthenticated].
+func (BearerTokenAuthenticator) Challenge() string {
+	return `Bearer realm="goproxy"`
+}
+
+// BasicAuthenticator is an [Authenticator] that accepts the requests carrying
+// any of its keys as the user name and its value as the password via HTTP
+// basic authentication, with the user name as the identity of the client.
+type BasicAuthenticator map[string]string
+
+// Authenticate implements [Authenticator].
+func (ba BasicAuthenticator) Authenticate(req *http.Request) (string, error) {
+	username, password, ok := req.BasicAuth()
+	if !ok {
+		return "", ErrUnauthenticated
+	}
+	want, ok := ba[username]
+	if subtle.ConstantTimeCompare([]byte(password), []byte(want)) != 1 || !ok {
+		return "", ErrUnauthenticated
+	}
+	return username, nil
+}
+
+// Challenge returns the WWW-Authenticate header of the responses to the
+// requests failing with [ErrUnauthenticated].
+func (BasicAuthenticator) Challenge() string {
+	return `Basic realm="goproxy", charset="UTF-8"`
+}
+
+// authenticate authenticates the req with the g.Authenticator, responding to
+// the client if it fails. It returns the identity of the client and reports
+// whether the req is authenticated. Requests are always authenticated, with
+// an empty identity, if the g.Authenticator is nil.
+func (g *Goproxy) authenticate(rw http.ResponseWriter, req *http.Request) (string, bool) {
+	if g.Authenticator == nil {
+		return "", true
+	}
+	identity, err := g.Authenticator.Authenticate(req)
+	if err == nil {
+		return identity, true
+	}
+	if errors.Is(err, ErrUnauthenticated) {
+		if c, ok := g.Authenticator.(interface{ Challenge() string }); ok {
+			rw.Header().Set("WWW-Authenticate", c.Challenge())
+		}
+		responseUnauthorized(rw, req)
+		return "", false
+	}
+	responseForbidden(rw, req, -1, err)
+	return "", false
+}
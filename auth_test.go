@@ -0,0 +1,173 @@
+package goproxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBearerTokenAuthenticator(t *testing.T) {
+	bta := BearerTokenAuthenticator{"secret": "team-a"}
+	for _, tt := range []struct {
+		n             int
+		authorization string
+		wantIdentity  string
+		wantError     error
+	}{
+		{1, "Bearer secret", "team-a", nil},
+		{2, "Bearer wrong", "", ErrUnauthenticated},
+		{3, "Bearer ", "", ErrUnauthenticated},
+		{4, "Basic secret", "", ErrUnauthenticated},
+		{5, "", "", ErrUnauthenticated},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		identity, err := bta.Authenticate(req)
+		if got, want := err, tt.wantError; !errors.Is(got, want) {
+			t.Errorf("test(%d): got %v, want %v", tt.n, got, want)
+		}
+		if got, want := identity, tt.wantIdentity; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+}
+
+func TestBasicAuthenticator(t *testing.T) {
+	ba := BasicAuthenticator{"alice": "secret"}
+	for _, tt := range []struct {
+		n            int
+		username     string
+		password     string
+		wantIdentity string
+		wantError    error
+	}{
+		{1, "alice", "secret", "alice", nil},
+		{2, "alice", "wrong", "", ErrUnauthenticated},
+		{3, "bob", "", "", ErrUnauthenticated},
+		{4, "", "", "", ErrUnauthenticated},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.username != "" {
+			req.SetBasicAuth(tt.username, tt.password)
+		}
+		identity, err := ba.Authenticate(req)
+		if got, want := err, tt.wantError; !errors.Is(got, want) {
+			t.Errorf("test(%d): got %v, want %v", tt.n, got, want)
+		}
+		if got, want := identity, tt.wantIdentity; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+}
+
+// testAuthenticator is an [Authenticator] that denies requests carrying a
+// "Denied" header and accepts those carrying an "Identity" header.
+type testAuthenticator struct{}
+
+func (testAuthenticator) Authenticate(req *http.Request) (string, error) {
+	if req.Header.Get("Denied") != "" {
+		return "", errors.New("denied by gateway")
+	}
+	identity := req.Header.Get("Identity")
+	if identity == "" {
+		return "", ErrUnauthenticated
+	}
+	return identity, nil
+}
+
+func TestGoproxyServeHTTPAuthenticator(t *testing.T) {
+	cacher := &MemoryCacher{}
+	if err := cacher.Put(context.Background(), "example.com/@v/list", strings.NewReader("v1.0.0")); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	var logBuf bytes.Buffer
+	g := &Goproxy{
+		Cacher:        cacher,
+		Offline:       true,
+		Authenticator: testAuthenticator{},
+		Logger:        slog.New(slog.NewTextHandler(&logBuf, nil)),
+	}
+	for _, tt := range []struct {
+		n              int
+		method         string
+		header         http.Header
+		wantStatusCode int
+		wantContent    string
+	}{
+		{1, http.MethodGet, http.Header{"Identity": {"team-a"}}, http.StatusOK, "v1.0.0"},
+		{2, http.MethodGet, nil, http.StatusUnauthorized, "unauthorized"},
+		{3, http.MethodGet, http.Header{"Denied": {"true"}}, http.StatusForbidden, "forbidden: denied by gateway"},
+		{4, http.MethodPost, nil, http.StatusMethodNotAllowed, "method not allowed"},
+	} {
+		req := httptest.NewRequest(tt.method, "/example.com/@v/list", nil)
+		for k, v := range tt.header {
+			req.Header[k] = v
+		}
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, req)
+		recr := rec.Result()
+		if got, want := recr.StatusCode, tt.wantStatusCode; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if b, err := io.ReadAll(recr.Body); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		} else if got, want := string(b), tt.wantContent; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+	if got, want := logBuf.String(), "client=team-a"; !strings.Contains(got, want) {
+		t.Errorf("got %q, want it to contain %q", got, want)
+	}
+
+	rec := httptest.NewRecorder()
+	g.MetricsHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+	if got, want := rec.Body.String(), `client="team-a"} 1`; !strings.Contains(got, want) {
+		t.Errorf("got %q, want it to contain %q", got, want)
+	}
+
+	g = &Goproxy{
+		Cacher:                       cacher,
+		Offline:                      true,
+		Authenticator:                BearerTokenAuthenticator{"secret": "team-a"},
+		AuthenticateHealthAndMetrics: true,
+	}
+	for _, tt := range []struct {
+		n                   int
+		handler             http.Handler
+		authorization       string
+		wantStatusCode      int
+		wantWWWAuthenticate string
+	}{
+		{1, g, "", http.StatusUnauthorized, `Bearer realm="goproxy"`},
+		{2, g, "Bearer secret", http.StatusOK, ""},
+		{3, g.MetricsHandler(), "", http.StatusUnauthorized, `Bearer realm="goproxy"`},
+		{4, g.MetricsHandler(), "Bearer secret", http.StatusOK, ""},
+		{5, g.HealthHandler(), "", http.StatusUnauthorized, `Bearer realm="goproxy"`},
+		{6, g.HealthHandler(), "Bearer secret", http.StatusOK, ""},
+		{7, g.ReadinessHandler(), "", http.StatusUnauthorized, `Bearer realm="goproxy"`},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/example.com/@v/list", nil)
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		rec := httptest.NewRecorder()
+		tt.handler.ServeHTTP(rec, req)
+		if got, want := rec.Code, tt.wantStatusCode; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if got, want := rec.Header().Get("WWW-Authenticate"), tt.wantWWWAuthenticate; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+}
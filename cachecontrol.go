@@ -0,0 +1,64 @@
+package goproxy
+
+import "net/http"
+
+const (
+	// defaultDownloadCacheControl is the default of
+	// [Goproxy.DownloadCacheControl].
+	defaultDownloadCacheControl = "public, max-age=604800, immutable"
+
+	// defaultQueryCacheControl is the default of
+	// [Goproxy.QueryCacheControl].
+	defaultQueryCacheControl = "public, max-age=60, must-revalidate"
+)
+
+// cacheControlResponseWriter is an [http.ResponseWriter] that sets the
+// Cache-Control header of successful and not modified responses to
+// cacheControl. Other responses keep the Cache-Control headers they were
+// given.
+type cacheControlResponseWriter struct {
+	http.ResponseWriter
+	cacheControl string
+	wroteHeader  bool
+}
+
+// WriteHeader implements [http.ResponseWriter].
+func (ccrw *cacheControlResponseWriter) WriteHeader(statusCode int) {
+	if !ccrw.wroteHeader {
+		ccrw.wroteHeader = true
+		if statusCode < http.StatusMultipleChoices || statusCode == http.StatusNotModified {
+			ccrw.Header().Set("Cache-Control", ccrw.cacheControl)
+		}
+	}
+	ccrw.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write implements [http.ResponseWriter].
+func (ccrw *cacheControlResponseWriter) Write(b []byte) (int, error) {
+	if !ccrw.wroteHeader {
+		ccrw.WriteHeader(http.StatusOK)
+	}
+	return ccrw.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the underlying [http.ResponseWriter] for use with
+// [http.ResponseController].
+func (ccrw *cacheControlResponseWriter) Unwrap() http.ResponseWriter {
+	return ccrw.ResponseWriter
+}
+
+// fetchCacheControl returns the Cache-Control header of the successful
+// responses to the fetch requests that are downloads if isDownload is true, or
+// queries otherwise.
+func (g *Goproxy) fetchCacheControl(isDownload bool) string {
+	if isDownload {
+		if g.DownloadCacheControl != "" {
+			return g.DownloadCacheControl
+		}
+		return defaultDownloadCacheControl
+	}
+	if g.QueryCacheControl != "" {
+		return g.QueryCacheControl
+	}
+	return defaultQueryCacheControl
+}
@@ -0,0 +1,57 @@
+package goproxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGoproxyServeFetchCacheControl(t *testing.T) {
+	cacher := &MemoryCacher{}
+	for name, content := range map[string]string{
+		"example.com/@v/list":        "v1.0.0",
+		"example.com/@latest":        `{"Version":"v1.0.0"}`,
+		"example.com/@v/v1.0.0.info": `{"Version":"v1.0.0"}`,
+		"example.com/@v/v1.0.0.mod":  "module example.com",
+	} {
+		if err := cacher.Put(context.Background(), name, strings.NewReader(content)); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+	g := &Goproxy{
+		Cacher:               cacher,
+		Offline:              true,
+		DownloadCacheControl: "public, max-age=31536000, immutable",
+		QueryCacheControl:    "public, max-age=30, must-revalidate",
+	}
+	for _, tt := range []struct {
+		n                int
+		path             string
+		ifNoneMatch      string
+		wantStatusCode   int
+		wantCacheControl string
+	}{
+		{1, "/example.com/@v/list", "", http.StatusOK, "public, max-age=30, must-revalidate"},
+		{2, "/example.com/@latest", "", http.StatusOK, "public, max-age=30, must-revalidate"},
+		{3, "/example.com/@v/v1.0.0.info", "", http.StatusOK, "public, max-age=31536000, immutable"},
+		{4, "/example.com/@v/v1.0.0.mod", "", http.StatusOK, "public, max-age=31536000, immutable"},
+		{5, "/example.com/@v/v1.0.0.mod", "*", http.StatusNotModified, "public, max-age=31536000, immutable"},
+		{6, "/example.com/@v/v1.1.0.mod", "", http.StatusNotFound, "public, max-age=60"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", tt.ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, req)
+		recr := rec.Result()
+		if got, want := recr.StatusCode, tt.wantStatusCode; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if got, want := recr.Header.Get("Cache-Control"), tt.wantCacheControl; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+}
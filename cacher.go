@@ -1,11 +1,34 @@
 package goproxy
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"container/list"
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/sumdb/dirhash"
 )
 
 // Cacher defines a set of intuitive methods used to cache module files for [Goproxy].
@@ -27,6 +50,19 @@ type Cacher interface {
 	//     headers when 1 is implemented. Note that the return value will be
 	//     assumed to have complied with RFC 7232, section 2.3, so it will
 	//     be used directly without further processing.
+	//  5. interface{ Size() int64 }, mainly for the Content-Length response
+	//     header when 1 is not implemented, so that HEAD requests report
+	//     the size without reading the content. A negative size means
+	//     unknown.
+	//
+	// If 4 is not implemented, ETags of .mod and .zip files are derived
+	// from their hashes. For .zip files, the hash is cached under the same
+	// name but with a ".ziphash" extension.
+	//
+	// All of the built-in cachers implement 1, so partial requests, such as
+	// those resuming interrupted .zip downloads, are answered with a 206
+	// status code, or a 416 status code if the range is unsatisfiable.
+	// Content that does not implement 1 is always served in full.
 	Get(ctx context.Context, name string) (io.ReadCloser, error)
 
 	// Put puts a cache for the name with the content.
@@ -36,38 +72,139 @@ type Cacher interface {
 // DirCacher implements [Cacher] using a directory on the local disk. If the
 // directory does not exist, it will be created with 0755 permissions. Cache
 // files will be created with 0644 permissions.
+//
+// Several processes, such as an old and a new one during a zero-downtime
+// restart, can share the same directory. See [DirCacher.Put] for how
+// concurrent puts are coordinated.
 type DirCacher string
 
 // Get implements [Cacher].
+//
+// Info and mod files compressed by [CompressedDirCacher] are detected and
+// decompressed, so caches put with and without compression are both served.
 func (dc DirCacher) Get(ctx context.Context, name string) (io.ReadCloser, error) {
-	f, err := os.Open(filepath.Join(string(dc), filepath.FromSlash(name)))
+	file, err := dc.file("open", name)
 	if err != nil {
 		return nil, err
 	}
+	acquireDirCacherFile(file)
+	f, err := os.Open(file)
+	if err != nil {
+		releaseDirCacherFile(file)
+		return nil, err
+	}
 	fi, err := f.Stat()
 	if err != nil {
+		f.Close()
+		releaseDirCacherFile(file)
 		return nil, err
 	}
-	return &struct {
-		*os.File
-		os.FileInfo
-	}{f, fi}, nil
+	if isDirCacherCompressible(name) {
+		magic := make([]byte, len(gzipMagic))
+		if n, _ := f.ReadAt(magic, 0); n == len(magic) && bytes.Equal(magic, gzipMagic) {
+			b, err := io.ReadAll(f)
+			f.Close()
+			releaseDirCacherFile(file)
+			if err != nil {
+				return nil, err
+			}
+			if b, err = decompressDirCacherContent(b); err != nil {
+				return nil, err
+			}
+			return &memoryCacherContent{bytes.NewReader(b), fi.ModTime()}, nil
+		}
+	}
+	return &dirCacherFile{File: f, FileInfo: fi, file: file}, nil
+}
+
+// dirCacherOpenFiles counts the files of any [DirCacher] that are open by
+// [DirCacher.Get], keyed by their paths, so that [DirCacher.CleanWatermarks]
+// never evicts a cache while it is being served.
+var dirCacherOpenFiles = struct {
+	sync.Mutex
+	counts map[string]int
+}{counts: map[string]int{}}
+
+// acquireDirCacherFile marks the file as open in [dirCacherOpenFiles]. It must
+// be called before the file is opened, so that the file is either evicted
+// before it is opened or not evicted at all.
+func acquireDirCacherFile(file string) {
+	dirCacherOpenFiles.Lock()
+	dirCacherOpenFiles.counts[file]++
+	dirCacherOpenFiles.Unlock()
+}
+
+// releaseDirCacherFile undoes an [acquireDirCacherFile] of the file.
+func releaseDirCacherFile(file string) {
+	dirCacherOpenFiles.Lock()
+	if dirCacherOpenFiles.counts[file]--; dirCacherOpenFiles.counts[file] <= 0 {
+		delete(dirCacherOpenFiles.counts, file)
+	}
+	dirCacherOpenFiles.Unlock()
+}
+
+// dirCacherFile is a cache file opened by [DirCacher.Get].
+type dirCacherFile struct {
+	*os.File
+	os.FileInfo
+	file      string
+	closeOnce sync.Once
+}
+
+// Close implements [io.Closer].
+func (f *dirCacherFile) Close() error {
+	err := f.File.Close()
+	f.closeOnce.Do(func() { releaseDirCacherFile(f.file) })
+	return err
+}
+
+// file returns the path to the cache file for the name in the dc. It fails with
+// a [fs.PathError] for the op wrapping [fs.ErrNotExist] if the name is not a
+// valid cache name, so that no name can refer to a file outside the dc. Valid
+// cache names are slash-separated relative paths as described by
+// [fs.ValidPath], without backslashes or control characters.
+func (dc DirCacher) file(op, name string) (string, error) {
+	if !fs.ValidPath(name) || name == "." || strings.ContainsFunc(name, func(r rune) bool {
+		return r == '\\' || unicode.IsControl(r)
+	}) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return filepath.Join(string(dc), filepath.FromSlash(name)), nil
 }
 
 // Put implements [Cacher].
+//
+// The content is first written to a temporary file in the same directory as
+// the cache file, which is then renamed to the cache file. So a partially
+// written cache file is never visible to [DirCacher.Get], even if the process
+// is killed during the write. On Windows, the rename replaces any existing
+// cache file as well.
+//
+// Temporary files are created exclusively under random names, so concurrent
+// puts of the same name never write to the same file, even from different
+// processes. Their renames are serialized by a lock file next to the cache
+// file, and a put whose content was written before the cache file was last put
+// by another one is discarded instead of replacing the newer cache file. A lock
+// file left behind by a killed process is atomically taken over by one of the
+// puts waiting for it once it is a few seconds old.
 func (dc DirCacher) Put(ctx context.Context, name string, content io.ReadSeeker) error {
-	file := filepath.Join(string(dc), filepath.FromSlash(name))
+	file, err := dc.file("put", name)
+	if err != nil {
+		return err
+	}
 	dir := filepath.Dir(file)
 	if err := os.MkdirAll(dir, 0o755); err != nil {
 		return err
 	}
 
+	startTime := time.Now()
 	f, err := os.CreateTemp(dir, fmt.Sprintf(".%s.tmp.*", filepath.Base(file)))
 	if err != nil {
 		return err
 	}
 	defer os.Remove(f.Name())
 	if _, err := io.Copy(f, content); err != nil {
+		f.Close() // An open file cannot be removed on Windows.
 		return err
 	}
 	if err := f.Close(); err != nil {
@@ -77,5 +214,1772 @@ func (dc DirCacher) Put(ctx context.Context, name string, content io.ReadSeeker)
 	if err := os.Chmod(f.Name(), 0o644); err != nil {
 		return err
 	}
-	return os.Rename(f.Name(), file)
+	return commitDirCacherFile(ctx, f.Name(), file, startTime)
+}
+
+// dirCacherLockMaxAge is the age after which the lock file of a cache file in a
+// [DirCacher] is considered abandoned by a killed process. Locks are held only
+// while renaming, so they are never legitimately held this long.
+const dirCacherLockMaxAge = 10 * time.Second
+
+// dirCacherLockRetryInterval is the interval between attempts to acquire the
+// lock file of a cache file in a [DirCacher] held by someone else.
+const dirCacherLockRetryInterval = 10 * time.Millisecond
+
+// commitDirCacherFile renames the tempFile, whose content was written since the
+// startTime, to the cache file while holding the lock file of the cache file.
+// If the cache file has been put by someone else since the startTime, the
+// tempFile is left as is for the caller to remove, so that the newer cache file
+// is kept.
+func commitDirCacherFile(ctx context.Context, tempFile, file string, startTime time.Time) error {
+	unlock, err := lockDirCacherFile(ctx, file)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if fi, err := os.Stat(file); err == nil {
+		// A modification time in the future means the clock was set back,
+		// in which case it says nothing about which put is newer.
+		if modTime := fi.ModTime(); !modTime.Before(startTime) && !modTime.After(time.Now()) {
+			return nil
+		}
+	}
+	return os.Rename(tempFile, file)
+}
+
+// lockDirCacherFile acquires the lock file of the cache file, waiting until the
+// ctx is done for it to be released by whoever holds it, in this or any other
+// process. The returned unlock must be called to release it.
+//
+// The lock file carries a token unique to its holder, so that a holder whose
+// lock has been taken over as stale never removes the lock of the next holder.
+func lockDirCacherFile(ctx context.Context, file string) (unlock func(), err error) {
+	lockFile := filepath.Join(filepath.Dir(file), fmt.Sprintf(".%s.lock", filepath.Base(file)))
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	token := fmt.Sprintf("%d.%s", os.Getpid(), hex.EncodeToString(b))
+	for {
+		f, err := os.OpenFile(lockFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err == nil {
+			_, err := f.WriteString(token)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(lockFile)
+				return nil, err
+			}
+			return func() { unlockDirCacherFile(lockFile, token) }, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+		if fi, err := os.Stat(lockFile); err == nil && time.Since(fi.ModTime()) > dirCacherLockMaxAge {
+			takeOverDirCacherLockFile(lockFile, fi, token)
+			continue
+		}
+		select {
+		case <-time.After(dirCacherLockRetryInterval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// takeOverDirCacherLockFile removes the lockFile, found abandoned as described
+// by the fi, so that it can be acquired again. The lockFile is first renamed to
+// a name unique to the token, which atomically succeeds for only one of the
+// processes taking it over at the same time. If the renamed lock file is not
+// the abandoned one, because another process took it over and acquired a new
+// one since the fi was taken, the new one is linked back in place unless yet
+// another one has been acquired meanwhile.
+func takeOverDirCacherLockFile(lockFile string, fi fs.FileInfo, token string) {
+	staleLockFile := fmt.Sprintf("%s.stale.%s", lockFile, token)
+	if err := os.Rename(lockFile, staleLockFile); err != nil {
+		return
+	}
+	if sfi, err := os.Stat(staleLockFile); err == nil && !os.SameFile(fi, sfi) {
+		os.Link(staleLockFile, lockFile)
+	}
+	os.Remove(staleLockFile)
+}
+
+// unlockDirCacherFile releases the lockFile acquired with the token. The
+// lockFile is left as is if it no longer carries the token, as it has then
+// been taken over as stale and possibly acquired by another process.
+func unlockDirCacherFile(lockFile, token string) {
+	if b, err := os.ReadFile(lockFile); err == nil && string(b) == token {
+		os.Remove(lockFile)
+	}
+}
+
+// Delete deletes the cache for the name. It returns [fs.ErrNotExist] if not
+// found. It is used by [Goproxy.AdminHandler].
+func (dc DirCacher) Delete(ctx context.Context, name string) error {
+	file, err := dc.file("remove", name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(file)
+}
+
+// List returns the sorted names of the caches that have the prefix. It is used
+// by [Goproxy.AdminHandler]. Only the directory of the prefix is walked, so
+// listing the caches of a module does not visit the rest of the dc.
+func (dc DirCacher) List(ctx context.Context, prefix string) ([]string, error) {
+	dir := path.Dir(prefix)
+	for _, elem := range strings.Split(dir, "/") {
+		if elem != "." && strings.HasPrefix(elem, ".") {
+			return nil, nil
+		}
+	}
+	root := filepath.Join(string(dc), filepath.FromSlash(dir))
+	var names []string
+	if err := filepath.WalkDir(root, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() && file != root {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(string(dc), file)
+		if err != nil {
+			return err
+		}
+		if name := filepath.ToSlash(rel); strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// Walk calls the fn for each cache in the dc with its name and size in bytes,
+// in lexical order of the names. It stops at the first error returned by the
+// fn and returns it. It is used by [Goproxy.AdminHandler].
+func (dc DirCacher) Walk(ctx context.Context, fn func(name string, size int64) error) error {
+	return filepath.WalkDir(string(dc), func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() && file != string(dc) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		rel, err := filepath.Rel(string(dc), file)
+		if err != nil {
+			return err
+		}
+		return fn(filepath.ToSlash(rel), fi.Size())
+	})
+}
+
+// CheckHealth checks whether the directory of the dc is writable. It is used by
+// [Goproxy.ReadinessHandler].
+func (dc DirCacher) CheckHealth(ctx context.Context) error {
+	if err := os.MkdirAll(string(dc), 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(string(dc), ".health.tmp.*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// dirCacherTempFileMaxAge is the age after which a temporary file in a
+// [DirCacher] is considered abandoned by an interrupted [DirCacher.Put].
+const dirCacherTempFileMaxAge = 24 * time.Hour
+
+// Clean evicts caches from the dc. It is the same as [DirCacher.CleanWatermarks]
+// with both watermarks set to the maxSize.
+func (dc DirCacher) Clean(ctx context.Context, maxAge time.Duration, maxSize int64) error {
+	_, err := dc.CleanWatermarks(ctx, maxAge, maxSize, maxSize)
+	return err
+}
+
+// DirCacherCleanResult is the result of cleaning a [DirCacher] by
+// [DirCacher.CleanWatermarks] or [DirCacher.CleanNotFound].
+type DirCacherCleanResult struct {
+	// EvictedEntries is the number of evicted caches, counting the info,
+	// mod, zip, and ziphash files of the same module version as one.
+	EvictedEntries int
+
+	// EvictedBytes is the total size in bytes of the evicted caches.
+	EvictedBytes int64
+
+	// Size is the total size in bytes of the remaining caches.
+	Size int64
+}
+
+// CleanWatermarks evicts caches from the dc. Caches that were put longer than
+// the maxAge ago are evicted. Then, if the total size of the remaining caches
+// exceeds the highWatermark, the least recently used caches are evicted until
+// it does not exceed the lowWatermark, so that a cache hovering around its
+// limit is not cleaned over and over again. A zero maxAge or highWatermark
+// means no limit. A lowWatermark that is not positive or exceeds the
+// highWatermark means the highWatermark.
+//
+// The info, mod, zip, and ziphash files of the same module version are evicted
+// together, so no orphans are left behind. Caches are aged by their
+// modification time, and their use is tracked by the later of their access and
+// modification times, which falls back to the latter on file systems mounted
+// without access times. Caches that are being served from a [DirCacher.Get] of
+// this process are never evicted.
+//
+// CleanWatermarks does not block concurrent use of the dc. Files that are
+// being written by [DirCacher.Put] are skipped, and temporary files abandoned
+// by interrupted writes are removed after a day. Content stored by
+// [DedupDirCacher.Put] that is no longer linked to any cache is removed after a
+// day as well. Hard links to the same content count toward the total size only
+// once, and the size of the content is counted as evicted only when its last
+// link is.
+func (dc DirCacher) CleanWatermarks(ctx context.Context, maxAge time.Duration, highWatermark, lowWatermark int64) (DirCacherCleanResult, error) {
+	if lowWatermark <= 0 || lowWatermark > highWatermark {
+		lowWatermark = highWatermark
+	}
+	type cacheGroup struct {
+		files      []string
+		infos      []fs.FileInfo
+		modTime    time.Time
+		accessTime time.Time
+		evicted    bool
+	}
+	var result DirCacherCleanResult
+	groups := map[string]*cacheGroup{}
+	links := map[fileID]int{}
+	now := time.Now()
+	if err := filepath.WalkDir(string(dc), func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if strings.HasPrefix(d.Name(), ".") && file != string(dc) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".") {
+			if now.Sub(fi.ModTime()) > dirCacherTempFileMaxAge {
+				os.Remove(file)
+			}
+			return nil
+		}
+
+		key := file
+		if filepath.Base(filepath.Dir(file)) == "@v" {
+			switch ext := filepath.Ext(file); ext {
+			case ".info", ".mod", ".zip", ".ziphash":
+				key = strings.TrimSuffix(file, ext)
+			}
+		}
+		g, ok := groups[key]
+		if !ok {
+			g = &cacheGroup{}
+			groups[key] = g
+		}
+		g.files = append(g.files, file)
+		g.infos = append(g.infos, fi)
+		if id, ok := fileIdentity(fi); ok {
+			links[id]++
+			if links[id] > 1 {
+				return nil
+			}
+		}
+		result.Size += fi.Size()
+		if fi.ModTime().After(g.modTime) {
+			g.modTime = fi.ModTime()
+		}
+		if accessTime := fileAccessTime(fi); accessTime.After(g.accessTime) {
+			g.accessTime = accessTime
+		}
+		return nil
+	}); err != nil {
+		return result, err
+	}
+
+	var remaining []*cacheGroup
+	evict := func(g *cacheGroup) error {
+		dirCacherOpenFiles.Lock()
+		defer dirCacherOpenFiles.Unlock()
+		for _, file := range g.files {
+			if dirCacherOpenFiles.counts[file] > 0 {
+				return nil
+			}
+		}
+		g.evicted = true
+		result.EvictedEntries++
+		for i, file := range g.files {
+			if err := os.Remove(file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			size := g.infos[i].Size()
+			if id, ok := fileIdentity(g.infos[i]); ok {
+				if links[id]--; links[id] > 0 {
+					continue
+				}
+			}
+			result.EvictedBytes += size
+			result.Size -= size
+		}
+		return nil
+	}
+	for _, g := range groups {
+		if maxAge > 0 && now.Sub(g.modTime) > maxAge {
+			if err := evict(g); err != nil {
+				return result, err
+			}
+			if g.evicted {
+				continue
+			}
+		}
+		remaining = append(remaining, g)
+	}
+	if highWatermark > 0 && result.Size > highWatermark {
+		sort.Slice(remaining, func(i, j int) bool {
+			return remaining[i].accessTime.Before(remaining[j].accessTime)
+		})
+		for _, g := range remaining {
+			if result.Size <= lowWatermark {
+				break
+			}
+			if err := ctx.Err(); err != nil {
+				return result, err
+			}
+			if err := evict(g); err != nil {
+				return result, err
+			}
+		}
+	}
+
+	linked := map[int64][]fs.FileInfo{}
+	for _, g := range groups {
+		if g.evicted {
+			continue
+		}
+		for _, fi := range g.infos {
+			linked[fi.Size()] = append(linked[fi.Size()], fi)
+		}
+	}
+	return result, dc.cleanBlobs(ctx, now, linked)
+}
+
+// fileID identifies a file by its device and inode numbers, so hard links to
+// the same file share one.
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+// cleanBlobs removes the content stored by [DedupDirCacher.Put] in the dc that
+// is not the same file as any of the linked, which are keyed by their sizes,
+// along with abandoned temporary files. Only files older than a day are
+// removed, so content that is about to be linked is never removed.
+func (dc DirCacher) cleanBlobs(ctx context.Context, now time.Time, linked map[int64][]fs.FileInfo) error {
+	return filepath.WalkDir(filepath.Join(string(dc), dirCacherBlobsDir), func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if now.Sub(fi.ModTime()) <= dirCacherTempFileMaxAge {
+			return nil
+		}
+		if !strings.HasPrefix(d.Name(), ".") {
+			for _, lfi := range linked[fi.Size()] {
+				if os.SameFile(fi, lfi) {
+					return nil
+				}
+			}
+		}
+		if err := os.Remove(file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	})
+}
+
+// CleanNotFound evicts the "not found" results cached in the dc by a
+// [Goproxy] with a NotFoundTTL or a NotFoundQueryTTL that were put longer than
+// the maxAge ago. A zero maxAge evicts all of them. Such results are never
+// served after their TTL expires, so the maxAge is usually the longer of the
+// two TTLs.
+//
+// The "not found" results are left alone by [DirCacher.CleanWatermarks] unless
+// they exceed its limits, so without CleanNotFound they pile up on disk for
+// every missing module path a client ever asked for.
+func (dc DirCacher) CleanNotFound(ctx context.Context, maxAge time.Duration) (DirCacherCleanResult, error) {
+	var result DirCacherCleanResult
+	now := time.Now()
+	err := filepath.WalkDir(string(dc), func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if strings.HasPrefix(d.Name(), ".") && file != string(dc) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".") || !strings.HasSuffix(d.Name(), notFoundCacheNameSuffix) {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if now.Sub(fi.ModTime()) < maxAge {
+			result.Size += fi.Size()
+			return nil
+		}
+		dirCacherOpenFiles.Lock()
+		defer dirCacherOpenFiles.Unlock()
+		if dirCacherOpenFiles.counts[file] > 0 {
+			result.Size += fi.Size()
+			return nil
+		}
+		if err := os.Remove(file); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		result.EvictedEntries++
+		result.EvictedBytes += fi.Size()
+		return nil
+	})
+	return result, err
+}
+
+// DirCacherVerifyResult is the result of verifying the caches of a module
+// version by [DirCacher.Verify].
+type DirCacherVerifyResult struct {
+	// Name is the cache name of the module version without an extension,
+	// such as "example.com/@v/v1.0.0".
+	Name string
+
+	// Err is the reason why the caches are corrupt. It is nil if they are
+	// intact.
+	Err error
+
+	// Deleted reports whether the corrupt caches were deleted.
+	Deleted bool
+}
+
+// Verify verifies the info, mod, zip, and ziphash files of each module version
+// in the dc, and calls the fn with the result. The fn is never called
+// concurrently, but the results are not in any particular order.
+//
+// The hash of a zip file is recomputed and compared against its ziphash file,
+// and a mod file must be parsable and match the go.mod file in the zip file, if
+// any. An info file must be valid JSON describing the same
+// version. If deleteCorrupt is true, all the files of a corrupt module version
+// are deleted, so they are fetched again when next requested.
+//
+// At most concurrency module versions are verified at the same time. A
+// concurrency less than 1 means 1.
+func (dc DirCacher) Verify(ctx context.Context, concurrency int, deleteCorrupt bool, fn func(result DirCacherVerifyResult)) error {
+	groups := map[string]map[string]string{} // name -> ext -> file
+	if err := filepath.WalkDir(string(dc), func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") || filepath.Base(filepath.Dir(file)) != "@v" {
+			return nil
+		}
+		ext := filepath.Ext(file)
+		switch ext {
+		case ".info", ".mod", ".zip", ".ziphash":
+		default:
+			return nil
+		}
+		rel, err := filepath.Rel(string(dc), strings.TrimSuffix(file, ext))
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if groups[name] == nil {
+			groups[name] = map[string]string{}
+		}
+		groups[name][ext] = file
+		return nil
+	}); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	var (
+		fnMutex sync.Mutex
+		wg      sync.WaitGroup
+		sem     = make(chan struct{}, concurrency)
+	)
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			wg.Wait()
+			return err
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(name string, files map[string]string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			result := DirCacherVerifyResult{Name: name, Err: verifyDirCacherModuleVersion(name, files)}
+			if result.Err != nil && deleteCorrupt {
+				result.Deleted = true
+				for _, file := range files {
+					if err := os.Remove(file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+						result.Deleted = false
+					}
+				}
+			}
+			fnMutex.Lock()
+			fn(result)
+			fnMutex.Unlock()
+		}(name, groups[name])
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// verifyDirCacherModuleVersion verifies the files, keyed by their extensions,
+// of the module version with the cache name as described in [DirCacher.Verify].
+func verifyDirCacherModuleVersion(name string, files map[string]string) error {
+	escapedModulePath, escapedModuleVersion, _ := strings.Cut(name, "/@v/")
+	modulePath, err := module.UnescapePath(escapedModulePath)
+	if err != nil {
+		return fmt.Errorf("invalid module path: %w", err)
+	}
+	moduleVersion, err := module.UnescapeVersion(escapedModuleVersion)
+	if err != nil {
+		return fmt.Errorf("invalid module version: %w", err)
+	}
+
+	if file, ok := files[".info"]; ok {
+		b, err := readDirCacherFile(file)
+		if err != nil {
+			return err
+		}
+		var info struct{ Version string }
+		if err := json.Unmarshal(b, &info); err != nil {
+			return fmt.Errorf("invalid info file: %w", err)
+		}
+		if info.Version != moduleVersion {
+			return fmt.Errorf("info file has version %q, want %q", info.Version, moduleVersion)
+		}
+	}
+
+	var goMod []byte
+	if file, ok := files[".mod"]; ok {
+		if goMod, err = readDirCacherFile(file); err != nil {
+			return err
+		}
+		if _, err := modfile.ParseLax("go.mod", goMod, nil); err != nil {
+			return fmt.Errorf("invalid mod file: %w", err)
+		}
+	}
+
+	if file, ok := files[".zip"]; ok {
+		zipHash, err := dirhash.HashZip(file, dirhash.DefaultHash)
+		if err != nil {
+			return fmt.Errorf("invalid zip file: %w", err)
+		}
+		if zipHashFile, ok := files[zipHashCacheNameExt]; ok {
+			b, err := os.ReadFile(zipHashFile)
+			if err != nil {
+				return err
+			}
+			if want := strings.TrimSpace(string(b)); zipHash != want {
+				return fmt.Errorf("zip file has hash %q, want %q", zipHash, want)
+			}
+		}
+		// The go.mod file in the zip file of an incompatible version is
+		// not necessarily the one served as its mod file.
+		if goMod != nil && !strings.HasSuffix(moduleVersion, "+incompatible") {
+			zr, err := zip.OpenReader(file)
+			if err != nil {
+				return fmt.Errorf("invalid zip file: %w", err)
+			}
+			defer zr.Close()
+			for _, zf := range zr.File {
+				if zf.Name != modulePath+"@"+moduleVersion+"/go.mod" {
+					continue
+				}
+				rc, err := zf.Open()
+				if err != nil {
+					return fmt.Errorf("invalid zip file: %w", err)
+				}
+				b, err := io.ReadAll(rc)
+				rc.Close()
+				if err != nil {
+					return fmt.Errorf("invalid zip file: %w", err)
+				}
+				if !bytes.Equal(b, goMod) {
+					return errors.New("mod file does not match go.mod in zip file")
+				}
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// Export writes the caches in the dc for whose names the include returns true,
+// or all of them if the include is nil, to the w as a tar archive that can be
+// read by [DirCacher.Import]. The caches are written as they are walked, in
+// lexical order of their names and with their modification times rounded to
+// the second, so caches of any size can be exported, and exporting the same
+// caches always writes the same archive.
+func (dc DirCacher) Export(ctx context.Context, w io.Writer, include func(name string) bool) error {
+	tw := tar.NewWriter(w)
+	if err := dc.Walk(ctx, func(name string, _ int64) error {
+		if include != nil && !include(name) {
+			return nil
+		}
+		file, err := dc.file("export", name)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil // Evicted since walked.
+			}
+			return err
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Size:     fi.Size(),
+			Mode:     0o644,
+			ModTime:  fi.ModTime(),
+		}); err != nil {
+			return err
+		}
+		_, err = io.CopyN(tw, f, fi.Size())
+		return err
+	}); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// Import reads the caches in the r, a tar archive as written by
+// [DirCacher.Export], into the dc with their modification times. The info,
+// mod, zip, and ziphash files of each module version are verified as by
+// [DirCacher.Verify] before they are put, and the fn, if not nil, is called
+// with the result. Module versions that fail the verification are not
+// imported. Other caches, such as version lists and checksum database tiles,
+// are put as is. Entries that are not regular files or whose names are not
+// valid cache names are skipped.
+//
+// Caches are staged in a hidden directory of the dc and then renamed into
+// place, so a partially imported cache is never visible to [DirCacher.Get].
+// Only the files of one module version are staged at a time, as long as they
+// are adjacent in the archive, as they are in archives written by
+// [DirCacher.Export].
+func (dc DirCacher) Import(ctx context.Context, r io.Reader, fn func(result DirCacherVerifyResult)) error {
+	if err := os.MkdirAll(string(dc), 0o755); err != nil {
+		return err
+	}
+	stagingDir, err := os.MkdirTemp(string(dc), ".import.tmp.*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(stagingDir)
+
+	type stagedCache struct {
+		name    string
+		file    string
+		modTime time.Time
+	}
+	commit := func(sc stagedCache) error {
+		file, err := dc.file("import", sc.name)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			return err
+		}
+		if err := os.Chtimes(sc.file, sc.modTime, sc.modTime); err != nil {
+			return err
+		}
+		return os.Rename(sc.file, file)
+	}
+
+	var (
+		groupName string
+		group     []stagedCache
+	)
+	flushGroup := func() error {
+		if len(group) == 0 {
+			return nil
+		}
+		files := make(map[string]string, len(group))
+		for _, sc := range group {
+			files[path.Ext(sc.name)] = sc.file
+		}
+		result := DirCacherVerifyResult{Name: groupName, Err: verifyDirCacherModuleVersion(groupName, files)}
+		for _, sc := range group {
+			if result.Err != nil {
+				os.Remove(sc.file)
+				continue
+			}
+			if err := commit(sc); err != nil {
+				return err
+			}
+		}
+		group = group[:0]
+		if fn != nil {
+			fn(result)
+		}
+		return nil
+	}
+
+	tr := tar.NewReader(r)
+	for i := 0; ; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		hdr, err := tr.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		name := hdr.Name
+		if hdr.Typeflag != tar.TypeReg || strings.HasPrefix(name, ".") || strings.Contains(name, "/.") {
+			continue
+		}
+		if _, err := dc.file("import", name); err != nil {
+			continue
+		}
+
+		// The staged file keeps the extension of the cache name, which
+		// tells whether it may be compressed.
+		sc := stagedCache{
+			name:    name,
+			file:    filepath.Join(stagingDir, strconv.Itoa(i)+path.Ext(name)),
+			modTime: hdr.ModTime,
+		}
+		f, err := os.OpenFile(sc.file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, tr); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+
+		var key string
+		if ext := path.Ext(name); path.Base(path.Dir(name)) == "@v" && slices.Contains(moduleVersionCacheNameExts, ext) {
+			key = strings.TrimSuffix(name, ext)
+		}
+		if key != groupName {
+			if err := flushGroup(); err != nil {
+				return err
+			}
+			groupName = key
+		}
+		if key == "" {
+			if err := commit(sc); err != nil {
+				return err
+			}
+			continue
+		}
+		group = append(group, sc)
+	}
+	return flushGroup()
+}
+
+// dirCacherBlobsDir is the directory in a [DirCacher] where [DedupDirCacher]
+// stores content under its hash.
+const dirCacherBlobsDir = ".blobs"
+
+// DedupDirCacher is a [DirCacher] that stores byte-identical module zip files,
+// such as those of versions re-tagged from the same commit, only once. The
+// content of each zip file is stored under its SHA-256 hash in the ".blobs"
+// directory of the DirCacher, and the cache file is a hard link to it. Other
+// caches are put as by the DirCacher.
+//
+// On file systems that do not support hard links, zip files are copied
+// instead, as by the DirCacher, so nothing is saved, but nothing breaks either.
+//
+// Hard links share their modification time, so putting a zip file refreshes
+// the modification time of all caches with the same content, and
+// [DirCacher.Clean] ages them together. Content that is no longer linked to
+// any cache is removed by [DirCacher.Clean].
+type DedupDirCacher struct {
+	DirCacher
+
+	// Compression is the compression method of info and mod files, as in
+	// [CompressedDirCacher].
+	Compression string
+}
+
+// Put implements [Cacher].
+func (ddc DedupDirCacher) Put(ctx context.Context, name string, content io.ReadSeeker) error {
+	if path.Ext(name) != ".zip" {
+		return CompressedDirCacher{ddc.DirCacher, ddc.Compression}.Put(ctx, name, content)
+	}
+
+	file, err := ddc.file("put", name)
+	if err != nil {
+		return err
+	}
+	blob, err := ddc.putBlob(content)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(file)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	linkFile := filepath.Join(dir, fmt.Sprintf(".%s.tmp.%s", filepath.Base(file), hex.EncodeToString(suffix)))
+	if err := os.Link(blob, linkFile); err != nil {
+		// The stored content is useless without a link to it, and caches
+		// that already link to it are unaffected by removing it.
+		os.Remove(blob)
+		if _, err := content.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		return ddc.DirCacher.Put(ctx, name, content)
+	}
+	defer os.Remove(linkFile)
+	now := time.Now()
+	if err := os.Chtimes(linkFile, now, now); err != nil {
+		return err
+	}
+	return commitDirCacherFile(ctx, linkFile, file, now)
+}
+
+// putBlob stores the content under its hash in the ddc, unless already stored,
+// and returns the path to the stored file.
+func (ddc DedupDirCacher) putBlob(content io.Reader) (string, error) {
+	blobsDir := filepath.Join(string(ddc.DirCacher), dirCacherBlobsDir)
+	if err := os.MkdirAll(blobsDir, 0o755); err != nil {
+		return "", err
+	}
+
+	f, err := os.CreateTemp(blobsDir, ".tmp.*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), content); err != nil {
+		f.Close() // An open file cannot be removed on Windows.
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+
+	hash := hex.EncodeToString(h.Sum(nil))
+	blob := filepath.Join(blobsDir, hash[:2], hash)
+	if _, err := os.Stat(blob); err == nil {
+		return blob, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(blob), 0o755); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(f.Name(), 0o644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(f.Name(), blob); err != nil {
+		return "", err
+	}
+	return blob, nil
+}
+
+// gzipMagic is the magic number at the start of gzip data. Info and mod files
+// never start with it, as they are JSON and go.mod files.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// isDirCacherCompressible reports whether the cache with the name is compressed
+// by [CompressedDirCacher]. Zip files are already compressed.
+func isDirCacherCompressible(name string) bool {
+	switch path.Ext(name) {
+	case ".info", ".mod":
+		return true
+	}
+	return false
+}
+
+// decompressDirCacherContent returns the b decompressed if it is compressed by
+// [CompressedDirCacher], or as is otherwise.
+func decompressDirCacherContent(b []byte) ([]byte, error) {
+	if !bytes.HasPrefix(b, gzipMagic) {
+		return b, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
+
+// readDirCacherFile reads the cache file, decompressing it if it is compressed
+// by [CompressedDirCacher].
+func readDirCacherFile(file string) ([]byte, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if !isDirCacherCompressible(file) {
+		return b, nil
+	}
+	return decompressDirCacherContent(b)
+}
+
+// CompressedDirCacher is a [DirCacher] that compresses info and mod files,
+// which are small text files that compress well, with the Compression before
+// storing them. Other caches, including zip files that are already compressed,
+// are put as by the DirCacher. Since [DirCacher.Get] detects and decompresses
+// compressed files, existing caches keep being served as they are, and the
+// DirCacher can be switched to and from a CompressedDirCacher at any time.
+type CompressedDirCacher struct {
+	DirCacher
+
+	// Compression is the compression method. The only supported one is
+	// "gzip". If Compression is empty, nothing is compressed.
+	Compression string
+}
+
+// Put implements [Cacher].
+func (cdc CompressedDirCacher) Put(ctx context.Context, name string, content io.ReadSeeker) error {
+	if cdc.Compression == "" || !isDirCacherCompressible(name) {
+		return cdc.DirCacher.Put(ctx, name, content)
+	}
+	if cdc.Compression != "gzip" {
+		return fmt.Errorf("unsupported compression %q", cdc.Compression)
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := io.Copy(zw, content); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return cdc.DirCacher.Put(ctx, name, bytes.NewReader(buf.Bytes()))
+}
+
+// errReadOnlyCacher is the error returned by [FSCacher.Put].
+var errReadOnlyCacher = fmt.Errorf("read-only cacher: %w", fs.ErrPermission)
+
+// FSCacher implements [Cacher] using an [fs.FS], such as an [embed.FS], laid
+// out as the directory of a [DirCacher]. It is read-only, so it is meant to
+// serve a fixed set of module files with [Goproxy.Offline], for example from
+// the binary itself in air-gapped environments. Anything not in the FS is not
+// found.
+type FSCacher struct {
+	// FS is the file system of the caches.
+	FS fs.FS
+}
+
+// Get implements [Cacher].
+func (fc FSCacher) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	if !fs.ValidPath(name) {
+		return nil, fs.ErrNotExist
+	}
+	f, err := fc.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if fi.IsDir() {
+		f.Close()
+		return nil, fs.ErrNotExist
+	}
+	if isDirCacherCompressible(name) {
+		b, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		if b, err = decompressDirCacherContent(b); err != nil {
+			return nil, err
+		}
+		return &memoryCacherContent{bytes.NewReader(b), fi.ModTime()}, nil
+	}
+	if rs, ok := f.(io.ReadSeeker); ok {
+		return &struct {
+			io.ReadSeeker
+			io.Closer
+			fs.FileInfo
+		}{rs, f, fi}, nil
+	}
+
+	// Files that cannot seek are read into memory, so that range requests
+	// are still answered.
+	b, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	return &struct {
+		io.ReadSeeker
+		io.Closer
+		fs.FileInfo
+	}{bytes.NewReader(b), io.NopCloser(nil), fi}, nil
+}
+
+// Put implements [Cacher]. It always fails, as the fc is read-only.
+func (fc FSCacher) Put(ctx context.Context, name string, content io.ReadSeeker) error {
+	return errReadOnlyCacher
+}
+
+// ModCacheCacher implements [Cacher] using the module cache of the go command,
+// which is the directory named by GOMODCACHE, so that a Goproxy can serve what
+// local builds on the same machine have already downloaded. Since direct
+// fetches are made by the go command, setting GOMODCACHE in [Goproxy.Env] to
+// the same directory also has them populate it.
+//
+// The "cache/download" directory of the module cache is laid out like a
+// [DirCacher], but only its info, mod, and zip files, their hashes, and the
+// tiles of checksum databases are served. Version lists there only hold the
+// versions downloaded so far, so they are never served as if they were
+// complete, and neither are the partial and lock files of the go command.
+//
+// A ModCacheCacher is read-only, since the module cache is managed by the go
+// command. Use it behind a writable Cacher in a [TieredCacher] to cache module
+// files fetched from proxies as well.
+type ModCacheCacher string
+
+// Get implements [Cacher].
+func (mcc ModCacheCacher) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	if !isModCacheName(name) {
+		return nil, fs.ErrNotExist
+	}
+	return DirCacher(filepath.Join(string(mcc), "cache", "download")).Get(ctx, name)
+}
+
+// Put implements [Cacher]. It always fails, as the mcc is read-only.
+func (mcc ModCacheCacher) Put(ctx context.Context, name string, content io.ReadSeeker) error {
+	return errReadOnlyCacher
+}
+
+// isModCacheName reports whether the cache name is served by [ModCacheCacher].
+func isModCacheName(name string) bool {
+	if strings.HasPrefix(name, "sumdb/") {
+		_, tile, ok := strings.Cut(strings.TrimPrefix(name, "sumdb/"), "/")
+		return ok && strings.HasPrefix(tile, "tile/")
+	}
+	if !strings.Contains(name, "/@v/") {
+		return false
+	}
+	switch path.Ext(name) {
+	case ".info", ".mod", ".zip", zipHashCacheNameExt:
+		return true
+	}
+	return false
+}
+
+// MemoryCacher implements [Cacher] using a least-recently-used cache in memory.
+// It is safe for concurrent use. The zero value is ready to use.
+//
+// Cached content is never modified once put, so readers returned by
+// [MemoryCacher.Get] share it without copying.
+type MemoryCacher struct {
+	// MaxSize is the maximum total size in bytes of the cached content.
+	// When it is exceeded, the least recently used caches are evicted.
+	// Content larger than MaxSize is not cached at all.
+	//
+	// If MaxSize is zero, there is no limit.
+	MaxSize int64
+
+	// Now returns the current time, which is recorded as the time when
+	// each cache is put. See [Goproxy.Now].
+	//
+	// If Now is nil, [time.Now] is used.
+	Now func() time.Time
+
+	mutex   sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+	size    int64
+}
+
+// memoryCacherEntry is an entry of [MemoryCacher].
+type memoryCacherEntry struct {
+	name    string
+	content []byte
+	modTime time.Time
+}
+
+// memoryCacherContent is the content returned by [MemoryCacher.Get]. It is also
+// returned for the decompressed content of the caches compressed by
+// [CompressedDirCacher].
+type memoryCacherContent struct {
+	*bytes.Reader
+	modTime time.Time
+}
+
+// Close implements [io.Closer].
+func (memoryCacherContent) Close() error {
+	return nil
+}
+
+// ModTime returns the time when the content was cached.
+func (mcc memoryCacherContent) ModTime() time.Time {
+	return mcc.modTime
+}
+
+// Get implements [Cacher].
+func (mc *MemoryCacher) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+	e, ok := mc.entries[name]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	mc.lru.MoveToFront(e)
+	entry := e.Value.(*memoryCacherEntry)
+	return memoryCacherContent{bytes.NewReader(entry.content), entry.modTime}, nil
+}
+
+// Put implements [Cacher].
+func (mc *MemoryCacher) Put(ctx context.Context, name string, content io.ReadSeeker) error {
+	offset, err := content.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	end, err := content.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if mc.MaxSize > 0 && end-offset > mc.MaxSize {
+		mc.mutex.Lock()
+		mc.remove(name)
+		mc.mutex.Unlock()
+		return nil
+	}
+	if _, err := content.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	b, err := io.ReadAll(content)
+	if err != nil {
+		return err
+	}
+
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+	if mc.entries == nil {
+		mc.lru = list.New()
+		mc.entries = map[string]*list.Element{}
+	}
+	mc.remove(name)
+	mc.entries[name] = mc.lru.PushFront(&memoryCacherEntry{
+		name:    name,
+		content: b,
+		modTime: mc.now(),
+	})
+	mc.size += int64(len(b))
+	for mc.MaxSize > 0 && mc.size > mc.MaxSize {
+		mc.remove(mc.lru.Back().Value.(*memoryCacherEntry).name)
+	}
+	return nil
+}
+
+// now returns the current time according to the mc.Now.
+func (mc *MemoryCacher) now() time.Time {
+	if mc.Now != nil {
+		return mc.Now()
+	}
+	return time.Now()
+}
+
+// Delete deletes the cache for the name. It returns [fs.ErrNotExist] if not
+// found.
+func (mc *MemoryCacher) Delete(ctx context.Context, name string) error {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+	if _, ok := mc.entries[name]; !ok {
+		return fs.ErrNotExist
+	}
+	mc.remove(name)
+	return nil
+}
+
+// List returns the sorted names of the caches that have the prefix.
+func (mc *MemoryCacher) List(ctx context.Context, prefix string) ([]string, error) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+	var names []string
+	for name := range mc.entries {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Walk calls the fn for each cache in the mc with its name and size in bytes,
+// in lexical order of the names. It stops at the first error returned by the
+// fn and returns it.
+func (mc *MemoryCacher) Walk(ctx context.Context, fn func(name string, size int64) error) error {
+	type cache struct {
+		name string
+		size int64
+	}
+	mc.mutex.Lock()
+	caches := make([]cache, 0, len(mc.entries))
+	for name, e := range mc.entries {
+		caches = append(caches, cache{name, int64(len(e.Value.(*memoryCacherEntry).content))})
+	}
+	mc.mutex.Unlock()
+	sort.Slice(caches, func(i, j int) bool { return caches[i].name < caches[j].name })
+	for _, c := range caches {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(c.name, c.size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remove removes the cache for the name. The mc.mutex must be held.
+func (mc *MemoryCacher) remove(name string) {
+	e, ok := mc.entries[name]
+	if !ok {
+		return
+	}
+	mc.lru.Remove(e)
+	delete(mc.entries, name)
+	mc.size -= int64(len(e.Value.(*memoryCacherEntry).content))
+}
+
+// Len returns the number of caches in the mc.
+func (mc *MemoryCacher) Len() int {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+	return len(mc.entries)
+}
+
+// Size returns the total size in bytes of the cached content in the mc.
+func (mc *MemoryCacher) Size() int64 {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+	return mc.size
+}
+
+// RedisClient is the subset of a Redis client used by [RedisCacher]. It can be
+// implemented by a thin adapter around any Redis client library.
+type RedisClient interface {
+	// Get returns the value of the key. It returns an error that satisfies
+	// errors.Is(err, fs.ErrNotExist) if the key does not exist.
+	Get(ctx context.Context, key string) ([]byte, error)
+
+	// Set sets the value of the key. If ttl is greater than zero, the key
+	// expires after ttl. Set must not retain the value after it returns.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+
+	// Del deletes the keys. Keys that do not exist are ignored.
+	Del(ctx context.Context, keys ...string) error
+}
+
+// RedisCacher implements [Cacher] using Redis, so that multiple [Goproxy]
+// instances can share the same cache. It is safe for concurrent use.
+//
+// Content is stored in chunks of at most ChunkSize bytes, and readers returned
+// by [RedisCacher.Get] fetch one chunk at a time, so module files of any size
+// can be cached without loading them into memory at once.
+type RedisCacher struct {
+	// Client is the Redis client.
+	Client RedisClient
+
+	// KeyPrefix is the prefix for all keys used by the [RedisCacher].
+	KeyPrefix string
+
+	// TTL is the time to live of the caches.
+	//
+	// If TTL is zero, the caches never expire.
+	TTL time.Duration
+
+	// ChunkSize is the maximum size in bytes of each stored chunk.
+	//
+	// If ChunkSize is zero, 1 MiB is used.
+	ChunkSize int
+}
+
+// redisCacherMetadata is the metadata of a cache of [RedisCacher].
+type redisCacherMetadata struct {
+	ID        string    `json:"id"`
+	Size      int64     `json:"size"`
+	ChunkSize int64     `json:"chunkSize"`
+	ModTime   time.Time `json:"modTime"`
+}
+
+// metadataKey returns the key of the metadata of the cache for the name.
+func (rc *RedisCacher) metadataKey(name string) string {
+	return rc.KeyPrefix + name
+}
+
+// chunkKeys returns the keys of the chunks described by the metadata of the
+// cache for the name.
+func (rc *RedisCacher) chunkKeys(name string, metadata redisCacherMetadata) []string {
+	n := (metadata.Size + metadata.ChunkSize - 1) / metadata.ChunkSize
+	keys := make([]string, 0, n)
+	for i := int64(0); i < n; i++ {
+		keys = append(keys, fmt.Sprintf("%s%s@%s/%d", rc.KeyPrefix, name, metadata.ID, i))
+	}
+	return keys
+}
+
+// getMetadata gets the metadata of the cache for the name.
+func (rc *RedisCacher) getMetadata(ctx context.Context, name string) (redisCacherMetadata, error) {
+	b, err := rc.Client.Get(ctx, rc.metadataKey(name))
+	if err != nil {
+		return redisCacherMetadata{}, err
+	}
+	var metadata redisCacherMetadata
+	if err := json.Unmarshal(b, &metadata); err != nil {
+		return redisCacherMetadata{}, err
+	}
+	if metadata.ChunkSize <= 0 || metadata.Size < 0 {
+		return redisCacherMetadata{}, errors.New("invalid redis cacher metadata")
+	}
+	return metadata, nil
+}
+
+// Get implements [Cacher].
+func (rc *RedisCacher) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	metadata, err := rc.getMetadata(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return &redisCacherContent{
+		ctx:       ctx,
+		client:    rc.Client,
+		metadata:  metadata,
+		chunkKeys: rc.chunkKeys(name, metadata),
+	}, nil
+}
+
+// Put implements [Cacher].
+//
+// The chunks are stored before the metadata that refers to them, so concurrent
+// readers never observe a partially stored cache. The chunks of any previous
+// cache for the name are deleted afterwards, so a reader of the previous cache
+// that is still in progress fails with [io.ErrUnexpectedEOF].
+func (rc *RedisCacher) Put(ctx context.Context, name string, content io.ReadSeeker) error {
+	chunkSize := rc.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = 1 << 20
+	}
+
+	oldMetadata, err := rc.getMetadata(ctx, name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	hasOldMetadata := err == nil
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	metadata := redisCacherMetadata{
+		ID:        hex.EncodeToString(id),
+		ChunkSize: int64(chunkSize),
+		ModTime:   time.Now().UTC(),
+	}
+
+	// Chunks outlive the metadata slightly, so a reader never finds a
+	// chunk expired before the metadata that refers to it.
+	chunkTTL := rc.TTL
+	if chunkTTL > 0 {
+		chunkTTL += time.Minute
+	}
+
+	var storedChunkKeys []string
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := io.ReadFull(content, buf)
+		if n > 0 {
+			key := fmt.Sprintf("%s%s@%s/%d", rc.KeyPrefix, name, metadata.ID, len(storedChunkKeys))
+			if err := rc.Client.Set(ctx, key, buf[:n], chunkTTL); err != nil {
+				rc.Client.Del(ctx, append(storedChunkKeys, key)...)
+				return err
+			}
+			storedChunkKeys = append(storedChunkKeys, key)
+			metadata.Size += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			rc.Client.Del(ctx, storedChunkKeys...)
+			return err
+		}
+	}
+
+	b, err := json.Marshal(metadata)
+	if err != nil {
+		rc.Client.Del(ctx, storedChunkKeys...)
+		return err
+	}
+	if err := rc.Client.Set(ctx, rc.metadataKey(name), b, rc.TTL); err != nil {
+		rc.Client.Del(ctx, storedChunkKeys...)
+		return err
+	}
+
+	if hasOldMetadata {
+		if oldChunkKeys := rc.chunkKeys(name, oldMetadata); len(oldChunkKeys) > 0 {
+			return rc.Client.Del(ctx, oldChunkKeys...)
+		}
+	}
+	return nil
+}
+
+// Delete deletes the cache for the name. It returns [fs.ErrNotExist] if not
+// found.
+func (rc *RedisCacher) Delete(ctx context.Context, name string) error {
+	metadata, err := rc.getMetadata(ctx, name)
+	if err != nil {
+		return err
+	}
+	return rc.Client.Del(ctx, append([]string{rc.metadataKey(name)}, rc.chunkKeys(name, metadata)...)...)
+}
+
+// redisCacherContent is the content returned by [RedisCacher.Get].
+type redisCacherContent struct {
+	ctx       context.Context
+	client    RedisClient
+	metadata  redisCacherMetadata
+	chunkKeys []string
+	offset    int64
+
+	chunkIndex int64
+	chunk      []byte
+}
+
+// Read implements [io.Reader].
+func (rcc *redisCacherContent) Read(p []byte) (int, error) {
+	if rcc.offset >= rcc.metadata.Size {
+		return 0, io.EOF
+	}
+	chunkIndex := rcc.offset / rcc.metadata.ChunkSize
+	if rcc.chunk == nil || rcc.chunkIndex != chunkIndex {
+		chunk, err := rcc.client.Get(rcc.ctx, rcc.chunkKeys[chunkIndex])
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return 0, io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		rcc.chunkIndex = chunkIndex
+		rcc.chunk = chunk
+	}
+	chunkOffset := rcc.offset - chunkIndex*rcc.metadata.ChunkSize
+	if chunkOffset >= int64(len(rcc.chunk)) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	n := copy(p, rcc.chunk[chunkOffset:])
+	rcc.offset += int64(n)
+	return n, nil
+}
+
+// Seek implements [io.Seeker].
+func (rcc *redisCacherContent) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += rcc.offset
+	case io.SeekEnd:
+		offset += rcc.metadata.Size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	rcc.offset = offset
+	return offset, nil
+}
+
+// Close implements [io.Closer].
+func (rcc *redisCacherContent) Close() error {
+	rcc.chunk = nil
+	return nil
+}
+
+// ModTime returns the time when the content was cached.
+func (rcc *redisCacherContent) ModTime() time.Time {
+	return rcc.metadata.ModTime
+}
+
+// TieredCacher implements [Cacher] by composing an ordered list of Cachers,
+// such as a fast local [DirCacher] in front of a large but slower shared one.
+// It is safe for concurrent use if all of its Tiers are.
+//
+// Get tries the Tiers in order, and a cache found in a later tier is promoted
+// into all of the earlier ones that miss it before being returned, so hot
+// caches end up in the fastest tier. Content that does not implement
+// [io.Seeker] is returned without being promoted. Note that a promoted cache
+// is put anew, so the earlier tiers report the time of the promotion as when
+// it was cached.
+//
+// Puts and promotions of the same name are made one at a time, and a promotion
+// never replaces a cache that an earlier tier already has, so a concurrent
+// promotion cannot overwrite what has just been put.
+type TieredCacher struct {
+	// Tiers is the ordered list of Cachers, fastest first.
+	Tiers []Cacher
+
+	// PutFirstTierOnly indicates whether Put writes only to the first of
+	// the Tiers, leaving the later ones to be filled by other means, such
+	// as other proxies sharing them. By default, Put writes through to all
+	// of the Tiers.
+	PutFirstTierOnly bool
+
+	nameMutex moduleMutex
+}
+
+// Get implements [Cacher].
+//
+// A tier failing with an error other than [fs.ErrNotExist] is skipped, so a
+// broken fast tier does not prevent serving from the later ones. The first
+// such error is returned only if none of the Tiers has the cache.
+func (tc *TieredCacher) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	var firstErr error
+	for i, tier := range tc.Tiers {
+		rc, err := tier.Get(ctx, name)
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) && firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if i > 0 {
+			if rs, ok := rc.(io.ReadSeeker); ok {
+				if err := tc.promote(ctx, name, rs, tc.Tiers[:i]); err != nil {
+					rc.Close()
+					return nil, err
+				}
+			}
+		}
+		return rc, nil
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return nil, fs.ErrNotExist
+}
+
+// promote puts the content of the cache for the name into each of the tiers
+// that misses it, and then rewinds the content. Failures of the tiers are
+// ignored, since the content is still served.
+func (tc *TieredCacher) promote(ctx context.Context, name string, content io.ReadSeeker, tiers []Cacher) error {
+	offset, err := content.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	unlock, err := tc.nameMutex.lock(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	for _, tier := range tiers {
+		if rc, err := tier.Get(ctx, name); err == nil {
+			rc.Close()
+			continue
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if _, err := content.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		tier.Put(ctx, name, content)
+	}
+	_, err = content.Seek(offset, io.SeekStart)
+	return err
+}
+
+// Put implements [Cacher].
+//
+// The content is put to each of the Tiers in order, or only to the first one if
+// PutFirstTierOnly is true. A tier failing does not fail the Put as long as
+// another one succeeds, so a full or unavailable tier does not fail requests.
+// If all of them fail, their errors are joined and returned.
+func (tc *TieredCacher) Put(ctx context.Context, name string, content io.ReadSeeker) error {
+	tiers := tc.Tiers
+	if tc.PutFirstTierOnly && len(tiers) > 1 {
+		tiers = tiers[:1]
+	}
+	offset, err := content.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	unlock, err := tc.nameMutex.lock(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	var errs []error
+	for _, tier := range tiers {
+		if _, err := content.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		if err := tier.Put(ctx, name, content); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 && len(errs) == len(tiers) {
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+// Delete deletes the cache for the name from all of the Tiers that implement
+// interface{ Delete(ctx context.Context, name string) error }. It returns
+// [fs.ErrNotExist] if none of them has it. It is used by
+// [Goproxy.AdminHandler].
+func (tc *TieredCacher) Delete(ctx context.Context, name string) error {
+	deleted := false
+	for _, tier := range tc.Tiers {
+		d, ok := tier.(interface {
+			Delete(ctx context.Context, name string) error
+		})
+		if !ok {
+			continue
+		}
+		if err := d.Delete(ctx, name); err == nil {
+			deleted = true
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	if !deleted {
+		return fs.ErrNotExist
+	}
+	return nil
+}
+
+// CheckHealth checks the health of the Tiers that implement
+// interface{ CheckHealth(context.Context) error }. Like Put, it fails only if
+// all of them are unhealthy. It is used by [Goproxy.ReadinessHandler].
+func (tc *TieredCacher) CheckHealth(ctx context.Context) error {
+	var errs []error
+	checked := 0
+	for _, tier := range tc.Tiers {
+		hc, ok := tier.(interface{ CheckHealth(context.Context) error })
+		if !ok {
+			continue
+		}
+		checked++
+		if err := hc.CheckHealth(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if checked > 0 && len(errs) == checked {
+		return errors.Join(errs...)
+	}
+	return nil
 }
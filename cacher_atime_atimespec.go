@@ -0,0 +1,23 @@
+//go:build darwin || freebsd || netbsd
+
+package goproxy
+
+import (
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+// fileAccessTime returns the later of the access and modification times of the
+// file described by the fi.
+func fileAccessTime(fi fs.FileInfo) time.Time {
+	modTime := fi.ModTime()
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return modTime
+	}
+	if accessTime := time.Unix(int64(st.Atimespec.Sec), int64(st.Atimespec.Nsec)); accessTime.After(modTime) {
+		return accessTime
+	}
+	return modTime
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !openbsd && !darwin && !freebsd && !netbsd
+
+package goproxy
+
+import (
+	"io/fs"
+	"time"
+)
+
+// fileAccessTime returns the modification time of the file described by the
+// fi, since access times are not available on this platform.
+func fileAccessTime(fi fs.FileInfo) time.Time {
+	return fi.ModTime()
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package goproxy
+
+import "io/fs"
+
+// fileIdentity reports false, since device and inode numbers are not available
+// on this platform.
+func fileIdentity(fi fs.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
@@ -0,0 +1,18 @@
+//go:build unix
+
+package goproxy
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// fileIdentity returns the device and inode numbers of the file described by
+// the fi, and reports whether they are available.
+func fileIdentity(fi fs.FileInfo) (fileID, bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, false
+	}
+	return fileID{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+}
@@ -1,14 +1,26 @@
 package goproxy
 
 import (
+	"archive/tar"
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/fstest"
+	"time"
+	"unicode"
+
+	"golang.org/x/mod/sumdb/dirhash"
 )
 
 type errorReadSeeker struct{}
@@ -21,6 +33,48 @@ func (errorReadSeeker) Seek(int64, int) (int64, error) {
 	return 0, errors.New("cannot seek")
 }
 
+type fakeRedisClient struct {
+	mutex  sync.Mutex
+	values map[string][]byte
+	ttls   map[string]time.Duration
+	setErr error
+}
+
+func newFakeRedisClient() *fakeRedisClient {
+	return &fakeRedisClient{values: map[string][]byte{}, ttls: map[string]time.Duration{}}
+}
+
+func (frc *fakeRedisClient) Get(ctx context.Context, key string) ([]byte, error) {
+	frc.mutex.Lock()
+	defer frc.mutex.Unlock()
+	value, ok := frc.values[key]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return value, nil
+}
+
+func (frc *fakeRedisClient) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	frc.mutex.Lock()
+	defer frc.mutex.Unlock()
+	if frc.setErr != nil {
+		return frc.setErr
+	}
+	frc.values[key] = append([]byte(nil), value...)
+	frc.ttls[key] = ttl
+	return nil
+}
+
+func (frc *fakeRedisClient) Del(ctx context.Context, keys ...string) error {
+	frc.mutex.Lock()
+	defer frc.mutex.Unlock()
+	for _, key := range keys {
+		delete(frc.values, key)
+		delete(frc.ttls, key)
+	}
+	return nil
+}
+
 func TestDirCacher(t *testing.T) {
 	dirCacher := DirCacher(t.TempDir())
 
@@ -66,8 +120,1728 @@ func TestDirCacher(t *testing.T) {
 		t.Errorf("got %q, want %q", got, want)
 	}
 
+	written := make(chan struct{})
+	unblock := make(chan struct{})
+	putErr := make(chan error, 1)
+	go func() {
+		putErr <- dirCacher.Put(context.Background(), "a/b/c", &midStreamErrorReadSeeker{
+			content: "foo",
+			written: written,
+			unblock: unblock,
+		})
+	}()
+	<-written
+	if rc, err := dirCacher.Get(context.Background(), "a/b/c"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if b, err := io.ReadAll(rc); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if err := rc.Close(); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := string(b), "foobar"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	close(unblock)
+	if err := <-putErr; err == nil {
+		t.Fatal("expected error")
+	} else if got, want := err.Error(), "connection reset"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if rc, err := dirCacher.Get(context.Background(), "a/b/c"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if b, err := io.ReadAll(rc); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if err := rc.Close(); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := string(b), "foobar"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if entries, err := os.ReadDir(filepath.Join(string(dirCacher), "a", "b")); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := len(entries), 1; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
 	dirCacher = DirCacher(filepath.Join(string(dirCacher), filepath.FromSlash("a/b/c")))
 	if err := dirCacher.Put(context.Background(), "d/e/f", strings.NewReader("foobar")); err == nil {
 		t.Fatal("expected error")
 	}
 }
+
+// midStreamErrorReadSeeker is an [io.ReadSeeker] that reads the content, then
+// blocks until the unblock is closed, and then fails.
+type midStreamErrorReadSeeker struct {
+	content string
+	written chan struct{}
+	unblock chan struct{}
+	read    bool
+}
+
+func (mserr *midStreamErrorReadSeeker) Read(p []byte) (int, error) {
+	if !mserr.read {
+		mserr.read = true
+		return copy(p, mserr.content), nil
+	}
+	close(mserr.written)
+	<-mserr.unblock
+	return 0, errors.New("connection reset")
+}
+
+func (mserr *midStreamErrorReadSeeker) Seek(int64, int) (int64, error) {
+	return 0, errors.New("cannot seek")
+}
+
+func TestDirCacherDeleteAndList(t *testing.T) {
+	dc := DirCacher(t.TempDir())
+	for _, name := range []string{"a/b/c", "a/b/d", "a/bc", "e", ".f"} {
+		if err := dc.Put(context.Background(), name, strings.NewReader(name)); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	if names, err := dc.List(context.Background(), "a/b"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := strings.Join(names, ","), "a/b/c,a/b/d,a/bc"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if err := dc.Delete(context.Background(), "a/b/c"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if err := dc.Delete(context.Background(), "a/b/c"); err == nil {
+		t.Fatal("expected error")
+	} else if got, want := err, fs.ErrNotExist; !errors.Is(got, want) && got.Error() != want.Error() {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if names, err := dc.List(context.Background(), ""); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := strings.Join(names, ","), "a/b/d,a/bc,e"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if names, err := dc.List(context.Background(), "a/b/"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := strings.Join(names, ","), "a/b/d"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	for _, prefix := range []string{"a/x/", ".f", "../a/b"} {
+		if names, err := dc.List(context.Background(), prefix); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		} else if len(names) != 0 {
+			t.Errorf("got %v, want empty", names)
+		}
+	}
+
+	if names, err := DirCacher(filepath.Join(t.TempDir(), "404")).List(context.Background(), ""); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if len(names) != 0 {
+		t.Errorf("got %v, want empty", names)
+	}
+}
+
+func TestDirCacherPutMultipleProcesses(t *testing.T) {
+	const (
+		dirEnv     = "GOPROXY_TEST_DIR_CACHER_PUT_DIR"
+		contentEnv = "GOPROXY_TEST_DIR_CACHER_PUT_CONTENT"
+		name       = "example.com/@v/v1.0.0.zip"
+		puts       = 50
+		size       = 1 << 20
+	)
+	if dir := os.Getenv(dirEnv); dir != "" {
+		content := bytes.Repeat([]byte(os.Getenv(contentEnv)), size)
+		for i := 0; i < puts; i++ {
+			if err := DirCacher(dir).Put(context.Background(), name, bytes.NewReader(content)); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+		os.Exit(0)
+	}
+
+	dir := t.TempDir()
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i, content := range []string{"a", "b"} {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestDirCacherPutMultipleProcesses$")
+		cmd.Env = append(os.Environ(), dirEnv+"="+dir, contentEnv+"="+content)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if out, err := cmd.CombinedOutput(); err != nil {
+				errs[i] = fmt.Errorf("%w: %s", err, out)
+			}
+		}(i)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	dc := DirCacher(dir)
+	for reading := true; reading; {
+		select {
+		case <-done:
+			reading = false
+		default:
+		}
+		rc, err := dc.Get(context.Background(), name)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			t.Fatalf("unexpected error %q", err)
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		if len(b) != size || (!bytes.Equal(b, bytes.Repeat([]byte("a"), size)) && !bytes.Equal(b, bytes.Repeat([]byte("b"), size))) {
+			t.Fatalf("got a corrupt cache of %d bytes", len(b))
+		}
+	}
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	entries, err := os.ReadDir(filepath.Join(dir, "example.com", "@v"))
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if got, want := strings.Join(names, ","), "v1.0.0.zip"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestLockDirCacherFileStale(t *testing.T) {
+	const lockers = 20
+	file := filepath.Join(t.TempDir(), "v1.0.0.zip")
+	lockFile := filepath.Join(filepath.Dir(file), ".v1.0.0.zip.lock")
+	if err := os.WriteFile(lockFile, []byte("killed"), 0o644); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	staleTime := time.Now().Add(-2 * dirCacherLockMaxAge)
+	if err := os.Chtimes(lockFile, staleTime, staleTime); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	var (
+		wg         sync.WaitGroup
+		holders    atomic.Int32
+		maxHolders atomic.Int32
+		errs       = make([]error, lockers)
+	)
+	start := make(chan struct{})
+	for i := 0; i < lockers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			unlock, err := lockDirCacherFile(context.Background(), file)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			if n := holders.Add(1); n > maxHolders.Load() {
+				maxHolders.Store(n)
+			}
+			time.Sleep(time.Millisecond)
+			holders.Add(-1)
+			unlock()
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", i, err)
+		}
+	}
+	if got, want := maxHolders.Load(), int32(1); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+	entries, err := os.ReadDir(filepath.Dir(file))
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if got, want := len(entries), 0; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	unlock, err := lockDirCacherFile(context.Background(), file)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if err := os.WriteFile(lockFile, []byte("other"), 0o644); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	unlock()
+	if b, err := os.ReadFile(lockFile); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := string(b), "other"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestDirCacherWalk(t *testing.T) {
+	c := DirCacher(t.TempDir())
+	for _, name := range []string{"a/b/d", "a/b/c", "a/bc", "e", ".f"} {
+		if err := c.Put(context.Background(), name, strings.NewReader(name)); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	var walked []string
+	if err := c.Walk(context.Background(), func(name string, size int64) error {
+		walked = append(walked, fmt.Sprintf("%s:%d", name, size))
+		return nil
+	}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if got, want := strings.Join(walked, ","), "a/b/c:5,a/b/d:5,a/bc:4,e:1"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	walked = nil
+	if err := c.Walk(context.Background(), func(name string, size int64) error {
+		walked = append(walked, name)
+		return errors.New("stop")
+	}); err == nil {
+		t.Fatal("expected error")
+	} else if got, want := err.Error(), "stop"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := strings.Join(walked, ","), "a/b/c"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if err := DirCacher(filepath.Join(t.TempDir(), "404")).Walk(context.Background(), func(string, int64) error {
+		t.Error("unexpected call")
+		return nil
+	}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+}
+
+func TestDirCacherCheckHealth(t *testing.T) {
+	dirCacher := DirCacher(filepath.Join(t.TempDir(), "caches"))
+	if err := dirCacher.CheckHealth(context.Background()); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if entries, err := os.ReadDir(string(dirCacher)); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := len(entries), 0; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if err := DirCacher(file).CheckHealth(context.Background()); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestDirCacherClean(t *testing.T) {
+	now := time.Now()
+	for _, tt := range []struct {
+		n         int
+		files     map[string]time.Duration // name -> age
+		maxAge    time.Duration
+		maxSize   int64
+		wantNames []string
+	}{
+		{
+			n: 1,
+			files: map[string]time.Duration{
+				"example.com/@v/v1.0.0.info":    3 * time.Hour,
+				"example.com/@v/v1.0.0.mod":     3 * time.Hour,
+				"example.com/@v/v1.0.0.zip":     3 * time.Hour,
+				"example.com/@v/v1.0.0.ziphash": 3 * time.Hour,
+				"example.com/@v/v1.1.0.info":    time.Hour,
+				"example.com/@v/list":           3 * time.Hour,
+			},
+			maxAge:    2 * time.Hour,
+			wantNames: []string{"example.com/@v/v1.1.0.info"},
+		},
+		{
+			n: 2,
+			files: map[string]time.Duration{
+				"example.com/@v/v1.0.0.info": 3 * time.Hour,
+				"example.com/@v/v1.0.0.zip":  time.Minute,
+				"example.com/@v/v1.1.0.info": 2 * time.Hour,
+				"example.com/@v/v1.1.0.mod":  2 * time.Hour,
+				"example.com/@v/v1.2.0.info": time.Hour,
+			},
+			maxSize: 12,
+			wantNames: []string{
+				"example.com/@v/v1.0.0.info",
+				"example.com/@v/v1.0.0.zip",
+				"example.com/@v/v1.2.0.info",
+			},
+		},
+		{
+			n: 3,
+			files: map[string]time.Duration{
+				"example.com/@v/v1.0.0.info":                     time.Hour,
+				"example.com/@v/.v1.0.0.zip.tmp.123":             time.Hour,
+				"example.com/@v/.v1.1.0.zip.tmp.123":             48 * time.Hour,
+				"example.com/@v/v1.1.0.zip.notfound":             48 * time.Hour,
+				"sumdb/sum.golang.org/lookup/example.com@v1.0.0": 48 * time.Hour,
+			},
+			maxAge: 24 * time.Hour,
+			wantNames: []string{
+				"example.com/@v/.v1.0.0.zip.tmp.123",
+				"example.com/@v/v1.0.0.info",
+			},
+		},
+		{
+			n: 4,
+			files: map[string]time.Duration{
+				"example.com/@v/v1.0.0.info": 48 * time.Hour,
+			},
+			wantNames: []string{"example.com/@v/v1.0.0.info"},
+		},
+	} {
+		dirCacher := DirCacher(t.TempDir())
+		for name, age := range tt.files {
+			file := filepath.Join(string(dirCacher), filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+				t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+			}
+			if err := os.WriteFile(file, []byte("foo"), 0o644); err != nil {
+				t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+			}
+			if err := os.Chtimes(file, now.Add(-age), now.Add(-age)); err != nil {
+				t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+			}
+		}
+		if err := dirCacher.Clean(context.Background(), tt.maxAge, tt.maxSize); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		}
+		var names []string
+		if err := filepath.WalkDir(string(dirCacher), func(file string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			name, err := filepath.Rel(string(dirCacher), file)
+			names = append(names, filepath.ToSlash(name))
+			return err
+		}); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		}
+		if got, want := strings.Join(names, "\n"), strings.Join(tt.wantNames, "\n"); got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+
+	if err := DirCacher(filepath.Join(t.TempDir(), "404")).Clean(context.Background(), time.Hour, 0); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	dirCacher := DirCacher(t.TempDir())
+	if err := dirCacher.Put(context.Background(), "a/b/c", strings.NewReader("foobar")); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if err := dirCacher.Clean(ctx, time.Hour, 0); err == nil {
+		t.Fatal("expected error")
+	} else if got, want := err, context.Canceled; !errors.Is(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestDirCacherCleanNotFound(t *testing.T) {
+	now := time.Now()
+	dirCacher := DirCacher(t.TempDir())
+	for name, age := range map[string]time.Duration{
+		"example.com/@v/v1.0.0.info":          2 * time.Hour,
+		"example.com/@v/v1.1.0.info.notfound": 2 * time.Hour,
+		"example.com/@v/v1.2.0.mod.notfound":  time.Second,
+		"example.com/@latest.notfound":        2 * time.Hour,
+	} {
+		if err := dirCacher.Put(context.Background(), name, strings.NewReader("foo")); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		if err := os.Chtimes(filepath.Join(string(dirCacher), filepath.FromSlash(name)), now.Add(-age), now.Add(-age)); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	result, err := dirCacher.CleanNotFound(context.Background(), time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if got, want := result, (DirCacherCleanResult{EvictedEntries: 2, EvictedBytes: 6, Size: 3}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	names, err := dirCacher.List(context.Background(), "")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if got, want := strings.Join(names, "\n"), "example.com/@v/v1.0.0.info\nexample.com/@v/v1.2.0.mod.notfound"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	result, err = dirCacher.CleanNotFound(context.Background(), 0)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if got, want := result, (DirCacherCleanResult{EvictedEntries: 1, EvictedBytes: 3}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	names, err = dirCacher.List(context.Background(), "")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if got, want := strings.Join(names, "\n"), "example.com/@v/v1.0.0.info"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestDirCacherCleanWatermarks(t *testing.T) {
+	now := time.Now()
+	dirCacher := DirCacher(t.TempDir())
+	for i, name := range []string{
+		"example.com/@v/v1.0.0.info",
+		"example.com/@v/v1.1.0.info",
+		"example.com/@v/v1.2.0.info",
+		"example.com/@v/v1.3.0.info",
+		"example.com/@v/v1.4.0.info",
+	} {
+		if err := dirCacher.Put(context.Background(), name, strings.NewReader("foo")); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		accessTime := now.Add(-time.Duration(i+1) * time.Hour)
+		if name == "example.com/@v/v1.4.0.info" {
+			accessTime = now
+		}
+		if err := os.Chtimes(filepath.Join(string(dirCacher), filepath.FromSlash(name)), accessTime, now.Add(-10*time.Hour)); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	result, err := dirCacher.CleanWatermarks(context.Background(), 0, 15, 9)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if got, want := result, (DirCacherCleanResult{Size: 15}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	rc, err := dirCacher.Get(context.Background(), "example.com/@v/v1.3.0.info")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	result, err = dirCacher.CleanWatermarks(context.Background(), 0, 12, 6)
+	rc.Close()
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if got, want := result, (DirCacherCleanResult{EvictedEntries: 3, EvictedBytes: 9, Size: 6}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	names, err := dirCacher.List(context.Background(), "")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if got, want := strings.Join(names, "\n"), "example.com/@v/v1.3.0.info\nexample.com/@v/v1.4.0.info"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := len(dirCacherOpenFiles.counts); got != 0 {
+		t.Errorf("got %d, want 0", got)
+	}
+}
+
+func TestDirCacherVerify(t *testing.T) {
+	dir := t.TempDir()
+	c := DirCacher(dir)
+	put := func(name, content string) {
+		if err := c.Put(context.Background(), name, strings.NewReader(content)); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+	putZip := func(name string, files map[string][]byte) string {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		if err := writeZipFile(file, files); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		zipHash, err := dirhash.HashZip(file, dirhash.DefaultHash)
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		return zipHash
+	}
+
+	put("example.com/@v/v1.0.0.info", `{"Version":"v1.0.0"}`)
+	put("example.com/@v/v1.0.0.mod", "module example.com\n")
+	put("example.com/@v/v1.0.0.ziphash", putZip("example.com/@v/v1.0.0.zip", map[string][]byte{
+		"example.com@v1.0.0/go.mod": []byte("module example.com\n"),
+	}))
+	put("example.com/@v/v1.1.0.info", `{"Version":"v1.0.0"}`)
+	put("example.com/@v/v1.2.0.mod", "module example.com\n")
+	putZip("example.com/@v/v1.2.0.zip", map[string][]byte{
+		"example.com@v1.2.0/go.mod": []byte("module example.com/foobar\n"),
+	})
+	put("example.com/@v/v1.3.0.ziphash", "h1:foobar")
+	putZip("example.com/@v/v1.3.0.zip", map[string][]byte{"example.com@v1.3.0/foo.go": []byte("package foo")})
+	put("example.com/@v/v1.4.0.zip", "foobar")
+	put("example.com/@v/v1.5.0.mod", `module "example.com`)
+	put("example.com/!foo/@v/v1.0.0.info", `{"Version":"v1.0.0"}`)
+	put("example.com/@v/list", "v1.0.0")
+	put("example.com/@latest", `{"Version":"v1.0.0"}`)
+
+	wantErrs := map[string]string{
+		"example.com/!foo/@v/v1.0.0": "",
+		"example.com/@v/v1.0.0":      "",
+		"example.com/@v/v1.1.0":      `info file has version "v1.0.0", want "v1.1.0"`,
+		"example.com/@v/v1.2.0":      "mod file does not match go.mod in zip file",
+		"example.com/@v/v1.3.0":      `zip file has hash "`,
+		"example.com/@v/v1.4.0":      "invalid zip file: ",
+		"example.com/@v/v1.5.0":      "invalid mod file: ",
+	}
+	for _, deleteCorrupt := range []bool{false, true} {
+		results := map[string]DirCacherVerifyResult{}
+		if err := c.Verify(context.Background(), 2, deleteCorrupt, func(result DirCacherVerifyResult) {
+			results[result.Name] = result
+		}); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		if got, want := len(results), len(wantErrs); got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+		for name, wantErr := range wantErrs {
+			result, ok := results[name]
+			if !ok {
+				t.Errorf("missing result for %q", name)
+				continue
+			}
+			if wantErr == "" {
+				if result.Err != nil {
+					t.Errorf("%s: unexpected error %q", name, result.Err)
+				}
+			} else if result.Err == nil {
+				t.Errorf("%s: expected error", name)
+			} else if got := result.Err.Error(); !strings.HasPrefix(got, wantErr) {
+				t.Errorf("%s: got %q, want it to start with %q", name, got, wantErr)
+			}
+			if got, want := result.Deleted, deleteCorrupt && wantErr != ""; got != want {
+				t.Errorf("%s: got %t, want %t", name, got, want)
+			}
+		}
+	}
+
+	names, err := c.List(context.Background(), "")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if got, want := strings.Join(names, ","), strings.Join([]string{
+		"example.com/!foo/@v/v1.0.0.info",
+		"example.com/@latest",
+		"example.com/@v/list",
+		"example.com/@v/v1.0.0.info",
+		"example.com/@v/v1.0.0.mod",
+		"example.com/@v/v1.0.0.zip",
+		"example.com/@v/v1.0.0.ziphash",
+	}, ","); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.Verify(ctx, 1, false, func(DirCacherVerifyResult) {}); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestDirCacherExportImport(t *testing.T) {
+	srcDir := t.TempDir()
+	src := DirCacher(srcDir)
+	put := func(name, content string) {
+		if err := src.Put(context.Background(), name, strings.NewReader(content)); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+	zipFile := filepath.Join(srcDir, "example.com", "@v", "v1.0.0.zip")
+	if err := os.MkdirAll(filepath.Dir(zipFile), 0o755); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if err := writeZipFile(zipFile, map[string][]byte{"example.com@v1.0.0/go.mod": []byte("module example.com\n")}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	zipHash, err := dirhash.HashZip(zipFile, dirhash.DefaultHash)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	put("example.com/@v/v1.0.0.info", `{"Version":"v1.0.0"}`)
+	put("example.com/@v/v1.0.0.mod", "module example.com\n")
+	put("example.com/@v/v1.0.0.ziphash", zipHash)
+	put("example.com/@v/v1.1.0.info", `{"Version":"v1.0.0"}`)
+	put("example.com/@v/list", "v1.0.0\nv1.1.0\n")
+	put("example.org/@v/v1.0.0.mod", "module example.org\n")
+	put("sumdb/sum.golang.org/supported", "")
+	modTime := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(srcDir, "example.com", "@v", "list"), modTime, modTime); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	var archive bytes.Buffer
+	if err := src.Export(context.Background(), &archive, nil); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	var archive2 bytes.Buffer
+	if err := src.Export(context.Background(), &archive2, nil); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if !bytes.Equal(archive.Bytes(), archive2.Bytes()) {
+		t.Error("expected exports of the same caches to be identical")
+	}
+
+	dst := DirCacher(t.TempDir())
+	results := map[string]error{}
+	if err := dst.Import(context.Background(), bytes.NewReader(archive.Bytes()), func(result DirCacherVerifyResult) {
+		results[result.Name] = result.Err
+	}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if got, want := len(results), 3; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+	for name, wantErr := range map[string]bool{
+		"example.com/@v/v1.0.0": false,
+		"example.com/@v/v1.1.0": true,
+		"example.org/@v/v1.0.0": false,
+	} {
+		if got, want := results[name] != nil, wantErr; got != want {
+			t.Errorf("%s: got %t, want %t", name, got, want)
+		}
+	}
+	for _, tt := range []struct {
+		n          int
+		name       string
+		wantExists bool
+	}{
+		{1, "example.com/@v/v1.0.0.info", true},
+		{2, "example.com/@v/v1.0.0.mod", true},
+		{3, "example.com/@v/v1.0.0.zip", true},
+		{4, "example.com/@v/v1.0.0.ziphash", true},
+		{5, "example.com/@v/v1.1.0.info", false},
+		{6, "example.com/@v/list", true},
+		{7, "example.org/@v/v1.0.0.mod", true},
+		{8, "sumdb/sum.golang.org/supported", true},
+	} {
+		rc, err := dst.Get(context.Background(), tt.name)
+		if !tt.wantExists {
+			if !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("test(%d): got error %v, want fs.ErrNotExist", tt.n, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		}
+		got, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		}
+		want, err := os.ReadFile(filepath.Join(srcDir, filepath.FromSlash(tt.name)))
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+	if fi, err := os.Stat(filepath.Join(string(dst), "example.com", "@v", "list")); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := fi.ModTime(), modTime; !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if entries, err := os.ReadDir(string(dst)); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else {
+		for _, entry := range entries {
+			if strings.HasPrefix(entry.Name(), ".import.tmp.") {
+				t.Errorf("unexpected staging directory %q", entry.Name())
+			}
+		}
+	}
+
+	archive.Reset()
+	if err := src.Export(context.Background(), &archive, func(name string) bool {
+		return strings.HasPrefix(name, "example.org/")
+	}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	var names []string
+	tr := tar.NewReader(&archive)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		names = append(names, hdr.Name)
+	}
+	if got, want := strings.Join(names, ","), "example.org/@v/v1.0.0.mod"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	archive.Reset()
+	tw := tar.NewWriter(&archive)
+	for _, name := range []string{"../evil", ".blobs/evil", "example.net/.evil", "example.net/@v/list"} {
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Size: 4, Mode: 0o644}); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		if _, err := tw.Write([]byte("evil")); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	dstDir := t.TempDir()
+	dst = DirCacher(filepath.Join(dstDir, "caches"))
+	if err := dst.Import(context.Background(), &archive, nil); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	var imported []string
+	if err := filepath.WalkDir(dstDir, func(file string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			rel, _ := filepath.Rel(dstDir, file)
+			imported = append(imported, filepath.ToSlash(rel))
+		}
+		return err
+	}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if got, want := strings.Join(imported, ","), "caches/example.net/@v/list"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestDirCacherFile(t *testing.T) {
+	for _, tt := range []struct {
+		n        int
+		name     string
+		wantFile string
+	}{
+		{1, "example.com/@v/list", filepath.Join("cache", "example.com", "@v", "list")},
+		{2, "sumdb/sum.golang.org/latest", filepath.Join("cache", "sumdb", "sum.golang.org", "latest")},
+		{3, "", ""},
+		{4, ".", ""},
+		{5, "..", ""},
+		{6, "../example.com/@v/list", ""},
+		{7, "example.com/../../@v/list", ""},
+		{8, "/example.com/@v/list", ""},
+		{9, "example.com//@v/list", ""},
+		{10, "example.com/@v/", ""},
+		{11, `example.com\..\..\@v\list`, ""},
+		{12, "example.com/@v/v1.0.0\x00.info", ""},
+		{13, "example.com/@v/v1.0.0\n.info", ""},
+	} {
+		file, err := DirCacher("cache").file("open", tt.name)
+		if tt.wantFile == "" {
+			if err == nil {
+				t.Fatalf("test(%d): expected error", tt.n)
+			}
+			if got, want := err, fs.ErrNotExist; !errors.Is(got, want) {
+				t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		}
+		if got, want := file, tt.wantFile; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+
+	dirCacher := DirCacher(t.TempDir())
+	if err := dirCacher.Put(context.Background(), "../foobar", strings.NewReader("foobar")); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := os.Stat(filepath.Join(string(dirCacher), "..", "foobar")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got %v, want %v", err, fs.ErrNotExist)
+	}
+	if _, err := dirCacher.Get(context.Background(), "../foobar"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got %v, want %v", err, fs.ErrNotExist)
+	}
+	if err := dirCacher.Delete(context.Background(), "../foobar"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got %v, want %v", err, fs.ErrNotExist)
+	}
+}
+
+func FuzzDirCacherFile(f *testing.F) {
+	for _, name := range []string{
+		"example.com/@v/list",
+		"..",
+		"../foobar",
+		"/foobar",
+		"foo/../../bar",
+		`foo\..\..\bar`,
+		"C:/foobar",
+		"foo\x00bar",
+	} {
+		f.Add(name)
+	}
+	root := filepath.Join("testdata", "cache")
+	f.Fuzz(func(t *testing.T, name string) {
+		file, err := DirCacher(root).file("open", name)
+		if err != nil {
+			return
+		}
+		if strings.ContainsFunc(file, unicode.IsControl) {
+			t.Errorf("control character in %q", file)
+		}
+		if rel, err := filepath.Rel(root, file); err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.IsAbs(rel) {
+			t.Errorf("cache file %q of %q is outside %q", file, name, root)
+		}
+	})
+}
+
+func TestDedupDirCacher(t *testing.T) {
+	ddc := DedupDirCacher{DirCacher: DirCacher(t.TempDir())}
+	for name, content := range map[string]string{
+		"example.com/@v/v1.0.0.zip": "foo",
+		"example.com/@v/v1.1.0.zip": "foo",
+		"example.com/@v/v1.2.0.zip": "bar",
+		"example.com/@v/v1.0.0.mod": "foo",
+	} {
+		if err := ddc.Put(context.Background(), name, strings.NewReader(content)); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	stat := func(name string) os.FileInfo {
+		fi, err := os.Stat(filepath.Join(string(ddc.DirCacher), filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		return fi
+	}
+	if !os.SameFile(stat("example.com/@v/v1.0.0.zip"), stat("example.com/@v/v1.1.0.zip")) {
+		t.Error("expected identical zip files to be the same file")
+	}
+	if os.SameFile(stat("example.com/@v/v1.0.0.zip"), stat("example.com/@v/v1.2.0.zip")) {
+		t.Error("expected different zip files not to be the same file")
+	}
+	if os.SameFile(stat("example.com/@v/v1.0.0.zip"), stat("example.com/@v/v1.0.0.mod")) {
+		t.Error("expected mod file not to be deduplicated")
+	}
+
+	rc, err := ddc.Get(context.Background(), "example.com/@v/v1.1.0.zip")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	b, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := string(b), "foo"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	names, err := ddc.List(context.Background(), "")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if got, want := strings.Join(names, ","), "example.com/@v/v1.0.0.mod,example.com/@v/v1.0.0.zip,example.com/@v/v1.1.0.zip,example.com/@v/v1.2.0.zip"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	blobs := func() []string {
+		var blobs []string
+		if err := filepath.WalkDir(filepath.Join(string(ddc.DirCacher), dirCacherBlobsDir), func(file string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			blobs = append(blobs, d.Name())
+			return nil
+		}); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		return blobs
+	}
+	if got, want := len(blobs()), 2; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	if err := ddc.Delete(context.Background(), "example.com/@v/v1.2.0.zip"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if err := ddc.Delete(context.Background(), "example.com/@v/v1.0.0.zip"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if err := ddc.Clean(context.Background(), 0, 0); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if got, want := len(blobs()), 2; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	for _, blob := range blobs() {
+		file := filepath.Join(string(ddc.DirCacher), dirCacherBlobsDir, blob[:2], blob)
+		if err := os.Chtimes(file, old, old); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+	if err := ddc.Clean(context.Background(), 0, 0); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if got, want := len(blobs()), 1; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+	if _, err := ddc.Get(context.Background(), "example.com/@v/v1.1.0.zip"); err != nil {
+		t.Errorf("unexpected error %q", err)
+	}
+
+	if err := ddc.Put(context.Background(), "example.com/@v/v1.3.0.zip", errorReadSeeker{}); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestDedupDirCacherCleanWatermarks(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skip("file identities are not available on this platform")
+	}
+
+	ddc := DedupDirCacher{DirCacher: DirCacher(t.TempDir())}
+	for _, name := range []string{
+		"example.com/@v/v1.0.0.zip",
+		"example.com/@v/v1.1.0.zip",
+		"example.com/@v/v1.2.0.zip",
+	} {
+		if err := ddc.Put(context.Background(), name, strings.NewReader("foo")); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+	accessTime := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(string(ddc.DirCacher), "example.com", "@v", "v1.0.0.zip"), accessTime, accessTime); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if err := ddc.Put(context.Background(), "example.com/@v/v2.0.0.mod", strings.NewReader("bar")); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	result, err := ddc.CleanWatermarks(context.Background(), 0, 6, 0)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if got, want := result, (DirCacherCleanResult{Size: 6}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	result, err = ddc.CleanWatermarks(context.Background(), 0, 5, 3)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if got, want := result, (DirCacherCleanResult{EvictedEntries: 3, EvictedBytes: 3, Size: 3}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	names, err := ddc.List(context.Background(), "")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if got, want := strings.Join(names, ","), "example.com/@v/v2.0.0.mod"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCompressedDirCacher(t *testing.T) {
+	cdc := CompressedDirCacher{DirCacher: DirCacher(t.TempDir()), Compression: "gzip"}
+	if err := cdc.DirCacher.Put(context.Background(), "example.com/@v/v1.0.0.info", strings.NewReader(`{"Version":"v1.0.0"}`)); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	for name, content := range map[string]string{
+		"example.com/@v/v1.1.0.info": `{"Version":"v1.1.0"}`,
+		"example.com/@v/v1.1.0.mod":  "module example.com",
+		"example.com/@v/v1.2.0.zip":  "foobar",
+		"example.com/@v/list":        "v1.0.0\nv1.1.0",
+	} {
+		if err := cdc.Put(context.Background(), name, strings.NewReader(content)); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	for _, tt := range []struct {
+		n              int
+		name           string
+		wantContent    string
+		wantCompressed bool
+	}{
+		{1, "example.com/@v/v1.0.0.info", `{"Version":"v1.0.0"}`, false},
+		{2, "example.com/@v/v1.1.0.info", `{"Version":"v1.1.0"}`, true},
+		{3, "example.com/@v/v1.1.0.mod", "module example.com", true},
+		{4, "example.com/@v/v1.2.0.zip", "foobar", false},
+		{5, "example.com/@v/list", "v1.0.0\nv1.1.0", false},
+	} {
+		b, err := os.ReadFile(filepath.Join(string(cdc.DirCacher), filepath.FromSlash(tt.name)))
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		}
+		if got, want := bytes.HasPrefix(b, gzipMagic), tt.wantCompressed; got != want {
+			t.Errorf("test(%d): got %t, want %t", tt.n, got, want)
+		}
+
+		rc, err := cdc.Get(context.Background(), tt.name)
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		}
+		if b, err = io.ReadAll(rc); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		} else if got, want := string(b), tt.wantContent; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		if got, want := rc.(interface{ Size() int64 }).Size(), int64(len(tt.wantContent)); got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if _, ok := rc.(io.Seeker); !ok {
+			t.Errorf("test(%d): expected io.Seeker", tt.n)
+		}
+		if _, ok := rc.(interface{ ModTime() time.Time }); !ok {
+			t.Errorf("test(%d): expected ModTime", tt.n)
+		}
+		rc.Close()
+
+		fsrc, err := (FSCacher{FS: os.DirFS(string(cdc.DirCacher))}).Get(context.Background(), tt.name)
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		}
+		if b, err = io.ReadAll(fsrc); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		} else if got, want := string(b), tt.wantContent; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		fsrc.Close()
+	}
+
+	if err := cdc.Verify(context.Background(), 1, false, func(result DirCacherVerifyResult) {
+		if got, want := result.Err != nil, result.Name == "example.com/@v/v1.2.0"; got != want {
+			t.Errorf("%s: got %t, want %t", result.Name, got, want)
+		}
+	}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	cdc.Compression = "foobar"
+	if err := cdc.Put(context.Background(), "example.com/@v/v1.2.0.mod", strings.NewReader("module example.com")); err == nil {
+		t.Fatal("expected error")
+	} else if got, want := err.Error(), `unsupported compression "foobar"`; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+type nonSeekableFS struct{ fs.FS }
+
+func (nsfs nonSeekableFS) Open(name string) (fs.File, error) {
+	f, err := nsfs.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return struct{ fs.File }{f}, nil
+}
+
+func TestFSCacher(t *testing.T) {
+	mapFS := fstest.MapFS{
+		"example.com/@v/list":       {Data: []byte("v1.0.0")},
+		"example.com/@v/v1.0.0.mod": {Data: []byte("module example.com")},
+	}
+	for _, tt := range []struct {
+		n           int
+		fsys        fs.FS
+		name        string
+		wantContent string
+		wantError   error
+	}{
+		{1, mapFS, "example.com/@v/list", "v1.0.0", nil},
+		{2, mapFS, "example.com/@v/v1.0.0.mod", "module example.com", nil},
+		{3, nonSeekableFS{mapFS}, "example.com/@v/v1.0.0.mod", "module example.com", nil},
+		{4, mapFS, "example.com/@v/v1.0.0.zip", "", fs.ErrNotExist},
+		{5, mapFS, "example.com/@v", "", fs.ErrNotExist},
+		{6, mapFS, "../example.com/@v/list", "", fs.ErrNotExist},
+	} {
+		fc := FSCacher{FS: tt.fsys}
+		rc, err := fc.Get(context.Background(), tt.name)
+		if tt.wantError != nil {
+			if err == nil {
+				t.Fatalf("test(%d): expected error", tt.n)
+			}
+			if got, want := err, tt.wantError; !errors.Is(got, want) {
+				t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		}
+		if _, err := rc.(io.Seeker).Seek(1, io.SeekStart); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		} else if got, want := string(b), tt.wantContent[1:]; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		if got, want := rc.(interface{ Size() int64 }).Size(), int64(len(tt.wantContent)); got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+	}
+
+	if err := (FSCacher{FS: mapFS}).Put(context.Background(), "example.com/@v/list", strings.NewReader("v1.1.0")); err == nil {
+		t.Fatal("expected error")
+	} else if got, want := err, fs.ErrPermission; !errors.Is(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestModCacheCacher(t *testing.T) {
+	dir := t.TempDir()
+	downloadDir := filepath.Join(dir, "cache", "download")
+	for name, content := range map[string]string{
+		"example.com/!foo/@v/v1.0.0.info":         `{"Version":"v1.0.0"}`,
+		"example.com/!foo/@v/v1.0.0.mod":          "module example.com/Foo",
+		"example.com/!foo/@v/v1.0.0.zip":          "zip",
+		"example.com/!foo/@v/v1.0.0.ziphash":      "h1:hash",
+		"example.com/!foo/@v/v1.0.0.lock":         "",
+		"example.com/!foo/@v/v1.1.0.zip.partial":  "partial",
+		"example.com/!foo/@v/list":                "v1.0.0",
+		"sumdb/sum.golang.org/tile/8/0/000":       "tile",
+		"sumdb/sum.golang.org/lookup/example.com": "lookup",
+	} {
+		file := filepath.Join(downloadDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0o444); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+	mcc := ModCacheCacher(dir)
+	for _, tt := range []struct {
+		n           int
+		name        string
+		wantContent string
+		wantError   error
+	}{
+		{1, "example.com/!foo/@v/v1.0.0.info", `{"Version":"v1.0.0"}`, nil},
+		{2, "example.com/!foo/@v/v1.0.0.mod", "module example.com/Foo", nil},
+		{3, "example.com/!foo/@v/v1.0.0.zip", "zip", nil},
+		{4, "example.com/!foo/@v/v1.0.0.ziphash", "h1:hash", nil},
+		{5, "example.com/!foo/@v/v1.0.0.lock", "", fs.ErrNotExist},
+		{6, "example.com/!foo/@v/v1.1.0.zip.partial", "", fs.ErrNotExist},
+		{7, "example.com/!foo/@v/list", "", fs.ErrNotExist},
+		{8, "example.com/!foo/@latest", "", fs.ErrNotExist},
+		{9, "sumdb/sum.golang.org/tile/8/0/000", "tile", nil},
+		{10, "sumdb/sum.golang.org/lookup/example.com", "", fs.ErrNotExist},
+		{11, "example.com/!foo/@v/v1.2.0.info", "", fs.ErrNotExist},
+	} {
+		rc, err := mcc.Get(context.Background(), tt.name)
+		if tt.wantError != nil {
+			if err == nil {
+				t.Fatalf("test(%d): expected error", tt.n)
+			}
+			if got, want := err, tt.wantError; !errors.Is(got, want) {
+				t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		} else if got, want := string(b), tt.wantContent; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+
+	if err := mcc.Put(context.Background(), "example.com/!foo/@v/v1.2.0.info", strings.NewReader("")); err == nil {
+		t.Fatal("expected error")
+	} else if got, want := err, errReadOnlyCacher; !errors.Is(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestMemoryCacher(t *testing.T) {
+	mc := &MemoryCacher{MaxSize: 10}
+
+	if rc, err := mc.Get(context.Background(), "a"); err == nil {
+		t.Fatal("expected error")
+	} else if got, want := err, fs.ErrNotExist; !errors.Is(got, want) && got.Error() != want.Error() {
+		t.Fatalf("got %q, want %q", got, want)
+	} else if rc != nil {
+		t.Errorf("got %v, want nil", rc)
+	}
+
+	for _, tt := range []struct {
+		n         int
+		name      string
+		content   string
+		wantLen   int
+		wantSize  int64
+		wantNames []string
+	}{
+		{1, "a", "foo", 1, 3, []string{"a"}},
+		{2, "b", "bar", 2, 6, []string{"a", "b"}},
+		{3, "a", "foobar", 2, 9, []string{"b", "a"}},
+		{4, "c", "baz", 2, 9, []string{"a", "c"}},
+		{5, "d", "foobarbaz", 1, 9, []string{"d"}},
+		{6, "d", "foobarbazqux", 0, 0, nil},
+	} {
+		if err := mc.Put(context.Background(), tt.name, strings.NewReader(tt.content)); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		}
+		if got, want := mc.Len(), tt.wantLen; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if got, want := mc.Size(), tt.wantSize; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		for _, name := range tt.wantNames {
+			if _, err := mc.Get(context.Background(), name); err != nil {
+				t.Errorf("test(%d): unexpected error %q", tt.n, err)
+			}
+		}
+	}
+
+	mc = &MemoryCacher{}
+	if err := mc.Put(context.Background(), "a/b/c", strings.NewReader("foobar")); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if rc, err := mc.Get(context.Background(), "a/b/c"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if _, ok := rc.(io.Seeker); !ok {
+		t.Error("want io.Seeker")
+	} else if _, ok := rc.(interface{ ModTime() time.Time }); !ok {
+		t.Error("want ModTime")
+	} else if b, err := io.ReadAll(rc); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if err := rc.Close(); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := string(b), "foobar"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := mc.Put(context.Background(), "a/b/c", strings.NewReader("foobar")); err != nil {
+				t.Errorf("unexpected error %q", err)
+			}
+			if rc, err := mc.Get(context.Background(), "a/b/c"); err != nil {
+				t.Errorf("unexpected error %q", err)
+			} else if b, err := io.ReadAll(rc); err != nil {
+				t.Errorf("unexpected error %q", err)
+			} else if got, want := string(b), "foobar"; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := mc.Put(context.Background(), "d/e/f", &errorReadSeeker{}); err == nil {
+		t.Fatal("expected error")
+	} else if got, want := err.Error(), "cannot seek"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestMemoryCacherDeleteAndList(t *testing.T) {
+	mc := &MemoryCacher{}
+	for _, name := range []string{"a/b/d", "a/b/c", "a/bc", "e"} {
+		if err := mc.Put(context.Background(), name, strings.NewReader(name)); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	if names, err := mc.List(context.Background(), "a/b"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := strings.Join(names, ","), "a/b/c,a/b/d,a/bc"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if err := mc.Delete(context.Background(), "a/b/c"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if err := mc.Delete(context.Background(), "a/b/c"); err == nil {
+		t.Fatal("expected error")
+	} else if got, want := err, fs.ErrNotExist; !errors.Is(got, want) && got.Error() != want.Error() {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := mc.Len(), 3; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+	if got, want := mc.Size(), int64(len("a/b/d")+len("a/bc")+len("e")); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
+
+func TestMemoryCacherNow(t *testing.T) {
+	now := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	mc := &MemoryCacher{Now: func() time.Time { return now }}
+	if err := mc.Put(context.Background(), "a", strings.NewReader("a")); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	rc, err := mc.Get(context.Background(), "a")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer rc.Close()
+	if got, want := cachedAt(rc), now; !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestMemoryCacherWalk(t *testing.T) {
+	c := &MemoryCacher{}
+	for _, name := range []string{"a/b/d", "a/b/c", "a/bc", "e"} {
+		if err := c.Put(context.Background(), name, strings.NewReader(name)); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	var walked []string
+	if err := c.Walk(context.Background(), func(name string, size int64) error {
+		walked = append(walked, fmt.Sprintf("%s:%d", name, size))
+		return nil
+	}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if got, want := strings.Join(walked, ","), "a/b/c:5,a/b/d:5,a/bc:4,e:1"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	walked = nil
+	if err := c.Walk(context.Background(), func(name string, size int64) error {
+		walked = append(walked, name)
+		return errors.New("stop")
+	}); err == nil {
+		t.Fatal("expected error")
+	} else if got, want := err.Error(), "stop"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := strings.Join(walked, ","), "a/b/c"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestTieredCacher(t *testing.T) {
+	fast := &MemoryCacher{}
+	slow := DirCacher(t.TempDir())
+	tc := &TieredCacher{Tiers: []Cacher{fast, slow}}
+
+	if err := slow.Put(context.Background(), "a", strings.NewReader("foo")); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	rc, err := tc.Get(context.Background(), "a")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if b, err := io.ReadAll(rc); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := string(b), "foo"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	rc.Close()
+	if got, want := fast.Len(), 1; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	if err := tc.Put(context.Background(), "b", strings.NewReader("bar")); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	for _, tier := range tc.Tiers {
+		if rc, err := tier.Get(context.Background(), "b"); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		} else if b, err := io.ReadAll(rc); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		} else if got, want := string(b), "bar"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		} else {
+			rc.Close()
+		}
+	}
+
+	if _, err := tc.Get(context.Background(), "c"); err == nil {
+		t.Fatal("expected error")
+	} else if got, want := err, fs.ErrNotExist; !errors.Is(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if err := tc.Delete(context.Background(), "b"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	for _, tier := range tc.Tiers {
+		if _, err := tier.Get(context.Background(), "b"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("got %v, want %v", err, fs.ErrNotExist)
+		}
+	}
+	if err := tc.Delete(context.Background(), "b"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got %v, want %v", err, fs.ErrNotExist)
+	}
+}
+
+func TestTieredCacherPutFirstTierOnly(t *testing.T) {
+	fast := &MemoryCacher{}
+	slow := &MemoryCacher{}
+	tc := &TieredCacher{Tiers: []Cacher{fast, slow}, PutFirstTierOnly: true}
+	if err := tc.Put(context.Background(), "a", strings.NewReader("foo")); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if got, want := fast.Len(), 1; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+	if got, want := slow.Len(), 0; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
+
+func TestTieredCacherFailingTier(t *testing.T) {
+	readOnly := FSCacher{FS: fstest.MapFS{}}
+	mc := &MemoryCacher{}
+	tc := &TieredCacher{Tiers: []Cacher{readOnly, mc}}
+	if err := tc.Put(context.Background(), "a", strings.NewReader("foo")); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if got, want := mc.Len(), 1; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+	if rc, err := tc.Get(context.Background(), "a"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if b, err := io.ReadAll(rc); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := string(b), "foo"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	tc = &TieredCacher{Tiers: []Cacher{readOnly, readOnly}}
+	if err := tc.Put(context.Background(), "a", strings.NewReader("foo")); err == nil {
+		t.Fatal("expected error")
+	} else if got, want := err, errReadOnlyCacher; !errors.Is(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestTieredCacherCheckHealth(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	broken := DirCacher(filepath.Join(file, "caches"))
+	tc := &TieredCacher{Tiers: []Cacher{broken, DirCacher(t.TempDir())}}
+	if err := tc.CheckHealth(context.Background()); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	tc = &TieredCacher{Tiers: []Cacher{broken, &MemoryCacher{}}}
+	if err := tc.CheckHealth(context.Background()); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestTieredCacherConcurrentPromotions(t *testing.T) {
+	fast := DirCacher(t.TempDir())
+	slow := &MemoryCacher{}
+	content := strings.Repeat("foobar", 1<<16)
+	if err := slow.Put(context.Background(), "a", strings.NewReader(content)); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	tc := &TieredCacher{Tiers: []Cacher{fast, slow}}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rc, err := tc.Get(context.Background(), "a")
+			if err != nil {
+				t.Errorf("unexpected error %q", err)
+				return
+			}
+			defer rc.Close()
+			if b, err := io.ReadAll(rc); err != nil {
+				t.Errorf("unexpected error %q", err)
+			} else if len(b) != len(content) {
+				t.Errorf("got %d bytes, want %d", len(b), len(content))
+			}
+		}()
+	}
+	wg.Wait()
+	if b, err := os.ReadFile(filepath.Join(string(fast), "a")); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if string(b) != content {
+		t.Error("unexpected promoted content")
+	}
+}
+
+func TestRedisCacherDelete(t *testing.T) {
+	client := newFakeRedisClient()
+	rc := &RedisCacher{Client: client, KeyPrefix: "goproxy:", ChunkSize: 4}
+	for _, name := range []string{"a/b/c", "a/b/d"} {
+		if err := rc.Put(context.Background(), name, strings.NewReader("foobarbaz")); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	if err := rc.Delete(context.Background(), "a/b/c"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if err := rc.Delete(context.Background(), "a/b/c"); err == nil {
+		t.Fatal("expected error")
+	} else if got, want := err, fs.ErrNotExist; !errors.Is(got, want) && got.Error() != want.Error() {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := len(client.values), 4; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+	if _, err := rc.Get(context.Background(), "a/b/d"); err != nil {
+		t.Errorf("unexpected error %q", err)
+	}
+}
+
+func TestRedisCacher(t *testing.T) {
+	client := newFakeRedisClient()
+	rc := &RedisCacher{Client: client, KeyPrefix: "goproxy:", TTL: time.Hour, ChunkSize: 4}
+
+	if rc, err := rc.Get(context.Background(), "a/b/c"); err == nil {
+		t.Fatal("expected error")
+	} else if got, want := err, fs.ErrNotExist; !errors.Is(got, want) && got.Error() != want.Error() {
+		t.Fatalf("got %q, want %q", got, want)
+	} else if rc != nil {
+		t.Errorf("got %v, want nil", rc)
+	}
+
+	for _, tt := range []struct {
+		n        int
+		content  string
+		wantKeys int
+	}{
+		{1, "", 1},
+		{2, "foo", 2},
+		{3, "foobar", 3},
+		{4, "foobarbaz", 4},
+		{5, "foobarba", 3},
+	} {
+		if err := rc.Put(context.Background(), "a/b/c", strings.NewReader(tt.content)); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		}
+		if got, want := len(client.values), tt.wantKeys; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if got, want := client.ttls["goproxy:a/b/c"], time.Hour; got != want {
+			t.Errorf("test(%d): got %s, want %s", tt.n, got, want)
+		}
+		if rcc, err := rc.Get(context.Background(), "a/b/c"); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		} else if _, ok := rcc.(interface{ ModTime() time.Time }); !ok {
+			t.Errorf("test(%d): want ModTime", tt.n)
+		} else if b, err := io.ReadAll(rcc); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		} else if err := rcc.Close(); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		} else if got, want := string(b), tt.content; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+
+	if rcc, err := rc.Get(context.Background(), "a/b/c"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if rs, ok := rcc.(io.ReadSeeker); !ok {
+		t.Error("want io.Seeker")
+	} else if size, err := rs.Seek(0, io.SeekEnd); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := size, int64(8); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	} else if _, err := rs.Seek(3, io.SeekStart); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if b, err := io.ReadAll(rs); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := string(b), "barba"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	} else if _, err := rs.Seek(-1, io.SeekStart); err == nil {
+		t.Fatal("expected error")
+	} else if got, want := err.Error(), "negative position"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	for key := range client.values {
+		if key != "goproxy:a/b/c" {
+			delete(client.values, key)
+			break
+		}
+	}
+	if rcc, err := rc.Get(context.Background(), "a/b/c"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if _, err := io.ReadAll(rcc); err == nil {
+		t.Fatal("expected error")
+	} else if got, want := err, io.ErrUnexpectedEOF; !errors.Is(got, want) && got.Error() != want.Error() {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	client.setErr = errors.New("cannot set")
+	if err := rc.Put(context.Background(), "d/e/f", strings.NewReader("foobar")); err == nil {
+		t.Fatal("expected error")
+	} else if got, want := err.Error(), "cannot set"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	client.setErr = nil
+
+	if err := rc.Put(context.Background(), "d/e/f", &errorReadSeeker{}); err == nil {
+		t.Fatal("expected error")
+	} else if got, want := err.Error(), "cannot read"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if _, err := rc.Get(context.Background(), "d/e/f"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got %v, want %v", err, fs.ErrNotExist)
+	}
+
+	client.values["goproxy:g/h/i"] = []byte("{")
+	if _, err := rc.Get(context.Background(), "g/h/i"); err == nil {
+		t.Fatal("expected error")
+	}
+	client.values["goproxy:g/h/i"] = []byte(`{"chunkSize":0}`)
+	if _, err := rc.Get(context.Background(), "g/h/i"); err == nil {
+		t.Fatal("expected error")
+	} else if got, want := err.Error(), "invalid redis cacher metadata"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	rc = &RedisCacher{Client: newFakeRedisClient()}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := rc.Put(context.Background(), "a/b/c", strings.NewReader("foobar")); err != nil {
+				t.Errorf("unexpected error %q", err)
+			}
+			if rcc, err := rc.Get(context.Background(), "a/b/c"); err != nil {
+				t.Errorf("unexpected error %q", err)
+			} else if b, err := io.ReadAll(rcc); err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("unexpected error %q", err)
+			} else if err == nil && string(b) != "foobar" {
+				t.Errorf("got %q, want %q", b, "foobar")
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,187 @@
+package goproxy
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// circuitBreakerSweepInterval is the minimum interval between sweeps of the
+// idle hosts of a [circuitBreaker].
+const circuitBreakerSweepInterval = time.Minute
+
+// circuitBreakerState is the state of a host of a [circuitBreaker].
+type circuitBreakerState uint8
+
+// The circuit breaker states.
+const (
+	circuitBreakerClosed circuitBreakerState = iota
+	circuitBreakerOpen
+	circuitBreakerHalfOpen
+)
+
+// String implements [fmt.Stringer].
+func (cbs circuitBreakerState) String() string {
+	switch cbs {
+	case circuitBreakerClosed:
+		return "closed"
+	case circuitBreakerOpen:
+		return "open"
+	case circuitBreakerHalfOpen:
+		return "half-open"
+	}
+	return "invalid"
+}
+
+// circuitBreaker is a circuit breaker with one circuit per host. A circuit
+// opens after threshold consecutive failures within the window, rejects
+// everything for the cooldown, and then lets a single probe through while
+// half-open. It is safe for concurrent use.
+type circuitBreaker struct {
+	threshold     int
+	window        time.Duration
+	cooldown      time.Duration
+	onStateChange func(host string, state circuitBreakerState)
+	mutex         sync.Mutex
+	hosts         map[string]*circuitBreakerHost
+	lastSweep     time.Time
+}
+
+// circuitBreakerHost is a host of a [circuitBreaker].
+type circuitBreakerHost struct {
+	state          circuitBreakerState
+	failures       int
+	firstFailureAt time.Time
+	openedAt       time.Time
+	probing        bool
+}
+
+// newCircuitBreaker returns a new [circuitBreaker] with the threshold, window,
+// and cooldown. The onStateChange, if not nil, is called with the mutex of the
+// [circuitBreaker] held whenever the state of a host changes.
+func newCircuitBreaker(threshold int, window, cooldown time.Duration, onStateChange func(host string, state circuitBreakerState)) *circuitBreaker {
+	return &circuitBreaker{
+		threshold:     threshold,
+		window:        window,
+		cooldown:      cooldown,
+		onStateChange: onStateChange,
+		hosts:         map[string]*circuitBreakerHost{},
+	}
+}
+
+// allow reports whether a request to the host may be made at the now. Once the
+// cooldown of an open circuit has elapsed, it lets exactly one request through
+// as a probe, which must be followed by a call to [circuitBreaker.record].
+func (cb *circuitBreaker) allow(host string, now time.Time) bool {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	h, ok := cb.hosts[host]
+	if !ok {
+		return true
+	}
+	switch h.state {
+	case circuitBreakerOpen:
+		if now.Sub(h.openedAt) < cb.cooldown {
+			return false
+		}
+		cb.setState(host, h, circuitBreakerHalfOpen)
+		h.probing = true
+		return true
+	case circuitBreakerHalfOpen:
+		if h.probing {
+			return false
+		}
+		h.probing = true
+		return true
+	}
+	return true
+}
+
+// record records the outcome of a request to the host at the now. A request
+// that neither failed nor succeeded, such as one canceled by its client, only
+// gives way to another probe if the circuit is half-open.
+func (cb *circuitBreaker) record(host string, failed, succeeded bool, now time.Time) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	if now.Sub(cb.lastSweep) >= circuitBreakerSweepInterval {
+		cb.sweep(now)
+	}
+
+	h, ok := cb.hosts[host]
+	switch {
+	case succeeded:
+		if ok {
+			cb.setState(host, h, circuitBreakerClosed)
+			delete(cb.hosts, host)
+		}
+		return
+	case !failed:
+		if ok && h.state == circuitBreakerHalfOpen {
+			h.probing = false
+		}
+		return
+	}
+
+	if !ok {
+		h = &circuitBreakerHost{}
+		cb.hosts[host] = h
+	}
+	switch h.state {
+	case circuitBreakerClosed:
+		if h.failures > 0 && now.Sub(h.firstFailureAt) > cb.window {
+			h.failures = 0
+		}
+		if h.failures == 0 {
+			h.firstFailureAt = now
+		}
+		h.failures++
+		if h.failures >= cb.threshold {
+			h.openedAt = now
+			cb.setState(host, h, circuitBreakerOpen)
+		}
+	case circuitBreakerHalfOpen:
+		h.openedAt = now
+		h.probing = false
+		cb.setState(host, h, circuitBreakerOpen)
+	}
+}
+
+// setState sets the state of the h of the host to the state.
+func (cb *circuitBreaker) setState(host string, h *circuitBreakerHost, state circuitBreakerState) {
+	if h.state == state {
+		return
+	}
+	h.state = state
+	if cb.onStateChange != nil {
+		cb.onStateChange(host, state)
+	}
+}
+
+// sweep removes the closed hosts whose failures are older than the window by
+// the now, since they are equivalent to new ones.
+func (cb *circuitBreaker) sweep(now time.Time) {
+	for host, h := range cb.hosts {
+		if h.state == circuitBreakerClosed && now.Sub(h.firstFailureAt) > cb.window {
+			delete(cb.hosts, host)
+		}
+	}
+	cb.lastSweep = now
+}
+
+// circuitOpenError is an error indicating that a request to a host was not
+// made because the circuit of the host is open.
+type circuitOpenError struct {
+	host string
+}
+
+// Error implements [error].
+func (coe *circuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker open for %s: %v", coe.host, errBadUpstream)
+}
+
+// Unwrap returns [errBadUpstream].
+func (*circuitOpenError) Unwrap() error {
+	return errBadUpstream
+}
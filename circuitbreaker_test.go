@@ -0,0 +1,253 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerStateString(t *testing.T) {
+	for _, tt := range []struct {
+		n     int
+		state circuitBreakerState
+		want  string
+	}{
+		{1, circuitBreakerClosed, "closed"},
+		{2, circuitBreakerOpen, "open"},
+		{3, circuitBreakerHalfOpen, "half-open"},
+		{4, 255, "invalid"},
+	} {
+		if got, want := tt.state.String(), tt.want; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var states []string
+	cb := newCircuitBreaker(3, time.Minute, 30*time.Second, func(host string, state circuitBreakerState) {
+		states = append(states, host+" "+state.String())
+	})
+	now := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 2; i++ {
+		if !cb.allow("example.com", now) {
+			t.Fatalf("attempt %d: expected allowed", i)
+		}
+		cb.record("example.com", true, false, now)
+	}
+	cb.record("example.com", true, false, now.Add(2*time.Minute))
+	if !cb.allow("example.com", now.Add(2*time.Minute)) {
+		t.Error("expected allowed after the window")
+	}
+
+	now = now.Add(2 * time.Minute)
+	cb.record("example.com", true, false, now)
+	cb.record("example.com", true, false, now)
+	if cb.allow("example.com", now) {
+		t.Error("expected not allowed")
+	}
+	if cb.allow("example.com", now.Add(29*time.Second)) {
+		t.Error("expected not allowed")
+	}
+	if !cb.allow("example.org", now) {
+		t.Error("expected other hosts allowed")
+	}
+
+	now = now.Add(30 * time.Second)
+	if !cb.allow("example.com", now) {
+		t.Error("expected the probe allowed")
+	}
+	if cb.allow("example.com", now) {
+		t.Error("expected not allowed while probing")
+	}
+	cb.record("example.com", false, false, now)
+	if !cb.allow("example.com", now) {
+		t.Error("expected another probe allowed")
+	}
+	cb.record("example.com", true, false, now)
+	if cb.allow("example.com", now.Add(time.Second)) {
+		t.Error("expected not allowed")
+	}
+
+	now = now.Add(30 * time.Second)
+	if !cb.allow("example.com", now) {
+		t.Error("expected the probe allowed")
+	}
+	cb.record("example.com", false, true, now)
+	if !cb.allow("example.com", now) {
+		t.Error("expected allowed")
+	}
+	if got, want := len(cb.hosts), 0; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	wantStates := []string{
+		"example.com open",
+		"example.com half-open",
+		"example.com open",
+		"example.com half-open",
+		"example.com closed",
+	}
+	if got, want := len(states), len(wantStates); got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+	for i, want := range wantStates {
+		if got := states[i]; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestCircuitBreakerSweep(t *testing.T) {
+	cb := newCircuitBreaker(3, time.Minute, 30*time.Second, nil)
+	now := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	cb.record("example.com", true, false, now)
+	cb.record("example.org", true, false, now)
+	for i := 0; i < 3; i++ {
+		cb.record("example.net", true, false, now)
+	}
+	cb.record("example.edu", true, false, now.Add(2*time.Minute))
+	if got, want := len(cb.hosts), 2; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+	if _, ok := cb.hosts["example.net"]; !ok {
+		t.Error("expected the open host kept")
+	}
+}
+
+func TestCircuitOpenError(t *testing.T) {
+	err := error(&circuitOpenError{host: "example.com"})
+	if got, want := err.Error(), "circuit breaker open for example.com: bad upstream"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if !errors.Is(err, errBadUpstream) {
+		t.Error("expected errBadUpstream")
+	}
+	if isCacheableNotFoundError(err) {
+		t.Error("expected not cacheable")
+	}
+}
+
+func TestGoproxyCircuitBreaker(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping test that requires a shell script as the go binary")
+	}
+	countFile := filepath.Join(t.TempDir(), "count")
+	goBin := filepath.Join(t.TempDir(), "go")
+	if err := os.WriteFile(goBin, []byte("#!/bin/sh\n[ \"$1\" = version ] && echo 'go version go1.21.0 linux/amd64' && exit\necho x >> \"$COUNT_FILE\"\necho 'dial tcp: connection refused' >&2\nexit 1\n"), 0o755); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	g := &Goproxy{
+		Env:                     []string{"GOPROXY=direct", "GOSUMDB=off", "COUNT_FILE=" + countFile},
+		GoBinName:               goBin,
+		FetchRetries:            -1,
+		CircuitBreakerThreshold: 2,
+		ErrorLogger:             log.New(io.Discard, "", 0),
+	}
+	g.initOnce.Do(g.init)
+	for _, tt := range []struct {
+		n           int
+		name        string
+		wantContent string
+		wantCount   int
+	}{
+		{1, "example.com/@v/list", "not found: bad upstream: dial tcp: connection refused", 1},
+		{2, "example.com/foo/@v/list", "not found: bad upstream: dial tcp: connection refused", 2},
+		{3, "example.com/@v/list", "not found: bad upstream", 2},
+		{4, "example.org/@v/list", "not found: bad upstream: dial tcp: connection refused", 3},
+	} {
+		rec := httptest.NewRecorder()
+		g.serveFetch(rec, httptest.NewRequest("", "/", nil), tt.name, t.TempDir())
+		recr := rec.Result()
+		if got, want := recr.StatusCode, http.StatusNotFound; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if b, err := io.ReadAll(recr.Body); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		} else if got, want := string(b), tt.wantContent; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		if b, err := os.ReadFile(countFile); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		} else if got, want := strings.Count(string(b), "x"), tt.wantCount; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+	}
+
+	var buf strings.Builder
+	if err := g.metrics.writeTo(&buf); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if got, want := buf.String(), `goproxy_circuit_breaker_state{host="example.com"} 1`; !strings.Contains(got, want) {
+		t.Errorf("got %q, want it to contain %q", got, want)
+	}
+}
+
+func TestGoproxyCircuitBreakerHalfOpenProbeCanceled(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping test that requires a shell script as the go binary")
+	}
+	countFile := filepath.Join(t.TempDir(), "count")
+	goBin := filepath.Join(t.TempDir(), "go")
+	if err := os.WriteFile(goBin, []byte("#!/bin/sh\n[ \"$1\" = version ] && echo 'go version go1.21.0 linux/amd64' && exit\necho x >> \"$COUNT_FILE\"\necho 'dial tcp: connection refused' >&2\nexit 1\n"), 0o755); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	g := &Goproxy{
+		Env:                     []string{"GOPROXY=direct", "GOSUMDB=off", "COUNT_FILE=" + countFile},
+		GoBinName:               goBin,
+		FetchRetries:            -1,
+		MaxDirectFetches:        1,
+		CircuitBreakerThreshold: 1,
+		CircuitBreakerCooldown:  time.Minute,
+		Now:                     func() time.Time { return now },
+	}
+	g.initOnce.Do(g.init)
+	doDirect := func(ctx context.Context) error {
+		f, err := newFetch(g, "example.com/@v/list", t.TempDir())
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		_, err = f.doDirect(ctx)
+		return err
+	}
+	count := func() int {
+		b, err := os.ReadFile(countFile)
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		return strings.Count(string(b), "x")
+	}
+
+	if err := doDirect(context.Background()); err == nil {
+		t.Fatal("expected error")
+	}
+	now = now.Add(2 * time.Minute)
+
+	g.directFetchWorkerPool <- struct{}{}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := doDirect(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+	<-g.directFetchWorkerPool
+
+	if err := doDirect(context.Background()); err == nil {
+		t.Fatal("expected error")
+	} else if got, want := err.Error(), "dial tcp: connection refused"; !strings.Contains(got, want) {
+		t.Errorf("got %q, want containing %q", got, want)
+	}
+	if got, want := count(), 2; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
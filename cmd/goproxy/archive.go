@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"syscall"
+
+	"github.com/goproxy/goproxy"
+	"golang.org/x/mod/module"
+)
+
+// runExport runs the "export" subcommand with the args, which writes the cache
+// directory as a tar archive, and returns the exit code.
+func runExport(args []string) int {
+	fs := flag.NewFlagSet(os.Args[0]+" export", flag.ContinueOnError)
+	cacheDir := fs.String("cache-dir", "caches", "directory of the cache to export")
+	out := fs.String("out", "-", "file to write the tar archive to (- means stdout)")
+	prefixes := fs.String("prefix", "", "comma-separated list of module path prefixes whose module files are exported")
+	goSum := fs.String("go-sum", "", "go.sum file whose module versions are exported")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "unexpected arguments: %s\n", strings.Join(fs.Args(), " "))
+		return 2
+	}
+
+	include, err := newExportFilter(*prefixes, *goSum)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	w := io.Writer(os.Stdout)
+	var f *os.File
+	if *out != "-" {
+		if f, err = os.Create(*out); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to create archive: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+	if err := goproxy.DirCacher(*cacheDir).Export(ctx, bw, include); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to export cache directory: %v\n", err)
+		return 1
+	}
+	if err := bw.Flush(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write archive: %v\n", err)
+		return 1
+	}
+	if f != nil {
+		if err := f.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write archive: %v\n", err)
+			return 1
+		}
+	}
+	return 0
+}
+
+// runImport runs the "import" subcommand with the args, which reads a tar
+// archive written by the "export" subcommand into the cache directory, and
+// returns the exit code.
+func runImport(args []string) int {
+	fs := flag.NewFlagSet(os.Args[0]+" import", flag.ContinueOnError)
+	cacheDir := fs.String("cache-dir", "caches", "directory of the cache to import into")
+	in := fs.String("in", "-", "file to read the tar archive from (- means stdin)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "unexpected arguments: %s\n", strings.Join(fs.Args(), " "))
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	r := io.Reader(os.Stdin)
+	if *in != "-" {
+		f, err := os.Open(*in)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to open archive: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		r = f
+	}
+	var imported, corrupt int
+	if err := goproxy.DirCacher(*cacheDir).Import(ctx, bufio.NewReader(r), func(result goproxy.DirCacherVerifyResult) {
+		if result.Err != nil {
+			corrupt++
+			fmt.Fprintf(os.Stdout, "corrupt: %s: %v\n", result.Name, result.Err)
+			return
+		}
+		imported++
+	}); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to import archive: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stdout, "imported %d, corrupt %d\n", imported, corrupt)
+	if corrupt > 0 {
+		return 1
+	}
+	return 0
+}
+
+// newExportFilter returns a function that reports whether a cache name is
+// exported by the "export" subcommand. Module files are exported if their
+// module paths are any of the comma-separated prefixes or start with one of
+// them followed by a slash, or if their module versions are listed in the
+// goSum file. Only the info and mod files are exported for module versions
+// that have only go.mod lines in the goSum file. It returns nil, meaning
+// everything is exported, if both the prefixes and the goSum are empty.
+func newExportFilter(prefixes, goSum string) (func(name string) bool, error) {
+	if prefixes == "" && goSum == "" {
+		return nil, nil
+	}
+
+	var escapedPrefixes []string
+	for _, prefix := range strings.Split(prefixes, ",") {
+		prefix = strings.TrimSuffix(strings.TrimSpace(prefix), "/")
+		if prefix == "" {
+			continue
+		}
+		escapedPrefix, err := module.EscapePath(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -prefix %q: %w", prefix, err)
+		}
+		escapedPrefixes = append(escapedPrefixes, escapedPrefix+"/")
+	}
+
+	// goSumVersions maps the cache names without extensions of the module
+	// versions in the goSum file to whether their zip files are needed.
+	goSumVersions := map[string]bool{}
+	if goSum != "" {
+		b, err := os.ReadFile(goSum)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read -go-sum: %w", err)
+		}
+		for _, line := range strings.Split(string(b), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) != 3 {
+				continue
+			}
+			moduleVersion, goModOnly := strings.CutSuffix(fields[1], "/go.mod")
+			escapedModulePath, err := module.EscapePath(fields[0])
+			if err != nil {
+				return nil, fmt.Errorf("invalid -go-sum line %q: %w", strings.TrimSpace(line), err)
+			}
+			escapedModuleVersion, err := module.EscapeVersion(moduleVersion)
+			if err != nil {
+				return nil, fmt.Errorf("invalid -go-sum line %q: %w", strings.TrimSpace(line), err)
+			}
+			name := escapedModulePath + "/@v/" + escapedModuleVersion
+			goSumVersions[name] = goSumVersions[name] || !goModOnly
+		}
+	}
+
+	return func(name string) bool {
+		if strings.HasPrefix(name, "sumdb/") {
+			return false
+		}
+		for _, escapedPrefix := range escapedPrefixes {
+			if strings.HasPrefix(name, escapedPrefix) {
+				return true
+			}
+		}
+		ext := path.Ext(name)
+		needsZip, ok := goSumVersions[strings.TrimSuffix(name, ext)]
+		if !ok {
+			return false
+		}
+		switch ext {
+		case ".info", ".mod":
+			return true
+		case ".zip", ".ziphash":
+			return needsZip
+		}
+		return false
+	}, nil
+}
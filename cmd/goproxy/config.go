@@ -0,0 +1,415 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/goproxy/goproxy"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the configuration of the goproxy command. Each of its fields
+// corresponds to the flag of the same name as its key, which is also used in
+// configuration files.
+type Config struct {
+	Address              string        `yaml:"address"`
+	UnixSocketMode       string        `yaml:"unix-socket-mode"`
+	ProxyProtocol        bool          `yaml:"proxy-protocol"`
+	H2C                  bool          `yaml:"h2c"`
+	TLSCertFile          string        `yaml:"tls-cert-file"`
+	TLSKeyFile           string        `yaml:"tls-key-file"`
+	ClientCAFile         string        `yaml:"client-ca-file"`
+	AutocertDomains      string        `yaml:"autocert-domains"`
+	AutocertCacheDir     string        `yaml:"autocert-cache-dir"`
+	AutocertHTTPAddress  string        `yaml:"autocert-http-address"`
+	TLSReloadInterval    time.Duration `yaml:"tls-reload-interval"`
+	PathPrefix           string        `yaml:"path-prefix"`
+	IndexPage            string        `yaml:"index-page"`
+	UpstreamProxies      string        `yaml:"upstream-proxies"`
+	Mirror               string        `yaml:"mirror"`
+	Netrc                string        `yaml:"netrc"`
+	GoBinName            string        `yaml:"go-bin-name"`
+	MinGoVersion         string        `yaml:"min-go-version"`
+	GoEnv                stringsFlag   `yaml:"go-env"`
+	GoWorkers            int           `yaml:"go-workers"`
+	MaxDirectFetches     int           `yaml:"max-direct-fetches"`
+	MaxDirectDownloads   int           `yaml:"max-direct-downloads"`
+	SerializeFetches     bool          `yaml:"serialize-module-fetches"`
+	FetchRetries         int           `yaml:"fetch-retries"`
+	FetchRetryBackoff    time.Duration `yaml:"fetch-retry-backoff"`
+	BreakerThreshold     int           `yaml:"circuit-breaker-threshold"`
+	BreakerWindow        time.Duration `yaml:"circuit-breaker-window"`
+	BreakerCooldown      time.Duration `yaml:"circuit-breaker-cooldown"`
+	Allow                string        `yaml:"allow"`
+	Block                string        `yaml:"block"`
+	ApprovedVersions     string        `yaml:"approved-versions-file"`
+	LatestPolicies       string        `yaml:"latest-policies-file"`
+	Quotas               string        `yaml:"quotas-file"`
+	PolicyReload         time.Duration `yaml:"policy-reload-interval"`
+	NoSumCheck           string        `yaml:"no-sum-check"`
+	Private              string        `yaml:"private"`
+	Rewrite              stringsFlag   `yaml:"rewrite"`
+	Offline              bool          `yaml:"offline"`
+	NoDirect             bool          `yaml:"no-direct"`
+	StartInMaintenance   bool          `yaml:"start-in-maintenance"`
+	NoStaleOnError       bool          `yaml:"no-stale-on-error"`
+	MaxStaleAge          time.Duration `yaml:"max-stale-age"`
+	UpstreamErrorStatus  bool          `yaml:"upstream-error-status"`
+	ListCacheTTL         time.Duration `yaml:"list-cache-ttl"`
+	FilterRetracted      bool          `yaml:"filter-retracted"`
+	RateLimit            float64       `yaml:"rate-limit"`
+	RateBurst            int           `yaml:"rate-burst"`
+	MaxRequests          int           `yaml:"max-requests"`
+	MaxRequestQueueWait  time.Duration `yaml:"max-request-queue-wait"`
+	LoadShedQueueWait    time.Duration `yaml:"load-shed-queue-wait"`
+	LoadShedGoroutines   int           `yaml:"load-shed-goroutines"`
+	LoadShedHeapBytes    int64         `yaml:"load-shed-heap-bytes"`
+	TrustedProxies       string        `yaml:"trusted-proxies"`
+	CORSOrigins          string        `yaml:"cors-origins"`
+	GzipMinSize          int64         `yaml:"gzip-min-size"`
+	DownloadCacheControl string        `yaml:"download-cache-control"`
+	QueryCacheControl    string        `yaml:"query-cache-control"`
+	MaxZipSize           int64         `yaml:"max-zip-size"`
+	ProxiedSUMDBs        string        `yaml:"proxied-sumdbs"`
+	ProxiedSUMDBsTLS     string        `yaml:"proxied-sumdbs-tls"`
+	CacheDir             string        `yaml:"cache-dir"`
+	CacheDedup           bool          `yaml:"cache-dedup"`
+	CacheCompression     string        `yaml:"cache-compression"`
+	CacheReadOnly        bool          `yaml:"cache-read-only"`
+	FallbackCacheDir     string        `yaml:"fallback-cache-dir"`
+	GoModCache           string        `yaml:"gomodcache"`
+	ServeGoModCache      bool          `yaml:"serve-gomodcache"`
+	CacheMaxAge          time.Duration `yaml:"cache-max-age"`
+	CacheMaxSize         int64         `yaml:"cache-max-size"`
+	CacheHighWatermark   string        `yaml:"cache-high-watermark"`
+	CacheLowWatermark    string        `yaml:"cache-low-watermark"`
+	CacheCleanupInterval time.Duration `yaml:"cache-cleanup-interval"`
+	VerifyCache          bool          `yaml:"verify-cache"`
+	VerifyConcurrency    int           `yaml:"verify-concurrency"`
+	VerifyDeleteCorrupt  bool          `yaml:"verify-delete-corrupt"`
+	TempDir              string        `yaml:"temp-dir"`
+	Insecure             bool          `yaml:"insecure"`
+	InsecureHosts        string        `yaml:"insecure-hosts"`
+	SOCKS5               string        `yaml:"socks5"`
+	UserAgent            string        `yaml:"user-agent"`
+	Header               stringsFlag   `yaml:"header"`
+	ConnectTimeout       time.Duration `yaml:"connect-timeout"`
+	FallbackDelay        time.Duration `yaml:"fallback-delay"`
+	MaxIdleConns         int           `yaml:"max-idle-conns"`
+	MaxIdleConnsPerHost  int           `yaml:"max-idle-conns-per-host"`
+	IdleConnTimeout      time.Duration `yaml:"idle-conn-timeout"`
+	IPVersion            string        `yaml:"ip-version"`
+	DNSCacheTTL          time.Duration `yaml:"dns-cache-ttl"`
+	DNSCacheNegativeTTL  time.Duration `yaml:"dns-cache-negative-ttl"`
+	FetchTimeout         time.Duration `yaml:"fetch-timeout"`
+	ListFetchTimeout     time.Duration `yaml:"list-fetch-timeout"`
+	InfoFetchTimeout     time.Duration `yaml:"info-fetch-timeout"`
+	ModFetchTimeout      time.Duration `yaml:"mod-fetch-timeout"`
+	ZipFetchTimeout      time.Duration `yaml:"zip-fetch-timeout"`
+	NotFoundTTL          time.Duration `yaml:"not-found-ttl"`
+	NotFoundQueryTTL     time.Duration `yaml:"not-found-query-ttl"`
+	MetricsPath          string        `yaml:"metrics-path"`
+	HealthPath           string        `yaml:"health-path"`
+	ReadinessPath        string        `yaml:"readiness-path"`
+	ReadinessUpstreams   string        `yaml:"readiness-upstreams"`
+	AdminPath            string        `yaml:"admin-path"`
+	AdminToken           string        `yaml:"admin-token"`
+	AuthTokens           string        `yaml:"auth-tokens-file"`
+	AuthHealthMetrics    bool          `yaml:"auth-health-and-metrics"`
+	VanityImports        string        `yaml:"vanity-imports-file"`
+	WebhookURL           string        `yaml:"webhook-url"`
+	WebhookSecret        string        `yaml:"webhook-secret"`
+	PprofAddress         string        `yaml:"pprof-address"`
+	ShutdownTimeout      time.Duration `yaml:"shutdown-timeout"`
+	LogFormat            string        `yaml:"log-format"`
+	LogLevel             string        `yaml:"log-level"`
+	DebugRequests        bool          `yaml:"debug-requests"`
+}
+
+// newConfig returns a new [Config] with the default values.
+func newConfig() *Config {
+	return &Config{
+		Address:              "localhost:8080",
+		UnixSocketMode:       "0660",
+		AutocertCacheDir:     "autocert",
+		AutocertHTTPAddress:  ":80",
+		TLSReloadInterval:    time.Minute,
+		PolicyReload:         time.Minute,
+		GoBinName:            "go",
+		FetchRetryBackoff:    100 * time.Millisecond,
+		CacheDir:             "caches",
+		CacheCleanupInterval: time.Hour,
+		VerifyConcurrency:    runtime.NumCPU(),
+		TempDir:              os.TempDir(),
+		ConnectTimeout:       30 * time.Second,
+		MaxIdleConns:         512,
+		MaxIdleConnsPerHost:  64,
+		IdleConnTimeout:      5 * time.Minute,
+		FetchTimeout:         10 * time.Minute,
+		AdminPath:            "/admin",
+		ShutdownTimeout:      30 * time.Second,
+		LogFormat:            "text",
+		LogLevel:             "warn",
+	}
+}
+
+// registerFlags registers the flags of the cfg in the fs, using the current
+// values of the cfg as the defaults.
+func (cfg *Config) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&cfg.Address, "address", cfg.Address, "TCP address, or Unix domain socket path prefixed with \"unix:\", that the HTTP server listens on")
+	fs.StringVar(&cfg.UnixSocketMode, "unix-socket-mode", cfg.UnixSocketMode, "file mode (in octal) of the Unix domain socket when -address is a Unix domain socket")
+	fs.BoolVar(&cfg.ProxyProtocol, "proxy-protocol", cfg.ProxyProtocol, "require a PROXY protocol (v1 or v2) header, as sent by L4 load balancers, at the start of each connection to -address and use its source address as the client address (connections without a valid header are rejected)")
+	fs.BoolVar(&cfg.H2C, "h2c", cfg.H2C, "serve HTTP/2 without TLS (h2c), both with prior knowledge and upgraded from HTTP/1.1, on -address, for clients behind a TLS-terminating proxy or service mesh (mutually exclusive with TLS)")
+	fs.StringVar(&cfg.TLSCertFile, "tls-cert-file", cfg.TLSCertFile, "path to the TLS certificate file")
+	fs.StringVar(&cfg.TLSKeyFile, "tls-key-file", cfg.TLSKeyFile, "path to the TLS key file")
+	fs.StringVar(&cfg.ClientCAFile, "client-ca-file", cfg.ClientCAFile, "path to the CA certificate bundle against which client certificates are verified (empty means clients are not required to present one)")
+	fs.StringVar(&cfg.AutocertDomains, "autocert-domains", cfg.AutocertDomains, "comma-separated list of domains for which TLS certificates are automatically obtained and renewed via ACME (empty means disabled)")
+	fs.StringVar(&cfg.AutocertCacheDir, "autocert-cache-dir", cfg.AutocertCacheDir, "directory that used to cache automatically obtained TLS certificates")
+	fs.StringVar(&cfg.AutocertHTTPAddress, "autocert-http-address", cfg.AutocertHTTPAddress, "TCP address that the HTTP server serving ACME HTTP-01 challenges and redirecting to HTTPS listens on when -autocert-domains is set")
+	fs.DurationVar(&cfg.TLSReloadInterval, "tls-reload-interval", cfg.TLSReloadInterval, "interval (0 means disabled) between checks of the TLS certificate and key files for changes to reload")
+	fs.StringVar(&cfg.PathPrefix, "path-prefix", cfg.PathPrefix, "prefix for all request paths")
+	fs.StringVar(&cfg.IndexPage, "index-page", cfg.IndexPage, "path to an HTML file served for GET requests to the root path, or \"default\" for a built-in page explaining how to set GOPROXY (empty means disabled)")
+	fs.StringVar(&cfg.UpstreamProxies, "upstream-proxies", cfg.UpstreamProxies, "list of upstream proxies in the same form as GOPROXY (empty means using the GOPROXY environment variable)")
+	fs.StringVar(&cfg.Mirror, "mirror", cfg.Mirror, "URL of an upstream proxy (empty means disabled) to mirror, forwarding all requests not cached, including those of the checksum databases of -proxied-sumdbs (sum.golang.org if empty), to it without ever fetching directly (mutually exclusive with -upstream-proxies)")
+	fs.StringVar(&cfg.Netrc, "netrc", cfg.Netrc, "path to the .netrc file whose credentials authenticate outgoing requests and direct fetches (empty means using the NETRC environment variable or the one in the home directory)")
+	fs.StringVar(&cfg.GoBinName, "go-bin-name", cfg.GoBinName, "name of the Go binary that is used to execute direct fetches")
+	fs.StringVar(&cfg.MinGoVersion, "min-go-version", cfg.MinGoVersion, "minimum version (e.g., go1.21, empty means go1.11) of the Go binary checked at startup")
+	fs.Var(&cfg.GoEnv, "go-env", "environment variable in the form \"<key>=<value>\" set over the process environment, for the go command executing direct fetches as well (can be repeated)")
+	fs.IntVar(&cfg.GoWorkers, "go-workers", cfg.GoWorkers, "maximum number (0 means no limit) of concurrent go command invocations for direct fetches of any kind, including those limited by -max-direct-downloads")
+	fs.IntVar(&cfg.MaxDirectFetches, "max-direct-fetches", cfg.MaxDirectFetches, "deprecated: use -go-workers, which takes precedence")
+	fs.IntVar(&cfg.MaxDirectDownloads, "max-direct-downloads", cfg.MaxDirectDownloads, "maximum number (0 means only -go-workers applies) of concurrent direct fetches of info, mod, and zip files, which clone repositories and wait without taking any of the -go-workers")
+	fs.BoolVar(&cfg.SerializeFetches, "serialize-module-fetches", cfg.SerializeFetches, "make direct fetches of the same module path one at a time, even for different versions")
+	fs.IntVar(&cfg.FetchRetries, "fetch-retries", cfg.FetchRetries, "maximum number (0 means 2, negative means no retries) of retries of a transiently failed fetch")
+	fs.DurationVar(&cfg.FetchRetryBackoff, "fetch-retry-backoff", cfg.FetchRetryBackoff, "base duration of the exponential backoff between retries of a failed fetch")
+	fs.IntVar(&cfg.BreakerThreshold, "circuit-breaker-threshold", cfg.BreakerThreshold, "number (0 means never) of consecutive transiently failed direct fetches from a host after which further ones fail fast for -circuit-breaker-cooldown")
+	fs.DurationVar(&cfg.BreakerWindow, "circuit-breaker-window", cfg.BreakerWindow, "period (0 means 1m) within which the consecutive failures counted by -circuit-breaker-threshold must occur")
+	fs.DurationVar(&cfg.BreakerCooldown, "circuit-breaker-cooldown", cfg.BreakerCooldown, "duration (0 means 30s) for which direct fetches from a host fail fast before one is let through to probe it")
+	fs.StringVar(&cfg.Allow, "allow", cfg.Allow, "comma-separated list of glob patterns of module path prefixes that are allowed (empty means all)")
+	fs.StringVar(&cfg.Block, "block", cfg.Block, "comma-separated list of glob patterns of module path prefixes that are blocked")
+	fs.StringVar(&cfg.ApprovedVersions, "approved-versions-file", cfg.ApprovedVersions, "path to a file of rules, one \"<module path pattern> <version constraint>...\" per line, restricting the versions served of the matching modules")
+	fs.StringVar(&cfg.LatestPolicies, "latest-policies-file", cfg.LatestPolicies, "path to a file of rules, one \"<module path pattern> <highest-semver|highest-including-prerelease|track-branch> [<branch>]\" per line, overriding how @latest of the matching modules is resolved")
+	fs.StringVar(&cfg.Quotas, "quotas-file", cfg.Quotas, "path to a file of quotas, one \"<identity> <bytes> <window>\" per line (\"*\" for all other identities), limiting the response bytes served to each authenticated client over a rolling window")
+	fs.DurationVar(&cfg.PolicyReload, "policy-reload-interval", cfg.PolicyReload, "interval (0 means disabled) between checks of the -config and -approved-versions-file files for changes to reload the policy (-allow, -block, -approved-versions-file, -rate-limit, and -rate-burst), which is also reloaded on SIGHUP")
+	fs.StringVar(&cfg.NoSumCheck, "no-sum-check", cfg.NoSumCheck, "comma-separated list of glob patterns, in the same form as GONOSUMDB, of module path prefixes served without checksum database verification (only ever match internal modules, since their content is trusted blindly)")
+	fs.StringVar(&cfg.Private, "private", cfg.Private, "comma-separated list of glob patterns, in the same form as GOPRIVATE, of module path prefixes of private modules, which are always fetched directly with .netrc credentials and never sent to upstream proxies or checksum databases")
+	fs.Var(&cfg.Rewrite, "rewrite", "module path prefix rewrite in the form \"<old>=<new>\" under which modules requested with the old prefix are fetched from the new one and served under the old one (can be repeated)")
+	fs.BoolVar(&cfg.Offline, "offline", cfg.Offline, "serve only cached content without fetching modules or proxying checksum databases")
+	fs.BoolVar(&cfg.NoDirect, "no-direct", cfg.NoDirect, "never fetch modules directly from their VCS hosts, only from the upstream proxies (modules that can only be fetched directly are not found)")
+	fs.BoolVar(&cfg.StartInMaintenance, "start-in-maintenance", cfg.StartInMaintenance, "start in maintenance mode, responding 503 to every module and checksum database request until it is left via the admin API")
+	fs.BoolVar(&cfg.NoStaleOnError, "no-stale-on-error", cfg.NoStaleOnError, "disable serving cached version lists and latest versions marked as stale when fetching them fails")
+	fs.DurationVar(&cfg.MaxStaleAge, "max-stale-age", cfg.MaxStaleAge, "maximum age (0 means no limit) of the stale cached content served when fetching fails")
+	fs.BoolVar(&cfg.UpstreamErrorStatus, "upstream-error-status", cfg.UpstreamErrorStatus, "respond to upstream failures with 502 and 504 instead of 404 status codes, which stops clients from falling back to the next proxy after a comma")
+	fs.DurationVar(&cfg.ListCacheTTL, "list-cache-ttl", cfg.ListCacheTTL, "how long (0 means never) cached version lists are served without fetching again")
+	fs.BoolVar(&cfg.FilterRetracted, "filter-retracted", cfg.FilterRetracted, "omit the versions retracted by the go.mod file of the latest version from version lists")
+	fs.Float64Var(&cfg.RateLimit, "rate-limit", cfg.RateLimit, "maximum number (0 means no limit) of requests per second allowed from each client IP address")
+	fs.IntVar(&cfg.RateBurst, "rate-burst", cfg.RateBurst, "maximum number (0 means the ceiling of -rate-limit) of requests allowed from each client IP address in a single burst")
+	fs.IntVar(&cfg.MaxRequests, "max-requests", cfg.MaxRequests, "maximum number (0 means no limit) of requests served concurrently, counting cache hits and fetches alike")
+	fs.DurationVar(&cfg.MaxRequestQueueWait, "max-request-queue-wait", cfg.MaxRequestQueueWait, "maximum amount of time (0 means rejecting at once) a request waits for a slot when -max-requests requests are being served")
+	fs.DurationVar(&cfg.LoadShedQueueWait, "load-shed-queue-wait", cfg.LoadShedQueueWait, "maximum amount of time (0 means no limit) a direct fetch waits for a slot of -max-direct-fetches or -max-direct-downloads before requests that need fetches are shed with 503 while cache hits are still served")
+	fs.IntVar(&cfg.LoadShedGoroutines, "load-shed-goroutines", cfg.LoadShedGoroutines, "maximum number (0 means no limit) of goroutines before requests that need fetches are shed with 503 while cache hits are still served")
+	fs.Int64Var(&cfg.LoadShedHeapBytes, "load-shed-heap-bytes", cfg.LoadShedHeapBytes, "maximum size in bytes (0 means no limit) of heap objects before requests that need fetches are shed with 503 while cache hits are still served")
+	fs.StringVar(&cfg.TrustedProxies, "trusted-proxies", cfg.TrustedProxies, "comma-separated list of IP addresses and CIDR prefixes of the reverse proxies whose X-Forwarded-For headers are honored")
+	fs.StringVar(&cfg.CORSOrigins, "cors-origins", cfg.CORSOrigins, "comma-separated list of origins (* means all) allowed to read module and checksum database responses via CORS")
+	fs.Int64Var(&cfg.GzipMinSize, "gzip-min-size", cfg.GzipMinSize, "minimum size in bytes (0 means never) of text responses gzip-compressed for clients that accept it")
+	fs.StringVar(&cfg.DownloadCacheControl, "download-cache-control", cfg.DownloadCacheControl, "Cache-Control header (empty means \"public, max-age=604800, immutable\") of successful responses to info, mod, and zip file requests of module versions")
+	fs.StringVar(&cfg.QueryCacheControl, "query-cache-control", cfg.QueryCacheControl, "Cache-Control header (empty means \"public, max-age=60, must-revalidate\") of successful responses to @latest, version list, and version query requests")
+	fs.Int64Var(&cfg.MaxZipSize, "max-zip-size", cfg.MaxZipSize, "maximum size in bytes (0 means 500 MiB as the go command, negative means no limit) of module zip files")
+	fs.StringVar(&cfg.ProxiedSUMDBs, "proxied-sumdbs", cfg.ProxiedSUMDBs, "comma-separated list of proxied checksum databases")
+	fs.StringVar(&cfg.ProxiedSUMDBsTLS, "proxied-sumdbs-tls", cfg.ProxiedSUMDBsTLS, "comma-separated list of TLS settings of proxied checksum databases, each in the form \"<sumdb-name> <client-cert-file> <client-key-file> [<ca-cert-file>]\"")
+	fs.StringVar(&cfg.CacheDir, "cache-dir", cfg.CacheDir, "directory that used to cache module files")
+	fs.BoolVar(&cfg.CacheDedup, "cache-dedup", cfg.CacheDedup, "store byte-identical module zip files in the cache directory only once using hard links, falling back to copies on file systems without them")
+	fs.StringVar(&cfg.CacheCompression, "cache-compression", cfg.CacheCompression, "compression method (\"gzip\", empty means none) of the info and mod files in the cache directory, which are served whether compressed or not")
+	fs.BoolVar(&cfg.CacheReadOnly, "cache-read-only", cfg.CacheReadOnly, "treat the cache directory as an immutable set of module files and serve only them, as -offline does, without ever writing to it")
+	fs.StringVar(&cfg.FallbackCacheDir, "fallback-cache-dir", cfg.FallbackCacheDir, "directory of a larger but slower cache, such as a shared one, behind the cache directory, from which module files are promoted into the cache directory when found only there (empty means none)")
+	fs.StringVar(&cfg.GoModCache, "gomodcache", cfg.GoModCache, "module cache directory of the go command executing direct fetches, such as one shared with local builds (empty means the GOMODCACHE environment variable or the go command's default)")
+	fs.BoolVar(&cfg.ServeGoModCache, "serve-gomodcache", cfg.ServeGoModCache, "also serve the module files already downloaded to the -gomodcache directory, behind the cache directory into which they are promoted")
+	fs.DurationVar(&cfg.CacheMaxAge, "cache-max-age", cfg.CacheMaxAge, "maximum age (0 means no limit) of module files in the cache directory before they are evicted")
+	fs.Int64Var(&cfg.CacheMaxSize, "cache-max-size", cfg.CacheMaxSize, "maximum total size in bytes (0 means no limit) of module files in the cache directory before the least recently cached are evicted")
+	fs.StringVar(&cfg.CacheHighWatermark, "cache-high-watermark", cfg.CacheHighWatermark, "total size in bytes, or percentage (such as \"90%\") of the file system, of module files in the cache directory above which the least recently used are evicted down to -cache-low-watermark")
+	fs.StringVar(&cfg.CacheLowWatermark, "cache-low-watermark", cfg.CacheLowWatermark, "total size in bytes, or percentage of the file system, of module files in the cache directory down to which they are evicted once -cache-high-watermark is exceeded (empty means -cache-high-watermark)")
+	fs.DurationVar(&cfg.CacheCleanupInterval, "cache-cleanup-interval", cfg.CacheCleanupInterval, "interval between evictions of module files in the cache directory when -cache-max-age, -cache-max-size, or -cache-high-watermark is set")
+	fs.BoolVar(&cfg.VerifyCache, "verify-cache", cfg.VerifyCache, "verify the integrity of the module files in the cache directory, print a summary, and exit with a non-zero status if any are corrupt, instead of serving")
+	fs.IntVar(&cfg.VerifyConcurrency, "verify-concurrency", cfg.VerifyConcurrency, "maximum number of module versions verified concurrently by -verify-cache")
+	fs.BoolVar(&cfg.VerifyDeleteCorrupt, "verify-delete-corrupt", cfg.VerifyDeleteCorrupt, "delete the corrupt module files found by -verify-cache so that they are fetched again")
+	fs.StringVar(&cfg.TempDir, "temp-dir", cfg.TempDir, "directory for storing temporary files")
+	fs.BoolVar(&cfg.Insecure, "insecure", cfg.Insecure, "allow insecure TLS connections")
+	fs.StringVar(&cfg.InsecureHosts, "insecure-hosts", cfg.InsecureHosts, "comma-separated list of glob patterns, in the same form as GOINSECURE but without paths, of hosts to which insecure TLS connections are allowed")
+	fs.StringVar(&cfg.SOCKS5, "socks5", cfg.SOCKS5, "address or URL (socks5://[user:password@]host:port) of a SOCKS5 proxy through which outgoing connections, including those of the go command, are made with hostnames resolved by the proxy")
+	fs.StringVar(&cfg.UserAgent, "user-agent", cfg.UserAgent, "User-Agent header (empty means the Go default) of outgoing requests other than those of the go command")
+	fs.Var(&cfg.Header, "header", "static header in the form \"<name>: <value>\" set on outgoing requests other than those of the go command (can be repeated)")
+	fs.DurationVar(&cfg.ConnectTimeout, "connect-timeout", cfg.ConnectTimeout, "maximum amount of time (0 means no limit) will wait for an outgoing connection to establish")
+	fs.DurationVar(&cfg.FallbackDelay, "fallback-delay", cfg.FallbackDelay, "how long (0 means 300ms, negative means disabled) an outgoing connection to a dual-stack host waits for the preferred address family before racing the other one")
+	fs.IntVar(&cfg.MaxIdleConns, "max-idle-conns", cfg.MaxIdleConns, "maximum number (0 means no limit) of idle outgoing connections kept alive across all hosts")
+	fs.IntVar(&cfg.MaxIdleConnsPerHost, "max-idle-conns-per-host", cfg.MaxIdleConnsPerHost, "maximum number (0 means 2) of idle outgoing connections kept alive to each host")
+	fs.DurationVar(&cfg.IdleConnTimeout, "idle-conn-timeout", cfg.IdleConnTimeout, "how long (0 means forever) an idle outgoing connection is kept alive before it is closed")
+	fs.StringVar(&cfg.IPVersion, "ip-version", cfg.IPVersion, "IP version (\"4\" or \"6\", empty means both) of outgoing connections, excluding the direct connections of the go command")
+	fs.DurationVar(&cfg.DNSCacheTTL, "dns-cache-ttl", cfg.DNSCacheTTL, "how long (0 means disabled) resolved addresses of hosts are cached in process for outgoing connections other than those of the go command and those made via -socks5")
+	fs.DurationVar(&cfg.DNSCacheNegativeTTL, "dns-cache-negative-ttl", cfg.DNSCacheNegativeTTL, "how long (0 means disabled) hosts not found are cached when -dns-cache-ttl is enabled")
+	fs.DurationVar(&cfg.FetchTimeout, "fetch-timeout", cfg.FetchTimeout, "maximum amount of time (0 means no limit) will wait for a fetch to complete")
+	fs.DurationVar(&cfg.ListFetchTimeout, "list-fetch-timeout", cfg.ListFetchTimeout, "maximum amount of time (0 means -fetch-timeout) will wait for a version list, latest version, or version query fetch to complete")
+	fs.DurationVar(&cfg.InfoFetchTimeout, "info-fetch-timeout", cfg.InfoFetchTimeout, "maximum amount of time (0 means -fetch-timeout) will wait for a version info fetch to complete")
+	fs.DurationVar(&cfg.ModFetchTimeout, "mod-fetch-timeout", cfg.ModFetchTimeout, "maximum amount of time (0 means -fetch-timeout) will wait for a mod file fetch to complete")
+	fs.DurationVar(&cfg.ZipFetchTimeout, "zip-fetch-timeout", cfg.ZipFetchTimeout, "maximum amount of time (0 means -fetch-timeout) will wait for a zip file fetch to complete")
+	fs.DurationVar(&cfg.NotFoundTTL, "not-found-ttl", cfg.NotFoundTTL, "how long (0 means disabled) not found results of module downloads are cached")
+	fs.DurationVar(&cfg.NotFoundQueryTTL, "not-found-query-ttl", cfg.NotFoundQueryTTL, "how long (0 means disabled) not found results of module queries and version lists are cached")
+	fs.StringVar(&cfg.MetricsPath, "metrics-path", cfg.MetricsPath, "request path (empty means disabled) for serving Prometheus metrics")
+	fs.StringVar(&cfg.HealthPath, "health-path", cfg.HealthPath, "request path (empty means disabled) for serving liveness checks")
+	fs.StringVar(&cfg.ReadinessPath, "readiness-path", cfg.ReadinessPath, "request path (empty means disabled) for serving readiness checks")
+	fs.StringVar(&cfg.ReadinessUpstreams, "readiness-upstreams", cfg.ReadinessUpstreams, "comma-separated list of URLs that readiness checks require to be reachable")
+	fs.StringVar(&cfg.AdminPath, "admin-path", cfg.AdminPath, "request path prefix for serving the admin API when -admin-token is set")
+	fs.StringVar(&cfg.AdminToken, "admin-token", cfg.AdminToken, "bearer token (empty means disabled) required by the admin API")
+	fs.StringVar(&cfg.AuthTokens, "auth-tokens-file", cfg.AuthTokens, "path to a file of bearer tokens (empty means no authentication), one \"<token> <identity>\" per line, one of which is required by module and checksum database requests")
+	fs.BoolVar(&cfg.AuthHealthMetrics, "auth-health-and-metrics", cfg.AuthHealthMetrics, "require -auth-tokens-file tokens for serving metrics, liveness checks, and readiness checks as well")
+	fs.StringVar(&cfg.VanityImports, "vanity-imports-file", cfg.VanityImports, "path to a file of vanity import paths (empty means disabled), one \"<prefix> <vcs> <repo-root> [<home> [<directory> <file>]]\" per line, for which go-import meta tags are served")
+	fs.StringVar(&cfg.WebhookURL, "webhook-url", cfg.WebhookURL, "URL (empty means disabled) to which a JSON notification is POSTed when a module zip file is cached for the first time")
+	fs.StringVar(&cfg.WebhookSecret, "webhook-secret", cfg.WebhookSecret, "secret (empty means unsigned) for signing the payloads POSTed to -webhook-url with HMAC-SHA256")
+	fs.StringVar(&cfg.PprofAddress, "pprof-address", cfg.PprofAddress, "TCP address (empty means disabled) that a separate HTTP server serving net/http/pprof profiles listens on, which should not be publicly reachable")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", cfg.ShutdownTimeout, "maximum amount of time (0 means no limit) will wait for in-flight requests to complete when shutting down")
+	fs.StringVar(&cfg.LogFormat, "log-format", cfg.LogFormat, "format of the logs (text or json)")
+	fs.StringVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "minimum level of the logs (debug, info, warn, or error; info logs every request)")
+	fs.BoolVar(&cfg.DebugRequests, "debug-requests", cfg.DebugRequests, "log every request in detail at debug level, with credentials redacted, implying -log-level debug (a single request can be logged so by sending the -admin-token in the X-Goproxy-Debug header with -log-level debug)")
+}
+
+// loadFile loads the YAML or JSON configuration file into the cfg. Only the
+// keys present in the file are changed. Unknown keys are reported as errors so
+// that typos are not silently ignored.
+func (cfg *Config) loadFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// YAML is a superset of JSON, so both are decoded the same way.
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// policy returns the [goproxy.Policy] of the cfg, reading the approved versions,
+// latest policies, and quotas files, if any.
+func (cfg *Config) policy() (goproxy.Policy, error) {
+	var p goproxy.Policy
+	if cfg.Allow != "" {
+		p.AllowedModulePatterns = strings.Split(cfg.Allow, ",")
+	}
+	if cfg.Block != "" {
+		p.BlockedModulePatterns = strings.Split(cfg.Block, ",")
+	}
+	if cfg.ApprovedVersions != "" {
+		data, err := os.ReadFile(cfg.ApprovedVersions)
+		if err != nil {
+			return goproxy.Policy{}, fmt.Errorf("invalid -approved-versions-file %q: %v", cfg.ApprovedVersions, err)
+		}
+		if p.ApprovedVersions, err = goproxy.ParseApprovedVersions(data); err != nil {
+			return goproxy.Policy{}, fmt.Errorf("invalid -approved-versions-file %q: %v", cfg.ApprovedVersions, err)
+		}
+	}
+	if cfg.LatestPolicies != "" {
+		data, err := os.ReadFile(cfg.LatestPolicies)
+		if err != nil {
+			return goproxy.Policy{}, fmt.Errorf("invalid -latest-policies-file %q: %v", cfg.LatestPolicies, err)
+		}
+		if p.LatestVersionPolicies, err = goproxy.ParseLatestVersionPolicies(data); err != nil {
+			return goproxy.Policy{}, fmt.Errorf("invalid -latest-policies-file %q: %v", cfg.LatestPolicies, err)
+		}
+	}
+	if cfg.Quotas != "" {
+		data, err := os.ReadFile(cfg.Quotas)
+		if err != nil {
+			return goproxy.Policy{}, fmt.Errorf("invalid -quotas-file %q: %v", cfg.Quotas, err)
+		}
+		if p.Quotas, err = goproxy.ParseQuotas(data); err != nil {
+			return goproxy.Policy{}, fmt.Errorf("invalid -quotas-file %q: %v", cfg.Quotas, err)
+		}
+	}
+	p.RateLimit = cfg.RateLimit
+	p.RateBurst = cfg.RateBurst
+	return p, nil
+}
+
+// authenticator returns the [goproxy.Authenticator] of the cfg, reading the
+// bearer tokens file, or nil if there is none. Empty lines and lines starting
+// with "#" in the file are ignored.
+func (cfg *Config) authenticator() (goproxy.Authenticator, error) {
+	if cfg.AuthTokens == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(cfg.AuthTokens)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -auth-tokens-file %q: %v", cfg.AuthTokens, err)
+	}
+	bta := goproxy.BearerTokenAuthenticator{}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid -auth-tokens-file %q: line %d: want \"<token> <identity>\"", cfg.AuthTokens, i+1)
+		}
+		bta[fields[0]] = fields[1]
+	}
+	return bta, nil
+}
+
+// vanityImports returns the [goproxy.VanityImport]s of the cfg, reading the
+// vanity imports file, or nil if there is none. Empty lines and lines starting
+// with "#" in the file are ignored.
+func (cfg *Config) vanityImports() (map[string]goproxy.VanityImport, error) {
+	if cfg.VanityImports == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(cfg.VanityImports)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -vanity-imports-file %q: %v", cfg.VanityImports, err)
+	}
+	vanityImports := map[string]goproxy.VanityImport{}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if l := len(fields); l != 3 && l != 4 && l != 6 {
+			return nil, fmt.Errorf("invalid -vanity-imports-file %q: line %d: want \"<prefix> <vcs> <repo-root> [<home> [<directory> <file>]]\"", cfg.VanityImports, i+1)
+		}
+		fields = append(fields, "", "", "")
+		vanityImports[fields[0]] = goproxy.VanityImport{
+			VCS:       fields[1],
+			RepoRoot:  fields[2],
+			Home:      fields[3],
+			Directory: fields[4],
+			File:      fields[5],
+		}
+	}
+	return vanityImports, nil
+}
+
+// stringsFlag is a [flag.Value] that collects the values of a flag repeated on
+// the command line. In configuration files, it is a list of strings.
+type stringsFlag []string
+
+// String implements [flag.Value].
+func (sf *stringsFlag) String() string {
+	if sf == nil {
+		return ""
+	}
+	return strings.Join(*sf, ", ")
+}
+
+// Set implements [flag.Value].
+func (sf *stringsFlag) Set(s string) error {
+	*sf = append(*sf, s)
+	return nil
+}
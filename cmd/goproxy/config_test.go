@@ -0,0 +1,114 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConfig(t *testing.T) {
+	for _, tt := range []struct {
+		n                   int
+		configFile          string
+		config              string
+		args                []string
+		wantErr             string
+		wantAddress         string
+		wantUpstreamProxies string
+		wantFetchTimeout    time.Duration
+	}{
+		{
+			n:                1,
+			wantAddress:      "localhost:8080",
+			wantFetchTimeout: 10 * time.Minute,
+		},
+		{
+			n:                   2,
+			configFile:          "config.yaml",
+			config:              "address: :8081\nupstream-proxies: https://proxy.example.com\nfetch-timeout: 1m\n",
+			wantAddress:         ":8081",
+			wantUpstreamProxies: "https://proxy.example.com",
+			wantFetchTimeout:    time.Minute,
+		},
+		{
+			n:                   3,
+			configFile:          "config.json",
+			config:              `{"address": ":8082", "upstream-proxies": "https://proxy.example.com"}`,
+			wantAddress:         ":8082",
+			wantUpstreamProxies: "https://proxy.example.com",
+			wantFetchTimeout:    10 * time.Minute,
+		},
+		{
+			n:                   4,
+			configFile:          "config.yaml",
+			config:              "address: :8081\nupstream-proxies: https://proxy.example.com\n",
+			args:                []string{"-address", ":8083", "-upstream-proxies", "direct"},
+			wantAddress:         ":8083",
+			wantUpstreamProxies: "direct",
+			wantFetchTimeout:    10 * time.Minute,
+		},
+		{
+			n:                5,
+			configFile:       "config.yaml",
+			config:           "",
+			wantAddress:      "localhost:8080",
+			wantFetchTimeout: 10 * time.Minute,
+		},
+		{
+			n:          6,
+			configFile: "config.yaml",
+			config:     "adress: :8081\n",
+			wantErr:    "field adress not found",
+		},
+		{
+			n:          7,
+			configFile: "config.yaml",
+			config:     "fetch-timeout: soon\n",
+			wantErr:    "cannot unmarshal",
+		},
+		{
+			n:          8,
+			configFile: "nonexistent.yaml",
+			wantErr:    "nonexistent.yaml",
+		},
+	} {
+		args := tt.args
+		if tt.configFile != "" {
+			configFile := filepath.Join(t.TempDir(), tt.configFile)
+			if tt.configFile != "nonexistent.yaml" {
+				if err := os.WriteFile(configFile, []byte(tt.config), 0o644); err != nil {
+					t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+				}
+			}
+			args = append([]string{"-config", configFile}, args...)
+		}
+		fs := flag.NewFlagSet("goproxy", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		cfg, _, err := parseConfig(fs, args)
+		if tt.wantErr != "" {
+			if err == nil {
+				t.Fatalf("test(%d): expected error", tt.n)
+			}
+			if got, want := err.Error(), tt.wantErr; !strings.Contains(got, want) {
+				t.Errorf("test(%d): got %q, want contains %q", tt.n, got, want)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		}
+		if got, want := cfg.Address, tt.wantAddress; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		if got, want := cfg.UpstreamProxies, tt.wantUpstreamProxies; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		if got, want := cfg.FetchTimeout, tt.wantFetchTimeout; got != want {
+			t.Errorf("test(%d): got %v, want %v", tt.n, got, want)
+		}
+	}
+}
@@ -1,79 +1,1656 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"flag"
-	"log"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"log/slog"
 	"net"
 	"net/http"
+	"net/http/pprof"
+	"net/netip"
+	"net/url"
 	"os"
+	"os/signal"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/goproxy/goproxy"
-)
-
-var (
-	address          = flag.String("address", "localhost:8080", "TCP address that the HTTP server listens on")
-	tlsCertFile      = flag.String("tls-cert-file", "", "path to the TLS certificate file")
-	tlsKeyFile       = flag.String("tls-key-file", "", "path to the TLS key file")
-	pathPrefix       = flag.String("path-prefix", "", "prefix for all request paths")
-	goBinName        = flag.String("go-bin-name", "go", "name of the Go binary that is used to execute direct fetches")
-	maxDirectFetches = flag.Int("max-direct-fetches", 0, "maximum number (0 means no limit) of concurrent direct fetches")
-	proxiedSUMDBs    = flag.String("proxied-sumdbs", "", "comma-separated list of proxied checksum databases")
-	cacheDir         = flag.String("cache-dir", "caches", "directory that used to cache module files")
-	tempDir          = flag.String("temp-dir", os.TempDir(), "directory for storing temporary files")
-	insecure         = flag.Bool("insecure", false, "allow insecure TLS connections")
-	connectTimeout   = flag.Duration("connect-timeout", 30*time.Second, "maximum amount of time (0 means no limit) will wait for an outgoing connection to establish")
-	fetchTimeout     = flag.Duration("fetch-timeout", 10*time.Minute, "maximum amount of time (0 means no limit) will wait for a fetch to complete")
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/mod/module"
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"golang.org/x/net/proxy"
 )
 
 func main() {
-	flag.Parse()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "export":
+			os.Exit(runExport(os.Args[2:]))
+		case "import":
+			os.Exit(runImport(os.Args[2:]))
+		}
+	}
+
+	cfg, _, err := parseConfig(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	var logLevel slog.Level
+	if err := logLevel.UnmarshalText([]byte(cfg.LogLevel)); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -log-level %q\n", cfg.LogLevel)
+		os.Exit(2)
+	}
+	if cfg.DebugRequests && logLevel > slog.LevelDebug {
+		logLevel = slog.LevelDebug
+	}
+	var logHandler slog.Handler
+	logHandlerOptions := &slog.HandlerOptions{Level: logLevel}
+	switch cfg.LogFormat {
+	case "text":
+		logHandler = slog.NewTextHandler(os.Stderr, logHandlerOptions)
+	case "json":
+		logHandler = slog.NewJSONHandler(os.Stderr, logHandlerOptions)
+	default:
+		fmt.Fprintf(os.Stderr, "invalid -log-format %q\n", cfg.LogFormat)
+		os.Exit(2)
+	}
+	logger := slog.New(logHandler)
+
+	// ctx is the root context, which is canceled when a SIGINT or SIGTERM
+	// is received. Background goroutines must stop when it is done.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if cfg.VerifyCache {
+		corrupt, err := verifyCacheDir(ctx, os.Stdout, goproxy.DirCacher(cfg.CacheDir), cfg.VerifyConcurrency, cfg.VerifyDeleteCorrupt)
+		if err != nil {
+			logger.Error("failed to verify cache directory", "error", err)
+			os.Exit(1)
+		}
+		if corrupt {
+			os.Exit(1)
+		}
+		return
+	}
+
+	g, server, err := cfg.build(logger)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := g.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration: %v\n", err)
+		os.Exit(1)
+	}
+	if g.DirectFetchEnabled() {
+		// The version was already detected by Validate and is
+		// remembered, so this never runs the go binary again.
+		goVersion, err := g.GoVersion(ctx)
+		if err != nil {
+			logger.Error("failed to check go binary", "error", err)
+			os.Exit(1)
+		}
+		logger.Info("detected go binary", "go_bin_name", cfg.GoBinName, "go_version", goVersion)
+	}
+
+	policyReloader, err := newPolicyReloader(os.Args[1:], g)
+	if err != nil {
+		logger.Error("failed to load policy", "error", err)
+		os.Exit(1)
+	}
+	go policyReloader.watch(ctx, logger, cfg.PolicyReload)
+
+	if cfg.CacheMaxSize > 0 && (cfg.CacheHighWatermark != "" || cfg.CacheLowWatermark != "") {
+		fmt.Fprintln(os.Stderr, "-cache-max-size is mutually exclusive with -cache-high-watermark and -cache-low-watermark")
+		os.Exit(2)
+	}
+	highWatermark := cacheWatermark{size: cfg.CacheMaxSize}
+	lowWatermark := highWatermark
+	if cfg.CacheHighWatermark != "" {
+		if highWatermark, err = parseCacheWatermark(cfg.CacheHighWatermark); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -cache-high-watermark: %v\n", err)
+			os.Exit(2)
+		}
+		lowWatermark = highWatermark
+	}
+	if cfg.CacheLowWatermark != "" {
+		if cfg.CacheHighWatermark == "" {
+			fmt.Fprintln(os.Stderr, "-cache-low-watermark requires -cache-high-watermark")
+			os.Exit(2)
+		}
+		if lowWatermark, err = parseCacheWatermark(cfg.CacheLowWatermark); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -cache-low-watermark: %v\n", err)
+			os.Exit(2)
+		}
+	}
+	notFoundMaxAge := max(cfg.NotFoundTTL, cfg.NotFoundQueryTTL)
+	if (cfg.CacheMaxAge > 0 || !highWatermark.isZero() || notFoundMaxAge > 0) && cfg.CacheCleanupInterval > 0 && !cfg.CacheReadOnly {
+		go cleanCacheDir(ctx, logger, g, goproxy.DirCacher(cfg.CacheDir), cfg.CacheCleanupInterval, cfg.CacheMaxAge, notFoundMaxAge, highWatermark, lowWatermark)
+	}
+
+	socketMode, err := strconv.ParseUint(cfg.UnixSocketMode, 8, 32)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -unix-socket-mode %q\n", cfg.UnixSocketMode)
+		os.Exit(2)
+	}
+	useTLS := cfg.TLSCertFile != "" && cfg.TLSKeyFile != ""
+	useAutocert := cfg.AutocertDomains != ""
+	if useAutocert && (cfg.TLSCertFile != "" || cfg.TLSKeyFile != "") {
+		fmt.Fprintln(os.Stderr, "-autocert-domains is mutually exclusive with -tls-cert-file and -tls-key-file")
+		os.Exit(2)
+	}
+	if (useTLS || useAutocert) && strings.HasPrefix(cfg.Address, "unix:") {
+		fmt.Fprintln(os.Stderr, "TLS is not supported when -address is a Unix domain socket")
+		os.Exit(2)
+	}
+	if cfg.H2C && (useTLS || useAutocert) {
+		fmt.Fprintln(os.Stderr, "-h2c is mutually exclusive with -tls-cert-file and -tls-key-file, and -autocert-domains")
+		os.Exit(2)
+	}
+	if cfg.ClientCAFile != "" && !useTLS && !useAutocert {
+		fmt.Fprintln(os.Stderr, "-client-ca-file requires -tls-cert-file and -tls-key-file, or -autocert-domains")
+		os.Exit(2)
+	}
+	var clientCAs *x509.CertPool
+	if cfg.ClientCAFile != "" {
+		clientCAs, err = loadCertPool(cfg.ClientCAFile)
+		if err != nil {
+			logger.Error("failed to load client ca certificates", "error", err)
+			os.Exit(1)
+		}
+	}
+	var certReloader *tlsCertReloader
+	if useTLS {
+		certReloader, err = newTLSCertReloader(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			logger.Error("failed to load tls certificate", "error", err)
+			os.Exit(1)
+		}
+		if cfg.TLSReloadInterval > 0 {
+			go certReloader.watch(ctx, logger, cfg.TLSReloadInterval)
+		}
+	}
+	// The listener, and so the Unix domain socket file, if any, is closed
+	// and removed when the server is shut down.
+	listener, err := listen(cfg.Address, os.FileMode(socketMode))
+	if err != nil {
+		logger.Error("failed to listen", "address", cfg.Address, "error", err)
+		os.Exit(1)
+	}
+	if cfg.ProxyProtocol {
+		listener = proxyProtocolListener{Listener: listener}
+	}
+
+	if useTLS {
+		server.TLSConfig = &tls.Config{GetCertificate: certReloader.getCertificate}
+	}
+	// The main, autocert, and pprof servers each send at most one error, so
+	// none of them blocks forever once the first one has been received.
+	serverErr := make(chan error, 3)
 
+	// The autocert HTTP server serves ACME HTTP-01 challenges and redirects
+	// all other requests to HTTPS.
+	var autocertServer *http.Server
+	if useAutocert {
+		var domains []string
+		for _, domain := range strings.Split(cfg.AutocertDomains, ",") {
+			domains = append(domains, strings.TrimSpace(domain))
+		}
+		autocertManager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(domains...),
+			Cache:      autocert.DirCache(cfg.AutocertCacheDir),
+		}
+		server.TLSConfig = autocertManager.TLSConfig()
+		autocertServer = &http.Server{Addr: cfg.AutocertHTTPAddress, Handler: autocertManager.HTTPHandler(nil)}
+		go func() {
+			if err := autocertServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				serverErr <- err
+			}
+		}()
+	}
+	if clientCAs != nil {
+		server.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		server.TLSConfig.ClientCAs = clientCAs
+	}
+
+	// The pprof HTTP server is kept separate from the main one, so the
+	// profiles are never exposed on the public address.
+	var pprofServer *http.Server
+	if cfg.PprofAddress != "" {
+		pprofServer = &http.Server{Addr: cfg.PprofAddress, Handler: newPprofHandler()}
+		go func() {
+			if err := pprofServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				serverErr <- err
+			}
+		}()
+	}
+
+	go func() {
+		if useTLS || useAutocert {
+			serverErr <- server.ServeTLS(listener, "", "")
+		} else {
+			serverErr <- server.Serve(listener)
+		}
+	}()
+	select {
+	case err := <-serverErr:
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("http server error", "error", err)
+		}
+		return
+	case <-ctx.Done():
+	}
+
+	// Restore the default behavior so that a second signal terminates the
+	// process immediately.
+	stop()
+
+	logger.Info("shutting down http server", "timeout", cfg.ShutdownTimeout)
+	shutdownCtx := context.Background()
+	if cfg.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		shutdownCtx, cancel = context.WithTimeout(shutdownCtx, cfg.ShutdownTimeout)
+		defer cancel()
+	}
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Error("failed to shut down http server gracefully", "error", err)
+		server.Close()
+	}
+	if autocertServer != nil {
+		if err := autocertServer.Shutdown(shutdownCtx); err != nil {
+			autocertServer.Close()
+		}
+	}
+	if err := g.Flush(shutdownCtx); err != nil {
+		logger.Error("failed to flush webhook deliveries", "error", err)
+	}
+	if pprofServer != nil {
+		pprofServer.Close()
+	}
+}
+
+// restrictIPVersion returns a [net.Dialer.ControlContext] that rejects every
+// connection whose network is not the network, so that the dialer moves on to
+// the next address of a host until one of the wanted IP version is found.
+func restrictIPVersion(network string) func(ctx context.Context, network, address string, c syscall.RawConn) error {
+	want := network
+	return func(_ context.Context, network, _ string, _ syscall.RawConn) error {
+		if network != want {
+			return fmt.Errorf("%s disabled by -ip-version", network)
+		}
+		return nil
+	}
+}
+
+// newPprofHandler returns an [http.Handler] that serves the net/http/pprof
+// profiles under "/debug/pprof/".
+func newPprofHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+// build builds a [goproxy.Goproxy] and an [http.Server] serving it with the
+// other handlers enabled by the cfg. The logger is used as the
+// [goproxy.Goproxy.Logger].
+func (cfg *Config) build(logger *slog.Logger) (*goproxy.Goproxy, *http.Server, error) {
+	env := os.Environ()
+	for i, goEnv := range cfg.GoEnv {
+		if key, _, ok := strings.Cut(goEnv, "="); !ok || strings.TrimSpace(key) == "" {
+			return nil, nil, fmt.Errorf("invalid -go-env #%d: want the form \"<key>=<value>\"", i+1)
+		}
+		env = append(env, goEnv)
+	}
+	dialer := &net.Dialer{Timeout: cfg.ConnectTimeout, KeepAlive: 30 * time.Second, FallbackDelay: cfg.FallbackDelay}
+	switch cfg.IPVersion {
+	case "":
+	case "4", "6":
+		dialer.ControlContext = restrictIPVersion("tcp" + cfg.IPVersion)
+	default:
+		return nil, nil, fmt.Errorf("invalid -ip-version %q: want \"4\" or \"6\"", cfg.IPVersion)
+	}
 	transport := http.DefaultTransport.(*http.Transport).Clone()
-	transport.DialContext = (&net.Dialer{Timeout: *connectTimeout, KeepAlive: 30 * time.Second}).DialContext
-	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: *insecure}
+	transport.DialContext = dialer.DialContext
+	if cfg.MaxIdleConns < 0 {
+		return nil, nil, fmt.Errorf("invalid -max-idle-conns %d", cfg.MaxIdleConns)
+	}
+	if cfg.MaxIdleConnsPerHost < 0 {
+		return nil, nil, fmt.Errorf("invalid -max-idle-conns-per-host %d", cfg.MaxIdleConnsPerHost)
+	}
+	transport.MaxIdleConns = cfg.MaxIdleConns
+	transport.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+	transport.IdleConnTimeout = cfg.IdleConnTimeout
+	if cfg.DNSCacheTTL > 0 {
+		transport.DialContext = newDNSCache(cfg.DNSCacheTTL, cfg.DNSCacheNegativeTTL, cfg.ConnectTimeout).dialContext(dialer)
+	}
+	if cfg.SOCKS5 != "" {
+		dialContext, socks5URL, err := newSOCKS5Dialer(cfg.SOCKS5, dialer)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid -socks5 %q: %v", cfg.SOCKS5, err)
+		}
+		transport.DialContext = dialContext
+		transport.Proxy = nil
+		for _, key := range []string{"ALL_PROXY", "all_proxy", "HTTPS_PROXY", "https_proxy", "HTTP_PROXY", "http_proxy"} {
+			env = append(env, key+"="+socks5URL)
+		}
+	}
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: cfg.Insecure}
+	if cfg.InsecureHosts != "" && !cfg.Insecure {
+		insecureHosts, err := parseInsecureHosts(cfg.InsecureHosts)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid -insecure-hosts: %v", err)
+		}
+		transport.DialTLSContext = dialTLSWithInsecureHosts(transport.DialContext, insecureHosts)
+	}
 	transport.RegisterProtocol("file", http.NewFileTransport(httpDirFS{}))
+	if cfg.UpstreamProxies != "" {
+		env = append(env, "GOPROXY="+cfg.UpstreamProxies)
+	}
+	proxiedSUMDBs := strings.Split(cfg.ProxiedSUMDBs, ",")
+	if cfg.Mirror != "" {
+		if cfg.UpstreamProxies != "" {
+			return nil, nil, errors.New("-mirror is mutually exclusive with -upstream-proxies")
+		}
+		mirrorURL, err := url.Parse(cfg.Mirror)
+		if err != nil || (mirrorURL.Scheme != "http" && mirrorURL.Scheme != "https") || mirrorURL.Host == "" {
+			return nil, nil, fmt.Errorf("invalid -mirror %q: want an http or https URL", cfg.Mirror)
+		}
+		mirror := strings.TrimSuffix(mirrorURL.String(), "/")
+		env = append(env, "GOPROXY="+mirror, "GONOPROXY=none")
+		if cfg.ProxiedSUMDBs == "" {
+			proxiedSUMDBs = []string{"sum.golang.org"}
+		}
+		for i, proxiedSUMDB := range proxiedSUMDBs {
+			if name := strings.TrimSpace(proxiedSUMDB); name != "" && !strings.ContainsAny(name, " \t") {
+				proxiedSUMDBs[i] = name + " " + mirror + "/sumdb/" + name
+			}
+		}
+	}
+	switch cfg.CacheCompression {
+	case "", "gzip":
+	default:
+		return nil, nil, fmt.Errorf("invalid -cache-compression %q", cfg.CacheCompression)
+	}
+	var cacher goproxy.Cacher = goproxy.DirCacher(cfg.CacheDir)
+	if cfg.CacheReadOnly {
+		if cfg.CacheDedup {
+			return nil, nil, errors.New("-cache-read-only is mutually exclusive with -cache-dedup")
+		}
+		if cfg.CacheCompression != "" {
+			return nil, nil, errors.New("-cache-read-only is mutually exclusive with -cache-compression")
+		}
+		cacher = goproxy.FSCacher{FS: os.DirFS(cfg.CacheDir)}
+	} else if cfg.CacheDedup {
+		cacher = goproxy.DedupDirCacher{DirCacher: goproxy.DirCacher(cfg.CacheDir), Compression: cfg.CacheCompression}
+	} else if cfg.CacheCompression != "" {
+		cacher = goproxy.CompressedDirCacher{DirCacher: goproxy.DirCacher(cfg.CacheDir), Compression: cfg.CacheCompression}
+	}
+	if cfg.FallbackCacheDir != "" {
+		if cfg.CacheReadOnly {
+			return nil, nil, errors.New("-cache-read-only is mutually exclusive with -fallback-cache-dir")
+		}
+		cacher = &goproxy.TieredCacher{Tiers: []goproxy.Cacher{cacher, goproxy.DirCacher(cfg.FallbackCacheDir)}}
+	}
+	if cfg.GoModCache != "" {
+		goModCache, err := filepath.Abs(cfg.GoModCache)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid -gomodcache %q: %v", cfg.GoModCache, err)
+		}
+		env = append(env, "GOMODCACHE="+goModCache)
+		if cfg.ServeGoModCache {
+			if cfg.CacheReadOnly {
+				return nil, nil, errors.New("-cache-read-only is mutually exclusive with -serve-gomodcache")
+			}
+			if tc, ok := cacher.(*goproxy.TieredCacher); ok {
+				tc.Tiers = append(tc.Tiers, goproxy.ModCacheCacher(goModCache))
+			} else {
+				cacher = &goproxy.TieredCacher{Tiers: []goproxy.Cacher{cacher, goproxy.ModCacheCacher(goModCache)}}
+			}
+		}
+	} else if cfg.ServeGoModCache {
+		return nil, nil, errors.New("-serve-gomodcache requires -gomodcache")
+	}
+	if cfg.Netrc != "" {
+		netrcFile, err := filepath.Abs(cfg.Netrc)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid -netrc %q: %v", cfg.Netrc, err)
+		}
+		env = append(env, "NETRC="+netrcFile)
+	}
+	goWorkers := cfg.GoWorkers
+	if goWorkers == 0 {
+		goWorkers = cfg.MaxDirectFetches
+	}
 	g := &goproxy.Goproxy{
-		GoBinName:        *goBinName,
-		MaxDirectFetches: *maxDirectFetches,
-		ProxiedSUMDBs:    strings.Split(*proxiedSUMDBs, ","),
-		Cacher:           goproxy.DirCacher(*cacheDir),
-		TempDir:          *tempDir,
-		Transport:        transport,
+		Env:                     env,
+		GoBinName:               cfg.GoBinName,
+		MinGoVersion:            cfg.MinGoVersion,
+		MaxDirectFetches:        goWorkers,
+		MaxDirectDownloads:      cfg.MaxDirectDownloads,
+		SerializeModuleFetches:  cfg.SerializeFetches,
+		FetchRetries:            cfg.FetchRetries,
+		FetchRetryBackoff:       cfg.FetchRetryBackoff,
+		FetchTimeout:            cfg.FetchTimeout,
+		ListFetchTimeout:        cfg.ListFetchTimeout,
+		InfoFetchTimeout:        cfg.InfoFetchTimeout,
+		ModFetchTimeout:         cfg.ModFetchTimeout,
+		ZipFetchTimeout:         cfg.ZipFetchTimeout,
+		CircuitBreakerThreshold: cfg.BreakerThreshold,
+		CircuitBreakerWindow:    cfg.BreakerWindow,
+		CircuitBreakerCooldown:  cfg.BreakerCooldown,
+		MaxZipFileSize:          cfg.MaxZipSize,
+		ProxiedSUMDBs:           proxiedSUMDBs,
+		Offline:                 cfg.Offline || cfg.CacheReadOnly,
+		DisableDirectFetch:      cfg.NoDirect,
+		PathPrefix:              cfg.PathPrefix,
+		DisableStaleOnError:     cfg.NoStaleOnError,
+		MaxStaleAge:             cfg.MaxStaleAge,
+		UpstreamErrorStatus:     cfg.UpstreamErrorStatus,
+		ListCacheTTL:            cfg.ListCacheTTL,
+		FilterRetractedVersions: cfg.FilterRetracted,
+		MaxConcurrentRequests:   cfg.MaxRequests,
+		MaxRequestQueueWait:     cfg.MaxRequestQueueWait,
+		LoadShedQueueWait:       cfg.LoadShedQueueWait,
+		LoadShedGoroutines:      cfg.LoadShedGoroutines,
+		LoadShedHeapBytes:       cfg.LoadShedHeapBytes,
+		GzipMinSize:             cfg.GzipMinSize,
+		DownloadCacheControl:    cfg.DownloadCacheControl,
+		QueryCacheControl:       cfg.QueryCacheControl,
+		Cacher:                  cacher,
+		NotFoundTTL:             cfg.NotFoundTTL,
+		NotFoundQueryTTL:        cfg.NotFoundQueryTTL,
+		TempDir:                 cfg.TempDir,
+		Transport:               transport,
+		UseNetrc:                true,
+		UserAgent:               cfg.UserAgent,
+		AdminToken:              cfg.AdminToken,
+		DebugRequests:           cfg.DebugRequests,
+		WebhookURL:              cfg.WebhookURL,
+		WebhookSecret:           cfg.WebhookSecret,
+		Logger:                  logger,
+	}
+	if cfg.StartInMaintenance {
+		g.SetMaintenance(true)
+	}
+	if len(cfg.Header) > 0 {
+		g.RequestHeader = http.Header{}
+		for _, header := range cfg.Header {
+			name, value, ok := strings.Cut(header, ":")
+			name = strings.TrimSpace(name)
+			if !ok || name == "" || strings.ContainsAny(name, " \t") {
+				return nil, nil, fmt.Errorf("invalid -header %q", header)
+			}
+			g.RequestHeader.Add(name, strings.TrimSpace(value))
+		}
+	}
+	if cfg.ProxiedSUMDBsTLS != "" {
+		g.SUMDBTransports = map[string]http.RoundTripper{}
+		for _, entry := range strings.Split(cfg.ProxiedSUMDBsTLS, ",") {
+			fields := strings.Fields(entry)
+			if len(fields) == 0 {
+				continue
+			}
+			if len(fields) != 3 && len(fields) != 4 {
+				return nil, nil, fmt.Errorf("invalid -proxied-sumdbs-tls entry %q", entry)
+			}
+			var caFile string
+			if len(fields) == 4 {
+				caFile = fields[3]
+			}
+			tlsConfig, err := newClientTLSConfig(fields[1], fields[2], caFile)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid -proxied-sumdbs-tls entry %q: %v", entry, err)
+			}
+			sumdbTransport := transport.Clone()
+			sumdbTransport.DialTLSContext = nil
+			sumdbTransport.TLSClientConfig = tlsConfig
+			g.SUMDBTransports[fields[0]] = sumdbTransport
+		}
+	}
+	policy, err := cfg.policy()
+	if err != nil {
+		return nil, nil, err
+	}
+	authenticator, err := cfg.authenticator()
+	if err != nil {
+		return nil, nil, err
+	}
+	if authenticator != nil {
+		g.Authenticator = authenticator
+		g.AuthenticateHealthAndMetrics = cfg.AuthHealthMetrics
+	}
+	if g.VanityImports, err = cfg.vanityImports(); err != nil {
+		return nil, nil, err
+	}
+	g.AllowedModulePatterns = policy.AllowedModulePatterns
+	g.BlockedModulePatterns = policy.BlockedModulePatterns
+	g.ApprovedVersions = policy.ApprovedVersions
+	g.LatestVersionPolicies = policy.LatestVersionPolicies
+	g.RateLimit = policy.RateLimit
+	g.RateBurst = policy.RateBurst
+	g.Quotas = policy.Quotas
+	if cfg.NoSumCheck != "" {
+		g.NoSumCheck = strings.Split(cfg.NoSumCheck, ",")
+	}
+	if cfg.Private != "" {
+		g.Private = strings.Split(cfg.Private, ",")
+	}
+	if len(cfg.Rewrite) > 0 {
+		g.ModulePathRewrites = map[string]string{}
+		for _, rewrite := range cfg.Rewrite {
+			from, to, ok := strings.Cut(rewrite, "=")
+			from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+			if !ok || from == "" || to == "" {
+				return nil, nil, fmt.Errorf("invalid -rewrite %q", rewrite)
+			}
+			g.ModulePathRewrites[from] = to
+		}
+	}
+	if cfg.TrustedProxies != "" {
+		for _, trustedProxy := range strings.Split(cfg.TrustedProxies, ",") {
+			trustedProxy = strings.TrimSpace(trustedProxy)
+			if _, err := netip.ParsePrefix(trustedProxy); err == nil {
+				continue
+			}
+			if _, err := netip.ParseAddr(trustedProxy); err != nil {
+				return nil, nil, fmt.Errorf("invalid -trusted-proxies entry %q", trustedProxy)
+			}
+		}
+		g.TrustedProxies = strings.Split(cfg.TrustedProxies, ",")
+	}
+	if cfg.CORSOrigins != "" {
+		g.CORSOrigins = strings.Split(cfg.CORSOrigins, ",")
+	}
+	if cfg.ReadinessUpstreams != "" {
+		g.ReadinessUpstreams = strings.Split(cfg.ReadinessUpstreams, ",")
 	}
 
 	handler := http.Handler(g)
-	if *pathPrefix != "" {
-		handler = http.StripPrefix(*pathPrefix, handler)
-	}
-	if *fetchTimeout > 0 {
-		handler = func(h http.Handler) http.Handler {
-			return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
-				ctx, cancel := context.WithTimeout(req.Context(), *fetchTimeout)
-				h.ServeHTTP(rw, req.WithContext(ctx))
-				cancel()
-			})
-		}(handler)
-	}
-
-	server := &http.Server{Addr: *address, Handler: handler}
-	var err error
-	if *tlsCertFile != "" && *tlsKeyFile != "" {
-		err = server.ListenAndServeTLS(*tlsCertFile, *tlsKeyFile)
-	} else {
-		err = server.ListenAndServe()
-	}
-	if err != nil && !errors.Is(err, http.ErrServerClosed) {
-		log.Printf("http server error: %v\n", err)
-		return
+	if cfg.IndexPage != "" {
+		var page []byte
+		if cfg.IndexPage != "default" {
+			var err error
+			if page, err = os.ReadFile(cfg.IndexPage); err != nil {
+				return nil, nil, fmt.Errorf("invalid -index-page %q: %v", cfg.IndexPage, err)
+			}
+		}
+		pathPrefix := strings.TrimSuffix(cfg.PathPrefix, "/")
+		handler = routePath(handler, pathPrefix+"/", newIndexHandler(page, pathPrefix))
+	}
+	if cfg.MetricsPath != "" {
+		handler = routePath(handler, cfg.MetricsPath, g.MetricsHandler())
+	}
+	if cfg.HealthPath != "" {
+		handler = routePath(handler, cfg.HealthPath, g.HealthHandler())
+	}
+	if cfg.ReadinessPath != "" {
+		handler = routePath(handler, cfg.ReadinessPath, g.ReadinessHandler())
+	}
+	if cfg.AdminToken != "" {
+		adminPrefix := strings.TrimSuffix(cfg.AdminPath, "/")
+		handler = routePathPrefix(handler, adminPrefix, http.StripPrefix(adminPrefix, g.AdminHandler()))
+	}
+
+	server := &http.Server{Handler: handler}
+	if cfg.H2C {
+		// Configuring the server lets it close the HTTP/2 connections,
+		// which it does not track once they are taken over by the h2c
+		// handler, when it is shut down.
+		h2Server := &http2.Server{}
+		if err := http2.ConfigureServer(server, h2Server); err != nil {
+			return nil, nil, err
+		}
+		server.Handler = h2c.NewHandler(handler, h2Server)
+	}
+	return g, server, nil
+}
+
+// listen listens on the address, which is either a TCP address or a Unix domain
+// socket path prefixed with "unix:". For the latter, a stale socket file left
+// by a previous process is removed first, and the new socket file is created
+// with the socketMode.
+func listen(address string, socketMode os.FileMode) (net.Listener, error) {
+	socketPath, ok := strings.CutPrefix(address, "unix:")
+	if !ok {
+		return net.Listen("tcp", address)
+	}
+	if err := removeStaleUnixSocket(socketPath); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(socketPath, socketMode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// removeStaleUnixSocket removes the Unix domain socket file at the socketPath
+// if no process is listening on it. It refuses to remove files that are not
+// sockets or sockets that are still in use.
+func removeStaleUnixSocket(socketPath string) error {
+	fi, err := os.Lstat(socketPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if fi.Mode()&fs.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", socketPath)
+	}
+	if conn, err := net.DialTimeout("unix", socketPath, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is already in use", socketPath)
+	}
+	return os.Remove(socketPath)
+}
+
+// proxyProtocolHeaderTimeout is the maximum amount of time a connection
+// accepted by a [proxyProtocolListener] has to send its PROXY protocol header.
+const proxyProtocolHeaderTimeout = 10 * time.Second
+
+// proxyProtocolV2Signature is the signature that starts a PROXY protocol v2
+// header.
+const proxyProtocolV2Signature = "\r\n\r\n\x00\r\nQUIT\n"
+
+// proxyProtocolListener is a [net.Listener] whose connections must start with
+// a PROXY protocol (v1 or v2) header, as sent by L4 load balancers, whose
+// source address then becomes the remote address of the connections.
+// Connections without a valid header are closed on their first use.
+type proxyProtocolListener struct {
+	net.Listener
+}
+
+// Accept implements [net.Listener]. The header is not read here, but by the
+// goroutine serving the connection, so slow clients cannot block accepting.
+func (ppl proxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := ppl.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyProtocolConn{Conn: conn, br: bufio.NewReader(conn)}, nil
+}
+
+// proxyProtocolConn is a [net.Conn] accepted by a [proxyProtocolListener].
+type proxyProtocolConn struct {
+	net.Conn
+	br         *bufio.Reader
+	once       sync.Once
+	remoteAddr net.Addr
+	err        error
+}
+
+// readHeader reads the PROXY protocol header of the ppc once.
+func (ppc *proxyProtocolConn) readHeader() {
+	ppc.once.Do(func() {
+		ppc.remoteAddr = ppc.Conn.RemoteAddr()
+		ppc.Conn.SetReadDeadline(time.Now().Add(proxyProtocolHeaderTimeout))
+		remoteAddr, err := readProxyProtocolHeader(ppc.br)
+		ppc.Conn.SetReadDeadline(time.Time{})
+		if err != nil {
+			ppc.err = fmt.Errorf("invalid PROXY protocol header from %s: %w", ppc.remoteAddr, err)
+			ppc.Conn.Close()
+			return
+		}
+		if remoteAddr != nil {
+			ppc.remoteAddr = remoteAddr
+		}
+	})
+}
+
+// Read implements [net.Conn].
+func (ppc *proxyProtocolConn) Read(b []byte) (int, error) {
+	ppc.readHeader()
+	if ppc.err != nil {
+		return 0, ppc.err
+	}
+	return ppc.br.Read(b)
+}
+
+// RemoteAddr implements [net.Conn].
+func (ppc *proxyProtocolConn) RemoteAddr() net.Addr {
+	ppc.readHeader()
+	return ppc.remoteAddr
+}
+
+// readProxyProtocolHeader reads a PROXY protocol v1 or v2 header from the br
+// and returns the source address in it. It returns a nil address for headers
+// that carry no usable address, such as those of health checks sent by load
+// balancers.
+func readProxyProtocolHeader(br *bufio.Reader) (net.Addr, error) {
+	sig, err := br.Peek(len(proxyProtocolV2Signature))
+	if err != nil {
+		return nil, err
+	}
+	if string(sig) == proxyProtocolV2Signature {
+		return readProxyProtocolV2Header(br)
+	}
+	if strings.HasPrefix(string(sig), "PROXY ") {
+		return readProxyProtocolV1Header(br)
+	}
+	return nil, errors.New("missing header")
+}
+
+// readProxyProtocolV1Header reads a PROXY protocol v1 header (e.g., "PROXY TCP4
+// 192.0.2.1 198.51.100.1 56324 443\r\n") from the br.
+func readProxyProtocolV1Header(br *bufio.Reader) (net.Addr, error) {
+	// A v1 header is at most 107 bytes long, which always fits in the
+	// buffer of the br.
+	line, err := br.ReadSlice('\n')
+	if err != nil {
+		if errors.Is(err, bufio.ErrBufferFull) {
+			err = errors.New("v1 header too long")
+		}
+		return nil, err
+	}
+	if len(line) > 107 || !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("malformed v1 header")
+	}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errors.New("malformed v1 header")
+	}
+	srcAddr, err := netip.ParseAddr(fields[2])
+	if err != nil || srcAddr.Is4() != (fields[1] == "TCP4") {
+		return nil, errors.New("invalid v1 source address")
+	}
+	srcPort, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, errors.New("invalid v1 source port")
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(srcAddr, uint16(srcPort))), nil
+}
+
+// readProxyProtocolV2Header reads a binary PROXY protocol v2 header from the
+// br.
+func readProxyProtocolV2Header(br *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, len(proxyProtocolV2Signature)+4)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, err
+	}
+	verCmd, family := header[12], header[13]
+	payload := make([]byte, int(header[14])<<8|int(header[15]))
+	if _, err := io.ReadFull(br, payload); err != nil {
+		return nil, err
+	}
+	if verCmd>>4 != 2 {
+		return nil, errors.New("unsupported v2 version")
+	}
+	switch verCmd & 0xf {
+	case 0: // LOCAL
+		return nil, nil
+	case 1: // PROXY
+	default:
+		return nil, errors.New("unsupported v2 command")
+	}
+	var addrLen int
+	switch family >> 4 {
+	case 1: // AF_INET
+		addrLen = 4
+	case 2: // AF_INET6
+		addrLen = 16
+	default: // AF_UNSPEC and AF_UNIX
+		return nil, nil
+	}
+	if len(payload) < 2*addrLen+4 {
+		return nil, errors.New("truncated v2 addresses")
+	}
+	srcAddr, _ := netip.AddrFromSlice(payload[:addrLen])
+	srcPort := uint16(payload[2*addrLen])<<8 | uint16(payload[2*addrLen+1])
+	addrPort := netip.AddrPortFrom(srcAddr, srcPort)
+	if family&0xf == 2 { // SOCK_DGRAM
+		return net.UDPAddrFromAddrPort(addrPort), nil
+	}
+	return net.TCPAddrFromAddrPort(addrPort), nil
+}
+
+// tlsCertReloader holds a TLS certificate loaded from a pair of certificate
+// and key files, and reloads it when either of the files changes. It is safe
+// for concurrent use.
+type tlsCertReloader struct {
+	certFile      string
+	keyFile       string
+	mutex         sync.RWMutex
+	cert          *tls.Certificate
+	certFileState fileState
+	keyFileState  fileState
+}
+
+// fileState is the state of a file used to detect its changes.
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// newTLSCertReloader returns a new [tlsCertReloader] with the certificate
+// loaded from the certFile and keyFile.
+func newTLSCertReloader(certFile, keyFile string) (*tlsCertReloader, error) {
+	tcr := &tlsCertReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := tcr.reload(); err != nil {
+		return nil, err
+	}
+	return tcr, nil
+}
+
+// reload reloads the certificate if the certificate or key file has changed
+// since the last successful load, and reports whether it did. If the files
+// cannot be loaded as a pair, such as when only one of them has been replaced
+// so far, the current certificate is kept and the next reload tries again.
+func (tcr *tlsCertReloader) reload() (bool, error) {
+	certFileState, err := statFile(tcr.certFile)
+	if err != nil {
+		return false, err
+	}
+	keyFileState, err := statFile(tcr.keyFile)
+	if err != nil {
+		return false, err
+	}
+	tcr.mutex.RLock()
+	unchanged := tcr.cert != nil && certFileState == tcr.certFileState && keyFileState == tcr.keyFileState
+	tcr.mutex.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(tcr.certFile, tcr.keyFile)
+	if err != nil {
+		return false, err
+	}
+	tcr.mutex.Lock()
+	tcr.cert = &cert
+	tcr.certFileState = certFileState
+	tcr.keyFileState = keyFileState
+	tcr.mutex.Unlock()
+	return true, nil
+}
+
+// statFile returns the state of the file, following symbolic links so
+// that atomic swaps of linked directories are detected.
+func statFile(file string) (fileState, error) {
+	fi, err := os.Stat(file)
+	if err != nil {
+		return fileState{}, err
+	}
+	return fileState{modTime: fi.ModTime(), size: fi.Size()}, nil
+}
+
+// watch reloads the certificate every interval until the ctx is done.
+func (tcr *tlsCertReloader) watch(ctx context.Context, logger *slog.Logger, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		if reloaded, err := tcr.reload(); err != nil {
+			logger.Warn("failed to reload tls certificate, will retry", "error", err)
+		} else if reloaded {
+			logger.Info("reloaded tls certificate", "cert_file", tcr.certFile, "key_file", tcr.keyFile)
+		}
+	}
+}
+
+// getCertificate implements [tls.Config.GetCertificate].
+func (tcr *tlsCertReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	tcr.mutex.RLock()
+	defer tcr.mutex.RUnlock()
+	return tcr.cert, nil
+}
+
+// parseConfig parses the args with the fs into a new [Config], loading the
+// configuration file named by the -config flag, if any, underneath the flags
+// set in the args. It also returns the name of the configuration file.
+func parseConfig(fs *flag.FlagSet, args []string) (*Config, string, error) {
+	cfg := newConfig()
+	cfg.registerFlags(fs)
+	configFile := fs.String("config", "", "path to a YAML or JSON configuration file whose keys are the names of the other flags (flags set on the command line take precedence)")
+	if err := fs.Parse(args); err != nil {
+		return nil, "", err
+	}
+	if *configFile != "" {
+		if err := cfg.loadFile(*configFile); err != nil {
+			return nil, "", fmt.Errorf("invalid -config %q: %v", *configFile, err)
+		}
+
+		// Parse the flags again so that those set on the command line
+		// override the configuration file. Repeatable flags are reset
+		// first, so they replace the configuration file values rather
+		// than being appended to twice.
+		fs.Visit(func(f *flag.Flag) {
+			if sf, ok := f.Value.(*stringsFlag); ok {
+				*sf = nil
+			}
+		})
+		if err := fs.Parse(args); err != nil {
+			return nil, "", err
+		}
+	}
+	return cfg, *configFile, nil
+}
+
+// policyReloader reloads the policy of a Goproxy from the configuration when
+// it receives a SIGHUP or when the configuration file, the approved versions
+// file, the latest policies file, or the quotas file changes.
+type policyReloader struct {
+	args       []string
+	g          *goproxy.Goproxy
+	fileStates map[string]fileState
+}
+
+// newPolicyReloader returns a new [policyReloader] with the policy of the g
+// loaded from the configuration parsed from the args.
+func newPolicyReloader(args []string, g *goproxy.Goproxy) (*policyReloader, error) {
+	pr := &policyReloader{args: args, g: g}
+	if _, err := pr.reload(true); err != nil {
+		return nil, err
+	}
+	return pr, nil
+}
+
+// reload reloads the policy if the force is true or any of the files it was
+// last successfully loaded from has changed since, and reports whether it
+// did. If the configuration is invalid, the current policy is kept.
+func (pr *policyReloader) reload(force bool) (bool, error) {
+	if !force {
+		changed := false
+		for file, state := range pr.fileStates {
+			if newState, err := statFile(file); err != nil || newState != state {
+				changed = true
+				break
+			}
+		}
+		if !changed {
+			return false, nil
+		}
+	}
+
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	cfg, configFile, err := parseConfig(fs, pr.args)
+	if err != nil {
+		return false, err
+	}
+
+	// The files are stated before they are read, so that a change made
+	// while reading them is picked up by the next reload.
+	fileStates := map[string]fileState{}
+	for _, file := range []string{configFile, cfg.ApprovedVersions, cfg.LatestPolicies, cfg.Quotas} {
+		if file == "" {
+			continue
+		}
+		state, err := statFile(file)
+		if err != nil {
+			return false, err
+		}
+		fileStates[file] = state
+	}
+	p, err := cfg.policy()
+	if err != nil {
+		return false, err
+	}
+	pr.g.SetPolicy(p)
+	pr.fileStates = fileStates
+	return true, nil
+}
+
+// watch reloads the policy on each SIGHUP, and every interval (0 means never)
+// if any of its files has changed, until the ctx is done.
+func (pr *policyReloader) watch(ctx context.Context, logger *slog.Logger, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		force := false
+		select {
+		case <-tick:
+		case <-hup:
+			force = true
+		case <-ctx.Done():
+			return
+		}
+		if reloaded, err := pr.reload(force); err != nil {
+			logger.Error("failed to reload policy, keeping the current one", "error", err)
+		} else if reloaded {
+			logger.Info("reloaded policy")
+		}
 	}
 }
 
+// cacheWatermark is a limit on the total size of the cache directory, either
+// in bytes or as a percentage of the file system it is on.
+type cacheWatermark struct {
+	size    int64
+	percent float64
+}
+
+// parseCacheWatermark parses the s as a [cacheWatermark], which is either a
+// number of bytes, such as "10737418240", or a percentage, such as "90%".
+func parseCacheWatermark(s string) (cacheWatermark, error) {
+	if percent, ok := strings.CutSuffix(s, "%"); ok {
+		p, err := strconv.ParseFloat(percent, 64)
+		if err != nil || p <= 0 || p > 100 {
+			return cacheWatermark{}, fmt.Errorf("invalid percentage %q", s)
+		}
+		return cacheWatermark{percent: p}, nil
+	}
+	size, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || size < 0 {
+		return cacheWatermark{}, fmt.Errorf("invalid size %q", s)
+	}
+	return cacheWatermark{size: size}, nil
+}
+
+// isZero reports whether the cw means no limit.
+func (cw cacheWatermark) isZero() bool {
+	return cw.size == 0 && cw.percent == 0
+}
+
+// bytes returns the cw in bytes for the cache directory dir. A percentage is
+// resolved against the current size of the file system, so that it follows a
+// resized volume.
+func (cw cacheWatermark) bytes(dir string) (int64, error) {
+	if cw.percent == 0 {
+		return cw.size, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return 0, err
+	}
+	size, err := fileSystemSize(dir)
+	if err != nil {
+		return 0, err
+	}
+	return int64(float64(size) * cw.percent / 100), nil
+}
+
+// cleanCacheDir cleans the dc every interval with the maxAge and the
+// highWatermark and lowWatermark until the ctx is done. The result of each
+// cleanup is recorded in the metrics of the g.
+func cleanCacheDir(ctx context.Context, logger *slog.Logger, g *goproxy.Goproxy, dc goproxy.DirCacher, interval, maxAge, notFoundMaxAge time.Duration, highWatermark, lowWatermark cacheWatermark) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := cleanCacheDirOnce(ctx, logger, g, dc, maxAge, notFoundMaxAge, highWatermark, lowWatermark); err != nil && ctx.Err() == nil {
+			logger.Error("failed to clean cache directory", "error", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// cleanCacheDirOnce is a single cleanup of [cleanCacheDir]. The "not found"
+// results older than the notFoundMaxAge are evicted first, so they do not
+// outlive their TTL on disk.
+func cleanCacheDirOnce(ctx context.Context, logger *slog.Logger, g *goproxy.Goproxy, dc goproxy.DirCacher, maxAge, notFoundMaxAge time.Duration, highWatermark, lowWatermark cacheWatermark) error {
+	high, err := highWatermark.bytes(string(dc))
+	if err != nil {
+		return fmt.Errorf("resolve high watermark: %w", err)
+	}
+	low, err := lowWatermark.bytes(string(dc))
+	if err != nil {
+		return fmt.Errorf("resolve low watermark: %w", err)
+	}
+	notFoundResult, err := dc.CleanNotFound(ctx, notFoundMaxAge)
+	if err != nil {
+		return fmt.Errorf("clean not found results: %w", err)
+	}
+	result, err := dc.CleanWatermarks(ctx, maxAge, high, low)
+	result.EvictedEntries += notFoundResult.EvictedEntries
+	result.EvictedBytes += notFoundResult.EvictedBytes
+	g.ObserveCacheEviction(result)
+	if result.EvictedEntries > 0 {
+		logger.Info("evicted module files from cache directory", "entries", result.EvictedEntries, "bytes", result.EvictedBytes, "remaining_bytes", result.Size)
+	}
+	return err
+}
+
+// verifyCacheDir verifies the dc with the concurrency, deleting the corrupt
+// module files if deleteCorrupt is true. Each corrupt module version and then
+// a summary are printed to the w. It reports whether any were corrupt.
+func verifyCacheDir(ctx context.Context, w io.Writer, dc goproxy.DirCacher, concurrency int, deleteCorrupt bool) (bool, error) {
+	var total, ok, corrupt, repaired int
+	if err := dc.Verify(ctx, concurrency, deleteCorrupt, func(result goproxy.DirCacherVerifyResult) {
+		total++
+		if result.Err == nil {
+			ok++
+			return
+		}
+		corrupt++
+		status := "corrupt"
+		if result.Deleted {
+			repaired++
+			status = "deleted"
+		}
+		fmt.Fprintf(w, "%s: %s: %v\n", status, result.Name, result.Err)
+	}); err != nil {
+		return false, err
+	}
+	fmt.Fprintf(w, "total %d, ok %d, corrupt %d, repaired %d\n", total, ok, corrupt, repaired)
+	return corrupt > 0, nil
+}
+
+// routePath returns an [http.Handler] that routes requests for the path to the
+// h and all other requests to the fallback.
+func routePath(fallback http.Handler, path string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == path {
+			h.ServeHTTP(rw, req)
+			return
+		}
+		fallback.ServeHTTP(rw, req)
+	})
+}
+
+// indexPageTemplate is the template of the built-in index page, executed with
+// the URL of the proxy.
+var indexPageTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Go module proxy</title>
+</head>
+<body>
+<h1>Go module proxy</h1>
+<p>This is a <a href="https://go.dev/ref/mod#goproxy-protocol">Go module proxy</a>. To use it, run:</p>
+<pre>go env -w GOPROXY={{.}},direct</pre>
+</body>
+</html>
+`))
+
+// newIndexHandler returns an [http.Handler] that serves the page, or the
+// built-in index page for the proxy under the pathPrefix if the page is nil.
+func newIndexHandler(page []byte, pathPrefix string) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet, http.MethodHead:
+		default:
+			rw.Header().Set("Allow", "GET, HEAD")
+			http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		content := page
+		if content == nil {
+			scheme := "http"
+			if req.TLS != nil {
+				scheme = "https"
+			}
+			var buf bytes.Buffer
+			if err := indexPageTemplate.Execute(&buf, scheme+"://"+req.Host+pathPrefix); err != nil {
+				http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			content = buf.Bytes()
+		}
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+		rw.Header().Set("Cache-Control", "public, max-age=60")
+		http.ServeContent(rw, req, "", time.Time{}, bytes.NewReader(content))
+	})
+}
+
+// newClientTLSConfig returns a new [tls.Config] that presents the client
+// certificate loaded from the certFile and keyFile. If the caFile is not empty,
+// the server certificates are verified against it instead of the system roots.
+func newClientTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if caFile != "" {
+		if tlsConfig.RootCAs, err = loadCertPool(caFile); err != nil {
+			return nil, err
+		}
+	}
+	return tlsConfig, nil
+}
+
+// loadCertPool returns a new [x509.CertPool] containing the PEM-encoded
+// certificates in the file.
+func loadCertPool(file string) (*x509.CertPool, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificates found in %s", file)
+	}
+	return pool, nil
+}
+
+// parseInsecureHosts parses the comma-separated list of host glob patterns of
+// the -insecure-hosts flag. To keep a typo from disabling TLS verification for
+// unrelated hosts, a pattern containing glob metacharacters is rejected unless
+// its last two labels are free of them. So "*.corp.example.com" is accepted,
+// but "*", "*.com", and "example.*" are not.
+func parseInsecureHosts(s string) (string, error) {
+	var patterns []string
+	for _, pattern := range strings.Split(s, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if strings.Contains(pattern, "/") {
+			return "", fmt.Errorf("pattern %q must not contain a path", pattern)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return "", fmt.Errorf("pattern %q: %v", pattern, err)
+		}
+		if labels := strings.Split(pattern, "."); strings.ContainsAny(pattern, `*?[\`) &&
+			(len(labels) < 3 || strings.ContainsAny(strings.Join(labels[len(labels)-2:], "."), `*?[\`)) {
+			return "", fmt.Errorf("pattern %q is too broad", pattern)
+		}
+		patterns = append(patterns, pattern)
+	}
+	if len(patterns) == 0 {
+		return "", errors.New("no patterns")
+	}
+	return strings.Join(patterns, ","), nil
+}
+
+// dialTLSWithInsecureHosts returns a function for
+// [http.Transport.DialTLSContext] that dials via the dialContext and skips TLS
+// verification only for the hosts matching the insecureHosts, which is a
+// comma-separated list of glob patterns matched as GOINSECURE is.
+func dialTLSWithInsecureHosts(dialContext func(ctx context.Context, network, addr string) (net.Conn, error), insecureHosts string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := dialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		tlsConn := tls.Client(conn, &tls.Config{
+			ServerName:         host,
+			NextProtos:         []string{"h2", "http/1.1"},
+			InsecureSkipVerify: module.MatchPrefixPatterns(insecureHosts, host),
+		})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tlsConn, nil
+	}
+}
+
+// newSOCKS5Dialer returns a function for [http.Transport.DialContext] that
+// dials via the SOCKS5 proxy specified by the s through the forward, along with
+// the URL of the proxy for the proxy environment variables of the go command.
+// The s is either the address of the proxy or a URL with the "socks5" or
+// "socks5h" scheme and optional credentials. Either way, hostnames are
+// resolved by the proxy rather than locally, so hosts only resolvable inside
+// the remote network are reachable.
+//
+// The timeout of the forward bounds the SOCKS5 handshake as well as the
+// connection to the proxy.
+func newSOCKS5Dialer(s string, forward *net.Dialer) (func(ctx context.Context, network, addr string) (net.Conn, error), string, error) {
+	u := &url.URL{Host: s}
+	if strings.Contains(s, "://") {
+		var err error
+		if u, err = url.Parse(s); err != nil {
+			return nil, "", err
+		}
+		if u.Scheme != "socks5" && u.Scheme != "socks5h" {
+			return nil, "", fmt.Errorf("unsupported scheme %q", u.Scheme)
+		}
+		if u.Path != "" && u.Path != "/" {
+			return nil, "", errors.New("unexpected path")
+		}
+	}
+	if _, _, err := net.SplitHostPort(u.Host); err != nil {
+		return nil, "", err
+	}
+	var auth *proxy.Auth
+	if u.User != nil {
+		password, _ := u.User.Password()
+		auth = &proxy.Auth{User: u.User.Username(), Password: password}
+	}
+	d, err := proxy.SOCKS5("tcp", u.Host, auth, forward)
+	if err != nil {
+		return nil, "", err
+	}
+	cd := d.(proxy.ContextDialer)
+	dialContext := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if forward.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, forward.Timeout)
+			defer cancel()
+		}
+		return cd.DialContext(ctx, network, addr)
+	}
+	proxyURL := &url.URL{Scheme: "socks5h", User: u.User, Host: u.Host}
+	return dialContext, proxyURL.String(), nil
+}
+
+// dnsCacheRefreshFraction is the fraction of the TTL of a [dnsCache] entry
+// after which the entry is refreshed in the background.
+const dnsCacheRefreshFraction = 0.8
+
+// dnsCache is an in-process cache of the addresses of hosts. It is safe for
+// concurrent use.
+type dnsCache struct {
+	lookupFunc    func(ctx context.Context, host string) ([]string, error)
+	now           func() time.Time
+	ttl           time.Duration
+	negativeTTL   time.Duration
+	lookupTimeout time.Duration
+	mutex         sync.Mutex
+	entries       map[string]*dnsCacheEntry
+	lastSweep     time.Time
+}
+
+// dnsCacheEntry is an entry of a [dnsCache].
+type dnsCacheEntry struct {
+	ready      chan struct{}
+	addrs      []string
+	err        error
+	resolvedAt time.Time
+	expiresAt  time.Time
+	refreshing bool
+}
+
+// newDNSCache returns a new [dnsCache] that caches addresses for the ttl and
+// hosts not found for the negativeTTL (0 means not cached), with each lookup
+// bounded by the lookupTimeout (0 means no limit).
+func newDNSCache(ttl, negativeTTL, lookupTimeout time.Duration) *dnsCache {
+	return &dnsCache{
+		lookupFunc:    net.DefaultResolver.LookupHost,
+		now:           time.Now,
+		ttl:           ttl,
+		negativeTTL:   negativeTTL,
+		lookupTimeout: lookupTimeout,
+		entries:       map[string]*dnsCacheEntry{},
+	}
+}
+
+// lookupHost looks up the addresses of the host. Concurrent lookups of the
+// same host share a single query, and an entry close to its expiry is
+// refreshed in the background while still being served.
+func (dc *dnsCache) lookupHost(ctx context.Context, host string) ([]string, error) {
+	now := dc.now()
+	dc.mutex.Lock()
+	if now.Sub(dc.lastSweep) >= dc.ttl {
+		dc.sweep(now)
+	}
+	e, ok := dc.entries[host]
+	if ok {
+		select {
+		case <-e.ready:
+			if !now.Before(e.expiresAt) {
+				ok = false
+			} else if e.err == nil && !e.refreshing && now.Sub(e.resolvedAt) >= time.Duration(float64(dc.ttl)*dnsCacheRefreshFraction) {
+				e.refreshing = true
+				go dc.refresh(host, e)
+			}
+		default:
+		}
+	}
+	if !ok {
+		e = &dnsCacheEntry{ready: make(chan struct{})}
+		dc.entries[host] = e
+		go dc.resolve(host, e)
+	}
+	dc.mutex.Unlock()
+
+	select {
+	case <-e.ready:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return e.addrs, e.err
+}
+
+// lookup looks up the addresses of the host, independent of any request.
+func (dc *dnsCache) lookup(host string) ([]string, error) {
+	ctx := context.Background()
+	if dc.lookupTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, dc.lookupTimeout)
+		defer cancel()
+	}
+	return dc.lookupFunc(ctx, host)
+}
+
+// resolve resolves the host for the e, which must not be ready yet. A failed
+// lookup is only remembered if the host was not found and the negative TTL is
+// not 0.
+func (dc *dnsCache) resolve(host string, e *dnsCacheEntry) {
+	addrs, err := dc.lookup(host)
+	now := dc.now()
+
+	dc.mutex.Lock()
+	defer dc.mutex.Unlock()
+	e.addrs, e.err, e.resolvedAt = addrs, err, now
+	if err == nil {
+		e.expiresAt = now.Add(dc.ttl)
+	} else if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound && dc.negativeTTL > 0 {
+		e.expiresAt = now.Add(dc.negativeTTL)
+	} else if dc.entries[host] == e {
+		delete(dc.entries, host)
+	}
+	close(e.ready)
+}
+
+// refresh refreshes the e of the host in the background. If the lookup fails,
+// the e is kept without further refreshes until it expires.
+func (dc *dnsCache) refresh(host string, e *dnsCacheEntry) {
+	addrs, err := dc.lookup(host)
+	now := dc.now()
+
+	dc.mutex.Lock()
+	defer dc.mutex.Unlock()
+	if dc.entries[host] == e && err == nil {
+		dc.entries[host] = &dnsCacheEntry{
+			ready:      e.ready,
+			addrs:      addrs,
+			resolvedAt: now,
+			expiresAt:  now.Add(dc.ttl),
+		}
+	}
+}
+
+// sweep removes the expired entries by the now.
+func (dc *dnsCache) sweep(now time.Time) {
+	for host, e := range dc.entries {
+		select {
+		case <-e.ready:
+			if !now.Before(e.expiresAt) {
+				delete(dc.entries, host)
+			}
+		default:
+		}
+	}
+	dc.lastSweep = now
+}
+
+// dialContext returns a function for [http.Transport.DialContext] that dials
+// via the dialer with hostnames resolved through the dc. The dialer is given a
+// resolver that answers the lookups of the dialed host from the dc, so it still
+// races the addresses of both IP families after its FallbackDelay and spreads
+// its Timeout over them as it does for hostnames it resolves itself.
+func (dc *dnsCache) dialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil || net.ParseIP(host) != nil {
+			return dialer.DialContext(ctx, network, addr)
+		}
+
+		// The resolver may query the host more than once, such as once
+		// per IP family and again after a failure, but the dc is only
+		// asked once per dial.
+		lookupHost := sync.OnceValues(func() ([]string, error) {
+			return dc.lookupHost(ctx, host)
+		})
+		d := *dialer
+		d.Resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(context.Context, string, string) (net.Conn, error) {
+				return &dnsCacheConn{host: host, lookupHost: lookupHost}, nil
+			},
+		}
+		return d.DialContext(ctx, network, addr)
+	}
+}
+
+// dnsCacheConn is a [net.Conn] of a DNS server over TCP that answers the A and
+// AAAA queries of a host from the addresses returned by its lookupHost. Queries
+// of other names, such as those made with search domains appended, are
+// answered as not found.
+type dnsCacheConn struct {
+	host       string
+	lookupHost func() ([]string, error)
+	query      []byte
+	answer     bytes.Buffer
+}
+
+// Read implements [net.Conn].
+func (dcc *dnsCacheConn) Read(b []byte) (int, error) {
+	return dcc.answer.Read(b)
+}
+
+// Write implements [net.Conn]. Each query is prefixed by its 2-byte length, as
+// is the answer written for it.
+func (dcc *dnsCacheConn) Write(b []byte) (int, error) {
+	dcc.query = append(dcc.query, b...)
+	for len(dcc.query) >= 2 {
+		n := int(dcc.query[0])<<8 | int(dcc.query[1])
+		if len(dcc.query) < 2+n {
+			break
+		}
+		answer, err := dcc.answerQuery(dcc.query[2 : 2+n])
+		if err != nil {
+			return 0, err
+		}
+		dcc.answer.Write([]byte{byte(len(answer) >> 8), byte(len(answer))})
+		dcc.answer.Write(answer)
+		dcc.query = dcc.query[2+n:]
+	}
+	return len(b), nil
+}
+
+// answerQuery returns the answer to the DNS message query.
+func (dcc *dnsCacheConn) answerQuery(query []byte) ([]byte, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(query)
+	if err != nil {
+		return nil, err
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil, err
+	}
+
+	var addrs []string
+	rcode := dnsmessage.RCodeNameError
+	if strings.EqualFold(strings.TrimSuffix(q.Name.String(), "."), strings.TrimSuffix(dcc.host, ".")) {
+		addrs, err = dcc.lookupHost()
+		var dnsErr *net.DNSError
+		switch {
+		case err == nil:
+			rcode = dnsmessage.RCodeSuccess
+		case errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+		default:
+			rcode = dnsmessage.RCodeServerFailure
+		}
+	}
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID:                 h.ID,
+		Response:           true,
+		Authoritative:      true,
+		RecursionDesired:   h.RecursionDesired,
+		RecursionAvailable: true,
+		RCode:              rcode,
+	})
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(q); err != nil {
+		return nil, err
+	}
+	if err := b.StartAnswers(); err != nil {
+		return nil, err
+	}
+	rh := dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET}
+	for _, a := range addrs {
+		ip, err := netip.ParseAddr(a)
+		if err != nil {
+			continue
+		}
+		ip = ip.Unmap()
+		switch {
+		case q.Type == dnsmessage.TypeA && ip.Is4():
+			err = b.AResource(rh, dnsmessage.AResource{A: ip.As4()})
+		case q.Type == dnsmessage.TypeAAAA && ip.Is6():
+			err = b.AAAAResource(rh, dnsmessage.AAAAResource{AAAA: ip.As16()})
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
+
+// Close implements [net.Conn].
+func (dcc *dnsCacheConn) Close() error { return nil }
+
+// LocalAddr implements [net.Conn].
+func (dcc *dnsCacheConn) LocalAddr() net.Addr { return nil }
+
+// RemoteAddr implements [net.Conn].
+func (dcc *dnsCacheConn) RemoteAddr() net.Addr { return nil }
+
+// SetDeadline implements [net.Conn].
+func (dcc *dnsCacheConn) SetDeadline(time.Time) error { return nil }
+
+// SetReadDeadline implements [net.Conn].
+func (dcc *dnsCacheConn) SetReadDeadline(time.Time) error { return nil }
+
+// SetWriteDeadline implements [net.Conn].
+func (dcc *dnsCacheConn) SetWriteDeadline(time.Time) error { return nil }
+
 type httpDirFS struct{}
 
 func (fs httpDirFS) Open(name string) (http.File, error) {
@@ -90,3 +1667,15 @@ func (fs httpDirFS) Open(name string) (http.File, error) {
 	}
 	return os.Open(name)
 }
+
+// routePathPrefix returns an [http.Handler] that routes requests for the prefix
+// and all paths under it to the h and all other requests to the fallback.
+func routePathPrefix(fallback http.Handler, prefix string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == prefix || strings.HasPrefix(req.URL.Path, prefix+"/") {
+			h.ServeHTTP(rw, req)
+			return
+		}
+		fallback.ServeHTTP(rw, req)
+	})
+}
@@ -0,0 +1,568 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/net/http2"
+)
+
+func proxyProtocolV2Header(verCmd, family byte, payload []byte) string {
+	return proxyProtocolV2Signature + string([]byte{verCmd, family, byte(len(payload) >> 8), byte(len(payload))}) + string(payload)
+}
+
+func TestReadProxyProtocolHeader(t *testing.T) {
+	v4Payload := []byte{192, 0, 2, 1, 198, 51, 100, 1, 0xdc, 0x04, 0x01, 0xbb}
+	v6Payload := append(append(append([]byte{}, net.ParseIP("2001:db8::1")...), net.ParseIP("2001:db8::2")...), 0xdc, 0x04, 0x01, 0xbb)
+	for _, tt := range []struct {
+		n            int
+		header       string
+		wantAddr     string
+		wantNetwork  string
+		wantErr      string
+		wantRemained string
+	}{
+		{1, "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nGET", "192.0.2.1:56324", "tcp", "", "GET"},
+		{2, "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\nGET", "[2001:db8::1]:56324", "tcp", "", "GET"},
+		{3, "PROXY UNKNOWN\r\nGET", "", "", "", "GET"},
+		{4, "PROXY UNKNOWN ffff::1 ffff::2 1 2\r\nGET", "", "", "", "GET"},
+		{5, "PROXY TCP4 192.0.2.1 198.51.100.1 56324", "", "", "EOF", ""},
+		{6, "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443" + strings.Repeat(" ", 100) + "\r\n", "", "", "malformed v1 header", ""},
+		{7, "PROXY " + strings.Repeat("A", 8192) + "\r\n", "", "", "v1 header too long", ""},
+		{8, "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\n", "", "", "malformed v1 header", ""},
+		{9, "PROXY UDP4 192.0.2.1 198.51.100.1 56324 443\r\n", "", "", "malformed v1 header", ""},
+		{10, "PROXY TCP4 2001:db8::1 198.51.100.1 56324 443\r\n", "", "", "invalid v1 source address", ""},
+		{11, "PROXY TCP4 192.0.2.1 198.51.100.1 65536 443\r\n", "", "", "invalid v1 source port", ""},
+		{12, proxyProtocolV2Header(0x21, 0x11, v4Payload) + "GET", "192.0.2.1:56324", "tcp", "", "GET"},
+		{13, proxyProtocolV2Header(0x21, 0x21, v6Payload) + "GET", "[2001:db8::1]:56324", "tcp", "", "GET"},
+		{14, proxyProtocolV2Header(0x21, 0x12, v4Payload) + "GET", "192.0.2.1:56324", "udp", "", "GET"},
+		{15, proxyProtocolV2Header(0x21, 0x11, append(append([]byte{}, v4Payload...), 0x04, 0x00, 0x01, 0x00)) + "GET", "192.0.2.1:56324", "tcp", "", "GET"},
+		{16, proxyProtocolV2Header(0x20, 0x11, v4Payload) + "GET", "", "", "", "GET"},
+		{17, proxyProtocolV2Header(0x20, 0x00, nil) + "GET", "", "", "", "GET"},
+		{18, proxyProtocolV2Header(0x21, 0x00, nil) + "GET", "", "", "", "GET"},
+		{19, proxyProtocolV2Header(0x21, 0x31, make([]byte, 216)) + "GET", "", "", "", "GET"},
+		{20, proxyProtocolV2Header(0x21, 0x41, nil) + "GET", "", "", "", "GET"},
+		{21, proxyProtocolV2Header(0x21, 0x11, v4Payload[:8]), "", "", "truncated v2 addresses", ""},
+		{22, proxyProtocolV2Header(0x21, 0x21, v4Payload), "", "", "truncated v2 addresses", ""},
+		{23, proxyProtocolV2Header(0x21, 0x11, v4Payload)[:20], "", "", "unexpected EOF", ""},
+		{24, proxyProtocolV2Signature + "\x21\x11", "", "", "unexpected EOF", ""},
+		{25, proxyProtocolV2Signature + "\x21\x11\xff\xff" + strings.Repeat("\x00", 100), "", "", "unexpected EOF", ""},
+		{26, proxyProtocolV2Header(0x11, 0x11, v4Payload), "", "", "unsupported v2 version", ""},
+		{27, proxyProtocolV2Header(0x22, 0x11, v4Payload), "", "", "unsupported v2 command", ""},
+		{28, "GET / HTTP/1.1\r\n\r\n", "", "", "missing header", ""},
+		{29, "PROXY", "", "", "EOF", ""},
+	} {
+		br := bufio.NewReader(strings.NewReader(tt.header))
+		addr, err := readProxyProtocolHeader(br)
+		if tt.wantErr != "" {
+			if err == nil {
+				t.Fatalf("test(%d): expected error", tt.n)
+			}
+			if got, want := err.Error(), tt.wantErr; got != want {
+				t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		}
+		if tt.wantAddr == "" {
+			if addr != nil {
+				t.Errorf("test(%d): got %v, want nil", tt.n, addr)
+			}
+		} else if addr == nil {
+			t.Errorf("test(%d): got nil, want %q", tt.n, tt.wantAddr)
+		} else {
+			if got, want := addr.String(), tt.wantAddr; got != want {
+				t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+			}
+			if got, want := addr.Network(), tt.wantNetwork; got != want {
+				t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+			}
+		}
+		remained, err := io.ReadAll(br)
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		}
+		if got, want := string(remained), tt.wantRemained; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	for _, tt := range []struct {
+		n              int
+		header         string
+		wantRemoteAddr string
+		wantRead       string
+		wantErr        bool
+	}{
+		{1, "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nGET", "192.0.2.1:56324", "GET", false},
+		{2, proxyProtocolV2Header(0x20, 0x00, nil) + "GET", "", "GET", false},
+		{3, "GET / HTTP/1.1\r\n\r\n", "", "", true},
+	} {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		}
+		ppl := proxyProtocolListener{Listener: l}
+
+		clientConn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		}
+		if _, err := io.WriteString(clientConn, tt.header); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		}
+		clientConn.(*net.TCPConn).CloseWrite()
+
+		conn, err := ppl.Accept()
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		}
+		wantRemoteAddr := tt.wantRemoteAddr
+		if wantRemoteAddr == "" {
+			wantRemoteAddr = clientConn.LocalAddr().String()
+		}
+		if got, want := conn.RemoteAddr().String(), wantRemoteAddr; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		b, err := io.ReadAll(conn)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("test(%d): expected error", tt.n)
+			}
+		} else if err != nil {
+			t.Errorf("test(%d): unexpected error %q", tt.n, err)
+		} else if got, want := string(b), tt.wantRead; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		conn.Close()
+		clientConn.Close()
+		l.Close()
+	}
+}
+
+type fakeLookup struct {
+	mutex sync.Mutex
+	addrs map[string][]string
+	err   error
+	calls map[string]int
+}
+
+func (fl *fakeLookup) lookupHost(ctx context.Context, host string) ([]string, error) {
+	fl.mutex.Lock()
+	defer fl.mutex.Unlock()
+	if fl.calls == nil {
+		fl.calls = map[string]int{}
+	}
+	fl.calls[host]++
+	if fl.err != nil {
+		return nil, fl.err
+	}
+	addrs, ok := fl.addrs[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return addrs, nil
+}
+
+func (fl *fakeLookup) callsOf(host string) int {
+	fl.mutex.Lock()
+	defer fl.mutex.Unlock()
+	return fl.calls[host]
+}
+
+func TestDNSCacheLookupHost(t *testing.T) {
+	fl := &fakeLookup{addrs: map[string][]string{"example.com": {"192.0.2.1"}}}
+	now := time.Now()
+	var nowMutex sync.Mutex
+	advance := func(d time.Duration) {
+		nowMutex.Lock()
+		defer nowMutex.Unlock()
+		now = now.Add(d)
+	}
+	dc := newDNSCache(time.Minute, 10*time.Second, 0)
+	dc.lookupFunc = fl.lookupHost
+	dc.now = func() time.Time {
+		nowMutex.Lock()
+		defer nowMutex.Unlock()
+		return now
+	}
+
+	lookup := func(host string, wantAddrs []string, wantNotFound bool, wantCalls int) {
+		t.Helper()
+		addrs, err := dc.lookupHost(context.Background(), host)
+		if wantNotFound {
+			var dnsErr *net.DNSError
+			if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+				t.Errorf("got %v, want not found", err)
+			}
+		} else if err != nil {
+			t.Errorf("unexpected error %q", err)
+		}
+		if got, want := addrs, wantAddrs; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if got, want := fl.callsOf(host), wantCalls; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+	}
+
+	lookup("example.com", []string{"192.0.2.1"}, false, 1)
+	lookup("example.com", []string{"192.0.2.1"}, false, 1)
+	advance(30 * time.Second)
+	lookup("example.com", []string{"192.0.2.1"}, false, 1)
+
+	// Near its expiry, the entry is still served while being refreshed
+	// in the background.
+	fl.mutex.Lock()
+	fl.addrs["example.com"] = []string{"192.0.2.2"}
+	fl.mutex.Unlock()
+	advance(20 * time.Second)
+	lookup("example.com", []string{"192.0.2.1"}, false, 1)
+	for i := 0; fl.callsOf("example.com") < 2; i++ {
+		if i == 100 {
+			t.Fatal("expected the entry to be refreshed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	lookup("example.com", []string{"192.0.2.2"}, false, 2)
+	advance(2 * time.Minute)
+	lookup("example.com", []string{"192.0.2.2"}, false, 3)
+
+	lookup("notfound.example.com", nil, true, 1)
+	lookup("notfound.example.com", nil, true, 1)
+	advance(11 * time.Second)
+	lookup("notfound.example.com", nil, true, 2)
+
+	fl.mutex.Lock()
+	fl.err = errors.New("temporary failure")
+	fl.mutex.Unlock()
+	if _, err := dc.lookupHost(context.Background(), "other.example.com"); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := dc.lookupHost(context.Background(), "other.example.com"); err == nil {
+		t.Fatal("expected error")
+	}
+	if got, want := fl.callsOf("other.example.com"), 2; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	dc = newDNSCache(time.Minute, 0, 0)
+	dc.lookupFunc = fl.lookupHost
+	fl.mutex.Lock()
+	fl.err = nil
+	fl.mutex.Unlock()
+	dc.lookupHost(context.Background(), "notfound.example.com")
+	dc.lookupHost(context.Background(), "notfound.example.com")
+	if got, want := fl.callsOf("notfound.example.com"), 4; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
+
+func TestDNSCacheDialContext(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	fl := &fakeLookup{addrs: map[string][]string{"goproxy.example": {"127.0.0.1"}}}
+	dc := newDNSCache(time.Minute, time.Minute, 0)
+	dc.lookupFunc = fl.lookupHost
+	dialContext := dc.dialContext(&net.Dialer{Timeout: 10 * time.Second})
+
+	for i := 0; i < 2; i++ {
+		conn, err := dialContext(context.Background(), "tcp", net.JoinHostPort("goproxy.example", port))
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		conn.Close()
+	}
+	if got, want := fl.callsOf("goproxy.example"), 1; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err := dialContext(context.Background(), "tcp", net.JoinHostPort("notfound.goproxy.example", port))
+		var dnsErr *net.DNSError
+		if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+			t.Errorf("got %v, want not found", err)
+		}
+	}
+	if got, want := fl.callsOf("notfound.goproxy.example"), 1; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	fl.mutex.Lock()
+	fl.err = errors.New("temporary failure")
+	fl.mutex.Unlock()
+	if _, err := dialContext(context.Background(), "tcp", net.JoinHostPort("other.goproxy.example", port)); err == nil {
+		t.Error("expected error")
+	}
+	if got, want := fl.callsOf("other.goproxy.example"), 1; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
+
+func TestParseInsecureHosts(t *testing.T) {
+	for _, tt := range []struct {
+		n         int
+		s         string
+		want      string
+		wantErr   string
+		wantMatch []string
+		wantNot   []string
+	}{
+		{1, "corp.example.com", "corp.example.com", "", []string{"corp.example.com"}, []string{"example.com", "other.example.com"}},
+		{2, " corp.example.com , *.internal.example.com ", "corp.example.com,*.internal.example.com", "", []string{"corp.example.com", "a.internal.example.com"}, []string{"internal.example.com", "a.example.com"}},
+		{3, "corp.example.com,,", "corp.example.com", "", nil, nil},
+		{4, "git-?.example.com", "git-?.example.com", "", []string{"git-1.example.com"}, []string{"git-10.example.com"}},
+		{5, "[ab].example.com", "[ab].example.com", "", []string{"a.example.com"}, []string{"c.example.com"}},
+		{6, "localhost", "localhost", "", []string{"localhost"}, nil},
+		{7, "", "", "no patterns", nil, nil},
+		{8, " , ", "", "no patterns", nil, nil},
+		{9, "*", "", `pattern "*" is too broad`, nil, nil},
+		{10, "*.com", "", `pattern "*.com" is too broad`, nil, nil},
+		{11, "example.*", "", `pattern "example.*" is too broad`, nil, nil},
+		{12, "corp.*.com", "", `pattern "corp.*.com" is too broad`, nil, nil},
+		{13, "corp.example.co?", "", `pattern "corp.example.co?" is too broad`, nil, nil},
+		{14, `corp\.example.com`, `corp\.example.com`, "", []string{"corp.example.com"}, nil},
+		{15, "corp.example.com/foo", "", `pattern "corp.example.com/foo" must not contain a path`, nil, nil},
+		{16, "[.example.com", "", `pattern "[.example.com": syntax error in pattern`, nil, nil},
+		{17, "corp.example.com,*", "", `pattern "*" is too broad`, nil, nil},
+	} {
+		got, err := parseInsecureHosts(tt.s)
+		if tt.wantErr != "" {
+			if err == nil {
+				t.Fatalf("test(%d): expected error", tt.n)
+			}
+			if got, want := err.Error(), tt.wantErr; got != want {
+				t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		}
+		if want := tt.want; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		for _, host := range tt.wantMatch {
+			if !module.MatchPrefixPatterns(got, host) {
+				t.Errorf("test(%d): expected %q to match %q", tt.n, host, got)
+			}
+		}
+		for _, host := range tt.wantNot {
+			if module.MatchPrefixPatterns(got, host) {
+				t.Errorf("test(%d): expected %q not to match %q", tt.n, host, got)
+			}
+		}
+	}
+}
+
+func writeTestCertPair(t *testing.T, certFile, keyFile string, serialNumber int64, modTime time.Time) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serialNumber),
+		Subject:      pkix.Name{CommonName: "goproxy.example"},
+		DNSNames:     []string{"goproxy.example"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	for file, block := range map[string]*pem.Block{
+		certFile: {Type: "CERTIFICATE", Bytes: certDER},
+		keyFile:  {Type: "EC PRIVATE KEY", Bytes: keyDER},
+	} {
+		if err := os.WriteFile(file, pem.EncodeToMemory(block), 0o600); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+}
+
+func TestTLSCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	modTime := time.Now().Add(-time.Hour)
+
+	if _, err := newTLSCertReloader(certFile, keyFile); err == nil {
+		t.Fatal("expected error")
+	}
+
+	writeTestCertPair(t, certFile, keyFile, 1, modTime)
+	tcr, err := newTLSCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	serialNumber := func() int64 {
+		t.Helper()
+		cert, err := tcr.getCertificate(nil)
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		return leaf.SerialNumber.Int64()
+	}
+	if got, want := serialNumber(), int64(1); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	if reloaded, err := tcr.reload(); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if reloaded {
+		t.Error("expected no reload of unchanged files")
+	}
+
+	// Only the certificate file has been replaced so far, so the pair
+	// does not match and the current certificate is kept.
+	otherCertFile := filepath.Join(dir, "other-cert.pem")
+	otherKeyFile := filepath.Join(dir, "other-key.pem")
+	writeTestCertPair(t, otherCertFile, otherKeyFile, 2, modTime.Add(time.Minute))
+	if err := os.Rename(otherCertFile, certFile); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if _, err := tcr.reload(); err == nil {
+		t.Fatal("expected error")
+	}
+	if got, want := serialNumber(), int64(1); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	if err := os.Rename(otherKeyFile, keyFile); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if reloaded, err := tcr.reload(); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if !reloaded {
+		t.Error("expected reload of changed files")
+	}
+	if got, want := serialNumber(), int64(2); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	if err := os.Remove(keyFile); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if _, err := tcr.reload(); err == nil {
+		t.Fatal("expected error")
+	}
+	if got, want := serialNumber(), int64(2); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	watchDone := make(chan struct{})
+	go func() {
+		defer close(watchDone)
+		tcr.watch(ctx, slog.New(slog.NewTextHandler(io.Discard, nil)), 10*time.Millisecond)
+	}()
+	writeTestCertPair(t, certFile, keyFile, 3, modTime.Add(2*time.Minute))
+	for i := 0; serialNumber() != 3; i++ {
+		if i == 100 {
+			t.Fatal("expected the certificate to be reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	select {
+	case <-watchDone:
+	case <-time.After(time.Second):
+		t.Fatal("expected watch to return after the context is done")
+	}
+}
+
+func TestConfigBuildH2C(t *testing.T) {
+	cfg := newConfig()
+	cfg.H2C = true
+	cfg.CacheDir = t.TempDir()
+	cfg.HealthPath = "/healthz"
+	_, server, err := cfg.build(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	go server.Serve(l)
+	defer server.Close()
+
+	var dials atomic.Int32
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			dials.Add(1)
+			return net.Dial(network, addr)
+		},
+	}}
+	for i := 0; i < 2; i++ {
+		res, err := client.Get("http://" + l.Addr().String() + "/healthz")
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+		if got, want := res.StatusCode, http.StatusOK; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+		if got, want := res.ProtoMajor, 2; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+	}
+	if got, want := dials.Load(), int32(1); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
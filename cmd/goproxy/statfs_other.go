@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !freebsd
+
+package main
+
+import "errors"
+
+// fileSystemSize returns the total size in bytes of the file system that the
+// dir is on. It is not supported on this platform.
+func fileSystemSize(dir string) (int64, error) {
+	return 0, errors.New("file system size is not supported on this platform")
+}
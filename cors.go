@@ -0,0 +1,84 @@
+package goproxy
+
+import (
+	"net/http"
+	"strings"
+)
+
+// corsAllowedHeaders are the request headers that cross-origin requests are
+// allowed to send.
+var corsAllowedHeaders = []string{
+	"Disable-Module-Fetch",
+	"If-Modified-Since",
+	"If-None-Match",
+	"If-Range",
+	"Range",
+	"Traceparent",
+	"Tracestate",
+}
+
+// corsExposedHeaders are the response headers that cross-origin requests are
+// allowed to read, in addition to the CORS-safelisted response headers.
+var corsExposedHeaders = []string{
+	"Content-Length",
+	"Content-Range",
+	"ETag",
+	requestIDHeader,
+	"Retry-After",
+	"Warning",
+}
+
+// corsPolicy is a Cross-Origin Resource Sharing policy for read-only requests.
+type corsPolicy struct {
+	allowAllOrigins bool
+	origins         map[string]struct{}
+}
+
+// newCORSPolicy returns a new [corsPolicy] that allows the origins, or nil if
+// the origins are empty. An origin of "*" allows all origins. Empty entries are
+// ignored.
+func newCORSPolicy(origins []string) *corsPolicy {
+	cp := &corsPolicy{origins: map[string]struct{}{}}
+	for _, origin := range origins {
+		origin = strings.TrimSuffix(strings.TrimSpace(origin), "/")
+		if origin == "*" {
+			cp.allowAllOrigins = true
+		} else if origin != "" {
+			cp.origins[strings.ToLower(origin)] = struct{}{}
+		}
+	}
+	if !cp.allowAllOrigins && len(cp.origins) == 0 {
+		return nil
+	}
+	return cp
+}
+
+// handle sets the CORS response headers of the req on the rw. It reports
+// whether the req is a preflight request, which has been responded to and needs
+// no further handling.
+func (cp *corsPolicy) handle(rw http.ResponseWriter, req *http.Request) bool {
+	if !cp.allowAllOrigins {
+		rw.Header().Add("Vary", "Origin")
+	}
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	if cp.allowAllOrigins {
+		rw.Header().Set("Access-Control-Allow-Origin", "*")
+	} else if _, ok := cp.origins[strings.ToLower(origin)]; ok {
+		rw.Header().Set("Access-Control-Allow-Origin", origin)
+	} else {
+		return false
+	}
+
+	if req.Method != http.MethodOptions || req.Header.Get("Access-Control-Request-Method") == "" {
+		rw.Header().Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+		return false
+	}
+	rw.Header().Set("Access-Control-Allow-Methods", "GET, HEAD")
+	rw.Header().Set("Access-Control-Allow-Headers", strings.Join(corsAllowedHeaders, ", "))
+	rw.Header().Set("Access-Control-Max-Age", "86400")
+	rw.WriteHeader(http.StatusNoContent)
+	return true
+}
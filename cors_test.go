@@ -0,0 +1,137 @@
+package goproxy
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewCORSPolicy(t *testing.T) {
+	for _, tt := range []struct {
+		n                   int
+		origins             []string
+		wantNil             bool
+		wantAllowAllOrigins bool
+		wantOrigins         []string
+	}{
+		{1, nil, true, false, nil},
+		{2, []string{"", " "}, true, false, nil},
+		{3, []string{"*"}, false, true, nil},
+		{4, []string{"https://example.com", " https://Example.org/ "}, false, false, []string{"https://example.com", "https://example.org"}},
+	} {
+		cp := newCORSPolicy(tt.origins)
+		if tt.wantNil {
+			if cp != nil {
+				t.Errorf("test(%d): got %v, want nil", tt.n, cp)
+			}
+			continue
+		}
+		if cp == nil {
+			t.Fatalf("test(%d): unexpected nil", tt.n)
+		}
+		if got, want := cp.allowAllOrigins, tt.wantAllowAllOrigins; got != want {
+			t.Errorf("test(%d): got %v, want %v", tt.n, got, want)
+		}
+		if got, want := len(cp.origins), len(tt.wantOrigins); got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		for _, origin := range tt.wantOrigins {
+			if _, ok := cp.origins[origin]; !ok {
+				t.Errorf("test(%d): missing origin %q", tt.n, origin)
+			}
+		}
+	}
+}
+
+func TestCORSPolicyHandle(t *testing.T) {
+	for _, tt := range []struct {
+		n                 int
+		origins           []string
+		method            string
+		origin            string
+		requestMethod     string
+		wantPreflight     bool
+		wantAllowOrigin   string
+		wantVary          string
+		wantExposeHeaders bool
+	}{
+		{1, []string{"https://example.com"}, http.MethodGet, "", "", false, "", "Origin", false},
+		{2, []string{"https://example.com"}, http.MethodGet, "https://example.com", "", false, "https://example.com", "Origin", true},
+		{3, []string{"https://example.com"}, http.MethodGet, "https://example.org", "", false, "", "Origin", false},
+		{4, []string{"*"}, http.MethodGet, "https://example.org", "", false, "*", "", true},
+		{5, []string{"https://example.com"}, http.MethodOptions, "https://example.com", http.MethodGet, true, "https://example.com", "Origin", false},
+		{6, []string{"https://example.com"}, http.MethodOptions, "https://example.org", http.MethodGet, false, "", "Origin", false},
+		{7, []string{"https://example.com"}, http.MethodOptions, "https://example.com", "", false, "https://example.com", "Origin", true},
+	} {
+		req := httptest.NewRequest(tt.method, "/", nil)
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+		if tt.requestMethod != "" {
+			req.Header.Set("Access-Control-Request-Method", tt.requestMethod)
+		}
+		rec := httptest.NewRecorder()
+		preflight := newCORSPolicy(tt.origins).handle(rec, req)
+		recr := rec.Result()
+		if got, want := preflight, tt.wantPreflight; got != want {
+			t.Errorf("test(%d): got %v, want %v", tt.n, got, want)
+		}
+		if got, want := recr.Header.Get("Access-Control-Allow-Origin"), tt.wantAllowOrigin; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		if got, want := recr.Header.Get("Vary"), tt.wantVary; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		if got, want := recr.Header.Get("Access-Control-Expose-Headers") != "", tt.wantExposeHeaders; got != want {
+			t.Errorf("test(%d): got %v, want %v", tt.n, got, want)
+		}
+		if tt.wantPreflight {
+			if got, want := recr.StatusCode, http.StatusNoContent; got != want {
+				t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+			}
+			if got, want := recr.Header.Get("Access-Control-Allow-Methods"), "GET, HEAD"; got != want {
+				t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+			}
+			if got, want := recr.Header.Get("Access-Control-Allow-Headers"), strings.Join(corsAllowedHeaders, ", "); got != want {
+				t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+			}
+		}
+	}
+}
+
+func TestGoproxyServeHTTPCORS(t *testing.T) {
+	g := &Goproxy{
+		CORSOrigins: []string{"https://example.com"},
+		Cacher:      DirCacher(t.TempDir()),
+		ErrorLogger: log.New(io.Discard, "", 0),
+		AdminToken:  "foobar",
+	}
+	for _, tt := range []struct {
+		n               int
+		handler         http.Handler
+		method          string
+		wantStatusCode  int
+		wantAllowOrigin string
+	}{
+		{1, g, http.MethodGet, http.StatusNotFound, "https://example.com"},
+		{2, g, http.MethodOptions, http.StatusNoContent, "https://example.com"},
+		{3, g, http.MethodPost, http.StatusMethodNotAllowed, "https://example.com"},
+		{4, g.AdminHandler(), http.MethodOptions, http.StatusUnauthorized, ""},
+	} {
+		req := httptest.NewRequest(tt.method, "/example.com/@v/list", nil)
+		req.Header.Set("Origin", "https://example.com")
+		req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+		rec := httptest.NewRecorder()
+		tt.handler.ServeHTTP(rec, req)
+		recr := rec.Result()
+		if got, want := recr.StatusCode, tt.wantStatusCode; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if got, want := recr.Header.Get("Access-Control-Allow-Origin"), tt.wantAllowOrigin; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package goproxy
+
+import (
+	"context"
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// debugHeader is the request header that turns on debug logging for a request
+// served by [Goproxy] when it carries the [Goproxy.AdminToken]. See
+// [Goproxy.DebugRequests].
+const debugHeader = "X-Goproxy-Debug"
+
+// redactedValue replaces the values that may carry credentials in debug logs.
+const redactedValue = "[REDACTED]"
+
+// debugRedactedHeaders are the request headers whose values are redacted in
+// debug logs.
+var debugRedactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", debugHeader}
+
+// debugUnredactedEnvKeys are the keys of the environment variables of the go
+// command whose values are logged as is in debug logs, since they are always
+// set by [Goproxy] itself to fixed values.
+var debugUnredactedEnvKeys = []string{"GO111MODULE", "GOTOOLCHAIN", "GOPROXY", "GONOPROXY", "GOSUMDB", "GONOSUMDB", "GOPRIVATE"}
+
+// requestDebugContextKey is the context key for the [requestDebug] of a
+// request.
+type requestDebugContextKey struct{}
+
+// requestDebug is the debug logging state of a request being served.
+type requestDebug struct {
+	logger    *slog.Logger
+	requestID string
+}
+
+// withRequestDebug returns a copy of the ctx that carries the rd.
+func withRequestDebug(ctx context.Context, rd *requestDebug) context.Context {
+	return context.WithValue(ctx, requestDebugContextKey{}, rd)
+}
+
+// logRequestDebug logs the msg with the attrs at [slog.LevelDebug] for the
+// request whose [requestDebug] is carried by the ctx. It does nothing if the
+// ctx carries no [requestDebug].
+func logRequestDebug(ctx context.Context, msg string, attrs ...slog.Attr) {
+	rd, ok := ctx.Value(requestDebugContextKey{}).(*requestDebug)
+	if !ok {
+		return
+	}
+	attrs = append([]slog.Attr{slog.String("request_id", rd.requestID)}, attrs...)
+	rd.logger.LogAttrs(ctx, slog.LevelDebug, msg, attrs...)
+}
+
+// shouldDebugRequest reports whether the req is logged in detail. See
+// [Goproxy.DebugRequests].
+func (g *Goproxy) shouldDebugRequest(req *http.Request) bool {
+	if g.Logger == nil {
+		return false
+	}
+	if g.DebugRequests {
+		return true
+	}
+	token := req.Header.Get(debugHeader)
+	return token != "" && g.AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(g.AdminToken)) == 1
+}
+
+// debugHeaderAttr returns the header as a [slog.Attr] with the key, with the
+// values of the [debugRedactedHeaders] redacted.
+func debugHeaderAttr(key string, header http.Header) slog.Attr {
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	attrs := make([]any, 0, len(keys))
+	for _, k := range keys {
+		v := strings.Join(header[k], ", ")
+		if slices.Contains(debugRedactedHeaders, http.CanonicalHeaderKey(k)) {
+			v = redactedValue
+		}
+		attrs = append(attrs, slog.String(k, v))
+	}
+	return slog.Group(key, attrs...)
+}
+
+// debugEnv returns the env with the values of all but the
+// [debugUnredactedEnvKeys] redacted.
+func debugEnv(env []string) []string {
+	redacted := make([]string, 0, len(env))
+	for _, e := range env {
+		k, v, _ := strings.Cut(e, "=")
+		if !slices.Contains(debugUnredactedEnvKeys, k) {
+			v = redactedValue
+		}
+		redacted = append(redacted, k+"="+v)
+	}
+	return redacted
+}
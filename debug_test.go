@@ -0,0 +1,80 @@
+package goproxy
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGoproxyServeHTTPDebugRequests(t *testing.T) {
+	proxyServer, setProxyHandler := newHTTPTestServer()
+	defer proxyServer.Close()
+	setProxyHandler(func(rw http.ResponseWriter, req *http.Request) {
+		responseString(rw, req, http.StatusOK, 60, "v1.0.0\n")
+	})
+
+	for _, tt := range []struct {
+		n             int
+		debugRequests bool
+		debugHeader   string
+		wantDebug     bool
+	}{
+		{1, true, "", true},
+		{2, false, "secret", true},
+		{3, false, "wrong", false},
+		{4, false, "", false},
+	} {
+		var logBuf bytes.Buffer
+		g := &Goproxy{
+			Env:           []string{"GOPROXY=" + proxyServer.URL, "GOSUMDB=off"},
+			TempDir:       t.TempDir(),
+			AdminToken:    "secret",
+			DebugRequests: tt.debugRequests,
+			Logger:        slog.New(slog.NewTextHandler(&logBuf, &slog.HandlerOptions{Level: slog.LevelDebug})),
+		}
+		req := httptest.NewRequest(http.MethodGet, "/example.com/@v/list", nil)
+		req.Header.Set("Authorization", "Bearer token")
+		if tt.debugHeader != "" {
+			req.Header.Set("X-Goproxy-Debug", tt.debugHeader)
+		}
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, req)
+		if got, want := rec.Code, http.StatusOK; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+
+		logs := logBuf.String()
+		for _, want := range []string{
+			`msg="debug: received request" request_id=`,
+			`uri=/example.com/@v/list`,
+			`header.Authorization=[REDACTED]`,
+			`msg="debug: decoded fetch request"`,
+			`module_path=example.com module_version=latest op=list`,
+			`msg="debug: requested upstream"`,
+			`url=` + proxyServer.URL + `/example.com/@v/list attempt=0 status=200`,
+			`msg="debug: served request"`,
+			`header.Content-Length=6`,
+			`bytes=6`,
+		} {
+			if got := strings.Contains(logs, want); got != tt.wantDebug {
+				t.Errorf("test(%d): got %v, want %v for %q in %q", tt.n, got, tt.wantDebug, want, logs)
+			}
+		}
+		for _, unwanted := range []string{"Bearer token", "secret"} {
+			if strings.Contains(logs, unwanted) {
+				t.Errorf("test(%d): got %q in %q", tt.n, unwanted, logs)
+			}
+		}
+	}
+}
+
+func TestDebugEnv(t *testing.T) {
+	got := strings.Join(debugEnv([]string{"GOPROXY=direct", "GOFLAGS=-mod=mod", "GIT_SSH_COMMAND=ssh -i key", "NETRC"}), " ")
+	want := "GOPROXY=direct GOFLAGS=[REDACTED] GIT_SSH_COMMAND=[REDACTED] NETRC=[REDACTED]"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,84 @@
+package goproxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// goEnvVars are the variables of the Go environment read by [NewFromEnv].
+var goEnvVars = []string{
+	"GOPROXY",
+	"GONOPROXY",
+	"GOSUMDB",
+	"GONOSUMDB",
+	"GOPRIVATE",
+	"GOINSECURE",
+	"GOMODCACHE",
+	"GOFLAGS",
+}
+
+// NewFromEnv returns a new [Goproxy] configured from the Go environment, so
+// that it fetches modules and verifies them as the go command would. The
+// GOPROXY, GONOPROXY, GOSUMDB, GONOSUMDB, GOPRIVATE, GOINSECURE, GOMODCACHE,
+// and GOFLAGS are resolved by "go env", which takes the ones set by
+// "go env -w" into account as well as the defaults, and set in the Env over
+// the process environment. The rest of the process environment is kept, so that
+// the go command executing direct fetches sees the same environment.
+//
+// Any field of the returned Goproxy, including the Env, can be overridden
+// before it is used, as with a Goproxy built from scratch. For example:
+//
+//	g, err := goproxy.NewFromEnv()
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	g.Cacher = goproxy.DirCacher("cache")
+//	http.ListenAndServe("localhost:8080", g)
+//
+// It returns an error if the go command cannot be executed, or if the Goproxy
+// is invalid as reported by [Goproxy.Validate].
+func NewFromEnv() (*Goproxy, error) {
+	return newFromEnv(context.Background(), "go", os.Environ())
+}
+
+// newFromEnv implements [NewFromEnv] using the go command targeted by the
+// goBinName with the environ.
+func newFromEnv(ctx context.Context, goBinName string, environ []string) (*Goproxy, error) {
+	cmd := exec.CommandContext(ctx, goBinName, append([]string{"env", "-json"}, goEnvVars...)...)
+	cmd.Env = append(environ[:len(environ):len(environ)], "GOTOOLCHAIN=local")
+	stdout, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Go environment: %w", goCommandError(stdout, err))
+	}
+	var goEnv map[string]string
+	if err := json.Unmarshal(stdout, &goEnv); err != nil {
+		return nil, fmt.Errorf("failed to read Go environment: %w", err)
+	}
+
+	env := make([]string, 0, len(environ)+len(goEnvVars))
+	for _, kv := range environ {
+		if k, _, _ := strings.Cut(kv, "="); k != "" {
+			if _, ok := goEnv[strings.TrimSpace(k)]; ok {
+				continue
+			}
+		}
+		env = append(env, kv)
+	}
+	for _, k := range goEnvVars {
+		if v, ok := goEnv[k]; ok {
+			env = append(env, k+"="+v)
+		}
+	}
+
+	// A throwaway Goproxy is validated instead of the returned one, since
+	// validating initializes it, which would make later overrides of its
+	// fields ineffective.
+	if err := (&Goproxy{Env: env}).Validate(); err != nil {
+		return nil, err
+	}
+	return &Goproxy{Env: env}, nil
+}
@@ -0,0 +1,86 @@
+package goproxy
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestNewFromEnv(t *testing.T) {
+	goEnvFile := filepath.Join(t.TempDir(), "env")
+	if err := os.WriteFile(goEnvFile, []byte("GOPROXY=https://example.com\nGOPRIVATE=example.org\nGOSUMDB=sum.golang.google.cn\n"), 0o644); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	environ := []string{
+		"PATH=" + os.Getenv("PATH"),
+		"HOME=" + t.TempDir(),
+		"GOENV=" + goEnvFile,
+		"GOSUMDB=off",
+		"GOFLAGS=",
+		"FOO=bar",
+	}
+
+	g, err := newFromEnv(context.Background(), "go", environ)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	for _, want := range []string{
+		"FOO=bar",
+		"GOPROXY=https://example.com",
+		"GONOPROXY=example.org",
+		"GOSUMDB=off",
+		"GONOSUMDB=example.org",
+		"GOPRIVATE=example.org",
+	} {
+		if !slices.Contains(g.Env, want) {
+			t.Errorf("got %q, want containing %q", g.Env, want)
+		}
+	}
+	for _, k := range goEnvVars {
+		var n int
+		for _, kv := range g.Env {
+			if strings.HasPrefix(kv, k+"=") {
+				n++
+			}
+		}
+		if got, want := n, 1; got != want {
+			t.Errorf("%s: got %d, want %d", k, got, want)
+		}
+	}
+	if g.httpClient != nil {
+		t.Fatal("expected uninitialized Goproxy")
+	}
+	g.initOnce.Do(g.init)
+	if got, want := g.envGOPROXY, "https://example.com"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := g.envGONOPROXY, "example.org"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := g.envGOSUMDB, "off"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	g, err = newFromEnv(context.Background(), "go", environ)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	transport := &http.Transport{}
+	g.Env = append(g.Env, "GOPROXY=https://example.net")
+	g.Transport = transport
+	g.initOnce.Do(g.init)
+	if got, want := g.envGOPROXY, "https://example.net"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := g.httpClient.Transport, http.RoundTripper(transport); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if _, err := newFromEnv(context.Background(), filepath.Join(t.TempDir(), "go"), environ); err == nil {
+		t.Fatal("expected error")
+	}
+}
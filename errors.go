@@ -0,0 +1,137 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+)
+
+// NotExistError is an error indicating that a module, or the version or file
+// of a module, does not exist. It is responded with a 404 status code, or with
+// a 410 status code if Gone is true.
+//
+// A [Fetcher] may return a NotExistError to have the [Goproxy] fall back to its
+// built-in fetching behavior, since it satisfies errors.Is(err,
+// fs.ErrNotExist).
+type NotExistError struct {
+	// Gone indicates whether an upstream has reported that the module is
+	// gone, with a 410 status code of its own.
+	Gone bool
+
+	// Err is the underlying error.
+	Err error
+}
+
+// Error implements [error].
+func (nee *NotExistError) Error() string {
+	if nee.Err == nil {
+		return errNotFound.Error()
+	}
+	return nee.Err.Error()
+}
+
+// Unwrap returns the nee.Err.
+func (nee *NotExistError) Unwrap() error {
+	return nee.Err
+}
+
+// Is reports whether the target matches [errNotFound] or [fs.ErrNotExist].
+func (*NotExistError) Is(target error) bool {
+	switch target {
+	case errNotFound, fs.ErrNotExist:
+		return true
+	}
+	return false
+}
+
+// UpstreamError is an error indicating that an upstream, such as a proxy in
+// the GOPROXY, a VCS host, or a checksum database, could not be reached or
+// failed to respond properly. It is responded with a 404 status code, or, if
+// [Goproxy.UpstreamErrorStatus] is set, with a 502 status code, or with a 504
+// status code if TimedOut is true.
+type UpstreamError struct {
+	// TimedOut indicates whether the upstream did not respond in time.
+	TimedOut bool
+
+	// Err is the underlying error.
+	Err error
+}
+
+// Error implements [error].
+func (ue *UpstreamError) Error() string {
+	if ue.Err == nil {
+		if ue.TimedOut {
+			return errFetchTimedOut.Error()
+		}
+		return errBadUpstream.Error()
+	}
+	return ue.Err.Error()
+}
+
+// Unwrap returns the ue.Err.
+func (ue *UpstreamError) Unwrap() error {
+	return ue.Err
+}
+
+// PolicyError is an error indicating that a module, or a version of it, is
+// denied by the policy of a [Goproxy], such as its AllowedModulePatterns,
+// BlockedModulePatterns, or ApprovedVersions. It is responded with a 403
+// status code.
+type PolicyError struct {
+	// ModulePath is the path of the denied module.
+	ModulePath string
+
+	// ModuleVersion is the denied version of the module, or empty if the
+	// module is denied as a whole.
+	ModuleVersion string
+
+	// Reason is why the module is denied, such as "blocked", "not allowed",
+	// or "not approved".
+	Reason string
+}
+
+// Error implements [error].
+func (pe *PolicyError) Error() string {
+	if pe.ModuleVersion != "" {
+		return fmt.Sprintf("version %s of module %s is %s by this proxy", pe.ModuleVersion, pe.ModulePath, pe.Reason)
+	}
+	return fmt.Sprintf("module %s is %s by this proxy", pe.ModulePath, pe.Reason)
+}
+
+// classifyError returns the err as a [*NotExistError] or an [*UpstreamError]
+// if it indicates either, so that it is responded with a deterministic status
+// code. Errors that already are one of them or a [*PolicyError], and errors
+// that indicate neither, are returned as is.
+//
+// Failures to reach upstreams are often reported as "not found" errors, such
+// as by the go command and the checksum database client, so they are told
+// apart by their messages.
+func classifyError(err error) error {
+	var (
+		nee *NotExistError
+		ue  *UpstreamError
+		pe  *PolicyError
+	)
+	if err == nil || errors.As(err, &nee) || errors.As(err, &ue) || errors.As(err, &pe) {
+		return err
+	}
+	msg := err.Error()
+	if errors.Is(err, errBadUpstream) || strings.Contains(msg, errBadUpstream.Error()) {
+		return &UpstreamError{Err: err}
+	}
+	if t, ok := err.(interface{ Timeout() bool }); (ok && t.Timeout()) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, errFetchTimedOut) ||
+		strings.Contains(msg, errFetchTimedOut.Error()) {
+		return &UpstreamError{TimedOut: true, Err: err}
+	}
+	if isRetryableGoCommandError(err) {
+		return &UpstreamError{Err: err}
+	}
+	if errors.Is(err, errNotFound) {
+		return &NotExistError{Err: err}
+	}
+	return err
+}
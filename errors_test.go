@@ -0,0 +1,82 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"testing"
+)
+
+func TestClassifyError(t *testing.T) {
+	for _, tt := range []struct {
+		n            int
+		err          error
+		wantNotExist bool
+		wantGone     bool
+		wantUpstream bool
+		wantTimedOut bool
+		wantPolicy   bool
+	}{
+		{n: 1, err: errNotFound, wantNotExist: true},
+		{n: 2, err: notFoundError("unknown revision v1.0.0"), wantNotExist: true},
+		{n: 3, err: &NotExistError{Gone: true, Err: notFoundError("gone")}, wantNotExist: true, wantGone: true},
+		{n: 4, err: errBadUpstream, wantUpstream: true},
+		{n: 5, err: notFoundError("example.com@v1.0.0: bad upstream"), wantUpstream: true},
+		{n: 6, err: &circuitOpenError{host: "example.com"}, wantUpstream: true},
+		{n: 7, err: notFoundError("dial tcp: connection refused"), wantUpstream: true},
+		{n: 8, err: errFetchTimedOut, wantUpstream: true, wantTimedOut: true},
+		{n: 9, err: fmt.Errorf("command: %w", context.DeadlineExceeded), wantUpstream: true, wantTimedOut: true},
+		{n: 10, err: &PolicyError{ModulePath: "example.com", Reason: "blocked"}, wantPolicy: true},
+		{n: 11, err: errors.New("internal")},
+	} {
+		err := classifyError(tt.err)
+		if got, want := err.Error(), tt.err.Error(); got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		var nee *NotExistError
+		if got, want := errors.As(err, &nee), tt.wantNotExist; got != want {
+			t.Errorf("test(%d): got %t, want %t", tt.n, got, want)
+		} else if got && nee.Gone != tt.wantGone {
+			t.Errorf("test(%d): got %t, want %t", tt.n, nee.Gone, tt.wantGone)
+		}
+		var ue *UpstreamError
+		if got, want := errors.As(err, &ue), tt.wantUpstream; got != want {
+			t.Errorf("test(%d): got %t, want %t", tt.n, got, want)
+		} else if got && ue.TimedOut != tt.wantTimedOut {
+			t.Errorf("test(%d): got %t, want %t", tt.n, ue.TimedOut, tt.wantTimedOut)
+		}
+		var pe *PolicyError
+		if got, want := errors.As(err, &pe), tt.wantPolicy; got != want {
+			t.Errorf("test(%d): got %t, want %t", tt.n, got, want)
+		}
+	}
+}
+
+func TestNotExistError(t *testing.T) {
+	err := &NotExistError{Err: errors.New("no such module")}
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Error("expected errors.Is(err, fs.ErrNotExist) to be true")
+	}
+	if got, want := err.Error(), "no such module"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := (&NotExistError{}).Error(), "not found"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestPolicyError(t *testing.T) {
+	for _, tt := range []struct {
+		n       int
+		err     *PolicyError
+		wantMsg string
+	}{
+		{1, &PolicyError{ModulePath: "example.com", Reason: "blocked"}, "module example.com is blocked by this proxy"},
+		{2, &PolicyError{ModulePath: "example.com", ModuleVersion: "v1.0.0", Reason: "not approved"}, "version v1.0.0 of module example.com is not approved by this proxy"},
+	} {
+		if got, want := tt.err.Error(), tt.wantMsg; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+}
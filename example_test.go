@@ -0,0 +1,44 @@
+package goproxy_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/goproxy/goproxy"
+)
+
+// This example tests the expiry of a cached version list by advancing a fake
+// clock shared by the Goproxy and its MemoryCacher, instead of sleeping.
+func ExampleGoproxy_Now() {
+	now := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	cacher := &goproxy.MemoryCacher{Now: clock}
+	g := &goproxy.Goproxy{
+		Env:                 []string{"GOPROXY=off", "GOSUMDB=off"},
+		Cacher:              cacher,
+		ListCacheTTL:        time.Minute,
+		DisableStaleOnError: true,
+		Now:                 clock,
+		ErrorLogger:         log.New(io.Discard, "", 0),
+	}
+	cacher.Put(context.Background(), "example.com/@v/list", strings.NewReader("v1.0.0"))
+
+	for _, advance := range []time.Duration{0, 30 * time.Second, time.Minute} {
+		now = now.Add(advance)
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/example.com/@v/list", nil))
+		fmt.Println(rec.Code, rec.Body.String())
+	}
+
+	// Output:
+	// 200 v1.0.0
+	// 200 v1.0.0
+	// 404 not found: module lookup disabled by GOPROXY=off
+}
@@ -2,16 +2,19 @@ package goproxy
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"log/slog"
 	"os"
 	"os/exec"
 	"path"
-	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/mod/module"
@@ -19,6 +22,7 @@ import (
 	"golang.org/x/mod/sumdb"
 	"golang.org/x/mod/sumdb/dirhash"
 	"golang.org/x/mod/zip"
+	"golang.org/x/sync/singleflight"
 )
 
 // fetch is a module fetch. All of its fields are populated only by [newFetch].
@@ -34,7 +38,11 @@ type fetch struct {
 	contentType      string
 }
 
-// newFetch parses the name and returns a new [fetch].
+// newFetch parses the name and returns a new [fetch]. The module path and
+// version in the name are validated as by [module.UnescapePath] and
+// [module.UnescapeVersion], which reject "..", absolute paths, and control
+// characters among others, before anything is done with them. Invalid ones are
+// reported as [errBadRequest].
 func newFetch(g *Goproxy, name, tempDir string) (*fetch, error) {
 	f := &fetch{
 		g:       g,
@@ -83,7 +91,7 @@ func newFetch(g *Goproxy, name, tempDir string) (*fetch, error) {
 		var err error
 		f.moduleVersion, err = module.UnescapeVersion(escapedModuleVersion)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("%w: %v", errBadRequest, err)
 		}
 
 		if f.moduleVersion == "latest" {
@@ -99,16 +107,53 @@ func newFetch(g *Goproxy, name, tempDir string) (*fetch, error) {
 	var err error
 	f.modulePath, err = module.UnescapePath(escapedModulePath)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %v", errBadRequest, err)
 	}
 	f.modAtVer = f.modulePath + "@" + f.moduleVersion
-	f.requiredToVerify = g.envGOSUMDB != "off" && !globsMatchPath(g.envGONOSUMDB, f.modulePath)
+	f.requiredToVerify = g.moduleRoute(f.modulePath).sumdb != ""
 	return f, nil
 }
 
 // do executes the f.
 func (f *fetch) do(ctx context.Context) (*fetchResult, error) {
-	if globsMatchPath(f.g.envGONOPROXY, f.modulePath) {
+	ctx, span := f.g.startSpan(ctx, "goproxy.fetch", fetchSpanAttrs(f)...)
+	startTime := time.Now()
+	var (
+		r   *fetchResult
+		err error
+	)
+	if sourceModulePath, ok := f.g.rewriteModulePath(f.modulePath); ok {
+		r, err = f.doRewritten(ctx, sourceModulePath)
+	} else {
+		r, err = f.doWalkGOPROXY(ctx)
+	}
+	if err == nil && r.Zip != "" && r.Sum == "" {
+		if r.Sum, err = dirhash.HashZip(r.Zip, dirhash.DefaultHash); err != nil {
+			err = fmt.Errorf("hash zip file: %w", err)
+		}
+	}
+	endSpan(span, err)
+	duration := time.Since(startTime)
+	f.g.metrics.observeFetchDuration(metricsEndpoint(f.name), duration)
+	addRequestLogAttrs(ctx, slog.Duration("fetch_duration", duration))
+	if err != nil {
+		f.g.metrics.incFetchErrors(f.modulePath)
+		addRequestLogAttrs(ctx, slog.String("fetch_error", err.Error()))
+		return nil, classifyError(err)
+	}
+	return r, nil
+}
+
+// doWalkGOPROXY executes the f via the f.g.Fetcher, if any, and then by
+// walking through the GOPROXY.
+func (f *fetch) doWalkGOPROXY(ctx context.Context) (*fetchResult, error) {
+	if f.g.Fetcher != nil {
+		r, err := f.doFetcher(ctx)
+		if err == nil || !errors.Is(err, fs.ErrNotExist) {
+			return r, err
+		}
+	}
+	if f.g.moduleRoute(f.modulePath).direct {
 		return f.doDirect(ctx)
 	}
 	var r *fetchResult
@@ -130,17 +175,25 @@ func (f *fetch) do(ctx context.Context) (*fetchResult, error) {
 }
 
 // doProxy executes the f via the proxy.
-func (f *fetch) doProxy(ctx context.Context, proxy string) (*fetchResult, error) {
+func (f *fetch) doProxy(ctx context.Context, proxy string) (_ *fetchResult, err error) {
 	proxyURL, err := parseRawURL(proxy)
 	if err != nil {
 		return nil, err
 	}
 
+	ctx, span := f.g.startSpan(ctx, "goproxy.fetch.proxy", append(fetchSpanAttrs(f), attrKeyUpstream.String(proxyURL.Redacted()))...)
+	defer func() { endSpan(span, err) }()
+
 	tempFile, err := os.CreateTemp(f.tempDir, "")
 	if err != nil {
 		return nil, err
 	}
-	if err := httpGet(ctx, f.g.httpClient, appendURL(proxyURL, f.name).String(), tempFile); err != nil {
+	dst := io.Writer(tempFile)
+	if f.ops == fetchOpsDownloadZip {
+		dst = limitZipFileWriter(tempFile, f.g.maxZipFileSize)
+	}
+	if err := httpGet(ctx, f.g.httpClient, f.g.fetchRetryPolicy, proxyURL.JoinPath(f.name).String(), dst); err != nil {
+		tempFile.Close()
 		return nil, err
 	}
 	if err := tempFile.Close(); err != nil {
@@ -154,7 +207,7 @@ func (f *fetch) doProxy(ctx context.Context, proxy string) (*fetchResult, error)
 		if err != nil {
 			return nil, err
 		}
-		r.Version, r.Time, err = unmarshalInfo(string(b))
+		r.Version, r.Time, r.Origin, err = unmarshalInfo(string(b))
 		if err != nil {
 			return nil, notFoundError(fmt.Sprintf("invalid info response: %v", err))
 		}
@@ -174,11 +227,9 @@ func (f *fetch) doProxy(ctx context.Context, proxy string) (*fetchResult, error)
 			}
 		}
 
-		sort.Slice(r.Versions, func(i, j int) bool {
-			return semver.Compare(r.Versions[i], r.Versions[j]) < 0
-		})
+		sortVersions(r.Versions)
 	case fetchOpsDownloadInfo:
-		if err := checkAndFormatInfoFile(tempFile.Name()); err != nil {
+		if err := checkAndFormatInfoFile(tempFile.Name(), nil); err != nil {
 			return nil, err
 		}
 		r.Info = tempFile.Name()
@@ -197,7 +248,7 @@ func (f *fetch) doProxy(ctx context.Context, proxy string) (*fetchResult, error)
 			return nil, err
 		}
 		if f.requiredToVerify {
-			if err := verifyZipFile(f.g.sumdbClient, tempFile.Name(), f.modulePath, f.moduleVersion); err != nil {
+			if r.Sum, err = verifyZipFile(f.g.sumdbClient, tempFile.Name(), f.modulePath, f.moduleVersion); err != nil {
 				return nil, err
 			}
 		}
@@ -206,84 +257,523 @@ func (f *fetch) doProxy(ctx context.Context, proxy string) (*fetchResult, error)
 	return r, nil
 }
 
+// doFetcher executes the f via the f.g.Fetcher.
+func (f *fetch) doFetcher(ctx context.Context) (*fetchResult, error) {
+	r := &fetchResult{f: f}
+	switch f.ops {
+	case fetchOpsResolve:
+		version, t, err := f.g.Fetcher.Query(ctx, f.modulePath, f.moduleVersion)
+		if err != nil {
+			return nil, err
+		}
+		if !semver.IsValid(version) {
+			return nil, notFoundError(fmt.Sprintf("invalid query result: invalid version %q", version))
+		} else if t.IsZero() {
+			return nil, notFoundError("invalid query result: zero time")
+		}
+		r.Version, r.Time = version, t
+	case fetchOpsList:
+		versions, err := f.g.Fetcher.List(ctx, f.modulePath)
+		if err != nil {
+			return nil, err
+		}
+		r.Versions = make([]string, 0, len(versions))
+		for _, version := range versions {
+			if semver.IsValid(version) && !module.IsPseudoVersion(version) {
+				r.Versions = append(r.Versions, version)
+			}
+		}
+		sortVersions(r.Versions)
+	case fetchOpsDownloadInfo, fetchOpsDownloadMod, fetchOpsDownloadZip:
+		info, mod, zip, err := f.g.Fetcher.Download(ctx, f.modulePath, f.moduleVersion)
+		for _, rc := range []io.ReadCloser{info, mod, zip} {
+			if rc != nil {
+				defer rc.Close()
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if info == nil || mod == nil || zip == nil {
+			return nil, fmt.Errorf("invalid download result of fetcher: missing info, mod, or zip file of %s@%s", f.modulePath, f.moduleVersion)
+		}
+
+		if r.Info, err = writeTempFile(f.tempDir, info, -1); err != nil {
+			return nil, err
+		}
+		if r.GoMod, err = writeTempFile(f.tempDir, mod, -1); err != nil {
+			return nil, err
+		}
+		if r.Zip, err = writeTempFile(f.tempDir, zip, f.g.maxZipFileSize); err != nil {
+			return nil, err
+		}
+
+		if err := checkAndFormatInfoFile(r.Info, nil); err != nil {
+			return nil, err
+		}
+		if err := checkModFile(r.GoMod); err != nil {
+			return nil, err
+		}
+		if err := checkZipFile(r.Zip, f.modulePath, f.moduleVersion); err != nil {
+			return nil, err
+		}
+		if f.requiredToVerify {
+			if err := verifyModFile(f.g.sumdbClient, r.GoMod, f.modulePath, f.moduleVersion); err != nil {
+				return nil, err
+			}
+			zipHash, err := verifyZipFile(f.g.sumdbClient, r.Zip, f.modulePath, f.moduleVersion)
+			if err != nil {
+				return nil, err
+			}
+			r.Sum = zipHash
+		}
+	}
+	return r, nil
+}
+
+// writeTempFile writes the content to a new temporary file in the dir and
+// returns its name. If the maxZipFileSize is not negative, the content is
+// treated as a module zip file and the write fails with a
+// [*zipFileTooLargeError] as soon as it exceeds the maxZipFileSize.
+func writeTempFile(dir string, content io.Reader, maxZipFileSize int64) (string, error) {
+	f, err := os.CreateTemp(dir, "")
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(limitZipFileWriter(f, maxZipFileSize), content); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// zipFileTooLargeError is an error indicating that a module zip file exceeds
+// the maximum size.
+type zipFileTooLargeError struct {
+	maxSize int64
+}
+
+// Error implements [error].
+func (ztle *zipFileTooLargeError) Error() string {
+	return fmt.Sprintf("module zip file exceeds the maximum size of %d bytes", ztle.maxSize)
+}
+
+// zipFileSizeLimitedWriter is an [io.Writer] that fails with a
+// [*zipFileTooLargeError] once more than maxSize bytes are written.
+type zipFileSizeLimitedWriter struct {
+	w         io.Writer
+	maxSize   int64
+	remaining int64
+}
+
+// limitZipFileWriter returns an [io.Writer] that writes to the w until the
+// maxSize is exceeded. A negative maxSize means no limit.
+func limitZipFileWriter(w io.Writer, maxSize int64) io.Writer {
+	if maxSize < 0 {
+		return w
+	}
+	return &zipFileSizeLimitedWriter{w: w, maxSize: maxSize, remaining: maxSize}
+}
+
+// Write implements [io.Writer].
+func (zfslw *zipFileSizeLimitedWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > zfslw.remaining {
+		return 0, &zipFileTooLargeError{maxSize: zfslw.maxSize}
+	}
+	zfslw.remaining -= int64(len(p))
+	return zfslw.w.Write(p)
+}
+
 // doDirect executes the f directly using the local go command.
 func (f *fetch) doDirect(ctx context.Context) (*fetchResult, error) {
+	if f.g.DisableDirectFetch {
+		return nil, notFoundError("direct module fetch disabled by DisableDirectFetch")
+	}
+
+	if mm := f.g.moduleFetchMutex; mm != nil {
+		unlock, err := mm.lock(ctx, f.modulePath)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+	}
+
+	stopWaiting := f.g.loadShedder.startWaiting(f.g.now())
+	switch f.ops {
+	case fetchOpsDownloadInfo, fetchOpsDownloadMod, fetchOpsDownloadZip:
+		if f.g.directDownloadSlots != nil {
+			select {
+			case f.g.directDownloadSlots <- struct{}{}:
+			case <-ctx.Done():
+				stopWaiting()
+				return nil, ctx.Err()
+			}
+			defer func() { <-f.g.directDownloadSlots }()
+		}
+	}
 	if f.g.directFetchWorkerPool != nil {
-		f.g.directFetchWorkerPool <- struct{}{}
+		select {
+		case f.g.directFetchWorkerPool <- struct{}{}:
+		case <-ctx.Done():
+			stopWaiting()
+			return nil, ctx.Err()
+		}
 		defer func() { <-f.g.directFetchWorkerPool }()
 	}
+	stopWaiting()
+
+	// The circuit breaker is consulted only once the slots are taken, so
+	// that a half-open probe is always followed by a record of its outcome,
+	// even if its ctx is done while waiting for them.
+	host, _, _ := strings.Cut(f.modulePath, "/")
+	if cb := f.g.circuitBreaker; cb != nil {
+		if !cb.allow(host, f.g.now()) {
+			return nil, &circuitOpenError{host: host}
+		}
+	}
+
+	f.g.metrics.addDirectFetchesInFlight(1)
+	defer f.g.metrics.addDirectFetchesInFlight(-1)
 
+	// Note that the go command resolves vanity import paths (via the
+	// "go-import" meta tag lookup) by itself on every execution, and there
+	// is no way to hand it a resolution we have already made. So caching
+	// the resolutions here would only add lookups, not save any.
 	var args []string
 	switch f.ops {
 	case fetchOpsResolve:
 		args = []string{"list", "-json", "-m", f.modAtVer}
 	case fetchOpsList:
 		args = []string{"list", "-json", "-m", "-versions", f.modAtVer}
+		if f.g.goVersionAtLeast("go1.16") && !f.g.FilterRetractedVersions {
+			// With -retracted, the go command lists tags without
+			// loading the go.mod file of the latest version for its
+			// retractions, which also keeps retracted versions listed
+			// as by other Go module proxies.
+			args = []string{"list", "-json", "-m", "-versions", "-retracted", f.modAtVer}
+		}
 	case fetchOpsDownloadInfo, fetchOpsDownloadMod, fetchOpsDownloadZip:
 		args = []string{"mod", "download", "-json", f.modAtVer}
 	}
 
-	cmd := exec.CommandContext(ctx, f.g.goBinName, args...)
-	cmd.Env = f.g.env
-	cmd.Dir = f.tempDir
-	stdout, err := cmd.Output()
-	if err != nil {
-		if err := ctx.Err(); errors.Is(err, context.DeadlineExceeded) {
-			return nil, fmt.Errorf("command %v: %w", cmd.Args, err)
+	var (
+		stdout []byte
+		err    error
+	)
+	for attempt := 0; f.g.fetchRetryPolicy.wait(ctx, attempt); attempt++ {
+		cmdCtx, span := f.g.startSpan(ctx, "goproxy.go_command", append(fetchSpanAttrs(f), attrKeyGoCommandArgs.StringSlice(args))...)
+		cmd := exec.CommandContext(cmdCtx, f.g.goBinName, args...)
+		cmd.Env = f.g.env
+		cmd.Dir = f.tempDir
+		startTime := time.Now()
+		stdout, err = cmd.Output()
+		debugAttrs := []slog.Attr{
+			slog.Any("args", cmd.Args),
+			slog.Any("env", debugEnv(cmd.Env)),
+			slog.String("dir", cmd.Dir),
+			slog.Int("exit_status", cmd.ProcessState.ExitCode()),
+			slog.Duration("duration", time.Since(startTime)),
 		}
-
-		output := stdout
-		if len(output) > 0 {
-			var goError struct{ Error string }
-			if err := json.Unmarshal(output, &goError); err != nil {
-				return nil, err
-			}
-			if goError.Error != "" {
-				output = []byte(goError.Error)
-			}
-		} else if ee, ok := err.(*exec.ExitError); ok {
-			output = ee.Stderr
-		} else {
-			return nil, err
+		if ee, ok := err.(*exec.ExitError); ok {
+			debugAttrs = append(debugAttrs, slog.String("stderr", string(ee.Stderr)))
 		}
-
-		var msg string
-		for _, line := range strings.Split(string(output), "\n") {
-			if !strings.HasPrefix(line, "go: finding") {
-				msg += line + "\n"
-			}
+		logRequestDebug(ctx, "debug: executed go command", debugAttrs...)
+		if err == nil {
+			span.End()
+			break
 		}
-		msg = strings.TrimPrefix(msg, "go: ")
-		msg = strings.TrimPrefix(msg, "go list -m: ")
-		msg = strings.TrimRight(msg, "\n")
-		return nil, notFoundError(msg)
+		if ctxErr := ctx.Err(); errors.Is(ctxErr, context.DeadlineExceeded) {
+			err = fmt.Errorf("command %v: %w", cmd.Args, ctxErr)
+			endSpan(span, err)
+			break
+		}
+		err = goCommandError(stdout, err)
+		endSpan(span, err)
+		if !isRetryableGoCommandError(err) {
+			break
+		}
+	}
+	if cb := f.g.circuitBreaker; cb != nil {
+		failed := errors.Is(err, context.DeadlineExceeded) || isRetryableGoCommandError(err)
+		cb.record(host, failed, !failed && ctx.Err() == nil, f.g.now())
+	}
+	if err != nil {
+		return nil, err
 	}
 
 	r := &fetchResult{f: f}
 	if err := json.Unmarshal(stdout, r); err != nil {
 		return nil, err
 	}
+	r.Origin = compactInfoOrigin(r.Origin)
 	switch f.ops {
 	case fetchOpsList:
-		sort.Slice(r.Versions, func(i, j int) bool {
-			return semver.Compare(r.Versions[i], r.Versions[j]) < 0
-		})
+		sortVersions(r.Versions)
+
+		// Without -retracted, the go command omits retracted versions
+		// by itself, fetching only the go.mod file of the latest
+		// version instead of the entire module.
+		r.retractionsFiltered = f.g.FilterRetractedVersions && f.g.goVersionAtLeast("go1.16")
 	case fetchOpsDownloadInfo, fetchOpsDownloadMod, fetchOpsDownloadZip:
-		if err := checkAndFormatInfoFile(r.Info); err != nil {
+		if f.g.maxZipFileSize >= 0 {
+			fi, err := os.Stat(r.Zip)
+			if err != nil {
+				return nil, err
+			}
+			if fi.Size() > f.g.maxZipFileSize {
+				return nil, &zipFileTooLargeError{maxSize: f.g.maxZipFileSize}
+			}
+		}
+		if err := checkAndFormatInfoFile(r.Info, r.Origin); err != nil {
 			return nil, err
 		}
 		if f.requiredToVerify {
 			if err := verifyModFile(f.g.sumdbClient, r.GoMod, f.modulePath, f.moduleVersion); err != nil {
 				return nil, err
 			}
-			if err := verifyZipFile(f.g.sumdbClient, r.Zip, f.modulePath, f.moduleVersion); err != nil {
+			zipHash, err := verifyZipFile(f.g.sumdbClient, r.Zip, f.modulePath, f.moduleVersion)
+			if err != nil {
 				return nil, err
 			}
+			r.Sum = zipHash
 		}
 	}
 	return r, nil
 }
 
+// sortVersions sorts the versions in ascending semver order, in which
+// pre-releases, including pseudo-versions, sort before their releases. Versions
+// that compare equal, such as those differing only in build metadata like
+// "+incompatible", are ordered by string comparison, so the order is stable no
+// matter in which order the versions were listed.
+func sortVersions(versions []string) {
+	semver.Sort(versions)
+}
+
+// goCommandError returns the error of a failed go command execution that wrote
+// the stdout and returned the err.
+func goCommandError(stdout []byte, err error) error {
+	output := stdout
+	if len(output) > 0 {
+		var goError struct{ Error string }
+		if err := json.Unmarshal(output, &goError); err != nil {
+			return err
+		}
+		if goError.Error != "" {
+			output = []byte(goError.Error)
+		}
+	} else if ee, ok := err.(*exec.ExitError); ok {
+		output = ee.Stderr
+	} else {
+		return err
+	}
+
+	var msg string
+	for _, line := range strings.Split(string(output), "\n") {
+		if !strings.HasPrefix(line, "go: finding") {
+			msg += line + "\n"
+		}
+	}
+	msg = strings.TrimPrefix(msg, "go: ")
+	msg = strings.TrimPrefix(msg, "go list -m: ")
+	msg = strings.TrimRight(msg, "\n")
+	return notFoundError(msg)
+}
+
+// retryableGoCommandErrorMsgs are the substrings of go command error messages
+// that indicate a transient failure.
+var retryableGoCommandErrorMsgs = []string{
+	"connection refused",
+	"connection reset by peer",
+	"i/o timeout",
+	"TLS handshake timeout",
+	"unexpected EOF",
+	"429 Too Many Requests",
+	"500 Internal Server Error",
+	"502 Bad Gateway",
+	"503 Service Unavailable",
+	"504 Gateway Timeout",
+	"The requested URL returned error: 5",
+}
+
+// isRetryableGoCommandError reports whether the err returned by
+// [goCommandError] indicates a transient failure.
+func isRetryableGoCommandError(err error) bool {
+	if !errors.Is(err, errNotFound) {
+		return false
+	}
+	msg := err.Error()
+	for _, s := range retryableGoCommandErrorMsgs {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchGroup deduplicates concurrent identical fetches so that only one of them
+// is executed and its result is shared by all callers. The zero value is ready
+// to use.
+type fetchGroup struct {
+	sf      singleflight.Group
+	mutex   sync.Mutex
+	flights map[string]*fetchFlight
+}
+
+// fetchFlight tracks the callers of [fetchGroup.do] for the same key, and the
+// temporary directories that hold the results shared between them.
+type fetchFlight struct {
+	refs     int
+	tempDirs []string
+}
+
+// do executes the doFunc with the f once for all concurrent callers with the
+// same f.ops and f.modAtVer, and returns its result to every one of them. A
+// failed result is shared only by the callers that are waiting for it, so a
+// subsequent call executes the doFunc again.
+//
+// The doFunc is executed with a copy of the f whose tempDir is a new temporary
+// directory created in the baseTempDir, and with a context that is not
+// canceled when the ctx is canceled, but still has the deadline of the ctx, so
+// a caller going away does not fail the fetch for the others.
+//
+// The release must be called once the caller is done with the result, which
+// remains valid until then.
+func (fg *fetchGroup) do(
+	ctx context.Context,
+	f *fetch,
+	baseTempDir string,
+	doFunc func(ctx context.Context, f *fetch) (*fetchResult, error),
+) (r *fetchResult, release func(), err error) {
+	key := f.ops.String() + " " + f.modAtVer
+	ff := fg.acquire(key)
+	release = func() { fg.release(key, ff) }
+
+	ch := fg.sf.DoChan(key, func() (any, error) {
+		// The doFunc holds its own reference, so the temporary
+		// directory outlives the caller that started it.
+		ff := fg.acquire(key)
+		defer fg.release(key, ff)
+
+		tempDir, err := os.MkdirTemp(baseTempDir, "goproxy.tmp.*")
+		if err != nil {
+			return nil, err
+		}
+		fg.mutex.Lock()
+		ff.tempDirs = append(ff.tempDirs, tempDir)
+		fg.mutex.Unlock()
+
+		doCtx := context.WithoutCancel(ctx)
+		if deadline, ok := ctx.Deadline(); ok {
+			var cancel context.CancelFunc
+			doCtx, cancel = context.WithDeadline(doCtx, deadline)
+			defer cancel()
+		}
+
+		sf := *f
+		sf.tempDir = tempDir
+		return doFunc(doCtx, &sf)
+	})
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			release()
+			return nil, nil, res.Err
+		}
+		if res.Shared {
+			addRequestLogAttrs(ctx, slog.Bool("fetch_shared", true))
+		}
+		return res.Val.(*fetchResult), release, nil
+	case <-ctx.Done():
+		release()
+		return nil, nil, ctx.Err()
+	}
+}
+
+// acquire returns the [fetchFlight] for the key with a new reference to it.
+func (fg *fetchGroup) acquire(key string) *fetchFlight {
+	fg.mutex.Lock()
+	defer fg.mutex.Unlock()
+	if fg.flights == nil {
+		fg.flights = map[string]*fetchFlight{}
+	}
+	ff, ok := fg.flights[key]
+	if !ok {
+		ff = &fetchFlight{}
+		fg.flights[key] = ff
+	}
+	ff.refs++
+	return ff
+}
+
+// release releases a reference to the ff for the key. When no references
+// remain, the temporary directories of the ff are removed.
+func (fg *fetchGroup) release(key string, ff *fetchFlight) {
+	fg.mutex.Lock()
+	defer fg.mutex.Unlock()
+	ff.refs--
+	if ff.refs == 0 {
+		delete(fg.flights, key)
+		for _, tempDir := range ff.tempDirs {
+			os.RemoveAll(tempDir)
+		}
+	}
+}
+
+// moduleMutex is a set of mutexes keyed by module path. A mutex exists only
+// while it is held or waited for, so the set does not grow unboundedly.
+type moduleMutex struct {
+	mutex sync.Mutex
+	locks map[string]*moduleLock
+}
+
+// moduleLock is a mutex of a [moduleMutex].
+type moduleLock struct {
+	ch   chan struct{}
+	refs int
+}
+
+// lock locks the mutex of the modulePath, waiting until the ctx is done. The
+// returned unlock must be called once the caller is done.
+func (mm *moduleMutex) lock(ctx context.Context, modulePath string) (unlock func(), err error) {
+	mm.mutex.Lock()
+	if mm.locks == nil {
+		mm.locks = map[string]*moduleLock{}
+	}
+	ml, ok := mm.locks[modulePath]
+	if !ok {
+		ml = &moduleLock{ch: make(chan struct{}, 1)}
+		mm.locks[modulePath] = ml
+	}
+	ml.refs++
+	mm.mutex.Unlock()
+
+	select {
+	case ml.ch <- struct{}{}:
+		return func() {
+			<-ml.ch
+			mm.release(modulePath, ml)
+		}, nil
+	case <-ctx.Done():
+		mm.release(modulePath, ml)
+		return nil, ctx.Err()
+	}
+}
+
+// release releases a reference to the ml for the modulePath. When no references
+// remain, the ml is removed.
+func (mm *moduleMutex) release(modulePath string, ml *moduleLock) {
+	mm.mutex.Lock()
+	defer mm.mutex.Unlock()
+	ml.refs--
+	if ml.refs == 0 {
+		delete(mm.locks, modulePath)
+	}
+}
+
 // fetchOps is the operation of [fetch].
 type fetchOps uint8
 
@@ -314,23 +804,31 @@ func (fo fetchOps) String() string {
 	return "invalid"
 }
 
-// fetchResult is a unified result for [fetch].
+// fetchResult is a unified result for [fetch]. The Sum is the hash of the Zip
+// in the same form as in go.sum files, which is always known once the
+// [fetch.do] returns.
 type fetchResult struct {
 	f *fetch
 
 	Version  string
 	Time     time.Time
+	Origin   json.RawMessage
 	Versions []string
 	Info     string
 	GoMod    string
 	Zip      string
+	Sum      string
+
+	// retractionsFiltered indicates whether the retracted versions have
+	// already been omitted from the Versions.
+	retractionsFiltered bool
 }
 
 // Open opens the content of the fr.
 func (fr *fetchResult) Open() (io.ReadSeekCloser, error) {
 	switch fr.f.ops {
 	case fetchOpsResolve:
-		content := strings.NewReader(marshalInfo(fr.Version, fr.Time))
+		content := strings.NewReader(marshalInfoWithOrigin(fr.Version, fr.Time, fr.Origin))
 		return struct {
 			io.ReadCloser
 			io.Seeker
@@ -351,38 +849,87 @@ func (fr *fetchResult) Open() (io.ReadSeekCloser, error) {
 	return nil, errors.New("invalid fetch operation")
 }
 
+// size returns the total size in bytes of the content of the fr. For download
+// operations, it includes all of the downloaded files.
+func (fr *fetchResult) size() int64 {
+	switch fr.f.ops {
+	case fetchOpsResolve:
+		return int64(len(marshalInfoWithOrigin(fr.Version, fr.Time, fr.Origin)))
+	case fetchOpsList:
+		return int64(len(strings.Join(fr.Versions, "\n")))
+	}
+	var size int64
+	for _, file := range []string{fr.Info, fr.GoMod, fr.Zip} {
+		if file == "" {
+			continue
+		}
+		if fi, err := os.Stat(file); err == nil {
+			size += fi.Size()
+		}
+	}
+	return size
+}
+
 // marshalInfo marshals the version and t as info.
 func marshalInfo(version string, t time.Time) string {
-	return fmt.Sprintf(`{"Version":%q,"Time":%q}`, version, t.UTC().Format(time.RFC3339Nano))
+	return marshalInfoWithOrigin(version, t, nil)
 }
 
-// unmarshalInfo unmarshals the s as info and returns version and time.
-func unmarshalInfo(s string) (string, time.Time, error) {
+// marshalInfoWithOrigin marshals the version, t, and origin as info. The
+// origin is the VCS provenance of the version reported by the go command, and
+// is omitted if it is empty.
+func marshalInfoWithOrigin(version string, t time.Time, origin json.RawMessage) string {
+	info := fmt.Sprintf(`{"Version":%q,"Time":%q`, version, t.UTC().Format(time.RFC3339Nano))
+	if len(origin) > 0 {
+		info += `,"Origin":` + string(origin)
+	}
+	return info + "}"
+}
+
+// unmarshalInfo unmarshals the s as info and returns version, time, and
+// origin. The returned origin is compacted, or nil if the s has none.
+func unmarshalInfo(s string) (string, time.Time, json.RawMessage, error) {
 	var info struct {
 		Version string
 		Time    time.Time
+		Origin  json.RawMessage
 	}
 	if err := json.Unmarshal([]byte(s), &info); err != nil {
-		return "", time.Time{}, err
+		return "", time.Time{}, nil, err
 	} else if !semver.IsValid(info.Version) {
-		return "", time.Time{}, errors.New("empty version")
+		return "", time.Time{}, nil, errors.New("empty version")
 	} else if info.Time.IsZero() {
-		return "", time.Time{}, errors.New("zero time")
+		return "", time.Time{}, nil, errors.New("zero time")
+	}
+	return info.Version, info.Time, compactInfoOrigin(info.Origin), nil
+}
+
+// compactInfoOrigin returns the compacted origin of info, or nil if the origin
+// is not a JSON object, so that a malformed origin is omitted rather than
+// failing the info.
+func compactInfoOrigin(origin json.RawMessage) json.RawMessage {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, origin); err != nil || buf.Len() == 0 || buf.Bytes()[0] != '{' {
+		return nil
 	}
-	return info.Version, info.Time, nil
+	return buf.Bytes()
 }
 
 // checkAndFormatInfoFile checks and formats the info file targeted by the name.
-func checkAndFormatInfoFile(name string) error {
+// If the info file has no origin, the origin, if any, is used instead.
+func checkAndFormatInfoFile(name string, origin json.RawMessage) error {
 	b, err := os.ReadFile(name)
 	if err != nil {
 		return err
 	}
-	infoVersion, infoTime, err := unmarshalInfo(string(b))
+	infoVersion, infoTime, infoOrigin, err := unmarshalInfo(string(b))
 	if err != nil {
 		return notFoundError(fmt.Sprintf("invalid info file: %v", err))
 	}
-	if info := marshalInfo(infoVersion, infoTime); info != string(b) {
+	if infoOrigin == nil {
+		infoOrigin = compactInfoOrigin(origin)
+	}
+	if info := marshalInfoWithOrigin(infoVersion, infoTime, infoOrigin); info != string(b) {
 		return os.WriteFile(name, []byte(info), 0o644)
 	}
 	return nil
@@ -440,20 +987,20 @@ func checkZipFile(name, modulePath, moduleVersion string) error {
 }
 
 // verifyZipFile uses the sumdbClient to verify the zip file targeted by the
-// name with the modulePath and moduleVersion.
-func verifyZipFile(sumdbClient *sumdb.Client, name, modulePath, moduleVersion string) error {
+// name with the modulePath and moduleVersion, and returns its verified hash.
+func verifyZipFile(sumdbClient *sumdb.Client, name, modulePath, moduleVersion string) (string, error) {
 	gosumLines, err := sumdbClient.Lookup(modulePath, moduleVersion)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	zipHash, err := dirhash.HashZip(name, dirhash.DefaultHash)
 	if err != nil {
-		return err
+		return "", err
 	}
 	if !stringSliceContains(gosumLines, fmt.Sprintf("%s %s %s", modulePath, moduleVersion, zipHash)) {
-		return notFoundError(fmt.Sprintf("%s@%s: invalid version: untrusted revision %s", modulePath, moduleVersion, moduleVersion))
+		return "", notFoundError(fmt.Sprintf("%s@%s: invalid version: untrusted revision %s", modulePath, moduleVersion, moduleVersion))
 	}
 
-	return nil
+	return zipHash, nil
 }
@@ -3,17 +3,24 @@ package goproxy
 import (
 	"archive/zip"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"net/http"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+	"unicode"
 
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
 	"golang.org/x/mod/sumdb"
 	"golang.org/x/mod/sumdb/dirhash"
 	"golang.org/x/mod/sumdb/note"
@@ -23,6 +30,7 @@ func TestNewFetch(t *testing.T) {
 	for _, tt := range []struct {
 		n                    int
 		env                  []string
+		noSumCheck           []string
 		name                 string
 		wantOps              fetchOps
 		wantModulePath       string
@@ -173,15 +181,57 @@ func TestNewFetch(t *testing.T) {
 		{
 			n:         18,
 			name:      "example.com/!!foobar/@latest",
-			wantError: errors.New(`invalid escaped module path "example.com/!!foobar"`),
+			wantError: errors.New(`bad request: invalid escaped module path "example.com/!!foobar"`),
 		},
 		{
 			n:         19,
 			name:      "example.com/@v/!!v1.0.0.info",
-			wantError: errors.New(`invalid escaped version "!!v1.0.0"`),
+			wantError: errors.New(`bad request: invalid escaped version "!!v1.0.0"`),
+		},
+		{
+			n:                    20,
+			name:                 "github.com/!azure/azure-sdk-for-go/@v/v1.0.0-!r!c1.zip",
+			wantOps:              fetchOpsDownloadZip,
+			wantModulePath:       "github.com/Azure/azure-sdk-for-go",
+			wantModuleVersion:    "v1.0.0-RC1",
+			wantModAtVer:         "github.com/Azure/azure-sdk-for-go@v1.0.0-RC1",
+			wantRequiredToVerify: true,
+			wantContentType:      "application/zip",
+		},
+		{
+			n:         21,
+			name:      "github.com/Azure/azure-sdk-for-go/@v/list",
+			wantError: errors.New(`bad request: invalid escaped module path "github.com/Azure/azure-sdk-for-go"`),
+		},
+		{
+			n:         22,
+			name:      "github.com/!azure/azure-sdk-for-go/@v/v1.0.0-RC1.info",
+			wantError: errors.New(`bad request: invalid escaped version "v1.0.0-RC1"`),
+		},
+		{
+			n:                    23,
+			noSumCheck:           []string{"", " example.com "},
+			name:                 "example.com/foobar/@latest",
+			wantOps:              fetchOpsResolve,
+			wantModulePath:       "example.com/foobar",
+			wantModuleVersion:    "latest",
+			wantModAtVer:         "example.com/foobar@latest",
+			wantRequiredToVerify: false,
+			wantContentType:      "application/json; charset=utf-8",
+		},
+		{
+			n:                    24,
+			noSumCheck:           []string{"example.com/foo*"},
+			name:                 "example.com/!foobar/@latest",
+			wantOps:              fetchOpsResolve,
+			wantModulePath:       "example.com/Foobar",
+			wantModuleVersion:    "latest",
+			wantModAtVer:         "example.com/Foobar@latest",
+			wantRequiredToVerify: true,
+			wantContentType:      "application/json; charset=utf-8",
 		},
 	} {
-		g := &Goproxy{Env: tt.env}
+		g := &Goproxy{Env: tt.env, NoSumCheck: tt.noSumCheck}
 		g.init()
 		f, err := newFetch(g, tt.name, "tempDir")
 		if tt.wantError != nil {
@@ -223,6 +273,53 @@ func TestNewFetch(t *testing.T) {
 	}
 }
 
+func FuzzNewFetch(f *testing.F) {
+	for _, name := range []string{
+		"example.com/@latest",
+		"example.com/@v/list",
+		"example.com/@v/v1.0.0.info",
+		"example.com/@v/v1.0.0.mod",
+		"example.com/@v/v1.0.0.zip",
+		"example.com/@v/master.info",
+		"example.com/!foobar/@v/v1.0.0-!r!c1.zip",
+		"../example.com/@v/list",
+		"/example.com/@v/list",
+		"example.com/../../@v/list",
+		"example.com/@v/../../v1.0.0.info",
+		"example.com/@v/..\\..\\v1.0.0.info",
+		"example.com/foo\x00bar/@v/list",
+		"example.com/@v/v1.0.0\n.info",
+		"-example.com/@v/list",
+	} {
+		f.Add(name)
+	}
+	root := filepath.Join("testdata", "cache")
+	g := &Goproxy{}
+	g.init()
+	f.Fuzz(func(t *testing.T, name string) {
+		ft, err := newFetch(g, name, "tempDir")
+		if err != nil {
+			return
+		}
+		if err := module.CheckPath(ft.modulePath); err != nil {
+			t.Errorf("invalid module path %q: %v", ft.modulePath, err)
+		}
+		if strings.ContainsFunc(ft.modAtVer, unicode.IsControl) {
+			t.Errorf("control character in %q", ft.modAtVer)
+		}
+		if strings.HasPrefix(ft.modAtVer, "-") {
+			t.Errorf("flag-like argument %q", ft.modAtVer)
+		}
+		file, err := DirCacher(root).file("open", ft.name)
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		if rel, err := filepath.Rel(root, file); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.IsAbs(rel) {
+			t.Errorf("cache file %q of %q is outside %q", file, name, root)
+		}
+	})
+}
+
 func TestFetchDo(t *testing.T) {
 	proxyServer, setProxyHandler := newHTTPTestServer()
 	defer proxyServer.Close()
@@ -293,6 +390,114 @@ func TestFetchDo(t *testing.T) {
 			name:      "example.com/@latest",
 			wantError: notFoundError("module lookup disabled by GOPROXY=off"),
 		},
+		{
+			n: 5,
+			proxyHandler: func(rw http.ResponseWriter, req *http.Request) {
+				if strings.HasPrefix(req.URL.Path, "/404/") {
+					responseNotFound(rw, req, 60)
+					return
+				}
+				responseSuccess(rw, req, strings.NewReader(marshalInfo("v1.0.0", infoTime)), "application/json; charset=utf-8", 60)
+			},
+			env: []string{
+				"GOPROXY=" + proxyServer.URL + "/404," + proxyServer.URL + "/200,direct",
+				"GOSUMDB=off",
+			},
+			name:        "example.com/@latest",
+			wantContent: marshalInfo("v1.0.0", infoTime),
+			wantVersion: "v1.0.0",
+			wantTime:    infoTime,
+		},
+		{
+			n: 6,
+			proxyHandler: func(rw http.ResponseWriter, req *http.Request) {
+				if strings.HasPrefix(req.URL.Path, "/501/") {
+					rw.WriteHeader(http.StatusNotImplemented)
+					return
+				}
+				responseSuccess(rw, req, strings.NewReader(marshalInfo("v1.0.0", infoTime)), "application/json; charset=utf-8", 60)
+			},
+			env: []string{
+				"GOPROXY=" + proxyServer.URL + "/501," + proxyServer.URL + "/200,direct",
+				"GOSUMDB=off",
+			},
+			name:      "example.com/@latest",
+			wantError: fmt.Errorf("GET %s/501/example.com/@latest: 501 Not Implemented: ", proxyServer.URL),
+		},
+		{
+			n: 7,
+			proxyHandler: func(rw http.ResponseWriter, req *http.Request) {
+				if strings.HasPrefix(req.URL.Path, "/501/") {
+					rw.WriteHeader(http.StatusNotImplemented)
+					return
+				}
+				responseSuccess(rw, req, strings.NewReader(marshalInfo("v1.0.0", infoTime)), "application/json; charset=utf-8", 60)
+			},
+			env: []string{
+				"GOPROXY=" + proxyServer.URL + "/501|" + proxyServer.URL + "/200,direct",
+				"GOSUMDB=off",
+			},
+			name:        "example.com/@latest",
+			wantContent: marshalInfo("v1.0.0", infoTime),
+			wantVersion: "v1.0.0",
+			wantTime:    infoTime,
+		},
+		{
+			n:            8,
+			proxyHandler: func(rw http.ResponseWriter, req *http.Request) { responseNotFound(rw, req, 60) },
+			env: []string{
+				"GOPROXY=" + proxyServer.URL,
+				"GOSUMDB=off",
+			},
+			setupGorpoxy: func(g *Goproxy) error {
+				g.Fetcher = &testFetcher{query: func(ctx context.Context, path, query string) (string, time.Time, error) {
+					return "v1.1.0", infoTime, nil
+				}}
+				return nil
+			},
+			name:        "example.com/@latest",
+			wantContent: marshalInfo("v1.1.0", infoTime),
+			wantVersion: "v1.1.0",
+			wantTime:    infoTime,
+		},
+		{
+			n: 9,
+			proxyHandler: func(rw http.ResponseWriter, req *http.Request) {
+				responseSuccess(rw, req, strings.NewReader(marshalInfo("v1.0.0", infoTime)), "application/json; charset=utf-8", 60)
+			},
+			env: []string{
+				"GOPROXY=" + proxyServer.URL,
+				"GOSUMDB=off",
+			},
+			setupGorpoxy: func(g *Goproxy) error {
+				g.Fetcher = &testFetcher{query: func(ctx context.Context, path, query string) (string, time.Time, error) {
+					return "", time.Time{}, fs.ErrNotExist
+				}}
+				return nil
+			},
+			name:        "example.com/@latest",
+			wantContent: marshalInfo("v1.0.0", infoTime),
+			wantVersion: "v1.0.0",
+			wantTime:    infoTime,
+		},
+		{
+			n: 10,
+			proxyHandler: func(rw http.ResponseWriter, req *http.Request) {
+				responseSuccess(rw, req, strings.NewReader(marshalInfo("v1.0.0", infoTime)), "application/json; charset=utf-8", 60)
+			},
+			env: []string{
+				"GOPROXY=" + proxyServer.URL,
+				"GOSUMDB=off",
+			},
+			setupGorpoxy: func(g *Goproxy) error {
+				g.Fetcher = &testFetcher{query: func(ctx context.Context, path, query string) (string, time.Time, error) {
+					return "", time.Time{}, errors.New("artifact store unavailable")
+				}}
+				return nil
+			},
+			name:      "example.com/@latest",
+			wantError: errors.New("artifact store unavailable"),
+		},
 	} {
 		setProxyHandler(tt.proxyHandler)
 		g := &Goproxy{Env: tt.env}
@@ -337,6 +542,232 @@ func TestFetchDo(t *testing.T) {
 	}
 }
 
+type testFetcher struct {
+	query    func(ctx context.Context, path, query string) (string, time.Time, error)
+	list     func(ctx context.Context, path string) ([]string, error)
+	download func(ctx context.Context, path, version string) (io.ReadCloser, io.ReadCloser, io.ReadCloser, error)
+}
+
+func (tf *testFetcher) Query(ctx context.Context, path, query string) (string, time.Time, error) {
+	if tf.query == nil {
+		return "", time.Time{}, fs.ErrNotExist
+	}
+	return tf.query(ctx, path, query)
+}
+
+func (tf *testFetcher) List(ctx context.Context, path string) ([]string, error) {
+	if tf.list == nil {
+		return nil, fs.ErrNotExist
+	}
+	return tf.list(ctx, path)
+}
+
+func (tf *testFetcher) Download(ctx context.Context, path, version string) (io.ReadCloser, io.ReadCloser, io.ReadCloser, error) {
+	if tf.download == nil {
+		return nil, nil, nil, fs.ErrNotExist
+	}
+	return tf.download(ctx, path, version)
+}
+
+func TestFetchDoFetcher(t *testing.T) {
+	infoTime := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	zipFile := filepath.Join(t.TempDir(), "zip")
+	if err := writeZipFile(zipFile, map[string][]byte{"example.com@v1.0.0/go.mod": []byte("module example.com")}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	zip, err := os.ReadFile(zipFile)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	download := func(info, mod, zip string) func(context.Context, string, string) (io.ReadCloser, io.ReadCloser, io.ReadCloser, error) {
+		return func(context.Context, string, string) (io.ReadCloser, io.ReadCloser, io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader(info)), io.NopCloser(strings.NewReader(mod)), io.NopCloser(strings.NewReader(zip)), nil
+		}
+	}
+	for _, tt := range []struct {
+		n            int
+		fetcher      *testFetcher
+		name         string
+		wantContent  string
+		wantVersion  string
+		wantTime     time.Time
+		wantVersions []string
+		wantError    error
+	}{
+		{
+			n: 1,
+			fetcher: &testFetcher{query: func(ctx context.Context, path, query string) (string, time.Time, error) {
+				if path != "example.com/Foo" || query != "master" {
+					return "", time.Time{}, fmt.Errorf("unexpected query %s@%s", path, query)
+				}
+				return "v1.0.0", infoTime, nil
+			}},
+			name:        "example.com/!foo/@v/master.info",
+			wantContent: marshalInfo("v1.0.0", infoTime),
+			wantVersion: "v1.0.0",
+			wantTime:    infoTime,
+		},
+		{
+			n: 2,
+			fetcher: &testFetcher{query: func(ctx context.Context, path, query string) (string, time.Time, error) {
+				return "master", infoTime, nil
+			}},
+			name:      "example.com/@latest",
+			wantError: notFoundError(`invalid query result: invalid version "master"`),
+		},
+		{
+			n: 3,
+			fetcher: &testFetcher{query: func(ctx context.Context, path, query string) (string, time.Time, error) {
+				return "v1.0.0", time.Time{}, nil
+			}},
+			name:      "example.com/@latest",
+			wantError: notFoundError("invalid query result: zero time"),
+		},
+		{
+			n: 4,
+			fetcher: &testFetcher{list: func(ctx context.Context, path string) ([]string, error) {
+				return []string{"v1.1.0", "v1.0.0", "v1.1.1-0.20200101000000-0123456789ab", "foobar", "v1.2.0"}, nil
+			}},
+			name:         "example.com/@v/list",
+			wantContent:  "v1.0.0\nv1.1.0\nv1.2.0",
+			wantVersions: []string{"v1.0.0", "v1.1.0", "v1.2.0"},
+		},
+		{
+			n:           5,
+			fetcher:     &testFetcher{download: download(marshalInfo("v1.0.0", infoTime), "module example.com", string(zip))},
+			name:        "example.com/@v/v1.0.0.info",
+			wantContent: marshalInfo("v1.0.0", infoTime),
+		},
+		{
+			n:           6,
+			fetcher:     &testFetcher{download: download(marshalInfo("v1.0.0", infoTime), "module example.com", string(zip))},
+			name:        "example.com/@v/v1.0.0.mod",
+			wantContent: "module example.com",
+		},
+		{
+			n:           7,
+			fetcher:     &testFetcher{download: download(marshalInfo("v1.0.0", infoTime), "module example.com", string(zip))},
+			name:        "example.com/@v/v1.0.0.zip",
+			wantContent: string(zip),
+		},
+		{
+			n:         8,
+			fetcher:   &testFetcher{download: download("{}", "module example.com", string(zip))},
+			name:      "example.com/@v/v1.0.0.info",
+			wantError: notFoundError("invalid info file: empty version"),
+		},
+		{
+			n:         9,
+			fetcher:   &testFetcher{download: download(marshalInfo("v1.0.0", infoTime), "foobar", string(zip))},
+			name:      "example.com/@v/v1.0.0.mod",
+			wantError: notFoundError("invalid mod file: missing module directive"),
+		},
+		{
+			n:         10,
+			fetcher:   &testFetcher{download: download(marshalInfo("v1.0.0", infoTime), "module example.com", "I'm a ZIP file!")},
+			name:      "example.com/@v/v1.0.0.zip",
+			wantError: notFoundError("invalid zip file: zip: not a valid zip file"),
+		},
+		{
+			n:         11,
+			fetcher:   &testFetcher{},
+			name:      "example.com/@v/v1.0.0.zip",
+			wantError: fs.ErrNotExist,
+		},
+		{
+			n: 12,
+			fetcher: &testFetcher{download: func(context.Context, string, string) (io.ReadCloser, io.ReadCloser, io.ReadCloser, error) {
+				return io.NopCloser(strings.NewReader(marshalInfo("v1.0.0", infoTime))), io.NopCloser(strings.NewReader("module example.com")), nil, nil
+			}},
+			name:      "example.com/@v/v1.0.0.info",
+			wantError: errors.New("invalid download result of fetcher: missing info, mod, or zip file of example.com@v1.0.0"),
+		},
+		{
+			n: 13,
+			fetcher: &testFetcher{download: func(context.Context, string, string) (io.ReadCloser, io.ReadCloser, io.ReadCloser, error) {
+				return nil, nil, nil, nil
+			}},
+			name:      "example.com/@v/v1.0.0.mod",
+			wantError: errors.New("invalid download result of fetcher: missing info, mod, or zip file of example.com@v1.0.0"),
+		},
+	} {
+		g := &Goproxy{
+			Env:     []string{"GOPROXY=off", "GOSUMDB=off"},
+			Fetcher: tt.fetcher,
+		}
+		g.init()
+		f, err := newFetch(g, tt.name, t.TempDir())
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		}
+		fr, err := f.doFetcher(context.Background())
+		if tt.wantError != nil {
+			if err == nil {
+				t.Fatalf("test(%d): expected error", tt.n)
+			}
+			if got, want := err, tt.wantError; !errors.Is(got, want) && got.Error() != want.Error() {
+				t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+			}
+		} else {
+			if err != nil {
+				t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+			}
+			if rsc, err := fr.Open(); err != nil {
+				t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+			} else if b, err := io.ReadAll(rsc); err != nil {
+				t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+			} else if err := rsc.Close(); err != nil {
+				t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+			} else if got, want := string(b), tt.wantContent; got != want {
+				t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+			}
+			if got, want := fr.Version, tt.wantVersion; got != want {
+				t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+			}
+			if got, want := fr.Time, tt.wantTime; !got.Equal(want) {
+				t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+			}
+			if got, want := strings.Join(fr.Versions, "\n"), strings.Join(tt.wantVersions, "\n"); got != want {
+				t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+			}
+		}
+	}
+}
+
+func TestWriteTempFile(t *testing.T) {
+	name, err := writeTempFile(t.TempDir(), strings.NewReader("foobar"), -1)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if b, err := os.ReadFile(name); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := string(b), "foobar"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if _, err := writeTempFile(t.TempDir(), &errorReadSeeker{}, -1); err == nil {
+		t.Fatal("expected error")
+	} else if got, want := err.Error(), "cannot read"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if _, err := writeTempFile(filepath.Join(t.TempDir(), "404"), strings.NewReader("foobar"), -1); err == nil {
+		t.Fatal("expected error")
+	} else if got, want := err, fs.ErrNotExist; !errors.Is(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if _, err := writeTempFile(t.TempDir(), strings.NewReader("foobar"), 6); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if _, err := writeTempFile(t.TempDir(), strings.NewReader("foobar"), 5); err == nil {
+		t.Fatal("expected error")
+	} else if got, want := err, (&zipFileTooLargeError{maxSize: 5}); got.Error() != want.Error() {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
 func TestFetchDoProxy(t *testing.T) {
 	proxyServer, setProxyHandler := newHTTPTestServer()
 	defer proxyServer.Close()
@@ -830,6 +1261,15 @@ func TestFetchDoDirect(t *testing.T) {
 			name:      "example.com/@v/v1.0.0.info",
 			wantError: notFoundError("example.com@v1.0.0: invalid version: untrusted revision v1.0.0"),
 		},
+		{
+			n:    14,
+			name: "example.com/@v/list",
+			setupFetch: func(f *fetch) error {
+				f.g.DisableDirectFetch = true
+				return nil
+			},
+			wantError: notFoundError("direct module fetch disabled by DisableDirectFetch"),
+		},
 	} {
 		ctx := context.Background()
 		switch tt.ctxTimeout {
@@ -911,6 +1351,340 @@ func TestFetchDoDirect(t *testing.T) {
 	}
 }
 
+func TestFetchDoDirectDownloadSlots(t *testing.T) {
+	g := &Goproxy{
+		Env:                append(os.Environ(), "GOPATH="+t.TempDir(), "GOPROXY=off", "GOSUMDB=off"),
+		MaxDirectFetches:   2,
+		MaxDirectDownloads: 1,
+	}
+	g.init()
+	g.env = append(g.env, "GOPROXY=off")
+	g.directDownloadSlots <- struct{}{}
+
+	f, err := newFetch(g, "example.com/@v/list", t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if _, err := f.doDirect(context.Background()); err == nil {
+		t.Fatal("expected error")
+	} else if got, want := err.Error(), "module lookup disabled by GOPROXY=off"; !strings.Contains(got, want) {
+		t.Errorf("got %q, want containing %q", got, want)
+	}
+
+	f, err = newFetch(g, "example.com/@v/v1.0.0.zip", t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := f.doDirect(ctx); err == nil {
+		t.Fatal("expected error")
+	} else if got, want := err, context.DeadlineExceeded; !errors.Is(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := len(g.directFetchWorkerPool), 0; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
+
+func TestSortVersions(t *testing.T) {
+	for _, tt := range []struct {
+		n            int
+		versions     []string
+		wantVersions []string
+	}{
+		{
+			n:            1,
+			versions:     []string{"v1.10.0", "v1.2.0", "v1.9.0", "v1.0.0"},
+			wantVersions: []string{"v1.0.0", "v1.2.0", "v1.9.0", "v1.10.0"},
+		},
+		{
+			n:            2,
+			versions:     []string{"v1.0.0", "v1.0.0-rc.1", "v1.0.0-beta", "v1.0.0-rc.10", "v1.0.0-rc.2", "v1.0.0-alpha"},
+			wantVersions: []string{"v1.0.0-alpha", "v1.0.0-beta", "v1.0.0-rc.1", "v1.0.0-rc.2", "v1.0.0-rc.10", "v1.0.0"},
+		},
+		{
+			n: 3,
+			versions: []string{
+				"v1.1.0",
+				"v1.0.1-0.20200101000000-abcdefabcdef",
+				"v1.0.0",
+				"v0.0.0-20190101000000-abcdefabcdef",
+				"v1.1.0-rc.1",
+				"v1.1.0-rc.1.0.20200201000000-abcdefabcdef",
+			},
+			wantVersions: []string{
+				"v0.0.0-20190101000000-abcdefabcdef",
+				"v1.0.0",
+				"v1.0.1-0.20200101000000-abcdefabcdef",
+				"v1.1.0-rc.1",
+				"v1.1.0-rc.1.0.20200201000000-abcdefabcdef",
+				"v1.1.0",
+			},
+		},
+		{
+			n:            4,
+			versions:     []string{"v3.0.0+incompatible", "v2.0.0+incompatible", "v1.0.0", "v2.1.0-pre+incompatible", "v2.0.0"},
+			wantVersions: []string{"v1.0.0", "v2.0.0", "v2.0.0+incompatible", "v2.1.0-pre+incompatible", "v3.0.0+incompatible"},
+		},
+		{
+			n:            5,
+			versions:     []string{"v2.0.0+incompatible", "v2.0.0"},
+			wantVersions: []string{"v2.0.0", "v2.0.0+incompatible"},
+		},
+		{
+			n:            6,
+			versions:     nil,
+			wantVersions: nil,
+		},
+	} {
+		versions := append([]string(nil), tt.versions...)
+		sortVersions(versions)
+		if got, want := strings.Join(versions, ","), strings.Join(tt.wantVersions, ","); got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		for i := 1; i < len(versions); i++ {
+			if semver.Compare(versions[i-1], versions[i]) > 0 {
+				t.Errorf("test(%d): %q sorted before %q", tt.n, versions[i-1], versions[i])
+			}
+		}
+	}
+}
+
+func TestGoCommandError(t *testing.T) {
+	for _, tt := range []struct {
+		n         int
+		stdout    string
+		err       error
+		wantError error
+	}{
+		{
+			n:         1,
+			stdout:    `{"Error":"go: example.com@v1.0.0: invalid version: unknown revision v1.0.0"}`,
+			err:       &exec.ExitError{},
+			wantError: notFoundError("example.com@v1.0.0: invalid version: unknown revision v1.0.0"),
+		},
+		{
+			n:         2,
+			err:       &exec.ExitError{Stderr: []byte("go: finding example.com v1.0.0\ngo list -m: example.com@v1.0.0: not found\n")},
+			wantError: notFoundError("example.com@v1.0.0: not found"),
+		},
+		{
+			n:         3,
+			stdout:    "{",
+			err:       &exec.ExitError{},
+			wantError: errors.New("unexpected end of JSON input"),
+		},
+		{
+			n:         4,
+			err:       exec.ErrNotFound,
+			wantError: exec.ErrNotFound,
+		},
+	} {
+		if got, want := goCommandError([]byte(tt.stdout), tt.err), tt.wantError; !errors.Is(got, want) && got.Error() != want.Error() {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+}
+
+func TestIsRetryableGoCommandError(t *testing.T) {
+	for _, tt := range []struct {
+		n    int
+		err  error
+		want bool
+	}{
+		{1, notFoundError("example.com@v1.0.0: reading https://example.com/@v/v1.0.0.info: 502 Bad Gateway"), true},
+		{2, notFoundError("example.com@v1.0.0: dial tcp: lookup example.com: i/o timeout"), true},
+		{3, notFoundError("fatal: unable to access 'https://example.com/': The requested URL returned error: 503"), true},
+		{4, notFoundError("example.com@v1.0.0: reading https://example.com/@v/v1.0.0.info: 404 Not Found"), false},
+		{5, notFoundError("example.com@v1.0.0: invalid version: unknown revision v1.0.0"), false},
+		{6, errors.New("502 Bad Gateway"), false},
+	} {
+		if got, want := isRetryableGoCommandError(tt.err), tt.want; got != want {
+			t.Errorf("test(%d): got %t, want %t", tt.n, got, want)
+		}
+	}
+}
+
+func TestFetchGroupDo(t *testing.T) {
+	g := &Goproxy{Env: []string{"GOPROXY=off", "GOSUMDB=off"}}
+	g.init()
+	f, err := newFetch(g, "example.com/@v/v1.0.0.info", t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	baseTempDir := t.TempDir()
+	infoTime := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var (
+		fg      fetchGroup
+		calls   atomic.Int32
+		started = make(chan struct{})
+		unblock = make(chan struct{})
+	)
+	doFunc := func(ctx context.Context, f *fetch) (*fetchResult, error) {
+		if calls.Add(1) == 1 {
+			close(started)
+		}
+		<-unblock
+		info, err := writeTempFile(f.tempDir, strings.NewReader(marshalInfo("v1.0.0", infoTime)), -1)
+		if err != nil {
+			return nil, err
+		}
+		return &fetchResult{f: f, Info: info}, nil
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mutex    sync.Mutex
+		releases []func()
+	)
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	for i := 0; i < 10; i++ {
+		ctx := context.Background()
+		if i == 0 {
+			ctx = leaderCtx
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			fr, release, err := fg.do(ctx, f, baseTempDir, doFunc)
+			if i == 0 {
+				if got, want := err, context.Canceled; !errors.Is(got, want) {
+					t.Errorf("got %v, want %v", got, want)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected error %q", err)
+				return
+			}
+			if rsc, err := fr.Open(); err != nil {
+				t.Errorf("unexpected error %q", err)
+			} else if b, err := io.ReadAll(rsc); err != nil {
+				t.Errorf("unexpected error %q", err)
+			} else if err := rsc.Close(); err != nil {
+				t.Errorf("unexpected error %q", err)
+			} else if got, want := string(b), marshalInfo("v1.0.0", infoTime); got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+			mutex.Lock()
+			releases = append(releases, release)
+			mutex.Unlock()
+		}(i)
+		if i == 0 {
+			<-started
+		}
+	}
+	waitRefs := func(want int) {
+		for {
+			fg.mutex.Lock()
+			refs := fg.flights["download info example.com@v1.0.0"].refs
+			fg.mutex.Unlock()
+			if refs == want {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	waitRefs(11)
+	cancelLeader()
+	waitRefs(10)
+	close(unblock)
+	wg.Wait()
+	if got, want := calls.Load(), int32(1); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+	if got, want := len(releases), 9; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+	for _, release := range releases[1:] {
+		release()
+	}
+	if entries, err := os.ReadDir(baseTempDir); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := len(entries), 1; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+	releases[0]()
+	if entries, err := os.ReadDir(baseTempDir); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := len(entries), 0; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+	if got, want := len(fg.flights), 0; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	calls.Store(0)
+	for i := 0; i < 2; i++ {
+		if _, _, err := fg.do(context.Background(), f, baseTempDir, func(ctx context.Context, f *fetch) (*fetchResult, error) {
+			calls.Add(1)
+			return nil, errNotFound
+		}); err == nil {
+			t.Fatal("expected error")
+		} else if got, want := err, errNotFound; !errors.Is(got, want) {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+	if got, want := calls.Load(), int32(2); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+	if entries, err := os.ReadDir(baseTempDir); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := len(entries), 0; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	if _, _, err := fg.do(context.Background(), f, filepath.Join(t.TempDir(), "404"), doFunc); err == nil {
+		t.Fatal("expected error")
+	} else if got, want := err, fs.ErrNotExist; !errors.Is(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestModuleMutexLock(t *testing.T) {
+	var mm moduleMutex
+	unlock, err := mm.lock(context.Background(), "example.com/foo")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	unlockOther, err := mm.lock(context.Background(), "example.com/bar")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	unlockOther()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := mm.lock(ctx, "example.com/foo"); err == nil {
+		t.Fatal("expected error")
+	} else if got, want := err, context.DeadlineExceeded; !errors.Is(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	locked := make(chan func())
+	go func() {
+		unlock, err := mm.lock(context.Background(), "example.com/foo")
+		if err != nil {
+			t.Errorf("unexpected error %q", err)
+		}
+		locked <- unlock
+	}()
+	select {
+	case <-locked:
+		t.Fatal("expected the lock to be held")
+	case <-time.After(10 * time.Millisecond):
+	}
+	unlock()
+	(<-locked)()
+
+	if got, want := len(mm.locks), 0; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
+
 func TestFetchOpsString(t *testing.T) {
 	for _, tt := range []struct {
 		n            int
@@ -972,6 +1746,11 @@ func TestFetchResultOpen(t *testing.T) {
 			fr:        &fetchResult{f: &fetch{ops: fetchOpsInvalid}},
 			wantError: errors.New("invalid fetch operation"),
 		},
+		{
+			n:           7,
+			fr:          &fetchResult{f: &fetch{ops: fetchOpsResolve}, Version: "v1.0.0", Time: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), Origin: json.RawMessage(`{"VCS":"git","Hash":"foobar"}`)},
+			wantContent: `{"Version":"v1.0.0","Time":"2000-01-01T00:00:00Z","Origin":{"VCS":"git","Hash":"foobar"}}`,
+		},
 	} {
 		if tt.setupFetchResult != nil {
 			if err := tt.setupFetchResult(tt.fr); err != nil {
@@ -1005,6 +1784,12 @@ func TestMarshalInfo(t *testing.T) {
 	if got != want {
 		t.Errorf("got %q, want %q", got, want)
 	}
+
+	got = marshalInfoWithOrigin("v1.0.0", time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), json.RawMessage(`{"VCS":"git","Hash":"foobar"}`))
+	want = `{"Version":"v1.0.0","Time":"2000-01-01T00:00:00Z","Origin":{"VCS":"git","Hash":"foobar"}}`
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
 }
 
 func TestUnmarshalInfo(t *testing.T) {
@@ -1013,6 +1798,7 @@ func TestUnmarshalInfo(t *testing.T) {
 		info        string
 		wantVersion string
 		wantTime    time.Time
+		wantOrigin  string
 		wantError   error
 	}{
 		{
@@ -1041,8 +1827,21 @@ func TestUnmarshalInfo(t *testing.T) {
 			wantVersion: "v1.0.0",
 			wantTime:    time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
 		},
+		{
+			n:           6,
+			info:        `{"Version":"v1.0.0","Time":"2000-01-01T00:00:00Z","Origin":{ "VCS": "git", "Hash": "foobar" }}`,
+			wantVersion: "v1.0.0",
+			wantTime:    time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+			wantOrigin:  `{"VCS":"git","Hash":"foobar"}`,
+		},
+		{
+			n:           7,
+			info:        `{"Version":"v1.0.0","Time":"2000-01-01T00:00:00Z","Origin":"foobar"}`,
+			wantVersion: "v1.0.0",
+			wantTime:    time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
 	} {
-		infoVersion, infoTime, err := unmarshalInfo(tt.info)
+		infoVersion, infoTime, infoOrigin, err := unmarshalInfo(tt.info)
 		if tt.wantError != nil {
 			if err == nil {
 				t.Fatalf("test(%d): expected error", tt.n)
@@ -1060,6 +1859,9 @@ func TestUnmarshalInfo(t *testing.T) {
 			if got, want := infoTime, tt.wantTime; !infoTime.Equal(tt.wantTime) {
 				t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
 			}
+			if got, want := string(infoOrigin), tt.wantOrigin; got != want {
+				t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+			}
 		}
 	}
 }
@@ -1068,6 +1870,7 @@ func TestCheckAndFormatInfoFile(t *testing.T) {
 	for _, tt := range []struct {
 		n         int
 		info      string
+		origin    string
 		wantInfo  string
 		wantError error
 	}{
@@ -1091,6 +1894,18 @@ func TestCheckAndFormatInfoFile(t *testing.T) {
 			info:      "",
 			wantError: fs.ErrNotExist,
 		},
+		{
+			n:        5,
+			info:     `{"Version":"v1.0.0","Time":"2000-01-01T00:00:00Z"}`,
+			origin:   `{ "VCS": "git", "Hash": "foobar" }`,
+			wantInfo: `{"Version":"v1.0.0","Time":"2000-01-01T00:00:00Z","Origin":{"VCS":"git","Hash":"foobar"}}`,
+		},
+		{
+			n:        6,
+			info:     `{"Version":"v1.0.0","Time":"2000-01-01T00:00:00Z","Origin":{"VCS":"git","Hash":"foobar"}}`,
+			origin:   `{"VCS":"git","Hash":"bazqux"}`,
+			wantInfo: `{"Version":"v1.0.0","Time":"2000-01-01T00:00:00Z","Origin":{"VCS":"git","Hash":"foobar"}}`,
+		},
 	} {
 		infoFile := filepath.Join(t.TempDir(), "info")
 		if tt.info != "" {
@@ -1098,7 +1913,7 @@ func TestCheckAndFormatInfoFile(t *testing.T) {
 				t.Fatalf("test(%d): unexpected error %q", tt.n, err)
 			}
 		}
-		err := checkAndFormatInfoFile(infoFile)
+		err := checkAndFormatInfoFile(infoFile, json.RawMessage(tt.origin))
 		if tt.wantError != nil {
 			if err == nil {
 				t.Fatalf("test(%d): expected error", tt.n)
@@ -1371,7 +2186,7 @@ func TestVerifyZipFile(t *testing.T) {
 			wantError:     notFoundError("example.com@v1.0.0: invalid version: untrusted revision v1.0.0"),
 		},
 	} {
-		err := verifyZipFile(g.sumdbClient, tt.zipFile, tt.modulePath, tt.moduleVersion)
+		_, err := verifyZipFile(g.sumdbClient, tt.zipFile, tt.modulePath, tt.moduleVersion)
 		if tt.wantError != nil {
 			if err == nil {
 				t.Fatalf("test(%d): expected error", tt.n)
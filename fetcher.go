@@ -0,0 +1,43 @@
+package goproxy
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// Fetcher defines a set of methods used to fetch modules for [Goproxy], in
+// place of or in front of the built-in fetching behavior that walks through
+// the GOPROXY.
+//
+// Each method receives the context of the request being served, so it is
+// canceled when the client goes away or the request times out, and its
+// values (such as those set by an [http.Handler] wrapping [Goproxy]) are
+// available. Module paths and versions are received unescaped. A Fetcher does
+// not receive the environment of the [Goproxy] (see [Goproxy.Env]); it is up
+// to the implementation to decide where modules come from.
+//
+// If a method returns an error that satisfies errors.Is(err, fs.ErrNotExist),
+// the [Goproxy] falls back to its built-in fetching behavior. This allows a
+// Fetcher to handle only a subset of modules (for example, internal modules
+// served from an artifact store), and allows multiple Fetchers to be composed
+// by chaining them in the same way.
+//
+// Results returned by a Fetcher are checked in the same way as those returned
+// by an upstream proxy, including verification against the checksum database
+// unless the module path matches GONOSUMDB or GOPRIVATE, or GOSUMDB is "off".
+type Fetcher interface {
+	// Query performs the version query for the module path. The query is
+	// "latest" or any other version query accepted by the go command, such
+	// as a branch name or a commit hash.
+	Query(ctx context.Context, path, query string) (version string, time time.Time, err error)
+
+	// List lists the known versions of the module path.
+	List(ctx context.Context, path string) (versions []string, err error)
+
+	// Download downloads the info, mod, and zip files of the module path at
+	// the version. All three must be non-nil if err is nil, otherwise the
+	// download fails with an internal error. The caller closes all returned
+	// non-nil [io.ReadCloser]s, even if err is not nil.
+	Download(ctx context.Context, path, version string) (info, mod, zip io.ReadCloser, err error)
+}
@@ -1,5 +1,15 @@
 module github.com/goproxy/goproxy
 
-go 1.18
+go 1.21
 
-require golang.org/x/mod v0.13.0
+require (
+	go.opentelemetry.io/otel v1.19.0
+	go.opentelemetry.io/otel/trace v1.19.0
+	golang.org/x/crypto v0.14.0
+	golang.org/x/mod v0.13.0
+	golang.org/x/net v0.10.0
+	golang.org/x/sync v0.4.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require golang.org/x/text v0.13.0 // indirect
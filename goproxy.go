@@ -4,24 +4,39 @@ Package goproxy implements a minimalist Go module proxy handler.
 package goproxy
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"log"
+	"log/slog"
 	"math"
 	"math/rand"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"os"
 	"path"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
 	"golang.org/x/mod/sumdb"
+	"golang.org/x/mod/sumdb/dirhash"
+	"golang.org/x/mod/sumdb/tlog"
+	"golang.org/x/mod/zip"
 )
 
 // Goproxy is the top-level struct of this project.
@@ -33,12 +48,58 @@ import (
 // GONOSUMDB, and GOPRIVATE to specify how Goproxy should verify the modules it
 // has just fetched. Importantly, all of these mentioned environment variables
 // are built-in supported, resulting in fewer external command calls and a
-// significant performance boost.
+// significant performance boost. They take precedence over each other exactly
+// as in the go command: a non-empty GONOPROXY or GONOSUMDB overrides GOPRIVATE
+// entirely, fetching a module directly never implies skipping its
+// verification, and GOINSECURE never affects verification. Checksum database
+// lookups of the modules matching GONOSUMDB (or GOPRIVATE, if GONOSUMDB is
+// empty) are answered with a 404 status code instead of being proxied, so their
+// paths are never disclosed to the checksum databases.
+//
+// If UseNetrc is set, Goproxy authenticates its outgoing requests, like the go
+// command, with the credentials in the .netrc file named by NETRC, or in the
+// home directory if NETRC is not set. Credentials are sent only over HTTPS and
+// only to the hosts that exactly match their "machine" entries. The go command
+// executing direct fetches sees the same NETRC regardless. Note that git, which
+// the go command uses to fetch from VCS hosts, reads only the .netrc file in
+// the home directory.
+//
+// Go toolchains, which the go command downloads as versions of the
+// golang.org/toolchain module (e.g., v0.0.1-go1.22.0.linux-amd64), are served
+// and cached like any other module. The go command executing direct fetches
+// always runs with GOTOOLCHAIN=local, so it never switches to another
+// toolchain itself.
 //
 // For requests involving the download of a large number of modules (e.g., for
 // bulk static analysis), Goproxy supports a non-standard header,
 // "Disable-Module-Fetch: true", which instructs it to return only cached
-// content.
+// content. See also [Goproxy.Offline].
+//
+// Only GET and HEAD requests are served. Requests of other methods are
+// responded with a 405 status code and an "Allow: GET, HEAD" header before
+// anything else is done with them, and request bodies are never read.
+//
+// Error responses are plain text, as the go command expects. Clients whose
+// Accept header lists "application/json" get a JSON object instead, with a
+// "message" field holding the same text and a stable "code" field, which is
+// one of "not_found", "bad_upstream", "fetch_timed_out", "bad_request",
+// "forbidden", "unauthorized", "too_many_requests", "quota_exceeded",
+// "service_unavailable", "maintenance", "overloaded", "method_not_allowed",
+// "zip_file_too_large", "insufficient_storage", "gone", and
+// "internal_server_error". The status code is the same either way. Requests
+// with invalid module paths or versions are responded with a 400 status code
+// before anything is done with them.
+//
+// Failed fetches are responded with a status code decided by the class of the
+// error: a 404 status code for a [*NotExistError], or a 410 status code if an
+// upstream reported the module as gone; a 404 status code for an
+// [*UpstreamError] as well, with a "bad_upstream" or "fetch_timed_out" code,
+// or a 502 or 504 status code if the UpstreamErrorStatus is set; and a 403
+// status code for a [*PolicyError]. A 500 status code always means a failure
+// of the Goproxy itself. The errors received by OnFetchComplete are of these
+// classes, so they can be inspected with [errors.As]. A full disk in the
+// TempDir or the Cacher is responded with a 507 status code, and nothing is
+// cached for the failed request, so a retry succeeds once space is freed.
 //
 // Make sure that all fields of Goproxy have been finalized before calling any
 // of its methods.
@@ -49,6 +110,13 @@ type Goproxy struct {
 	//
 	// If Env contains duplicate environment keys, only the last value in
 	// the slice for each duplicate key is used.
+	//
+	// The go command executing direct fetches runs with the Env, so it can
+	// carry settings such as GOFLAGS, GOMODCACHE, and GIT_SSH_COMMAND. The
+	// GOPROXY, GONOPROXY, GOSUMDB, GONOSUMDB, and GOPRIVATE are interpreted
+	// by the g itself instead, and the go command always runs with
+	// GOPROXY=direct and GOSUMDB=off, so direct fetches never recurse into
+	// the g or any other proxy. Values of the Env are never logged.
 	Env []string
 
 	// GoBinName is the name of the Go binary that is used to execute direct
@@ -57,14 +125,142 @@ type Goproxy struct {
 	// If GoBinName is empty, "go" is used.
 	//
 	// Note that the version of the Go binary targeted by GoBinName must be
-	// at least version 1.11.
+	// at least version 1.11. Use [Goproxy.GoVersion] to check it. Fields of
+	// the go command's JSON output that older versions do not report, such
+	// as the Origin of info files, are treated as absent.
 	GoBinName string
 
-	// MaxDirectFetches is the maximum number of concurrent direct fetches.
+	// MinGoVersion is the minimum version (e.g., "go1.21") of the Go binary
+	// targeted by GoBinName accepted by [Goproxy.GoVersion].
+	//
+	// If MinGoVersion is empty, "go1.11" is used.
+	MinGoVersion string
+
+	// MaxDirectFetches is the maximum number of concurrent direct fetches,
+	// that is, the size of the worker pool of go command invocations. It
+	// bounds cheap invocations listing versions and resolving queries as
+	// well as expensive ones downloading modules.
 	//
 	// If MaxDirectFetches is zero, there is no limit.
 	MaxDirectFetches int
 
+	// MaxDirectDownloads is the maximum number of concurrent direct fetches
+	// of the info, mod, and zip files of module versions, which clone
+	// repositories and create zip files. They wait for one of the
+	// MaxDirectDownloads before taking any of the MaxDirectFetches, so
+	// waiting downloads never hold up version lists and queries. For
+	// example, MaxDirectFetches of 50 and MaxDirectDownloads of 8 allow 50
+	// concurrent go command invocations, at most 8 of which download.
+	//
+	// If MaxDirectDownloads is zero, only the MaxDirectFetches applies.
+	MaxDirectDownloads int
+
+	// SerializeModuleFetches indicates whether direct fetches of the same
+	// module path are made one at a time, even for different versions. The
+	// later ones wait without taking any of the MaxDirectFetches, and then
+	// benefit from the VCS cache warmed by the earlier ones, which avoids
+	// concurrent clones of a large repository thrashing the disk.
+	SerializeModuleFetches bool
+
+	// FetchRetries is the maximum number of times a failed fetch is
+	// retried. Only failures that are likely to be transient are retried,
+	// such as connection errors, timeouts, and 5xx responses from upstream
+	// proxies and checksum databases. Failures such as 404 and 410
+	// responses are never retried. Direct fetches are retried when the
+	// output of the go command indicates such a failure.
+	//
+	// If FetchRetries is zero, failed fetches are retried at most twice,
+	// which is enough to ride out a dropped connection without stalling
+	// requests while an upstream is down. If FetchRetries is negative,
+	// failed fetches are never retried.
+	FetchRetries int
+
+	// FetchRetryBackoff is the base duration of the exponential backoff
+	// between retries of failed fetches. Each backoff is a random duration
+	// (also known as full jitter) up to the FetchRetryBackoff doubled for
+	// each retry, capped at ten times the FetchRetryBackoff. Retries stop as
+	// soon as the request context is done.
+	//
+	// If FetchRetryBackoff is zero, 100 milliseconds is used.
+	FetchRetryBackoff time.Duration
+
+	// FetchTimeout is the maximum amount of time a module fetch or a
+	// checksum database proxy request may take, including retries. It
+	// applies unless overridden for the kind of fetch by ListFetchTimeout,
+	// InfoFetchTimeout, ModFetchTimeout, or ZipFetchTimeout. A fetch that
+	// times out fails with a "fetch timed out" error, which is never cached.
+	// Concurrent identical requests share the timeout of the one that
+	// started the fetch.
+	//
+	// If FetchTimeout is zero, fetches are only limited by the request
+	// context.
+	FetchTimeout time.Duration
+
+	// ListFetchTimeout overrides FetchTimeout for "/@v/list", "/@latest",
+	// and version query requests, which are expected to be fast.
+	//
+	// If ListFetchTimeout is zero, FetchTimeout is used.
+	ListFetchTimeout time.Duration
+
+	// InfoFetchTimeout overrides FetchTimeout for "/@v/<version>.info"
+	// requests.
+	//
+	// If InfoFetchTimeout is zero, FetchTimeout is used.
+	InfoFetchTimeout time.Duration
+
+	// ModFetchTimeout overrides FetchTimeout for "/@v/<version>.mod"
+	// requests.
+	//
+	// If ModFetchTimeout is zero, FetchTimeout is used.
+	ModFetchTimeout time.Duration
+
+	// ZipFetchTimeout overrides FetchTimeout for "/@v/<version>.zip"
+	// requests, which can legitimately take much longer than others, for
+	// example for large modules or Go toolchains.
+	//
+	// If ZipFetchTimeout is zero, FetchTimeout is used.
+	ZipFetchTimeout time.Duration
+
+	// CircuitBreakerThreshold is the number of consecutive failed direct
+	// fetches from a host, which is the first element of module paths
+	// (e.g., "github.com"), after which further direct fetches from it fail
+	// fast with a "bad upstream" error for the CircuitBreakerCooldown. Only
+	// transient failures, as those retried per FetchRetries, and timeouts
+	// count, so a host answering that a module does not exist is healthy.
+	// Once the cooldown has elapsed, a single direct fetch is let through
+	// to probe the host. If it succeeds, the host recovers, otherwise the
+	// cooldown starts over. This keeps a degraded host from tying up
+	// direct fetches from the others.
+	//
+	// If CircuitBreakerThreshold is zero, direct fetches are never failed
+	// fast.
+	CircuitBreakerThreshold int
+
+	// CircuitBreakerWindow is the period within which the consecutive
+	// failures counted by CircuitBreakerThreshold must occur.
+	//
+	// If CircuitBreakerWindow is zero, one minute is used.
+	CircuitBreakerWindow time.Duration
+
+	// CircuitBreakerCooldown is how long direct fetches from a host fail
+	// fast once CircuitBreakerThreshold is reached.
+	//
+	// If CircuitBreakerCooldown is zero, 30 seconds is used.
+	CircuitBreakerCooldown time.Duration
+
+	// MaxZipFileSize is the maximum size in bytes of a module zip file.
+	// Fetches of larger zip files fail as soon as the limit is exceeded
+	// while downloading, and the partial downloads are discarded without
+	// being cached. Clients are responded to with a 413 status code.
+	//
+	// Note that direct fetches are also subject to the limit of the go
+	// command itself, and the size of their zip files can only be checked
+	// after they have been downloaded.
+	//
+	// If MaxZipFileSize is zero, 500 MiB is used to match the go command.
+	// If MaxZipFileSize is negative, there is no limit.
+	MaxZipFileSize int64
+
 	// ProxiedSUMDBs is a list of proxied checksum databases (see
 	// https://go.dev/design/25530-sumdb#proxying-a-checksum-database). Each
 	// entry is in the form "<sumdb-name>" or "<sumdb-name> <sumdb-URL>".
@@ -72,17 +268,389 @@ type Goproxy struct {
 	// <sumdb-URL> will be the <sumdb-name> itself as a host with an "https"
 	// scheme.
 	//
+	// All endpoints of the checksum database protocol are supported. Tiles
+	// never change once published, so they are cached by the Cacher and
+	// served from it without contacting the upstream again. The "/latest"
+	// and "/lookup/" endpoints are always fetched from the upstream, and
+	// the cached results are served only when the upstream fails.
+	//
 	// If ProxiedSUMDBs contains duplicate checksum database names, only the
 	// last value in the slice for each duplicate checksum database name is
 	// used.
 	ProxiedSUMDBs []string
 
+	// SUMDBTransports maps names of checksum databases to the transports used
+	// to proxy them as ProxiedSUMDBs, and to verify modules against them as
+	// the GOSUMDB when no proxy in the GOPROXY supports them, so that each
+	// checksum database can have its own TLS settings or credentials, such
+	// as client certificates for an internal one. Checksum databases without
+	// an entry use the Transport.
+	SUMDBTransports map[string]http.RoundTripper
+
+	// AllowedModulePatterns is a list of glob patterns (as defined by
+	// [path.Match]) of module path prefixes, in the same form as GOPRIVATE
+	// entries. If it is not empty, requests for modules whose paths match
+	// none of the patterns are denied with a 403 status code before any
+	// cache lookup or fetch.
+	//
+	// Module paths are matched after being unescaped (for example,
+	// "github.com/!foo" is matched as "github.com/Foo") and
+	// case-insensitively, since many code hosts treat paths that way.
+	//
+	// Note that checksum database proxy requests are not subject to
+	// AllowedModulePatterns.
+	AllowedModulePatterns []string
+
+	// BlockedModulePatterns is the same as AllowedModulePatterns, but
+	// requests for modules whose paths match any of the patterns are
+	// denied. It takes precedence over AllowedModulePatterns.
+	BlockedModulePatterns []string
+
+	// ApprovedVersions restricts the versions served of the modules it has
+	// rules for. Requests for the info, mod, and zip files of versions that
+	// are not approved are denied with a 403 status code, as are requests
+	// resolving to them, and version lists are filtered to only the
+	// approved versions. Only responses are restricted, so the Cacher
+	// still holds whatever has been fetched.
+	//
+	// If ApprovedVersions is nil, all versions are served.
+	ApprovedVersions *ApprovedVersions
+
+	// LatestVersionPolicies overrides how the @latest of the modules it has
+	// rules for is resolved, such as to the highest version including
+	// pre-release versions, or to the tip of a release branch. Such
+	// requests are served as requests for the info file of the version, or
+	// of the branch, that @latest resolves to.
+	//
+	// If LatestVersionPolicies is nil, @latest is resolved as by the go
+	// command.
+	LatestVersionPolicies *LatestVersionPolicies
+
+	// ModulePathRewrites maps module path prefixes to those of the modules
+	// fetched in their place, such as after the modules have moved to
+	// another host. A request for a module whose path starts with a key,
+	// as whole path elements, is fetched from the module with that prefix
+	// replaced by the value, and served under the requested path: the
+	// module path declared by the go.mod file and prefixing the zip file
+	// entries is rewritten back to the requested one. If several keys
+	// match, the longest one wins.
+	//
+	// The rewritten files differ from those of the modules fetched, so the
+	// requested modules cannot be found in checksum databases and should be
+	// private to the clients, such as by GOPRIVATE or GONOSUMDB.
+	ModulePathRewrites map[string]string
+
+	// VanityImports maps import path prefixes, such as
+	// "git.example.com/myteam/lib", to the VCS repositories of the modules
+	// under them, so the g can also serve as the host of those vanity
+	// import paths. A request whose host and path form an import path
+	// matching a key is served the go-import meta tag of the value if it
+	// carries the "go-get=1" query, or redirected to the repository browser
+	// of the value otherwise. Requests for module files and checksum
+	// databases are never redirected. If several keys match, the longest
+	// one wins.
+	//
+	// Vanity import requests are authenticated by the Authenticator, if
+	// any, like module requests, but are never affected by the maintenance
+	// mode, rate limits, or quotas.
+	VanityImports map[string]VanityImport
+
+	// NoSumCheck is a list of glob patterns (as defined by [path.Match]) of
+	// module path prefixes, in the same form as GONOSUMDB entries, of
+	// modules that are served without checksum database verification, in
+	// addition to those matching GONOSUMDB or GOPRIVATE. As with those,
+	// checksum database lookups of matching modules are also answered with a
+	// 404 status code instead of being proxied, so the g itself never
+	// discloses their paths to the checksum databases. However, unlike
+	// Private, matching modules are still fetched through the GOPROXY, and
+	// an upstream proxy may look them up in a checksum database on its own,
+	// as proxy.golang.org does. As with GONOSUMDB, module paths are matched
+	// case-sensitively.
+	//
+	// Security tradeoff: content of matching modules is passed through
+	// unverified, so a compromised upstream or VCS host can serve anything
+	// for them without detection. NoSumCheck should only ever match
+	// internal modules, never broad patterns that could also match public
+	// ones. [Goproxy.Validate] rejects patterns that match any host, such
+	// as "*".
+	NoSumCheck []string
+
+	// Private is a list of glob patterns (as defined by [path.Match]) of
+	// module path prefixes, in the same form as GOPRIVATE entries, of
+	// private modules, in addition to those matching GOPRIVATE. Matching
+	// modules are always fetched directly using the local go command, with
+	// the credentials of the .netrc file, and never from any proxy in the
+	// GOPROXY. They are served without checksum database verification, and
+	// checksum database lookups of them are answered with a 404 status code
+	// instead of being proxied, so their paths are never disclosed to public
+	// infrastructure. As with GOPRIVATE, module paths are matched
+	// case-sensitively.
+	//
+	// Note that modules matching Private are still fetched by the Fetcher,
+	// if any, which is up to the embedder.
+	Private []string
+
+	// Fetcher is used to fetch modules before walking through the GOPROXY.
+	// If the Fetcher returns an error that satisfies errors.Is(err,
+	// fs.ErrNotExist), the GOPROXY is walked through as usual.
+	//
+	// If Fetcher is nil, modules are fetched only by walking through the
+	// GOPROXY.
+	Fetcher Fetcher
+
+	// Offline indicates whether the g serves only cached content, as if
+	// every request had the "Disable-Module-Fetch: true" header. No
+	// modules are fetched and no checksum databases are proxied to
+	// upstreams, so requests for content that is not cached fail fast
+	// with a 404 status code.
+	//
+	// Offline is useful for serving a pre-populated Cacher in
+	// environments without network access.
+	Offline bool
+
+	// DisableDirectFetch indicates whether the g never fetches modules
+	// directly from their VCS hosts using the local go command, so that
+	// every module served comes from a proxy in the GOPROXY, or from the
+	// Fetcher, and is verified against the checksum databases as usual.
+	// The "direct" entry in the GOPROXY and modules matching the GONOPROXY
+	// fail with a 404 status code instead.
+	DisableDirectFetch bool
+
+	// DisableStaleOnError indicates whether the g responds with the error
+	// instead of serving the cached copy of a list or latest fetch request
+	// when fetching fails, for example while an upstream VCS is
+	// unavailable. By default, such stale responses are served with a
+	// `Warning: 110 - "Response is Stale"` header. Other fetch requests
+	// target immutable content and are always served from the cache when
+	// cached.
+	DisableStaleOnError bool
+
+	// MaxStaleAge is the maximum age of the stale cached copies served when
+	// fetching fails, measured from when they were cached. Cached copies of
+	// unknown age are considered too old.
+	//
+	// If MaxStaleAge is zero, cached copies are served regardless of their
+	// age.
+	MaxStaleAge time.Duration
+
+	// UpstreamErrorStatus indicates whether the g responds to fetches that
+	// failed because of an upstream, as an [*UpstreamError] does, with
+	// a 502 status code, or a 504 status code if the upstream timed out,
+	// instead of a 404 status code.
+	//
+	// Note that the go command falls back to the next proxy in its GOPROXY
+	// on any error only after a "|" separator. After a "," separator, it
+	// falls back only on 404 and 410 status codes, so setting
+	// UpstreamErrorStatus stops it from falling back from the g when an
+	// upstream of the g fails.
+	UpstreamErrorStatus bool
+
+	// ListCacheTTL is how long the cached result of a "/@v/list" request is
+	// served from the Cacher without fetching again, measured from when it
+	// was cached. Listing versions is cheap compared to downloading, but
+	// still takes a round trip to the upstream, which adds up for modules
+	// queried as often as popular ones are. Cached results of unknown age are
+	// never served this way.
+	//
+	// If ListCacheTTL is zero, "/@v/list" requests are always fetched.
+	ListCacheTTL time.Duration
+
+	// FilterRetractedVersions indicates whether the g omits the versions
+	// retracted by the module author from "/@v/list" responses, so that
+	// clients never see them. By default, retracted versions are listed,
+	// as by other Go module proxies, and it is up to clients to skip them.
+	//
+	// Retractions are declared in the go.mod file of the latest version,
+	// which is the highest release version in the list, or the highest
+	// pre-release version if there are no release versions. Only that
+	// go.mod file is fetched if necessary, not the zip file of the latest
+	// version, and it is cached by the Cacher like any other module file,
+	// so later lists only need to read it from the Cacher. If it cannot be
+	// obtained, the list is served unfiltered. Lists fetched directly are
+	// filtered by the local go command itself, which likewise fetches only
+	// that go.mod file.
+	FilterRetractedVersions bool
+
+	// RateLimit is the maximum number of requests per second allowed from
+	// each client IP address. Requests over the limit are rejected with a
+	// 429 status code and a Retry-After header.
+	//
+	// If RateLimit is zero, requests are not rate limited.
+	RateLimit float64
+
+	// RateBurst is the maximum number of requests allowed from each client
+	// IP address in a single burst.
+	//
+	// If RateBurst is zero, the ceiling of RateLimit is used.
+	RateBurst int
+
+	// Quotas is the set of limits on the number of bytes of responses served
+	// to the identities of clients, verified by mutual TLS or returned by
+	// the Authenticator, over rolling windows. Bytes are counted as they
+	// are sent, whether the responses are served from the Cacher or
+	// fetched, and compressed responses count by their compressed sizes.
+	// Requests from a client whose usage has reached its quota are rejected
+	// with a 429 status code until enough of the usage falls out of the
+	// window. A response in progress when the quota is reached is served in
+	// full. Anonymous clients are never limited.
+	//
+	// If Quotas is nil, the bytes served to clients are not limited.
+	Quotas *Quotas
+
+	// QuotaStore is used to record the bytes served to the identities of
+	// clients that have quotas in the Quotas.
+	//
+	// If QuotaStore is nil, a [MemoryQuotaStore] is used, so usage is
+	// neither shared between processes nor kept across restarts.
+	QuotaStore QuotaStore
+
+	// MaxConcurrentRequests is the maximum number of requests served by the
+	// g at the same time, whether they are served from the cache or
+	// fetched. A request over the limit waits up to MaxRequestQueueWait
+	// for a slot, and is rejected with a 503 status code and a Retry-After
+	// header if none frees up in time.
+	//
+	// A slot is released when the request is served, or as soon as the
+	// context of the request is done, so a slow client cannot hold a slot
+	// beyond the deadline of the request context.
+	//
+	// If MaxConcurrentRequests is zero, there is no limit.
+	MaxConcurrentRequests int
+
+	// MaxRequestQueueWait is the maximum amount of time a request waits for
+	// a slot when MaxConcurrentRequests requests are already being served.
+	//
+	// If MaxRequestQueueWait is zero, such requests are rejected at once.
+	MaxRequestQueueWait time.Duration
+
+	// LoadShedQueueWait is the maximum amount of time a direct fetch may
+	// wait for a slot of the MaxDirectFetches or MaxDirectDownloads before
+	// the g starts shedding load. While shedding load, the g responds to
+	// requests that need fetches with a 503 status code and a Retry-After
+	// header, while still serving requests that can be served from the
+	// Cacher, including version lists and @latest requests, whose cached
+	// responses are served even if stale. Shed requests are counted by the
+	// metrics served by the handler returned by [Goproxy.MetricsHandler].
+	//
+	// If LoadShedQueueWait is zero, the wait for slots never sheds load.
+	LoadShedQueueWait time.Duration
+
+	// LoadShedGoroutines is the maximum number of goroutines of the process
+	// before the g starts shedding load as described for
+	// LoadShedQueueWait.
+	//
+	// If LoadShedGoroutines is zero, the number of goroutines never sheds
+	// load.
+	LoadShedGoroutines int
+
+	// LoadShedHeapBytes is the maximum size in bytes of the heap objects of
+	// the process before the g starts shedding load as described for
+	// LoadShedQueueWait. The size is sampled at most once per second.
+	//
+	// If LoadShedHeapBytes is zero, the heap size never sheds load.
+	LoadShedHeapBytes int64
+
+	// TrustedProxies is a list of IP addresses and CIDR prefixes (e.g.,
+	// "10.0.0.0/8") of the reverse proxies in front of the g. The client IP
+	// address of a request is determined from its X-Forwarded-For header
+	// only if the request comes from one of them. Invalid entries are
+	// ignored.
+	//
+	// If TrustedProxies is empty, the X-Forwarded-For header is never
+	// honored.
+	TrustedProxies []string
+
+	// CORSOrigins is a list of origins (e.g., "https://example.com") whose
+	// browser-based tools are allowed to read the responses of the g via
+	// Cross-Origin Resource Sharing. An origin of "*" allows all origins.
+	// Only GET and HEAD requests are allowed, and "OPTIONS" preflight
+	// requests for them are answered with a 204 status code. The handlers
+	// returned by [Goproxy.AdminHandler], [Goproxy.MetricsHandler],
+	// [Goproxy.HealthHandler], and [Goproxy.ReadinessHandler] never send
+	// CORS headers.
+	//
+	// If CORSOrigins is empty, no CORS headers are sent.
+	CORSOrigins []string
+
+	// GzipMinSize is the minimum size in bytes of the successful text
+	// responses of the g, such as those of list, info, and mod files and
+	// checksum database lookups, that are gzip-compressed for clients that
+	// accept "gzip" via the Accept-Encoding header. Responses of unknown
+	// sizes, module zip files, which are already compressed, and partial
+	// responses to range requests are never compressed. Compressed
+	// responses carry weak versions of the ETag headers of their
+	// uncompressed ones.
+	//
+	// If GzipMinSize is zero or negative, responses are never compressed.
+	GzipMinSize int64
+
+	// DownloadCacheControl is the Cache-Control header of the successful
+	// and not modified responses to the info, mod, and zip file requests of
+	// module versions, whose content never changes, so that CDNs and other
+	// shared caches in front of the g can keep them for long. Error
+	// responses carry Cache-Control headers of their own.
+	//
+	// If DownloadCacheControl is empty,
+	// "public, max-age=604800, immutable" is used.
+	DownloadCacheControl string
+
+	// QueryCacheControl is the same as DownloadCacheControl, but for the
+	// "/@latest", "/@v/list", and version query requests, whose content
+	// changes as new versions are published, so that shared caches keep
+	// them only briefly.
+	//
+	// If QueryCacheControl is empty, "public, max-age=60, must-revalidate"
+	// is used.
+	QueryCacheControl string
+
+	// ReadinessUpstreams is a list of URLs that are checked for
+	// reachability by the handler returned by [Goproxy.ReadinessHandler].
+	// Each URL is considered reachable if it responds to a GET request with
+	// a status code less than 500 within a few seconds.
+	//
+	// If ReadinessUpstreams is empty, upstreams are not checked.
+	ReadinessUpstreams []string
+
+	// PathPrefix is the prefix of all request paths served by the g, such as
+	// "/goproxy" for a g mounted under "/goproxy/" behind a reverse proxy.
+	// Requests whose paths are not under it are answered with a 404 status
+	// code, except for the PathPrefix itself, which is redirected to the
+	// PathPrefix with a trailing "/". A trailing "/" is ignored.
+	//
+	// If PathPrefix is empty, request paths are served as is.
+	PathPrefix string
+
 	// Cacher is used to cache module files.
 	//
 	// If Cacher is nil, module files will be temporarily stored on the
 	// local disk and discarded when the request ends.
 	Cacher Cacher
 
+	// NotFoundTTL is how long a "not found" result of fetching a module
+	// version's info, mod, or zip file is cached by the Cacher. Until it
+	// expires, requests for the same file are answered with the cached
+	// result instead of fetching again. Since a missing version may
+	// legitimately appear later (for example, a freshly pushed tag), it
+	// should be kept short.
+	//
+	// The "not found" results are cached under the name of the requested
+	// file with a ".notfound" suffix, so they are never mistaken for module
+	// files. Expired ones are deleted when found, if the Cacher supports
+	// deletion. The ones that are never requested again are left to the
+	// Cacher, see [DirCacher.CleanNotFound].
+	//
+	// If NotFoundTTL is zero, "not found" results of downloads are not
+	// cached.
+	NotFoundTTL time.Duration
+
+	// NotFoundQueryTTL is the same as NotFoundTTL, but for the
+	// "/@latest", "/@v/list", and version query requests, whose results
+	// change more often. It should be shorter than NotFoundTTL.
+	//
+	// If NotFoundQueryTTL is zero, "not found" results of queries are not
+	// cached.
+	NotFoundQueryTTL time.Duration
+
 	// TempDir is the directory for storing temporary files.
 	//
 	// If TempDir is empty, [os.TempDir] is used.
@@ -94,22 +662,211 @@ type Goproxy struct {
 	// If Transport is nil, [http.DefaultTransport] is used.
 	Transport http.RoundTripper
 
+	// UseNetrc indicates whether the outgoing requests executed by the
+	// Transport and the SUMDBTransports are authenticated with the
+	// credentials of the .netrc file named by NETRC, or in the home
+	// directory if NETRC is not set, as the go command does. Credentials
+	// are sent only over HTTPS and only to the hosts that exactly match
+	// their "machine" entries, including when following redirects.
+	//
+	// UseNetrc does not affect direct fetches, since the go command reads
+	// the .netrc file by itself.
+	UseNetrc bool
+
+	// UserAgent is the User-Agent header of outgoing requests, excluding
+	// those initiated by direct fetches.
+	//
+	// If UserAgent is empty, the default of the [net/http] package is used.
+	UserAgent string
+
+	// RequestHeader is the set of static headers set on outgoing requests,
+	// excluding those initiated by direct fetches. It replaces any header
+	// of the same name, except that a User-Agent in it is overridden by a
+	// non-empty UserAgent. Headers of incoming requests are never forwarded
+	// upstream.
+	RequestHeader http.Header
+
 	// ErrorLogger is used to log errors that occur during proxying.
 	//
 	// If ErrorLogger is nil, [log.Default] is used.
+	//
+	// Note that ErrorLogger is ignored if Logger is not nil.
 	ErrorLogger *log.Logger
 
+	// Logger is used to emit structured log events. Errors that occur
+	// during proxying are logged at [slog.LevelError]. In addition, one
+	// event is logged at [slog.LevelInfo] for each request served, with
+	// attributes such as the method, path, module path and version, cache
+	// hit or miss, fetch error, status code, and duration.
+	//
+	// Each request is assigned a correlation ID, which is logged as the
+	// "request_id" attribute and returned in the "X-Goproxy-Request-ID"
+	// response header. If the request is made over TLS with a verified
+	// client certificate, the common name or first subject alternative
+	// name of the certificate is logged as the "client" attribute, unless
+	// the Authenticator returns a non-empty identity for the request.
+	//
+	// If Logger is nil, no request events are logged, and errors are
+	// logged to the ErrorLogger.
+	Logger *slog.Logger
+
+	// Authenticator is used to authenticate the module and checksum
+	// database requests served by the g. Requests failing authentication
+	// are responded with a 401 or 403 status code before anything else is
+	// done with them, except that the method is checked first. The
+	// identity of an authenticated client is logged, traced, and counted
+	// in the metrics like the one verified by mutual TLS, which it takes
+	// precedence over. See [BearerTokenAuthenticator] and
+	// [BasicAuthenticator] for the built-in implementations.
+	//
+	// If Authenticator is nil, requests are not authenticated.
+	Authenticator Authenticator
+
+	// AuthenticateHealthAndMetrics indicates whether the requests to the
+	// handlers returned by [Goproxy.HealthHandler],
+	// [Goproxy.ReadinessHandler], and [Goproxy.MetricsHandler] are
+	// authenticated by the Authenticator as well. The handler returned by
+	// [Goproxy.AdminHandler] always requires the AdminToken instead.
+	//
+	// If AuthenticateHealthAndMetrics is false, those handlers are exempt
+	// from authentication, so that probes and scrapers need no
+	// credentials.
+	AuthenticateHealthAndMetrics bool
+
+	// AdminToken is the bearer token required by the handler returned by
+	// [Goproxy.AdminHandler].
+	//
+	// If AdminToken is empty, all requests to that handler are rejected.
+	AdminToken string
+
+	// DebugRequests indicates whether every request served by the g is
+	// logged in detail to the Logger at [slog.LevelDebug], for debugging
+	// protocol issues such as those of vanity import paths or path
+	// escaping. Each request is logged with its request line and headers,
+	// its decoded module path, version, and operation, the go commands
+	// executed with their arguments, environments, and exit statuses, the
+	// upstream URLs requested with their status codes, and the status
+	// code, headers, and number of bytes of its response. Credentials in
+	// headers, URLs, and environments are redacted.
+	//
+	// Regardless of DebugRequests, a single request is logged in the same
+	// way if it carries the AdminToken in the "X-Goproxy-Debug" header.
+	//
+	// Note that nothing is logged if the Logger is nil or not enabled for
+	// [slog.LevelDebug].
+	DebugRequests bool
+
+	// TracerProvider is used to create OpenTelemetry spans: a root span for
+	// each request served, with child spans for cache operations, upstream
+	// fetches, and go command executions, annotated with the module path
+	// and version. The W3C trace context of incoming requests is honored,
+	// so the root span is linked to the span of the client, if any.
+	//
+	// If TracerProvider is nil, no spans are created.
+	TracerProvider trace.TracerProvider
+
+	// OnFetchStart is called when a module fetch starts, with the decoded
+	// module path and version, and the operation ("resolve", "list",
+	// "download info", "download mod", or "download zip"). Concurrent
+	// identical requests share a single fetch, so OnFetchStart is called
+	// once for all of them. For "resolve" and "list" operations, the version
+	// is the query, such as "latest".
+	//
+	// OnFetchStart, OnFetchComplete, and OnCacheHit are called inline in
+	// the request path, so they must return quickly and hand off any slow
+	// work, such as sending alerts, to another goroutine. They may be
+	// called concurrently.
+	//
+	// The ctx passed to OnFetchStart and OnFetchComplete is the one of the
+	// shared fetch. It carries the values of the request that started the
+	// fetch, but it is not canceled when that client goes away, only when
+	// the fetch times out.
+	//
+	// If OnFetchStart is nil, it is not called.
+	OnFetchStart func(ctx context.Context, modulePath, moduleVersion, op string)
+
+	// OnFetchComplete is called when a fetch started by OnFetchStart
+	// completes, with the error, if any, and the total size in bytes of the
+	// fetched content. See OnFetchStart for details.
+	//
+	// If OnFetchComplete is nil, it is not called.
+	OnFetchComplete func(ctx context.Context, modulePath, moduleVersion, op string, err error, bytes int64)
+
+	// OnCacheHit is called when a module request is served from the
+	// g.Cacher, with the ctx of the request, which is canceled when the
+	// client goes away. See OnFetchStart for details.
+	//
+	// If OnCacheHit is nil, it is not called.
+	OnCacheHit func(ctx context.Context, modulePath, moduleVersion, op string)
+
+	// WebhookURL is the URL to which the g POSTs a JSON object with the
+	// "module_path", "version", "size", and "time" fields when it caches a
+	// module zip file it has not cached before, for example to start a
+	// security scan of every new module version. Deliveries are made in the
+	// background with retries, so they never delay responses. A module
+	// version is delivered at most once a day, even if it is cached again
+	// after being evicted from the g.Cacher. Call [Goproxy.Flush] on
+	// shutdown to wait for the pending deliveries.
+	//
+	// If WebhookURL is empty, nothing is delivered.
+	WebhookURL string
+
+	// WebhookSecret is the secret with which the payloads delivered to the
+	// WebhookURL are signed. The hex-encoded HMAC-SHA256 of each payload
+	// is sent in the "X-Goproxy-Signature" header as "sha256=<hex>".
+	//
+	// If WebhookSecret is empty, payloads are not signed.
+	WebhookSecret string
+
+	// Now returns the current time. It is the time source of everything
+	// that expires or ages in the g, such as the ListCacheTTL, MaxStaleAge,
+	// NotFoundTTL, rate limiting, and circuit breaking, so tests can
+	// advance time deterministically instead of sleeping. Caches are aged
+	// by the times reported by the Cacher, so a [MemoryCacher] with the
+	// same Now should be used along with it.
+	//
+	// If Now is nil, [time.Now] is used.
+	Now func() time.Time
+
 	initOnce              sync.Once
 	env                   []string
 	envGOPROXY            string
 	envGONOPROXY          string
 	envGOSUMDB            string
 	envGONOSUMDB          string
+	noSumCheck            string
+	private               string
+	modulePathRewrites    []modulePathRewrite
+	vanityImports         []vanityImport
 	goBinName             string
+	moduleFetchMutex      *moduleMutex
 	directFetchWorkerPool chan struct{}
+	directDownloadSlots   chan struct{}
+	requestSlots          chan struct{}
+	loadShedder           *loadShedder
+	quotaStore            QuotaStore
 	proxiedSUMDBs         map[string]*url.URL
+	sumdbHTTPClients      map[string]*http.Client
 	httpClient            *http.Client
+	fetchRetryPolicy      retryPolicy
+	circuitBreaker        *circuitBreaker
+	maxZipFileSize        int64
+	tracer                trace.Tracer
+	trustedProxies        []netip.Prefix
+	corsPolicy            *corsPolicy
+	pathPrefix            string
+	goVersionOnce         sync.Once
+	goVersionMutex        sync.Mutex
+	goVersion             string
 	sumdbClient           *sumdb.Client
+	metrics               *metrics
+	readiness             *readiness
+	cacheStats            *cacheStatsCollector
+	webhook               *webhook
+	maintenance           atomic.Bool
+	policy                atomic.Pointer[policy]
+	fetchGroup            *fetchGroup
+	moduleRegistry        *moduleRegistry
 }
 
 // init initializes the g.
@@ -122,7 +879,7 @@ func (g *Goproxy) init() {
 	for _, env := range env {
 		if k, v, ok := strings.Cut(env, "="); ok {
 			switch strings.TrimSpace(k) {
-			case "GO111MODULE":
+			case "GO111MODULE", "GOTOOLCHAIN":
 			case "GOPROXY":
 				g.envGOPROXY = v
 			case "GONOPROXY":
@@ -141,6 +898,7 @@ func (g *Goproxy) init() {
 	g.env = append(
 		g.env,
 		"GO111MODULE=on",
+		"GOTOOLCHAIN=local",
 		"GOPROXY=direct",
 		"GONOPROXY=",
 		"GOSUMDB=off",
@@ -211,14 +969,46 @@ func (g *Goproxy) init() {
 		g.envGONOSUMDB = strings.Join(nosumdbs, ",")
 	}
 
+	g.policy.Store(newPolicy(Policy{
+		AllowedModulePatterns: g.AllowedModulePatterns,
+		BlockedModulePatterns: g.BlockedModulePatterns,
+		ApprovedVersions:      g.ApprovedVersions,
+		LatestVersionPolicies: g.LatestVersionPolicies,
+		RateLimit:             g.RateLimit,
+		RateBurst:             g.RateBurst,
+		Quotas:                g.Quotas,
+	}, nil))
+	g.modulePathRewrites = newModulePathRewrites(g.ModulePathRewrites)
+	g.vanityImports = newVanityImports(g.VanityImports)
+	g.noSumCheck = joinPathPatterns(g.NoSumCheck)
+	g.private = joinPathPatterns(g.Private)
+
 	g.goBinName = g.GoBinName
 	if g.goBinName == "" {
 		g.goBinName = "go"
 	}
 
+	g.maxZipFileSize = g.MaxZipFileSize
+	if g.maxZipFileSize == 0 {
+		g.maxZipFileSize = zip.MaxZipFile
+	}
 	if g.MaxDirectFetches > 0 {
 		g.directFetchWorkerPool = make(chan struct{}, g.MaxDirectFetches)
 	}
+	if g.MaxDirectDownloads > 0 {
+		g.directDownloadSlots = make(chan struct{}, g.MaxDirectDownloads)
+	}
+	if g.SerializeModuleFetches {
+		g.moduleFetchMutex = &moduleMutex{}
+	}
+	g.quotaStore = g.QuotaStore
+	if g.quotaStore == nil {
+		g.quotaStore = &MemoryQuotaStore{}
+	}
+	g.loadShedder = newLoadShedder(g.LoadShedQueueWait, g.LoadShedGoroutines, g.LoadShedHeapBytes)
+	if g.MaxConcurrentRequests > 0 {
+		g.requestSlots = make(chan struct{}, g.MaxConcurrentRequests)
+	}
 
 	g.proxiedSUMDBs = map[string]*url.URL{}
 	for _, proxiedSUMDB := range g.ProxiedSUMDBs {
@@ -238,11 +1028,66 @@ func (g *Goproxy) init() {
 		g.proxiedSUMDBs[sumdbName] = sumdbURL
 	}
 
-	g.httpClient = &http.Client{Transport: g.Transport}
+	g.metrics = newMetrics()
+	g.readiness = &readiness{}
+	g.cacheStats = &cacheStatsCollector{}
+	g.fetchGroup = &fetchGroup{}
+	g.moduleRegistry = newModuleRegistry()
+
+	var netrcLines []netrcLine
+	if g.UseNetrc {
+		netrcLines = readNetrc(env)
+	}
+	newHTTPClient := func(transport http.RoundTripper) *http.Client {
+		if len(netrcLines) > 0 {
+			transport = &netrcTransport{base: transport, lines: netrcLines}
+		}
+		if g.UserAgent != "" || len(g.RequestHeader) > 0 {
+			transport = &headerTransport{base: transport, userAgent: g.UserAgent, header: g.RequestHeader}
+		}
+		return &http.Client{Transport: transport}
+	}
+	g.httpClient = newHTTPClient(g.Transport)
+	g.sumdbHTTPClients = map[string]*http.Client{}
+	for sumdbName, transport := range g.SUMDBTransports {
+		g.sumdbHTTPClients[sumdbName] = newHTTPClient(transport)
+	}
+	g.fetchRetryPolicy = newRetryPolicy(g.FetchRetries, g.FetchRetryBackoff)
+	if g.WebhookURL != "" {
+		transport := g.Transport
+		if transport == nil {
+			transport = http.DefaultTransport
+		}
+		g.webhook = newWebhook(g.WebhookURL, g.WebhookSecret, &http.Client{Transport: transport}, g.logErrorf)
+	}
+	if g.CircuitBreakerThreshold > 0 {
+		window := g.CircuitBreakerWindow
+		if window <= 0 {
+			window = time.Minute
+		}
+		cooldown := g.CircuitBreakerCooldown
+		if cooldown <= 0 {
+			cooldown = 30 * time.Second
+		}
+		g.circuitBreaker = newCircuitBreaker(g.CircuitBreakerThreshold, window, cooldown, g.metrics.setCircuitBreakerState)
+	}
+
+	g.trustedProxies = parseTrustedProxies(g.TrustedProxies)
+	g.corsPolicy = newCORSPolicy(g.CORSOrigins)
+	g.pathPrefix = strings.TrimSuffix(g.PathPrefix, "/")
+
+	tracerProvider := g.TracerProvider
+	if tracerProvider == nil {
+		tracerProvider = trace.NewNoopTracerProvider()
+	}
+	g.tracer = tracerProvider.Tracer(tracerName)
+
 	g.sumdbClient = sumdb.NewClient(&sumdbClientOps{
-		envGOPROXY: g.envGOPROXY,
-		envGOSUMDB: g.envGOSUMDB,
-		httpClient: g.httpClient,
+		envGOPROXY:      g.envGOPROXY,
+		envGOSUMDB:      g.envGOSUMDB,
+		httpClient:      g.httpClient,
+		sumdbHTTPClient: g.sumdbHTTPClients[sumdbName(g.envGOSUMDB)],
+		retryPolicy:     g.fetchRetryPolicy,
 	})
 }
 
@@ -250,24 +1095,174 @@ func (g *Goproxy) init() {
 func (g *Goproxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	g.initOnce.Do(g.init)
 
+	requestID := newRequestID()
+	rw.Header().Set(requestIDHeader, requestID)
+	srw := &statusResponseWriter{ResponseWriter: rw}
+	rw = srw
+
+	ctx := propagation.TraceContext{}.Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+	ctx, span := g.tracer.Start(
+		ctx,
+		req.Method,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("url.path", req.URL.Path),
+			attribute.String("goproxy.request_id", requestID),
+		),
+	)
+	defer func() {
+		span.SetAttributes(attribute.Int("http.response.status_code", srw.statusCode))
+		if srw.statusCode >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(srw.statusCode))
+		}
+		span.End()
+	}()
+	req = req.WithContext(ctx)
+
+	client := clientIdentity(req)
+
+	if g.Logger != nil {
+		rl := &requestLog{}
+		req = req.WithContext(withRequestLog(req.Context(), rl))
+		startTime := time.Now()
+		defer func() {
+			attrs := append([]slog.Attr{
+				slog.String("request_id", requestID),
+				slog.String("method", req.Method),
+				slog.String("path", req.URL.Path),
+			}, rl.Attrs()...)
+			if client != "" {
+				attrs = append(attrs, slog.String("client", client))
+			}
+			attrs = append(
+				attrs,
+				slog.Int("status", srw.statusCode),
+				slog.Duration("duration", time.Since(startTime)),
+			)
+			g.Logger.LogAttrs(req.Context(), slog.LevelInfo, "served request", attrs...)
+		}()
+	}
+
+	if g.shouldDebugRequest(req) {
+		req = req.WithContext(withRequestDebug(req.Context(), &requestDebug{logger: g.Logger, requestID: requestID}))
+		logRequestDebug(
+			req.Context(),
+			"debug: received request",
+			slog.String("method", req.Method),
+			slog.String("uri", req.RequestURI),
+			slog.String("proto", req.Proto),
+			slog.String("remote_addr", req.RemoteAddr),
+			debugHeaderAttr("header", req.Header),
+		)
+		startTime := time.Now()
+		defer func() {
+			logRequestDebug(
+				req.Context(),
+				"debug: served request",
+				slog.Int("status", srw.statusCode),
+				debugHeaderAttr("header", srw.Header()),
+				slog.Int64("bytes", srw.written),
+				slog.Duration("duration", time.Since(startTime)),
+			)
+		}()
+	}
+
+	if g.corsPolicy != nil && g.corsPolicy.handle(rw, req) {
+		return
+	}
+
+	// Module and checksum database requests never have a body, so the
+	// method is checked before anything else is done with the request, and
+	// any body is refused to be read.
 	switch req.Method {
 	case http.MethodGet, http.MethodHead:
 	default:
+		rw.Header().Set("Allow", "GET, HEAD")
 		responseMethodNotAllowed(rw, req, 86400)
 		return
 	}
+	req.Body = http.MaxBytesReader(rw, req.Body, 0)
+
+	identity, ok := g.authenticate(rw, req)
+	if !ok {
+		return
+	}
+	if identity != "" {
+		client = identity
+	}
+	if client != "" {
+		span.SetAttributes(attribute.String("goproxy.client", client))
+		g.metrics.incClientRequests(client)
+	}
+
+	if vi, ok := g.vanityImport(req); ok {
+		serveVanityImport(rw, req, vi)
+		return
+	}
+
+	if g.GzipMinSize > 0 {
+		grw := newGzipResponseWriter(rw, req, g.GzipMinSize)
+		defer grw.close()
+		rw = grw
+	}
+
+	if g.Maintenance() {
+		responseMaintenance(rw, req, maintenanceRetryAfter)
+		return
+	}
+
+	if rateLimiter := g.policy.Load().rateLimiter; rateLimiter != nil {
+		if ok, retryAfter := rateLimiter.allow(clientIP(req, g.trustedProxies), g.now()); !ok {
+			responseTooManyRequests(rw, req, retryAfter)
+			return
+		}
+	}
+
+	if p := g.policy.Load(); p.quotas != nil && client != "" {
+		if !g.checkQuota(req.Context(), p, client, g.now()) {
+			responseQuotaExceeded(rw, req)
+			return
+		}
+		defer func() {
+			g.addQuotaUsage(context.WithoutCancel(req.Context()), p, client, g.now(), srw.written)
+		}()
+	}
+
+	if g.requestSlots != nil {
+		release, ok := g.acquireRequestSlot(req.Context())
+		if !ok {
+			responseServiceUnavailable(rw, req, time.Second)
+			return
+		}
+		defer release()
+	}
 
 	path := cleanPath(req.URL.Path)
 	if path != req.URL.Path || path[len(path)-1] == '/' {
 		responseNotFound(rw, req, 86400)
 		return
 	}
+	if g.pathPrefix != "" {
+		var ok bool
+		if path, ok = strings.CutPrefix(path, g.pathPrefix); ok && path == "" {
+			// The Location is built from the g.pathPrefix rather than
+			// being relative to the request path, so it stays under the
+			// prefix however the g is mounted.
+			setResponseCacheControlHeader(rw, 86400)
+			http.Redirect(rw, req, g.pathPrefix+"/", http.StatusMovedPermanently)
+			return
+		}
+		if !ok || !strings.HasPrefix(path, "/") {
+			responseNotFound(rw, req, 86400)
+			return
+		}
+	}
 	name := path[1:]
 
 	tempDir, err := os.MkdirTemp(g.TempDir, "goproxy.tmp.*")
 	if err != nil {
-		g.logErrorf("failed to create temporary directory: %v", err)
-		responseInternalServerError(rw, req)
+		g.responseStorageError(rw, req, err, "failed to create temporary directory")
 		return
 	}
 	defer os.RemoveAll(tempDir)
@@ -280,13 +1275,102 @@ func (g *Goproxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	g.serveFetch(rw, req, name, tempDir)
 }
 
+// acquireRequestSlot acquires one of the g.requestSlots, waiting up to the
+// g.MaxRequestQueueWait for it. It reports whether a slot was acquired until the
+// ctx is done. The returned release must be called once the request is served,
+// but the slot is released as soon as the ctx is done anyway.
+func (g *Goproxy) acquireRequestSlot(ctx context.Context) (release func(), ok bool) {
+	select {
+	case g.requestSlots <- struct{}{}:
+	default:
+		if g.MaxRequestQueueWait <= 0 {
+			return nil, false
+		}
+		timer := time.NewTimer(g.MaxRequestQueueWait)
+		defer timer.Stop()
+		select {
+		case g.requestSlots <- struct{}{}:
+		case <-timer.C:
+			return nil, false
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+
+	var releaseOnce sync.Once
+	releaseSlot := func() { releaseOnce.Do(func() { <-g.requestSlots }) }
+	stop := context.AfterFunc(ctx, releaseSlot)
+	return func() {
+		stop()
+		releaseSlot()
+	}, true
+}
+
+// MetricsHandler returns an [http.Handler] that serves the metrics collected by
+// the g in the Prometheus text exposition format. The metrics include cache
+// hits and misses by endpoint type, upstream fetch durations, in-flight direct
+// fetches, fetch errors by the first path element of module paths, requests by
+// the client identity verified by mutual TLS or returned by the
+// [Goproxy.Authenticator], circuit breaker states by host, requests shed under
+// load by reason, and cache evictions recorded by
+// [Goproxy.ObserveCacheEviction].
+//
+// Requests to the handler are authenticated by the g.Authenticator only if the
+// g.AuthenticateHealthAndMetrics is true.
+func (g *Goproxy) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		g.initOnce.Do(g.init)
+		if g.AuthenticateHealthAndMetrics {
+			if _, ok := g.authenticate(rw, req); !ok {
+				return
+			}
+		}
+		g.metrics.ServeHTTP(rw, req)
+	})
+}
+
+// ObserveCacheEviction records the result of an eviction run of the cache of
+// the g, such as one by [DirCacher.CleanWatermarks], in the metrics served by
+// the handler returned by [Goproxy.MetricsHandler]. Both the totals and the
+// numbers of the last run are served.
+func (g *Goproxy) ObserveCacheEviction(result DirCacherCleanResult) {
+	g.initOnce.Do(g.init)
+	g.metrics.observeCacheEviction(result.EvictedEntries, result.EvictedBytes)
+}
+
 // serveFetch serves fetch requests.
 func (g *Goproxy) serveFetch(rw http.ResponseWriter, req *http.Request, name, tempDir string) {
 	f, err := newFetch(g, name, tempDir)
 	if err != nil {
-		responseNotFound(rw, req, 86400, err)
+		if errors.Is(err, errBadRequest) {
+			responseBadRequest(rw, req, 86400, err)
+		} else {
+			responseNotFound(rw, req, 86400, err)
+		}
 		return
 	}
+	logRequestDebug(
+		req.Context(),
+		"debug: decoded fetch request",
+		slog.String("name", f.name),
+		slog.String("module_path", f.modulePath),
+		slog.String("module_version", f.moduleVersion),
+		slog.String("op", f.ops.String()),
+	)
+	p := g.policy.Load()
+	if err := p.checkModulePath(f.modulePath); err != nil {
+		responseForbidden(rw, req, -1, err)
+		return
+	}
+	addRequestLogAttrs(
+		req.Context(),
+		slog.String("module_path", f.modulePath),
+		slog.String("module_version", f.moduleVersion),
+	)
+	trace.SpanFromContext(req.Context()).SetAttributes(
+		attrKeyModulePath.String(f.modulePath),
+		attrKeyModuleVersion.String(f.moduleVersion),
+	)
 
 	var isDownload bool
 	switch f.ops {
@@ -294,35 +1378,105 @@ func (g *Goproxy) serveFetch(rw http.ResponseWriter, req *http.Request, name, te
 		isDownload = true
 	}
 
+	rw = &cacheControlResponseWriter{ResponseWriter: rw, cacheControl: g.fetchCacheControl(isDownload)}
+
+	if rule := p.approvedVersions.rule(f.modulePath); rule != nil {
+		if isDownload {
+			if !rule.allows(f.moduleVersion) {
+				responseForbidden(rw, req, -1, notApprovedError(f.modulePath, f.moduleVersion))
+				return
+			}
+		} else {
+			avrw, avReq := newApprovedVersionsResponseWriter(rw, req, f, rule)
+			defer avrw.finish()
+			rw, req = avrw, avReq
+		}
+	}
+
+	if g.serveRegisteredModule(rw, req, f) {
+		return
+	}
+
 	noFetch, _ := strconv.ParseBool(req.Header.Get("Disable-Module-Fetch"))
-	if noFetch {
+	if f.ops == fetchOpsResolve && f.moduleVersion == "latest" {
+		if rule := p.latestVersionPolicies.rule(f.modulePath); rule != nil {
+			if err := g.applyLatestVersionRule(req.Context(), f, rule, noFetch || g.Offline); err != nil {
+				g.logErrorf("failed to apply latest version policy: %s: %v", f.name, err)
+				responseError(rw, req, err, true, g.UpstreamErrorStatus)
+				return
+			}
+		}
+	}
+	if noFetch || g.Offline {
 		var cacheControlMaxAge int
 		if isDownload {
 			cacheControlMaxAge = 604800
 		} else {
 			cacheControlMaxAge = 60
 		}
-		g.serveCache(rw, req, f.name, f.contentType, cacheControlMaxAge, func() {
-			responseNotFound(rw, req, 60, "temporarily unavailable")
-		})
+		if g.serveCache(rw, req, f.name, f.contentType, cacheControlMaxAge, func() {
+			if g.Offline {
+				responseNotFound(rw, req, 60, "not cached by this proxy in offline mode")
+			} else {
+				responseNotFound(rw, req, 60, "temporarily unavailable")
+			}
+		}) && g.OnCacheHit != nil {
+			g.OnCacheHit(req.Context(), f.modulePath, f.moduleVersion, f.ops.String())
+		}
 		return
 	}
 
 	if isDownload {
-		g.serveCache(rw, req, f.name, f.contentType, 604800, func() {
+		if g.serveCache(rw, req, f.name, f.contentType, 604800, func() {
+			if reason := g.loadShedder.shed(g.now()); reason != "" {
+				g.metrics.incRequestsShed(reason)
+				responseOverloaded(rw, req, loadShedRetryAfter)
+				return
+			}
 			g.serveFetchDownload(rw, req, f)
-		})
+		}) && g.OnCacheHit != nil {
+			g.OnCacheHit(req.Context(), f.modulePath, f.moduleVersion, f.ops.String())
+		}
+		return
+	}
+
+	if f.ops == fetchOpsList && g.ListCacheTTL > 0 && g.serveFreshCache(rw, req, f, g.ListCacheTTL) {
+		if g.OnCacheHit != nil {
+			g.OnCacheHit(req.Context(), f.modulePath, f.moduleVersion, f.ops.String())
+		}
+		return
+	}
+
+	if reason := g.loadShedder.shed(g.now()); reason != "" {
+		if g.serveCache(rw, req, f.name, f.contentType, 60, func() {
+			g.metrics.incRequestsShed(reason)
+			responseOverloaded(rw, req, loadShedRetryAfter)
+		}) && g.OnCacheHit != nil {
+			g.OnCacheHit(req.Context(), f.modulePath, f.moduleVersion, f.ops.String())
+		}
 		return
 	}
 
-	fr, err := f.do(req.Context())
+	fr, release, err := g.doFetch(req.Context(), f)
 	if err != nil {
-		g.serveCache(rw, req, f.name, f.contentType, 60, func() {
+		if g.DisableStaleOnError {
 			g.logErrorf("failed to %s module version: %s: %v", f.ops, f.name, err)
-			responseError(rw, req, err, true)
-		})
+			responseError(rw, req, err, true, g.UpstreamErrorStatus)
+		} else if g.serveStaleCache(rw, req, f, err) && g.OnCacheHit != nil {
+			g.OnCacheHit(req.Context(), f.modulePath, f.moduleVersion, f.ops.String())
+		}
 		return
 	}
+	defer release()
+
+	if f.ops == fetchOpsList && g.FilterRetractedVersions && !fr.retractionsFiltered {
+		versions, err := g.filterRetractedVersions(req.Context(), f, fr.Versions, false)
+		if err != nil {
+			g.logErrorf("failed to filter retracted versions: %s: %v", f.name, err)
+		} else {
+			fr = &fetchResult{f: fr.f, Versions: versions}
+		}
+	}
 
 	content, err := fr.Open()
 	if err != nil {
@@ -333,8 +1487,7 @@ func (g *Goproxy) serveFetch(rw http.ResponseWriter, req *http.Request, name, te
 	defer content.Close()
 
 	if err := g.putCache(req.Context(), f.name, content); err != nil {
-		g.logErrorf("failed to cache module file: %s: %v", f.name, err)
-		responseInternalServerError(rw, req)
+		g.responseStorageError(rw, req, err, "failed to cache module file: %s", f.name)
 		return
 	} else if _, err := content.Seek(0, io.SeekStart); err != nil {
 		g.logErrorf("failed to seek fetch result content: %s: %v", f.name, err)
@@ -347,42 +1500,372 @@ func (g *Goproxy) serveFetch(rw http.ResponseWriter, req *http.Request, name, te
 
 // serveFetchDownload serves fetch download requests.
 func (g *Goproxy) serveFetchDownload(rw http.ResponseWriter, req *http.Request, f *fetch) {
-	fr, err := f.do(req.Context())
+	fr, release, err := g.doFetch(req.Context(), f)
 	if err != nil {
 		g.logErrorf("failed to download module version: %s: %v", f.name, err)
-		responseError(rw, req, err, false)
+		responseError(rw, req, err, false, g.UpstreamErrorStatus)
 		return
 	}
+	defer release()
 
 	nameWithoutExt := strings.TrimSuffix(f.name, path.Ext(f.name))
+	if f.ops == fetchOpsDownloadZip && isUnconditionalFullGetRequest(req) {
+		g.serveFetchDownloadZip(rw, req, f, nameWithoutExt, fr)
+		return
+	}
+
+	if err := g.putFetchDownloadCaches(req.Context(), nameWithoutExt, fr, nil); err != nil {
+		g.responseStorageError(rw, req, err, "failed to cache module files: %s", f.name)
+		return
+	}
+
+	content, err := fr.Open()
+	if err != nil {
+		g.logErrorf("failed to open fetch result: %s: %v", f.name, err)
+		responseInternalServerError(rw, req)
+		return
+	}
+	defer content.Close()
+
+	g.setETagHeader(req.Context(), rw, f.name, content)
+	if f.ops == fetchOpsDownloadInfo {
+		responseSuccess(rw, req, withInfoTime(content), f.contentType, 604800)
+	} else {
+		responseSuccess(rw, req, content, f.contentType, 604800)
+	}
+}
+
+// fetchDownloadZipWriteTimeout is the maximum amount of time each write to the
+// client teed from putting a fetched zip file to the [Goproxy.Cacher] may take.
+var fetchDownloadZipWriteTimeout = 30 * time.Second
+
+// serveFetchDownloadZip serves the zip file of the fr to the fetch download
+// request for it as soon as the zip file is on disk, instead of only after all
+// of the files of the fr have been put to the g.Cacher. The single read pass
+// of the zip file that puts it to the g.Cacher is teed to the client, which is
+// then sent whatever the g.Cacher did not read. Since the response has already
+// started by then, failing to cache the files is only logged.
+//
+// Each teed write is bounded by the fetchDownloadZipWriteTimeout, so a slow
+// client cannot hold the put, along with the temporary directory of the fr
+// and any lock of the g.Cacher, for long. Once a write fails, the client is
+// no longer written to, but the put still finishes, even if the client has
+// gone away.
+//
+// Note that the zip file cannot be streamed while it is still being created,
+// since it must be complete to be verified against the checksum database.
+func (g *Goproxy) serveFetchDownloadZip(rw http.ResponseWriter, req *http.Request, f *fetch, nameWithoutExt string, fr *fetchResult) {
+	zipFile, err := os.Open(fr.Zip)
+	if err != nil {
+		g.logErrorf("failed to open fetch result: %s: %v", f.name, err)
+		responseInternalServerError(rw, req)
+		return
+	}
+	defer zipFile.Close()
+	fi, err := zipFile.Stat()
+	if err != nil {
+		g.logErrorf("failed to stat fetch result: %s: %v", f.name, err)
+		responseInternalServerError(rw, req)
+		return
+	}
+
+	rw.Header().Set("Content-Type", f.contentType)
+	rw.Header().Set("Content-Length", strconv.FormatInt(fi.Size(), 10))
+	rw.Header().Set("ETag", strconv.Quote(fr.Sum))
+	setResponseCacheControlHeader(rw, 604800)
+	rw.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(rw)
+	trs := &teeReadSeeker{ReadSeeker: zipFile, w: &deadlineWriter{w: rw, rc: rc, timeout: fetchDownloadZipWriteTimeout}}
+	if err := g.putFetchDownloadCaches(context.WithoutCancel(req.Context()), nameWithoutExt, fr, trs); err != nil {
+		g.logErrorf("failed to cache module files: %s: %v", f.name, err)
+	}
+	if trs.err != nil {
+		g.logErrorf("failed to write fetch result: %s: %v", f.name, trs.err)
+		return
+	}
+	rc.SetWriteDeadline(time.Time{})
+	if _, err := zipFile.Seek(trs.offset, io.SeekStart); err != nil {
+		g.logErrorf("failed to seek fetch result: %s: %v", f.name, err)
+		return
+	}
+	if _, err := io.Copy(rw, zipFile); err != nil {
+		g.logErrorf("failed to write fetch result: %s: %v", f.name, err)
+	}
+}
+
+// putFetchDownloadCaches puts the info, mod, and zip files of the fr, if any,
+// to the g.Cacher under the nameWithoutExt, along with the hash of the zip file.
+// If the zipContent is not nil, it is put as the zip file instead of the
+// content of the local one.
+func (g *Goproxy) putFetchDownloadCaches(ctx context.Context, nameWithoutExt string, fr *fetchResult, zipContent io.ReadSeeker) error {
 	for _, cache := range []struct{ nameExt, localFile string }{
 		{".info", fr.Info},
 		{".mod", fr.GoMod},
-		{".zip", fr.Zip},
 	} {
 		if cache.localFile == "" {
 			continue
 		}
-		if err := g.putCacheFile(req.Context(), nameWithoutExt+cache.nameExt, cache.localFile); err != nil {
-			g.logErrorf("failed to cache module file: %s: %v", f.name, err)
-			responseInternalServerError(rw, req)
-			return
+		if err := g.putCacheFile(ctx, nameWithoutExt+cache.nameExt, cache.localFile); err != nil {
+			return err
+		}
+	}
+	if fr.Zip == "" {
+		return nil
+	}
+	if zipContent != nil {
+		if err := g.putCache(ctx, nameWithoutExt+".zip", zipContent); err != nil {
+			return err
 		}
+	} else if err := g.putCacheFile(ctx, nameWithoutExt+".zip", fr.Zip); err != nil {
+		return err
 	}
+	if err := g.putCache(ctx, nameWithoutExt+zipHashCacheNameExt, strings.NewReader(fr.Sum)); err != nil {
+		return err
+	}
+	if g.webhook != nil {
+		if fi, err := os.Stat(fr.Zip); err == nil {
+			g.webhook.notify(fr.f.modulePath, fr.f.moduleVersion, fi.Size(), g.now())
+		}
+	}
+	return nil
+}
 
-	content, err := fr.Open()
+// filterRetractedVersions returns the versions, which are sorted in ascending
+// order, without those retracted by the go.mod file of the latest one of them.
+// The latest go.mod file is read from the g.Cacher, or fetched and cached if it
+// is not cached yet and noFetch is false.
+func (g *Goproxy) filterRetractedVersions(ctx context.Context, f *fetch, versions []string, noFetch bool) ([]string, error) {
+	latestVersion := ""
+	for i := len(versions) - 1; i >= 0; i-- {
+		if latestVersion == "" {
+			latestVersion = versions[i]
+		}
+		if semver.Prerelease(versions[i]) == "" {
+			latestVersion = versions[i]
+			break
+		}
+	}
+	if latestVersion == "" {
+		return versions, nil
+	}
+
+	mod, err := g.latestGoMod(ctx, f, latestVersion, noFetch)
 	if err != nil {
-		g.logErrorf("failed to open fetch result: %s: %v", f.name, err)
-		responseInternalServerError(rw, req)
-		return
+		return nil, err
 	}
-	defer content.Close()
+	mf, err := modfile.ParseLax("go.mod", mod, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(mf.Retract) == 0 {
+		return versions, nil
+	}
+
+	filtered := make([]string, 0, len(versions))
+	for _, version := range versions {
+		retracted := false
+		for _, r := range mf.Retract {
+			if semver.Compare(version, r.Low) >= 0 && semver.Compare(version, r.High) <= 0 {
+				retracted = true
+				break
+			}
+		}
+		if !retracted {
+			filtered = append(filtered, version)
+		}
+	}
+	return filtered, nil
+}
+
+// latestGoMod returns the content of the go.mod file of the latestVersion of
+// the module of the f. It is read from the g.Cacher, or fetched and cached if
+// it is not cached yet and noFetch is false.
+func (g *Goproxy) latestGoMod(ctx context.Context, f *fetch, latestVersion string, noFetch bool) ([]byte, error) {
+	escapedModuleVersion, err := module.EscapeVersion(latestVersion)
+	if err != nil {
+		return nil, err
+	}
+	nameWithoutExt := strings.TrimSuffix(f.name, "/list") + "/" + escapedModuleVersion
+	if rc, err := g.cache(ctx, nameWithoutExt+".mod"); err == nil {
+		defer rc.Close()
+		return io.ReadAll(rc)
+	} else if !errors.Is(err, fs.ErrNotExist) || noFetch {
+		return nil, err
+	}
+
+	mf, err := newFetch(g, nameWithoutExt+".mod", f.tempDir)
+	if err != nil {
+		return nil, err
+	}
+	fr, release, err := g.doFetch(ctx, mf)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	if err := g.putFetchDownloadCaches(ctx, nameWithoutExt, fr, nil); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(fr.GoMod)
+}
+
+// joinModulePatterns joins the non-empty patterns into a lowercase
+// comma-separated list for [globsMatchPath].
+func joinModulePatterns(patterns []string) string {
+	var nonEmptyPatterns []string
+	for _, pattern := range patterns {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			nonEmptyPatterns = append(nonEmptyPatterns, strings.ToLower(pattern))
+		}
+	}
+	return strings.Join(nonEmptyPatterns, ",")
+}
 
-	responseSuccess(rw, req, content, f.contentType, 604800)
+// joinPathPatterns joins the non-empty patterns into a comma-separated list for
+// [globsMatchPath]. Unlike [joinModulePatterns], the case of the patterns is
+// kept, as with GOPRIVATE entries.
+func joinPathPatterns(patterns []string) string {
+	var nonEmptyPatterns []string
+	for _, pattern := range patterns {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			nonEmptyPatterns = append(nonEmptyPatterns, pattern)
+		}
+	}
+	return strings.Join(nonEmptyPatterns, ",")
+}
+
+// doFetch executes the f, unless its "not found" result has been cached by
+// [Goproxy.putNotFoundCache] and has not expired, in which case that result is
+// returned instead. Concurrent identical fetches are deduplicated by the
+// g.fetchGroup.
+//
+// The release must be called once the caller is done with the result.
+func (g *Goproxy) doFetch(ctx context.Context, f *fetch) (r *fetchResult, release func(), err error) {
+	if timeout := g.fetchTimeout(f); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	ttl := g.notFoundTTL(f)
+	if ttl > 0 {
+		if err := g.notFoundCache(ctx, f.name, ttl); err != nil {
+			addRequestLogAttrs(ctx, slog.String("cache", "not_found_hit"))
+			return nil, nil, err
+		}
+	}
+	return g.fetchGroup.do(ctx, f, g.TempDir, func(ctx context.Context, f *fetch) (*fetchResult, error) {
+		if g.OnFetchStart != nil {
+			g.OnFetchStart(ctx, f.modulePath, f.moduleVersion, f.ops.String())
+		}
+		r, err := f.do(ctx)
+		if g.OnFetchComplete != nil {
+			var size int64
+			if err == nil {
+				size = r.size()
+			}
+			g.OnFetchComplete(ctx, f.modulePath, f.moduleVersion, f.ops.String(), err, size)
+		}
+		if err != nil && ttl > 0 && isCacheableNotFoundError(err) {
+			if err := g.putNotFoundCache(ctx, f.name, err); err != nil {
+				g.logErrorf("failed to cache not found result: %s: %v", f.name, err)
+			}
+		}
+		return r, err
+	})
+}
+
+// fetchTimeout returns the maximum amount of time the f may take, or zero if
+// there is no limit.
+func (g *Goproxy) fetchTimeout(f *fetch) time.Duration {
+	var timeout time.Duration
+	switch f.ops {
+	case fetchOpsResolve, fetchOpsList:
+		timeout = g.ListFetchTimeout
+	case fetchOpsDownloadInfo:
+		timeout = g.InfoFetchTimeout
+	case fetchOpsDownloadMod:
+		timeout = g.ModFetchTimeout
+	case fetchOpsDownloadZip:
+		timeout = g.ZipFetchTimeout
+	}
+	if timeout > 0 {
+		return timeout
+	}
+	return g.FetchTimeout
+}
+
+// notFoundTTL returns how long the "not found" result of the f is cached.
+func (g *Goproxy) notFoundTTL(f *fetch) time.Duration {
+	switch f.ops {
+	case fetchOpsResolve, fetchOpsList:
+		return g.NotFoundQueryTTL
+	}
+	return g.NotFoundTTL
+}
+
+// notFoundCacheNameSuffix is the suffix of the names under which "not found"
+// results are cached.
+const notFoundCacheNameSuffix = ".notfound"
+
+// notFoundCache returns the cached "not found" result for the name if it was
+// cached within the ttl. Otherwise, it returns nil, deleting the expired result
+// if any.
+func (g *Goproxy) notFoundCache(ctx context.Context, name string, ttl time.Duration) error {
+	content, err := g.cache(ctx, name+notFoundCacheNameSuffix)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			g.logErrorf("failed to get cached not found result: %s: %v", name, err)
+		}
+		return nil
+	}
+	var nf struct {
+		Error string
+		Time  time.Time
+	}
+	err = json.NewDecoder(content).Decode(&nf)
+	content.Close()
+	if err != nil {
+		g.logErrorf("failed to decode cached not found result: %s: %v", name, err)
+		return nil
+	}
+	if g.now().Sub(nf.Time) >= ttl {
+		if dc, ok := g.Cacher.(deleterCacher); ok {
+			if err := dc.Delete(ctx, name+notFoundCacheNameSuffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				g.logErrorf("failed to delete expired not found result: %s: %v", name, err)
+			}
+		}
+		return nil
+	}
+	return notFoundError(nf.Error)
+}
+
+// putNotFoundCache puts a cache to the g.Cacher for the "not found" result
+// notFoundErr for the name.
+func (g *Goproxy) putNotFoundCache(ctx context.Context, name string, notFoundErr error) error {
+	b, err := json.Marshal(struct {
+		Error string
+		Time  time.Time
+	}{notFoundErr.Error(), g.now().UTC()})
+	if err != nil {
+		return err
+	}
+	return g.putCache(ctx, name+notFoundCacheNameSuffix, bytes.NewReader(b))
+}
+
+// isCacheableNotFoundError reports whether the err is a "not found" error that
+// is not caused by a failing upstream, as classified by [classifyError].
+func isCacheableNotFoundError(err error) bool {
+	var nee *NotExistError
+	return errors.As(classifyError(err), &nee)
 }
 
 // serveSUMDB serves checksum database proxy requests.
 func (g *Goproxy) serveSUMDB(rw http.ResponseWriter, req *http.Request, name, tempDir string) {
+	if g.FetchTimeout > 0 {
+		ctx, cancel := context.WithTimeout(req.Context(), g.FetchTimeout)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
 	sumdbURL, err := parseRawURL(strings.TrimPrefix(name, "sumdb/"))
 	if err != nil {
 		responseNotFound(rw, req, 86400)
@@ -397,6 +1880,7 @@ func (g *Goproxy) serveSUMDB(rw http.ResponseWriter, req *http.Request, name, te
 	var (
 		contentType        string
 		cacheControlMaxAge int
+		isTile             bool
 	)
 	if sumdbURL.Path == "/supported" {
 		setResponseCacheControlHeader(rw, 86400)
@@ -406,38 +1890,79 @@ func (g *Goproxy) serveSUMDB(rw http.ResponseWriter, req *http.Request, name, te
 		contentType = "text/plain; charset=utf-8"
 		cacheControlMaxAge = 3600
 	} else if strings.HasPrefix(sumdbURL.Path, "/lookup/") {
+		escapedModulePath, _, _ := strings.Cut(strings.TrimPrefix(sumdbURL.Path, "/lookup/"), "@")
+		if modulePath, err := module.UnescapePath(escapedModulePath); err == nil && g.moduleRoute(modulePath).private {
+			responseNotFound(rw, req, 60, "checksum database lookup disabled by this proxy")
+			return
+		}
 		contentType = "text/plain; charset=utf-8"
-		cacheControlMaxAge = 86400
+		cacheControlMaxAge = 60
 	} else if strings.HasPrefix(sumdbURL.Path, "/tile/") {
+		if _, err := tlog.ParseTilePath(sumdbURL.Path[1:]); err != nil {
+			responseNotFound(rw, req, 86400)
+			return
+		}
 		contentType = "application/octet-stream"
-		cacheControlMaxAge = 86400
+		cacheControlMaxAge = 31536000
+		isTile = true
 	} else {
 		responseNotFound(rw, req, 86400)
 		return
 	}
 
+	if g.Offline {
+		g.serveCache(rw, req, name, contentType, cacheControlMaxAge, func() {
+			responseNotFound(rw, req, 60, "not cached by this proxy in offline mode")
+		})
+		return
+	}
+
+	upstreamURL := proxiedSUMDBURL.JoinPath(sumdbURL.Path).String()
+	httpClient, ok := g.sumdbHTTPClients[sumdbURL.Host]
+	if !ok {
+		httpClient = g.httpClient
+	}
+	if isTile {
+		// Tiles, including partial ones, never change once published, so
+		// they are served from the cache whenever possible.
+		g.serveCache(rw, req, name, contentType, cacheControlMaxAge, func() {
+			g.serveSUMDBUpstream(rw, req, name, tempDir, httpClient, upstreamURL, contentType, cacheControlMaxAge, false)
+		})
+		return
+	}
+	g.serveSUMDBUpstream(rw, req, name, tempDir, httpClient, upstreamURL, contentType, cacheControlMaxAge, true)
+}
+
+// serveSUMDBUpstream serves checksum database proxy requests by getting the
+// content from the upstreamURL with the httpClient and caching it. If
+// cacheFallback is true, the cached content is served when the upstreamURL
+// fails.
+func (g *Goproxy) serveSUMDBUpstream(rw http.ResponseWriter, req *http.Request, name, tempDir string, httpClient *http.Client, upstreamURL, contentType string, cacheControlMaxAge int, cacheFallback bool) {
 	tempFile, err := os.CreateTemp(tempDir, "")
 	if err != nil {
-		g.logErrorf("failed to create temporary file: %v", err)
-		responseInternalServerError(rw, req)
+		g.responseStorageError(rw, req, err, "failed to create temporary file")
 		return
 	}
-	if err := httpGet(req.Context(), g.httpClient, appendURL(proxiedSUMDBURL, sumdbURL.Path).String(), tempFile); err != nil {
-		g.serveCache(rw, req, name, contentType, cacheControlMaxAge, func() {
+	if err := httpGet(req.Context(), httpClient, g.fetchRetryPolicy, upstreamURL, tempFile); err != nil {
+		tempFile.Close()
+		onError := func() {
 			g.logErrorf("failed to proxy checksum database: %s: %v", name, err)
-			responseError(rw, req, err, true)
-		})
+			responseError(rw, req, err, true, g.UpstreamErrorStatus)
+		}
+		if cacheFallback {
+			g.serveCache(rw, req, name, contentType, cacheControlMaxAge, onError)
+		} else {
+			onError()
+		}
 		return
 	}
 	if err := tempFile.Close(); err != nil {
-		g.logErrorf("failed to close temporary file: %v", err)
-		responseInternalServerError(rw, req)
+		g.responseStorageError(rw, req, err, "failed to close temporary file")
 		return
 	}
 
 	if err := g.putCacheFile(req.Context(), name, tempFile.Name()); err != nil {
-		g.logErrorf("failed to cache module file: %s: %v", name, err)
-		responseInternalServerError(rw, req)
+		g.responseStorageError(rw, req, err, "failed to cache module file: %s", name)
 		return
 	}
 
@@ -452,35 +1977,193 @@ func (g *Goproxy) serveSUMDB(rw http.ResponseWriter, req *http.Request, name, te
 	responseSuccess(rw, req, content, contentType, cacheControlMaxAge)
 }
 
-// serveCache serves requests with cached module files.
-func (g *Goproxy) serveCache(rw http.ResponseWriter, req *http.Request, name, contentType string, cacheControlMaxAge int, onNotFound func()) {
+// serveCache serves requests with cached module files. It reports whether the
+// request was served from the cache.
+func (g *Goproxy) serveCache(rw http.ResponseWriter, req *http.Request, name, contentType string, cacheControlMaxAge int, onNotFound func()) bool {
 	content, err := g.cache(req.Context(), name)
 	if err != nil {
 		if errors.Is(err, fs.ErrNotExist) {
+			g.metrics.incCacheMisses(metricsEndpoint(name))
+			addRequestLogAttrs(req.Context(), slog.String("cache", "miss"))
 			onNotFound()
-			return
+			return false
 		}
 		g.logErrorf("failed to get cached module file: %s: %v", name, err)
 		responseInternalServerError(rw, req)
-		return
+		return false
 	}
 	defer content.Close()
-	responseSuccess(rw, req, content, contentType, cacheControlMaxAge)
+	g.metrics.incCacheHits(metricsEndpoint(name))
+	addRequestLogAttrs(req.Context(), slog.String("cache", "hit"))
+	g.setETagHeader(req.Context(), rw, name, content)
+	if isDownloadInfoName(name) {
+		responseSuccess(rw, req, withInfoTime(content), contentType, cacheControlMaxAge)
+	} else {
+		responseSuccess(rw, req, content, contentType, cacheControlMaxAge)
+	}
+	return true
+}
+
+// isDownloadInfoName reports whether the name is that of the info file of a
+// module version, which never changes, as opposed to that of a version query,
+// whose result moves as new versions appear.
+func isDownloadInfoName(name string) bool {
+	_, base, ok := strings.Cut(name, "/@v/")
+	if !ok {
+		return false
+	}
+	escapedModuleVersion, ok := strings.CutSuffix(base, ".info")
+	if !ok {
+		return false
+	}
+	moduleVersion, err := module.UnescapeVersion(escapedModuleVersion)
+	return err == nil && semver.IsValid(moduleVersion)
+}
+
+// serveStaleCache serves the cached copy of the f as a stale response after
+// fetching the f failed with the fetchErr. It responds with the fetchErr
+// instead if the f is not cached or its cached copy is older than the
+// g.MaxStaleAge. It reports whether the cached copy was served.
+func (g *Goproxy) serveStaleCache(rw http.ResponseWriter, req *http.Request, f *fetch, fetchErr error) bool {
+	onUnavailable := func() {
+		g.logErrorf("failed to %s module version: %s: %v", f.ops, f.name, fetchErr)
+		responseError(rw, req, fetchErr, true, g.UpstreamErrorStatus)
+	}
+	content, err := g.cache(req.Context(), f.name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			g.metrics.incCacheMisses(metricsEndpoint(f.name))
+			addRequestLogAttrs(req.Context(), slog.String("cache", "miss"))
+			onUnavailable()
+			return false
+		}
+		g.logErrorf("failed to get cached module file: %s: %v", f.name, err)
+		responseInternalServerError(rw, req)
+		return false
+	}
+	defer content.Close()
+	if g.MaxStaleAge > 0 {
+		if cachedAt := cachedAt(content); cachedAt.IsZero() || g.now().Sub(cachedAt) > g.MaxStaleAge {
+			onUnavailable()
+			return false
+		}
+	}
+	g.metrics.incCacheHits(metricsEndpoint(f.name))
+	addRequestLogAttrs(req.Context(), slog.String("cache", "stale"))
+	g.logErrorf("serving stale cache after failing to %s module version: %s: %v", f.ops, f.name, fetchErr)
+	rw.Header().Set("Warning", `110 - "Response is Stale"`)
+	responseSuccess(rw, req, content, f.contentType, 60)
+	return true
+}
+
+// serveFreshCache serves the cached copy of the f if it was cached no longer
+// than the ttl ago. It reports whether the request was served. Otherwise,
+// nothing is responded, so the caller can go on to fetch.
+func (g *Goproxy) serveFreshCache(rw http.ResponseWriter, req *http.Request, f *fetch, ttl time.Duration) bool {
+	content, err := g.cache(req.Context(), f.name)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			g.logErrorf("failed to get cached module file: %s: %v", f.name, err)
+		}
+		return false
+	}
+	defer content.Close()
+	if cachedAt := cachedAt(content); cachedAt.IsZero() || g.now().Sub(cachedAt) > ttl {
+		return false
+	}
+	g.metrics.incCacheHits(metricsEndpoint(f.name))
+	addRequestLogAttrs(req.Context(), slog.String("cache", "hit"))
+	responseSuccess(rw, req, content, f.contentType, 60)
+	return true
+}
+
+// now returns the current time according to the g.Now.
+func (g *Goproxy) now() time.Time {
+	if g.Now != nil {
+		return g.Now()
+	}
+	return time.Now()
+}
+
+// cachedAt returns when the content was cached, or the zero [time.Time] if
+// unknown.
+func cachedAt(content io.Reader) time.Time {
+	if lm, ok := content.(interface{ LastModified() time.Time }); ok {
+		return lm.LastModified()
+	} else if mt, ok := content.(interface{ ModTime() time.Time }); ok {
+		return mt.ModTime()
+	}
+	return time.Time{}
+}
+
+// zipHashCacheNameExt is the extension of the cache names of module zip file
+// hashes, which are cached alongside the module zip files.
+const zipHashCacheNameExt = ".ziphash"
+
+// setETagHeader sets a strong ETag header for the module file targeted by the
+// name with the content, so that conditional requests can be answered with a
+// 304 status code. It does nothing unless the name targets a .mod or .zip file,
+// both of which are immutable.
+//
+// The ETag of a .zip file is its hash cached under the name with the
+// [zipHashCacheNameExt], so the zip file itself is never read. The ETag of a
+// .mod file is the hash of the content, which must be an [io.ReadSeeker] and is
+// rewound afterward.
+func (g *Goproxy) setETagHeader(ctx context.Context, rw http.ResponseWriter, name string, content io.Reader) {
+	if strings.HasPrefix(name, "sumdb/") || !strings.Contains(name, "/@v/") {
+		return
+	}
+	var hash string
+	switch path.Ext(name) {
+	case ".zip":
+		rc, err := g.cache(ctx, strings.TrimSuffix(name, ".zip")+zipHashCacheNameExt)
+		if err != nil {
+			return
+		}
+		defer rc.Close()
+		b, err := io.ReadAll(io.LimitReader(rc, 256))
+		if err != nil {
+			return
+		}
+		hash = strings.TrimSpace(string(b))
+	case ".mod":
+		rs, ok := content.(io.ReadSeeker)
+		if !ok {
+			return
+		}
+		var err error
+		hash, err = dirhash.DefaultHash([]string{"go.mod"}, func(string) (io.ReadCloser, error) {
+			return io.NopCloser(rs), nil
+		})
+		if _, seekErr := rs.Seek(0, io.SeekStart); err != nil || seekErr != nil {
+			return
+		}
+	}
+	if hash != "" {
+		rw.Header().Set("ETag", strconv.Quote(hash))
+	}
 }
 
 // cache returns the matched cache for the name from the g.Cacher.
-func (g *Goproxy) cache(ctx context.Context, name string) (io.ReadCloser, error) {
+func (g *Goproxy) cache(ctx context.Context, name string) (rc io.ReadCloser, err error) {
 	if g.Cacher == nil {
 		return nil, fs.ErrNotExist
 	}
+	ctx, span := g.startSpan(ctx, "goproxy.cache.get", attrKeyCacheName.String(name))
+	defer func() {
+		span.SetAttributes(attrKeyCacheHit.Bool(err == nil))
+		endSpan(span, err)
+	}()
 	return g.Cacher.Get(ctx, name)
 }
 
 // putCache puts a cache to the g.Cacher for the name with the content.
-func (g *Goproxy) putCache(ctx context.Context, name string, content io.ReadSeeker) error {
+func (g *Goproxy) putCache(ctx context.Context, name string, content io.ReadSeeker) (err error) {
 	if g.Cacher == nil {
 		return nil
 	}
+	ctx, span := g.startSpan(ctx, "goproxy.cache.put", attrKeyCacheName.String(name))
+	defer func() { endSpan(span, err) }()
 	return g.Cacher.Put(ctx, name, content)
 }
 
@@ -494,8 +2177,28 @@ func (g *Goproxy) putCacheFile(ctx context.Context, name, file string) error {
 	return g.putCache(ctx, name, f)
 }
 
-// logErrorf formats according to a format specifier and writes to the g.ErrorLogger.
+// responseStorageError logs the err of storing files with the message formatted
+// according to the format and v, and responses it to the client. A full disk is
+// logged as such and responded with a 507 status code, so that it is not
+// mistaken for any other internal server error.
+func (g *Goproxy) responseStorageError(rw http.ResponseWriter, req *http.Request, err error, format string, v ...any) {
+	msg := fmt.Sprintf(format, v...)
+	if isDiskFullError(err) {
+		g.logErrorf("%s: disk full, free up space in the TempDir or Cacher: %v", msg, err)
+		responseInsufficientStorage(rw, req)
+		return
+	}
+	g.logErrorf("%s: %v", msg, err)
+	responseInternalServerError(rw, req)
+}
+
+// logErrorf formats according to a format specifier and writes to the g.Logger
+// or the g.ErrorLogger.
 func (g *Goproxy) logErrorf(format string, v ...any) {
+	if g.Logger != nil {
+		g.Logger.Error(fmt.Sprintf(format, v...))
+		return
+	}
 	msg := "goproxy: " + fmt.Sprintf(format, v...)
 	if g.ErrorLogger != nil {
 		g.ErrorLogger.Output(2, msg)
@@ -3,19 +3,31 @@ package goproxy
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"log"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"testing"
 	"time"
+
+	"golang.org/x/mod/sumdb/dirhash"
+	"golang.org/x/mod/sumdb/note"
 )
 
 func getenv(env []string, key string) string {
@@ -86,7 +98,7 @@ func TestGoproxyInit(t *testing.T) {
 		},
 		{
 			n:              7,
-			env:            append(os.Environ(), "GOSUMDB=example.com"),
+			env:            append(os.Environ(), "GOSUMDB=example.com", "GOTOOLCHAIN=go1.22.0"),
 			wantEnvGOPROXY: "https://proxy.golang.org,direct",
 			wantEnvGOSUMDB: "example.com",
 		},
@@ -135,6 +147,9 @@ func TestGoproxyInit(t *testing.T) {
 		if got, want := getenv(g.env, "GOPRIVATE"), ""; got != want {
 			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
 		}
+		if got, want := getenv(g.env, "GOTOOLCHAIN"), "local"; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
 	}
 
 	g := &Goproxy{MaxDirectFetches: 1}
@@ -142,6 +157,15 @@ func TestGoproxyInit(t *testing.T) {
 	if g.directFetchWorkerPool == nil {
 		t.Fatal("unexpected nil")
 	}
+	if g.directDownloadSlots != nil {
+		t.Fatal("expected nil")
+	}
+
+	g = &Goproxy{MaxDirectDownloads: 1}
+	g.init()
+	if got, want := cap(g.directDownloadSlots), 1; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
 
 	g = &Goproxy{ProxiedSUMDBs: []string{
 		"sum.golang.google.cn",
@@ -189,7 +213,7 @@ func TestGoproxyServeHTTP(t *testing.T) {
 			tempDir:          t.TempDir(),
 			wantStatusCode:   http.StatusOK,
 			wantContentType:  "application/json; charset=utf-8",
-			wantCacheControl: "public, max-age=60",
+			wantCacheControl: "public, max-age=60, must-revalidate",
 			wantContent:      info,
 		},
 		{
@@ -202,7 +226,7 @@ func TestGoproxyServeHTTP(t *testing.T) {
 			tempDir:          t.TempDir(),
 			wantStatusCode:   http.StatusOK,
 			wantContentType:  "application/json; charset=utf-8",
-			wantCacheControl: "public, max-age=60",
+			wantCacheControl: "public, max-age=60, must-revalidate",
 		},
 		{
 			n:                3,
@@ -274,11 +298,71 @@ func TestGoproxyServeHTTP(t *testing.T) {
 			wantContentType: "text/plain; charset=utf-8",
 			wantContent:     "internal server error",
 		},
+		{
+			n: 10,
+			proxyHandler: func(rw http.ResponseWriter, req *http.Request) {
+				responseSuccess(rw, req, strings.NewReader(info), "application/json; charset=utf-8", -2)
+			},
+			path:             "/prefix/example.com/@latest",
+			pathPrefix:       "/prefix",
+			tempDir:          t.TempDir(),
+			wantStatusCode:   http.StatusOK,
+			wantContentType:  "application/json; charset=utf-8",
+			wantCacheControl: "public, max-age=60, must-revalidate",
+			wantContent:      info,
+		},
+		{
+			n: 11,
+			proxyHandler: func(rw http.ResponseWriter, req *http.Request) {
+				responseSuccess(rw, req, strings.NewReader(info), "application/json; charset=utf-8", -2)
+			},
+			path:             "/prefix/example.com/@latest",
+			pathPrefix:       "/prefix/",
+			tempDir:          t.TempDir(),
+			wantStatusCode:   http.StatusOK,
+			wantContentType:  "application/json; charset=utf-8",
+			wantCacheControl: "public, max-age=60, must-revalidate",
+			wantContent:      info,
+		},
+		{
+			n:                12,
+			proxyHandler:     func(rw http.ResponseWriter, req *http.Request) {},
+			path:             "/example.com/@latest",
+			pathPrefix:       "/prefix",
+			tempDir:          t.TempDir(),
+			wantStatusCode:   http.StatusNotFound,
+			wantContentType:  "text/plain; charset=utf-8",
+			wantCacheControl: "public, max-age=86400",
+			wantContent:      "not found",
+		},
+		{
+			n:                13,
+			proxyHandler:     func(rw http.ResponseWriter, req *http.Request) {},
+			path:             "/prefixexample.com/@latest",
+			pathPrefix:       "/prefix",
+			tempDir:          t.TempDir(),
+			wantStatusCode:   http.StatusNotFound,
+			wantContentType:  "text/plain; charset=utf-8",
+			wantCacheControl: "public, max-age=86400",
+			wantContent:      "not found",
+		},
+		{
+			n:                14,
+			proxyHandler:     func(rw http.ResponseWriter, req *http.Request) {},
+			path:             "/prefix/",
+			pathPrefix:       "/prefix",
+			tempDir:          t.TempDir(),
+			wantStatusCode:   http.StatusNotFound,
+			wantContentType:  "text/plain; charset=utf-8",
+			wantCacheControl: "public, max-age=86400",
+			wantContent:      "not found",
+		},
 	} {
 		setProxyHandler(tt.proxyHandler)
 		g := &Goproxy{
 			Env:         []string{"GOPROXY=" + proxyServer.URL, "GOSUMDB=off"},
 			Cacher:      DirCacher(t.TempDir()),
+			PathPrefix:  tt.pathPrefix,
 			TempDir:     tt.tempDir,
 			ErrorLogger: log.New(io.Discard, "", 0),
 		}
@@ -303,6 +387,402 @@ func TestGoproxyServeHTTP(t *testing.T) {
 	}
 }
 
+func TestGoproxyServeHTTPPathPrefixRedirect(t *testing.T) {
+	for _, tt := range []struct {
+		n              int
+		pathPrefix     string
+		path           string
+		wantStatusCode int
+		wantLocation   string
+	}{
+		{1, "/prefix", "/prefix", http.StatusMovedPermanently, "/prefix/"},
+		{2, "/prefix/", "/prefix", http.StatusMovedPermanently, "/prefix/"},
+		{3, "/a/b", "/a/b", http.StatusMovedPermanently, "/a/b/"},
+		{4, "/prefix", "/prefix/", http.StatusNotFound, ""},
+		{5, "/prefix", "/prefixes", http.StatusNotFound, ""},
+		{6, "", "/", http.StatusNotFound, ""},
+	} {
+		g := &Goproxy{
+			Env:         []string{"GOPROXY=off"},
+			PathPrefix:  tt.pathPrefix,
+			TempDir:     t.TempDir(),
+			ErrorLogger: log.New(io.Discard, "", 0),
+		}
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		recr := rec.Result()
+		if got, want := recr.StatusCode, tt.wantStatusCode; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if got, want := recr.Header.Get("Location"), tt.wantLocation; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+}
+
+func TestGoproxyServeHTTPMethods(t *testing.T) {
+	proxyServer, setProxyHandler := newHTTPTestServer()
+	defer proxyServer.Close()
+	var upstreamRequests int
+	setProxyHandler(func(rw http.ResponseWriter, req *http.Request) {
+		upstreamRequests++
+		responseString(rw, req, http.StatusOK, -2, "v1.0.0")
+	})
+	g := &Goproxy{
+		Env:         []string{"GOPROXY=" + proxyServer.URL, "GOSUMDB=off"},
+		TempDir:     t.TempDir(),
+		ErrorLogger: log.New(io.Discard, "", 0),
+	}
+	for _, tt := range []struct {
+		n              int
+		method         string
+		path           string
+		wantStatusCode int
+		wantAllow      string
+	}{
+		{1, http.MethodPost, "/example.com/@v/list", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{2, http.MethodPut, "/example.com/@v/v1.0.0.zip", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{3, http.MethodDelete, "/example.com/@latest", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{4, http.MethodPatch, "/sumdb/sumdb.example.com/supported", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{5, http.MethodGet, "/example.com/@v/list", http.StatusOK, ""},
+	} {
+		body := &countingReader{r: strings.NewReader(strings.Repeat("x", 1<<20))}
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, body))
+		recr := rec.Result()
+		if got, want := recr.StatusCode, tt.wantStatusCode; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if got, want := recr.Header.Get("Allow"), tt.wantAllow; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		if got, want := body.n, int64(0); got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+	}
+	if got, want := upstreamRequests, 1; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	g.SetMaintenance(true)
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/example.com/@v/list", nil))
+	if got, want := rec.Result().StatusCode, http.StatusMethodNotAllowed; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
+
+// countingReader is an [io.Reader] that counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+func TestGoproxyServeHTTPRateLimit(t *testing.T) {
+	g := &Goproxy{
+		RateLimit:      1,
+		RateBurst:      2,
+		TrustedProxies: []string{"10.0.0.0/8"},
+		Cacher:         DirCacher(t.TempDir()),
+		ErrorLogger:    log.New(io.Discard, "", 0),
+	}
+	for _, tt := range []struct {
+		n              int
+		remoteAddr     string
+		forwardedFor   string
+		wantStatusCode int
+	}{
+		{1, "203.0.113.1:1234", "", http.StatusNotFound},
+		{2, "203.0.113.1:1234", "198.51.100.1", http.StatusNotFound},
+		{3, "203.0.113.1:1234", "198.51.100.2", http.StatusTooManyRequests},
+		{4, "10.0.0.1:1234", "198.51.100.1", http.StatusNotFound},
+		{5, "10.0.0.2:1234", "198.51.100.1", http.StatusNotFound},
+		{6, "10.0.0.3:1234", "198.51.100.1", http.StatusTooManyRequests},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tt.remoteAddr
+		if tt.forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", tt.forwardedFor)
+		}
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, req)
+		recr := rec.Result()
+		if got, want := recr.StatusCode, tt.wantStatusCode; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if tt.wantStatusCode == http.StatusTooManyRequests {
+			if got, want := recr.Header.Get("Retry-After"), "1"; got != want {
+				t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+			}
+		}
+	}
+}
+
+func TestGoproxyServeHTTPMaxConcurrentRequests(t *testing.T) {
+	g := &Goproxy{
+		MaxConcurrentRequests: 1,
+		Cacher:                DirCacher(t.TempDir()),
+		ErrorLogger:           log.New(io.Discard, "", 0),
+	}
+	g.initOnce.Do(g.init)
+
+	serve := func() *http.Response {
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec.Result()
+	}
+
+	if got, want := serve().StatusCode, http.StatusNotFound; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	release, ok := g.acquireRequestSlot(context.Background())
+	if !ok {
+		t.Fatal("expected ok")
+	}
+	recr := serve()
+	if got, want := recr.StatusCode, http.StatusServiceUnavailable; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+	if got, want := recr.Header.Get("Retry-After"), "1"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	release()
+	if got, want := serve().StatusCode, http.StatusNotFound; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
+
+func TestGoproxyAcquireRequestSlot(t *testing.T) {
+	g := &Goproxy{MaxConcurrentRequests: 1}
+	g.initOnce.Do(g.init)
+
+	release, ok := g.acquireRequestSlot(context.Background())
+	if !ok {
+		t.Fatal("expected ok")
+	}
+	if _, ok := g.acquireRequestSlot(context.Background()); ok {
+		t.Error("expected not ok")
+	}
+	release()
+	release()
+	release, ok = g.acquireRequestSlot(context.Background())
+	if !ok {
+		t.Fatal("expected ok")
+	}
+
+	g.MaxRequestQueueWait = 10 * time.Millisecond
+	if _, ok := g.acquireRequestSlot(context.Background()); ok {
+		t.Error("expected not ok")
+	}
+	g.MaxRequestQueueWait = time.Minute
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		release()
+	}()
+	release, ok = g.acquireRequestSlot(context.Background())
+	if !ok {
+		t.Fatal("expected ok")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, ok := g.acquireRequestSlot(ctx); ok {
+		t.Error("expected not ok")
+	}
+	release()
+
+	ctx, cancel = context.WithCancel(context.Background())
+	release, ok = g.acquireRequestSlot(ctx)
+	if !ok {
+		t.Fatal("expected ok")
+	}
+	cancel()
+	if _, ok := g.acquireRequestSlot(context.Background()); !ok {
+		t.Error("expected ok")
+	}
+	release()
+	if got, want := len(g.requestSlots), 1; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
+
+func TestGoproxyMetricsHandler(t *testing.T) {
+	proxyServer, setProxyHandler := newHTTPTestServer()
+	defer proxyServer.Close()
+	setProxyHandler(func(rw http.ResponseWriter, req *http.Request) { responseNotFound(rw, req, 60) })
+
+	g := &Goproxy{
+		Env:         []string{"GOPROXY=" + proxyServer.URL, "GOSUMDB=off"},
+		Cacher:      DirCacher(t.TempDir()),
+		TempDir:     t.TempDir(),
+		ErrorLogger: log.New(io.Discard, "", 0),
+	}
+	if err := g.Cacher.Put(context.Background(), "example.com/@v/v1.0.0.mod", strings.NewReader("module example.com")); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	for _, path := range []string{
+		"/example.com/@v/v1.0.0.mod",
+		"/example.com/@v/v1.1.0.mod",
+	} {
+		g.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("", path, nil))
+	}
+
+	rec := httptest.NewRecorder()
+	g.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("", "/metrics", nil))
+	recr := rec.Result()
+	if got, want := recr.StatusCode, http.StatusOK; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+	b, err := io.ReadAll(recr.Body)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	for _, want := range []string{
+		`goproxy_cache_hits_total{endpoint="mod"} 1`,
+		`goproxy_cache_misses_total{endpoint="mod"} 1`,
+		`goproxy_fetch_duration_seconds_count{endpoint="mod"} 1`,
+		`goproxy_fetch_errors_total{module_path_prefix="example.com"} 1`,
+		"goproxy_direct_fetches_in_flight 0",
+	} {
+		if got := string(b); !strings.Contains(got, want) {
+			t.Errorf("got %q, want it to contain %q", got, want)
+		}
+	}
+}
+
+func TestGoproxyServeHTTPLogger(t *testing.T) {
+	proxyServer, setProxyHandler := newHTTPTestServer()
+	defer proxyServer.Close()
+	setProxyHandler(func(rw http.ResponseWriter, req *http.Request) { responseNotFound(rw, req, 60) })
+
+	var buf bytes.Buffer
+	g := &Goproxy{
+		Env:     []string{"GOPROXY=" + proxyServer.URL, "GOSUMDB=off"},
+		Cacher:  DirCacher(t.TempDir()),
+		TempDir: t.TempDir(),
+		Logger:  slog.New(slog.NewTextHandler(&buf, nil)),
+	}
+	if err := g.Cacher.Put(context.Background(), "example.com/@v/v1.0.0.mod", strings.NewReader("module example.com")); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	for _, tt := range []struct {
+		n            int
+		path         string
+		tls          *tls.ConnectionState
+		wantContains []string
+	}{
+		{
+			n:    1,
+			path: "/example.com/@v/v1.0.0.mod",
+			wantContains: []string{
+				"level=INFO",
+				`msg="served request"`,
+				"method=GET",
+				"path=/example.com/@v/v1.0.0.mod",
+				"module_path=example.com",
+				"module_version=v1.0.0",
+				"cache=hit",
+				"status=200",
+				"duration=",
+			},
+		},
+		{
+			n:    2,
+			path: "/example.com/@v/v1.1.0.mod",
+			wantContains: []string{
+				"cache=miss",
+				"fetch_duration=",
+				"upstream_status=404",
+				"fetch_error=",
+				"status=404",
+			},
+		},
+		{
+			n:    3,
+			path: "/example.com/@v/v1.0.0.mod",
+			tls: &tls.ConnectionState{
+				VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "team-a"}}}},
+			},
+			wantContains: []string{
+				"client=team-a",
+				"status=200",
+			},
+		},
+	} {
+		buf.Reset()
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("", tt.path, nil)
+		req.TLS = tt.tls
+		g.ServeHTTP(rec, req)
+		requestID := rec.Result().Header.Get("X-Goproxy-Request-ID")
+		if got, want := len(requestID), 16; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		got := buf.String()
+		for _, want := range append(tt.wantContains, "request_id="+requestID) {
+			if !strings.Contains(got, want) {
+				t.Errorf("test(%d): got %q, want it to contain %q", tt.n, got, want)
+			}
+		}
+	}
+}
+
+func TestGoproxyRequestHeader(t *testing.T) {
+	var (
+		mutex      sync.Mutex
+		gotHeaders []http.Header
+	)
+	proxyServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		mutex.Lock()
+		gotHeaders = append(gotHeaders, req.Header.Clone())
+		mutex.Unlock()
+		responseSuccess(rw, req, strings.NewReader("v1.0.0"), "text/plain; charset=utf-8", -2)
+	}))
+	defer proxyServer.Close()
+
+	g := &Goproxy{
+		Env:           []string{"GOPROXY=" + proxyServer.URL, "GOSUMDB=off"},
+		ProxiedSUMDBs: []string{"sumdb.example.com " + proxyServer.URL},
+		UserAgent:     "foobar-goproxy/1.0",
+		RequestHeader: http.Header{"X-Foo": {"bar"}},
+		ErrorLogger:   log.New(io.Discard, "", 0),
+	}
+	for _, path := range []string{"/example.com/@v/list", "/sumdb/sumdb.example.com/latest"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("User-Agent", "Go-http-client/1.1")
+		req.Header.Set("Authorization", "Bearer foobar")
+		req.Header.Set("X-Qux", "quux")
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, req)
+		if got, want := rec.Result().StatusCode, http.StatusOK; got != want {
+			t.Errorf("%s: got %d, want %d", path, got, want)
+		}
+	}
+	if got, want := len(gotHeaders), 2; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+	for _, header := range gotHeaders {
+		if got, want := header.Get("User-Agent"), "foobar-goproxy/1.0"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if got, want := header.Get("X-Foo"), "bar"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		for _, key := range []string{"Authorization", "X-Qux"} {
+			if got := header.Get(key); got != "" {
+				t.Errorf("got %q, want empty %s", got, key)
+			}
+		}
+	}
+}
+
 func TestGoproxyServeFetch(t *testing.T) {
 	proxyServer, setProxyHandler := newHTTPTestServer()
 	defer proxyServer.Close()
@@ -314,6 +794,7 @@ func TestGoproxyServeFetch(t *testing.T) {
 		setupCacher        func(cacher Cacher) error
 		name               string
 		disableModuleFetch bool
+		offline            bool
 		wantStatusCode     int
 		wantContentType    string
 		wantCacheControl   string
@@ -328,7 +809,7 @@ func TestGoproxyServeFetch(t *testing.T) {
 			name:             "example.com/@latest",
 			wantStatusCode:   http.StatusOK,
 			wantContentType:  "application/json; charset=utf-8",
-			wantCacheControl: "public, max-age=60",
+			wantCacheControl: "public, max-age=60, must-revalidate",
 			wantContent:      info,
 		},
 		{
@@ -350,7 +831,7 @@ func TestGoproxyServeFetch(t *testing.T) {
 			name:             "example.com/@v/v1.0.0.info",
 			wantStatusCode:   http.StatusOK,
 			wantContentType:  "application/json; charset=utf-8",
-			wantCacheControl: "public, max-age=604800",
+			wantCacheControl: "public, max-age=604800, immutable",
 			wantContent:      info,
 		},
 		{
@@ -374,7 +855,7 @@ func TestGoproxyServeFetch(t *testing.T) {
 			disableModuleFetch: true,
 			wantStatusCode:     http.StatusOK,
 			wantContentType:    "application/json; charset=utf-8",
-			wantCacheControl:   "public, max-age=60",
+			wantCacheControl:   "public, max-age=60, must-revalidate",
 			wantContent:        info,
 		},
 		{
@@ -399,7 +880,7 @@ func TestGoproxyServeFetch(t *testing.T) {
 			disableModuleFetch: true,
 			wantStatusCode:     http.StatusOK,
 			wantContentType:    "application/json; charset=utf-8",
-			wantCacheControl:   "public, max-age=604800",
+			wantCacheControl:   "public, max-age=604800, immutable",
 			wantContent:        info,
 		},
 		{
@@ -423,6 +904,50 @@ func TestGoproxyServeFetch(t *testing.T) {
 			wantContentType: "text/plain; charset=utf-8",
 			wantContent:     "internal server error",
 		},
+		{
+			n: 10,
+			proxyHandler: func(rw http.ResponseWriter, req *http.Request) {
+				responseSuccess(rw, req, strings.NewReader(info), "application/json; charset=utf-8", -2)
+			},
+			cacher: DirCacher(t.TempDir()),
+			setupCacher: func(cacher Cacher) error {
+				return cacher.Put(context.Background(), "example.com/!blocked/@v/v1.0.0.info", strings.NewReader(info))
+			},
+			name:             "example.com/!blocked/@v/v1.0.0.info",
+			wantStatusCode:   http.StatusForbidden,
+			wantContentType:  "text/plain; charset=utf-8",
+			wantCacheControl: "must-revalidate, no-cache, no-store",
+			wantContent:      "forbidden: module example.com/Blocked is blocked by this proxy",
+		},
+		{
+			n: 11,
+			proxyHandler: func(rw http.ResponseWriter, req *http.Request) {
+				responseSuccess(rw, req, strings.NewReader("v1.1.0"), "text/plain; charset=utf-8", -2)
+			},
+			cacher: DirCacher(t.TempDir()),
+			setupCacher: func(cacher Cacher) error {
+				return cacher.Put(context.Background(), "example.com/@v/list", strings.NewReader("v1.0.0"))
+			},
+			name:             "example.com/@v/list",
+			offline:          true,
+			wantStatusCode:   http.StatusOK,
+			wantContentType:  "text/plain; charset=utf-8",
+			wantCacheControl: "public, max-age=60, must-revalidate",
+			wantContent:      "v1.0.0",
+		},
+		{
+			n: 12,
+			proxyHandler: func(rw http.ResponseWriter, req *http.Request) {
+				responseSuccess(rw, req, strings.NewReader(info), "application/json; charset=utf-8", -2)
+			},
+			cacher:           DirCacher(t.TempDir()),
+			name:             "example.com/@v/v1.0.0.info",
+			offline:          true,
+			wantStatusCode:   http.StatusNotFound,
+			wantContentType:  "text/plain; charset=utf-8",
+			wantCacheControl: "public, max-age=60",
+			wantContent:      "not found: not cached by this proxy in offline mode",
+		},
 	} {
 		setProxyHandler(tt.proxyHandler)
 		if tt.setupCacher != nil {
@@ -431,9 +956,11 @@ func TestGoproxyServeFetch(t *testing.T) {
 			}
 		}
 		g := &Goproxy{
-			Env:         []string{"GOPROXY=" + proxyServer.URL, "GOSUMDB=off"},
-			Cacher:      tt.cacher,
-			ErrorLogger: log.New(io.Discard, "", 0),
+			Env:                   []string{"GOPROXY=" + proxyServer.URL, "GOSUMDB=off"},
+			BlockedModulePatterns: []string{"example.com/blocked"},
+			Offline:               tt.offline,
+			Cacher:                tt.cacher,
+			ErrorLogger:           log.New(io.Discard, "", 0),
 		}
 		g.init()
 		req := httptest.NewRequest("", "/", nil)
@@ -539,15 +1066,1157 @@ func TestGoproxyServeFetchDownload(t *testing.T) {
 	}
 }
 
-func TestGoproxyServeSUMDB(t *testing.T) {
-	sumdbServer, setSUMDBHandler := newHTTPTestServer()
-	defer sumdbServer.Close()
-	for _, tt := range []struct {
-		n                int
-		sumdbHandler     http.HandlerFunc
+func TestGoproxyServeFetchDownloadETag(t *testing.T) {
+	proxyServer, setProxyHandler := newHTTPTestServer()
+	defer proxyServer.Close()
+	zipFile := filepath.Join(t.TempDir(), "zip")
+	if err := writeZipFile(zipFile, map[string][]byte{"example.com@v1.0.0/go.mod": []byte("module example.com")}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	zipHash, err := dirhash.HashZip(zipFile, dirhash.DefaultHash)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	modHash, err := dirhash.DefaultHash([]string{"go.mod"}, func(string) (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader("module example.com")), nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	setProxyHandler(func(rw http.ResponseWriter, req *http.Request) {
+		switch path.Ext(req.URL.Path) {
+		case ".zip":
+			http.ServeFile(rw, req, zipFile)
+		case ".mod":
+			responseSuccess(rw, req, strings.NewReader("module example.com"), "text/plain; charset=utf-8", -2)
+		default:
+			responseNotFound(rw, req, -2)
+		}
+	})
+	g := &Goproxy{
+		Env:         []string{"GOPROXY=" + proxyServer.URL, "GOSUMDB=off"},
+		Cacher:      DirCacher(t.TempDir()),
+		ErrorLogger: log.New(io.Discard, "", 0),
+	}
+	for _, tt := range []struct {
+		n              int
+		path           string
+		ifNoneMatch    string
+		wantStatusCode int
+		wantETag       string
+	}{
+		{1, "/example.com/@v/v1.0.0.zip", "", http.StatusOK, strconv.Quote(zipHash)},
+		{2, "/example.com/@v/v1.0.0.zip", "", http.StatusOK, strconv.Quote(zipHash)},
+		{3, "/example.com/@v/v1.0.0.zip", strconv.Quote(zipHash), http.StatusNotModified, strconv.Quote(zipHash)},
+		{4, "/example.com/@v/v1.0.0.zip", `"h1:foobar"`, http.StatusOK, strconv.Quote(zipHash)},
+		{5, "/example.com/@v/v1.0.0.mod", "", http.StatusOK, strconv.Quote(modHash)},
+		{6, "/example.com/@v/v1.0.0.mod", strconv.Quote(modHash), http.StatusNotModified, strconv.Quote(modHash)},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", tt.ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, req)
+		recr := rec.Result()
+		if got, want := recr.StatusCode, tt.wantStatusCode; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if got, want := recr.Header.Get("ETag"), tt.wantETag; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+}
+
+func TestGoproxyServeFetchDownloadZip(t *testing.T) {
+	proxyServer, setProxyHandler := newHTTPTestServer()
+	defer proxyServer.Close()
+	zipFile := filepath.Join(t.TempDir(), "zip")
+	if err := writeZipFile(zipFile, map[string][]byte{
+		"example.com@v1.0.0/go.mod":  []byte("module example.com"),
+		"example.com@v1.0.0/foo.txt": bytes.Repeat([]byte("foo"), 1<<20),
+	}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	zipContent, err := os.ReadFile(zipFile)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	zipHash, err := dirhash.HashZip(zipFile, dirhash.DefaultHash)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	setProxyHandler(func(rw http.ResponseWriter, req *http.Request) {
+		http.ServeFile(rw, req, zipFile)
+	})
+	for _, tt := range []struct {
+		n         int
+		cacher    Cacher
+		wantCache bool
+	}{
+		{1, &MemoryCacher{}, true},
+		{2, DirCacher(t.TempDir()), true},
+		{3, putFailingCacher{}, false},
+		{4, nil, false},
+	} {
+		g := &Goproxy{
+			Env:         []string{"GOPROXY=" + proxyServer.URL, "GOSUMDB=off"},
+			Cacher:      tt.cacher,
+			ErrorLogger: log.New(io.Discard, "", 0),
+		}
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/example.com/@v/v1.0.0.zip", nil))
+		recr := rec.Result()
+		if got, want := recr.StatusCode, http.StatusOK; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if got, want := recr.Header.Get("Content-Length"), strconv.Itoa(len(zipContent)); got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		if got, want := recr.Header.Get("ETag"), strconv.Quote(zipHash); got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		if b, err := io.ReadAll(recr.Body); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		} else if !bytes.Equal(b, zipContent) {
+			t.Errorf("test(%d): got %d bytes, want %d bytes of zip file", tt.n, len(b), len(zipContent))
+		}
+		if !tt.wantCache {
+			continue
+		}
+		for name, want := range map[string]string{
+			"example.com/@v/v1.0.0.zip":     string(zipContent),
+			"example.com/@v/v1.0.0.ziphash": zipHash,
+		} {
+			rc, err := tt.cacher.Get(context.Background(), name)
+			if err != nil {
+				t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+			}
+			b, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+			}
+			if got := string(b); got != want {
+				t.Errorf("test(%d): got %d bytes, want %d bytes of %s", tt.n, len(got), len(want), name)
+			}
+		}
+	}
+}
+
+// stalledResponseWriter is an [http.ResponseWriter] of a client that stops
+// reading the response, so every write blocks until its deadline.
+type stalledResponseWriter struct {
+	*httptest.ResponseRecorder
+	mutex    sync.Mutex
+	deadline time.Time
+}
+
+func (srw *stalledResponseWriter) SetWriteDeadline(deadline time.Time) error {
+	srw.mutex.Lock()
+	defer srw.mutex.Unlock()
+	srw.deadline = deadline
+	return nil
+}
+
+func (srw *stalledResponseWriter) Write(b []byte) (int, error) {
+	srw.mutex.Lock()
+	deadline := srw.deadline
+	srw.mutex.Unlock()
+	if deadline.IsZero() {
+		select {}
+	}
+	time.Sleep(time.Until(deadline))
+	return 0, os.ErrDeadlineExceeded
+}
+
+func TestGoproxyServeFetchDownloadZipStalledClient(t *testing.T) {
+	proxyServer, setProxyHandler := newHTTPTestServer()
+	defer proxyServer.Close()
+	zipFile := filepath.Join(t.TempDir(), "zip")
+	if err := writeZipFile(zipFile, map[string][]byte{
+		"example.com@v1.0.0/go.mod":  []byte("module example.com"),
+		"example.com@v1.0.0/foo.txt": bytes.Repeat([]byte("foo"), 1<<20),
+	}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	zipContent, err := os.ReadFile(zipFile)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	setProxyHandler(func(rw http.ResponseWriter, req *http.Request) {
+		http.ServeFile(rw, req, zipFile)
+	})
+
+	defer func(timeout time.Duration) { fetchDownloadZipWriteTimeout = timeout }(fetchDownloadZipWriteTimeout)
+	fetchDownloadZipWriteTimeout = 10 * time.Millisecond
+
+	var errorLog bytes.Buffer
+	cacher := &MemoryCacher{}
+	g := &Goproxy{
+		Env:         []string{"GOPROXY=" + proxyServer.URL, "GOSUMDB=off"},
+		Cacher:      cacher,
+		ErrorLogger: log.New(&errorLog, "", 0),
+	}
+	rw := &stalledResponseWriter{ResponseRecorder: httptest.NewRecorder()}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		g.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/example.com/@v/v1.0.0.zip", nil))
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected a stalled client not to block serving")
+	}
+
+	rc, err := cacher.Get(context.Background(), "example.com/@v/v1.0.0.zip")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	b, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if !bytes.Equal(b, zipContent) {
+		t.Errorf("got %d bytes, want %d bytes of zip file", len(b), len(zipContent))
+	}
+	if got, want := errorLog.String(), "goproxy: failed to write fetch result: example.com/@v/v1.0.0.zip: "+os.ErrDeadlineExceeded.Error()+"\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestGoproxyServeFetchToolchain(t *testing.T) {
+	proxyServer, setProxyHandler := newHTTPTestServer()
+	defer proxyServer.Close()
+	const (
+		modulePath    = "golang.org/toolchain"
+		moduleVersion = "v0.0.1-go1.22.0.linux-amd64"
+	)
+	info := marshalInfo(moduleVersion, time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+	zipFile := filepath.Join(t.TempDir(), "zip")
+	if err := writeZipFile(zipFile, map[string][]byte{
+		modulePath + "@" + moduleVersion + "/bin/go":  bytes.Repeat([]byte("go"), 1<<20),
+		modulePath + "@" + moduleVersion + "/VERSION": []byte("go1.22.0"),
+	}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	zipContent, err := os.ReadFile(zipFile)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	setProxyHandler(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/" + modulePath + "/@v/" + moduleVersion + ".info":
+			responseSuccess(rw, req, strings.NewReader(info), "application/json; charset=utf-8", -2)
+		case "/" + modulePath + "/@v/" + moduleVersion + ".mod":
+			responseSuccess(rw, req, strings.NewReader("module "+modulePath), "text/plain; charset=utf-8", -2)
+		case "/" + modulePath + "/@v/" + moduleVersion + ".zip":
+			http.ServeFile(rw, req, zipFile)
+		default:
+			responseNotFound(rw, req, -2)
+		}
+	})
+	g := &Goproxy{
+		Env:         []string{"GOPROXY=" + proxyServer.URL, "GOSUMDB=off"},
+		Cacher:      DirCacher(t.TempDir()),
+		TempDir:     t.TempDir(),
+		ErrorLogger: log.New(io.Discard, "", 0),
+	}
+	for _, tt := range []struct {
+		n           int
+		path        string
+		wantContent string
+	}{
+		{1, "/" + modulePath + "/@v/" + moduleVersion + ".info", info},
+		{2, "/" + modulePath + "/@v/" + moduleVersion + ".mod", "module " + modulePath},
+		{3, "/" + modulePath + "/@v/" + moduleVersion + ".zip", string(zipContent)},
+	} {
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest("", tt.path, nil))
+		recr := rec.Result()
+		if got, want := recr.StatusCode, http.StatusOK; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if b, err := io.ReadAll(recr.Body); err != nil {
+			t.Errorf("test(%d): unexpected error %q", tt.n, err)
+		} else if got, want := string(b), tt.wantContent; got != want {
+			t.Errorf("test(%d): got %d bytes, want %d bytes", tt.n, len(got), len(want))
+		}
+	}
+
+	setProxyHandler(func(rw http.ResponseWriter, req *http.Request) { responseNotFound(rw, req, -2) })
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, httptest.NewRequest("", "/"+modulePath+"/@v/"+moduleVersion+".zip", nil))
+	recr := rec.Result()
+	if got, want := recr.StatusCode, http.StatusOK; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+	if got, want := rec.Body.Len(), len(zipContent); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+	rc, err := g.Cacher.Get(context.Background(), modulePath+"/@v/"+moduleVersion+".ziphash")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	rc.Close()
+}
+
+func TestGoproxyServeFetchEscapedModulePath(t *testing.T) {
+	proxyServer, setProxyHandler := newHTTPTestServer()
+	defer proxyServer.Close()
+	info := marshalInfo("v1.0.0-RC1", time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+	var (
+		mutex      sync.Mutex
+		proxyPaths []string
+	)
+	setProxyHandler(func(rw http.ResponseWriter, req *http.Request) {
+		mutex.Lock()
+		proxyPaths = append(proxyPaths, req.URL.Path)
+		mutex.Unlock()
+		switch req.URL.Path {
+		case "/github.com/!azure/azure-sdk-for-go/@v/list":
+			responseSuccess(rw, req, strings.NewReader("v1.0.0\nv1.0.0-RC1"), "text/plain; charset=utf-8", -2)
+		case "/github.com/!azure/azure-sdk-for-go/@v/v1.0.0-!r!c1.info":
+			responseSuccess(rw, req, strings.NewReader(info), "application/json; charset=utf-8", -2)
+		default:
+			responseNotFound(rw, req, -2)
+		}
+	})
+
+	var fetcherPaths []string
+	for _, tt := range []struct {
+		n                int
+		fetcher          Fetcher
+		path             string
+		wantStatusCode   int
+		wantContent      string
+		wantProxyPaths   []string
+		wantFetcherPaths []string
+	}{
+		{
+			n:              1,
+			path:           "/github.com/!azure/azure-sdk-for-go/@v/list",
+			wantStatusCode: http.StatusOK,
+			wantContent:    "v1.0.0-RC1\nv1.0.0",
+			wantProxyPaths: []string{"/github.com/!azure/azure-sdk-for-go/@v/list"},
+		},
+		{
+			n:              2,
+			path:           "/github.com/!azure/azure-sdk-for-go/@v/v1.0.0-!r!c1.info",
+			wantStatusCode: http.StatusOK,
+			wantContent:    info,
+			wantProxyPaths: []string{"/github.com/!azure/azure-sdk-for-go/@v/v1.0.0-!r!c1.info"},
+		},
+		{
+			n:              3,
+			path:           "/github.com/Azure/azure-sdk-for-go/@v/list",
+			wantStatusCode: http.StatusBadRequest,
+			wantContent:    `bad request: invalid escaped module path "github.com/Azure/azure-sdk-for-go"`,
+		},
+		{
+			n: 4,
+			fetcher: &testFetcher{list: func(ctx context.Context, path string) ([]string, error) {
+				fetcherPaths = append(fetcherPaths, path)
+				return []string{"v1.0.0-RC1"}, nil
+			}},
+			path:             "/github.com/!azure/azure-sdk-for-go/@v/list",
+			wantStatusCode:   http.StatusOK,
+			wantContent:      "v1.0.0-RC1",
+			wantFetcherPaths: []string{"github.com/Azure/azure-sdk-for-go"},
+		},
+	} {
+		proxyPaths, fetcherPaths = nil, nil
+		cacher := DirCacher(t.TempDir())
+		g := &Goproxy{
+			Env:         []string{"GOPROXY=" + proxyServer.URL, "GOSUMDB=off"},
+			Fetcher:     tt.fetcher,
+			Cacher:      cacher,
+			ErrorLogger: log.New(io.Discard, "", 0),
+		}
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		recr := rec.Result()
+		if got, want := recr.StatusCode, tt.wantStatusCode; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if b, err := io.ReadAll(recr.Body); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		} else if got, want := string(b), tt.wantContent; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		if got, want := strings.Join(proxyPaths, ","), strings.Join(tt.wantProxyPaths, ","); got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		if got, want := strings.Join(fetcherPaths, ","), strings.Join(tt.wantFetcherPaths, ","); got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		if tt.wantStatusCode != http.StatusOK {
+			continue
+		}
+
+		// Cache names are the escaped request paths, so the cached files
+		// round-trip on case-insensitive file systems too.
+		if b, err := os.ReadFile(filepath.Join(string(cacher), filepath.FromSlash(strings.TrimPrefix(tt.path, "/")))); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		} else if got, want := string(b), tt.wantContent; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		rec = httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		req.Header.Set("Disable-Module-Fetch", "true")
+		g.ServeHTTP(rec, req)
+		if got, want := rec.Body.String(), tt.wantContent; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+}
+
+func TestGoproxyServeFetchInfoLastModified(t *testing.T) {
+	cacher := &MemoryCacher{Now: func() time.Time { return time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC) }}
+	for _, name := range []string{"example.com/@latest", "example.com/@v/master.info", "example.com/@v/v1.0.0.info"} {
+		if err := cacher.Put(context.Background(), name, strings.NewReader(`{"Version":"v1.0.0","Time":"2000-01-01T00:00:00.123456789Z"}`)); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+	g := &Goproxy{Cacher: cacher, Offline: true}
+	for _, tt := range []struct {
+		n                int
+		path             string
+		ifModifiedSince  string
+		wantStatusCode   int
+		wantLastModified string
+	}{
+		{1, "/example.com/@latest", "", http.StatusOK, "Fri, 01 Jan 2010 00:00:00 GMT"},
+		{2, "/example.com/@latest", "Sat, 01 Jan 2000 00:00:00 GMT", http.StatusOK, "Fri, 01 Jan 2010 00:00:00 GMT"},
+		{3, "/example.com/@latest", "Fri, 01 Jan 2010 00:00:00 GMT", http.StatusNotModified, "Fri, 01 Jan 2010 00:00:00 GMT"},
+		{4, "/example.com/@v/master.info", "Sat, 01 Jan 2000 00:00:00 GMT", http.StatusOK, "Fri, 01 Jan 2010 00:00:00 GMT"},
+		{5, "/example.com/@v/v1.0.0.info", "", http.StatusOK, "Sat, 01 Jan 2000 00:00:00 GMT"},
+		{6, "/example.com/@v/v1.0.0.info", "Sat, 01 Jan 2000 00:00:00 GMT", http.StatusNotModified, "Sat, 01 Jan 2000 00:00:00 GMT"},
+		{7, "/example.com/@v/v1.0.0.info", "Fri, 31 Dec 1999 23:59:59 GMT", http.StatusOK, "Sat, 01 Jan 2000 00:00:00 GMT"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.ifModifiedSince != "" {
+			req.Header.Set("If-Modified-Since", tt.ifModifiedSince)
+		}
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, req)
+		recr := rec.Result()
+		if got, want := recr.StatusCode, tt.wantStatusCode; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if got, want := recr.Header.Get("Last-Modified"), tt.wantLastModified; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+}
+
+func TestIsDownloadInfoName(t *testing.T) {
+	for _, tt := range []struct {
+		n    int
+		name string
+		want bool
+	}{
+		{1, "example.com/@v/v1.0.0.info", true},
+		{2, "example.com/!foo/@v/v1.0.0-!r!c.1.info", true},
+		{3, "example.com/@v/master.info", false},
+		{4, "example.com/@latest", false},
+		{5, "example.com/@v/v1.0.0.mod", false},
+		{6, "sumdb/sum.golang.org/latest", false},
+	} {
+		if got, want := isDownloadInfoName(tt.name), tt.want; got != want {
+			t.Errorf("test(%d): got %t, want %t", tt.n, got, want)
+		}
+	}
+}
+
+func TestGoproxyServeFetchStaleOnError(t *testing.T) {
+	proxyServer, setProxyHandler := newHTTPTestServer()
+	defer proxyServer.Close()
+	setProxyHandler(func(rw http.ResponseWriter, req *http.Request) {
+		responseString(rw, req, http.StatusBadGateway, -2, "bad gateway")
+	})
+	for _, tt := range []struct {
+		n                   int
+		disableStaleOnError bool
+		maxStaleAge         time.Duration
+		name                string
+		cachedAge           time.Duration
+		wantStatusCode      int
+		wantWarning         string
+		wantContent         string
+	}{
+		{1, true, 0, "example.com/@v/list", time.Second, http.StatusNotFound, "", "not found: bad upstream"},
+		{2, false, 0, "example.com/@v/list", 24 * time.Hour, http.StatusOK, `110 - "Response is Stale"`, "v1.0.0"},
+		{3, false, time.Hour, "example.com/@v/list", time.Second, http.StatusOK, `110 - "Response is Stale"`, "v1.0.0"},
+		{4, false, time.Hour, "example.com/@v/list", 24 * time.Hour, http.StatusNotFound, "", "not found: bad upstream"},
+		{5, false, time.Hour, "example.com/@latest", time.Second, http.StatusOK, `110 - "Response is Stale"`, "v1.0.0"},
+		{6, false, time.Hour, "example.com/v2/@latest", 0, http.StatusNotFound, "", "not found: bad upstream"},
+	} {
+		cacheDir := t.TempDir()
+		if tt.cachedAge > 0 {
+			cacheFile := filepath.Join(cacheDir, filepath.FromSlash(tt.name))
+			if err := os.MkdirAll(filepath.Dir(cacheFile), 0o755); err != nil {
+				t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+			}
+			if err := os.WriteFile(cacheFile, []byte("v1.0.0"), 0o644); err != nil {
+				t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+			}
+			cachedAt := time.Now().Add(-tt.cachedAge)
+			if err := os.Chtimes(cacheFile, cachedAt, cachedAt); err != nil {
+				t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+			}
+		}
+		g := &Goproxy{
+			Env:                 []string{"GOPROXY=" + proxyServer.URL, "GOSUMDB=off"},
+			Cacher:              DirCacher(cacheDir),
+			DisableStaleOnError: tt.disableStaleOnError,
+			MaxStaleAge:         tt.maxStaleAge,
+			FetchRetries:        -1,
+			ErrorLogger:         log.New(io.Discard, "", 0),
+		}
+		g.init()
+		rec := httptest.NewRecorder()
+		g.serveFetch(rec, httptest.NewRequest("", "/", nil), tt.name, t.TempDir())
+		recr := rec.Result()
+		if got, want := recr.StatusCode, tt.wantStatusCode; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if got, want := recr.Header.Get("Warning"), tt.wantWarning; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		if b, err := io.ReadAll(recr.Body); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		} else if got, want := string(b), tt.wantContent; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+}
+
+func TestGoproxyServeFetchListCacheTTL(t *testing.T) {
+	proxyServer, setProxyHandler := newHTTPTestServer()
+	defer proxyServer.Close()
+	for _, tt := range []struct {
+		n               int
+		listCacheTTL    time.Duration
+		name            string
+		cachedAge       time.Duration
+		wantContent     string
+		wantUpstreamHit bool
+	}{
+		{1, 0, "example.com/@v/list", time.Second, "v1.1.0", true},
+		{2, time.Minute, "example.com/@v/list", time.Second, "v1.0.0", false},
+		{3, time.Minute, "example.com/@v/list", time.Hour, "v1.1.0", true},
+		{4, time.Minute, "example.com/@v/list", 0, "v1.1.0", true},
+		{5, time.Minute, "example.com/@latest", time.Second, `{"Version":"v1.1.0","Time":"2000-01-01T00:00:00Z"}`, true},
+	} {
+		var upstreamHit bool
+		setProxyHandler(func(rw http.ResponseWriter, req *http.Request) {
+			upstreamHit = true
+			switch req.URL.Path {
+			case "/example.com/@v/list":
+				responseSuccess(rw, req, strings.NewReader("v1.1.0"), "text/plain; charset=utf-8", -2)
+			case "/example.com/@latest":
+				responseSuccess(rw, req, strings.NewReader(`{"Version":"v1.1.0","Time":"2000-01-01T00:00:00Z"}`), "application/json; charset=utf-8", -2)
+			default:
+				responseNotFound(rw, req, -2)
+			}
+		})
+		cacheDir := t.TempDir()
+		if tt.cachedAge > 0 {
+			cacheFile := filepath.Join(cacheDir, filepath.FromSlash(tt.name))
+			if err := os.MkdirAll(filepath.Dir(cacheFile), 0o755); err != nil {
+				t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+			}
+			if err := os.WriteFile(cacheFile, []byte("v1.0.0"), 0o644); err != nil {
+				t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+			}
+			cachedAt := time.Now().Add(-tt.cachedAge)
+			if err := os.Chtimes(cacheFile, cachedAt, cachedAt); err != nil {
+				t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+			}
+		}
+		g := &Goproxy{
+			Env:          []string{"GOPROXY=" + proxyServer.URL, "GOSUMDB=off"},
+			Cacher:       DirCacher(cacheDir),
+			ListCacheTTL: tt.listCacheTTL,
+			ErrorLogger:  log.New(io.Discard, "", 0),
+		}
+		g.init()
+		rec := httptest.NewRecorder()
+		g.serveFetch(rec, httptest.NewRequest("", "/", nil), tt.name, t.TempDir())
+		recr := rec.Result()
+		if got, want := recr.StatusCode, http.StatusOK; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if b, err := io.ReadAll(recr.Body); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		} else if got, want := string(b), tt.wantContent; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		if got, want := upstreamHit, tt.wantUpstreamHit; got != want {
+			t.Errorf("test(%d): got %t, want %t", tt.n, got, want)
+		}
+	}
+}
+
+func TestGoproxyServeFetchTimeout(t *testing.T) {
+	proxyServer, setProxyHandler := newHTTPTestServer()
+	defer proxyServer.Close()
+	setProxyHandler(func(rw http.ResponseWriter, req *http.Request) {
+		select {
+		case <-time.After(200 * time.Millisecond):
+		case <-req.Context().Done():
+			return
+		}
+		responseSuccess(rw, req, strings.NewReader("v1.0.0"), "text/plain; charset=utf-8", -2)
+	})
+	for _, tt := range []struct {
+		n                   int
+		fetchTimeout        time.Duration
+		listFetchTimeout    time.Duration
+		upstreamErrorStatus bool
+		wantStatusCode      int
+		wantContent         string
+	}{
+		{1, 0, 0, false, http.StatusOK, "v1.0.0"},
+		{2, 10 * time.Millisecond, 0, false, http.StatusNotFound, "not found: fetch timed out"},
+		{3, 10 * time.Millisecond, time.Minute, false, http.StatusOK, "v1.0.0"},
+		{4, time.Minute, 10 * time.Millisecond, false, http.StatusNotFound, "not found: fetch timed out"},
+		{5, 10 * time.Millisecond, 0, true, http.StatusGatewayTimeout, "fetch timed out"},
+	} {
+		g := &Goproxy{
+			Env:                 []string{"GOPROXY=" + proxyServer.URL, "GOSUMDB=off"},
+			FetchRetries:        -1,
+			FetchTimeout:        tt.fetchTimeout,
+			ListFetchTimeout:    tt.listFetchTimeout,
+			UpstreamErrorStatus: tt.upstreamErrorStatus,
+			ErrorLogger:         log.New(io.Discard, "", 0),
+		}
+		g.init()
+		rec := httptest.NewRecorder()
+		g.serveFetch(rec, httptest.NewRequest("", "/", nil), "example.com/@v/list", t.TempDir())
+		recr := rec.Result()
+		if got, want := recr.StatusCode, tt.wantStatusCode; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if b, err := io.ReadAll(recr.Body); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		} else if got, want := string(b), tt.wantContent; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+}
+
+func TestGoproxyFetchTimeout(t *testing.T) {
+	g := &Goproxy{
+		FetchTimeout:     time.Minute,
+		ListFetchTimeout: time.Second,
+		ZipFetchTimeout:  time.Hour,
+	}
+	g.init()
+	for _, tt := range []struct {
+		n    int
+		name string
+		want time.Duration
+	}{
+		{1, "example.com/@v/list", time.Second},
+		{2, "example.com/@latest", time.Second},
+		{3, "example.com/@v/master.info", time.Second},
+		{4, "example.com/@v/v1.0.0.info", time.Minute},
+		{5, "example.com/@v/v1.0.0.mod", time.Minute},
+		{6, "example.com/@v/v1.0.0.zip", time.Hour},
+	} {
+		f, err := newFetch(g, tt.name, t.TempDir())
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		}
+		if got, want := g.fetchTimeout(f), tt.want; got != want {
+			t.Errorf("test(%d): got %v, want %v", tt.n, got, want)
+		}
+	}
+}
+
+func TestGoproxyServeFetchFilterRetracted(t *testing.T) {
+	proxyServer, setProxyHandler := newHTTPTestServer()
+	defer proxyServer.Close()
+	for _, tt := range []struct {
+		n                       int
+		filterRetractedVersions bool
+		list                    string
+		latestVersion           string
+		latestMod               string
+		wantContent             string
+	}{
+		{1, false, "v1.0.0\nv1.1.0\nv1.2.0", "v1.2.0", "module example.com\nretract v1.1.0", "v1.0.0\nv1.1.0\nv1.2.0"},
+		{2, true, "v1.0.0\nv1.1.0\nv1.2.0", "v1.2.0", "module example.com\nretract v1.1.0", "v1.0.0\nv1.2.0"},
+		{3, true, "v1.0.0\nv1.1.0\nv1.2.0", "v1.2.0", "module example.com\nretract [v1.0.0, v1.1.0]", "v1.2.0"},
+		{4, true, "v1.0.0\nv1.1.0\nv1.2.0-rc.1", "v1.1.0", "module example.com\nretract v1.0.0", "v1.1.0\nv1.2.0-rc.1"},
+		{5, true, "v1.0.0-rc.1\nv1.0.0-rc.2", "v1.0.0-rc.2", "module example.com\nretract v1.0.0-rc.1", "v1.0.0-rc.2"},
+		{6, true, "v1.0.0\nv1.1.0", "v1.1.0", "module example.com", "v1.0.0\nv1.1.0"},
+		{7, true, "v1.0.0\nv1.1.0", "v1.1.0", "", "v1.0.0\nv1.1.0"},
+		{8, true, "", "", "", ""},
+	} {
+		setProxyHandler(func(rw http.ResponseWriter, req *http.Request) {
+			switch {
+			case req.URL.Path == "/example.com/@v/list":
+				responseSuccess(rw, req, strings.NewReader(tt.list), "text/plain; charset=utf-8", -2)
+			case req.URL.Path == "/example.com/@v/"+tt.latestVersion+".mod" && tt.latestMod != "":
+				responseSuccess(rw, req, strings.NewReader(tt.latestMod), "text/plain; charset=utf-8", -2)
+			default:
+				responseNotFound(rw, req, -2)
+			}
+		})
+		g := &Goproxy{
+			Env:                     []string{"GOPROXY=" + proxyServer.URL, "GOSUMDB=off"},
+			Cacher:                  &MemoryCacher{},
+			FilterRetractedVersions: tt.filterRetractedVersions,
+			ErrorLogger:             log.New(io.Discard, "", 0),
+		}
+		g.init()
+		rec := httptest.NewRecorder()
+		g.serveFetch(rec, httptest.NewRequest("", "/", nil), "example.com/@v/list", t.TempDir())
+		recr := rec.Result()
+		if got, want := recr.StatusCode, http.StatusOK; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if b, err := io.ReadAll(recr.Body); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		} else if got, want := string(b), tt.wantContent; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+
+		rc, err := g.Cacher.Get(context.Background(), "example.com/@v/list")
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		} else if got, want := string(b), tt.wantContent; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+
+		_, err = g.Cacher.Get(context.Background(), "example.com/@v/"+tt.latestVersion+".mod")
+		if tt.filterRetractedVersions && tt.latestMod != "" {
+			if err != nil {
+				t.Errorf("test(%d): unexpected error %q", tt.n, err)
+			}
+		} else if got, want := err, fs.ErrNotExist; !errors.Is(got, want) {
+			t.Errorf("test(%d): got %v, want %v", tt.n, got, want)
+		}
+	}
+}
+
+func TestGoproxyServeFetchFilterRetractedDirect(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping test that requires a shell script as the go binary")
+	}
+	argsFile := filepath.Join(t.TempDir(), "args")
+	goBin := filepath.Join(t.TempDir(), "go")
+	if err := os.WriteFile(goBin, []byte(`#!/bin/sh
+if [ "$1" = version ]; then
+	echo 'go version go1.21.0 linux/amd64'
+	exit
+fi
+echo "$@" >> "$ARGS_FILE"
+echo '{"Versions":["v1.2.0","v1.0.0"]}'
+`), 0o755); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	g := &Goproxy{
+		Env:                     []string{"GOPROXY=direct", "GOSUMDB=off", "ARGS_FILE=" + argsFile},
+		GoBinName:               goBin,
+		Cacher:                  &MemoryCacher{},
+		FilterRetractedVersions: true,
+	}
+	g.init()
+	rec := httptest.NewRecorder()
+	g.serveFetch(rec, httptest.NewRequest("", "/", nil), "example.com/@v/list", t.TempDir())
+	recr := rec.Result()
+	if got, want := recr.StatusCode, http.StatusOK; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+	if b, err := io.ReadAll(recr.Body); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := string(b), "v1.0.0\nv1.2.0"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if b, err := os.ReadFile(argsFile); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := string(b), "list -json -m -versions example.com@latest\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestGoproxyFetchCallbacks(t *testing.T) {
+	proxyServer, setProxyHandler := newHTTPTestServer()
+	defer proxyServer.Close()
+	info := marshalInfo("v1.0.0", time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+	setProxyHandler(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/example.com/!foo/@v/v1.0.0.info":
+			responseSuccess(rw, req, strings.NewReader(info), "application/json; charset=utf-8", -2)
+		case "/example.com/!foo/@v/list":
+			responseSuccess(rw, req, strings.NewReader("v1.0.0\nv1.1.0"), "text/plain; charset=utf-8", -2)
+		default:
+			responseNotFound(rw, req, -2)
+		}
+	})
+
+	var (
+		mutex  sync.Mutex
+		events []string
+	)
+	addEvent := func(format string, a ...any) {
+		mutex.Lock()
+		events = append(events, fmt.Sprintf(format, a...))
+		mutex.Unlock()
+	}
+	g := &Goproxy{
+		Env:    []string{"GOPROXY=" + proxyServer.URL, "GOSUMDB=off"},
+		Cacher: DirCacher(t.TempDir()),
+		OnFetchStart: func(ctx context.Context, modulePath, moduleVersion, op string) {
+			addEvent("start %s@%s %s", modulePath, moduleVersion, op)
+		},
+		OnFetchComplete: func(ctx context.Context, modulePath, moduleVersion, op string, err error, bytes int64) {
+			addEvent("complete %s@%s %s %v %d", modulePath, moduleVersion, op, err, bytes)
+		},
+		OnCacheHit: func(ctx context.Context, modulePath, moduleVersion, op string) {
+			addEvent("hit %s@%s %s", modulePath, moduleVersion, op)
+		},
+		ErrorLogger: log.New(io.Discard, "", 0),
+	}
+	for _, tt := range []struct {
+		n              int
+		path           string
+		disableFetch   bool
+		wantStatusCode int
+		wantEvents     []string
+	}{
+		{1, "/example.com/!foo/@v/v1.0.0.info", false, http.StatusOK, []string{
+			"start example.com/Foo@v1.0.0 download info",
+			fmt.Sprintf("complete example.com/Foo@v1.0.0 download info <nil> %d", len(info)),
+		}},
+		{2, "/example.com/!foo/@v/v1.0.0.info", false, http.StatusOK, []string{
+			"hit example.com/Foo@v1.0.0 download info",
+		}},
+		{3, "/example.com/!foo/@v/list", false, http.StatusOK, []string{
+			"start example.com/Foo@latest list",
+			"complete example.com/Foo@latest list <nil> 13",
+		}},
+		{4, "/example.com/!foo/@v/list", true, http.StatusOK, []string{
+			"hit example.com/Foo@latest list",
+		}},
+		{5, "/example.com/!foo/@v/v1.1.0.info", false, http.StatusNotFound, []string{
+			"start example.com/Foo@v1.1.0 download info",
+			"complete example.com/Foo@v1.1.0 download info not found 0",
+		}},
+		{6, "/example.com/!foo/@v/v1.2.0.info", true, http.StatusNotFound, nil},
+	} {
+		events = nil
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.disableFetch {
+			req.Header.Set("Disable-Module-Fetch", "true")
+		}
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, req)
+		if got, want := rec.Code, tt.wantStatusCode; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if got, want := strings.Join(events, "\n"), strings.Join(tt.wantEvents, "\n"); got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+}
+
+func TestGoproxyServeFetchDownloadMaxZipFileSize(t *testing.T) {
+	proxyServer, setProxyHandler := newHTTPTestServer()
+	defer proxyServer.Close()
+	zipFile := filepath.Join(t.TempDir(), "zip")
+	if err := writeZipFile(zipFile, map[string][]byte{"example.com@v1.0.0/go.mod": []byte("module example.com")}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	fi, err := os.Stat(zipFile)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	setProxyHandler(func(rw http.ResponseWriter, req *http.Request) {
+		if path.Ext(req.URL.Path) != ".zip" {
+			responseNotFound(rw, req, -2)
+			return
+		}
+		http.ServeFile(rw, req, zipFile)
+	})
+	for _, tt := range []struct {
+		n              int
+		maxZipFileSize int64
+		wantStatusCode int
+		wantContent    string
+		wantCached     bool
+	}{
+		{1, 0, http.StatusOK, "", true},
+		{2, -1, http.StatusOK, "", true},
+		{3, fi.Size(), http.StatusOK, "", true},
+		{4, fi.Size() - 1, http.StatusRequestEntityTooLarge, fmt.Sprintf("module zip file exceeds the maximum size of %d bytes", fi.Size()-1), false},
+	} {
+		cacher := DirCacher(t.TempDir())
+		g := &Goproxy{
+			Env:            []string{"GOPROXY=" + proxyServer.URL, "GOSUMDB=off"},
+			Cacher:         cacher,
+			MaxZipFileSize: tt.maxZipFileSize,
+			ErrorLogger:    log.New(io.Discard, "", 0),
+		}
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/example.com/@v/v1.0.0.zip", nil))
+		recr := rec.Result()
+		if got, want := recr.StatusCode, tt.wantStatusCode; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if tt.wantContent != "" {
+			if b, err := io.ReadAll(recr.Body); err != nil {
+				t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+			} else if got, want := string(b), tt.wantContent; got != want {
+				t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+			}
+		}
+		rc, err := cacher.Get(context.Background(), "example.com/@v/v1.0.0.zip")
+		if err == nil {
+			rc.Close()
+		}
+		if got, want := err == nil, tt.wantCached; got != want {
+			t.Errorf("test(%d): got %t, want %t", tt.n, got, want)
+		}
+	}
+}
+
+func TestGoproxySetETagHeader(t *testing.T) {
+	cacher := DirCacher(t.TempDir())
+	if err := cacher.Put(context.Background(), "example.com/@v/v1.0.0.ziphash", strings.NewReader("h1:foobar\n")); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	g := &Goproxy{Cacher: cacher}
+	g.init()
+	for _, tt := range []struct {
+		n        int
+		name     string
+		content  io.Reader
+		wantETag string
+	}{
+		{1, "example.com/@v/v1.0.0.zip", nil, `"h1:foobar"`},
+		{2, "example.com/@v/v1.1.0.zip", nil, ""},
+		{3, "example.com/@v/v1.0.0.mod", strings.NewReader("module example.com"), `"h1:vUsPMGpx9ZXXzECCOsOmYCW7npJTwuA16yl89n3Mgls="`},
+		{4, "example.com/@v/v1.0.0.mod", io.MultiReader(strings.NewReader("module example.com")), ""},
+		{5, "example.com/@v/v1.0.0.info", strings.NewReader("{}"), ""},
+		{6, "example.com/@latest", strings.NewReader("{}"), ""},
+		{7, "sumdb/sumdb.example.com/@v/v1.0.0.zip", nil, ""},
+	} {
+		rec := httptest.NewRecorder()
+		g.setETagHeader(context.Background(), rec, tt.name, tt.content)
+		if got, want := rec.Header().Get("ETag"), tt.wantETag; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		if rs, ok := tt.content.(io.ReadSeeker); ok {
+			if b, err := io.ReadAll(rs); err != nil {
+				t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+			} else if len(b) == 0 {
+				t.Errorf("test(%d): expected rewound content", tt.n)
+			}
+		}
+	}
+}
+
+func TestJoinModulePatterns(t *testing.T) {
+	for _, tt := range []struct {
+		n        int
+		patterns []string
+		want     string
+	}{
+		{1, nil, ""},
+		{2, []string{"", " "}, ""},
+		{3, []string{"github.com/MyCompany/*", " golang.org "}, "github.com/mycompany/*,golang.org"},
+	} {
+		if got, want := joinModulePatterns(tt.patterns), tt.want; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+}
+
+func TestGoproxyDoFetch(t *testing.T) {
+	proxyServer, setProxyHandler := newHTTPTestServer()
+	defer proxyServer.Close()
+	for _, tt := range []struct {
+		n                 int
+		proxyHandler      http.HandlerFunc
+		notFoundTTL       time.Duration
+		notFoundQueryTTL  time.Duration
+		now               time.Time
+		name              string
+		setupCacher       func(cacher Cacher) error
+		wantProxyRequests int
+		wantError         error
+		wantNotFoundCache bool
+	}{
+		{
+			n: 1,
+			proxyHandler: func(rw http.ResponseWriter, req *http.Request) {
+				responseNotFound(rw, req, 60, "unknown revision v1.0.0")
+			},
+			notFoundTTL:       time.Minute,
+			name:              "example.com/@v/v1.0.0.info",
+			wantProxyRequests: 1,
+			wantError:         notFoundError("not found: unknown revision v1.0.0"),
+			wantNotFoundCache: true,
+		},
+		{
+			n:                 2,
+			proxyHandler:      func(rw http.ResponseWriter, req *http.Request) { responseNotFound(rw, req, 60) },
+			notFoundTTL:       time.Minute,
+			name:              "example.com/@v/list",
+			wantProxyRequests: 3,
+			wantError:         errNotFound,
+		},
+		{
+			n:                 3,
+			proxyHandler:      func(rw http.ResponseWriter, req *http.Request) { responseNotFound(rw, req, 60) },
+			notFoundQueryTTL:  time.Minute,
+			name:              "example.com/@latest",
+			wantProxyRequests: 1,
+			wantError:         errNotFound,
+			wantNotFoundCache: true,
+		},
+		{
+			n:            4,
+			proxyHandler: func(rw http.ResponseWriter, req *http.Request) { responseNotFound(rw, req, 60) },
+			notFoundTTL:  time.Minute,
+			name:         "example.com/@v/v1.0.0.mod",
+			setupCacher: func(cacher Cacher) error {
+				return cacher.Put(context.Background(), "example.com/@v/v1.0.0.mod.notfound", strings.NewReader(`{"Error":"not found","Time":"2000-01-01T00:00:00Z"}`))
+			},
+			wantProxyRequests: 1,
+			wantError:         errNotFound,
+			wantNotFoundCache: true,
+		},
+		{
+			n:            5,
+			proxyHandler: func(rw http.ResponseWriter, req *http.Request) { responseNotFound(rw, req, 60) },
+			notFoundTTL:  time.Minute,
+			name:         "example.com/@v/v1.0.0.mod",
+			setupCacher: func(cacher Cacher) error {
+				return cacher.Put(context.Background(), "example.com/@v/v1.0.0.mod.notfound", strings.NewReader("{"))
+			},
+			wantProxyRequests: 1,
+			wantError:         errNotFound,
+			wantNotFoundCache: true,
+		},
+		{
+			n:                 6,
+			proxyHandler:      func(rw http.ResponseWriter, req *http.Request) { responseNotFound(rw, req, 60, errFetchTimedOut) },
+			notFoundTTL:       time.Minute,
+			name:              "example.com/@v/v1.0.0.zip",
+			wantProxyRequests: 3,
+			wantError:         notFoundError("not found: fetch timed out"),
+		},
+		{
+			n:            7,
+			proxyHandler: func(rw http.ResponseWriter, req *http.Request) { responseNotFound(rw, req, 60) },
+			notFoundTTL:  time.Minute,
+			now:          time.Date(2000, 1, 1, 0, 0, 30, 0, time.UTC),
+			name:         "example.com/@v/v1.0.0.mod",
+			setupCacher: func(cacher Cacher) error {
+				return cacher.Put(context.Background(), "example.com/@v/v1.0.0.mod.notfound", strings.NewReader(`{"Error":"not found","Time":"2000-01-01T00:00:00Z"}`))
+			},
+			wantProxyRequests: 0,
+			wantError:         errNotFound,
+			wantNotFoundCache: true,
+		},
+		{
+			n:            8,
+			proxyHandler: func(rw http.ResponseWriter, req *http.Request) { responseNotFound(rw, req, 60, errFetchTimedOut) },
+			notFoundTTL:  time.Minute,
+			name:         "example.com/@v/v1.0.0.mod",
+			setupCacher: func(cacher Cacher) error {
+				return cacher.Put(context.Background(), "example.com/@v/v1.0.0.mod.notfound", strings.NewReader(`{"Error":"not found","Time":"2000-01-01T00:00:00Z"}`))
+			},
+			wantProxyRequests: 3,
+			wantError:         notFoundError("not found: fetch timed out"),
+		},
+	} {
+		var proxyRequests int
+		setProxyHandler(func(rw http.ResponseWriter, req *http.Request) {
+			proxyRequests++
+			tt.proxyHandler(rw, req)
+		})
+		g := &Goproxy{
+			Env:              []string{"GOPROXY=" + proxyServer.URL, "GOSUMDB=off"},
+			Cacher:           DirCacher(t.TempDir()),
+			NotFoundTTL:      tt.notFoundTTL,
+			NotFoundQueryTTL: tt.notFoundQueryTTL,
+			ErrorLogger:      log.New(io.Discard, "", 0),
+		}
+		if !tt.now.IsZero() {
+			g.Now = func() time.Time { return tt.now }
+		}
+		g.init()
+		if tt.setupCacher != nil {
+			if err := tt.setupCacher(g.Cacher); err != nil {
+				t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+			}
+		}
+		for i := 0; i < 3; i++ {
+			f, err := newFetch(g, tt.name, t.TempDir())
+			if err != nil {
+				t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+			}
+			if _, _, err := g.doFetch(context.Background(), f); err == nil {
+				t.Fatalf("test(%d): expected error", tt.n)
+			} else if got, want := err, tt.wantError; !errors.Is(got, want) && got.Error() != want.Error() {
+				t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+			}
+		}
+		if got, want := proxyRequests, tt.wantProxyRequests; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if _, err := g.Cacher.Get(context.Background(), tt.name+".notfound"); (err == nil) != tt.wantNotFoundCache {
+			t.Errorf("test(%d): got error %v, want not found cache %t", tt.n, err, tt.wantNotFoundCache)
+		}
+		if _, err := g.Cacher.Get(context.Background(), tt.name); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("test(%d): got error %v, want %v", tt.n, err, fs.ErrNotExist)
+		}
+	}
+}
+
+func TestIsCacheableNotFoundError(t *testing.T) {
+	for _, tt := range []struct {
+		n    int
+		err  error
+		want bool
+	}{
+		{1, errNotFound, true},
+		{2, notFoundError("unknown revision v1.0.0"), true},
+		{3, notFoundError("example.com@v1.0.0: bad upstream"), false},
+		{4, notFoundError(errFetchTimedOut.Error()), false},
+		{5, errBadUpstream, false},
+		{6, errors.New("internal error"), false},
+	} {
+		if got, want := isCacheableNotFoundError(tt.err), tt.want; got != want {
+			t.Errorf("test(%d): got %t, want %t", tt.n, got, want)
+		}
+	}
+}
+
+func TestGoproxyServeSUMDB(t *testing.T) {
+	sumdbServer, setSUMDBHandler := newHTTPTestServer()
+	defer sumdbServer.Close()
+	for _, tt := range []struct {
+		n                int
+		sumdbHandler     http.HandlerFunc
 		cacher           Cacher
+		setupCacher      func(cacher Cacher) error
 		name             string
 		tempDir          string
+		offline          bool
 		wantStatusCode   int
 		wantContentType  string
 		wantCacheControl string
@@ -581,19 +2250,19 @@ func TestGoproxyServeSUMDB(t *testing.T) {
 			tempDir:          t.TempDir(),
 			wantStatusCode:   http.StatusOK,
 			wantContentType:  "text/plain; charset=utf-8",
-			wantCacheControl: "public, max-age=86400",
+			wantCacheControl: "public, max-age=60",
 			wantContent:      "/lookup/example.com@v1.0.0",
 		},
 		{
 			n:                4,
 			sumdbHandler:     func(rw http.ResponseWriter, req *http.Request) { fmt.Fprint(rw, req.URL.Path) },
 			cacher:           DirCacher(t.TempDir()),
-			name:             "sumdb/sumdb.example.com/tile/2/0/0",
+			name:             "sumdb/sumdb.example.com/tile/2/0/000",
 			tempDir:          t.TempDir(),
 			wantStatusCode:   http.StatusOK,
 			wantContentType:  "application/octet-stream",
-			wantCacheControl: "public, max-age=86400",
-			wantContent:      "/tile/2/0/0",
+			wantCacheControl: "public, max-age=31536000",
+			wantContent:      "/tile/2/0/000",
 		},
 		{
 			n:                5,
@@ -639,30 +2308,99 @@ func TestGoproxyServeSUMDB(t *testing.T) {
 			wantContent:     "internal server error",
 		},
 		{
-			n:                9,
+			n:                9,
+			sumdbHandler:     func(rw http.ResponseWriter, req *http.Request) { rw.WriteHeader(http.StatusNotFound) },
+			cacher:           DirCacher(t.TempDir()),
+			name:             "sumdb/sumdb.example.com/lookup/example.com/v2@v2.0.0",
+			tempDir:          t.TempDir(),
+			wantStatusCode:   http.StatusNotFound,
+			wantContentType:  "text/plain; charset=utf-8",
+			wantCacheControl: "public, max-age=60",
+			wantContent:      "not found",
+		},
+		{
+			n:               10,
+			sumdbHandler:    func(rw http.ResponseWriter, req *http.Request) {},
+			cacher:          &errorCacher{},
+			name:            "sumdb/sumdb.example.com/latest",
+			tempDir:         t.TempDir(),
+			wantStatusCode:  http.StatusInternalServerError,
+			wantContentType: "text/plain; charset=utf-8",
+			wantContent:     "internal server error",
+		},
+		{
+			n:            11,
+			sumdbHandler: func(rw http.ResponseWriter, req *http.Request) { fmt.Fprint(rw, req.URL.Path) },
+			cacher:       DirCacher(t.TempDir()),
+			setupCacher: func(cacher Cacher) error {
+				return cacher.Put(context.Background(), "sumdb/sumdb.example.com/latest", strings.NewReader("cached"))
+			},
+			name:             "sumdb/sumdb.example.com/latest",
+			tempDir:          t.TempDir(),
+			offline:          true,
+			wantStatusCode:   http.StatusOK,
+			wantContentType:  "text/plain; charset=utf-8",
+			wantCacheControl: "public, max-age=3600",
+			wantContent:      "cached",
+		},
+		{
+			n:                12,
+			sumdbHandler:     func(rw http.ResponseWriter, req *http.Request) { fmt.Fprint(rw, req.URL.Path) },
+			cacher:           DirCacher(t.TempDir()),
+			name:             "sumdb/sumdb.example.com/lookup/example.com@v1.0.0",
+			tempDir:          t.TempDir(),
+			offline:          true,
+			wantStatusCode:   http.StatusNotFound,
+			wantContentType:  "text/plain; charset=utf-8",
+			wantCacheControl: "public, max-age=60",
+			wantContent:      "not found: not cached by this proxy in offline mode",
+		},
+		{
+			n:            13,
+			sumdbHandler: func(rw http.ResponseWriter, req *http.Request) { rw.WriteHeader(http.StatusInternalServerError) },
+			cacher:       DirCacher(t.TempDir()),
+			setupCacher: func(cacher Cacher) error {
+				return cacher.Put(context.Background(), "sumdb/sumdb.example.com/tile/8/data/x001/002.p/5", strings.NewReader("cached"))
+			},
+			name:             "sumdb/sumdb.example.com/tile/8/data/x001/002.p/5",
+			tempDir:          t.TempDir(),
+			wantStatusCode:   http.StatusOK,
+			wantContentType:  "application/octet-stream",
+			wantCacheControl: "public, max-age=31536000",
+			wantContent:      "cached",
+		},
+		{
+			n:                14,
+			sumdbHandler:     func(rw http.ResponseWriter, req *http.Request) { fmt.Fprint(rw, req.URL.Path) },
+			cacher:           DirCacher(t.TempDir()),
+			name:             "sumdb/sumdb.example.com/tile/2/0/0",
+			tempDir:          t.TempDir(),
+			wantStatusCode:   http.StatusNotFound,
+			wantContentType:  "text/plain; charset=utf-8",
+			wantCacheControl: "public, max-age=86400",
+			wantContent:      "not found",
+		},
+		{
+			n:                15,
 			sumdbHandler:     func(rw http.ResponseWriter, req *http.Request) { rw.WriteHeader(http.StatusNotFound) },
 			cacher:           DirCacher(t.TempDir()),
-			name:             "sumdb/sumdb.example.com/lookup/example.com/v2@v2.0.0",
+			name:             "sumdb/sumdb.example.com/tile/8/1/000",
 			tempDir:          t.TempDir(),
 			wantStatusCode:   http.StatusNotFound,
 			wantContentType:  "text/plain; charset=utf-8",
 			wantCacheControl: "public, max-age=60",
 			wantContent:      "not found",
 		},
-		{
-			n:               10,
-			sumdbHandler:    func(rw http.ResponseWriter, req *http.Request) {},
-			cacher:          &errorCacher{},
-			name:            "sumdb/sumdb.example.com/latest",
-			tempDir:         t.TempDir(),
-			wantStatusCode:  http.StatusInternalServerError,
-			wantContentType: "text/plain; charset=utf-8",
-			wantContent:     "internal server error",
-		},
 	} {
 		setSUMDBHandler(tt.sumdbHandler)
+		if tt.setupCacher != nil {
+			if err := tt.setupCacher(tt.cacher); err != nil {
+				t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+			}
+		}
 		g := &Goproxy{
 			ProxiedSUMDBs: []string{"sumdb.example.com " + sumdbServer.URL},
+			Offline:       tt.offline,
 			Cacher:        tt.cacher,
 			ErrorLogger:   log.New(io.Discard, "", 0),
 		}
@@ -687,6 +2425,238 @@ func TestGoproxyServeSUMDB(t *testing.T) {
 	}
 }
 
+func TestGoproxyServeSUMDBNoSumCheck(t *testing.T) {
+	sumdbServer, setSUMDBHandler := newHTTPTestServer()
+	defer sumdbServer.Close()
+	var upstreamPaths []string
+	setSUMDBHandler(func(rw http.ResponseWriter, req *http.Request) {
+		upstreamPaths = append(upstreamPaths, req.URL.Path)
+		fmt.Fprint(rw, req.URL.Path)
+	})
+	g := &Goproxy{
+		ProxiedSUMDBs: []string{"sumdb.example.com " + sumdbServer.URL},
+		NoSumCheck:    []string{"corp.example.com/*"},
+		Cacher:        DirCacher(t.TempDir()),
+		TempDir:       t.TempDir(),
+		ErrorLogger:   log.New(io.Discard, "", 0),
+	}
+	for _, tt := range []struct {
+		n              int
+		path           string
+		wantStatusCode int
+	}{
+		{1, "/sumdb/sumdb.example.com/lookup/corp.example.com/foo@v1.0.0", http.StatusNotFound},
+		{2, "/sumdb/sumdb.example.com/lookup/corp.example.com/!foo/bar@v1.0.0", http.StatusNotFound},
+		{3, "/sumdb/sumdb.example.com/lookup/corp.example.com@v1.0.0", http.StatusOK},
+		{4, "/sumdb/sumdb.example.com/lookup/example.com/foo@v1.0.0", http.StatusOK},
+	} {
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest("", tt.path, nil))
+		if got, want := rec.Result().StatusCode, tt.wantStatusCode; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+	}
+	if got, want := strings.Join(upstreamPaths, ","), "/lookup/corp.example.com@v1.0.0,/lookup/example.com/foo@v1.0.0"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestGoproxyPrivate(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping test that requires a shell script as the go binary")
+	}
+	goBin := filepath.Join(t.TempDir(), "go")
+	if err := os.WriteFile(goBin, []byte("#!/bin/sh\necho '{\"Versions\":[\"v1.0.0\"]}'\n"), 0o755); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	proxyServer, setProxyHandler := newHTTPTestServer()
+	defer proxyServer.Close()
+	var proxyPaths []string
+	setProxyHandler(func(rw http.ResponseWriter, req *http.Request) {
+		proxyPaths = append(proxyPaths, req.URL.Path)
+		responseString(rw, req, http.StatusOK, -2, "v1.1.0")
+	})
+	sumdbServer, setSUMDBHandler := newHTTPTestServer()
+	defer sumdbServer.Close()
+	var sumdbPaths []string
+	setSUMDBHandler(func(rw http.ResponseWriter, req *http.Request) {
+		sumdbPaths = append(sumdbPaths, req.URL.Path)
+		fmt.Fprint(rw, req.URL.Path)
+	})
+	g := &Goproxy{
+		Env:           []string{"GOPROXY=" + proxyServer.URL, "GOSUMDB=off"},
+		GoBinName:     goBin,
+		ProxiedSUMDBs: []string{"sumdb.example.com " + sumdbServer.URL},
+		Private:       []string{"", " corp.example.com "},
+		TempDir:       t.TempDir(),
+		ErrorLogger:   log.New(io.Discard, "", 0),
+	}
+	for _, tt := range []struct {
+		n              int
+		path           string
+		wantStatusCode int
+		wantContent    string
+	}{
+		{1, "/corp.example.com/foo/@v/list", http.StatusOK, "v1.0.0"},
+		{2, "/example.com/foo/@v/list", http.StatusOK, "v1.1.0"},
+		{3, "/sumdb/sumdb.example.com/lookup/corp.example.com/foo@v1.0.0", http.StatusNotFound, "not found: checksum database lookup disabled by this proxy"},
+		{4, "/sumdb/sumdb.example.com/lookup/example.com/foo@v1.0.0", http.StatusOK, "/lookup/example.com/foo@v1.0.0"},
+	} {
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest("", tt.path, nil))
+		recr := rec.Result()
+		if got, want := recr.StatusCode, tt.wantStatusCode; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if b, err := io.ReadAll(recr.Body); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		} else if got, want := string(b), tt.wantContent; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+	if got, want := strings.Join(proxyPaths, ","), "/example.com/foo/@v/list"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := strings.Join(sumdbPaths, ","), "/lookup/example.com/foo@v1.0.0"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	g = &Goproxy{Env: []string{}, Private: []string{"corp.example.com"}}
+	g.init()
+	for _, tt := range []struct {
+		n                    int
+		name                 string
+		wantRequiredToVerify bool
+	}{
+		{1, "corp.example.com/foo/@v/v1.0.0.info", false},
+		{2, "example.com/foo/@v/v1.0.0.info", true},
+	} {
+		f, err := newFetch(g, tt.name, t.TempDir())
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		}
+		if got, want := f.requiredToVerify, tt.wantRequiredToVerify; got != want {
+			t.Errorf("test(%d): got %t, want %t", tt.n, got, want)
+		}
+	}
+}
+
+func TestGoproxyServeSUMDBTile(t *testing.T) {
+	sumdbServer, setSUMDBHandler := newHTTPTestServer()
+	defer sumdbServer.Close()
+	tile := make([]byte, 8*32)
+	for i := range tile {
+		tile[i] = byte(i)
+	}
+	var upstreamRequests int
+	setSUMDBHandler(func(rw http.ResponseWriter, req *http.Request) {
+		upstreamRequests++
+		if req.URL.Path != "/tile/8/0/x123/456" {
+			responseNotFound(rw, req, -2)
+			return
+		}
+		responseSuccess(rw, req, bytes.NewReader(tile), "application/octet-stream", -2)
+	})
+	g := &Goproxy{
+		ProxiedSUMDBs: []string{"sumdb.example.com " + sumdbServer.URL},
+		Cacher:        DirCacher(t.TempDir()),
+		ErrorLogger:   log.New(io.Discard, "", 0),
+	}
+	g.init()
+	for i := 1; i <= 2; i++ {
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest("", "/sumdb/sumdb.example.com/tile/8/0/x123/456", nil))
+		recr := rec.Result()
+		if got, want := recr.StatusCode, http.StatusOK; got != want {
+			t.Errorf("test(%d): got %d, want %d", i, got, want)
+		}
+		if got, want := recr.Header.Get("Cache-Control"), "public, max-age=31536000"; got != want {
+			t.Errorf("test(%d): got %q, want %q", i, got, want)
+		}
+		if b, err := io.ReadAll(recr.Body); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", i, err)
+		} else if !bytes.Equal(b, tile) {
+			t.Errorf("test(%d): got %x, want %x", i, b, tile)
+		}
+	}
+	if got, want := upstreamRequests, 1; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
+
+func TestGoproxyServeSUMDBTransports(t *testing.T) {
+	var sumdbServers []*httptest.Server
+	for _, latest := range []string{"foo", "bar"} {
+		latest := latest
+		sumdbServer := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			responseSuccess(rw, req, strings.NewReader(latest), "text/plain; charset=utf-8", -2)
+		}))
+		defer sumdbServer.Close()
+		sumdbServers = append(sumdbServers, sumdbServer)
+	}
+	g := &Goproxy{
+		ProxiedSUMDBs: []string{
+			"foo.example.com " + sumdbServers[0].URL,
+			"bar.example.com " + sumdbServers[1].URL,
+			"baz.example.com " + sumdbServers[1].URL,
+		},
+		SUMDBTransports: map[string]http.RoundTripper{
+			"foo.example.com": sumdbServers[0].Client().Transport,
+			"bar.example.com": sumdbServers[1].Client().Transport,
+		},
+		FetchRetries: -1,
+		ErrorLogger:  log.New(io.Discard, "", 0),
+	}
+	for _, tt := range []struct {
+		n              int
+		path           string
+		wantStatusCode int
+		wantContent    string
+	}{
+		{1, "/sumdb/foo.example.com/latest", http.StatusOK, "foo"},
+		{2, "/sumdb/bar.example.com/latest", http.StatusOK, "bar"},
+		{3, "/sumdb/baz.example.com/latest", http.StatusInternalServerError, "internal server error"},
+	} {
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if got, want := rec.Code, tt.wantStatusCode; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if got, want := rec.Body.String(), tt.wantContent; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+}
+
+func TestGoproxySUMDBClientTransport(t *testing.T) {
+	_, vkey, err := note.GenerateKey(nil, "sumdb.example.com")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	var lookups []string
+	sumdbServer := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		lookups = append(lookups, req.URL.Path)
+		responseNotFound(rw, req, -2)
+	}))
+	defer sumdbServer.Close()
+	g := &Goproxy{
+		Env: []string{"GOPROXY=direct", "GOSUMDB=" + vkey + " " + sumdbServer.URL},
+		SUMDBTransports: map[string]http.RoundTripper{
+			"sumdb.example.com": sumdbServer.Client().Transport,
+		},
+		FetchRetries: -1,
+	}
+	g.init()
+	if _, err := g.sumdbClient.Lookup("example.com", "v1.0.0"); err == nil {
+		t.Fatal("expected error")
+	} else if got, want := err.Error(), "not found"; !strings.Contains(got, want) {
+		t.Errorf("got %q, want containing %q", got, want)
+	}
+	if got, want := strings.Join(lookups, ","), "/lookup/example.com@v1.0.0"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
 type errorCacher struct{}
 
 func (errorCacher) Get(context.Context, string) (io.ReadCloser, error) {
@@ -757,6 +2727,256 @@ func TestGoproxyServeCache(t *testing.T) {
 	}
 }
 
+func TestGoproxyServeCacheStreaming(t *testing.T) {
+	const (
+		zipSize      = 64 << 20
+		maxReadAhead = 1 << 20
+		zipCacheName = "example.com/@v/v1.0.0.zip"
+	)
+	dc := DirCacher(t.TempDir())
+	zipFile := filepath.Join(string(dc), filepath.FromSlash(zipCacheName))
+	if err := os.MkdirAll(filepath.Dir(zipFile), 0o755); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if err := os.WriteFile(zipFile, bytes.Repeat([]byte{'x'}, zipSize), 0o644); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	rw := &countingResponseWriter{header: http.Header{}}
+	g := &Goproxy{Cacher: &streamingCheckCacher{Cacher: dc, rw: rw, maxReadAhead: maxReadAhead}}
+	g.init()
+
+	g.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/"+zipCacheName, nil))
+	if got, want := rw.statusCode, http.StatusOK; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+	if got, want := rw.header.Get("Content-Length"), strconv.Itoa(zipSize); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := rw.written, int64(zipSize); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/example.com/@v/v1.0.0.zip", nil)
+	req.Header.Set("Range", "bytes=100-199")
+	g.ServeHTTP(rec, req)
+	recr := rec.Result()
+	if got, want := recr.StatusCode, http.StatusPartialContent; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+	if got, want := recr.Header.Get("Content-Range"), fmt.Sprintf("bytes 100-199/%d", zipSize); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := rec.Body.Len(), 100; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
+
+func TestGoproxyServeRange(t *testing.T) {
+	proxyServer, setProxyHandler := newHTTPTestServer()
+	defer proxyServer.Close()
+	zipFile := filepath.Join(t.TempDir(), "zip")
+	if err := writeZipFile(zipFile, map[string][]byte{"example.com@v1.0.0/go.mod": bytes.Repeat([]byte("x"), 1000)}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	zipContent, err := os.ReadFile(zipFile)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	zipHash, err := dirhash.HashZip(zipFile, dirhash.DefaultHash)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	setProxyHandler(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/example.com/@v/v1.0.0.zip" {
+			responseNotFound(rw, req, -2)
+			return
+		}
+		http.ServeFile(rw, req, zipFile)
+	})
+	for _, cacher := range []Cacher{DirCacher(t.TempDir()), &MemoryCacher{}} {
+		g := &Goproxy{
+			Env:         []string{"GOPROXY=" + proxyServer.URL, "GOSUMDB=off"},
+			Cacher:      cacher,
+			TempDir:     t.TempDir(),
+			ErrorLogger: log.New(io.Discard, "", 0),
+		}
+		for _, tt := range []struct {
+			n                int
+			rangeHeader      string
+			ifRange          string
+			wantStatusCode   int
+			wantContentRange string
+			wantContent      string
+		}{
+			{1, "bytes=10-19", "", http.StatusPartialContent, fmt.Sprintf("bytes 10-19/%d", len(zipContent)), string(zipContent[10:20])},
+			{2, "bytes=10-19", "", http.StatusPartialContent, fmt.Sprintf("bytes 10-19/%d", len(zipContent)), string(zipContent[10:20])},
+			{3, "bytes=-5", "", http.StatusPartialContent, fmt.Sprintf("bytes %d-%d/%d", len(zipContent)-5, len(zipContent)-1, len(zipContent)), string(zipContent[len(zipContent)-5:])},
+			{4, fmt.Sprintf("bytes=%d-", len(zipContent)), "", http.StatusRequestedRangeNotSatisfiable, fmt.Sprintf("bytes */%d", len(zipContent)), ""},
+			{5, "bytes=10-19", strconv.Quote(zipHash), http.StatusPartialContent, fmt.Sprintf("bytes 10-19/%d", len(zipContent)), string(zipContent[10:20])},
+			{6, "bytes=10-19", `"h1:foobar"`, http.StatusOK, "", string(zipContent)},
+		} {
+			req := httptest.NewRequest(http.MethodGet, "/example.com/@v/v1.0.0.zip", nil)
+			req.Header.Set("Range", tt.rangeHeader)
+			if tt.ifRange != "" {
+				req.Header.Set("If-Range", tt.ifRange)
+			}
+			rec := httptest.NewRecorder()
+			g.ServeHTTP(rec, req)
+			recr := rec.Result()
+			if got, want := recr.StatusCode, tt.wantStatusCode; got != want {
+				t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+			}
+			if tt.wantStatusCode != http.StatusRequestedRangeNotSatisfiable {
+				if got, want := recr.Header.Get("Accept-Ranges"), "bytes"; got != want {
+					t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+				}
+			}
+			if got, want := recr.Header.Get("Content-Range"), tt.wantContentRange; got != want {
+				t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+			}
+			if tt.wantContent != "" {
+				if got, want := rec.Body.String(), tt.wantContent; got != want {
+					t.Errorf("test(%d): got %d bytes, want %d bytes", tt.n, len(got), len(want))
+				}
+			}
+		}
+	}
+}
+
+func TestGoproxyServeHEAD(t *testing.T) {
+	proxyServer, setProxyHandler := newHTTPTestServer()
+	defer proxyServer.Close()
+	info := marshalInfo("v1.0.0", time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+	zipFile := filepath.Join(t.TempDir(), "zip")
+	if err := writeZipFile(zipFile, map[string][]byte{"example.com@v1.0.0/go.mod": []byte("module example.com")}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	zipContent, err := os.ReadFile(zipFile)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	zipHash, err := dirhash.HashZip(zipFile, dirhash.DefaultHash)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	setProxyHandler(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/example.com/@v/list":
+			responseSuccess(rw, req, strings.NewReader("v1.0.0"), "text/plain; charset=utf-8", -2)
+		case "/example.com/@v/v1.0.0.info":
+			responseSuccess(rw, req, strings.NewReader(info), "application/json; charset=utf-8", -2)
+		case "/example.com/@v/v1.0.0.zip":
+			http.ServeFile(rw, req, zipFile)
+		default:
+			responseNotFound(rw, req, -2)
+		}
+	})
+	for _, cacher := range []Cacher{DirCacher(t.TempDir()), &MemoryCacher{}} {
+		g := &Goproxy{
+			Env:         []string{"GOPROXY=" + proxyServer.URL, "GOSUMDB=off"},
+			Cacher:      cacher,
+			TempDir:     t.TempDir(),
+			ErrorLogger: log.New(io.Discard, "", 0),
+		}
+		for _, tt := range []struct {
+			n                 int
+			name              string
+			wantStatusCode    int
+			wantContentType   string
+			wantContentLength string
+			wantETag          string
+			wantLastModified  bool
+		}{
+			{1, "example.com/@v/v1.0.0.zip", http.StatusOK, "application/zip", strconv.Itoa(len(zipContent)), strconv.Quote(zipHash), false},
+			{2, "example.com/@v/v1.0.0.zip", http.StatusOK, "application/zip", strconv.Itoa(len(zipContent)), strconv.Quote(zipHash), true},
+			{3, "example.com/@v/v1.0.0.info", http.StatusOK, "application/json; charset=utf-8", strconv.Itoa(len(info)), "", true},
+			{4, "example.com/@v/v1.0.0.info", http.StatusOK, "application/json; charset=utf-8", strconv.Itoa(len(info)), "", true},
+			{5, "example.com/@v/list", http.StatusOK, "text/plain; charset=utf-8", "6", "", false},
+			{6, "example.com/@v/v1.1.0.info", http.StatusNotFound, "text/plain; charset=utf-8", "", "", false},
+		} {
+			rec := httptest.NewRecorder()
+			g.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, "/"+tt.name, nil))
+			recr := rec.Result()
+			if got, want := recr.StatusCode, tt.wantStatusCode; got != want {
+				t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+			}
+			if got, want := recr.Header.Get("Content-Type"), tt.wantContentType; got != want {
+				t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+			}
+			if got, want := recr.Header.Get("Content-Length"), tt.wantContentLength; got != want {
+				t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+			}
+			if got, want := recr.Header.Get("ETag"), tt.wantETag; got != want {
+				t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+			}
+			if got, want := recr.Header.Get("Last-Modified") != "", tt.wantLastModified; got != want {
+				t.Errorf("test(%d): got %v, want %v", tt.n, got, want)
+			}
+			if got := rec.Body.Len(); got != 0 {
+				t.Errorf("test(%d): got %d bytes, want none", tt.n, got)
+			}
+		}
+	}
+}
+
+// streamingCheckCacher is a [Cacher] whose contents fail to be read past the
+// maxReadAhead bytes before anything is written to the rw, so that a content
+// read into memory as a whole before being served is caught.
+type streamingCheckCacher struct {
+	Cacher
+	rw           *countingResponseWriter
+	maxReadAhead int64
+}
+
+func (scc *streamingCheckCacher) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	rc, err := scc.Cacher.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	rsc, ok := rc.(io.ReadSeekCloser)
+	if !ok {
+		rc.Close()
+		return nil, errors.New("content is not seekable")
+	}
+	return &streamingCheckContent{ReadSeekCloser: rsc, scc: scc}, nil
+}
+
+type streamingCheckContent struct {
+	io.ReadSeekCloser
+	scc  *streamingCheckCacher
+	read int64
+}
+
+func (scc *streamingCheckContent) Read(b []byte) (int, error) {
+	n, err := scc.ReadSeekCloser.Read(b)
+	scc.read += int64(n)
+	if scc.scc.rw.written == 0 && scc.read > scc.scc.maxReadAhead {
+		return n, fmt.Errorf("read past %d bytes before the first write", scc.scc.maxReadAhead)
+	}
+	return n, err
+}
+
+type countingResponseWriter struct {
+	header     http.Header
+	statusCode int
+	written    int64
+}
+
+func (crw *countingResponseWriter) Header() http.Header { return crw.header }
+
+func (crw *countingResponseWriter) WriteHeader(statusCode int) {
+	if crw.statusCode == 0 {
+		crw.statusCode = statusCode
+	}
+}
+
+func (crw *countingResponseWriter) Write(b []byte) (int, error) {
+	crw.WriteHeader(http.StatusOK)
+	crw.written += int64(len(b))
+	return len(b), nil
+}
+
 func TestGoproxyCache(t *testing.T) {
 	dc := DirCacher(t.TempDir())
 	g := &Goproxy{Cacher: dc}
@@ -833,6 +3053,80 @@ func TestGoproxyPutCacheFile(t *testing.T) {
 	}
 }
 
+func TestGoproxyPutFetchDownloadCachesWebhook(t *testing.T) {
+	webhookServer, setWebhookHandler := newHTTPTestServer()
+	defer webhookServer.Close()
+	var (
+		events      []webhookEvent
+		eventsMutex sync.Mutex
+	)
+	setWebhookHandler(func(rw http.ResponseWriter, req *http.Request) {
+		var event webhookEvent
+		if err := json.NewDecoder(req.Body).Decode(&event); err != nil {
+			t.Errorf("unexpected error %q", err)
+		}
+		eventsMutex.Lock()
+		events = append(events, event)
+		eventsMutex.Unlock()
+	})
+
+	g := &Goproxy{Cacher: &MemoryCacher{}, WebhookURL: webhookServer.URL}
+	g.init()
+	f, err := newFetch(g, "example.com/@v/v1.0.0.zip", t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	zipFile := filepath.Join(t.TempDir(), "zip")
+	if err := writeZipFile(zipFile, map[string][]byte{"example.com@v1.0.0/go.mod": []byte("module example.com")}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	zipInfo, err := os.Stat(zipFile)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := g.putFetchDownloadCaches(context.Background(), "example.com/@v/v1.0.0", &fetchResult{f: f, Zip: zipFile, Sum: "h1:foo"}, nil); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+	g.webhook.deliveries.Wait()
+	if got, want := len(events), 1; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+	if got, want := events[0].ModulePath, "example.com"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := events[0].Version, "v1.0.0"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := events[0].Size, zipInfo.Size(); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
+
+func TestGoproxyResponseStorageError(t *testing.T) {
+	for _, tt := range []struct {
+		n              int
+		err            error
+		wantStatusCode int
+		wantLog        string
+	}{
+		{1, &fs.PathError{Op: "write", Path: "foo", Err: syscall.ENOSPC}, http.StatusInsufficientStorage, "goproxy: failed to cache module file: bar: disk full, free up space in the TempDir or Cacher: write foo: no space left on device\n"},
+		{2, errors.New("foobar"), http.StatusInternalServerError, "goproxy: failed to cache module file: bar: foobar\n"},
+	} {
+		var errorLoggerBuffer bytes.Buffer
+		g := &Goproxy{ErrorLogger: log.New(&errorLoggerBuffer, "", 0)}
+		rec := httptest.NewRecorder()
+		g.responseStorageError(rec, httptest.NewRequest("", "/", nil), tt.err, "failed to cache module file: %s", "bar")
+		if got, want := rec.Code, tt.wantStatusCode; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if got, want := errorLoggerBuffer.String(), tt.wantLog; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+}
+
 func TestGoproxyLogErrorf(t *testing.T) {
 	for _, tt := range []struct {
 		n           int
@@ -0,0 +1,126 @@
+package goproxy
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/mod/semver"
+)
+
+// defaultMinGoVersion is the default value of [Goproxy.MinGoVersion].
+const defaultMinGoVersion = "go1.11"
+
+// GoVersion returns the version (e.g., "go1.21.0") of the Go binary targeted
+// by the g.GoBinName, as reported by "go version". It returns an error if the
+// version cannot be detected or is older than the g.MinGoVersion, so it is
+// best called once at startup to fail fast rather than at request time. The
+// detected version is remembered after the first successful detection.
+func (g *Goproxy) GoVersion(ctx context.Context) (string, error) {
+	g.initOnce.Do(g.init)
+
+	minGoVersion := g.MinGoVersion
+	if minGoVersion == "" {
+		minGoVersion = defaultMinGoVersion
+	}
+	minVersion := goVersionSemver(minGoVersion)
+	if minVersion == "" {
+		return "", fmt.Errorf("invalid MinGoVersion %q", minGoVersion)
+	}
+
+	goVersion, err := g.detectGoVersion(ctx)
+	if err != nil {
+		return "", err
+	}
+	if semver.Compare(goVersionSemver(goVersion), minVersion) < 0 {
+		return goVersion, fmt.Errorf("go binary %q is %s, older than the minimum %s", g.goBinName, goVersion, minGoVersion)
+	}
+	return goVersion, nil
+}
+
+// detectGoVersion returns the version of the Go binary targeted by the
+// g.GoBinName, running "go version" unless it has already been detected.
+func (g *Goproxy) detectGoVersion(ctx context.Context) (string, error) {
+	g.goVersionMutex.Lock()
+	goVersion := g.goVersion
+	g.goVersionMutex.Unlock()
+	if goVersion != "" {
+		return goVersion, nil
+	}
+
+	cmd := exec.CommandContext(ctx, g.goBinName, "version")
+	cmd.Env = g.env
+	stdout, err := cmd.Output()
+	if err != nil {
+		return "", goCommandError(stdout, err)
+	}
+	if goVersion = parseGoVersionOutput(string(stdout)); goVersion == "" {
+		return "", fmt.Errorf("unexpected output of command %v: %q", cmd.Args, strings.TrimSpace(string(stdout)))
+	}
+	g.goVersionMutex.Lock()
+	g.goVersion = goVersion
+	g.goVersionMutex.Unlock()
+	return goVersion, nil
+}
+
+// goVersionAtLeast reports whether the version of the Go binary targeted by the
+// g.GoBinName is known to be at least the goVersion. Unless [Goproxy.GoVersion]
+// has already detected the version, it is detected the first time it is
+// needed, and only then, so no command is added to later fetches. If the
+// detection fails, the version is treated as unknown from then on.
+func (g *Goproxy) goVersionAtLeast(goVersion string) bool {
+	g.goVersionOnce.Do(func() {
+		g.detectGoVersion(context.Background())
+	})
+	g.goVersionMutex.Lock()
+	v := g.goVersion
+	g.goVersionMutex.Unlock()
+	return v != "" && semver.Compare(goVersionSemver(v), goVersionSemver(goVersion)) >= 0
+}
+
+// parseGoVersionOutput parses the output of "go version" (e.g., "go version
+// go1.21.0 linux/amd64") and returns the Go version in it. It returns an empty
+// string if the output is not recognized. For development builds, the version
+// of the release they are based on is returned.
+func parseGoVersionOutput(output string) string {
+	fields := strings.Fields(output)
+	if len(fields) < 3 || fields[0] != "go" || fields[1] != "version" {
+		return ""
+	}
+	goVersion := fields[2]
+	if goVersion == "devel" && len(fields) > 3 {
+		goVersion, _, _ = strings.Cut(fields[3], "-")
+	}
+	if goVersionSemver(goVersion) == "" {
+		return ""
+	}
+	return goVersion
+}
+
+// goVersionSemver returns the semantic version equivalent to the Go version
+// goVersion (e.g., "v1.21.0-rc.1" for "go1.21rc1"), which can be compared with
+// [semver.Compare]. Any suffix after a "-", as in custom toolchain names like
+// "go1.21.0-bigcorp", is ignored. It returns an empty string if the goVersion
+// is invalid.
+func goVersionSemver(goVersion string) string {
+	v, ok := strings.CutPrefix(goVersion, "go")
+	if !ok {
+		return ""
+	}
+	v, _, _ = strings.Cut(v, "-")
+	var prerelease string
+	for _, kind := range []string{"rc", "beta"} {
+		if i := strings.Index(v, kind); i >= 0 {
+			v, prerelease = v[:i], "-"+kind+"."+v[i+len(kind):]
+			break
+		}
+	}
+	if strings.Count(v, ".") == 1 {
+		v += ".0"
+	}
+	if v = "v" + v + prerelease; !semver.IsValid(v) {
+		return ""
+	}
+	return v
+}
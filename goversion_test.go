@@ -0,0 +1,135 @@
+package goproxy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestGoproxyGoVersion(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping test that requires a shell script as the go binary")
+	}
+	goBin := filepath.Join(t.TempDir(), "go")
+	for _, tt := range []struct {
+		n             int
+		output        string
+		minGoVersion  string
+		wantGoVersion string
+		wantErr       string
+	}{
+		{1, "go version go1.21.0 linux/amd64", "", "go1.21.0", ""},
+		{2, "go version go1.21.0 linux/amd64", "go1.21", "go1.21.0", ""},
+		{3, "go version go1.21rc2 linux/amd64", "go1.21", "go1.21rc2", `go binary "` + goBin + `" is go1.21rc2, older than the minimum go1.21`},
+		{4, "go version go1.20.5 linux/amd64", "go1.21.0", "go1.20.5", `go binary "` + goBin + `" is go1.20.5, older than the minimum go1.21.0`},
+		{5, "go version go1.21.0 linux/amd64", "1.21", "", `invalid MinGoVersion "1.21"`},
+		{6, "foobar", "", "", "unexpected output of command [" + goBin + ` version]: "foobar"`},
+	} {
+		if err := os.WriteFile(goBin, []byte("#!/bin/sh\necho '"+tt.output+"'\n"), 0o755); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		}
+		g := &Goproxy{GoBinName: goBin, MinGoVersion: tt.minGoVersion}
+		goVersion, err := g.GoVersion(context.Background())
+		if tt.wantErr != "" {
+			if err == nil {
+				t.Fatalf("test(%d): expected error", tt.n)
+			}
+			if got, want := err.Error(), tt.wantErr; got != want {
+				t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+			}
+		} else if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		}
+		if got, want := goVersion, tt.wantGoVersion; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+
+	g := &Goproxy{GoBinName: filepath.Join(t.TempDir(), "go")}
+	if _, err := g.GoVersion(context.Background()); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestGoproxyGoVersionAtLeast(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping test that requires a shell script as the go binary")
+	}
+	countFile := filepath.Join(t.TempDir(), "count")
+	goBin := filepath.Join(t.TempDir(), "go")
+	if err := os.WriteFile(goBin, []byte("#!/bin/sh\necho x >> \"$COUNT_FILE\"\necho 'go version go1.21.0 linux/amd64'\n"), 0o755); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	g := &Goproxy{Env: []string{"COUNT_FILE=" + countFile}, GoBinName: goBin}
+	g.init()
+	for _, tt := range []struct {
+		n         int
+		goVersion string
+		want      bool
+	}{
+		{1, "go1.16", true},
+		{2, "go1.21.0", true},
+		{3, "go1.22", false},
+	} {
+		if got, want := g.goVersionAtLeast(tt.goVersion), tt.want; got != want {
+			t.Errorf("test(%d): got %t, want %t", tt.n, got, want)
+		}
+	}
+	if b, err := os.ReadFile(countFile); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := strings.Count(string(b), "x"), 1; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	g = &Goproxy{GoBinName: filepath.Join(t.TempDir(), "go")}
+	g.init()
+	if g.goVersionAtLeast("go1.11") {
+		t.Error("want false for undetectable version")
+	}
+}
+
+func TestParseGoVersionOutput(t *testing.T) {
+	for _, tt := range []struct {
+		n      int
+		output string
+		want   string
+	}{
+		{1, "go version go1.21.0 linux/amd64\n", "go1.21.0"},
+		{2, "go version go1.11 darwin/amd64", "go1.11"},
+		{3, "go version go1.22rc1 linux/arm64", "go1.22rc1"},
+		{4, "go version devel go1.23-e8ee1dc4f9 Thu Jan 1 00:00:00 2024 +0000 linux/amd64", "go1.23"},
+		{5, "go version go1.21.0-bigcorp linux/amd64", "go1.21.0-bigcorp"},
+		{6, "go version", ""},
+		{7, "gccgo version 1.21", ""},
+		{8, "go version foobar linux/amd64", ""},
+	} {
+		if got, want := parseGoVersionOutput(tt.output), tt.want; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+}
+
+func TestGoVersionSemver(t *testing.T) {
+	for _, tt := range []struct {
+		n         int
+		goVersion string
+		want      string
+	}{
+		{1, "go1.21.0", "v1.21.0"},
+		{2, "go1.21", "v1.21.0"},
+		{3, "go1.21rc1", "v1.21.0-rc.1"},
+		{4, "go1.18beta2", "v1.18.0-beta.2"},
+		{5, "go1.21.0-bigcorp", "v1.21.0"},
+		{6, "go1", "v1"},
+		{7, "1.21.0", ""},
+		{8, "go1.21rc", ""},
+		{9, "gofoo", ""},
+	} {
+		if got, want := goVersionSemver(tt.goVersion), tt.want; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+}
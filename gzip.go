@@ -0,0 +1,133 @@
+package goproxy
+
+import (
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// gzipResponseWriter is an [http.ResponseWriter] that gzip-compresses
+// successful text responses of known sizes of at least minSize bytes for
+// clients that accept "gzip". Other responses are written through as is.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	req         *http.Request
+	minSize     int64
+	acceptsGzip bool
+	wroteHeader bool
+	gw          *gzip.Writer
+}
+
+// newGzipResponseWriter returns a new [gzipResponseWriter] for the req.
+func newGzipResponseWriter(rw http.ResponseWriter, req *http.Request, minSize int64) *gzipResponseWriter {
+	return &gzipResponseWriter{
+		ResponseWriter: rw,
+		req:            req,
+		minSize:        minSize,
+		acceptsGzip:    acceptsGzip(req),
+	}
+}
+
+// WriteHeader implements [http.ResponseWriter].
+func (grw *gzipResponseWriter) WriteHeader(statusCode int) {
+	if grw.wroteHeader {
+		grw.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+	grw.wroteHeader = true
+
+	header := grw.Header()
+	switch statusCode {
+	case http.StatusOK:
+		if header.Get("Content-Encoding") != "" || !isCompressibleContentType(header.Get("Content-Type")) {
+			break
+		}
+		header.Add("Vary", "Accept-Encoding")
+		size, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+		if !grw.acceptsGzip || err != nil || size < grw.minSize {
+			break
+		}
+		header.Del("Content-Length")
+		header.Del("Accept-Ranges")
+		header.Set("Content-Encoding", "gzip")
+		setWeakETag(header)
+		if grw.req.Method != http.MethodHead {
+			grw.gw = gzip.NewWriter(grw.ResponseWriter)
+		}
+	case http.StatusNotModified:
+		// The client may have validated a compressed response, whose ETag
+		// is weak, so the ETag is kept weak as well.
+		if grw.acceptsGzip && strings.Contains(grw.req.Header.Get("If-None-Match"), "W/") {
+			setWeakETag(header)
+		}
+	}
+	grw.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write implements [http.ResponseWriter].
+func (grw *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !grw.wroteHeader {
+		grw.WriteHeader(http.StatusOK)
+	}
+	if grw.gw != nil {
+		return grw.gw.Write(b)
+	}
+	return grw.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the underlying [http.ResponseWriter] for use with
+// [http.ResponseController].
+func (grw *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return grw.ResponseWriter
+}
+
+// close flushes the compressed response, if any. It must be called once the
+// response is written.
+func (grw *gzipResponseWriter) close() error {
+	if grw.gw == nil {
+		return nil
+	}
+	return grw.gw.Close()
+}
+
+// acceptsGzip reports whether the Accept-Encoding header of the req lists
+// "gzip" or "*" with a nonzero quality value.
+func acceptsGzip(req *http.Request) bool {
+	for _, acceptEncoding := range req.Header.Values("Accept-Encoding") {
+		for _, coding := range strings.Split(acceptEncoding, ",") {
+			coding, params, _ := strings.Cut(coding, ";")
+			coding = strings.TrimSpace(coding)
+			if !strings.EqualFold(coding, "gzip") && coding != "*" {
+				continue
+			}
+			if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				if v, err := strconv.ParseFloat(q, 64); err != nil || v == 0 {
+					continue
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// isCompressibleContentType reports whether the contentType is a text one, or
+// JSON, which compresses well.
+func isCompressibleContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") || mediaType == "application/json"
+}
+
+// setWeakETag turns the ETag header in the header, if any, into a weak one, as
+// the compressed and uncompressed representations of a response are not
+// byte-identical.
+func setWeakETag(header http.Header) {
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		header.Set("ETag", "W/"+etag)
+	}
+}
@@ -0,0 +1,153 @@
+package goproxy
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	for _, tt := range []struct {
+		n              int
+		acceptEncoding string
+		want           bool
+	}{
+		{1, "", false},
+		{2, "gzip", true},
+		{3, "br, GZIP", true},
+		{4, "deflate", false},
+		{5, "gzip;q=0", false},
+		{6, "gzip; q=0.5", true},
+		{7, "*", true},
+		{8, "identity, *;q=0", false},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		}
+		if got, want := acceptsGzip(req), tt.want; got != want {
+			t.Errorf("test(%d): got %t, want %t", tt.n, got, want)
+		}
+	}
+}
+
+func TestIsCompressibleContentType(t *testing.T) {
+	for _, tt := range []struct {
+		n           int
+		contentType string
+		want        bool
+	}{
+		{1, "text/plain; charset=utf-8", true},
+		{2, "application/json", true},
+		{3, "application/zip", false},
+		{4, "application/octet-stream", false},
+		{5, "", false},
+	} {
+		if got, want := isCompressibleContentType(tt.contentType), tt.want; got != want {
+			t.Errorf("test(%d): got %t, want %t", tt.n, got, want)
+		}
+	}
+}
+
+func TestGoproxyServeHTTPGzip(t *testing.T) {
+	modFile := "module example.com\n\n" + strings.Repeat("// padding\n", 100)
+	cacher := &MemoryCacher{}
+	for name, content := range map[string]string{
+		"example.com/@v/v1.0.0.info": `{"Version":"v1.0.0"}`,
+		"example.com/@v/v1.0.0.mod":  modFile,
+		"example.com/@v/v1.0.0.zip":  strings.Repeat("z", 1000),
+	} {
+		if err := cacher.Put(context.Background(), name, strings.NewReader(content)); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+	g := &Goproxy{Cacher: cacher, Offline: true, GzipMinSize: 100}
+	for _, tt := range []struct {
+		n                   int
+		method              string
+		path                string
+		acceptEncoding      string
+		rangeHeader         string
+		wantStatusCode      int
+		wantContentEncoding string
+		wantVary            bool
+		wantContent         string
+	}{
+		{1, http.MethodGet, "/example.com/@v/v1.0.0.mod", "gzip", "", http.StatusOK, "gzip", true, modFile},
+		{2, http.MethodGet, "/example.com/@v/v1.0.0.mod", "", "", http.StatusOK, "", true, modFile},
+		{3, http.MethodHead, "/example.com/@v/v1.0.0.mod", "gzip", "", http.StatusOK, "gzip", true, ""},
+		{4, http.MethodGet, "/example.com/@v/v1.0.0.mod", "gzip", "bytes=0-5", http.StatusPartialContent, "", false, "module"},
+		{5, http.MethodGet, "/example.com/@v/v1.0.0.info", "gzip", "", http.StatusOK, "", true, `{"Version":"v1.0.0"}`},
+		{6, http.MethodGet, "/example.com/@v/v1.0.0.zip", "gzip", "", http.StatusOK, "", false, strings.Repeat("z", 1000)},
+	} {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		}
+		if tt.rangeHeader != "" {
+			req.Header.Set("Range", tt.rangeHeader)
+		}
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, req)
+		recr := rec.Result()
+		if got, want := recr.StatusCode, tt.wantStatusCode; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if got, want := recr.Header.Get("Content-Encoding"), tt.wantContentEncoding; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		if got, want := strings.Contains(recr.Header.Get("Vary"), "Accept-Encoding"), tt.wantVary; got != want {
+			t.Errorf("test(%d): got %t, want %t", tt.n, got, want)
+		}
+		if tt.wantContentEncoding == "" {
+			if got := recr.Header.Get("ETag"); strings.HasPrefix(got, "W/") {
+				t.Errorf("test(%d): unexpected weak ETag %q", tt.n, got)
+			}
+		} else {
+			if got := recr.Header.Get("Content-Length"); got != "" {
+				t.Errorf("test(%d): unexpected Content-Length %q", tt.n, got)
+			}
+			if got := recr.Header.Get("ETag"); !strings.HasPrefix(got, "W/") {
+				t.Errorf("test(%d): expected weak ETag, got %q", tt.n, got)
+			}
+		}
+		if tt.method == http.MethodHead {
+			continue
+		}
+		body := io.Reader(recr.Body)
+		if tt.wantContentEncoding == "gzip" {
+			gr, err := gzip.NewReader(recr.Body)
+			if err != nil {
+				t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+			}
+			body = gr
+		}
+		if b, err := io.ReadAll(body); err != nil {
+			t.Errorf("test(%d): unexpected error %q", tt.n, err)
+		} else if got, want := string(b), tt.wantContent; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/example.com/@v/v1.0.0.mod", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, req)
+	etag := rec.Result().Header.Get("ETag")
+	req = httptest.NewRequest(http.MethodGet, "/example.com/@v/v1.0.0.mod", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	g.ServeHTTP(rec, req)
+	recr := rec.Result()
+	if got, want := recr.StatusCode, http.StatusNotModified; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+	if got, want := recr.Header.Get("ETag"), etag; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,183 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// readinessCacheTTL is how long the result of a readiness check is
+	// reused by [Goproxy.ReadinessHandler].
+	readinessCacheTTL = 5 * time.Second
+
+	// readinessUpstreamTimeout is the maximum amount of time a readiness
+	// check waits for each of the [Goproxy.ReadinessUpstreams].
+	readinessUpstreamTimeout = 3 * time.Second
+
+	// readinessCheckTimeout is the maximum amount of time a readiness check
+	// takes as a whole.
+	readinessCheckTimeout = 10 * time.Second
+
+	// maintenanceRetryAfter is the Retry-After of the requests rejected
+	// while a [Goproxy] is in maintenance mode.
+	maintenanceRetryAfter = time.Minute
+)
+
+// SetMaintenance puts the g into maintenance mode if on is true, or takes it
+// out of maintenance mode otherwise. In maintenance mode, the g responds to
+// every module and checksum database request with a 503 status code and a
+// Retry-After header, while requests already in flight are allowed to finish.
+// The [Goproxy.HealthHandler] keeps responding "ok", so the process is not
+// restarted, but the [Goproxy.ReadinessHandler] responds "not ready", so load
+// balancers drain traffic from the g.
+//
+// SetMaintenance is safe for concurrent use, including while the g is serving
+// requests. See also the "/maintenance" endpoint of [Goproxy.AdminHandler].
+func (g *Goproxy) SetMaintenance(on bool) {
+	g.maintenance.Store(on)
+}
+
+// Maintenance reports whether the g is in maintenance mode. See
+// [Goproxy.SetMaintenance].
+func (g *Goproxy) Maintenance() bool {
+	return g.maintenance.Load()
+}
+
+// HealthHandler returns an [http.Handler] that serves liveness checks for the
+// g. It always responds "ok" with a 200 status code and never fetches anything,
+// unless the request fails authentication (see
+// [Goproxy.AuthenticateHealthAndMetrics]).
+func (g *Goproxy) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet, http.MethodHead:
+		default:
+			responseMethodNotAllowed(rw, req, -2)
+			return
+		}
+		if g.AuthenticateHealthAndMetrics {
+			if _, ok := g.authenticate(rw, req); !ok {
+				return
+			}
+		}
+		responseString(rw, req, http.StatusOK, -1, "ok")
+	})
+}
+
+// ReadinessHandler returns an [http.Handler] that serves readiness checks for
+// the g. It responds "ok" with a 200 status code if the g is not in maintenance
+// mode, the g.TempDir is writable, the g.Cacher is healthy, and all of the
+// g.ReadinessUpstreams are reachable. Otherwise, it responds with a 503 status
+// code and the reason.
+//
+// The g.Cacher is considered healthy unless it implements
+// interface{ CheckHealth(context.Context) error } and CheckHealth returns
+// an error.
+//
+// The result of a check is reused for a few seconds, so frequent probes do not
+// put load on the upstreams.
+func (g *Goproxy) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet, http.MethodHead:
+		default:
+			responseMethodNotAllowed(rw, req, -2)
+			return
+		}
+		if g.AuthenticateHealthAndMetrics {
+			if _, ok := g.authenticate(rw, req); !ok {
+				return
+			}
+		}
+		g.initOnce.Do(g.init)
+		if g.Maintenance() {
+			responseString(rw, req, http.StatusServiceUnavailable, -1, "not ready: in maintenance")
+			return
+		}
+		if err := g.readiness.check(g.checkReadiness); err != nil {
+			responseString(rw, req, http.StatusServiceUnavailable, -1, fmt.Sprintf("not ready: %v", err))
+			return
+		}
+		responseString(rw, req, http.StatusOK, -1, "ok")
+	})
+}
+
+// checkReadiness checks whether the g is ready to serve requests.
+func (g *Goproxy) checkReadiness(ctx context.Context) error {
+	tempDir, err := os.MkdirTemp(g.TempDir, "goproxy.tmp.*")
+	if err != nil {
+		return fmt.Errorf("temporary directory is not writable: %w", err)
+	}
+	os.RemoveAll(tempDir)
+
+	if hc, ok := g.Cacher.(interface{ CheckHealth(context.Context) error }); ok {
+		if err := hc.CheckHealth(ctx); err != nil {
+			return fmt.Errorf("cacher is unhealthy: %w", err)
+		}
+	}
+
+	for _, upstream := range g.ReadinessUpstreams {
+		if err := g.checkUpstreamReachability(ctx, upstream); err != nil {
+			return fmt.Errorf("upstream is unreachable: %w", err)
+		}
+	}
+	return nil
+}
+
+// checkUpstreamReachability checks whether the upstream URL is reachable. It
+// is considered reachable if it responds with a status code less than 500.
+func (g *Goproxy) checkUpstreamReachability(ctx context.Context, upstream string) error {
+	ctx, cancel := context.WithTimeout(ctx, readinessUpstreamTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, upstream, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("GET %s: %s", resp.Request.URL.Redacted(), resp.Status)
+	}
+	return nil
+}
+
+// readiness caches the result of readiness checks. The zero value is ready to
+// use.
+type readiness struct {
+	mutex     sync.Mutex
+	checkedAt time.Time
+	err       error
+}
+
+// check returns the cached result of the last call to the checkFunc if it is
+// recent enough, and otherwise calls the checkFunc again. The checkFunc is
+// called with a ctx of its own, limited by the [readinessCheckTimeout], rather
+// than that of the probe that triggered it, so that a probe giving up never
+// fails the check for the probes after it. A check failing because its ctx was
+// canceled is never cached.
+func (r *readiness) check(checkFunc func(ctx context.Context) error) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if !r.checkedAt.IsZero() && time.Since(r.checkedAt) < readinessCacheTTL {
+		return r.err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), readinessCheckTimeout)
+	defer cancel()
+	err := checkFunc(ctx)
+	if errors.Is(err, context.Canceled) {
+		return err
+	}
+	r.err = err
+	r.checkedAt = time.Now()
+	return r.err
+}
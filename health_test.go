@@ -0,0 +1,239 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type unhealthyCacher struct {
+	errorCacher
+}
+
+func (unhealthyCacher) CheckHealth(context.Context) error {
+	return errors.New("cannot connect")
+}
+
+func TestGoproxyHealthHandler(t *testing.T) {
+	g := &Goproxy{}
+	for _, tt := range []struct {
+		n              int
+		method         string
+		wantStatusCode int
+		wantContent    string
+	}{
+		{1, http.MethodGet, http.StatusOK, "ok"},
+		{2, http.MethodHead, http.StatusOK, ""},
+		{3, http.MethodPost, http.StatusMethodNotAllowed, "method not allowed"},
+	} {
+		rec := httptest.NewRecorder()
+		g.HealthHandler().ServeHTTP(rec, httptest.NewRequest(tt.method, "/healthz", nil))
+		recr := rec.Result()
+		if got, want := recr.StatusCode, tt.wantStatusCode; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if b, err := io.ReadAll(recr.Body); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		} else if got, want := string(b), tt.wantContent; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+}
+
+func TestGoproxyMaintenance(t *testing.T) {
+	g := &Goproxy{Cacher: &MemoryCacher{}, Offline: true}
+	g.SetMaintenance(true)
+	for _, tt := range []struct {
+		n              int
+		handler        http.Handler
+		path           string
+		wantStatusCode int
+		wantContent    string
+	}{
+		{1, g, "/example.com/@v/list", http.StatusServiceUnavailable, "service unavailable: in maintenance"},
+		{2, g, "/sumdb/sum.golang.org/supported", http.StatusServiceUnavailable, "service unavailable: in maintenance"},
+		{3, g.HealthHandler(), "/healthz", http.StatusOK, "ok"},
+	} {
+		rec := httptest.NewRecorder()
+		tt.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		recr := rec.Result()
+		if got, want := recr.StatusCode, tt.wantStatusCode; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if got, want := recr.Header.Get("Retry-After"), "60"; tt.wantStatusCode != http.StatusOK && got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		if b, err := io.ReadAll(recr.Body); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		} else if got, want := string(b), tt.wantContent; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+
+	g.SetMaintenance(false)
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/example.com/@v/list", nil))
+	if got, want := rec.Result().StatusCode, http.StatusNotFound; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
+
+func TestGoproxyReadinessHandler(t *testing.T) {
+	upstreamServer, setUpstreamHandler := newHTTPTestServer()
+	defer upstreamServer.Close()
+	for _, tt := range []struct {
+		n               int
+		upstreamHandler http.HandlerFunc
+		method          string
+		tempDir         string
+		cacher          Cacher
+		upstreams       []string
+		maintenance     bool
+		wantStatusCode  int
+		wantContent     string
+	}{
+		{
+			n:              1,
+			tempDir:        t.TempDir(),
+			cacher:         DirCacher(t.TempDir()),
+			wantStatusCode: http.StatusOK,
+			wantContent:    "ok",
+		},
+		{
+			n:               2,
+			upstreamHandler: func(rw http.ResponseWriter, req *http.Request) { responseNotFound(rw, req, 60) },
+			tempDir:         t.TempDir(),
+			upstreams:       []string{upstreamServer.URL},
+			wantStatusCode:  http.StatusOK,
+			wantContent:     "ok",
+		},
+		{
+			n:               3,
+			upstreamHandler: func(rw http.ResponseWriter, req *http.Request) { rw.WriteHeader(http.StatusBadGateway) },
+			tempDir:         t.TempDir(),
+			upstreams:       []string{upstreamServer.URL},
+			wantStatusCode:  http.StatusServiceUnavailable,
+			wantContent:     "not ready: upstream is unreachable: GET " + upstreamServer.URL + ": 502 Bad Gateway",
+		},
+		{
+			n:              4,
+			tempDir:        t.TempDir(),
+			upstreams:      []string{"://invalid"},
+			wantStatusCode: http.StatusServiceUnavailable,
+			wantContent:    `not ready: upstream is unreachable: parse "://invalid": missing protocol scheme`,
+		},
+		{
+			n:              5,
+			tempDir:        t.TempDir(),
+			cacher:         unhealthyCacher{},
+			wantStatusCode: http.StatusServiceUnavailable,
+			wantContent:    "not ready: cacher is unhealthy: cannot connect",
+		},
+		{
+			n:              6,
+			tempDir:        filepath.Join(t.TempDir(), "404"),
+			wantStatusCode: http.StatusServiceUnavailable,
+			wantContent:    "not ready: temporary directory is not writable: ",
+		},
+		{
+			n:              7,
+			method:         http.MethodPost,
+			tempDir:        t.TempDir(),
+			wantStatusCode: http.StatusMethodNotAllowed,
+			wantContent:    "method not allowed",
+		},
+		{
+			n:              8,
+			tempDir:        t.TempDir(),
+			maintenance:    true,
+			wantStatusCode: http.StatusServiceUnavailable,
+			wantContent:    "not ready: in maintenance",
+		},
+	} {
+		setUpstreamHandler(tt.upstreamHandler)
+		g := &Goproxy{
+			Cacher:             tt.cacher,
+			TempDir:            tt.tempDir,
+			ReadinessUpstreams: tt.upstreams,
+		}
+		g.SetMaintenance(tt.maintenance)
+		rec := httptest.NewRecorder()
+		g.ReadinessHandler().ServeHTTP(rec, httptest.NewRequest(tt.method, "/readyz", nil))
+		recr := rec.Result()
+		if got, want := recr.StatusCode, tt.wantStatusCode; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if got, want := recr.Header.Get("Cache-Control"), "must-revalidate, no-cache, no-store"; tt.method == "" && got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		if b, err := io.ReadAll(recr.Body); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		} else if got, want := string(b), tt.wantContent; !strings.HasPrefix(got, want) {
+			t.Errorf("test(%d): got %q, want prefix %q", tt.n, got, want)
+		}
+	}
+}
+
+func TestReadinessCheck(t *testing.T) {
+	var r readiness
+	var calls int
+	checkFunc := func(ctx context.Context) error {
+		calls++
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("expected deadline")
+		}
+		return errors.New("not ready")
+	}
+	for i := 0; i < 3; i++ {
+		if err := r.check(checkFunc); err == nil {
+			t.Fatal("expected error")
+		} else if got, want := err.Error(), "not ready"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+	if got, want := calls, 1; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	r.checkedAt = time.Now().Add(-readinessCacheTTL)
+	r.check(checkFunc)
+	if got, want := calls, 2; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	r = readiness{}
+	if err := r.check(func(context.Context) error { return context.Canceled }); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+	if err := r.check(func(context.Context) error { return nil }); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+}
+
+func TestGoproxyReadinessHandlerCanceledProbe(t *testing.T) {
+	upstreamServer, setUpstreamHandler := newHTTPTestServer()
+	defer upstreamServer.Close()
+	setUpstreamHandler(func(rw http.ResponseWriter, req *http.Request) {})
+	g := &Goproxy{TempDir: t.TempDir(), ReadinessUpstreams: []string{upstreamServer.URL}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i, req := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx),
+		httptest.NewRequest(http.MethodGet, "/", nil),
+	} {
+		rec := httptest.NewRecorder()
+		g.ReadinessHandler().ServeHTTP(rec, req)
+		if got, want := rec.Code, http.StatusOK; got != want {
+			t.Errorf("test(%d): got %d, want %d", i+1, got, want)
+		}
+		if got, want := rec.Body.String(), "ok"; got != want {
+			t.Errorf("test(%d): got %q, want %q", i+1, got, want)
+		}
+	}
+}
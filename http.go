@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"path"
@@ -24,6 +25,9 @@ var (
 
 	// errFetchTimedOut indicates a fetch operation has timed out.
 	errFetchTimedOut = errors.New("fetch timed out")
+
+	// errBadRequest indicates a request is malformed.
+	errBadRequest = errors.New("bad request")
 )
 
 // notFoundError is an error indicating that something was not found.
@@ -43,16 +47,84 @@ func (notFoundError) Is(target error) bool {
 	return false
 }
 
-// httpGet gets the content from the given url and writes it into the dst.
-func httpGet(ctx context.Context, client *http.Client, url string, dst io.Writer) error {
+// retryPolicy is a policy for retrying failed operations with exponential
+// backoff and jitter.
+type retryPolicy struct {
+	retries int
+	backoff time.Duration
+}
+
+// defaultRetryPolicy is the default [retryPolicy]. It retries only a couple of
+// times, so that a failing upstream is reported quickly instead of stalling
+// every request for it.
+var defaultRetryPolicy = retryPolicy{retries: 2, backoff: 100 * time.Millisecond}
+
+// newRetryPolicy returns a new [retryPolicy] with the retries and backoff. A
+// zero retries or backoff means the value of [defaultRetryPolicy], and a
+// negative retries means no retries.
+func newRetryPolicy(retries int, backoff time.Duration) retryPolicy {
+	rp := defaultRetryPolicy
+	if retries < 0 {
+		rp.retries = 0
+	} else if retries > 0 {
+		rp.retries = retries
+	}
+	if backoff > 0 {
+		rp.backoff = backoff
+	}
+	return rp
+}
+
+// wait waits before the attempt, which starts from 0 and is never waited for.
+// It returns false without waiting for the whole backoff if the ctx is done, or
+// if no attempts remain.
+func (rp retryPolicy) wait(ctx context.Context, attempt int) bool {
+	if attempt == 0 {
+		return true
+	}
+	if attempt > rp.retries || ctx.Err() != nil {
+		return false
+	}
+	select {
+	case <-time.After(backoffSleep(rp.backoff, 10*rp.backoff, attempt)):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// headerTransport is an [http.RoundTripper] that sets the static header and
+// userAgent on each request. It wraps a [netrcTransport], if any, so an
+// Authorization in the header takes precedence over .netrc credentials.
+type headerTransport struct {
+	base      http.RoundTripper
+	userAgent string
+	header    http.Header
+}
+
+// RoundTrip implements [http.RoundTripper].
+func (ht *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := ht.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	req = req.Clone(req.Context())
+	for k, vs := range ht.header {
+		req.Header[http.CanonicalHeaderKey(k)] = append([]string(nil), vs...)
+	}
+	if ht.userAgent != "" {
+		req.Header.Set("User-Agent", ht.userAgent)
+	}
+	return base.RoundTrip(req)
+}
+
+// httpGet gets the content from the given url and writes it into the dst. Failed
+// attempts are retried following the rp.
+func httpGet(ctx context.Context, client *http.Client, rp retryPolicy, url string, dst io.Writer) error {
 	var lastError error
-	for attempt := 0; attempt < 10; attempt++ {
-		if attempt > 0 {
-			select {
-			case <-time.After(backoffSleep(100*time.Millisecond, time.Second, attempt)):
-			case <-ctx.Done():
-				return lastError
-			}
+	for attempt := 0; ; attempt++ {
+		if !rp.wait(ctx, attempt) {
+			return lastError
 		}
 
 		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
@@ -62,12 +134,15 @@ func httpGet(ctx context.Context, client *http.Client, url string, dst io.Writer
 
 		resp, err := client.Do(req)
 		if err != nil {
+			logRequestDebug(ctx, "debug: requested upstream", slog.String("url", req.URL.Redacted()), slog.Int("attempt", attempt), slog.String("error", err.Error()))
 			if isRetryableHTTPClientDoError(err) {
 				lastError = err
 				continue
 			}
 			return err
 		}
+		addRequestLogAttrs(ctx, slog.Int("upstream_status", resp.StatusCode))
+		logRequestDebug(ctx, "debug: requested upstream", slog.String("url", req.URL.Redacted()), slog.Int("attempt", attempt), slog.Int("status", resp.StatusCode))
 		if resp.StatusCode == http.StatusOK {
 			if dst != nil {
 				_, err = io.Copy(dst, resp.Body)
@@ -83,9 +158,10 @@ func httpGet(ctx context.Context, client *http.Client, url string, dst io.Writer
 		}
 		switch resp.StatusCode {
 		case http.StatusBadRequest,
-			http.StatusNotFound,
-			http.StatusGone:
+			http.StatusNotFound:
 			return notFoundError(respBody)
+		case http.StatusGone:
+			return &NotExistError{Gone: true, Err: notFoundError(respBody)}
 		case http.StatusTooManyRequests,
 			http.StatusInternalServerError,
 			http.StatusBadGateway,
@@ -97,7 +173,6 @@ func httpGet(ctx context.Context, client *http.Client, url string, dst io.Writer
 			return fmt.Errorf("GET %s: %s: %s", resp.Request.URL.Redacted(), resp.Status, respBody)
 		}
 	}
-	return lastError
 }
 
 // isRetryableHTTPClientDoError reports whether the err is a retryable error
@@ -134,24 +209,3 @@ func parseRawURL(rawURL string) (*url.URL, error) {
 	}
 	return u, nil
 }
-
-// appendURL appends the extraPaths to the u safely and reutrns a new [url.URL].
-//
-// TODO: Remove appendURL when the minimum supported Go version is 1.19. See
-// https://go.dev/doc/go1.19#net/url.
-func appendURL(u *url.URL, extraPaths ...string) *url.URL {
-	nu := *u
-	u = &nu
-	for _, ep := range extraPaths {
-		if ep == "" {
-			continue
-		}
-		u.Path = path.Join(u.Path, ep)
-		u.RawPath = path.Join(u.RawPath, strings.ReplaceAll(url.PathEscape(ep), "%2F", "/"))
-		if ep[len(ep)-1] == '/' {
-			u.Path += "/"
-			u.RawPath += "/"
-		}
-	}
-	return u
-}
@@ -51,6 +51,62 @@ func TestNotFoundError(t *testing.T) {
 	}
 }
 
+func TestHeaderTransport(t *testing.T) {
+	var gotHeader http.Header
+	for _, tt := range []struct {
+		n          int
+		userAgent  string
+		header     http.Header
+		reqHeader  http.Header
+		wantHeader http.Header
+	}{
+		{
+			n:          1,
+			userAgent:  "foobar/1.0",
+			reqHeader:  http.Header{"User-Agent": {"Go-http-client/1.1"}},
+			wantHeader: http.Header{"User-Agent": {"foobar/1.0"}},
+		},
+		{
+			n:          2,
+			header:     http.Header{"x-foo": {"bar", "baz"}, "User-Agent": {"foo/1.0"}},
+			reqHeader:  http.Header{"X-Foo": {"qux"}, "Accept": {"*/*"}},
+			wantHeader: http.Header{"X-Foo": {"bar", "baz"}, "User-Agent": {"foo/1.0"}, "Accept": {"*/*"}},
+		},
+		{
+			n:          3,
+			userAgent:  "foobar/1.0",
+			header:     http.Header{"User-Agent": {"foo/1.0"}, "Authorization": {"Bearer foobar"}},
+			wantHeader: http.Header{"User-Agent": {"foobar/1.0"}, "Authorization": {"Bearer foobar"}},
+		},
+	} {
+		ht := &headerTransport{
+			base: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				gotHeader = req.Header
+				return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+			}),
+			userAgent: tt.userAgent,
+			header:    tt.header,
+		}
+		req, err := http.NewRequest(http.MethodGet, "https://example.com", nil)
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		}
+		for k, vs := range tt.reqHeader {
+			req.Header[k] = vs
+		}
+		reqHeader := req.Header.Clone()
+		if _, err := ht.RoundTrip(req); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		}
+		if got, want := fmt.Sprint(gotHeader), fmt.Sprint(tt.wantHeader); got != want {
+			t.Errorf("test(%d): got %s, want %s", tt.n, got, want)
+		}
+		if got, want := fmt.Sprint(req.Header), fmt.Sprint(reqHeader); got != want {
+			t.Errorf("test(%d): got %s, want %s", tt.n, got, want)
+		}
+	}
+}
+
 func TestHTTPGet(t *testing.T) {
 	server, setHandler := newHTTPTestServer()
 	defer server.Close()
@@ -162,7 +218,7 @@ func TestHTTPGet(t *testing.T) {
 		}
 		setHandler(tt.handler)
 		var content bytes.Buffer
-		err := httpGet(ctx, client, server.URL, &content)
+		err := httpGet(ctx, client, defaultRetryPolicy, server.URL, &content)
 		if tt.wantError != nil {
 			if err == nil {
 				t.Fatalf("test(%d): expected error", tt.n)
@@ -180,11 +236,88 @@ func TestHTTPGet(t *testing.T) {
 		}
 	}
 
-	if err := httpGet(context.Background(), http.DefaultClient, "::", nil); err == nil {
+	if err := httpGet(context.Background(), http.DefaultClient, defaultRetryPolicy, "::", nil); err == nil {
 		t.Fatal("expected error")
 	}
 }
 
+func TestNewRetryPolicy(t *testing.T) {
+	for _, tt := range []struct {
+		n               int
+		retries         int
+		backoff         time.Duration
+		wantRetryPolicy retryPolicy
+	}{
+		{1, 0, 0, retryPolicy{retries: 2, backoff: 100 * time.Millisecond}},
+		{2, 3, time.Second, retryPolicy{retries: 3, backoff: time.Second}},
+		{3, -1, 0, retryPolicy{retries: 0, backoff: defaultRetryPolicy.backoff}},
+	} {
+		if got, want := newRetryPolicy(tt.retries, tt.backoff), tt.wantRetryPolicy; got != want {
+			t.Errorf("test(%d): got %+v, want %+v", tt.n, got, want)
+		}
+	}
+}
+
+func TestRetryPolicyWait(t *testing.T) {
+	rp := retryPolicy{retries: 2, backoff: time.Millisecond}
+	for attempt, want := range []bool{true, true, true, false} {
+		if got := rp.wait(context.Background(), attempt); got != want {
+			t.Errorf("attempt(%d): got %t, want %t", attempt, got, want)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if got, want := rp.wait(ctx, 0), true; got != want {
+		t.Errorf("got %t, want %t", got, want)
+	}
+	if got, want := rp.wait(ctx, 1), false; got != want {
+		t.Errorf("got %t, want %t", got, want)
+	}
+
+	rp = retryPolicy{retries: 1, backoff: time.Hour}
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	startTime := time.Now()
+	rp.wait(ctx, 1)
+	if got, want := time.Since(startTime), time.Minute; got >= want {
+		t.Errorf("got %s, want less than %s", got, want)
+	}
+}
+
+func TestHTTPGetRetries(t *testing.T) {
+	server, setHandler := newHTTPTestServer()
+	defer server.Close()
+	for _, tt := range []struct {
+		n            int
+		statusCode   int
+		rp           retryPolicy
+		wantRequests int
+		wantError    error
+	}{
+		{1, http.StatusBadGateway, retryPolicy{retries: 2, backoff: time.Millisecond}, 3, errBadUpstream},
+		{2, http.StatusBadGateway, retryPolicy{}, 1, errBadUpstream},
+		{3, http.StatusGatewayTimeout, retryPolicy{retries: 1, backoff: time.Millisecond}, 2, errFetchTimedOut},
+		{4, http.StatusNotFound, retryPolicy{retries: 2, backoff: time.Millisecond}, 1, errNotFound},
+		{5, http.StatusGone, retryPolicy{retries: 2, backoff: time.Millisecond}, 1, errNotFound},
+		{6, http.StatusForbidden, retryPolicy{retries: 2, backoff: time.Millisecond}, 1, fmt.Errorf("GET %s: 403 Forbidden: ", server.URL)},
+	} {
+		var requests int
+		setHandler(func(rw http.ResponseWriter, req *http.Request) {
+			requests++
+			rw.WriteHeader(tt.statusCode)
+		})
+		if err := httpGet(context.Background(), http.DefaultClient, tt.rp, server.URL, nil); err == nil {
+			t.Fatalf("test(%d): expected error", tt.n)
+		} else if got, want := err, tt.wantError; !errors.Is(got, want) && got.Error() != want.Error() {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		if got, want := requests, tt.wantRequests; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+	}
+}
+
 func TestIsRetryableHTTPClientDoError(t *testing.T) {
 	for _, tt := range []struct {
 		n               int
@@ -230,23 +363,3 @@ func TestParseRawURL(t *testing.T) {
 		t.Fatal("expected error")
 	}
 }
-
-func TestAppendURL(t *testing.T) {
-	u := &url.URL{Scheme: "https", Host: "example.com"}
-	for _, tt := range []struct {
-		n       int
-		au      *url.URL
-		wantURL string
-	}{
-		{1, appendURL(u, "foobar"), "https://example.com/foobar"},
-		{2, appendURL(u, "foo", "bar"), "https://example.com/foo/bar"},
-		{3, appendURL(u, "", "foo", "", "bar"), "https://example.com/foo/bar"},
-		{4, appendURL(u, "foo/bar"), "https://example.com/foo/bar"},
-		{5, appendURL(u, "/foo/bar"), "https://example.com/foo/bar"},
-		{6, appendURL(u, "/foo/bar/"), "https://example.com/foo/bar/"},
-	} {
-		if got, want := tt.au.String(), tt.wantURL; got != want {
-			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
-		}
-	}
-}
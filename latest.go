@@ -0,0 +1,221 @@
+package goproxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// Latest version policies of a [LatestVersionPolicies] rule.
+const (
+	// latestHighestSemver resolves @latest as the go command does: to the
+	// highest release version, or the highest pre-release version if there
+	// is no release version, or a pseudo-version for the tip of the default
+	// branch if there is no version at all.
+	latestHighestSemver = "highest-semver"
+
+	// latestHighestIncludingPrerelease resolves @latest to the highest
+	// version that is not retracted, whether it is a release or a
+	// pre-release version, falling back to [latestHighestSemver] if there is
+	// no such version at all.
+	latestHighestIncludingPrerelease = "highest-including-prerelease"
+
+	// latestTrackBranch resolves @latest to the tip of a branch, as a
+	// pseudo-version unless the tip is tagged.
+	latestTrackBranch = "track-branch"
+)
+
+// LatestVersionPolicies is a set of rules that override how a [Goproxy]
+// resolves the @latest of some modules. See [ParseLatestVersionPolicies] and
+// [Goproxy.LatestVersionPolicies].
+type LatestVersionPolicies struct {
+	rules []latestVersionRule
+}
+
+// latestVersionRule is a rule of a [LatestVersionPolicies].
+type latestVersionRule struct {
+	pattern string
+	policy  string
+	branch  string
+}
+
+// ParseLatestVersionPolicies parses the data as a [LatestVersionPolicies].
+//
+// Each non-empty line of the data, except for those starting with "#", is a
+// rule in the form "<pattern> <policy> [<branch>]". The pattern is a glob
+// pattern (as defined by [path.Match]) of module path prefixes, in the same
+// form as a GOPRIVATE entry. The policy is one of:
+//
+//   - "highest-semver": Resolves @latest as the go command does, to the
+//     highest release version, else the highest pre-release version, else
+//     a pseudo-version for the tip of the default branch.
+//   - "highest-including-prerelease": Resolves @latest to the highest
+//     version that is not retracted, whether it is a pre-release version or
+//     not, else as "highest-semver" does.
+//   - "track-branch": Resolves @latest to the tip of the branch, as a
+//     pseudo-version unless the tip is tagged, so that the resolved version
+//     can be downloaded and verified like any other. The branch must be
+//     valid as a version query in the GOPROXY protocol.
+//
+// The @latest of a module is resolved by the first rule whose pattern matches
+// the module path. Modules matching no rule are resolved by "highest-semver".
+//
+// For example:
+//
+//	# Internal services are released from their release branches.
+//	example.com/internal/* track-branch release
+//	example.com/beta highest-including-prerelease
+func ParseLatestVersionPolicies(data []byte) (*LatestVersionPolicies, error) {
+	lvp := &LatestVersionPolicies{}
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for lineNum := 1; s.Scan(); lineNum++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: missing policy", lineNum)
+		}
+		pattern := strings.ToLower(fields[0])
+		if strings.Contains(pattern, ",") {
+			return nil, fmt.Errorf("line %d: invalid pattern %q", lineNum, fields[0])
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("line %d: invalid pattern %q: %w", lineNum, fields[0], err)
+		}
+		rule := latestVersionRule{pattern: pattern, policy: fields[1]}
+		switch rule.policy {
+		case latestHighestSemver, latestHighestIncludingPrerelease:
+			if len(fields) > 2 {
+				return nil, fmt.Errorf("line %d: unexpected arguments for policy %q", lineNum, rule.policy)
+			}
+		case latestTrackBranch:
+			if len(fields) != 3 {
+				return nil, fmt.Errorf("line %d: policy %q requires exactly one branch", lineNum, rule.policy)
+			}
+			rule.branch = fields[2]
+			if _, err := module.EscapeVersion(rule.branch); err != nil || rule.branch == "latest" || semver.IsValid(rule.branch) {
+				return nil, fmt.Errorf("line %d: invalid branch %q", lineNum, rule.branch)
+			}
+		default:
+			return nil, fmt.Errorf("line %d: unknown policy %q", lineNum, rule.policy)
+		}
+		lvp.rules = append(lvp.rules, rule)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return lvp, nil
+}
+
+// rule returns the first rule of the lvp whose pattern matches the modulePath,
+// or nil if there is none.
+func (lvp *LatestVersionPolicies) rule(modulePath string) *latestVersionRule {
+	if lvp == nil {
+		return nil
+	}
+	lowerModulePath := strings.ToLower(modulePath)
+	for i := range lvp.rules {
+		if globsMatchPath(lvp.rules[i].pattern, lowerModulePath) {
+			return &lvp.rules[i]
+		}
+	}
+	return nil
+}
+
+// applyLatestVersionRule turns the @latest fetch f into a fetch resolving the
+// version that the r resolves @latest to, if it is not the one the go command
+// would. The f is left as is if the r has nothing to override. If noFetch is
+// true, the versions of the module are taken from the cached version list
+// instead of being fetched.
+func (g *Goproxy) applyLatestVersionRule(ctx context.Context, f *fetch, r *latestVersionRule, noFetch bool) error {
+	escapedModulePath := strings.TrimSuffix(f.name, "/@latest")
+	var version string
+	switch r.policy {
+	case latestHighestIncludingPrerelease:
+		versions, err := g.latestVersionCandidates(ctx, escapedModulePath, f.tempDir, noFetch)
+		if err != nil {
+			return err
+		}
+		if len(versions) == 0 {
+			return nil
+		}
+		version = versions[len(versions)-1]
+	case latestTrackBranch:
+		version = r.branch
+	default:
+		return nil
+	}
+	escapedVersion, err := module.EscapeVersion(version)
+	if err != nil {
+		return err
+	}
+	f.name = escapedModulePath + "/@v/" + escapedVersion + ".info"
+	f.moduleVersion = version
+	f.modAtVer = f.modulePath + "@" + version
+	return nil
+}
+
+// latestVersionCandidates returns the valid versions in the version list of
+// the module with the escapedModulePath, sorted in ascending order and without
+// those retracted by the module author, as the go command skips them when
+// resolving @latest. The version list is taken from the cache if noFetch is
+// true, and a version list that is not cached is treated as empty. The go.mod
+// file declaring the retractions is likewise only read from the cache if
+// noFetch is true. If the retractions cannot be read, no version is treated as
+// retracted.
+func (g *Goproxy) latestVersionCandidates(ctx context.Context, escapedModulePath, tempDir string, noFetch bool) ([]string, error) {
+	lf, err := newFetch(g, escapedModulePath+"/@v/list", tempDir)
+	if err != nil {
+		return nil, err
+	}
+	var versions []string
+	if noFetch {
+		rc, err := g.cache(ctx, lf.name)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		defer rc.Close()
+		b, err := io.ReadAll(rc)
+		if err != nil {
+			return nil, err
+		}
+		versions = strings.Fields(string(b))
+	} else {
+		fr, release, err := g.doFetch(ctx, lf)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		versions = fr.Versions
+	}
+
+	validVersions := make([]string, 0, len(versions))
+	for _, v := range versions {
+		if semver.IsValid(v) {
+			validVersions = append(validVersions, v)
+		}
+	}
+	sortVersions(validVersions)
+	filtered, err := g.filterRetractedVersions(ctx, lf, validVersions, noFetch)
+	if err != nil {
+		if !noFetch || !errors.Is(err, fs.ErrNotExist) {
+			g.logErrorf("failed to filter retracted versions: %s: %v", lf.name, err)
+		}
+		return validVersions, nil
+	}
+	return filtered, nil
+}
@@ -0,0 +1,181 @@
+package goproxy
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseLatestVersionPolicies(t *testing.T) {
+	for _, tt := range []struct {
+		n         int
+		data      string
+		wantRules int
+		wantError string
+	}{
+		{1, "", 0, ""},
+		{2, "# comment\n\nexample.com track-branch release\nExample.org/* highest-including-prerelease\nexample.net highest-semver\n", 3, ""},
+		{3, "example.com\n", 0, "line 1: missing policy"},
+		{4, "\nexample.com newest\n", 0, `line 2: unknown policy "newest"`},
+		{5, "example.com track-branch\n", 0, `line 1: policy "track-branch" requires exactly one branch`},
+		{6, "example.com track-branch v1.0.0\n", 0, `line 1: invalid branch "v1.0.0"`},
+		{7, "example.com track-branch latest\n", 0, `line 1: invalid branch "latest"`},
+		{8, "example.com highest-semver main\n", 0, `line 1: unexpected arguments for policy "highest-semver"`},
+		{9, "example.com,example.org highest-semver\n", 0, `line 1: invalid pattern "example.com,example.org"`},
+		{10, "[example.com highest-semver\n", 0, `line 1: invalid pattern "[example.com": syntax error in pattern`},
+	} {
+		lvp, err := ParseLatestVersionPolicies([]byte(tt.data))
+		if tt.wantError != "" {
+			if err == nil {
+				t.Fatalf("test(%d): expected error", tt.n)
+			}
+			if got, want := err.Error(), tt.wantError; got != want {
+				t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+			}
+		} else if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		} else if got, want := len(lvp.rules), tt.wantRules; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+	}
+
+	if got := (*LatestVersionPolicies)(nil).rule("example.com"); got != nil {
+		t.Errorf("got %v, want nil", got)
+	}
+}
+
+func TestGoproxyServeFetchLatestVersionPolicies(t *testing.T) {
+	proxyServer, setProxyHandler := newHTTPTestServer()
+	defer proxyServer.Close()
+	var upstreamPaths []string
+	setProxyHandler(func(rw http.ResponseWriter, req *http.Request) {
+		upstreamPaths = append(upstreamPaths, req.URL.Path)
+		switch req.URL.Path {
+		case "/example.com/stable/@latest", "/example.com/beta/@latest", "/example.com/branch/@latest":
+			responseString(rw, req, http.StatusOK, -2, `{"Version":"v1.0.0","Time":"2020-01-01T00:00:00Z"}`)
+		case "/example.com/beta/@v/list":
+			responseString(rw, req, http.StatusOK, -2, "v1.0.0\nv1.1.0-beta.1\n")
+		case "/example.com/beta/@v/v1.0.0.mod":
+			responseString(rw, req, http.StatusOK, -2, "module example.com/beta\n")
+		case "/example.com/beta/@v/v1.1.0-beta.1.info", "/example.com/retracted/@v/v1.1.0-beta.1.info":
+			responseString(rw, req, http.StatusOK, -2, `{"Version":"v1.1.0-beta.1","Time":"2020-01-01T00:00:00Z"}`)
+		case "/example.com/retracted/@v/list":
+			responseString(rw, req, http.StatusOK, -2, "v1.0.0\nv1.1.0-beta.1\nv1.1.0-beta.2\n")
+		case "/example.com/retracted/@v/v1.0.0.mod":
+			responseString(rw, req, http.StatusOK, -2, "module example.com/retracted\n\nretract v1.1.0-beta.2\n")
+		case "/example.com/allretracted/@v/list":
+			responseString(rw, req, http.StatusOK, -2, "v1.0.0-beta.1\n")
+		case "/example.com/allretracted/@v/v1.0.0-beta.1.mod":
+			responseString(rw, req, http.StatusOK, -2, "module example.com/allretracted\n\nretract v1.0.0-beta.1\n")
+		case "/example.com/allretracted/@latest":
+			responseString(rw, req, http.StatusOK, -2, `{"Version":"v1.0.0-beta.1","Time":"2020-01-01T00:00:00Z"}`)
+		case "/example.com/branch/@v/release.info":
+			responseString(rw, req, http.StatusOK, -2, `{"Version":"v1.1.0-0.20200101000000-0123456789ab","Time":"2020-01-01T00:00:00Z"}`)
+		case "/example.com/empty/@v/list":
+			responseString(rw, req, http.StatusOK, -2, "")
+		case "/example.com/empty/@latest":
+			responseString(rw, req, http.StatusOK, -2, `{"Version":"v0.0.0-20200101000000-0123456789ab","Time":"2020-01-01T00:00:00Z"}`)
+		default:
+			responseNotFound(rw, req, -2)
+		}
+	})
+	lvp, err := ParseLatestVersionPolicies([]byte(`
+example.com/beta highest-including-prerelease
+example.com/empty highest-including-prerelease
+example.com/retracted highest-including-prerelease
+example.com/allretracted highest-including-prerelease
+example.com/branch track-branch release
+example.com/stable highest-semver
+`))
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	g := &Goproxy{
+		Env:                   []string{"GOPROXY=" + proxyServer.URL, "GOSUMDB=off"},
+		Cacher:                &MemoryCacher{},
+		TempDir:               t.TempDir(),
+		LatestVersionPolicies: lvp,
+		ErrorLogger:           log.New(io.Discard, "", 0),
+	}
+	for _, tt := range []struct {
+		n                 int
+		path              string
+		wantContent       string
+		wantUpstreamPaths []string
+	}{
+		{1, "/example.com/stable/@latest", `{"Version":"v1.0.0","Time":"2020-01-01T00:00:00Z"}`, []string{"/example.com/stable/@latest"}},
+		{2, "/example.com/beta/@latest", `{"Version":"v1.1.0-beta.1","Time":"2020-01-01T00:00:00Z"}`, []string{"/example.com/beta/@v/list", "/example.com/beta/@v/v1.0.0.mod", "/example.com/beta/@v/v1.1.0-beta.1.info"}},
+		{3, "/example.com/branch/@latest", `{"Version":"v1.1.0-0.20200101000000-0123456789ab","Time":"2020-01-01T00:00:00Z"}`, []string{"/example.com/branch/@v/release.info"}},
+		{4, "/example.com/empty/@latest", `{"Version":"v0.0.0-20200101000000-0123456789ab","Time":"2020-01-01T00:00:00Z"}`, []string{"/example.com/empty/@v/list", "/example.com/empty/@latest"}},
+		{5, "/example.com/retracted/@latest", `{"Version":"v1.1.0-beta.1","Time":"2020-01-01T00:00:00Z"}`, []string{"/example.com/retracted/@v/list", "/example.com/retracted/@v/v1.0.0.mod", "/example.com/retracted/@v/v1.1.0-beta.1.info"}},
+		{6, "/example.com/allretracted/@latest", `{"Version":"v1.0.0-beta.1","Time":"2020-01-01T00:00:00Z"}`, []string{"/example.com/allretracted/@v/list", "/example.com/allretracted/@v/v1.0.0-beta.1.mod", "/example.com/allretracted/@latest"}},
+	} {
+		upstreamPaths = nil
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		recr := rec.Result()
+		if got, want := recr.StatusCode, http.StatusOK; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if b, err := io.ReadAll(recr.Body); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		} else if got, want := string(b), tt.wantContent; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		if got, want := strings.Join(upstreamPaths, ","), strings.Join(tt.wantUpstreamPaths, ","); got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+}
+
+func TestGoproxyServeFetchLatestVersionPoliciesOffline(t *testing.T) {
+	cacher := &MemoryCacher{}
+	for name, content := range map[string]string{
+		"example.com/beta/@latest":                    `{"Version":"v1.0.0"}`,
+		"example.com/beta/@v/list":                    "v1.0.0\nv1.1.0-beta.1",
+		"example.com/beta/@v/v1.1.0-beta.1.info":      `{"Version":"v1.1.0-beta.1"}`,
+		"example.com/branch/@latest":                  `{"Version":"v1.0.0"}`,
+		"example.com/branch/@v/release.info":          `{"Version":"v1.1.0-0.20200101000000-0123456789ab"}`,
+		"example.com/nolist/@latest":                  `{"Version":"v1.0.0"}`,
+		"example.com/retracted/@latest":               `{"Version":"v1.0.0"}`,
+		"example.com/retracted/@v/list":               "v1.0.0\nv1.1.0-beta.1\nv1.1.0-beta.2",
+		"example.com/retracted/@v/v1.0.0.mod":         "module example.com/retracted\n\nretract v1.1.0-beta.2\n",
+		"example.com/retracted/@v/v1.1.0-beta.1.info": `{"Version":"v1.1.0-beta.1"}`,
+		"example.com/nolist/@v/v1.1.0-beta.1.info":    `{"Version":"v1.1.0-beta.1"}`,
+	} {
+		if err := cacher.Put(context.Background(), name, strings.NewReader(content)); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+	lvp, err := ParseLatestVersionPolicies([]byte("example.com/branch track-branch release\nexample.com/* highest-including-prerelease\n"))
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	g := &Goproxy{Cacher: cacher, Offline: true, LatestVersionPolicies: lvp}
+	for _, tt := range []struct {
+		n           int
+		path        string
+		wantContent string
+	}{
+		{1, "/example.com/beta/@latest", `{"Version":"v1.1.0-beta.1"}`},
+		{2, "/example.com/branch/@latest", `{"Version":"v1.1.0-0.20200101000000-0123456789ab"}`},
+		{3, "/example.com/nolist/@latest", `{"Version":"v1.0.0"}`},
+		{4, "/example.com/retracted/@latest", `{"Version":"v1.1.0-beta.1"}`},
+	} {
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		recr := rec.Result()
+		if got, want := recr.StatusCode, http.StatusOK; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if b, err := io.ReadAll(recr.Body); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		} else if got, want := string(b), tt.wantContent; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package goproxy
+
+import (
+	"runtime"
+	runtimemetrics "runtime/metrics"
+	"sync"
+	"time"
+)
+
+const (
+	// loadShedRetryAfter is the Retry-After of the requests shed by a
+	// [loadShedder].
+	loadShedRetryAfter = 5 * time.Second
+
+	// loadShedHeapSampleInterval is how long a heap size sampled by a
+	// [loadShedder] is reused, since sampling is not free.
+	loadShedHeapSampleInterval = time.Second
+
+	// loadShedHeapMetric is the runtime metric of the heap size checked by
+	// a [loadShedder].
+	loadShedHeapMetric = "/memory/classes/heap/objects:bytes"
+)
+
+// Reasons for a [loadShedder] to shed load, used as metric labels.
+const (
+	loadShedReasonQueueWait  = "queue_wait"
+	loadShedReasonGoroutines = "goroutines"
+	loadShedReasonHeap       = "heap"
+)
+
+// loadShedder decides whether requests that need fetches are shed based on how
+// long direct fetches have been waiting for slots, the number of goroutines,
+// and the heap size. It is safe for concurrent use. A nil loadShedder never
+// sheds.
+type loadShedder struct {
+	maxQueueWait  time.Duration
+	maxGoroutines int
+	maxHeapBytes  int64
+
+	mutex           sync.Mutex
+	waiters         map[uint64]time.Time
+	nextWaiterID    uint64
+	heapBytes       int64
+	heapSampledAt   time.Time
+	sampleHeapBytes func() int64
+}
+
+// newLoadShedder returns a new [loadShedder] with the thresholds, or nil if all
+// of them are zero or negative.
+func newLoadShedder(maxQueueWait time.Duration, maxGoroutines int, maxHeapBytes int64) *loadShedder {
+	if maxQueueWait <= 0 && maxGoroutines <= 0 && maxHeapBytes <= 0 {
+		return nil
+	}
+	return &loadShedder{
+		maxQueueWait:    maxQueueWait,
+		maxGoroutines:   maxGoroutines,
+		maxHeapBytes:    maxHeapBytes,
+		waiters:         map[uint64]time.Time{},
+		sampleHeapBytes: sampleHeapBytes,
+	}
+}
+
+// startWaiting records a direct fetch starting to wait for a slot at the now.
+// The returned done must be called once it stops waiting.
+func (ls *loadShedder) startWaiting(now time.Time) (done func()) {
+	if ls == nil || ls.maxQueueWait <= 0 {
+		return func() {}
+	}
+	ls.mutex.Lock()
+	id := ls.nextWaiterID
+	ls.nextWaiterID++
+	ls.waiters[id] = now
+	ls.mutex.Unlock()
+	return func() {
+		ls.mutex.Lock()
+		delete(ls.waiters, id)
+		ls.mutex.Unlock()
+	}
+}
+
+// shed returns the reason to shed a request that needs a fetch at the now, or
+// an empty string if it should be served.
+func (ls *loadShedder) shed(now time.Time) string {
+	if ls == nil {
+		return ""
+	}
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+	if ls.maxQueueWait > 0 {
+		for _, startTime := range ls.waiters {
+			if now.Sub(startTime) > ls.maxQueueWait {
+				return loadShedReasonQueueWait
+			}
+		}
+	}
+	if ls.maxGoroutines > 0 && runtime.NumGoroutine() > ls.maxGoroutines {
+		return loadShedReasonGoroutines
+	}
+	if ls.maxHeapBytes > 0 {
+		if ls.heapSampledAt.IsZero() || now.Sub(ls.heapSampledAt) >= loadShedHeapSampleInterval {
+			ls.heapBytes = ls.sampleHeapBytes()
+			ls.heapSampledAt = now
+		}
+		if ls.heapBytes > ls.maxHeapBytes {
+			return loadShedReasonHeap
+		}
+	}
+	return ""
+}
+
+// sampleHeapBytes returns the number of bytes of the heap occupied by objects,
+// including unreachable ones not yet collected.
+func sampleHeapBytes() int64 {
+	samples := []runtimemetrics.Sample{{Name: loadShedHeapMetric}}
+	runtimemetrics.Read(samples)
+	if samples[0].Value.Kind() != runtimemetrics.KindUint64 {
+		return 0
+	}
+	return int64(samples[0].Value.Uint64())
+}
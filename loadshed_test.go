@@ -0,0 +1,110 @@
+package goproxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadShedder(t *testing.T) {
+	if ls := newLoadShedder(0, 0, 0); ls != nil {
+		t.Fatalf("got %v, want nil", ls)
+	}
+	var nilLS *loadShedder
+	nilLS.startWaiting(time.Now())()
+	if got := nilLS.shed(time.Now()); got != "" {
+		t.Errorf("got %q, want %q", got, "")
+	}
+
+	now := time.Now()
+	ls := newLoadShedder(time.Second, 0, 0)
+	done := ls.startWaiting(now)
+	if got, want := ls.shed(now.Add(time.Second)), ""; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := ls.shed(now.Add(2*time.Second)), loadShedReasonQueueWait; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	done()
+	if got, want := ls.shed(now.Add(2*time.Second)), ""; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	ls = newLoadShedder(0, 1, 0)
+	if got, want := ls.shed(now), loadShedReasonGoroutines; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	ls = newLoadShedder(0, 0, 100)
+	var samples int
+	heapBytes := int64(200)
+	ls.sampleHeapBytes = func() int64 {
+		samples++
+		return heapBytes
+	}
+	if got, want := ls.shed(now), loadShedReasonHeap; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	heapBytes = 50
+	if got, want := ls.shed(now.Add(time.Second/2)), loadShedReasonHeap; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := ls.shed(now.Add(time.Second)), ""; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := samples, 2; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
+
+func TestGoproxyServeHTTPLoadShedding(t *testing.T) {
+	cacher := &MemoryCacher{}
+	for name, content := range map[string]string{
+		"example.com/@v/list":        "v1.0.0",
+		"example.com/@v/v1.0.0.info": `{"Version":"v1.0.0"}`,
+	} {
+		if err := cacher.Put(context.Background(), name, strings.NewReader(content)); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+	g := &Goproxy{Cacher: cacher, TempDir: t.TempDir(), LoadShedGoroutines: 1}
+	for _, tt := range []struct {
+		n              int
+		path           string
+		wantStatusCode int
+		wantContent    string
+	}{
+		{1, "/example.com/@v/v1.0.0.info", http.StatusOK, `{"Version":"v1.0.0"}`},
+		{2, "/example.com/@v/list", http.StatusOK, "v1.0.0"},
+		{3, "/example.com/@v/v1.1.0.info", http.StatusServiceUnavailable, "service unavailable: overloaded"},
+		{4, "/example.com/@latest", http.StatusServiceUnavailable, "service unavailable: overloaded"},
+		{5, "/example.org/@v/list", http.StatusServiceUnavailable, "service unavailable: overloaded"},
+	} {
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		recr := rec.Result()
+		if got, want := recr.StatusCode, tt.wantStatusCode; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if b, err := io.ReadAll(recr.Body); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		} else if got, want := string(b), tt.wantContent; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		if tt.wantStatusCode == http.StatusServiceUnavailable {
+			if got, want := recr.Header.Get("Retry-After"), "5"; got != want {
+				t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+			}
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	g.MetricsHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got, want := rec.Body.String(), `goproxy_requests_shed_total{reason="goroutines"} 3`; !strings.Contains(got, want) {
+		t.Errorf("got %q, want it to contain %q", got, want)
+	}
+}
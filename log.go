@@ -0,0 +1,116 @@
+package goproxy
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"sync"
+)
+
+// requestIDHeader is the response header that carries the correlation ID of a
+// request served by [Goproxy].
+const requestIDHeader = "X-Goproxy-Request-ID"
+
+// newRequestID returns a new random request correlation ID.
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// clientIdentity returns the identity of the client of the req verified by
+// mutual TLS, which is the common name of its certificate, or the first DNS,
+// email or URI subject alternative name if the common name is empty. It
+// returns an empty string if the req carries no verified client certificate.
+func clientIdentity(req *http.Request) string {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return certIdentity(req.TLS.VerifiedChains[0][0])
+}
+
+// certIdentity returns the identity of the cert as described in
+// [clientIdentity].
+func certIdentity(cert *x509.Certificate) string {
+	switch {
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	}
+	return ""
+}
+
+// requestLogContextKey is the context key for the [requestLog] of a request.
+type requestLogContextKey struct{}
+
+// requestLog collects the attributes of a request being served so that they
+// can be emitted as a single structured log event. It is safe for concurrent
+// use.
+type requestLog struct {
+	mutex sync.Mutex
+	attrs []slog.Attr
+}
+
+// withRequestLog returns a copy of the ctx that carries the rl.
+func withRequestLog(ctx context.Context, rl *requestLog) context.Context {
+	return context.WithValue(ctx, requestLogContextKey{}, rl)
+}
+
+// addRequestLogAttrs adds the attrs to the [requestLog] carried by the ctx. It
+// does nothing if the ctx carries no [requestLog].
+func addRequestLogAttrs(ctx context.Context, attrs ...slog.Attr) {
+	rl, ok := ctx.Value(requestLogContextKey{}).(*requestLog)
+	if !ok {
+		return
+	}
+	rl.mutex.Lock()
+	rl.attrs = append(rl.attrs, attrs...)
+	rl.mutex.Unlock()
+}
+
+// Attrs returns a copy of the attributes collected by the rl.
+func (rl *requestLog) Attrs() []slog.Attr {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+	return append([]slog.Attr(nil), rl.attrs...)
+}
+
+// statusResponseWriter is an [http.ResponseWriter] that records the status
+// code and the number of body bytes written of the response.
+type statusResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	written    int64
+}
+
+// WriteHeader implements [http.ResponseWriter].
+func (srw *statusResponseWriter) WriteHeader(statusCode int) {
+	if srw.statusCode == 0 {
+		srw.statusCode = statusCode
+	}
+	srw.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write implements [http.ResponseWriter].
+func (srw *statusResponseWriter) Write(b []byte) (int, error) {
+	if srw.statusCode == 0 {
+		srw.statusCode = http.StatusOK
+	}
+	n, err := srw.ResponseWriter.Write(b)
+	srw.written += int64(n)
+	return n, err
+}
+
+// Unwrap returns the underlying [http.ResponseWriter] for use with
+// [http.ResponseController].
+func (srw *statusResponseWriter) Unwrap() http.ResponseWriter {
+	return srw.ResponseWriter
+}
@@ -0,0 +1,92 @@
+package goproxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestNewRequestID(t *testing.T) {
+	id := newRequestID()
+	if got, want := len(id), 16; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+	if got := newRequestID(); got == id {
+		t.Errorf("got %q, want it to differ from %q", got, id)
+	}
+}
+
+func TestClientIdentity(t *testing.T) {
+	for _, tt := range []struct {
+		n    int
+		tls  *tls.ConnectionState
+		want string
+	}{
+		{1, nil, ""},
+		{2, &tls.ConnectionState{}, ""},
+		{3, &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "team-a"}}}}, ""},
+		{4, &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "team-a"}, DNSNames: []string{"a.example.com"}}}}}, "team-a"},
+		{5, &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{DNSNames: []string{"a.example.com", "b.example.com"}}}}}, "a.example.com"},
+		{6, &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{EmailAddresses: []string{"team-a@example.com"}}}}}, "team-a@example.com"},
+		{7, &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{URIs: []*url.URL{{Scheme: "spiffe", Host: "example.com", Path: "/team-a"}}}}}}, "spiffe://example.com/team-a"},
+		{8, &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}}, ""},
+	} {
+		req := httptest.NewRequest("", "/", nil)
+		req.TLS = tt.tls
+		if got := clientIdentity(req); got != tt.want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestAddRequestLogAttrs(t *testing.T) {
+	addRequestLogAttrs(context.Background(), slog.String("foo", "bar"))
+
+	rl := &requestLog{}
+	ctx := withRequestLog(context.Background(), rl)
+	addRequestLogAttrs(ctx, slog.String("foo", "bar"))
+	addRequestLogAttrs(ctx, slog.Int("baz", 1), slog.Bool("qux", true))
+	attrs := rl.Attrs()
+	if got, want := len(attrs), 3; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+	for i, want := range []slog.Attr{slog.String("foo", "bar"), slog.Int("baz", 1), slog.Bool("qux", true)} {
+		if got := attrs[i]; !got.Equal(want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestStatusResponseWriter(t *testing.T) {
+	for _, tt := range []struct {
+		n              int
+		writeHeader    int
+		write          bool
+		wantStatusCode int
+	}{
+		{1, 0, false, 0},
+		{2, 0, true, http.StatusOK},
+		{3, http.StatusNotFound, true, http.StatusNotFound},
+	} {
+		rec := httptest.NewRecorder()
+		srw := &statusResponseWriter{ResponseWriter: rec}
+		if tt.writeHeader != 0 {
+			srw.WriteHeader(tt.writeHeader)
+		}
+		if tt.write {
+			srw.Write([]byte("foobar"))
+		}
+		if got, want := srw.statusCode, tt.wantStatusCode; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if got, want := srw.Unwrap(), http.ResponseWriter(rec); got != want {
+			t.Errorf("test(%d): got %v, want %v", tt.n, got, want)
+		}
+	}
+}
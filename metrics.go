@@ -0,0 +1,318 @@
+package goproxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricsEndpoints is the list of endpoint labels used by [metrics].
+var metricsEndpoints = []string{"latest", "list", "info", "mod", "zip", "sumdb"}
+
+// metricsFetchDurationBuckets is the list of upper bounds (in seconds) of the
+// fetch duration histogram buckets used by [metrics].
+var metricsFetchDurationBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600}
+
+// metricsMaxModulePathPrefixes is the maximum number of distinct module path
+// prefixes tracked by [metrics]. Any prefix beyond it is recorded as "other"
+// so that clients requesting random module paths cannot blow up the label
+// cardinality.
+const metricsMaxModulePathPrefixes = 100
+
+// metricsMaxClients is the maximum number of distinct client identities
+// tracked by [metrics]. Any identity beyond it is recorded as "other".
+const metricsMaxClients = 100
+
+// metrics is a set of metrics collected by [Goproxy]. It is safe for
+// concurrent use.
+type metrics struct {
+	mutex                 sync.Mutex
+	cacheHits             map[string]uint64
+	cacheMisses           map[string]uint64
+	fetchDurations        map[string]*metricsHistogram
+	fetchErrors           map[string]uint64
+	clientRequests        map[string]uint64
+	circuitBreakerStates  map[string]circuitBreakerState
+	directFetchesInFlight int64
+	requestsShed          map[string]uint64
+	cacheEvictionRuns     uint64
+	cacheEvictedEntries   uint64
+	cacheEvictedBytes     uint64
+	lastEvictedEntries    int64
+	lastEvictedBytes      int64
+}
+
+// metricsHistogram is a histogram of [metrics].
+type metricsHistogram struct {
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+// newMetrics returns a new [metrics].
+func newMetrics() *metrics {
+	return &metrics{
+		cacheHits:            map[string]uint64{},
+		cacheMisses:          map[string]uint64{},
+		fetchDurations:       map[string]*metricsHistogram{},
+		fetchErrors:          map[string]uint64{},
+		clientRequests:       map[string]uint64{},
+		circuitBreakerStates: map[string]circuitBreakerState{},
+		requestsShed:         map[string]uint64{},
+	}
+}
+
+// incCacheHits increments the cache hits counter for the endpoint.
+func (m *metrics) incCacheHits(endpoint string) {
+	m.mutex.Lock()
+	m.cacheHits[endpoint]++
+	m.mutex.Unlock()
+}
+
+// incCacheMisses increments the cache misses counter for the endpoint.
+func (m *metrics) incCacheMisses(endpoint string) {
+	m.mutex.Lock()
+	m.cacheMisses[endpoint]++
+	m.mutex.Unlock()
+}
+
+// cacheHitsAndMisses returns the total numbers of cache hits and misses across
+// all endpoints.
+func (m *metrics) cacheHitsAndMisses() (hits, misses uint64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, n := range m.cacheHits {
+		hits += n
+	}
+	for _, n := range m.cacheMisses {
+		misses += n
+	}
+	return hits, misses
+}
+
+// observeFetchDuration records the d in the fetch duration histogram for the
+// endpoint.
+func (m *metrics) observeFetchDuration(endpoint string, d time.Duration) {
+	seconds := d.Seconds()
+	m.mutex.Lock()
+	h, ok := m.fetchDurations[endpoint]
+	if !ok {
+		h = &metricsHistogram{buckets: make([]uint64, len(metricsFetchDurationBuckets))}
+		m.fetchDurations[endpoint] = h
+	}
+	for i, le := range metricsFetchDurationBuckets {
+		if seconds <= le {
+			h.buckets[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+	m.mutex.Unlock()
+}
+
+// incFetchErrors increments the fetch errors counter for the first path
+// element of the modulePath.
+func (m *metrics) incFetchErrors(modulePath string) {
+	prefix, _, _ := strings.Cut(modulePath, "/")
+	m.mutex.Lock()
+	if _, ok := m.fetchErrors[prefix]; !ok && len(m.fetchErrors) >= metricsMaxModulePathPrefixes {
+		prefix = "other"
+	}
+	m.fetchErrors[prefix]++
+	m.mutex.Unlock()
+}
+
+// incClientRequests increments the requests counter for the client identity
+// verified by mutual TLS.
+func (m *metrics) incClientRequests(client string) {
+	m.mutex.Lock()
+	if _, ok := m.clientRequests[client]; !ok && len(m.clientRequests) >= metricsMaxClients {
+		client = "other"
+	}
+	m.clientRequests[client]++
+	m.mutex.Unlock()
+}
+
+// setCircuitBreakerState sets the circuit breaker state gauge for the host. Any
+// host beyond [metricsMaxModulePathPrefixes] is not tracked.
+func (m *metrics) setCircuitBreakerState(host string, state circuitBreakerState) {
+	m.mutex.Lock()
+	if _, ok := m.circuitBreakerStates[host]; ok || len(m.circuitBreakerStates) < metricsMaxModulePathPrefixes {
+		m.circuitBreakerStates[host] = state
+	}
+	m.mutex.Unlock()
+}
+
+// addDirectFetchesInFlight adds the delta to the in-flight direct fetches
+// gauge.
+func (m *metrics) addDirectFetchesInFlight(delta int64) {
+	m.mutex.Lock()
+	m.directFetchesInFlight += delta
+	m.mutex.Unlock()
+}
+
+// incRequestsShed increments the shed requests counter for the reason.
+func (m *metrics) incRequestsShed(reason string) {
+	m.mutex.Lock()
+	m.requestsShed[reason]++
+	m.mutex.Unlock()
+}
+
+// observeCacheEviction records an eviction run of the cache that evicted the
+// entries totaling the bytes.
+func (m *metrics) observeCacheEviction(entries int, bytes int64) {
+	m.mutex.Lock()
+	m.cacheEvictionRuns++
+	m.cacheEvictedEntries += uint64(entries)
+	m.cacheEvictedBytes += uint64(bytes)
+	m.lastEvictedEntries = int64(entries)
+	m.lastEvictedBytes = bytes
+	m.mutex.Unlock()
+}
+
+// writeTo writes the m to the w in the Prometheus text exposition format.
+func (m *metrics) writeTo(w io.Writer) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var b strings.Builder
+
+	b.WriteString("# HELP goproxy_cache_hits_total Total number of cache hits.\n")
+	b.WriteString("# TYPE goproxy_cache_hits_total counter\n")
+	for _, endpoint := range metricsEndpoints {
+		fmt.Fprintf(&b, "goproxy_cache_hits_total{endpoint=%q} %d\n", endpoint, m.cacheHits[endpoint])
+	}
+
+	b.WriteString("# HELP goproxy_cache_misses_total Total number of cache misses.\n")
+	b.WriteString("# TYPE goproxy_cache_misses_total counter\n")
+	for _, endpoint := range metricsEndpoints {
+		fmt.Fprintf(&b, "goproxy_cache_misses_total{endpoint=%q} %d\n", endpoint, m.cacheMisses[endpoint])
+	}
+
+	b.WriteString("# HELP goproxy_fetch_duration_seconds Duration of upstream fetches.\n")
+	b.WriteString("# TYPE goproxy_fetch_duration_seconds histogram\n")
+	for _, endpoint := range metricsEndpoints {
+		h, ok := m.fetchDurations[endpoint]
+		if !ok {
+			continue
+		}
+		for i, le := range metricsFetchDurationBuckets {
+			fmt.Fprintf(&b, "goproxy_fetch_duration_seconds_bucket{endpoint=%q,le=%q} %d\n", endpoint, formatMetricsFloat(le), h.buckets[i])
+		}
+		fmt.Fprintf(&b, "goproxy_fetch_duration_seconds_bucket{endpoint=%q,le=\"+Inf\"} %d\n", endpoint, h.count)
+		fmt.Fprintf(&b, "goproxy_fetch_duration_seconds_sum{endpoint=%q} %s\n", endpoint, formatMetricsFloat(h.sum))
+		fmt.Fprintf(&b, "goproxy_fetch_duration_seconds_count{endpoint=%q} %d\n", endpoint, h.count)
+	}
+
+	b.WriteString("# HELP goproxy_fetch_errors_total Total number of fetch errors.\n")
+	b.WriteString("# TYPE goproxy_fetch_errors_total counter\n")
+	prefixes := make([]string, 0, len(m.fetchErrors))
+	for prefix := range m.fetchErrors {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	for _, prefix := range prefixes {
+		fmt.Fprintf(&b, "goproxy_fetch_errors_total{module_path_prefix=%q} %d\n", prefix, m.fetchErrors[prefix])
+	}
+
+	b.WriteString("# HELP goproxy_client_requests_total Total number of requests by client identity verified by mutual TLS.\n")
+	b.WriteString("# TYPE goproxy_client_requests_total counter\n")
+	clients := make([]string, 0, len(m.clientRequests))
+	for client := range m.clientRequests {
+		clients = append(clients, client)
+	}
+	sort.Strings(clients)
+	for _, client := range clients {
+		fmt.Fprintf(&b, "goproxy_client_requests_total{client=%q} %d\n", client, m.clientRequests[client])
+	}
+
+	b.WriteString("# HELP goproxy_circuit_breaker_state State of the circuit breaker of direct fetches by host (0 closed, 1 open, 2 half-open).\n")
+	b.WriteString("# TYPE goproxy_circuit_breaker_state gauge\n")
+	hosts := make([]string, 0, len(m.circuitBreakerStates))
+	for host := range m.circuitBreakerStates {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		fmt.Fprintf(&b, "goproxy_circuit_breaker_state{host=%q} %d\n", host, m.circuitBreakerStates[host])
+	}
+
+	b.WriteString("# HELP goproxy_direct_fetches_in_flight Number of in-flight direct fetches.\n")
+	b.WriteString("# TYPE goproxy_direct_fetches_in_flight gauge\n")
+	fmt.Fprintf(&b, "goproxy_direct_fetches_in_flight %d\n", m.directFetchesInFlight)
+
+	b.WriteString("# HELP goproxy_requests_shed_total Total number of requests shed under load by reason.\n")
+	b.WriteString("# TYPE goproxy_requests_shed_total counter\n")
+	for _, reason := range []string{loadShedReasonQueueWait, loadShedReasonGoroutines, loadShedReasonHeap} {
+		fmt.Fprintf(&b, "goproxy_requests_shed_total{reason=%q} %d\n", reason, m.requestsShed[reason])
+	}
+
+	b.WriteString("# HELP goproxy_cache_eviction_runs_total Total number of cache eviction runs.\n")
+	b.WriteString("# TYPE goproxy_cache_eviction_runs_total counter\n")
+	fmt.Fprintf(&b, "goproxy_cache_eviction_runs_total %d\n", m.cacheEvictionRuns)
+
+	b.WriteString("# HELP goproxy_cache_evicted_entries_total Total number of cache entries evicted.\n")
+	b.WriteString("# TYPE goproxy_cache_evicted_entries_total counter\n")
+	fmt.Fprintf(&b, "goproxy_cache_evicted_entries_total %d\n", m.cacheEvictedEntries)
+
+	b.WriteString("# HELP goproxy_cache_evicted_bytes_total Total number of bytes of cache entries evicted.\n")
+	b.WriteString("# TYPE goproxy_cache_evicted_bytes_total counter\n")
+	fmt.Fprintf(&b, "goproxy_cache_evicted_bytes_total %d\n", m.cacheEvictedBytes)
+
+	b.WriteString("# HELP goproxy_cache_last_evicted_entries Number of cache entries evicted by the last eviction run.\n")
+	b.WriteString("# TYPE goproxy_cache_last_evicted_entries gauge\n")
+	fmt.Fprintf(&b, "goproxy_cache_last_evicted_entries %d\n", m.lastEvictedEntries)
+
+	b.WriteString("# HELP goproxy_cache_last_evicted_bytes Number of bytes of cache entries evicted by the last eviction run.\n")
+	b.WriteString("# TYPE goproxy_cache_last_evicted_bytes gauge\n")
+	fmt.Fprintf(&b, "goproxy_cache_last_evicted_bytes %d\n", m.lastEvictedBytes)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// ServeHTTP implements [http.Handler].
+func (m *metrics) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+	default:
+		responseMethodNotAllowed(rw, req, -2)
+		return
+	}
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	setResponseCacheControlHeader(rw, -1)
+	rw.WriteHeader(http.StatusOK)
+	if req.Method != http.MethodHead {
+		m.writeTo(rw)
+	}
+}
+
+// metricsEndpoint returns the endpoint label used by [metrics] for the name.
+func metricsEndpoint(name string) string {
+	switch {
+	case strings.HasPrefix(name, "sumdb/"):
+		return "sumdb"
+	case strings.HasSuffix(name, "/@latest"):
+		return "latest"
+	case strings.HasSuffix(name, "/@v/list"):
+		return "list"
+	}
+	switch path.Ext(name) {
+	case ".mod":
+		return "mod"
+	case ".zip":
+		return "zip"
+	}
+	return "info"
+}
+
+// formatMetricsFloat formats the f for the Prometheus text exposition format.
+func formatMetricsFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,190 @@
+package goproxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	m := newMetrics()
+	m.incCacheHits("zip")
+	m.incCacheHits("zip")
+	m.incCacheMisses("list")
+	m.observeFetchDuration("mod", 200*time.Millisecond)
+	m.observeFetchDuration("mod", 3*time.Second)
+	m.incFetchErrors("example.com/foo/bar")
+	m.incFetchErrors("example.com")
+	m.incClientRequests("team-a")
+	m.incClientRequests("team-a")
+	m.incClientRequests("team-b")
+	m.setCircuitBreakerState("example.com", circuitBreakerOpen)
+	m.setCircuitBreakerState("example.org", circuitBreakerOpen)
+	m.setCircuitBreakerState("example.org", circuitBreakerClosed)
+	m.addDirectFetchesInFlight(2)
+	m.addDirectFetchesInFlight(-1)
+	m.observeCacheEviction(3, 300)
+	m.observeCacheEviction(1, 50)
+
+	var buf bytes.Buffer
+	if err := m.writeTo(&buf); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	got := buf.String()
+	for _, want := range []string{
+		"# TYPE goproxy_cache_hits_total counter\n",
+		`goproxy_cache_hits_total{endpoint="zip"} 2` + "\n",
+		`goproxy_cache_hits_total{endpoint="list"} 0` + "\n",
+		"# TYPE goproxy_cache_misses_total counter\n",
+		`goproxy_cache_misses_total{endpoint="list"} 1` + "\n",
+		"# TYPE goproxy_fetch_duration_seconds histogram\n",
+		`goproxy_fetch_duration_seconds_bucket{endpoint="mod",le="0.1"} 0` + "\n",
+		`goproxy_fetch_duration_seconds_bucket{endpoint="mod",le="0.25"} 1` + "\n",
+		`goproxy_fetch_duration_seconds_bucket{endpoint="mod",le="5"} 2` + "\n",
+		`goproxy_fetch_duration_seconds_bucket{endpoint="mod",le="+Inf"} 2` + "\n",
+		`goproxy_fetch_duration_seconds_sum{endpoint="mod"} 3.2` + "\n",
+		`goproxy_fetch_duration_seconds_count{endpoint="mod"} 2` + "\n",
+		"# TYPE goproxy_fetch_errors_total counter\n",
+		`goproxy_fetch_errors_total{module_path_prefix="example.com"} 2` + "\n",
+		"# TYPE goproxy_client_requests_total counter\n",
+		`goproxy_client_requests_total{client="team-a"} 2` + "\n",
+		`goproxy_client_requests_total{client="team-b"} 1` + "\n",
+		"# TYPE goproxy_circuit_breaker_state gauge\n",
+		`goproxy_circuit_breaker_state{host="example.com"} 1` + "\n",
+		`goproxy_circuit_breaker_state{host="example.org"} 0` + "\n",
+		"# TYPE goproxy_direct_fetches_in_flight gauge\n",
+		"goproxy_direct_fetches_in_flight 1\n",
+		"# TYPE goproxy_cache_eviction_runs_total counter\n",
+		"goproxy_cache_eviction_runs_total 2\n",
+		"goproxy_cache_evicted_entries_total 4\n",
+		"goproxy_cache_evicted_bytes_total 350\n",
+		"# TYPE goproxy_cache_last_evicted_entries gauge\n",
+		"goproxy_cache_last_evicted_entries 1\n",
+		"goproxy_cache_last_evicted_bytes 50\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("got %q, want it to contain %q", got, want)
+		}
+	}
+	if strings.Contains(got, `goproxy_fetch_duration_seconds_count{endpoint="zip"}`) {
+		t.Errorf("got %q, want it not to contain unobserved endpoints", got)
+	}
+
+	m = newMetrics()
+	for i := 0; i < metricsMaxModulePathPrefixes+10; i++ {
+		m.incFetchErrors(fmt.Sprintf("example%d.com/foobar", i))
+	}
+	if got, want := len(m.fetchErrors), metricsMaxModulePathPrefixes+1; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+	if got, want := m.fetchErrors["other"], uint64(10); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	m = newMetrics()
+	for i := 0; i < metricsMaxClients+10; i++ {
+		m.incClientRequests(fmt.Sprintf("team-%d", i))
+	}
+	if got, want := len(m.clientRequests), metricsMaxClients+1; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+	if got, want := m.clientRequests["other"], uint64(10); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	m = newMetrics()
+	for i := 0; i < metricsMaxModulePathPrefixes+10; i++ {
+		m.setCircuitBreakerState(fmt.Sprintf("example%d.com", i), circuitBreakerOpen)
+	}
+	if got, want := len(m.circuitBreakerStates), metricsMaxModulePathPrefixes; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
+
+func TestMetricsServeHTTP(t *testing.T) {
+	m := newMetrics()
+	m.incCacheHits("info")
+	for _, tt := range []struct {
+		n               int
+		method          string
+		wantStatusCode  int
+		wantContentType string
+		wantContent     string
+	}{
+		{
+			n:               1,
+			wantStatusCode:  http.StatusOK,
+			wantContentType: "text/plain; version=0.0.4; charset=utf-8",
+			wantContent:     `goproxy_cache_hits_total{endpoint="info"} 1`,
+		},
+		{
+			n:               2,
+			method:          http.MethodHead,
+			wantStatusCode:  http.StatusOK,
+			wantContentType: "text/plain; version=0.0.4; charset=utf-8",
+		},
+		{
+			n:               3,
+			method:          http.MethodPost,
+			wantStatusCode:  http.StatusMethodNotAllowed,
+			wantContentType: "text/plain; charset=utf-8",
+			wantContent:     "method not allowed",
+		},
+	} {
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(tt.method, "/metrics", nil))
+		recr := rec.Result()
+		if got, want := recr.StatusCode, tt.wantStatusCode; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if got, want := recr.Header.Get("Content-Type"), tt.wantContentType; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		if b, err := io.ReadAll(recr.Body); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		} else if got, want := string(b), tt.wantContent; !strings.Contains(got, want) || (want == "" && got != "") {
+			t.Errorf("test(%d): got %q, want it to contain %q", tt.n, got, want)
+		}
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	for _, tt := range []struct {
+		n            int
+		name         string
+		wantEndpoint string
+	}{
+		{1, "example.com/@latest", "latest"},
+		{2, "example.com/@v/list", "list"},
+		{3, "example.com/@v/v1.0.0.info", "info"},
+		{4, "example.com/@v/master.info", "info"},
+		{5, "example.com/@v/v1.0.0.mod", "mod"},
+		{6, "example.com/@v/v1.0.0.zip", "zip"},
+		{7, "sumdb/sum.golang.org/latest", "sumdb"},
+	} {
+		if got, want := metricsEndpoint(tt.name), tt.wantEndpoint; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+}
+
+func TestFormatMetricsFloat(t *testing.T) {
+	for _, tt := range []struct {
+		n    int
+		f    float64
+		want string
+	}{
+		{1, 0.1, "0.1"},
+		{2, 600, "600"},
+		{3, 1e21, "1e+21"},
+	} {
+		if got, want := formatMetricsFloat(tt.f), tt.want; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package goproxy
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// netrcLine is a machine entry of a .netrc file.
+type netrcLine struct {
+	machine  string
+	login    string
+	password string
+}
+
+// parseNetrc parses the data of a .netrc file. Entries without both a login
+// and a password are ignored, as are the "default" entry and everything after
+// it, matching the go command.
+func parseNetrc(data string) []netrcLine {
+	var (
+		lines     []netrcLine
+		l         netrcLine
+		inMacro   bool
+		hasLogin  bool
+		hasPasswd bool
+	)
+	flush := func() {
+		if l.machine != "" && hasLogin && hasPasswd {
+			lines = append(lines, l)
+		}
+		l, hasLogin, hasPasswd = netrcLine{}, false, false
+	}
+	for _, line := range strings.Split(data, "\n") {
+		if inMacro {
+			if strings.TrimSpace(line) == "" {
+				inMacro = false
+			}
+			continue
+		}
+
+		fields := strings.Fields(line)
+		for i := 0; i < len(fields); i++ {
+			switch fields[i] {
+			case "machine":
+				flush()
+				if i+1 < len(fields) {
+					l.machine = fields[i+1]
+					i++
+				}
+			case "login":
+				if i+1 < len(fields) {
+					l.login = fields[i+1]
+					hasLogin = true
+					i++
+				}
+			case "password":
+				if i+1 < len(fields) {
+					l.password = fields[i+1]
+					hasPasswd = true
+					i++
+				}
+			case "macdef":
+				// A macro definition lasts until the next blank line.
+				inMacro = true
+				i = len(fields)
+			case "default":
+				flush()
+				return lines
+			}
+		}
+	}
+	flush()
+	return lines
+}
+
+// netrcFile returns the path of the .netrc file for the env, which is the
+// value of NETRC if set, or the .netrc file in the home directory otherwise. It
+// returns an empty string if neither is available.
+func netrcFile(env []string) string {
+	var netrc, home string
+	homeKey, netrcName := "HOME", ".netrc"
+	if runtime.GOOS == "windows" {
+		homeKey, netrcName = "USERPROFILE", "_netrc"
+	}
+	for _, e := range env {
+		if k, v, ok := strings.Cut(e, "="); ok {
+			switch strings.TrimSpace(k) {
+			case "NETRC":
+				netrc = v
+			case homeKey:
+				home = v
+			}
+		}
+	}
+	if netrc != "" {
+		return netrc
+	}
+	if home != "" {
+		return filepath.Join(home, netrcName)
+	}
+	return ""
+}
+
+// readNetrc reads and parses the .netrc file for the env. A missing or
+// unreadable file results in no entries.
+func readNetrc(env []string) []netrcLine {
+	file := netrcFile(env)
+	if file == "" {
+		return nil
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+	return parseNetrc(string(b))
+}
+
+// netrcTransport is an [http.RoundTripper] that adds the credentials of the
+// .netrc entry whose machine exactly matches the hostname of each HTTPS
+// request. Requests to any other host, including those following redirects,
+// and plain HTTP requests are sent without credentials, as the go command does.
+type netrcTransport struct {
+	base  http.RoundTripper
+	lines []netrcLine
+}
+
+// RoundTrip implements [http.RoundTripper].
+func (nt *netrcTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := nt.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if req.URL.Scheme != "https" || req.Header.Get("Authorization") != "" {
+		return base.RoundTrip(req)
+	}
+	for _, l := range nt.lines {
+		if l.machine == req.URL.Hostname() {
+			req = req.Clone(req.Context())
+			req.SetBasicAuth(l.login, l.password)
+			break
+		}
+	}
+	return base.RoundTrip(req)
+}
@@ -0,0 +1,205 @@
+package goproxy
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestParseNetrc(t *testing.T) {
+	for _, tt := range []struct {
+		n         int
+		data      string
+		wantLines []netrcLine
+	}{
+		{
+			n:    1,
+			data: "machine example.com login alice password secret",
+			wantLines: []netrcLine{
+				{machine: "example.com", login: "alice", password: "secret"},
+			},
+		},
+		{
+			n: 2,
+			data: `machine example.com
+	login alice
+	password secret
+
+machine example.org login bob password hunter2
+`,
+			wantLines: []netrcLine{
+				{machine: "example.com", login: "alice", password: "secret"},
+				{machine: "example.org", login: "bob", password: "hunter2"},
+			},
+		},
+		{
+			n:    3,
+			data: "machine example.com login alice\nmachine example.org password secret",
+		},
+		{
+			n: 4,
+			data: `macdef init
+machine example.net login eve password evil
+
+machine example.com login alice password secret
+`,
+			wantLines: []netrcLine{
+				{machine: "example.com", login: "alice", password: "secret"},
+			},
+		},
+		{
+			n: 5,
+			data: `machine example.com login alice password secret
+default login anonymous password guest
+machine example.org login bob password hunter2
+`,
+			wantLines: []netrcLine{
+				{machine: "example.com", login: "alice", password: "secret"},
+			},
+		},
+		{
+			n: 6,
+		},
+	} {
+		if got, want := parseNetrc(tt.data), tt.wantLines; !reflect.DeepEqual(got, want) {
+			t.Errorf("test(%d): got %+v, want %+v", tt.n, got, want)
+		}
+	}
+}
+
+func TestNetrcFile(t *testing.T) {
+	homeKey, netrcName := "HOME", ".netrc"
+	if runtime.GOOS == "windows" {
+		homeKey, netrcName = "USERPROFILE", "_netrc"
+	}
+	for _, tt := range []struct {
+		n    int
+		env  []string
+		want string
+	}{
+		{1, []string{"NETRC=/foo/netrc", homeKey + "=/home"}, "/foo/netrc"},
+		{2, []string{homeKey + "=/home"}, filepath.Join("/home", netrcName)},
+		{3, []string{"NETRC=", homeKey + "=/home"}, filepath.Join("/home", netrcName)},
+		{4, nil, ""},
+	} {
+		if got, want := netrcFile(tt.env), tt.want; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+}
+
+func TestReadNetrc(t *testing.T) {
+	netrc := filepath.Join(t.TempDir(), "netrc")
+	if err := os.WriteFile(netrc, []byte("machine example.com login alice password secret"), 0o600); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if got, want := readNetrc([]string{"NETRC=" + netrc}), []netrcLine{{machine: "example.com", login: "alice", password: "secret"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got := readNetrc([]string{"NETRC=" + filepath.Join(t.TempDir(), "404")}); got != nil {
+		t.Errorf("got %+v, want nil", got)
+	}
+}
+
+func TestNetrcTransport(t *testing.T) {
+	var gotAuthorization string
+	nt := &netrcTransport{
+		base: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			gotAuthorization = req.Header.Get("Authorization")
+			return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+		}),
+		lines: []netrcLine{{machine: "ghe.example.com", login: "alice", password: "secret"}},
+	}
+	for _, tt := range []struct {
+		n                 int
+		url               string
+		authorization     string
+		wantAuthorization string
+	}{
+		{1, "https://ghe.example.com/foo/@v/list", "", "Basic YWxpY2U6c2VjcmV0"},
+		{2, "https://ghe.example.com:8443/foo/@v/list", "", "Basic YWxpY2U6c2VjcmV0"},
+		{3, "https://github.com/fork/foo/@v/list", "", ""},
+		{4, "https://ghe.example.com.evil.com/foo/@v/list", "", ""},
+		{5, "https://sub.ghe.example.com/foo/@v/list", "", ""},
+		{6, "https://ghe.example.com/foo/@v/list", "Bearer foobar", "Bearer foobar"},
+		{7, "http://ghe.example.com/foo/@v/list", "", ""},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		if _, err := nt.RoundTrip(req); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		}
+		if got, want := gotAuthorization, tt.wantAuthorization; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		if got, want := req.Header.Get("Authorization"), tt.authorization; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestGoproxyNetrc(t *testing.T) {
+	proxyServer := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if username, password, ok := req.BasicAuth(); !ok || username != "alice" || password != "secret" {
+			responseNotFound(rw, req, -2, "unauthorized")
+			return
+		}
+		responseSuccess(rw, req, strings.NewReader("v1.0.0"), "text/plain; charset=utf-8", -2)
+	}))
+	defer proxyServer.Close()
+
+	netrc := filepath.Join(t.TempDir(), "netrc")
+	if err := os.WriteFile(netrc, []byte("machine 127.0.0.1 login alice password secret"), 0o600); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	for _, tt := range []struct {
+		n              int
+		useNetrc       bool
+		wantStatusCode int
+		wantContent    string
+	}{
+		{1, true, http.StatusOK, "v1.0.0"},
+		{2, false, http.StatusNotFound, "not found: unauthorized"},
+	} {
+		var logs strings.Builder
+		g := &Goproxy{
+			Env:         []string{"GOPROXY=" + proxyServer.URL, "GOSUMDB=off", "NETRC=" + netrc},
+			Transport:   proxyServer.Client().Transport,
+			UseNetrc:    tt.useNetrc,
+			ErrorLogger: log.New(&logs, "", 0),
+		}
+		g.init()
+		if got, want := getenv(g.env, "NETRC"), netrc; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/example.com/@v/list", nil))
+		recr := rec.Result()
+		if got, want := recr.StatusCode, tt.wantStatusCode; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if b, err := io.ReadAll(recr.Body); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		} else if got, want := string(b), tt.wantContent; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		if strings.Contains(logs.String(), "secret") {
+			t.Errorf("test(%d): credentials leaked into logs: %q", tt.n, logs.String())
+		}
+	}
+}
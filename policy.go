@@ -0,0 +1,90 @@
+package goproxy
+
+import (
+	"strings"
+)
+
+// Policy is the part of the configuration of a [Goproxy] that decides which
+// requests it serves. Unlike the rest of the configuration, it can be replaced
+// while the Goproxy is serving requests. See [Goproxy.SetPolicy].
+type Policy struct {
+	// AllowedModulePatterns is the same as [Goproxy.AllowedModulePatterns].
+	AllowedModulePatterns []string
+
+	// BlockedModulePatterns is the same as [Goproxy.BlockedModulePatterns].
+	BlockedModulePatterns []string
+
+	// ApprovedVersions is the same as [Goproxy.ApprovedVersions].
+	ApprovedVersions *ApprovedVersions
+
+	// LatestVersionPolicies is the same as [Goproxy.LatestVersionPolicies].
+	LatestVersionPolicies *LatestVersionPolicies
+
+	// RateLimit is the same as [Goproxy.RateLimit].
+	RateLimit float64
+
+	// RateBurst is the same as [Goproxy.RateBurst].
+	RateBurst int
+
+	// Quotas is the same as [Goproxy.Quotas].
+	Quotas *Quotas
+}
+
+// SetPolicy replaces the policy of the g, which is initially made of the
+// AllowedModulePatterns, BlockedModulePatterns, ApprovedVersions,
+// LatestVersionPolicies, RateLimit, RateBurst, and Quotas of the g, with the p.
+// Each module request is served entirely under either the old or the new
+// policy, never a mix of both. If the rate limit and burst are unchanged, the
+// requests already counted against them are kept. Usage counted against quotas
+// is always kept, since it lives in the [Goproxy.QuotaStore].
+//
+// SetPolicy is safe for concurrent use, including while the g is serving
+// requests.
+func (g *Goproxy) SetPolicy(p Policy) {
+	g.initOnce.Do(g.init)
+	g.policy.Store(newPolicy(p, g.policy.Load()))
+}
+
+// policy is a [Policy] in the form used to serve requests.
+type policy struct {
+	allowedModulePatterns string
+	blockedModulePatterns string
+	approvedVersions      *ApprovedVersions
+	latestVersionPolicies *LatestVersionPolicies
+	rateLimiter           *rateLimiter
+	quotas                *Quotas
+}
+
+// newPolicy returns a new [policy] from the p. The rate limiter of the current
+// one, if any, is reused when its rate and burst are unchanged.
+func newPolicy(p Policy, current *policy) *policy {
+	np := &policy{
+		allowedModulePatterns: joinModulePatterns(p.AllowedModulePatterns),
+		blockedModulePatterns: joinModulePatterns(p.BlockedModulePatterns),
+		approvedVersions:      p.ApprovedVersions,
+		latestVersionPolicies: p.LatestVersionPolicies,
+		quotas:                p.Quotas,
+	}
+	if p.RateLimit > 0 {
+		np.rateLimiter = newRateLimiter(p.RateLimit, p.RateBurst)
+		if current != nil && current.rateLimiter != nil &&
+			current.rateLimiter.rate == np.rateLimiter.rate &&
+			current.rateLimiter.burst == np.rateLimiter.burst {
+			np.rateLimiter = current.rateLimiter
+		}
+	}
+	return np
+}
+
+// checkModulePath checks whether the modulePath is allowed to be served
+// according to the allowed and blocked module patterns of the p.
+func (p *policy) checkModulePath(modulePath string) error {
+	lowerModulePath := strings.ToLower(modulePath)
+	if globsMatchPath(p.blockedModulePatterns, lowerModulePath) {
+		return &PolicyError{ModulePath: modulePath, Reason: "blocked"}
+	}
+	if p.allowedModulePatterns != "" && !globsMatchPath(p.allowedModulePatterns, lowerModulePath) {
+		return &PolicyError{ModulePath: modulePath, Reason: "not allowed"}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package goproxy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGoproxySetPolicy(t *testing.T) {
+	g := &Goproxy{Offline: true, Cacher: &MemoryCacher{}, RateLimit: 1}
+	get := func() int {
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/example.com/@v/list", nil))
+		return rec.Result().StatusCode
+	}
+	if got, want := get(), http.StatusNotFound; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	g.SetPolicy(Policy{BlockedModulePatterns: []string{"example.com"}, RateLimit: 1})
+	if got, want := get(), http.StatusTooManyRequests; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	g.SetPolicy(Policy{BlockedModulePatterns: []string{"example.com"}, RateLimit: 2})
+	if got, want := get(), http.StatusForbidden; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	g.SetPolicy(Policy{})
+	for i := 0; i < 3; i++ {
+		if got, want := get(), http.StatusNotFound; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+	}
+}
+
+func TestPolicyCheckModulePath(t *testing.T) {
+	for _, tt := range []struct {
+		n                     int
+		allowedModulePatterns []string
+		blockedModulePatterns []string
+		modulePath            string
+		wantError             error
+	}{
+		{1, nil, nil, "example.com", nil},
+		{2, nil, []string{"github.com/evil/*"}, "github.com/evil/foo", errors.New("module github.com/evil/foo is blocked by this proxy")},
+		{3, nil, []string{"github.com/evil/*"}, "github.com/evil/foo/v2", errors.New("module github.com/evil/foo/v2 is blocked by this proxy")},
+		{4, nil, []string{"github.com/evil/*"}, "github.com/Evil/Foo", errors.New("module github.com/Evil/Foo is blocked by this proxy")},
+		{5, nil, []string{"github.com/evil/*"}, "github.com/evilcorp/foo", nil},
+		{6, []string{"github.com/mycompany/*", " golang.org ", ""}, nil, "github.com/mycompany/foo", nil},
+		{7, []string{"github.com/mycompany/*", " golang.org ", ""}, nil, "golang.org/x/mod", nil},
+		{8, []string{"github.com/mycompany/*", " golang.org ", ""}, nil, "example.com", errors.New("module example.com is not allowed by this proxy")},
+		{9, []string{"github.com"}, []string{"github.com/evil"}, "github.com/evil/foo", errors.New("module github.com/evil/foo is blocked by this proxy")},
+		{10, []string{"github.com"}, []string{"github.com/evil"}, "github.com/mycompany/foo", nil},
+	} {
+		g := &Goproxy{
+			AllowedModulePatterns: tt.allowedModulePatterns,
+			BlockedModulePatterns: tt.blockedModulePatterns,
+		}
+		g.init()
+		err := g.policy.Load().checkModulePath(tt.modulePath)
+		if tt.wantError != nil {
+			if err == nil {
+				t.Fatalf("test(%d): expected error", tt.n)
+			}
+			if got, want := err.Error(), tt.wantError.Error(); got != want {
+				t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+			}
+		} else if err != nil {
+			t.Errorf("test(%d): unexpected error %q", tt.n, err)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+
+	"golang.org/x/mod/module"
+)
+
+// prefetchDefaultConcurrency is the maximum number of module versions
+// prefetched concurrently by [Goproxy.AdminHandler] if the
+// [Goproxy.MaxDirectFetches] and [Goproxy.MaxDirectDownloads] are zero.
+const prefetchDefaultConcurrency = 8
+
+// Prefetch fetches the info, mod, and zip files of the module version with the
+// modulePath and moduleVersion, and caches them in the g.Cacher so that later
+// requests for them are served from the cache. Files that are already cached
+// are not fetched again, so calling Prefetch repeatedly is cheap.
+//
+// The moduleVersion must be a canonical version rather than a query. Prefetch
+// fails if the g.Cacher is nil, the g is [Goproxy.Offline], or the module is
+// denied by the [Goproxy.AllowedModulePatterns] or
+// [Goproxy.BlockedModulePatterns].
+func (g *Goproxy) Prefetch(ctx context.Context, modulePath, moduleVersion string) error {
+	g.initOnce.Do(g.init)
+	_, err := g.prefetch(ctx, modulePath, moduleVersion, true)
+	return err
+}
+
+// prefetch implements [Goproxy.Prefetch], but skips the zip file unless
+// withZip is true. It reports whether all the files of the module version were
+// already cached.
+func (g *Goproxy) prefetch(ctx context.Context, modulePath, moduleVersion string, withZip bool) (cached bool, err error) {
+	if g.Cacher == nil {
+		return false, errors.New("no cacher")
+	}
+	if g.Offline {
+		return false, errors.New("prefetching is disabled in offline mode")
+	}
+	if err := module.Check(modulePath, moduleVersion); err != nil {
+		return false, err
+	}
+	if module.CanonicalVersion(moduleVersion) != moduleVersion {
+		return false, &module.ModuleError{
+			Path: modulePath,
+			Err:  &module.InvalidVersionError{Version: moduleVersion, Err: errors.New("not a canonical version")},
+		}
+	}
+	if err := g.policy.Load().checkModulePath(modulePath); err != nil {
+		return false, err
+	}
+	escapedModulePath, err := module.EscapePath(modulePath)
+	if err != nil {
+		return false, err
+	}
+	escapedModuleVersion, err := module.EscapeVersion(moduleVersion)
+	if err != nil {
+		return false, err
+	}
+	nameWithoutExt := escapedModulePath + "/@v/" + escapedModuleVersion
+
+	tempDir, err := os.MkdirTemp(g.TempDir, "goproxy.tmp.*")
+	if err != nil {
+		return false, err
+	}
+	defer os.RemoveAll(tempDir)
+
+	exts := []string{".info", ".mod"}
+	if withZip {
+		exts = append(exts, ".zip")
+	}
+	cached = true
+	for _, ext := range exts {
+		name := nameWithoutExt + ext
+		if rc, err := g.cache(ctx, name); err == nil {
+			rc.Close()
+			continue
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return false, err
+		}
+		cached = false
+
+		f, err := newFetch(g, name, tempDir)
+		if err != nil {
+			return false, err
+		}
+		fr, release, err := g.doFetch(ctx, f)
+		if err != nil {
+			return false, err
+		}
+		err = g.putFetchDownloadCaches(ctx, nameWithoutExt, fr, nil)
+		release()
+		if err != nil {
+			return false, err
+		}
+	}
+	return cached, nil
+}
+
+// prefetchResult is the result of prefetching a module version.
+type prefetchResult struct {
+	Path    string
+	Version string
+	Cached  bool   `json:",omitempty"`
+	Error   string `json:",omitempty"`
+}
+
+// prefetchAll prefetches the module versions of the mvs concurrently, with at
+// most the g.MaxDirectDownloads or g.MaxDirectFetches, whichever is smaller and
+// not zero, or [prefetchDefaultConcurrency] if both are zero, at the same time.
+// The zip files of the module versions in the goModOnly are skipped. The
+// results are in the same order as the mvs.
+func (g *Goproxy) prefetchAll(ctx context.Context, mvs []module.Version, goModOnly map[module.Version]bool) []prefetchResult {
+	concurrency := g.MaxDirectFetches
+	if g.MaxDirectDownloads > 0 && (concurrency <= 0 || g.MaxDirectDownloads < concurrency) {
+		concurrency = g.MaxDirectDownloads
+	}
+	if concurrency <= 0 {
+		concurrency = prefetchDefaultConcurrency
+	}
+	results := make([]prefetchResult, len(mvs))
+	var (
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, concurrency)
+	)
+	for i, mv := range mvs {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, mv module.Version) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			result := prefetchResult{Path: mv.Path, Version: mv.Version}
+			cached, err := g.prefetch(ctx, mv.Path, mv.Version, !goModOnly[mv])
+			if err != nil {
+				g.logErrorf("failed to prefetch module version: %s@%s: %v", mv.Path, mv.Version, err)
+				result.Error = err.Error()
+			} else {
+				result.Cached = cached
+			}
+			results[i] = result
+		}(i, mv)
+	}
+	wg.Wait()
+	return results
+}
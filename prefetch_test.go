@@ -0,0 +1,118 @@
+package goproxy
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newPrefetchTestProxyServer(t *testing.T) (server interface{ Close() }, url string, requests func() []string) {
+	zipFile := filepath.Join(t.TempDir(), "zip")
+	if err := writeZipFile(zipFile, map[string][]byte{"example.com@v1.0.0/go.mod": []byte("module example.com")}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	info := marshalInfo("v1.0.0", time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+	var (
+		mutex sync.Mutex
+		paths []string
+	)
+	proxyServer, setProxyHandler := newHTTPTestServer()
+	setProxyHandler(func(rw http.ResponseWriter, req *http.Request) {
+		mutex.Lock()
+		paths = append(paths, req.URL.Path)
+		mutex.Unlock()
+		switch req.URL.Path {
+		case "/example.com/@v/v1.0.0.info":
+			responseSuccess(rw, req, strings.NewReader(info), "application/json; charset=utf-8", -2)
+		case "/example.com/@v/v1.0.0.mod":
+			responseSuccess(rw, req, strings.NewReader("module example.com"), "text/plain; charset=utf-8", -2)
+		case "/example.com/@v/v1.0.0.zip":
+			http.ServeFile(rw, req, zipFile)
+		default:
+			responseNotFound(rw, req, -2)
+		}
+	})
+	return proxyServer, proxyServer.URL, func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]string(nil), paths...)
+	}
+}
+
+func TestGoproxyPrefetch(t *testing.T) {
+	proxyServer, proxyURL, proxyRequests := newPrefetchTestProxyServer(t)
+	defer proxyServer.Close()
+
+	g := &Goproxy{
+		Env:                   []string{"GOPROXY=" + proxyURL, "GOSUMDB=off"},
+		Cacher:                &MemoryCacher{},
+		TempDir:               t.TempDir(),
+		BlockedModulePatterns: []string{"example.org"},
+		ErrorLogger:           log.New(io.Discard, "", 0),
+	}
+	if err := g.Prefetch(context.Background(), "example.com", "v1.0.0"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if got, want := strings.Join(proxyRequests(), ","), "/example.com/@v/v1.0.0.info,/example.com/@v/v1.0.0.mod,/example.com/@v/v1.0.0.zip"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	for _, name := range []string{
+		"example.com/@v/v1.0.0.info",
+		"example.com/@v/v1.0.0.mod",
+		"example.com/@v/v1.0.0.zip",
+		"example.com/@v/v1.0.0.ziphash",
+	} {
+		rc, err := g.Cacher.Get(context.Background(), name)
+		if err != nil {
+			t.Fatalf("%s: unexpected error %q", name, err)
+		}
+		rc.Close()
+	}
+
+	if cached, err := g.prefetch(context.Background(), "example.com", "v1.0.0", true); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if !cached {
+		t.Error("want cached")
+	}
+	if got, want := len(proxyRequests()), 3; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	for _, tt := range []struct {
+		n             int
+		modulePath    string
+		moduleVersion string
+		wantError     string
+	}{
+		{1, "example.com", "latest", `example.com@latest: invalid version: not a semantic version`},
+		{2, "example.com", "v1.0", `example.com@v1.0: invalid version: not a canonical version`},
+		{3, "example.org", "v1.0.0", "module example.org is blocked by this proxy"},
+		{4, "example.com", "v1.1.0", "not found"},
+	} {
+		if err := g.Prefetch(context.Background(), tt.modulePath, tt.moduleVersion); err == nil {
+			t.Errorf("test(%d): expected error", tt.n)
+		} else if got, want := err.Error(), tt.wantError; !strings.Contains(got, want) {
+			t.Errorf("test(%d): got %q, want it to contain %q", tt.n, got, want)
+		}
+	}
+
+	g = &Goproxy{Offline: true, Cacher: &MemoryCacher{}}
+	if err := g.Prefetch(context.Background(), "example.com", "v1.0.0"); err == nil {
+		t.Fatal("expected error")
+	} else if got, want := err.Error(), "prefetching is disabled in offline mode"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	g = &Goproxy{}
+	if err := g.Prefetch(context.Background(), "example.com", "v1.0.0"); err == nil {
+		t.Fatal("expected error")
+	} else if got, want := err.Error(), "no cacher"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,191 @@
+package goproxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryQuotaStoreBucketWidth is the width of the time buckets in which a
+// [MemoryQuotaStore] records usage.
+const memoryQuotaStoreBucketWidth = time.Minute
+
+// Quotas is a set of limits on the number of bytes served to the identities of
+// clients over rolling windows. See [ParseQuotas] and [Goproxy.Quotas].
+type Quotas struct {
+	quotas map[string]quota
+}
+
+// quota is a limit of a [Quotas].
+type quota struct {
+	bytes  int64
+	window time.Duration
+}
+
+// ParseQuotas parses the data as a [Quotas].
+//
+// Each non-empty line of the data, except for those starting with "#", is a
+// quota in the form "<identity> <bytes> <window>". The identity is that of the
+// clients as logged in the "client" attribute, or "*" for all identities that
+// have no quota of their own. The bytes is the maximum number of bytes served
+// to the identity within any window, which is a duration in the form accepted
+// by [time.ParseDuration].
+//
+// For example:
+//
+//	# 100 GB a month for the data team, 10 GB a day for everyone else.
+//	team-data 100000000000 720h
+//	* 10000000000 24h
+func ParseQuotas(data []byte) (*Quotas, error) {
+	q := &Quotas{quotas: map[string]quota{}}
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for lineNum := 1; s.Scan(); lineNum++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: want \"<identity> <bytes> <window>\"", lineNum)
+		}
+		identity := fields[0]
+		if _, ok := q.quotas[identity]; ok {
+			return nil, fmt.Errorf("line %d: duplicate identity %q", lineNum, identity)
+		}
+		n, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("line %d: invalid bytes %q", lineNum, fields[1])
+		}
+		window, err := time.ParseDuration(fields[2])
+		if err != nil || window <= 0 {
+			return nil, fmt.Errorf("line %d: invalid window %q", lineNum, fields[2])
+		}
+		q.quotas[identity] = quota{bytes: n, window: window}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// quota returns the quota of the identity, falling back to that of "*", and
+// reports whether there is one. Anonymous clients never have a quota.
+func (q *Quotas) quota(identity string) (quota, bool) {
+	if q == nil || identity == "" {
+		return quota{}, false
+	}
+	if qt, ok := q.quotas[identity]; ok {
+		return qt, true
+	}
+	qt, ok := q.quotas["*"]
+	return qt, ok
+}
+
+// QuotaStore records the numbers of bytes served to the identities of clients,
+// against which their [Goproxy.Quotas] are checked. Implementations backed by
+// shared storage let several Goproxy instances, and restarts of them, enforce
+// the same quotas.
+//
+// All methods of QuotaStore must be safe for concurrent use.
+type QuotaStore interface {
+	// AddUsage records that n bytes were served to the identity at the t.
+	AddUsage(ctx context.Context, identity string, t time.Time, n int64) error
+
+	// Usage returns the number of bytes served to the identity since the
+	// since. Usage recorded before the since may be discarded.
+	Usage(ctx context.Context, identity string, since time.Time) (int64, error)
+}
+
+// MemoryQuotaStore implements [QuotaStore] using memory, so its usage is lost
+// when the process exits. Usage is recorded in one-minute buckets, so windows
+// are rounded up to whole minutes.
+//
+// The zero value is ready for use.
+type MemoryQuotaStore struct {
+	mutex   sync.Mutex
+	buckets map[string][]quotaUsageBucket
+}
+
+// quotaUsageBucket is a time bucket of a [MemoryQuotaStore].
+type quotaUsageBucket struct {
+	start time.Time
+	bytes int64
+}
+
+// AddUsage implements [QuotaStore].
+func (mqs *MemoryQuotaStore) AddUsage(ctx context.Context, identity string, t time.Time, n int64) error {
+	start := t.Truncate(memoryQuotaStoreBucketWidth)
+	mqs.mutex.Lock()
+	defer mqs.mutex.Unlock()
+	if mqs.buckets == nil {
+		mqs.buckets = map[string][]quotaUsageBucket{}
+	}
+	buckets := mqs.buckets[identity]
+	if len(buckets) > 0 && !buckets[len(buckets)-1].start.Before(start) {
+		// Usage is added in roughly chronological order, so a usage
+		// that arrives late is counted in the latest bucket.
+		buckets[len(buckets)-1].bytes += n
+		return nil
+	}
+	mqs.buckets[identity] = append(buckets, quotaUsageBucket{start: start, bytes: n})
+	return nil
+}
+
+// Usage implements [QuotaStore].
+func (mqs *MemoryQuotaStore) Usage(ctx context.Context, identity string, since time.Time) (int64, error) {
+	since = since.Truncate(memoryQuotaStoreBucketWidth)
+	mqs.mutex.Lock()
+	defer mqs.mutex.Unlock()
+	buckets := mqs.buckets[identity]
+	i := 0
+	for i < len(buckets) && buckets[i].start.Before(since) {
+		i++
+	}
+	if i == len(buckets) {
+		delete(mqs.buckets, identity)
+		return 0, nil
+	}
+	buckets = buckets[i:]
+	mqs.buckets[identity] = buckets
+	var usage int64
+	for _, b := range buckets {
+		usage += b.bytes
+	}
+	return usage, nil
+}
+
+// checkQuota reports whether the client is within its quota under the p at the
+// now. A client without a quota is always within it. Errors of the
+// g.quotaStore are logged, and the client is considered within its quota, so
+// that a failing store does not take the g down with it.
+func (g *Goproxy) checkQuota(ctx context.Context, p *policy, client string, now time.Time) bool {
+	qt, ok := p.quotas.quota(client)
+	if !ok {
+		return true
+	}
+	usage, err := g.quotaStore.Usage(ctx, client, now.Add(-qt.window))
+	if err != nil {
+		g.logErrorf("failed to get quota usage of %q: %v", client, err)
+		return true
+	}
+	return usage < qt.bytes
+}
+
+// addQuotaUsage records the n bytes served to the client at the now if the
+// client has a quota under the p.
+func (g *Goproxy) addQuotaUsage(ctx context.Context, p *policy, client string, now time.Time, n int64) {
+	if n <= 0 {
+		return
+	}
+	if _, ok := p.quotas.quota(client); !ok {
+		return
+	}
+	if err := g.quotaStore.AddUsage(ctx, client, now, n); err != nil {
+		g.logErrorf("failed to add quota usage of %q: %v", client, err)
+	}
+}
@@ -0,0 +1,164 @@
+package goproxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseQuotas(t *testing.T) {
+	for _, tt := range []struct {
+		n          int
+		data       string
+		wantQuotas int
+		wantError  string
+	}{
+		{1, "", 0, ""},
+		{2, "# comment\n\nteam-a 1000 24h\n* 100 1h\n", 2, ""},
+		{3, "team-a 1000\n", 0, `line 1: want "<identity> <bytes> <window>"`},
+		{4, "\nteam-a many 24h\n", 0, `line 2: invalid bytes "many"`},
+		{5, "team-a -1 24h\n", 0, `line 1: invalid bytes "-1"`},
+		{6, "team-a 1000 daily\n", 0, `line 1: invalid window "daily"`},
+		{7, "team-a 1000 0s\n", 0, `line 1: invalid window "0s"`},
+		{8, "team-a 1000 24h\nteam-a 2000 24h\n", 0, `line 2: duplicate identity "team-a"`},
+	} {
+		q, err := ParseQuotas([]byte(tt.data))
+		if tt.wantError != "" {
+			if err == nil {
+				t.Fatalf("test(%d): expected error", tt.n)
+			}
+			if got, want := err.Error(), tt.wantError; got != want {
+				t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+			}
+		} else if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		} else if got, want := len(q.quotas), tt.wantQuotas; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+	}
+
+	q, err := ParseQuotas([]byte("team-a 1000 24h\n* 100 1h\n"))
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	for _, tt := range []struct {
+		n         int
+		identity  string
+		wantQuota quota
+		wantOK    bool
+	}{
+		{1, "team-a", quota{bytes: 1000, window: 24 * time.Hour}, true},
+		{2, "team-b", quota{bytes: 100, window: time.Hour}, true},
+		{3, "", quota{}, false},
+	} {
+		qt, ok := q.quota(tt.identity)
+		if got, want := qt, tt.wantQuota; got != want {
+			t.Errorf("test(%d): got %v, want %v", tt.n, got, want)
+		}
+		if got, want := ok, tt.wantOK; got != want {
+			t.Errorf("test(%d): got %v, want %v", tt.n, got, want)
+		}
+	}
+	if _, ok := (*Quotas)(nil).quota("team-a"); ok {
+		t.Error("expected no quota")
+	}
+}
+
+func TestMemoryQuotaStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	mqs := &MemoryQuotaStore{}
+	for _, u := range []struct {
+		t time.Time
+		n int64
+	}{
+		{now, 1},
+		{now.Add(30 * time.Second), 2},
+		{now.Add(time.Hour), 4},
+		{now.Add(2 * time.Hour), 8},
+		{now.Add(time.Hour), 16},
+	} {
+		if err := mqs.AddUsage(ctx, "team-a", u.t, u.n); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+	for _, tt := range []struct {
+		n         int
+		identity  string
+		since     time.Time
+		wantUsage int64
+	}{
+		{1, "team-a", now, 31},
+		{2, "team-a", now.Add(time.Hour), 28},
+		{3, "team-a", now.Add(2 * time.Hour), 24},
+		{4, "team-b", now, 0},
+		{5, "team-a", now.Add(3 * time.Hour), 0},
+		{6, "team-a", now, 0},
+	} {
+		usage, err := mqs.Usage(ctx, tt.identity, tt.since)
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		}
+		if got, want := usage, tt.wantUsage; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+	}
+}
+
+func TestGoproxyServeHTTPQuotas(t *testing.T) {
+	cacher := &MemoryCacher{}
+	if err := cacher.Put(context.Background(), "example.com/@v/list", strings.NewReader("v1.0.0\n")); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	q, err := ParseQuotas([]byte("team-a 10 1h\n"))
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	g := &Goproxy{
+		Cacher:        cacher,
+		Offline:       true,
+		Authenticator: BearerTokenAuthenticator{"a": "team-a", "b": "team-b"},
+		Quotas:        q,
+		Now:           func() time.Time { return now },
+	}
+	for _, tt := range []struct {
+		n              int
+		token          string
+		advance        time.Duration
+		wantStatusCode int
+		wantContent    string
+	}{
+		{1, "a", 0, http.StatusOK, "v1.0.0\n"},
+		{2, "b", 0, http.StatusOK, "v1.0.0\n"},
+		{3, "a", 0, http.StatusOK, "v1.0.0\n"},
+		{4, "a", 0, http.StatusTooManyRequests, "too many requests: quota exceeded"},
+		{5, "b", 0, http.StatusOK, "v1.0.0\n"},
+		{6, "a", time.Hour + time.Minute, http.StatusOK, "v1.0.0\n"},
+	} {
+		now = now.Add(tt.advance)
+		req := httptest.NewRequest(http.MethodGet, "/example.com/@v/list", nil)
+		req.Header.Set("Authorization", "Bearer "+tt.token)
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, req)
+		recr := rec.Result()
+		if got, want := recr.StatusCode, tt.wantStatusCode; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if b, err := io.ReadAll(recr.Body); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		} else if got, want := string(b), tt.wantContent; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+
+	if usage, err := g.quotaStore.Usage(context.Background(), "team-b", time.Time{}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := usage, int64(0); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
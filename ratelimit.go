@@ -0,0 +1,147 @@
+package goproxy
+
+import (
+	"math"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimiterSweepInterval is the minimum interval between sweeps of the idle
+// buckets of a [rateLimiter].
+const rateLimiterSweepInterval = time.Minute
+
+// rateLimiter is a token bucket rate limiter with one bucket per key. It is
+// safe for concurrent use.
+type rateLimiter struct {
+	rate      float64
+	burst     float64
+	mutex     sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// tokenBucket is a bucket of a [rateLimiter].
+type tokenBucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// newRateLimiter returns a new [rateLimiter] that allows rate events per second
+// with the burst for each key. A non-positive burst means the ceiling of the
+// rate.
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst <= 0 {
+		burst = int(math.Ceil(rate))
+	}
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: map[string]*tokenBucket{},
+	}
+}
+
+// allow reports whether an event for the key may happen at the now. If not, it
+// also returns how long until the event may happen.
+func (rl *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	if now.Sub(rl.lastSweep) >= rateLimiterSweepInterval {
+		rl.sweep(now)
+	}
+
+	b, ok := rl.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: rl.burst, updatedAt: now}
+		rl.buckets[key] = b
+	}
+	b.tokens = rl.tokensAt(b, now)
+	b.updatedAt = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / rl.rate * float64(time.Second))
+}
+
+// tokensAt returns the tokens of the b at the now.
+func (rl *rateLimiter) tokensAt(b *tokenBucket, now time.Time) float64 {
+	elapsed := now.Sub(b.updatedAt)
+	if elapsed <= 0 {
+		return b.tokens
+	}
+	return math.Min(rl.burst, b.tokens+elapsed.Seconds()*rl.rate)
+}
+
+// sweep removes the buckets that have been refilled by the now, since they
+// are equivalent to new ones.
+func (rl *rateLimiter) sweep(now time.Time) {
+	for key, b := range rl.buckets {
+		if rl.tokensAt(b, now) >= rl.burst {
+			delete(rl.buckets, key)
+		}
+	}
+	rl.lastSweep = now
+}
+
+// parseTrustedProxies parses the trustedProxies, each of which is an IP
+// address or a CIDR prefix. Invalid entries are ignored.
+func parseTrustedProxies(trustedProxies []string) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, trustedProxy := range trustedProxies {
+		trustedProxy = strings.TrimSpace(trustedProxy)
+		if prefix, err := netip.ParsePrefix(trustedProxy); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+		} else if addr, err := netip.ParseAddr(trustedProxy); err == nil {
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+		}
+	}
+	return prefixes
+}
+
+// isTrustedProxy reports whether the addr matches any of the trustedProxies.
+func isTrustedProxy(trustedProxies []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the IP address of the client that sent the req.
+//
+// The X-Forwarded-For header is honored only if the req comes from one of the
+// trustedProxies, in which case its addresses are walked from right to left
+// and the first one that is not a trusted proxy is returned. This prevents
+// clients from spoofing their addresses by sending the header themselves.
+func clientIP(req *http.Request, trustedProxies []netip.Prefix) string {
+	addrPort, err := netip.ParseAddrPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	addr := addrPort.Addr().Unmap()
+	if !isTrustedProxy(trustedProxies, addr) {
+		return addr.String()
+	}
+
+	var forwardedFor []string
+	for _, v := range req.Header.Values("X-Forwarded-For") {
+		forwardedFor = append(forwardedFor, strings.Split(v, ",")...)
+	}
+	for i := len(forwardedFor) - 1; i >= 0; i-- {
+		forwardedAddr, err := netip.ParseAddr(strings.TrimSpace(forwardedFor[i]))
+		if err != nil {
+			break
+		}
+		addr = forwardedAddr.Unmap()
+		if !isTrustedProxy(trustedProxies, addr) {
+			break
+		}
+	}
+	return addr.String()
+}
@@ -0,0 +1,117 @@
+package goproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	now := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	rl := newRateLimiter(2, 3)
+	for _, tt := range []struct {
+		n              int
+		key            string
+		after          time.Duration
+		wantOK         bool
+		wantRetryAfter time.Duration
+	}{
+		{1, "a", 0, true, 0},
+		{2, "a", 0, true, 0},
+		{3, "a", 0, true, 0},
+		{4, "a", 0, false, 500 * time.Millisecond},
+		{5, "b", 0, true, 0},
+		{6, "a", 250 * time.Millisecond, false, 250 * time.Millisecond},
+		{7, "a", 250 * time.Millisecond, true, 0},
+		{8, "a", 0, false, 500 * time.Millisecond},
+		{9, "a", 10 * time.Second, true, 0},
+	} {
+		now = now.Add(tt.after)
+		ok, retryAfter := rl.allow(tt.key, now)
+		if got, want := ok, tt.wantOK; got != want {
+			t.Errorf("test(%d): got %t, want %t", tt.n, got, want)
+		}
+		if got, want := retryAfter, tt.wantRetryAfter; got != want {
+			t.Errorf("test(%d): got %s, want %s", tt.n, got, want)
+		}
+	}
+
+	now = now.Add(rateLimiterSweepInterval)
+	rl.allow("c", now)
+	if got, want := len(rl.buckets), 1; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
+
+func TestNewRateLimiter(t *testing.T) {
+	for _, tt := range []struct {
+		n         int
+		rate      float64
+		burst     int
+		wantBurst float64
+	}{
+		{1, 10, 20, 20},
+		{2, 10, 0, 10},
+		{3, 0.5, 0, 1},
+		{4, 2.5, -1, 3},
+	} {
+		rl := newRateLimiter(tt.rate, tt.burst)
+		if got, want := rl.burst, tt.wantBurst; got != want {
+			t.Errorf("test(%d): got %v, want %v", tt.n, got, want)
+		}
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	got := parseTrustedProxies([]string{"10.0.0.0/8", " 192.168.1.1 ", "::ffff:172.16.0.1", "2001:db8::1/32", "invalid", ""})
+	want := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.168.1.1/32"),
+		netip.MustParsePrefix("172.16.0.1/32"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("got %v, want %v", got, want)
+			break
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trustedProxies := parseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1"})
+	for _, tt := range []struct {
+		n             int
+		remoteAddr    string
+		forwardedFors []string
+		wantClientIP  string
+	}{
+		{1, "203.0.113.1:1234", nil, "203.0.113.1"},
+		{2, "203.0.113.1:1234", []string{"198.51.100.1"}, "203.0.113.1"},
+		{3, "10.0.0.1:1234", nil, "10.0.0.1"},
+		{4, "10.0.0.1:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{5, "10.0.0.1:1234", []string{"198.51.100.2, 198.51.100.1"}, "198.51.100.1"},
+		{6, "10.0.0.1:1234", []string{"198.51.100.1, 192.168.1.1"}, "198.51.100.1"},
+		{7, "10.0.0.1:1234", []string{"198.51.100.1", "10.0.0.2"}, "198.51.100.1"},
+		{8, "10.0.0.1:1234", []string{"10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{9, "10.0.0.1:1234", []string{"198.51.100.1, invalid"}, "10.0.0.1"},
+		{10, "10.0.0.1:1234", []string{"invalid, 198.51.100.1"}, "198.51.100.1"},
+		{11, "[::ffff:10.0.0.1]:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{12, "[2001:db8::1]:1234", []string{"198.51.100.1"}, "2001:db8::1"},
+		{13, "invalid", []string{"198.51.100.1"}, "invalid"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tt.remoteAddr
+		for _, forwardedFor := range tt.forwardedFors {
+			req.Header.Add("X-Forwarded-For", forwardedFor)
+		}
+		if got, want := clientIP(req, trustedProxies), tt.wantClientIP; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package goproxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// ModuleVersion is the content of a version of a module registered by
+// [Goproxy.RegisterModule].
+type ModuleVersion struct {
+	// Info is the content of the info file, which is a JSON object with at
+	// least the "Version" and "Time" fields, such as
+	// `{"Version":"v1.0.0","Time":"2006-01-02T15:04:05Z"}`.
+	Info []byte
+
+	// Mod is the content of the go.mod file.
+	Mod []byte
+
+	// Zip is the content of the module zip file, whose files are all in the
+	// "<module path>@<version>/" directory as created by
+	// [golang.org/x/mod/zip.Create].
+	Zip []byte
+}
+
+// RegisterModule registers the versions, keyed by version, of the module of
+// the modulePath, so that the g serves them as is, without fetching or caching
+// anything. The list and latest requests of the module then reflect only the
+// versions registered. Registering the modulePath again replaces its versions,
+// and registering it with no versions unregisters it.
+//
+// Each version must be a canonical semantic version valid for the modulePath,
+// and its info, mod, and zip files must be valid and describe the same module
+// version. A registered module is still subject to the policy of the g (see
+// [Goproxy.SetPolicy]), but is never looked up in any checksum database, so
+// the clients should be set up to not look it up either, such as by GOPRIVATE
+// or GONOSUMDB.
+//
+// RegisterModule is safe for concurrent use, including while the g is serving
+// requests.
+func (g *Goproxy) RegisterModule(modulePath string, versions map[string]ModuleVersion) error {
+	g.initOnce.Do(g.init)
+	if err := module.CheckPath(modulePath); err != nil {
+		return err
+	}
+	registered := make(map[string]*registeredModuleVersion, len(versions))
+	for version, mv := range versions {
+		rmv, err := g.newRegisteredModuleVersion(modulePath, version, mv)
+		if err != nil {
+			return fmt.Errorf("version %s: %w", version, err)
+		}
+		registered[version] = rmv
+	}
+	g.moduleRegistry.set(modulePath, registered)
+	return nil
+}
+
+// registeredModuleVersion is a [ModuleVersion] checked by
+// [Goproxy.RegisterModule].
+type registeredModuleVersion struct {
+	info []byte
+	mod  []byte
+	zip  []byte
+}
+
+// newRegisteredModuleVersion checks the mv as the version of the module of the
+// modulePath and returns it as a [registeredModuleVersion].
+func (g *Goproxy) newRegisteredModuleVersion(modulePath, version string, mv ModuleVersion) (*registeredModuleVersion, error) {
+	if err := module.Check(modulePath, version); err != nil {
+		return nil, err
+	}
+	if semver.Canonical(version) != version {
+		return nil, errors.New("version is not canonical")
+	}
+
+	infoVersion, infoTime, infoOrigin, err := unmarshalInfo(string(mv.Info))
+	if err != nil {
+		return nil, fmt.Errorf("invalid info file: %w", err)
+	} else if infoVersion != version {
+		return nil, fmt.Errorf("invalid info file: version %s does not match", infoVersion)
+	}
+
+	mf, err := modfile.ParseLax("go.mod", mv.Mod, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mod file: %w", err)
+	} else if mf.Module == nil {
+		return nil, errors.New("invalid mod file: missing module directive")
+	} else if mf.Module.Mod.Path != modulePath {
+		return nil, fmt.Errorf("invalid mod file: module path %s does not match", mf.Module.Mod.Path)
+	}
+
+	zipFile, err := writeTempFile(g.TempDir, bytes.NewReader(mv.Zip), g.maxZipFileSize)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(zipFile)
+	if err := checkZipFile(zipFile, modulePath, version); err != nil {
+		return nil, err
+	}
+
+	return &registeredModuleVersion{
+		info: []byte(marshalInfoWithOrigin(infoVersion, infoTime, infoOrigin)),
+		mod:  mv.Mod,
+		zip:  mv.Zip,
+	}, nil
+}
+
+// moduleRegistry is a set of modules registered by [Goproxy.RegisterModule].
+// It is safe for concurrent use.
+type moduleRegistry struct {
+	mutex   sync.RWMutex
+	modules map[string]map[string]*registeredModuleVersion
+}
+
+// newModuleRegistry returns a new [moduleRegistry].
+func newModuleRegistry() *moduleRegistry {
+	return &moduleRegistry{modules: map[string]map[string]*registeredModuleVersion{}}
+}
+
+// set sets the versions of the module of the modulePath, or removes the module
+// if there are none.
+func (mr *moduleRegistry) set(modulePath string, versions map[string]*registeredModuleVersion) {
+	mr.mutex.Lock()
+	defer mr.mutex.Unlock()
+	if len(versions) == 0 {
+		delete(mr.modules, modulePath)
+	} else {
+		mr.modules[modulePath] = versions
+	}
+}
+
+// get returns the versions of the module of the modulePath, or nil if it is not
+// registered.
+func (mr *moduleRegistry) get(modulePath string) map[string]*registeredModuleVersion {
+	mr.mutex.RLock()
+	defer mr.mutex.RUnlock()
+	return mr.modules[modulePath]
+}
+
+// serveRegisteredModule serves the f from the module registered by
+// [Goproxy.RegisterModule] for its module path, and reports whether there is
+// one.
+func (g *Goproxy) serveRegisteredModule(rw http.ResponseWriter, req *http.Request, f *fetch) bool {
+	versions := g.moduleRegistry.get(f.modulePath)
+	if versions == nil {
+		return false
+	}
+	switch f.ops {
+	case fetchOpsList:
+		list := make([]string, 0, len(versions))
+		for version := range versions {
+			if !module.IsPseudoVersion(version) {
+				list = append(list, version)
+			}
+		}
+		sortVersions(list)
+		responseSuccess(rw, req, strings.NewReader(strings.Join(list, "\n")), f.contentType, 60)
+	case fetchOpsResolve:
+		version := f.moduleVersion
+		if version == "latest" {
+			version = latestRegisteredVersion(versions)
+		}
+		rmv, ok := versions[version]
+		if !ok {
+			responseNotFound(rw, req, 60, fmt.Sprintf("unknown revision %s", f.moduleVersion))
+			return true
+		}
+		responseSuccess(rw, req, bytes.NewReader(rmv.info), f.contentType, 60)
+	default:
+		rmv, ok := versions[f.moduleVersion]
+		if !ok {
+			responseNotFound(rw, req, 60, fmt.Sprintf("unknown revision %s", f.moduleVersion))
+			return true
+		}
+		switch f.ops {
+		case fetchOpsDownloadInfo:
+			responseSuccess(rw, req, withInfoTime(bytes.NewReader(rmv.info)), f.contentType, 604800)
+		case fetchOpsDownloadMod:
+			responseSuccess(rw, req, bytes.NewReader(rmv.mod), f.contentType, 604800)
+		default:
+			responseSuccess(rw, req, bytes.NewReader(rmv.zip), f.contentType, 604800)
+		}
+	}
+	return true
+}
+
+// latestRegisteredVersion returns the version that the "latest" query resolves
+// to among the versions, preferring releases over pre-releases and
+// pre-releases over pseudo-versions, as the go command does.
+func latestRegisteredVersion(versions map[string]*registeredModuleVersion) string {
+	var latest [3]string // release, pre-release, pseudo-version
+	for version := range versions {
+		i := 0
+		if module.IsPseudoVersion(version) {
+			i = 2
+		} else if semver.Prerelease(version) != "" {
+			i = 1
+		}
+		if latest[i] == "" || semver.Compare(version, latest[i]) > 0 {
+			latest[i] = version
+		}
+	}
+	for _, version := range latest {
+		if version != "" {
+			return version
+		}
+	}
+	return ""
+}
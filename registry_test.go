@@ -0,0 +1,115 @@
+package goproxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGoproxyRegisterModule(t *testing.T) {
+	zipFile := filepath.Join(t.TempDir(), "module.zip")
+	if err := writeZipFile(zipFile, map[string][]byte{
+		"example.com/tool@v1.0.0/go.mod":  []byte("module example.com/tool\n"),
+		"example.com/tool@v1.0.0/main.go": []byte("package main\n"),
+	}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	zip, err := os.ReadFile(zipFile)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	mv := ModuleVersion{
+		Info: []byte(`{"Version":"v1.0.0","Time":"2000-01-01T00:00:00Z"}`),
+		Mod:  []byte("module example.com/tool\n"),
+		Zip:  zip,
+	}
+
+	g := &Goproxy{Offline: true, TempDir: t.TempDir()}
+	for _, tt := range []struct {
+		n          int
+		modulePath string
+		version    string
+		mv         ModuleVersion
+		wantError  string
+	}{
+		{1, "example.com/tool", "v1.0", mv, "version v1.0: version is not canonical"},
+		{2, "example.com/tool", "v2.0.0", mv, `version v2.0.0: example.com/tool@v2.0.0: invalid version: should be v0 or v1, not v2`},
+		{3, "example.com/tool", "v1.0.0", ModuleVersion{Info: []byte(`{"Version":"v1.1.0","Time":"2000-01-01T00:00:00Z"}`), Mod: mv.Mod, Zip: mv.Zip}, "version v1.0.0: invalid info file: version v1.1.0 does not match"},
+		{4, "example.com/tool", "v1.0.0", ModuleVersion{Info: mv.Info, Mod: []byte("module example.com/other\n"), Zip: mv.Zip}, "version v1.0.0: invalid mod file: module path example.com/other does not match"},
+		{5, "example.com/tool", "v1.0.0", ModuleVersion{Info: mv.Info, Mod: mv.Mod, Zip: []byte("zip")}, "version v1.0.0: invalid zip file: zip: not a valid zip file"},
+		{6, "example.com/tool", "v1.0.0", mv, ""},
+	} {
+		err := g.RegisterModule(tt.modulePath, map[string]ModuleVersion{tt.version: tt.mv})
+		if tt.wantError != "" {
+			if err == nil {
+				t.Fatalf("test(%d): expected error", tt.n)
+			}
+			if got, want := err.Error(), tt.wantError; got != want {
+				t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+			}
+		} else if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		}
+	}
+
+	for _, tt := range []struct {
+		n              int
+		path           string
+		wantStatusCode int
+		wantContent    string
+	}{
+		{1, "/example.com/tool/@v/list", http.StatusOK, "v1.0.0"},
+		{2, "/example.com/tool/@latest", http.StatusOK, `{"Version":"v1.0.0","Time":"2000-01-01T00:00:00Z"}`},
+		{3, "/example.com/tool/@v/v1.0.0.info", http.StatusOK, `{"Version":"v1.0.0","Time":"2000-01-01T00:00:00Z"}`},
+		{4, "/example.com/tool/@v/v1.0.0.mod", http.StatusOK, "module example.com/tool\n"},
+		{5, "/example.com/tool/@v/v1.0.0.zip", http.StatusOK, string(zip)},
+		{6, "/example.com/tool/@v/v1.1.0.info", http.StatusNotFound, "not found: unknown revision v1.1.0"},
+		{7, "/example.com/tool/@v/master.info", http.StatusNotFound, "not found: unknown revision master"},
+		{8, "/example.com/other/@v/list", http.StatusNotFound, "not found: not cached by this proxy in offline mode"},
+	} {
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		recr := rec.Result()
+		if got, want := recr.StatusCode, tt.wantStatusCode; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if b, err := io.ReadAll(recr.Body); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		} else if got, want := string(b), tt.wantContent; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+
+	if err := g.RegisterModule("example.com/tool", nil); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/example.com/tool/@v/list", nil))
+	if got, want := rec.Code, http.StatusNotFound; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
+
+func TestLatestRegisteredVersion(t *testing.T) {
+	for _, tt := range []struct {
+		n        int
+		versions []string
+		want     string
+	}{
+		{1, []string{"v1.0.0", "v1.1.0-rc.1", "v0.9.0"}, "v1.0.0"},
+		{2, []string{"v1.1.0-rc.1", "v1.1.0-rc.2", "v0.0.0-20000101000000-abcdefabcdef"}, "v1.1.0-rc.2"},
+		{3, []string{"v0.0.0-20000101000000-abcdefabcdef"}, "v0.0.0-20000101000000-abcdefabcdef"},
+		{4, nil, ""},
+	} {
+		versions := map[string]*registeredModuleVersion{}
+		for _, version := range tt.versions {
+			versions[version] = &registeredModuleVersion{}
+		}
+		if got, want := latestRegisteredVersion(versions), tt.want; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+}
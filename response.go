@@ -1,12 +1,17 @@
 package goproxy
 
 import (
-	"context"
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"runtime"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -35,6 +40,46 @@ func responseString(rw http.ResponseWriter, req *http.Request, statusCode, cache
 	}
 }
 
+// responseErrorString responses the msg of an error with the code to the client
+// with the statusCode and cacheControlMaxAge. The msg is responded as is
+// unless the client accepts "application/json", in which case it is responded
+// as a JSON object with the code and msg as the "code" and "message" fields.
+func responseErrorString(rw http.ResponseWriter, req *http.Request, statusCode, cacheControlMaxAge int, code, msg string) {
+	rw.Header().Add("Vary", "Accept")
+	if !acceptsJSON(req) {
+		responseString(rw, req, statusCode, cacheControlMaxAge, msg)
+		return
+	}
+	b, err := json.Marshal(struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}{code, msg})
+	if err != nil {
+		responseString(rw, req, statusCode, cacheControlMaxAge, msg)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	setResponseCacheControlHeader(rw, cacheControlMaxAge)
+	rw.WriteHeader(statusCode)
+	if req.Method != http.MethodHead {
+		rw.Write(b)
+	}
+}
+
+// acceptsJSON reports whether the Accept header of the req explicitly lists
+// "application/json".
+func acceptsJSON(req *http.Request) bool {
+	for _, accept := range req.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			mediaType, _, _ := strings.Cut(mediaRange, ";")
+			if strings.EqualFold(strings.TrimSpace(mediaType), "application/json") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // responseNotFound responses "not found" to the client with the
 // cacheControlMaxAge and optional msgs.
 func responseNotFound(rw http.ResponseWriter, req *http.Request, cacheControlMaxAge int, msgs ...any) {
@@ -49,18 +94,198 @@ func responseNotFound(rw http.ResponseWriter, req *http.Request, cacheControlMax
 	if msg == "" {
 		msg = "not found"
 	}
-	responseString(rw, req, http.StatusNotFound, cacheControlMaxAge, msg)
+	responseErrorString(rw, req, http.StatusNotFound, cacheControlMaxAge, "not_found", msg)
+}
+
+// responseGone responses "gone" to the client with the cacheControlMaxAge and
+// optional msgs.
+func responseGone(rw http.ResponseWriter, req *http.Request, cacheControlMaxAge int, msgs ...any) {
+	msg := "gone"
+	if len(msgs) > 0 {
+		if s := strings.TrimPrefix(fmt.Sprint(msgs...), "gone: "); s != "" && s != "gone" {
+			msg += ": " + s
+		}
+	}
+	responseErrorString(rw, req, http.StatusGone, cacheControlMaxAge, "gone", msg)
+}
+
+// responseUpstreamError responses the ue to the client, with "bad upstream" and
+// a 502 status code, or with "fetch timed out" and a 504 status code if the ue
+// timed out. Both are responded with a 404 status code and prefixed with "not
+// found: " instead unless the upstreamErrorStatus is true.
+func responseUpstreamError(rw http.ResponseWriter, req *http.Request, ue *UpstreamError, upstreamErrorStatus bool) {
+	statusCode, code, msg := http.StatusBadGateway, "bad_upstream", errBadUpstream.Error()
+	if ue.TimedOut {
+		statusCode, code, msg = http.StatusGatewayTimeout, "fetch_timed_out", errFetchTimedOut.Error()
+	} else if s := strings.TrimPrefix(ue.Error(), "not found: "); !strings.Contains(s, msg) {
+		msg += ": " + s
+	}
+	if !upstreamErrorStatus {
+		statusCode, msg = http.StatusNotFound, "not found: "+msg
+	}
+	responseErrorString(rw, req, statusCode, -1, code, msg)
+}
+
+// responseBadRequest responses "bad request" to the client with the
+// cacheControlMaxAge and optional msgs.
+func responseBadRequest(rw http.ResponseWriter, req *http.Request, cacheControlMaxAge int, msgs ...any) {
+	msg := "bad request"
+	if len(msgs) > 0 {
+		if s := strings.TrimPrefix(fmt.Sprint(msgs...), "bad request: "); s != "" {
+			msg += ": " + s
+		}
+	}
+	responseErrorString(rw, req, http.StatusBadRequest, cacheControlMaxAge, "bad_request", msg)
+}
+
+// responseForbidden responses "forbidden" to the client with the
+// cacheControlMaxAge and optional msgs.
+func responseForbidden(rw http.ResponseWriter, req *http.Request, cacheControlMaxAge int, msgs ...any) {
+	msg := "forbidden"
+	if len(msgs) > 0 {
+		if s := fmt.Sprint(msgs...); s != "" {
+			msg += ": " + s
+		}
+	}
+	responseErrorString(rw, req, http.StatusForbidden, cacheControlMaxAge, "forbidden", msg)
+}
+
+// responseUnauthorized responses "unauthorized" to the client.
+func responseUnauthorized(rw http.ResponseWriter, req *http.Request) {
+	responseErrorString(rw, req, http.StatusUnauthorized, -1, "unauthorized", "unauthorized")
+}
+
+// responseTooManyRequests responses "too many requests" to the client with the
+// retryAfter, which is rounded up to whole seconds.
+func responseTooManyRequests(rw http.ResponseWriter, req *http.Request, retryAfter time.Duration) {
+	rw.Header().Set("Retry-After", strconv.FormatInt(int64(math.Ceil(retryAfter.Seconds())), 10))
+	responseErrorString(rw, req, http.StatusTooManyRequests, -1, "too_many_requests", "too many requests")
+}
+
+// responseQuotaExceeded responses "too many requests: quota exceeded" to the
+// client.
+func responseQuotaExceeded(rw http.ResponseWriter, req *http.Request) {
+	responseErrorString(rw, req, http.StatusTooManyRequests, -1, "quota_exceeded", "too many requests: quota exceeded")
+}
+
+// responseServiceUnavailable responses "service unavailable" to the client with
+// the retryAfter, which is rounded up to whole seconds.
+func responseServiceUnavailable(rw http.ResponseWriter, req *http.Request, retryAfter time.Duration) {
+	rw.Header().Set("Retry-After", strconv.FormatInt(int64(math.Ceil(retryAfter.Seconds())), 10))
+	responseErrorString(rw, req, http.StatusServiceUnavailable, -1, "service_unavailable", "service unavailable")
+}
+
+// responseMaintenance responses "service unavailable: in maintenance" to the
+// client with the retryAfter, which is rounded up to whole seconds.
+func responseMaintenance(rw http.ResponseWriter, req *http.Request, retryAfter time.Duration) {
+	rw.Header().Set("Retry-After", strconv.FormatInt(int64(math.Ceil(retryAfter.Seconds())), 10))
+	responseErrorString(rw, req, http.StatusServiceUnavailable, -1, "maintenance", "service unavailable: in maintenance")
+}
+
+// responseOverloaded responses "service unavailable: overloaded" to the client
+// with the retryAfter, which is rounded up to whole seconds.
+func responseOverloaded(rw http.ResponseWriter, req *http.Request, retryAfter time.Duration) {
+	rw.Header().Set("Retry-After", strconv.FormatInt(int64(math.Ceil(retryAfter.Seconds())), 10))
+	responseErrorString(rw, req, http.StatusServiceUnavailable, -1, "overloaded", "service unavailable: overloaded")
 }
 
 // responseMethodNotAllowed responses "method not allowed" to the client with
 // the cacheControlMaxAge.
 func responseMethodNotAllowed(rw http.ResponseWriter, req *http.Request, cacheControlMaxAge int) {
-	responseString(rw, req, http.StatusMethodNotAllowed, cacheControlMaxAge, "method not allowed")
+	responseErrorString(rw, req, http.StatusMethodNotAllowed, cacheControlMaxAge, "method_not_allowed", "method not allowed")
 }
 
 // responseInternalServerError responses "internal server error" to the client.
 func responseInternalServerError(rw http.ResponseWriter, req *http.Request) {
-	responseString(rw, req, http.StatusInternalServerError, -2, "internal server error")
+	responseErrorString(rw, req, http.StatusInternalServerError, -2, "internal_server_error", "internal server error")
+}
+
+// responseInsufficientStorage responses "insufficient storage" to the client.
+func responseInsufficientStorage(rw http.ResponseWriter, req *http.Request) {
+	responseErrorString(rw, req, http.StatusInsufficientStorage, -1, "insufficient_storage", "insufficient storage")
+}
+
+// isDiskFullError reports whether the err indicates that a disk is full, either
+// directly or in the output of the go command or an upstream.
+func isDiskFullError(err error) bool {
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		switch {
+		case errno == syscall.ENOSPC:
+			return true
+		case runtime.GOOS == "windows" && (errno == 39 || errno == 112): // ERROR_HANDLE_DISK_FULL and ERROR_DISK_FULL
+			return true
+		}
+	}
+	return strings.Contains(err.Error(), "no space left on device")
+}
+
+// isUnconditionalFullGetRequest reports whether the req is a GET request for
+// the full content without any precondition, whose response therefore does not
+// depend on the content itself.
+func isUnconditionalFullGetRequest(req *http.Request) bool {
+	if req.Method != http.MethodGet {
+		return false
+	}
+	for _, h := range []string{"Range", "If-Range", "If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since"} {
+		if req.Header.Get(h) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// teeReadSeeker is an [io.ReadSeeker] that writes to w the bytes read from the
+// underlying [io.ReadSeeker] in order, so a reader that may seek around, such
+// as a [Cacher] putting the content, can share its read pass with a client.
+// Bytes read again after seeking back, or skipped by seeking forward, are not
+// written, so the offset is where the rest of the content must be written from.
+// Writing stops at the first error, which is recorded in err without failing
+// any read.
+type teeReadSeeker struct {
+	io.ReadSeeker
+	w      io.Writer
+	pos    int64
+	offset int64
+	err    error
+}
+
+// Read implements [io.Reader].
+func (trs *teeReadSeeker) Read(p []byte) (int, error) {
+	n, err := trs.ReadSeeker.Read(p)
+	if trs.err == nil && trs.pos <= trs.offset && trs.pos+int64(n) > trs.offset {
+		var written int
+		written, trs.err = trs.w.Write(p[trs.offset-trs.pos : n])
+		trs.offset += int64(written)
+	}
+	trs.pos += int64(n)
+	return n, err
+}
+
+// Seek implements [io.Seeker].
+func (trs *teeReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	pos, err := trs.ReadSeeker.Seek(offset, whence)
+	if err == nil {
+		trs.pos = pos
+	}
+	return pos, err
+}
+
+// deadlineWriter is an [io.Writer] that bounds each write to the w by the
+// timeout, using the rc of the response the w writes to. If the rc does not
+// support write deadlines, writes are not bounded.
+type deadlineWriter struct {
+	w       io.Writer
+	rc      *http.ResponseController
+	timeout time.Duration
+}
+
+// Write implements [io.Writer].
+func (dw *deadlineWriter) Write(p []byte) (int, error) {
+	if err := dw.rc.SetWriteDeadline(time.Now().Add(dw.timeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return 0, err
+	}
+	return dw.w.Write(p)
 }
 
 // responseSuccess responses success to the client with the content, contentType
@@ -90,6 +315,11 @@ func responseSuccess(rw http.ResponseWriter, req *http.Request, content io.Reade
 	if !lastModified.IsZero() {
 		rw.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
 	}
+	if sz, ok := content.(interface{ Size() int64 }); ok {
+		if size := sz.Size(); size >= 0 {
+			rw.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		}
+	}
 
 	rw.WriteHeader(http.StatusOK)
 	if req.Method != http.MethodHead {
@@ -97,28 +327,94 @@ func responseSuccess(rw http.ResponseWriter, req *http.Request, content io.Reade
 	}
 }
 
-// responseError responses error to the client with the err and cacheSensitive.
-func responseError(rw http.ResponseWriter, req *http.Request, err error, cacheSensitive bool) {
-	if errors.Is(err, errNotFound) {
-		cacheControlMaxAge := -1
-		msg := err.Error()
-		if strings.Contains(msg, errBadUpstream.Error()) {
-			msg = errBadUpstream.Error()
-		} else if strings.Contains(msg, errFetchTimedOut.Error()) {
-			msg = errFetchTimedOut.Error()
-		} else if cacheSensitive {
+// infoContent is the content of an info file served with the time of its
+// version as the last modification time.
+type infoContent struct {
+	*bytes.Reader
+	lastModified time.Time
+	etag         string
+}
+
+// LastModified returns the last modification time of the ic.
+func (ic *infoContent) LastModified() time.Time {
+	return ic.lastModified
+}
+
+// ETag returns the ETag of the ic.
+func (ic *infoContent) ETag() string {
+	return ic.etag
+}
+
+// withInfoTime returns the content of an info file with the Time of its
+// version, truncated to whole seconds as the Last-Modified header is, as its
+// last modification time, so that [responseSuccess] answers conditional
+// requests based on when the version was made rather than when it was cached.
+// The last modification time of the content is kept if it has no valid Time.
+//
+// It must only be used for the info files of module versions, which never
+// change. The results of version queries, such as "/@latest", may move to a
+// version made earlier than the one they resolved to before, so they are
+// served with the time they were cached, if any, and their ETags instead.
+func withInfoTime(content io.Reader) io.Reader {
+	b, err := io.ReadAll(content)
+	if err != nil {
+		// Serve what has been read, then fail the same way reading the
+		// content directly would.
+		return io.MultiReader(bytes.NewReader(b), &errorReader{err})
+	}
+	ic := &infoContent{Reader: bytes.NewReader(b), lastModified: cachedAt(content)}
+	if et, ok := content.(interface{ ETag() string }); ok {
+		ic.etag = et.ETag()
+	}
+	var info struct{ Time time.Time }
+	if json.Unmarshal(b, &info) == nil && !info.Time.IsZero() {
+		ic.lastModified = info.Time.Truncate(time.Second)
+	}
+	return ic
+}
+
+// errorReader is an [io.Reader] that always fails with the err.
+type errorReader struct {
+	err error
+}
+
+// Read implements [io.Reader].
+func (er *errorReader) Read([]byte) (int, error) {
+	return 0, er.err
+}
+
+// responseError responses error to the client with the err, cacheSensitive,
+// and upstreamErrorStatus. The status code is decided by the class of the err
+// as returned by [classifyError]: 404 or 410 for a [*NotExistError], 404 for an
+// [*UpstreamError], or 502 or 504 if the upstreamErrorStatus is true, and 403
+// for a [*PolicyError]. A full disk and a too large zip file have their own
+// status codes, and anything else is responded with a 500 status code.
+func responseError(rw http.ResponseWriter, req *http.Request, err error, cacheSensitive, upstreamErrorStatus bool) {
+	var (
+		ztle *zipFileTooLargeError
+		nee  *NotExistError
+		ue   *UpstreamError
+		pe   *PolicyError
+	)
+	err = classifyError(err)
+	if isDiskFullError(err) {
+		responseInsufficientStorage(rw, req)
+	} else if errors.As(err, &ztle) {
+		responseErrorString(rw, req, http.StatusRequestEntityTooLarge, -1, "zip_file_too_large", ztle.Error())
+	} else if errors.As(err, &pe) {
+		responseForbidden(rw, req, -1, pe)
+	} else if errors.As(err, &ue) {
+		responseUpstreamError(rw, req, ue, upstreamErrorStatus)
+	} else if errors.As(err, &nee) {
+		cacheControlMaxAge := 600
+		if cacheSensitive {
 			cacheControlMaxAge = 60
+		}
+		if nee.Gone {
+			responseGone(rw, req, cacheControlMaxAge, nee)
 		} else {
-			cacheControlMaxAge = 600
+			responseNotFound(rw, req, cacheControlMaxAge, nee)
 		}
-		responseNotFound(rw, req, cacheControlMaxAge, msg)
-	} else if errors.Is(err, errBadUpstream) {
-		responseNotFound(rw, req, -1, errBadUpstream)
-	} else if t, ok := err.(interface{ Timeout() bool }); (ok && t.Timeout()) ||
-		errors.Is(err, context.DeadlineExceeded) ||
-		errors.Is(err, errFetchTimedOut) ||
-		strings.Contains(err.Error(), errFetchTimedOut.Error()) {
-		responseNotFound(rw, req, -1, errFetchTimedOut)
 	} else {
 		responseInternalServerError(rw, req)
 	}
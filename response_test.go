@@ -1,11 +1,15 @@
 package goproxy
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -68,6 +72,49 @@ func TestResponseString(t *testing.T) {
 	}
 }
 
+func TestResponseErrorString(t *testing.T) {
+	for _, tt := range []struct {
+		n               int
+		method          string
+		accept          []string
+		wantContentType string
+		wantContent     string
+	}{
+		{1, http.MethodGet, nil, "text/plain; charset=utf-8", "not found: foobar"},
+		{2, http.MethodGet, []string{"text/plain"}, "text/plain; charset=utf-8", "not found: foobar"},
+		{3, http.MethodGet, []string{"application/json"}, "application/json; charset=utf-8", `{"code":"not_found","message":"not found: foobar"}`},
+		{4, http.MethodGet, []string{"text/html, Application/JSON;q=0.9"}, "application/json; charset=utf-8", `{"code":"not_found","message":"not found: foobar"}`},
+		{5, http.MethodGet, []string{"text/html", "application/json"}, "application/json; charset=utf-8", `{"code":"not_found","message":"not found: foobar"}`},
+		{6, http.MethodGet, []string{"application/jsonx"}, "text/plain; charset=utf-8", "not found: foobar"},
+		{7, http.MethodHead, []string{"application/json"}, "application/json; charset=utf-8", ""},
+	} {
+		req := httptest.NewRequest(tt.method, "/", nil)
+		for _, accept := range tt.accept {
+			req.Header.Add("Accept", accept)
+		}
+		rec := httptest.NewRecorder()
+		responseErrorString(rec, req, http.StatusNotFound, 60, "not_found", "not found: foobar")
+		recr := rec.Result()
+		if got, want := recr.StatusCode, http.StatusNotFound; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if got, want := recr.Header.Get("Content-Type"), tt.wantContentType; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		if got, want := recr.Header.Get("Cache-Control"), "public, max-age=60"; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		if got, want := recr.Header.Get("Vary"), "Accept"; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		if b, err := io.ReadAll(recr.Body); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		} else if got, want := string(b), tt.wantContent; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+}
+
 func TestResponseNotFound(t *testing.T) {
 	for _, tt := range []struct {
 		n           int
@@ -107,6 +154,135 @@ func TestResponseNotFound(t *testing.T) {
 	}
 }
 
+func TestResponseBadRequest(t *testing.T) {
+	for _, tt := range []struct {
+		n           int
+		msgs        []any
+		wantContent string
+	}{
+		{1, nil, "bad request"},
+		{2, []any{""}, "bad request"},
+		{3, []any{"foobar"}, "bad request: foobar"},
+		{4, []any{errors.New("bad request: foobar")}, "bad request: foobar"},
+	} {
+		rec := httptest.NewRecorder()
+		responseBadRequest(rec, httptest.NewRequest("", "/", nil), 86400, tt.msgs...)
+		recr := rec.Result()
+		if got, want := recr.StatusCode, http.StatusBadRequest; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if got, want := recr.Header.Get("Cache-Control"), "public, max-age=86400"; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		if b, err := io.ReadAll(recr.Body); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		} else if got, want := string(b), tt.wantContent; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+}
+
+func TestResponseForbidden(t *testing.T) {
+	for _, tt := range []struct {
+		n           int
+		msgs        []any
+		wantContent string
+	}{
+		{1, nil, "forbidden"},
+		{2, []any{""}, "forbidden"},
+		{3, []any{"foobar"}, "forbidden: foobar"},
+		{4, []any{errors.New("foo"), "bar"}, "forbidden: foobar"},
+	} {
+		rec := httptest.NewRecorder()
+		responseForbidden(rec, httptest.NewRequest("", "/", nil), -1, tt.msgs...)
+		recr := rec.Result()
+		if got, want := recr.StatusCode, http.StatusForbidden; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if got, want := recr.Header.Get("Content-Type"), "text/plain; charset=utf-8"; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		if got, want := recr.Header.Get("Cache-Control"), "must-revalidate, no-cache, no-store"; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		if b, err := io.ReadAll(recr.Body); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		} else if got, want := string(b), tt.wantContent; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+}
+
+func TestResponseTooManyRequests(t *testing.T) {
+	for _, tt := range []struct {
+		n              int
+		retryAfter     time.Duration
+		wantRetryAfter string
+	}{
+		{1, 0, "0"},
+		{2, 100 * time.Millisecond, "1"},
+		{3, 2 * time.Second, "2"},
+		{4, 2500 * time.Millisecond, "3"},
+	} {
+		rec := httptest.NewRecorder()
+		responseTooManyRequests(rec, httptest.NewRequest("", "/", nil), tt.retryAfter)
+		recr := rec.Result()
+		if got, want := recr.StatusCode, http.StatusTooManyRequests; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if got, want := recr.Header.Get("Retry-After"), tt.wantRetryAfter; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		if got, want := recr.Header.Get("Cache-Control"), "must-revalidate, no-cache, no-store"; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		if b, err := io.ReadAll(recr.Body); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		} else if got, want := string(b), "too many requests"; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+}
+
+func TestResponseServiceUnavailable(t *testing.T) {
+	rec := httptest.NewRecorder()
+	responseServiceUnavailable(rec, httptest.NewRequest("", "/", nil), 1500*time.Millisecond)
+	recr := rec.Result()
+	if got, want := recr.StatusCode, http.StatusServiceUnavailable; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+	if got, want := recr.Header.Get("Retry-After"), "2"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := recr.Header.Get("Cache-Control"), "must-revalidate, no-cache, no-store"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if b, err := io.ReadAll(recr.Body); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := string(b), "service unavailable"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestResponseMaintenance(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("", "/", nil)
+	req.Header.Set("Accept", "application/json")
+	responseMaintenance(rec, req, time.Minute)
+	recr := rec.Result()
+	if got, want := recr.StatusCode, http.StatusServiceUnavailable; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+	if got, want := recr.Header.Get("Retry-After"), "60"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if b, err := io.ReadAll(recr.Body); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := string(b), `{"code":"maintenance","message":"service unavailable: in maintenance"}`; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
 func TestResponseMethodNotAllowed(t *testing.T) {
 	rec := httptest.NewRecorder()
 	responseMethodNotAllowed(rec, httptest.NewRequest("", "/", nil), 60)
@@ -174,24 +350,76 @@ func (srb successResponseBody_ETag) ETag() string {
 	return srb.etag
 }
 
+type successResponseBody_Size struct {
+	io.Reader
+	size int64
+}
+
+func (srb successResponseBody_Size) Size() int64 {
+	return srb.size
+}
+
+func TestResponseInsufficientStorage(t *testing.T) {
+	rec := httptest.NewRecorder()
+	responseInsufficientStorage(rec, httptest.NewRequest("", "/", nil))
+	recr := rec.Result()
+	if got, want := recr.StatusCode, http.StatusInsufficientStorage; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+	if got, want := recr.Header.Get("Content-Type"), "text/plain; charset=utf-8"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := recr.Header.Get("Cache-Control"), "must-revalidate, no-cache, no-store"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if b, err := io.ReadAll(recr.Body); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := string(b), "insufficient storage"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestIsDiskFullError(t *testing.T) {
+	for _, tt := range []struct {
+		n    int
+		err  error
+		want bool
+	}{
+		{1, syscall.ENOSPC, true},
+		{2, &fs.PathError{Op: "write", Path: "foo", Err: syscall.ENOSPC}, true},
+		{3, fmt.Errorf("failed to cache: %w", &fs.PathError{Op: "write", Path: "foo", Err: syscall.ENOSPC}), true},
+		{4, notFoundError("write /tmp/foo: no space left on device"), true},
+		{5, syscall.ENOENT, false},
+		{6, errNotFound, false},
+		{7, errors.New("foobar"), false},
+	} {
+		if got, want := isDiskFullError(tt.err), tt.want; got != want {
+			t.Errorf("test(%d): got %t, want %t", tt.n, got, want)
+		}
+	}
+}
+
 func TestResponseSuccess(t *testing.T) {
 	for _, tt := range []struct {
-		n                int
-		method           string
-		content          io.Reader
-		wantLastModified string
-		wantETag         string
-		wantContent      string
+		n                 int
+		method            string
+		content           io.Reader
+		wantLastModified  string
+		wantETag          string
+		wantContentLength string
+		wantContent       string
 	}{
 		{
-			n:           1,
-			content:     strings.NewReader("foobar"),
-			wantContent: "foobar",
+			n:                 1,
+			content:           strings.NewReader("foobar"),
+			wantContentLength: "6",
+			wantContent:       "foobar",
 		},
 		{
-			n:       2,
-			method:  http.MethodHead,
-			content: strings.NewReader("foobar"),
+			n:                 2,
+			method:            http.MethodHead,
+			content:           strings.NewReader("foobar"),
+			wantContentLength: "6",
 		},
 		{
 			n: 3,
@@ -237,6 +465,17 @@ func TestResponseSuccess(t *testing.T) {
 			wantETag:         `"foobar"`,
 			wantContent:      "foobar",
 		},
+		{
+			n:                 7,
+			method:            http.MethodHead,
+			content:           successResponseBody_Size{Reader: strings.NewReader("foobar"), size: 6},
+			wantContentLength: "6",
+		},
+		{
+			n:           8,
+			content:     successResponseBody_Size{Reader: strings.NewReader("foobar"), size: -1},
+			wantContent: "foobar",
+		},
 	} {
 		rec := httptest.NewRecorder()
 		responseSuccess(rec, httptest.NewRequest(tt.method, "/", nil), tt.content, "text/plain; charset=utf-8", 60)
@@ -256,6 +495,9 @@ func TestResponseSuccess(t *testing.T) {
 		if got, want := recr.Header.Get("ETag"), tt.wantETag; got != want {
 			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
 		}
+		if got, want := recr.Header.Get("Content-Length"), tt.wantContentLength; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
 		if b, err := io.ReadAll(recr.Body); err != nil {
 			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
 		} else if got, want := string(b), tt.wantContent; got != want {
@@ -264,14 +506,196 @@ func TestResponseSuccess(t *testing.T) {
 	}
 }
 
-func TestResponseError(t *testing.T) {
+func TestIsUnconditionalFullGetRequest(t *testing.T) {
+	for _, tt := range []struct {
+		n      int
+		method string
+		header string
+		want   bool
+	}{
+		{1, http.MethodGet, "", true},
+		{2, http.MethodHead, "", false},
+		{3, http.MethodGet, "Range", false},
+		{4, http.MethodGet, "If-None-Match", false},
+		{5, http.MethodGet, "If-Modified-Since", false},
+		{6, http.MethodGet, "Accept", true},
+	} {
+		req := httptest.NewRequest(tt.method, "/", nil)
+		if tt.header != "" {
+			req.Header.Set(tt.header, "foobar")
+		}
+		if got, want := isUnconditionalFullGetRequest(req), tt.want; got != want {
+			t.Errorf("test(%d): got %v, want %v", tt.n, got, want)
+		}
+	}
+}
+
+func TestTeeReadSeeker(t *testing.T) {
+	for _, tt := range []struct {
+		n          int
+		read       func(rs io.ReadSeeker) error
+		wantTeed   string
+		wantOffset int64
+	}{
+		{
+			n: 1,
+			read: func(rs io.ReadSeeker) error {
+				_, err := io.Copy(io.Discard, rs)
+				return err
+			},
+			wantTeed:   "foobar",
+			wantOffset: 6,
+		},
+		{
+			n: 2,
+			read: func(rs io.ReadSeeker) error {
+				if _, err := rs.Seek(0, io.SeekEnd); err != nil {
+					return err
+				}
+				if _, err := rs.Seek(0, io.SeekStart); err != nil {
+					return err
+				}
+				_, err := io.Copy(io.Discard, rs)
+				return err
+			},
+			wantTeed:   "foobar",
+			wantOffset: 6,
+		},
+		{
+			n: 3,
+			read: func(rs io.ReadSeeker) error {
+				if _, err := io.CopyN(io.Discard, rs, 4); err != nil {
+					return err
+				}
+				if _, err := rs.Seek(1, io.SeekStart); err != nil {
+					return err
+				}
+				_, err := io.CopyN(io.Discard, rs, 4)
+				return err
+			},
+			wantTeed:   "fooba",
+			wantOffset: 5,
+		},
+		{
+			n: 4,
+			read: func(rs io.ReadSeeker) error {
+				if _, err := rs.Seek(3, io.SeekStart); err != nil {
+					return err
+				}
+				_, err := io.Copy(io.Discard, rs)
+				return err
+			},
+			wantTeed:   "",
+			wantOffset: 0,
+		},
+	} {
+		var teed strings.Builder
+		trs := &teeReadSeeker{ReadSeeker: strings.NewReader("foobar"), w: &teed}
+		if err := tt.read(trs); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		}
+		if got, want := teed.String(), tt.wantTeed; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		if got, want := trs.offset, tt.wantOffset; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+	}
+
+	trs := &teeReadSeeker{ReadSeeker: strings.NewReader("foobar"), w: errorWriter{}}
+	if _, err := io.Copy(io.Discard, trs); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if trs.err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+type errorWriter struct{}
+
+func (errorWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestWithInfoTime(t *testing.T) {
 	for _, tt := range []struct {
 		n                int
-		err              error
-		cacheSensitive   bool
+		content          io.Reader
+		ifModifiedSince  string
 		wantStatusCode   int
-		wantCacheControl string
+		wantLastModified string
 		wantContent      string
+	}{
+		{
+			n:                1,
+			content:          strings.NewReader(`{"Version":"v1.0.0","Time":"2000-01-01T00:00:00.5Z"}`),
+			wantStatusCode:   http.StatusOK,
+			wantLastModified: "Sat, 01 Jan 2000 00:00:00 GMT",
+			wantContent:      `{"Version":"v1.0.0","Time":"2000-01-01T00:00:00.5Z"}`,
+		},
+		{
+			n:                2,
+			content:          strings.NewReader(`{"Version":"v1.0.0","Time":"2000-01-01T00:00:00.5Z"}`),
+			ifModifiedSince:  "Sat, 01 Jan 2000 00:00:00 GMT",
+			wantStatusCode:   http.StatusNotModified,
+			wantLastModified: "Sat, 01 Jan 2000 00:00:00 GMT",
+		},
+		{
+			n:                3,
+			content:          strings.NewReader(`{"Version":"v1.0.0","Time":"2000-01-02T00:00:00Z"}`),
+			ifModifiedSince:  "Sat, 01 Jan 2000 00:00:00 GMT",
+			wantStatusCode:   http.StatusOK,
+			wantLastModified: "Sun, 02 Jan 2000 00:00:00 GMT",
+			wantContent:      `{"Version":"v1.0.0","Time":"2000-01-02T00:00:00Z"}`,
+		},
+		{
+			n: 4,
+			content: successResponseBody_ModTime{
+				Reader:  strings.NewReader(`{"Version":"v1.0.0"}`),
+				modTime: time.Date(2000, 1, 3, 0, 0, 0, 0, time.UTC),
+			},
+			wantStatusCode:   http.StatusOK,
+			wantLastModified: "Mon, 03 Jan 2000 00:00:00 GMT",
+			wantContent:      `{"Version":"v1.0.0"}`,
+		},
+		{
+			n:              5,
+			content:        io.MultiReader(strings.NewReader("{"), &errorReader{errors.New("foobar")}),
+			wantStatusCode: http.StatusOK,
+			wantContent:    "{",
+		},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.ifModifiedSince != "" {
+			req.Header.Set("If-Modified-Since", tt.ifModifiedSince)
+		}
+		rec := httptest.NewRecorder()
+		responseSuccess(rec, req, withInfoTime(tt.content), "application/json; charset=utf-8", 60)
+		recr := rec.Result()
+		if got, want := recr.StatusCode, tt.wantStatusCode; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if got, want := recr.Header.Get("Last-Modified"), tt.wantLastModified; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		if b, err := io.ReadAll(recr.Body); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		} else if got, want := string(b), tt.wantContent; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+}
+
+func TestResponseError(t *testing.T) {
+	for _, tt := range []struct {
+		n                   int
+		err                 error
+		cacheSensitive      bool
+		upstreamErrorStatus bool
+		wantStatusCode      int
+		wantCacheControl    string
+		wantContent         string
+		wantCode            string
 	}{
 		{
 			n:                1,
@@ -279,6 +703,7 @@ func TestResponseError(t *testing.T) {
 			wantStatusCode:   http.StatusNotFound,
 			wantCacheControl: "public, max-age=600",
 			wantContent:      "not found",
+			wantCode:         "not_found",
 		},
 		{
 			n:                2,
@@ -286,6 +711,7 @@ func TestResponseError(t *testing.T) {
 			wantStatusCode:   http.StatusNotFound,
 			wantCacheControl: "must-revalidate, no-cache, no-store",
 			wantContent:      "not found: bad upstream",
+			wantCode:         "bad_upstream",
 		},
 		{
 			n:                3,
@@ -293,6 +719,7 @@ func TestResponseError(t *testing.T) {
 			wantStatusCode:   http.StatusNotFound,
 			wantCacheControl: "must-revalidate, no-cache, no-store",
 			wantContent:      "not found: fetch timed out",
+			wantCode:         "fetch_timed_out",
 		},
 		{
 			n:                4,
@@ -301,6 +728,7 @@ func TestResponseError(t *testing.T) {
 			wantStatusCode:   http.StatusNotFound,
 			wantCacheControl: "public, max-age=60",
 			wantContent:      "not found: cache sensitive",
+			wantCode:         "not_found",
 		},
 		{
 			n:                5,
@@ -308,6 +736,7 @@ func TestResponseError(t *testing.T) {
 			wantStatusCode:   http.StatusNotFound,
 			wantCacheControl: "must-revalidate, no-cache, no-store",
 			wantContent:      "not found: bad upstream",
+			wantCode:         "bad_upstream",
 		},
 		{
 			n:                6,
@@ -315,16 +744,94 @@ func TestResponseError(t *testing.T) {
 			wantStatusCode:   http.StatusNotFound,
 			wantCacheControl: "must-revalidate, no-cache, no-store",
 			wantContent:      "not found: fetch timed out",
+			wantCode:         "fetch_timed_out",
 		},
 		{
 			n:              7,
 			err:            errors.New("internal server error"),
 			wantStatusCode: http.StatusInternalServerError,
 			wantContent:    "internal server error",
+			wantCode:       "internal_server_error",
+		},
+		{
+			n:                8,
+			err:              fmt.Errorf("failed to fetch: %w", &zipFileTooLargeError{maxSize: 1024}),
+			wantStatusCode:   http.StatusRequestEntityTooLarge,
+			wantCacheControl: "must-revalidate, no-cache, no-store",
+			wantContent:      "module zip file exceeds the maximum size of 1024 bytes",
+			wantCode:         "zip_file_too_large",
+		},
+		{
+			n:                9,
+			err:              &NotExistError{Gone: true, Err: notFoundError("unknown revision v1.0.0")},
+			wantStatusCode:   http.StatusGone,
+			wantCacheControl: "public, max-age=600",
+			wantContent:      "gone: unknown revision v1.0.0",
+			wantCode:         "gone",
+		},
+		{
+			n:                10,
+			err:              notFoundError("dial tcp: connection refused"),
+			wantStatusCode:   http.StatusNotFound,
+			wantCacheControl: "must-revalidate, no-cache, no-store",
+			wantContent:      "not found: bad upstream: dial tcp: connection refused",
+			wantCode:         "bad_upstream",
+		},
+		{
+			n:                11,
+			err:              fmt.Errorf("failed to fetch: %w", &UpstreamError{Err: errors.New("unexpected status")}),
+			wantStatusCode:   http.StatusNotFound,
+			wantCacheControl: "must-revalidate, no-cache, no-store",
+			wantContent:      "not found: bad upstream: unexpected status",
+			wantCode:         "bad_upstream",
+		},
+		{
+			n:                12,
+			err:              &PolicyError{ModulePath: "example.com", Reason: "blocked"},
+			wantStatusCode:   http.StatusForbidden,
+			wantCacheControl: "must-revalidate, no-cache, no-store",
+			wantContent:      "forbidden: module example.com is blocked by this proxy",
+			wantCode:         "forbidden",
+		},
+		{
+			n:                   13,
+			err:                 errBadUpstream,
+			upstreamErrorStatus: true,
+			wantStatusCode:      http.StatusBadGateway,
+			wantCacheControl:    "must-revalidate, no-cache, no-store",
+			wantContent:         "bad upstream",
+			wantCode:            "bad_upstream",
+		},
+		{
+			n:                   14,
+			err:                 notFoundError("not found: fetch timed out"),
+			upstreamErrorStatus: true,
+			wantStatusCode:      http.StatusGatewayTimeout,
+			wantCacheControl:    "must-revalidate, no-cache, no-store",
+			wantContent:         "fetch timed out",
+			wantCode:            "fetch_timed_out",
+		},
+		{
+			n:                   15,
+			err:                 fmt.Errorf("failed to fetch: %w", &UpstreamError{Err: errors.New("unexpected status")}),
+			upstreamErrorStatus: true,
+			wantStatusCode:      http.StatusBadGateway,
+			wantCacheControl:    "must-revalidate, no-cache, no-store",
+			wantContent:         "bad upstream: unexpected status",
+			wantCode:            "bad_upstream",
+		},
+		{
+			n:                   16,
+			err:                 errNotFound,
+			upstreamErrorStatus: true,
+			wantStatusCode:      http.StatusNotFound,
+			wantCacheControl:    "public, max-age=600",
+			wantContent:         "not found",
+			wantCode:            "not_found",
 		},
 	} {
 		rec := httptest.NewRecorder()
-		responseError(rec, httptest.NewRequest("", "/", nil), tt.err, tt.cacheSensitive)
+		responseError(rec, httptest.NewRequest("", "/", nil), tt.err, tt.cacheSensitive, tt.upstreamErrorStatus)
 		recr := rec.Result()
 		if got, want := recr.StatusCode, tt.wantStatusCode; got != want {
 			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
@@ -340,5 +847,24 @@ func TestResponseError(t *testing.T) {
 		} else if got, want := string(b), tt.wantContent; got != want {
 			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
 		}
+
+		req := httptest.NewRequest("", "/", nil)
+		req.Header.Set("Accept", "application/json")
+		rec = httptest.NewRecorder()
+		responseError(rec, req, tt.err, tt.cacheSensitive, tt.upstreamErrorStatus)
+		recr = rec.Result()
+		var body struct {
+			Code    string
+			Message string
+		}
+		if err := json.NewDecoder(recr.Body).Decode(&body); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		}
+		if got, want := body.Code, tt.wantCode; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		if got, want := body.Message, tt.wantContent; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
 	}
 }
@@ -0,0 +1,193 @@
+package goproxy
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+)
+
+// modulePathRewrite is an entry of [Goproxy.ModulePathRewrites].
+type modulePathRewrite struct {
+	from string
+	to   string
+}
+
+// newModulePathRewrites returns the [modulePathRewrite]s of the
+// modulePathRewrites, sorted so that longer prefixes come first.
+func newModulePathRewrites(modulePathRewrites map[string]string) []modulePathRewrite {
+	var mprs []modulePathRewrite
+	for from, to := range modulePathRewrites {
+		from = strings.TrimSuffix(strings.TrimSpace(from), "/")
+		to = strings.TrimSuffix(strings.TrimSpace(to), "/")
+		if from != "" && to != "" && from != to {
+			mprs = append(mprs, modulePathRewrite{from: from, to: to})
+		}
+	}
+	sort.Slice(mprs, func(i, j int) bool {
+		if len(mprs[i].from) != len(mprs[j].from) {
+			return len(mprs[i].from) > len(mprs[j].from)
+		}
+		return mprs[i].from < mprs[j].from
+	})
+	return mprs
+}
+
+// rewriteModulePath returns the path of the module fetched in place of the
+// modulePath according to the g.ModulePathRewrites, and reports whether there
+// is one.
+func (g *Goproxy) rewriteModulePath(modulePath string) (string, bool) {
+	for _, mpr := range g.modulePathRewrites {
+		if rest, ok := strings.CutPrefix(modulePath, mpr.from); ok && (rest == "" || rest[0] == '/') {
+			return mpr.to + rest, true
+		}
+	}
+	return "", false
+}
+
+// doRewritten executes the f by fetching the module of the sourceModulePath
+// instead, and then rewriting the module path in its go.mod and zip files, if
+// any, back to the one of the f.
+func (f *fetch) doRewritten(ctx context.Context, sourceModulePath string) (*fetchResult, error) {
+	escapedModulePath, err := module.EscapePath(f.modulePath)
+	if err != nil {
+		return nil, err
+	}
+	escapedSourceModulePath, err := module.EscapePath(sourceModulePath)
+	if err != nil {
+		return nil, err
+	}
+	sf, err := newFetch(f.g, escapedSourceModulePath+strings.TrimPrefix(f.name, escapedModulePath), f.tempDir)
+	if err != nil {
+		return nil, err
+	}
+	r, err := sf.doWalkGOPROXY(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r.f = f
+	if r.GoMod != "" {
+		if r.GoMod, err = rewriteGoModFile(f.tempDir, r.GoMod, sourceModulePath, f.modulePath); err != nil {
+			return nil, fmt.Errorf("rewrite go.mod file: %w", err)
+		}
+	}
+	if r.Zip != "" {
+		if r.Zip, err = rewriteZipFile(f.tempDir, r.Zip, sf.modAtVer, f.modAtVer, sourceModulePath, f.modulePath); err != nil {
+			return nil, fmt.Errorf("rewrite zip file: %w", err)
+		}
+		r.Sum = ""
+	}
+	return r, nil
+}
+
+// rewriteGoMod returns the go.mod file content mod with its module path
+// rewritten from the sourceModulePath to the modulePath. The mod is returned as
+// is if it declares another module path.
+func rewriteGoMod(mod []byte, sourceModulePath, modulePath string) ([]byte, error) {
+	mf, err := modfile.ParseLax("go.mod", mod, nil)
+	if err != nil {
+		return nil, err
+	}
+	if mf.Module == nil || mf.Module.Mod.Path != sourceModulePath {
+		return mod, nil
+	}
+	if err := mf.AddModuleStmt(modulePath); err != nil {
+		return nil, err
+	}
+	return mf.Format()
+}
+
+// rewriteGoModFile writes the go.mod file named by the name with its module path
+// rewritten as by [rewriteGoMod] to a new file in the tempDir, and returns
+// the name of the new file.
+func rewriteGoModFile(tempDir, name, sourceModulePath, modulePath string) (string, error) {
+	mod, err := os.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	mod, err = rewriteGoMod(mod, sourceModulePath, modulePath)
+	if err != nil {
+		return "", err
+	}
+	tempFile, err := os.CreateTemp(tempDir, "")
+	if err != nil {
+		return "", err
+	}
+	if _, err := tempFile.Write(mod); err != nil {
+		tempFile.Close()
+		return "", err
+	}
+	return tempFile.Name(), tempFile.Close()
+}
+
+// rewriteZipFile writes the module zip file named by the name with the
+// sourceModAtVer prefix of its entries replaced by the modAtVer, and the module
+// path of its go.mod file rewritten as by [rewriteGoMod], to a new file in the
+// tempDir, and returns the name of the new file. Other entries are copied
+// without being recompressed.
+func rewriteZipFile(tempDir, name, sourceModAtVer, modAtVer, sourceModulePath, modulePath string) (string, error) {
+	zr, err := zip.OpenReader(name)
+	if err != nil {
+		return "", err
+	}
+	defer zr.Close()
+
+	tempFile, err := os.CreateTemp(tempDir, "")
+	if err != nil {
+		return "", err
+	}
+	defer tempFile.Close()
+	zw := zip.NewWriter(tempFile)
+	for _, zf := range zr.File {
+		rest, ok := strings.CutPrefix(zf.Name, sourceModAtVer+"/")
+		if !ok {
+			return "", fmt.Errorf("unexpected file %q", zf.Name)
+		}
+		fh := zf.FileHeader
+		fh.Name = modAtVer + "/" + rest
+		if rest != "go.mod" {
+			rc, err := zf.OpenRaw()
+			if err != nil {
+				return "", err
+			}
+			w, err := zw.CreateRaw(&fh)
+			if err != nil {
+				return "", err
+			}
+			if _, err := io.Copy(w, rc); err != nil {
+				return "", err
+			}
+			continue
+		}
+
+		rc, err := zf.Open()
+		if err != nil {
+			return "", err
+		}
+		mod, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return "", err
+		}
+		if mod, err = rewriteGoMod(mod, sourceModulePath, modulePath); err != nil {
+			return "", err
+		}
+		w, err := zw.CreateHeader(&fh)
+		if err != nil {
+			return "", err
+		}
+		if _, err := w.Write(mod); err != nil {
+			return "", err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+	return tempFile.Name(), tempFile.Close()
+}
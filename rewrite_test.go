@@ -0,0 +1,201 @@
+package goproxy
+
+import (
+	"archive/zip"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestGoproxyRewriteModulePath(t *testing.T) {
+	g := &Goproxy{ModulePathRewrites: map[string]string{
+		"git.old.corp":        "git.new.corp",
+		" git.old.corp/team/": "git.team.corp",
+		"example.com":         "",
+		"example.org":         "example.org",
+	}}
+	g.init()
+	for _, tt := range []struct {
+		n              int
+		modulePath     string
+		wantModulePath string
+		wantOK         bool
+	}{
+		{1, "git.old.corp/x", "git.new.corp/x", true},
+		{2, "git.old.corp", "git.new.corp", true},
+		{3, "git.old.corp/team/x/v2", "git.team.corp/x/v2", true},
+		{4, "git.old.corp/teams/x", "git.new.corp/teams/x", true},
+		{5, "git.old.corporate/x", "", false},
+		{6, "example.com/x", "", false},
+		{7, "example.org/x", "", false},
+	} {
+		modulePath, ok := g.rewriteModulePath(tt.modulePath)
+		if got, want := ok, tt.wantOK; got != want {
+			t.Errorf("test(%d): got %t, want %t", tt.n, got, want)
+		}
+		if got, want := modulePath, tt.wantModulePath; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+}
+
+func TestRewriteGoMod(t *testing.T) {
+	for _, tt := range []struct {
+		n       int
+		mod     string
+		wantMod string
+	}{
+		{1, "module git.new.corp/x\n", "module git.old.corp/x\n"},
+		{2, "module git.new.corp/x\n\ngo 1.21\n\nrequire example.com v1.0.0\n", "module git.old.corp/x\n\ngo 1.21\n\nrequire example.com v1.0.0\n"},
+		{3, "module git.old.corp/x\n", "module git.old.corp/x\n"},
+		{4, "go 1.21\n", "go 1.21\n"},
+	} {
+		mod, err := rewriteGoMod([]byte(tt.mod), "git.new.corp/x", "git.old.corp/x")
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		}
+		if got, want := string(mod), tt.wantMod; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+}
+
+func TestRewriteZipFile(t *testing.T) {
+	tempDir := t.TempDir()
+	zipFile := filepath.Join(tempDir, "source.zip")
+	if err := writeZipFile(zipFile, map[string][]byte{
+		"git.new.corp/x@v1.0.0/go.mod":  []byte("module git.new.corp/x\n"),
+		"git.new.corp/x@v1.0.0/main.go": []byte("package main\n"),
+	}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	name, err := rewriteZipFile(tempDir, zipFile, "git.new.corp/x@v1.0.0", "git.old.corp/x@v1.0.0", "git.new.corp/x", "git.old.corp/x")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if got, want := readZipFiles(t, name), "git.old.corp/x@v1.0.0/go.mod=module git.old.corp/x\n,git.old.corp/x@v1.0.0/main.go=package main\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if err := writeZipFile(zipFile, map[string][]byte{"other/main.go": []byte("package main\n")}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if _, err := rewriteZipFile(tempDir, zipFile, "git.new.corp/x@v1.0.0", "git.old.corp/x@v1.0.0", "git.new.corp/x", "git.old.corp/x"); err == nil {
+		t.Fatal("expected error")
+	} else if got, want := err.Error(), `unexpected file "other/main.go"`; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestGoproxyServeFetchModulePathRewrites(t *testing.T) {
+	zipFile := filepath.Join(t.TempDir(), "source.zip")
+	if err := writeZipFile(zipFile, map[string][]byte{
+		"git.new.corp/x@v1.0.0/go.mod":  []byte("module git.new.corp/x\n"),
+		"git.new.corp/x@v1.0.0/main.go": []byte("package main\n"),
+	}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	zipContent, err := os.ReadFile(zipFile)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	proxyServer, setProxyHandler := newHTTPTestServer()
+	defer proxyServer.Close()
+	var requestedPaths []string
+	setProxyHandler(func(rw http.ResponseWriter, req *http.Request) {
+		requestedPaths = append(requestedPaths, req.URL.Path)
+		switch req.URL.Path {
+		case "/git.new.corp/x/@v/list":
+			rw.Write([]byte("v1.0.0"))
+		case "/git.new.corp/x/@v/v1.0.0.info":
+			rw.Write([]byte(`{"Version":"v1.0.0","Time":"2000-01-01T00:00:00Z"}`))
+		case "/git.new.corp/x/@v/v1.0.0.mod":
+			rw.Write([]byte("module git.new.corp/x\n"))
+		case "/git.new.corp/x/@v/v1.0.0.zip":
+			rw.Write(zipContent)
+		default:
+			responseNotFound(rw, req, -1)
+		}
+	})
+
+	cacher := DirCacher(t.TempDir())
+	g := &Goproxy{
+		Env:                []string{"GOPROXY=" + proxyServer.URL, "GOSUMDB=off"},
+		Cacher:             cacher,
+		TempDir:            t.TempDir(),
+		ModulePathRewrites: map[string]string{"git.old.corp": "git.new.corp"},
+		ErrorLogger:        log.New(io.Discard, "", 0),
+	}
+	for _, tt := range []struct {
+		n           int
+		path        string
+		wantContent string
+	}{
+		{1, "/git.old.corp/x/@v/list", "v1.0.0"},
+		{2, "/git.old.corp/x/@v/v1.0.0.info", `{"Version":"v1.0.0","Time":"2000-01-01T00:00:00Z"}`},
+		{3, "/git.old.corp/x/@v/v1.0.0.mod", "module git.old.corp/x\n"},
+	} {
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		recr := rec.Result()
+		if got, want := recr.StatusCode, http.StatusOK; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if b, err := io.ReadAll(recr.Body); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		} else if got, want := string(b), tt.wantContent; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/git.old.corp/x/@v/v1.0.0.zip", nil))
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+	servedZipFile := filepath.Join(t.TempDir(), "served.zip")
+	if err := os.WriteFile(servedZipFile, rec.Body.Bytes(), 0o644); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if got, want := readZipFiles(t, servedZipFile), "git.old.corp/x@v1.0.0/go.mod=module git.old.corp/x\n,git.old.corp/x@v1.0.0/main.go=package main\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	for _, requestedPath := range requestedPaths {
+		if !strings.HasPrefix(requestedPath, "/git.new.corp/") {
+			t.Errorf("unexpected request for %q", requestedPath)
+		}
+	}
+}
+
+// readZipFiles returns the files of the zip file named by the name as sorted
+// comma-separated "<name>=<content>" pairs.
+func readZipFiles(t *testing.T, name string) string {
+	zr, err := zip.OpenReader(name)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer zr.Close()
+	var files []string
+	for _, zf := range zr.File {
+		rc, err := zf.Open()
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		files = append(files, zf.Name+"="+string(b))
+	}
+	sort.Strings(files)
+	return strings.Join(files, ",")
+}
@@ -0,0 +1,90 @@
+package goproxy
+
+import "strings"
+
+// moduleRoute is how a [Goproxy] fetches and verifies a module.
+type moduleRoute struct {
+	// direct indicates whether the module is fetched directly using the
+	// local go command instead of walking through the GOPROXY.
+	direct bool
+
+	// sumdb is the name of the checksum database the module is verified
+	// against, or empty if the module is served without verification.
+	sumdb string
+
+	// private indicates whether the module path must never be disclosed to
+	// any checksum database, so lookups of it are not proxied.
+	private bool
+}
+
+// moduleRoute returns the [moduleRoute] of the modulePath, decided from the
+// g's environment and fields the same way as the go command decides from its
+// environment:
+//
+//   - The module is fetched directly if it matches GONOPROXY or Private. An
+//     empty GONOPROXY defaults to GOPRIVATE, so a non-empty GONOPROXY
+//     overrides GOPRIVATE entirely.
+//   - The module is verified against the checksum database named by GOSUMDB,
+//     unless GOSUMDB is "off" or the module matches GONOSUMDB, NoSumCheck, or
+//     Private. An empty GONOSUMDB defaults to GOPRIVATE, so a non-empty
+//     GONOSUMDB overrides GOPRIVATE entirely.
+//   - The module is private to the checksum databases if it matches
+//     GONOSUMDB, NoSumCheck, or Private, regardless of GOSUMDB.
+//
+// Whether the module is fetched directly and whether it is verified are
+// independent, so a module matching only GONOPROXY is still verified.
+// GOINSECURE only affects how the go command connects to VCS hosts, never
+// whether the module is verified.
+func (g *Goproxy) moduleRoute(modulePath string) moduleRoute {
+	private := globsMatchPath(g.private, modulePath)
+	mr := moduleRoute{
+		direct:  private || globsMatchPath(g.envGONOPROXY, modulePath),
+		private: private || globsMatchPath(g.envGONOSUMDB, modulePath) || globsMatchPath(g.noSumCheck, modulePath),
+	}
+	if !mr.private && g.envGOSUMDB != "off" {
+		mr.sumdb = sumdbName(g.envGOSUMDB)
+	}
+	return mr
+}
+
+// sumdbName returns the name of the checksum database configured by the
+// envGOSUMDB.
+func sumdbName(envGOSUMDB string) string {
+	fields := strings.Fields(envGOSUMDB)
+	if len(fields) == 0 {
+		return ""
+	}
+	name, _, _ := strings.Cut(fields[0], "+")
+	if name == "sum.golang.google.cn" {
+		return "sum.golang.org"
+	}
+	return name
+}
+
+// DirectFetchEnabled reports whether the g may fetch modules directly from
+// their VCS hosts using the local go command, which is the case unless the
+// Offline or the DisableDirectFetch is set, or neither the GOPROXY reaches
+// "direct" nor any module path pattern routes modules to it. A GONOPROXY of
+// "none", as commonly used to route no modules directly, routes none.
+func (g *Goproxy) DirectFetchEnabled() bool {
+	g.initOnce.Do(g.init)
+	if g.Offline || g.DisableDirectFetch {
+		return false
+	}
+	if g.private != "" {
+		return true
+	}
+	for _, noproxy := range strings.Split(g.envGONOPROXY, ",") {
+		if noproxy != "" && noproxy != "none" {
+			return true
+		}
+	}
+	direct := false
+	walkGOPROXY(g.envGOPROXY, func(string) error {
+		return errNotFound
+	}, func() error {
+		direct = true
+		return nil
+	}, func() error { return nil })
+	return direct
+}
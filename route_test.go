@@ -0,0 +1,78 @@
+package goproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/mod/module"
+)
+
+func TestGoproxyModuleRoute(t *testing.T) {
+	for _, tt := range []struct {
+		n          int
+		env        []string
+		noSumCheck []string
+		private    []string
+		modulePath string
+		wantRoute  moduleRoute
+	}{
+		{1, nil, nil, nil, "example.com/foo", moduleRoute{sumdb: "sum.golang.org"}},
+		{2, []string{"GOPRIVATE=corp.example.com"}, nil, nil, "corp.example.com/foo", moduleRoute{direct: true, private: true}},
+		{3, []string{"GOPRIVATE=corp.example.com"}, nil, nil, "example.com/foo", moduleRoute{sumdb: "sum.golang.org"}},
+		{4, []string{"GOPRIVATE=corp.example.com", "GONOSUMDB=other.example.com"}, nil, nil, "corp.example.com/foo", moduleRoute{direct: true, sumdb: "sum.golang.org"}},
+		{5, []string{"GOPRIVATE=corp.example.com", "GONOSUMDB=other.example.com"}, nil, nil, "other.example.com/foo", moduleRoute{private: true}},
+		{6, []string{"GOPRIVATE=corp.example.com", "GONOPROXY=other.example.com"}, nil, nil, "corp.example.com/foo", moduleRoute{private: true}},
+		{7, []string{"GOPRIVATE=corp.example.com", "GONOPROXY=other.example.com"}, nil, nil, "other.example.com/foo", moduleRoute{direct: true, sumdb: "sum.golang.org"}},
+		{8, []string{"GOPRIVATE=corp.example.com", "GONOSUMDB="}, nil, nil, "corp.example.com/foo", moduleRoute{direct: true, private: true}},
+		{9, []string{"GONOPROXY=corp.example.com"}, nil, nil, "corp.example.com/foo", moduleRoute{direct: true, sumdb: "sum.golang.org"}},
+		{10, []string{"GONOSUMDB=corp.example.com"}, nil, nil, "corp.example.com/foo", moduleRoute{private: true}},
+		{11, []string{"GOSUMDB=off"}, nil, nil, "example.com/foo", moduleRoute{}},
+		{12, []string{"GOSUMDB=off", "GOPRIVATE=corp.example.com"}, nil, nil, "corp.example.com/foo", moduleRoute{direct: true, private: true}},
+		{13, []string{"GOINSECURE=corp.example.com"}, nil, nil, "corp.example.com/foo", moduleRoute{sumdb: "sum.golang.org"}},
+		{14, []string{"GOSUMDB=sumdb.example.com+key https://sumdb.example.com"}, nil, nil, "example.com/foo", moduleRoute{sumdb: "sumdb.example.com"}},
+		{15, []string{"GOSUMDB=sum.golang.google.cn"}, nil, nil, "example.com/foo", moduleRoute{sumdb: "sum.golang.org"}},
+		{16, nil, []string{"corp.example.com"}, nil, "corp.example.com/foo", moduleRoute{private: true}},
+		{17, []string{"GONOPROXY=other.example.com"}, nil, []string{"corp.example.com"}, "corp.example.com/foo", moduleRoute{direct: true, private: true}},
+		{18, []string{"GOPRIVATE=*.example.com"}, nil, nil, "corp.example.com/foo", moduleRoute{direct: true, private: true}},
+		{19, []string{"GOPRIVATE=*.example.com"}, nil, nil, "example.com/foo", moduleRoute{sumdb: "sum.golang.org"}},
+		{20, []string{"GOPRIVATE=corp.example.com/foo"}, nil, nil, "corp.example.com/foobar", moduleRoute{sumdb: "sum.golang.org"}},
+		{21, []string{"GOPRIVATE=example.com/corp"}, nil, nil, "example.com/Corp", moduleRoute{sumdb: "sum.golang.org"}},
+		{22, []string{"GOPRIVATE= corp.example.com , other.example.com "}, nil, nil, "other.example.com/foo", moduleRoute{direct: true, private: true}},
+	} {
+		g := &Goproxy{Env: tt.env, NoSumCheck: tt.noSumCheck, Private: tt.private}
+		if g.Env == nil {
+			g.Env = []string{}
+		}
+		g.init()
+		if got, want := g.moduleRoute(tt.modulePath), tt.wantRoute; got != want {
+			t.Errorf("test(%d): got %+v, want %+v", tt.n, got, want)
+		}
+		escapedModulePath, err := module.EscapePath(tt.modulePath)
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		}
+		f, err := newFetch(g, escapedModulePath+"/@v/list", t.TempDir())
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		}
+		if got, want := f.requiredToVerify, tt.wantRoute.sumdb != ""; got != want {
+			t.Errorf("test(%d): got %v, want %v", tt.n, got, want)
+		}
+	}
+}
+
+func TestGoproxyServeSUMDBLookupGONOSUMDB(t *testing.T) {
+	g := &Goproxy{
+		Env:           []string{"GOPRIVATE=corp.example.com"},
+		ProxiedSUMDBs: []string{"sum.golang.org"},
+	}
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/sumdb/sum.golang.org/lookup/corp.example.com/foo@v1.0.0", nil))
+	if got, want := rec.Code, http.StatusNotFound; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+	if got, want := rec.Body.String(), "not found: checksum database lookup disabled by this proxy"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
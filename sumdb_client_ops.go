@@ -16,14 +16,21 @@ import (
 const sumGolangOrgKey = "sum.golang.org+033de0ae+Ac4zctda0e5eza+HJyk9SxEdh+s3Ux18htTTAD8OuAn8"
 
 // sumdbClientOps implements [golang.org/x/mod/sumdb.ClientOps].
+//
+// The httpClient is used to reach the proxies in the envGOPROXY, and the
+// sumdbHTTPClient, if not nil, to reach the checksum database itself when no
+// proxy supports it.
 type sumdbClientOps struct {
-	initOnce    sync.Once
-	initError   error
-	key         []byte
-	endpointURL *url.URL
-	envGOPROXY  string
-	envGOSUMDB  string
-	httpClient  *http.Client
+	initOnce           sync.Once
+	initError          error
+	key                []byte
+	endpointURL        *url.URL
+	endpointHTTPClient *http.Client
+	envGOPROXY         string
+	envGOSUMDB         string
+	httpClient         *http.Client
+	sumdbHTTPClient    *http.Client
+	retryPolicy        retryPolicy
 }
 
 // init initializes the sco.
@@ -58,16 +65,21 @@ func (sco *sumdbClientOps) init() {
 	if sco.initError != nil {
 		return
 	}
+	sco.endpointHTTPClient = sco.sumdbHTTPClient
+	if sco.endpointHTTPClient == nil {
+		sco.endpointHTTPClient = sco.httpClient
+	}
 	if err := walkGOPROXY(sco.envGOPROXY, func(proxy string) error {
 		proxyURL, err := parseRawURL(proxy)
 		if err != nil {
 			return err
 		}
-		endpointURL := appendURL(proxyURL, "sumdb", sumdbName)
-		if err := httpGet(context.Background(), sco.httpClient, appendURL(endpointURL, "/supported").String(), nil); err != nil {
+		endpointURL := proxyURL.JoinPath("sumdb", sumdbName)
+		if err := httpGet(context.Background(), sco.httpClient, sco.retryPolicy, endpointURL.JoinPath("/supported").String(), nil); err != nil {
 			return err
 		}
 		sco.endpointURL = endpointURL
+		sco.endpointHTTPClient = sco.httpClient
 		return nil
 	}, func() error {
 		return nil
@@ -85,7 +97,7 @@ func (sco *sumdbClientOps) ReadRemote(path string) ([]byte, error) {
 		return nil, sco.initError
 	}
 	var buf bytes.Buffer
-	if err := httpGet(context.Background(), sco.httpClient, appendURL(sco.endpointURL, path).String(), &buf); err != nil {
+	if err := httpGet(context.Background(), sco.endpointHTTPClient, sco.retryPolicy, sco.endpointURL.JoinPath(path).String(), &buf); err != nil {
 		return nil, err
 	}
 	return buf.Bytes(), nil
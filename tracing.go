@@ -0,0 +1,54 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the name of the [trace.Tracer] used by [Goproxy].
+const tracerName = "github.com/goproxy/goproxy"
+
+// Attribute keys of the spans created by [Goproxy].
+const (
+	attrKeyModulePath    = attribute.Key("goproxy.module.path")
+	attrKeyModuleVersion = attribute.Key("goproxy.module.version")
+	attrKeyFetchOps      = attribute.Key("goproxy.fetch.ops")
+	attrKeyUpstream      = attribute.Key("goproxy.upstream")
+	attrKeyCacheName     = attribute.Key("goproxy.cache.name")
+	attrKeyCacheHit      = attribute.Key("goproxy.cache.hit")
+	attrKeyGoCommandArgs = attribute.Key("goproxy.go_command.args")
+)
+
+// startSpan starts a span with the name and attrs as a child of the span
+// carried by the ctx, if any.
+func (g *Goproxy) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	tracer := g.tracer
+	if tracer == nil {
+		tracer = trace.NewNoopTracerProvider().Tracer(tracerName)
+	}
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// fetchSpanAttrs returns the span attributes of the f.
+func fetchSpanAttrs(f *fetch) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attrKeyModulePath.String(f.modulePath),
+		attrKeyModuleVersion.String(f.moduleVersion),
+		attrKeyFetchOps.String(f.ops.String()),
+	}
+}
+
+// endSpan ends the span, recording the err unless it is nil or a "not found"
+// error, which is a normal outcome rather than a failure.
+func endSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,252 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type testTracerProvider struct {
+	mutex      sync.Mutex
+	spans      []*testSpan
+	nextSpanID byte
+}
+
+func (tp *testTracerProvider) Tracer(string, ...trace.TracerOption) trace.Tracer {
+	return &testTracer{tp: tp}
+}
+
+func (tp *testTracerProvider) span(name string) *testSpan {
+	tp.mutex.Lock()
+	defer tp.mutex.Unlock()
+	for _, s := range tp.spans {
+		if s.name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+type testTracer struct{ tp *testTracerProvider }
+
+func (t *testTracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	cfg := trace.NewSpanStartConfig(opts...)
+	parent := trace.SpanContextFromContext(ctx)
+	t.tp.mutex.Lock()
+	t.tp.nextSpanID++
+	traceID := parent.TraceID()
+	if !traceID.IsValid() {
+		traceID = trace.TraceID{1}
+	}
+	s := &testSpan{
+		Span:   trace.SpanFromContext(context.Background()),
+		name:   name,
+		parent: parent,
+		spanContext: trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID: traceID,
+			SpanID:  trace.SpanID{t.tp.nextSpanID},
+		}),
+		attrs: cfg.Attributes(),
+	}
+	t.tp.spans = append(t.tp.spans, s)
+	t.tp.mutex.Unlock()
+	return trace.ContextWithSpan(ctx, s), s
+}
+
+type testSpan struct {
+	trace.Span
+	mutex       sync.Mutex
+	name        string
+	parent      trace.SpanContext
+	spanContext trace.SpanContext
+	attrs       []attribute.KeyValue
+	statusCode  codes.Code
+	ended       bool
+}
+
+func (s *testSpan) SpanContext() trace.SpanContext { return s.spanContext }
+
+func (s *testSpan) SetAttributes(attrs ...attribute.KeyValue) {
+	s.mutex.Lock()
+	s.attrs = append(s.attrs, attrs...)
+	s.mutex.Unlock()
+}
+
+func (s *testSpan) SetStatus(code codes.Code, _ string) {
+	s.mutex.Lock()
+	s.statusCode = code
+	s.mutex.Unlock()
+}
+
+func (s *testSpan) End(...trace.SpanEndOption) {
+	s.mutex.Lock()
+	s.ended = true
+	s.mutex.Unlock()
+}
+
+func (s *testSpan) attr(key attribute.Key) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, attr := range s.attrs {
+		if attr.Key == key {
+			return attr.Value.Emit()
+		}
+	}
+	return ""
+}
+
+func TestGoproxyServeHTTPTracing(t *testing.T) {
+	proxyServer, setProxyHandler := newHTTPTestServer()
+	defer proxyServer.Close()
+	info := marshalInfo("v1.0.0", time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+	setProxyHandler(func(rw http.ResponseWriter, req *http.Request) {
+		responseSuccess(rw, req, strings.NewReader(info), "application/json; charset=utf-8", -2)
+	})
+
+	tp := &testTracerProvider{}
+	g := &Goproxy{
+		Env:            []string{"GOPROXY=" + proxyServer.URL, "GOSUMDB=off"},
+		Cacher:         DirCacher(t.TempDir()),
+		TracerProvider: tp,
+		ErrorLogger:    log.New(io.Discard, "", 0),
+	}
+	req := httptest.NewRequest(http.MethodGet, "/example.com/@v/v1.0.0.info", nil)
+	req.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, req)
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	rootSpan := tp.span(http.MethodGet)
+	if rootSpan == nil {
+		t.Fatal("expected root span")
+	}
+	if got, want := rootSpan.parent.TraceID().String(), "0af7651916cd43dd8448eb211c80319c"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := rootSpan.parent.SpanID().String(), "b7ad6b7169203331"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if !rootSpan.parent.IsRemote() {
+		t.Error("expected remote parent")
+	}
+	for key, want := range map[attribute.Key]string{
+		"url.path":                  "/example.com/@v/v1.0.0.info",
+		"http.response.status_code": "200",
+		attrKeyModulePath:           "example.com",
+		attrKeyModuleVersion:        "v1.0.0",
+	} {
+		if got := rootSpan.attr(key); got != want {
+			t.Errorf("%s: got %q, want %q", key, got, want)
+		}
+	}
+
+	for _, tt := range []struct {
+		n          int
+		name       string
+		parentName string
+		attrs      map[attribute.Key]string
+	}{
+		{1, "goproxy.cache.get", http.MethodGet, map[attribute.Key]string{
+			attrKeyCacheName: "example.com/@v/v1.0.0.info",
+			attrKeyCacheHit:  "false",
+		}},
+		{2, "goproxy.fetch", http.MethodGet, map[attribute.Key]string{
+			attrKeyModulePath:    "example.com",
+			attrKeyModuleVersion: "v1.0.0",
+			attrKeyFetchOps:      "download info",
+		}},
+		{3, "goproxy.fetch.proxy", "goproxy.fetch", map[attribute.Key]string{
+			attrKeyUpstream: proxyServer.URL,
+		}},
+		{4, "goproxy.cache.put", http.MethodGet, map[attribute.Key]string{
+			attrKeyCacheName: "example.com/@v/v1.0.0.info",
+		}},
+	} {
+		s := tp.span(tt.name)
+		if s == nil {
+			t.Errorf("test(%d): expected span %q", tt.n, tt.name)
+			continue
+		}
+		if got, want := s.parent.SpanID(), tp.span(tt.parentName).spanContext.SpanID(); got != want {
+			t.Errorf("test(%d): got %s, want %s", tt.n, got, want)
+		}
+		if got, want := s.spanContext.TraceID(), rootSpan.parent.TraceID(); got != want {
+			t.Errorf("test(%d): got %s, want %s", tt.n, got, want)
+		}
+		for key, want := range tt.attrs {
+			if got := s.attr(key); got != want {
+				t.Errorf("test(%d): %s: got %q, want %q", tt.n, key, got, want)
+			}
+		}
+		if !s.ended {
+			t.Errorf("test(%d): expected ended span", tt.n)
+		}
+		if got, want := s.statusCode, codes.Unset; got != want {
+			t.Errorf("test(%d): got %v, want %v", tt.n, got, want)
+		}
+	}
+}
+
+func TestGoproxyTracingGoCommand(t *testing.T) {
+	tp := &testTracerProvider{}
+	g := &Goproxy{
+		Env:            []string{"GOPROXY=direct", "GOSUMDB=off"},
+		GoBinName:      "goproxy-nonexistent-go",
+		TracerProvider: tp,
+		ErrorLogger:    log.New(io.Discard, "", 0),
+	}
+	g.init()
+	f, err := newFetch(g, "example.com/@v/v1.0.0.info", t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if _, err := f.do(context.Background()); err == nil {
+		t.Fatal("expected error")
+	}
+	s := tp.span("goproxy.go_command")
+	if s == nil {
+		t.Fatal("expected span")
+	}
+	if got, want := s.attr(attrKeyGoCommandArgs), "[mod download -json example.com@v1.0.0]"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := s.statusCode, codes.Error; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestEndSpan(t *testing.T) {
+	for _, tt := range []struct {
+		n              int
+		err            error
+		wantStatusCode codes.Code
+	}{
+		{1, nil, codes.Unset},
+		{2, fs.ErrNotExist, codes.Unset},
+		{3, notFoundError("not found"), codes.Unset},
+		{4, errors.New("foobar"), codes.Error},
+	} {
+		s := &testSpan{Span: trace.SpanFromContext(context.Background())}
+		endSpan(s, tt.err)
+		if got, want := s.statusCode, tt.wantStatusCode; got != want {
+			t.Errorf("test(%d): got %v, want %v", tt.n, got, want)
+		}
+		if !s.ended {
+			t.Errorf("test(%d): expected ended span", tt.n)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package goproxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/module"
+)
+
+// validateCacheName is the name of the probe cache put by [Goproxy.Validate]. It
+// can never be the name of a module file.
+const validateCacheName = ".goproxy-validate"
+
+// Validate checks the configuration of the g and returns the first problem
+// found, so that misconfigurations are caught at startup instead of failing
+// requests later. It checks that:
+//   - the Go binary targeted by the GoBinName is runnable and its version is
+//     accepted by [Goproxy.GoVersion], if [Goproxy.DirectFetchEnabled];
+//   - the TempDir is writable;
+//   - the Cacher is writable, if it can also delete the probe it puts, as
+//     the built-in cachers can;
+//   - the ProxiedSUMDBs entries are valid;
+//   - the GOPROXY lists at least one proxy, if the DisableDirectFetch is set;
+//   - the ModulePathRewrites entries are valid module paths;
+//   - the NoSumCheck entries do not match any host, as "*" does;
+//   - the WebhookURL, if any, is an absolute HTTP or HTTPS URL;
+//   - the durations are not negative.
+//
+// Validate must not be called while the g is serving requests, as it may put
+// and delete a cache named ".goproxy-validate".
+func (g *Goproxy) Validate() error {
+	g.initOnce.Do(g.init)
+	ctx := context.Background()
+
+	for _, d := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"FetchRetryBackoff", g.FetchRetryBackoff},
+		{"FetchTimeout", g.FetchTimeout},
+		{"ListFetchTimeout", g.ListFetchTimeout},
+		{"InfoFetchTimeout", g.InfoFetchTimeout},
+		{"ModFetchTimeout", g.ModFetchTimeout},
+		{"ZipFetchTimeout", g.ZipFetchTimeout},
+		{"CircuitBreakerWindow", g.CircuitBreakerWindow},
+		{"CircuitBreakerCooldown", g.CircuitBreakerCooldown},
+		{"MaxStaleAge", g.MaxStaleAge},
+		{"ListCacheTTL", g.ListCacheTTL},
+		{"MaxRequestQueueWait", g.MaxRequestQueueWait},
+		{"NotFoundTTL", g.NotFoundTTL},
+		{"NotFoundQueryTTL", g.NotFoundQueryTTL},
+	} {
+		if d.value < 0 {
+			return fmt.Errorf("invalid %s %v: must not be negative", d.name, d.value)
+		}
+	}
+
+	for _, proxiedSUMDB := range g.ProxiedSUMDBs {
+		sumdbParts := strings.Fields(proxiedSUMDB)
+		switch len(sumdbParts) {
+		case 0:
+			continue
+		case 1, 2:
+		default:
+			return fmt.Errorf("invalid ProxiedSUMDBs entry %q: too many fields", proxiedSUMDB)
+		}
+		rawSUMDBURL := sumdbParts[len(sumdbParts)-1]
+		if _, err := parseRawURL(rawSUMDBURL); err != nil {
+			return fmt.Errorf("invalid ProxiedSUMDBs entry %q: %w", proxiedSUMDB, err)
+		}
+	}
+
+	if g.DisableDirectFetch {
+		hasProxy := false
+		walkGOPROXY(g.envGOPROXY, func(string) error {
+			hasProxy = true
+			return errNotFound
+		}, func() error { return nil }, func() error { return nil })
+		if !hasProxy {
+			return fmt.Errorf("invalid GOPROXY %q: no proxies to fetch from with DisableDirectFetch", g.envGOPROXY)
+		}
+	}
+
+	for from, to := range g.ModulePathRewrites {
+		for _, modulePath := range []string{from, to} {
+			if err := module.CheckImportPath(modulePath); err != nil {
+				return fmt.Errorf("invalid ModulePathRewrites entry %q: %w", from+"="+to, err)
+			}
+		}
+	}
+
+	for _, pattern := range g.NoSumCheck {
+		if isCatchAllPathPattern(pattern) {
+			return fmt.Errorf("invalid NoSumCheck entry %q: must not match public modules", pattern)
+		}
+	}
+
+	if g.WebhookURL != "" {
+		if u, err := url.Parse(g.WebhookURL); err != nil {
+			return fmt.Errorf("invalid WebhookURL: %w", err)
+		} else if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid WebhookURL %q: must be an absolute HTTP or HTTPS URL", g.WebhookURL)
+		}
+	}
+
+	if g.DirectFetchEnabled() {
+		if _, err := g.GoVersion(ctx); err != nil {
+			return fmt.Errorf("invalid GoBinName %q: %w", g.goBinName, err)
+		}
+	}
+
+	tempDir, err := os.MkdirTemp(g.TempDir, "goproxy.tmp.*")
+	if err != nil {
+		return fmt.Errorf("invalid TempDir: %w", err)
+	}
+	if err := os.RemoveAll(tempDir); err != nil {
+		return fmt.Errorf("invalid TempDir: %w", err)
+	}
+
+	if dc, ok := g.Cacher.(deleterCacher); ok {
+		if err := validateCacher(ctx, dc); err != nil {
+			return fmt.Errorf("invalid Cacher: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// isCatchAllPathPattern reports whether the pattern, in the same form as
+// GONOSUMDB entries, matches module paths of any host, such as "*" or "*.*".
+func isCatchAllPathPattern(pattern string) bool {
+	host, _, _ := strings.Cut(strings.TrimSpace(pattern), "/")
+	for _, publicHost := range []string{"github.com", "golang.org"} {
+		if ok, err := path.Match(host, publicHost); err != nil || !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// validateCacher checks that the dc can put, get, and delete a cache.
+func validateCacher(ctx context.Context, dc deleterCacher) error {
+	content := []byte("goproxy")
+	if err := dc.Put(ctx, validateCacheName, bytes.NewReader(content)); err != nil {
+		return err
+	}
+	rc, err := dc.Get(ctx, validateCacheName)
+	if err == nil {
+		var b []byte
+		b, err = io.ReadAll(rc)
+		rc.Close()
+		if err == nil && !bytes.Equal(b, content) {
+			err = errors.New("mismatched cache content")
+		}
+	}
+	if deleteErr := dc.Delete(ctx, validateCacheName); err == nil {
+		err = deleteErr
+	}
+	return err
+}
@@ -0,0 +1,93 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+type putFailingCacher struct{}
+
+func (putFailingCacher) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	return nil, os.ErrNotExist
+}
+
+func (putFailingCacher) Put(ctx context.Context, name string, content io.ReadSeeker) error {
+	return errors.New("put failed")
+}
+
+func TestGoproxyValidate(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping test that requires a shell script as the go binary")
+	}
+	goBin := filepath.Join(t.TempDir(), "go")
+	if err := os.WriteFile(goBin, []byte("#!/bin/sh\necho 'go version go1.21.0 linux/amd64'\n"), 0o755); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	notDir := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(notDir, nil, 0o644); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	for _, tt := range []struct {
+		n       int
+		g       *Goproxy
+		wantErr string
+	}{
+		{1, &Goproxy{GoBinName: goBin, TempDir: t.TempDir(), Cacher: DirCacher(t.TempDir())}, ""},
+		{2, &Goproxy{GoBinName: goBin, TempDir: t.TempDir(), Cacher: &MemoryCacher{}}, ""},
+		{3, &Goproxy{GoBinName: goBin, TempDir: t.TempDir(), Cacher: putFailingCacher{}}, ""},
+		{4, &Goproxy{GoBinName: goBin, TempDir: t.TempDir(), ProxiedSUMDBs: []string{"", "sum.golang.org", "sum.example.com https://sum.example.com"}}, ""},
+		{5, &Goproxy{GoBinName: goBin, TempDir: t.TempDir(), FetchRetryBackoff: -time.Second}, "invalid FetchRetryBackoff -1s: must not be negative"},
+		{6, &Goproxy{GoBinName: goBin, TempDir: t.TempDir(), NotFoundQueryTTL: -time.Second}, "invalid NotFoundQueryTTL -1s: must not be negative"},
+		{7, &Goproxy{GoBinName: goBin, TempDir: t.TempDir(), ProxiedSUMDBs: []string{"sum.example.com https://sum.example.com foo"}}, `invalid ProxiedSUMDBs entry "sum.example.com https://sum.example.com foo": too many fields`},
+		{8, &Goproxy{GoBinName: goBin, TempDir: t.TempDir(), ProxiedSUMDBs: []string{"sum.example.com %zz"}}, `invalid ProxiedSUMDBs entry "sum.example.com %zz": `},
+		{9, &Goproxy{GoBinName: filepath.Join(t.TempDir(), "go"), TempDir: t.TempDir()}, `invalid GoBinName "`},
+		{10, &Goproxy{GoBinName: goBin, TempDir: filepath.Join(t.TempDir(), "404")}, "invalid TempDir: "},
+		{11, &Goproxy{GoBinName: goBin, TempDir: t.TempDir(), Cacher: DirCacher(notDir)}, "invalid Cacher: "},
+		{12, &Goproxy{GoBinName: goBin, TempDir: t.TempDir(), DisableDirectFetch: true}, ""},
+		{13, &Goproxy{Env: []string{"GOPROXY=direct"}, GoBinName: goBin, TempDir: t.TempDir(), DisableDirectFetch: true}, `invalid GOPROXY "direct": no proxies to fetch from with DisableDirectFetch`},
+		{14, &Goproxy{Env: []string{"GOPROXY=off"}, GoBinName: goBin, TempDir: t.TempDir(), DisableDirectFetch: true}, `invalid GOPROXY "off": no proxies to fetch from with DisableDirectFetch`},
+		{15, &Goproxy{GoBinName: goBin, TempDir: t.TempDir(), WebhookURL: "https://example.com/hook"}, ""},
+		{16, &Goproxy{GoBinName: goBin, TempDir: t.TempDir(), WebhookURL: "example.com/hook"}, `invalid WebhookURL "example.com/hook": must be an absolute HTTP or HTTPS URL`},
+		{17, &Goproxy{GoBinName: goBin, TempDir: t.TempDir(), WebhookURL: "%zz"}, "invalid WebhookURL: "},
+		{18, &Goproxy{GoBinName: goBin, TempDir: t.TempDir(), ModulePathRewrites: map[string]string{"git.old.corp": "git.new.corp"}}, ""},
+		{19, &Goproxy{GoBinName: goBin, TempDir: t.TempDir(), ModulePathRewrites: map[string]string{"git.old.corp": "git new corp"}}, `invalid ModulePathRewrites entry "git.old.corp=git new corp": `},
+		{20, &Goproxy{GoBinName: filepath.Join(t.TempDir(), "go"), TempDir: t.TempDir(), Offline: true}, ""},
+		{21, &Goproxy{GoBinName: filepath.Join(t.TempDir(), "go"), TempDir: t.TempDir(), DisableDirectFetch: true}, ""},
+		{22, &Goproxy{Env: []string{"GOPROXY=https://proxy.example.com", "GONOPROXY=none"}, GoBinName: filepath.Join(t.TempDir(), "go"), TempDir: t.TempDir()}, ""},
+		{23, &Goproxy{Env: []string{"GOPROXY=https://proxy.example.com", "GONOPROXY=corp.example.com"}, GoBinName: filepath.Join(t.TempDir(), "go"), TempDir: t.TempDir()}, `invalid GoBinName "`},
+		{24, &Goproxy{Env: []string{"GOPROXY=https://proxy.example.com|direct"}, GoBinName: filepath.Join(t.TempDir(), "go"), TempDir: t.TempDir()}, `invalid GoBinName "`},
+		{25, &Goproxy{Env: []string{"GOPROXY=https://proxy.example.com"}, GoBinName: filepath.Join(t.TempDir(), "go"), TempDir: t.TempDir(), Private: []string{"corp.example.com"}}, `invalid GoBinName "`},
+		{26, &Goproxy{GoBinName: goBin, TempDir: t.TempDir(), NoSumCheck: []string{"corp.example.com", "*.corp.example.com/*"}}, ""},
+		{27, &Goproxy{GoBinName: goBin, TempDir: t.TempDir(), NoSumCheck: []string{"corp.example.com", " * "}}, `invalid NoSumCheck entry " * ": must not match public modules`},
+		{28, &Goproxy{GoBinName: goBin, TempDir: t.TempDir(), NoSumCheck: []string{"*.*/internal"}}, `invalid NoSumCheck entry "*.*/internal": must not match public modules`},
+	} {
+		err := tt.g.Validate()
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("test(%d): unexpected error %q", tt.n, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("test(%d): expected error", tt.n)
+		} else if got, want := err.Error(), tt.wantErr; !strings.HasPrefix(got, want) {
+			t.Errorf("test(%d): got %q, want prefix %q", tt.n, got, want)
+		}
+	}
+
+	dc := DirCacher(t.TempDir())
+	g := &Goproxy{GoBinName: goBin, TempDir: t.TempDir(), Cacher: dc}
+	if err := g.Validate(); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if _, err := os.Stat(filepath.Join(string(dc), validateCacheName)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got %v, want %v", err, os.ErrNotExist)
+	}
+}
@@ -0,0 +1,125 @@
+package goproxy
+
+import (
+	"fmt"
+	"html"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// VanityImport is the VCS repository of the modules whose import paths are
+// prefixed by a key of [Goproxy.VanityImports].
+type VanityImport struct {
+	// VCS is the version control system of the repository, such as "git".
+	VCS string
+
+	// RepoRoot is the root URL of the repository, such as
+	// "https://git.example.com/myteam/lib.git".
+	RepoRoot string
+
+	// Home is the URL of the repository browser, such as
+	// "https://git.example.com/myteam/lib". Requests without the "go-get=1"
+	// query are redirected to it, or to the RepoRoot if it is empty.
+	//
+	// If Home is not empty, a go-source meta tag is served along with the
+	// go-import one.
+	Home string
+
+	// Directory and File are the URL templates of the directories and
+	// files of the repository served in the go-source meta tag, such as
+	// "https://git.example.com/myteam/lib/tree/main{/dir}" and
+	// "https://git.example.com/myteam/lib/blob/main{/dir}/{file}#L{line}".
+	// Empty ones are served as "_".
+	Directory string
+	File      string
+}
+
+// vanityImport is an entry of [Goproxy.VanityImports].
+type vanityImport struct {
+	prefix string
+	VanityImport
+}
+
+// newVanityImports returns the [vanityImport]s of the vanityImports, sorted so
+// that longer prefixes come first.
+func newVanityImports(vanityImports map[string]VanityImport) []vanityImport {
+	var vis []vanityImport
+	for prefix, vi := range vanityImports {
+		prefix = strings.TrimSuffix(strings.TrimSpace(prefix), "/")
+		if prefix != "" && vi.VCS != "" && vi.RepoRoot != "" {
+			vis = append(vis, vanityImport{prefix: prefix, VanityImport: vi})
+		}
+	}
+	sort.Slice(vis, func(i, j int) bool {
+		if len(vis[i].prefix) != len(vis[j].prefix) {
+			return len(vis[i].prefix) > len(vis[j].prefix)
+		}
+		return vis[i].prefix < vis[j].prefix
+	})
+	return vis
+}
+
+// vanityImport returns the [vanityImport] of the import path requested by the
+// req, and reports whether there is one. The import path is made of the host
+// and path of the req. Requests for module files and checksum databases never
+// have one unless they carry the "go-get=1" query.
+func (g *Goproxy) vanityImport(req *http.Request) (vanityImport, bool) {
+	if len(g.vanityImports) == 0 {
+		return vanityImport{}, false
+	}
+	if req.URL.Query().Get("go-get") != "1" &&
+		(strings.Contains(req.URL.Path, "/@") || strings.HasPrefix(req.URL.Path, "/sumdb/")) {
+		return vanityImport{}, false
+	}
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	importPath := strings.TrimSuffix(strings.ToLower(host)+cleanPath(req.URL.Path), "/")
+	for _, vi := range g.vanityImports {
+		if rest, ok := strings.CutPrefix(importPath, vi.prefix); ok && (rest == "" || rest[0] == '/') {
+			return vi, true
+		}
+	}
+	return vanityImport{}, false
+}
+
+// serveVanityImport serves the go-import and go-source meta tags of the vi for
+// requests with the "go-get=1" query, and redirects other requests to the
+// repository browser of the vi.
+func serveVanityImport(rw http.ResponseWriter, req *http.Request, vi vanityImport) {
+	setResponseCacheControlHeader(rw, 3600)
+	if req.URL.Query().Get("go-get") != "1" {
+		home := vi.Home
+		if home == "" {
+			home = vi.RepoRoot
+		}
+		http.Redirect(rw, req, home, http.StatusFound)
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE html>\n<html>\n<head>\n")
+	fmt.Fprintf(&b, "<meta name=\"go-import\" content=\"%s\">\n", html.EscapeString(vi.prefix+" "+vi.VCS+" "+vi.RepoRoot))
+	if vi.Home != "" {
+		directory, file := vi.Directory, vi.File
+		if directory == "" {
+			directory = "_"
+		}
+		if file == "" {
+			file = "_"
+		}
+		fmt.Fprintf(&b, "<meta name=\"go-source\" content=\"%s\">\n", html.EscapeString(vi.prefix+" "+vi.Home+" "+directory+" "+file))
+	}
+	b.WriteString("</head>\n<body>\n")
+	fmt.Fprintf(&b, "go get %s\n", html.EscapeString(vi.prefix))
+	b.WriteString("</body>\n</html>\n")
+
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.WriteHeader(http.StatusOK)
+	if req.Method != http.MethodHead {
+		rw.Write([]byte(b.String()))
+	}
+}
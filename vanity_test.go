@@ -0,0 +1,73 @@
+package goproxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGoproxyServeHTTPVanityImports(t *testing.T) {
+	cacher := &MemoryCacher{}
+	if err := cacher.Put(context.Background(), "git.example.com/myteam/lib/@v/list", strings.NewReader("v1.0.0\n")); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	g := &Goproxy{
+		Cacher:  cacher,
+		Offline: true,
+		VanityImports: map[string]VanityImport{
+			"git.example.com/myteam/lib": {
+				VCS:      "git",
+				RepoRoot: "https://vcs.example.com/myteam/lib.git",
+				Home:     "https://vcs.example.com/myteam/lib",
+				File:     "https://vcs.example.com/myteam/lib/blob/main{/dir}/{file}#L{line}",
+			},
+			"git.example.com/myteam/lib/v2": {VCS: "git", RepoRoot: "https://vcs.example.com/myteam/lib-v2.git"},
+			"git.example.com/invalid":       {VCS: "git"},
+		},
+	}
+	for _, tt := range []struct {
+		n              int
+		url            string
+		wantStatusCode int
+		wantLocation   string
+		wantContent    string
+	}{
+		{1, "http://git.example.com/myteam/lib?go-get=1", http.StatusOK, "", `<!DOCTYPE html>
+<html>
+<head>
+<meta name="go-import" content="git.example.com/myteam/lib git https://vcs.example.com/myteam/lib.git">
+<meta name="go-source" content="git.example.com/myteam/lib https://vcs.example.com/myteam/lib _ https://vcs.example.com/myteam/lib/blob/main{/dir}/{file}#L{line}">
+</head>
+<body>
+go get git.example.com/myteam/lib
+</body>
+</html>
+`},
+		{2, "http://git.example.com:8080/myteam/lib/sub/pkg?go-get=1", http.StatusOK, "", `<meta name="go-import" content="git.example.com/myteam/lib git https://vcs.example.com/myteam/lib.git">`},
+		{3, "http://git.example.com/myteam/lib/v2?go-get=1", http.StatusOK, "", `<meta name="go-import" content="git.example.com/myteam/lib/v2 git https://vcs.example.com/myteam/lib-v2.git">`},
+		{4, "http://git.example.com/myteam/lib", http.StatusFound, "https://vcs.example.com/myteam/lib", ""},
+		{5, "http://git.example.com/myteam/lib/v2/pkg", http.StatusFound, "https://vcs.example.com/myteam/lib-v2.git", ""},
+		{6, "http://git.example.com/myteam/library?go-get=1", http.StatusNotFound, "", "not found"},
+		{7, "http://git.example.com/invalid?go-get=1", http.StatusNotFound, "", "not found"},
+		{8, "http://proxy.example.com/myteam/lib?go-get=1", http.StatusNotFound, "", "not found"},
+		{9, "http://git.example.com/git.example.com/myteam/lib/@v/list", http.StatusOK, "", "v1.0.0\n"},
+	} {
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.url, nil))
+		recr := rec.Result()
+		if got, want := recr.StatusCode, tt.wantStatusCode; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if got, want := recr.Header.Get("Location"), tt.wantLocation; got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+		if b, err := io.ReadAll(recr.Body); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		} else if got, want := string(b), tt.wantContent; !strings.Contains(got, want) {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package goproxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// webhookDedupeWindow is how long a module version notified by a
+	// [webhook] is not notified again, for example when it is cached again
+	// after being evicted.
+	webhookDedupeWindow = 24 * time.Hour
+
+	// webhookAttemptTimeout is the maximum amount of time each delivery
+	// attempt of a [webhook] takes.
+	webhookAttemptTimeout = 10 * time.Second
+
+	// webhookSignatureHeader is the header of the HMAC-SHA256 signature of
+	// the payload delivered by a [webhook], in the form "sha256=<hex>".
+	webhookSignatureHeader = "X-Goproxy-Signature"
+)
+
+// webhookRetryPolicy is the [retryPolicy] of the deliveries of a [webhook].
+var webhookRetryPolicy = retryPolicy{retries: 5, backoff: time.Second}
+
+// webhookEvent is the JSON payload delivered by a [webhook].
+type webhookEvent struct {
+	ModulePath string    `json:"module_path"`
+	Version    string    `json:"version"`
+	Size       int64     `json:"size"`
+	Time       time.Time `json:"time"`
+}
+
+// webhook delivers a [webhookEvent] to a URL for each module version it is
+// notified of, unless already notified within the [webhookDedupeWindow].
+// Deliveries are made in the background with retries. It is safe for
+// concurrent use.
+type webhook struct {
+	url         string
+	secret      []byte
+	httpClient  *http.Client
+	retryPolicy retryPolicy
+	logErrorf   func(format string, v ...any)
+	mutex       sync.Mutex
+	notified    map[string]time.Time
+	lastSweep   time.Time
+	deliveries  sync.WaitGroup
+}
+
+// newWebhook returns a new [webhook] that delivers to the url via the
+// httpClient, signing the payloads with the secret if it is not empty. Failed
+// deliveries are reported to the logErrorf.
+func newWebhook(url, secret string, httpClient *http.Client, logErrorf func(format string, v ...any)) *webhook {
+	return &webhook{
+		url:         url,
+		secret:      []byte(secret),
+		httpClient:  httpClient,
+		retryPolicy: webhookRetryPolicy,
+		logErrorf:   logErrorf,
+		notified:    map[string]time.Time{},
+	}
+}
+
+// notify delivers a [webhookEvent] for the module version of the size cached at
+// the now in the background, unless already notified within the
+// [webhookDedupeWindow].
+func (w *webhook) notify(modulePath, moduleVersion string, size int64, now time.Time) {
+	key := modulePath + "@" + moduleVersion
+	w.mutex.Lock()
+	if now.Sub(w.lastSweep) >= webhookDedupeWindow {
+		for k, notifiedAt := range w.notified {
+			if now.Sub(notifiedAt) >= webhookDedupeWindow {
+				delete(w.notified, k)
+			}
+		}
+		w.lastSweep = now
+	}
+	if notifiedAt, ok := w.notified[key]; ok && now.Sub(notifiedAt) < webhookDedupeWindow {
+		w.mutex.Unlock()
+		return
+	}
+	w.notified[key] = now
+	w.mutex.Unlock()
+
+	event := webhookEvent{ModulePath: modulePath, Version: moduleVersion, Size: size, Time: now.UTC()}
+	w.deliveries.Add(1)
+	go func() {
+		defer w.deliveries.Done()
+		if err := w.deliver(context.Background(), event); err != nil {
+			w.logErrorf("failed to deliver webhook: %s: %v", key, err)
+		}
+	}()
+}
+
+// deliver delivers the event, retrying on network errors and on 5xx and 429
+// status codes.
+func (w *webhook) deliver(ctx context.Context, event webhookEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	var signature string
+	if len(w.secret) > 0 {
+		mac := hmac.New(sha256.New, w.secret)
+		mac.Write(payload)
+		signature = "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	var lastErr error
+	for attempt := 0; w.retryPolicy.wait(ctx, attempt); attempt++ {
+		retryable, err := w.post(ctx, payload, signature)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if !retryable {
+			break
+		}
+	}
+	return lastErr
+}
+
+// post posts the payload with the signature once. It reports whether a failure
+// is worth retrying.
+func (w *webhook) post(ctx context.Context, payload []byte, signature string) (retryable bool, err error) {
+	ctx, cancel := context.WithTimeout(ctx, webhookAttemptTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(payload))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if signature != "" {
+		req.Header.Set(webhookSignatureHeader, signature)
+	}
+	res, err := w.httpClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("unexpected status code %d", res.StatusCode)
+	return res.StatusCode >= http.StatusInternalServerError || res.StatusCode == http.StatusTooManyRequests, err
+}
+
+// flush waits for the pending deliveries of the w to finish, or until the ctx
+// is done.
+func (w *webhook) flush(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		w.deliveries.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Flush waits for the deliveries to the WebhookURL still pending in the
+// background to finish, or until the ctx is done, in which case it returns
+// the ctx's error and the pending deliveries are abandoned to the exit of the
+// process. It is meant to be called on shutdown, once the g has stopped
+// serving requests, so that no delivery is silently dropped.
+func (g *Goproxy) Flush(ctx context.Context) error {
+	g.initOnce.Do(g.init)
+	if g.webhook == nil {
+		return nil
+	}
+	return g.webhook.flush(ctx)
+}
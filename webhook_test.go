@@ -0,0 +1,175 @@
+package goproxy
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhookNotify(t *testing.T) {
+	server, setHandler := newHTTPTestServer()
+	defer server.Close()
+	var (
+		events      []webhookEvent
+		eventsMutex sync.Mutex
+	)
+	setHandler(func(rw http.ResponseWriter, req *http.Request) {
+		b, err := io.ReadAll(req.Body)
+		if err != nil {
+			t.Errorf("unexpected error %q", err)
+		}
+		var event webhookEvent
+		if err := json.Unmarshal(b, &event); err != nil {
+			t.Errorf("unexpected error %q", err)
+		}
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write(b)
+		if got, want := req.Header.Get(webhookSignatureHeader), "sha256="+hex.EncodeToString(mac.Sum(nil)); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		eventsMutex.Lock()
+		events = append(events, event)
+		eventsMutex.Unlock()
+	})
+
+	var errs []string
+	w := newWebhook(server.URL, "secret", server.Client(), func(format string, v ...any) {
+		errs = append(errs, format)
+	})
+	now := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	w.notify("example.com", "v1.0.0", 42, now)
+	w.notify("example.com", "v1.0.0", 42, now.Add(time.Hour))
+	w.notify("example.com", "v1.1.0", 43, now.Add(time.Hour))
+	w.deliveries.Wait()
+	w.notify("example.com", "v1.0.0", 42, now.Add(webhookDedupeWindow))
+	w.deliveries.Wait()
+
+	if got, want := len(errs), 0; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+	if got, want := len(events), 3; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+	if events[0].Version == "v1.1.0" {
+		events[0], events[1] = events[1], events[0]
+	}
+	for i, want := range []webhookEvent{
+		{"example.com", "v1.0.0", 42, now},
+		{"example.com", "v1.1.0", 43, now.Add(time.Hour)},
+		{"example.com", "v1.0.0", 42, now.Add(webhookDedupeWindow)},
+	} {
+		if got := events[i]; got.ModulePath != want.ModulePath || got.Version != want.Version || got.Size != want.Size || !got.Time.Equal(want.Time) {
+			t.Errorf("event %d: got %+v, want %+v", i, got, want)
+		}
+	}
+	if got, want := len(w.notified), 2; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
+
+func TestWebhookDeliver(t *testing.T) {
+	server, setHandler := newHTTPTestServer()
+	defer server.Close()
+	for _, tt := range []struct {
+		n             int
+		secret        string
+		statusCodes   []int
+		wantAttempts  int
+		wantError     string
+		wantSignature bool
+	}{
+		{1, "", []int{http.StatusOK}, 1, "", false},
+		{2, "secret", []int{http.StatusNoContent}, 1, "", true},
+		{3, "", []int{http.StatusInternalServerError, http.StatusTooManyRequests, http.StatusOK}, 3, "", false},
+		{4, "", []int{http.StatusBadRequest}, 1, "unexpected status code 400", false},
+		{5, "", []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway}, 3, "unexpected status code 502", false},
+	} {
+		var (
+			attempts      int
+			hasSignature  bool
+			attemptsMutex sync.Mutex
+		)
+		setHandler(func(rw http.ResponseWriter, req *http.Request) {
+			attemptsMutex.Lock()
+			defer attemptsMutex.Unlock()
+			if got, want := req.Method, http.MethodPost; got != want {
+				t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+			}
+			if got, want := req.Header.Get("Content-Type"), "application/json"; got != want {
+				t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+			}
+			hasSignature = strings.HasPrefix(req.Header.Get(webhookSignatureHeader), "sha256=")
+			statusCode := tt.statusCodes[len(tt.statusCodes)-1]
+			if attempts < len(tt.statusCodes) {
+				statusCode = tt.statusCodes[attempts]
+			}
+			attempts++
+			rw.WriteHeader(statusCode)
+		})
+		w := newWebhook(server.URL, tt.secret, server.Client(), nil)
+		w.retryPolicy = retryPolicy{retries: 2, backoff: time.Millisecond}
+		err := w.deliver(context.Background(), webhookEvent{ModulePath: "example.com", Version: "v1.0.0"})
+		if tt.wantError != "" {
+			if err == nil {
+				t.Fatalf("test(%d): expected error", tt.n)
+			}
+			if got, want := err.Error(), tt.wantError; got != want {
+				t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+			}
+		} else if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		}
+		if got, want := attempts, tt.wantAttempts; got != want {
+			t.Errorf("test(%d): got %d, want %d", tt.n, got, want)
+		}
+		if got, want := hasSignature, tt.wantSignature; got != want {
+			t.Errorf("test(%d): got %t, want %t", tt.n, got, want)
+		}
+	}
+}
+
+func TestGoproxyFlush(t *testing.T) {
+	if err := (&Goproxy{}).Flush(context.Background()); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	server, setHandler := newHTTPTestServer()
+	defer server.Close()
+	release := make(chan struct{})
+	var delivered atomic.Int32
+	setHandler(func(rw http.ResponseWriter, req *http.Request) {
+		<-release
+		delivered.Add(1)
+	})
+	g := &Goproxy{WebhookURL: server.URL, ErrorLogger: log.New(io.Discard, "", 0)}
+	g.initOnce.Do(g.init)
+	g.webhook.notify("example.com", "v1.0.0", 42, time.Now())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := g.Flush(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if got, want := delivered.Load(), int32(0); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	close(release)
+	if err := g.Flush(context.Background()); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if got, want := delivered.Load(), int32(1); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}